@@ -2,10 +2,14 @@
 package actions
 
 import (
+	"context"
 	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/hewiefreeman/GopherGameServer/core"
 	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"reflect"
+	"sync"
+	"time"
 )
 
 // CustomClientAction is an action that you can handle on the server from
@@ -14,7 +18,15 @@ import (
 // You just need to make a callback function for the CustomClientAction type "setPosition", and as soon as the
 // action is received by the server, the callback function will be executed concurrently in a Goroutine.
 type CustomClientAction struct {
-	dataType int
+	dataType        int
+	requireLogin    bool
+	allowSpectators bool
+	requireTurn     bool
+
+	rateLimit int
+	ratePer   time.Duration
+
+	schema reflect.Type // THE STRUCT THE PARAMS ARE BOUND TO, OR nil
 
 	callback func(interface{}, *Client)
 }
@@ -27,8 +39,12 @@ type Client struct {
 	user   *core.User
 	connID string
 	socket *websocket.Conn
+	ctx    context.Context
 
+	//mux LOCKS responded, SINCE A CALLBACK THAT RAN OUT OF TIME CAN STILL RESPOND
+	mux       sync.Mutex
 	responded bool
+	warning   map[string]interface{}
 }
 
 // ClientError is used when an error is thrown in your CustomClientAction. Use `actions.NewError()` to make a
@@ -36,6 +52,7 @@ type Client struct {
 type ClientError struct {
 	message string
 	id      int
+	field   string // THE PARAM THAT DIDN'T FIT THE ACTION'S SCHEMA
 }
 
 var (
@@ -49,6 +66,12 @@ var (
 const (
 	ErrorMismatchedTypes    = iota + 1001 // Client didn't pass the right data type for the given action
 	ErrorUnrecognizedAction               // The custom action has not been defined
+	ErrorActionRemoved                    // The custom action was deprecated and has passed its sunset time
+	ErrorNotLoggedIn                      // The custom action requires the client to be logged in
+	ErrorActionFailed                     // The custom action's callback panicked
+	ErrorActionTimedOut                   // The custom action's callback didn't respond before the server's ActionTimeout
+	ErrorSpectating                       // The custom action doesn't accept clients that are spectating a Room
+	ErrorNotYourTurn                      // The custom action requires it to be the client's turn in their Room
 )
 
 // These are the accepted data types that a client can send with a CustomClientMessage. You must use one
@@ -86,11 +109,21 @@ const (
 //
 // - client: A `Client` object representing the client that sent the action
 //
+// Clients can call the action by using the `actionType` as the action name of a built-in client message, or through the built-in
+// custom action message. For that reason, the `actionType` cannot be the same as any of the built-in client actions in the helpers package.
 //
 // Note: This function can only be called BEFORE starting the server.
 func New(actionType string, dataType int, callback func(interface{}, *Client)) error {
 	if serverStarted {
 		return errors.New("Cannot make a new CustomClientAction once the server has started")
+	} else if len(actionType) == 0 {
+		return errors.New("actions.New() requires an action type")
+	} else if callback == nil {
+		return errors.New("actions.New() requires a callback")
+	} else if helpers.IsClientAction(actionType) {
+		return errors.New("The action type '" + actionType + "' is reserved for a built-in client action")
+	} else if _, ok := customClientActions[actionType]; ok {
+		return errors.New("The CustomClientAction '" + actionType + "' already exists")
 	}
 	customClientActions[actionType] = CustomClientAction{
 		dataType: dataType,
@@ -99,6 +132,134 @@ func New(actionType string, dataType int, callback func(interface{}, *Client)) e
 	return nil
 }
 
+// RequireLogin makes a `CustomClientAction` only accept clients that are logged in as a User. Any other
+// client calling the action will receive an `ErrorNotLoggedIn` error, and your callback will not be executed.
+// Otherwise, use *Client.LoggedIn() in your callback to check if the client is logged in.
+//
+// Note: This function can only be called BEFORE starting the server.
+func RequireLogin(actionType string) error {
+	if serverStarted {
+		return errors.New("Cannot change a CustomClientAction once the server has started")
+	}
+	customAction, ok := customClientActions[actionType]
+	if !ok {
+		return errors.New("The CustomClientAction '" + actionType + "' does not exist")
+	}
+	customAction.requireLogin = true
+	customClientActions[actionType] = customAction
+	return nil
+}
+
+// RequireTurn makes a `CustomClientAction` only accept the client whose turn it is in the Room they're in (see
+// *Room.EnableTurns()). Any other client calling the action will receive an `ErrorNotYourTurn` error, and your callback will
+// not be executed. The turn can still change while your callback runs, so end the turn with *Room.EndTurn().
+//
+// Note: This function can only be called BEFORE starting the server.
+func RequireTurn(actionType string) error {
+	if serverStarted {
+		return errors.New("Cannot change a CustomClientAction once the server has started")
+	}
+	customAction, ok := customClientActions[actionType]
+	if !ok {
+		return errors.New("The CustomClientAction '" + actionType + "' does not exist")
+	}
+	customAction.requireTurn = true
+	customClientActions[actionType] = customAction
+	return nil
+}
+
+// AllowSpectators lets clients that are spectating a Room call a `CustomClientAction`. Otherwise, they will receive an
+// `ErrorSpectating` error, and your callback will not be executed. Use *Client.IsSpectating() in your callback to check if
+// the client is spectating.
+//
+// Note: This function can only be called BEFORE starting the server.
+func AllowSpectators(actionType string) error {
+	if serverStarted {
+		return errors.New("Cannot change a CustomClientAction once the server has started")
+	}
+	customAction, ok := customClientActions[actionType]
+	if !ok {
+		return errors.New("The CustomClientAction '" + actionType + "' does not exist")
+	}
+	customAction.allowSpectators = true
+	customClientActions[actionType] = customAction
+	return nil
+}
+
+// SetRateLimit overrides ActionRateLimit in ServerSettings for a `CustomClientAction`. Each connection can call the action
+// limit times every per, and any more calls are rate limited until it has waited. Calls to the action with its own rate limit do
+// not count towards ActionRateLimit.
+//
+// Note: This function can only be called BEFORE starting the server.
+func SetRateLimit(actionType string, limit int, per time.Duration) error {
+	if serverStarted {
+		return errors.New("Cannot change a CustomClientAction once the server has started")
+	} else if limit < 1 || per <= 0 {
+		return errors.New("actions.SetRateLimit() requires a limit of at least 1 and a positive duration")
+	}
+	customAction, ok := customClientActions[actionType]
+	if !ok {
+		return errors.New("The CustomClientAction '" + actionType + "' does not exist")
+	}
+	customAction.rateLimit = limit
+	customAction.ratePer = per
+	customClientActions[actionType] = customAction
+	return nil
+}
+
+// SetSchema makes a `CustomClientAction` bind its params onto a new copy of the schema, a struct (or pointer to one) with
+// "param" tags, with `actions.Bind()`. Params that don't fit are rejected with a `helpers.ErrorMalformedRequest` error
+// naming the param, and your callback will not be executed. Otherwise, your callback gets a pointer to the bound struct
+// as its actionData:
+//
+//     actions.New("move", actions.DataTypeMap, func(actionData interface{}, client *actions.Client) {
+//         params := actionData.(*MoveParams)
+//         //...
+//     })
+//     actions.SetSchema("move", MoveParams{})
+//
+// The action must take `DataTypeMap`.
+//
+// Note: This function can only be called BEFORE starting the server.
+func SetSchema(actionType string, schema interface{}) error {
+	if serverStarted {
+		return errors.New("Cannot change a CustomClientAction once the server has started")
+	}
+	customAction, ok := customClientActions[actionType]
+	if !ok {
+		return errors.New("The CustomClientAction '" + actionType + "' does not exist")
+	} else if customAction.dataType != DataTypeMap {
+		return errors.New("Only a CustomClientAction that takes DataTypeMap can have a schema")
+	}
+	schemaType := reflect.TypeOf(schema)
+	if schemaType != nil && schemaType.Kind() == reflect.Ptr {
+		schemaType = schemaType.Elem()
+	}
+	if schemaType == nil || schemaType.Kind() != reflect.Struct {
+		return errors.New("actions.SetSchema() requires a struct")
+	} else if err := checkSchema(schemaType); err != nil {
+		return err
+	}
+	customAction.schema = schemaType
+	customClientActions[actionType] = customAction
+	return nil
+}
+
+// RateLimit is only for internal Gopher Game Server mechanics.
+func RateLimit(actionType string) (int, time.Duration, bool) {
+	customAction, ok := customClientActions[actionType]
+	if !ok || customAction.rateLimit == 0 {
+		return 0, 0, false
+	}
+	return customAction.rateLimit, customAction.ratePer, true
+}
+
+// Exists is only for internal Gopher Game Server mechanics.
+func Exists(actionType string) bool {
+	_, ok := customClientActions[actionType]
+	return ok
+}
+
 // NewError creates a new error with a provided message and ID.
 func NewError(message string, id int) ClientError {
 	return ClientError{message: message, id: id}
@@ -118,21 +279,107 @@ func NoError() ClientError {
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Your CustomClientAction callbacks are called
 // from this function. This could spawn errors and/or memory leaks.
 func HandleCustomClientAction(action string, data interface{}, user *core.User, conn *websocket.Conn, connID string) {
-	client := Client{user: user, action: action, socket: conn, connID: connID, responded: false}
+	HandleCustomClientActionCtx(context.Background(), action, data, user, conn, connID)
+}
+
+// HandleCustomClientActionCtx is the same as HandleCustomClientAction, but stops waiting for the callback when ctx is done,
+// and gives the client an ErrorActionTimedOut unless the callback has already responded.
+//
+// WARNING: This is only meant for internal Gopher Game Server mechanics.
+func HandleCustomClientActionCtx(ctx context.Context, action string, data interface{}, user *core.User, conn *websocket.Conn, connID string) {
+	client := Client{user: user, action: action, socket: conn, connID: connID, ctx: ctx, responded: false}
 	// CHECK IF ACTION EXISTS
 	if customAction, ok := customClientActions[action]; ok {
+		// CHECK IF THE ACTION IS DEPRECATED OR REMOVED
+		var removed bool
+		if client.warning, removed = checkDeprecation(action); removed {
+			message := "Action removed"
+			if replacement, _ := client.warning["r"].(string); replacement != "" {
+				message = message + ", use '" + replacement + "' instead"
+			}
+			client.Respond(nil, NewError(message, ErrorActionRemoved))
+			return
+		}
+		// CHECK IF THE CLIENT MUST BE LOGGED IN
+		if customAction.requireLogin && user == nil {
+			client.Respond(nil, NewError("You must be logged in", ErrorNotLoggedIn))
+			return
+		}
+		// CHECK IF THE CLIENT IS SPECTATING
+		if !customAction.allowSpectators && client.IsSpectating() {
+			client.Respond(nil, NewError("Spectators can't do that", ErrorSpectating))
+			return
+		}
+		// CHECK IF IT'S THE CLIENT'S TURN
+		if customAction.requireTurn && !client.IsTurn() {
+			client.Respond(nil, NewError("It's not your turn", ErrorNotYourTurn))
+			return
+		}
 		// CHECK IF THE TYPE OF data MATCHES THE TYPE action SPECIFIES
 		if !typesMatch(data, customAction.dataType) {
 			client.Respond(nil, NewError("Mismatched data type", ErrorMismatchedTypes))
 			return
 		}
+		// BIND THE PARAMS TO THE ACTION'S SCHEMA
+		if customAction.schema != nil {
+			var bindErr ClientError
+			if data, bindErr = customAction.bindSchema(data); bindErr.id != -1 {
+				client.Respond(nil, bindErr)
+				return
+			}
+		}
 		//EXECUTE CALLBACK
-		customAction.callback(data, &client)
+		if _, hasDeadline := ctx.Deadline(); !hasDeadline {
+			runCallback(customAction, data, &client)
+			return
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			runCallback(customAction, data, &client)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// THE CALLBACK KEEPS RUNNING, BUT THE CLIENT DOESN'T WAIT FOR IT
+			helpers.Log().Warn("CustomClientAction callback timed out", "action", action, "conn", connID)
+			client.Respond(nil, NewError("Action timed out", ErrorActionTimedOut))
+		}
 	} else {
 		client.Respond(nil, NewError("Unrecognized action", ErrorUnrecognizedAction))
 	}
 }
 
+// bindSchema binds params to a new copy of the action's schema. Params that don't fit get a helpers.ErrorMalformedRequest
+// naming the param.
+func (a CustomClientAction) bindSchema(data interface{}) (interface{}, ClientError) {
+	bound := reflect.New(a.schema)
+	if err := Bind(data, bound.Interface()); err != nil {
+		clientErr := NewError(err.Error(), helpers.ErrorMalformedRequest)
+		if bindErr, ok := err.(*BindError); ok {
+			clientErr.field = bindErr.Field
+		}
+		return nil, clientErr
+	}
+	return bound.Interface(), NoError()
+}
+
+// runCallback runs a CustomClientAction's callback. A panic in the callback is logged and passed to the PanicHandler, and the
+// client gets an ErrorActionFailed.
+func runCallback(customAction CustomClientAction, data interface{}, client *Client) {
+	defer func() {
+		if r := recover(); r != nil {
+			var userName string
+			if client.user != nil {
+				userName = client.user.Name()
+			}
+			helpers.Recovered("CustomClientAction "+client.action, r, "user", userName, "conn", client.connID)
+			client.Respond(nil, NewError("Action failed", ErrorActionFailed))
+		}
+	}()
+	customAction.callback(data, client)
+}
+
 //
 func typesMatch(data interface{}, theType int) bool {
 	switch data.(type) {
@@ -198,15 +445,20 @@ func typesMatch(data interface{}, theType int) bool {
 // by the Client (the response parameter will not be sent as well). It's perfectly fine to not send back any response if none
 // is needed.
 //
+// If the CustomClientAction has been deprecated with `actions.Deprecate()`, the response will also carry the deprecation warning.
+//
 // NOTE: A response can only be sent once to a Client. Any more calls to Respond() on the same Client will not send a response,
 // nor do anything at all. If you want to send a stream of messages to the Client, first get their User object with *Client.User(),
 // then you can send data messages directly to the User with the *User.DataMessage() function.
 func (c *Client) Respond(response interface{}, err ClientError) {
 	//YOU CAN ONLY RESPOND ONCE
+	(*c).mux.Lock()
 	if (*c).responded {
+		(*c).mux.Unlock()
 		return
 	}
 	(*c).responded = true
+	(*c).mux.Unlock()
 	//CONSTRUCT MESSAGE
 	r := map[string]map[string]interface{}{
 		helpers.ServerActionCustomClientActionResponse: {
@@ -216,24 +468,37 @@ func (c *Client) Respond(response interface{}, err ClientError) {
 	if err.id != -1 {
 		r[helpers.ServerActionCustomClientActionResponse]["e"] = map[string]interface{}{
 			"m":  err.message,
+			"c":  err.id,
 			"id": err.id,
 		}
+		if err.field != "" {
+			r[helpers.ServerActionCustomClientActionResponse]["e"].(map[string]interface{})["f"] = err.field
+		}
 	} else {
 		r[helpers.ServerActionCustomClientActionResponse]["r"] = response
 	}
+	if (*c).warning != nil {
+		r[helpers.ServerActionCustomClientActionResponse]["w"] = (*c).warning
+	}
 	//SEND MESSAGE TO CLIENT
-	(*c).socket.WriteJSON(r)
+	helpers.WriteMessage((*c).socket, r)
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 //   Client ATTRIBUTE READERS   ///////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// User gets the *User of the Client.
+// User gets the *User of the Client. Returns nil if the Client is not logged in.
 func (c *Client) User() *core.User {
 	return c.user
 }
 
+// LoggedIn returns true if the Client is logged in as a User. Use *User.IsGuest() on the Client's *User to check if
+// they logged in as a guest.
+func (c *Client) LoggedIn() bool {
+	return c.user != nil
+}
+
 // ConnectionID gets the connection ID of the Client. This is only used if you have MultiConnect enabled in ServerSettings and
 // you need to, for instance, call *User functions with the Client's *User obtained with the client.User() function. If
 // you do, use client.ConnectionID() when calling any *User functions from a Client.
@@ -241,20 +506,41 @@ func (c *Client) ConnectionID() string {
 	return c.connID
 }
 
+// IsSpectating returns true if the Client is logged in and spectating the Room their connection is in.
+func (c *Client) IsSpectating() bool {
+	return c.user != nil && c.user.IsSpectating(c.connID)
+}
+
+// IsTurn returns true if the Client is logged in, and it's their turn in the Room their connection is in.
+func (c *Client) IsTurn() bool {
+	if c.user == nil {
+		return false
+	}
+	room := c.user.RoomIn(c.connID)
+	return room != nil && room.CurrentTurn() == c.user.Name()
+}
+
 // Action gets the type of action the Client sent.
 func (c *Client) Action() string {
 	return c.action
 }
 
+// Context gets the Context of the action the Client sent. It's done when the action runs out of time with an ActionTimeout
+// in ServerSettings, so pass it to anything in your callback that could take a while, like your own database queries.
+func (c *Client) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 //   SERVER STARTUP FUNCTIONS   ///////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // SetServerStarted is for Gopher Game Server internal mechanics only.
 func SetServerStarted(val bool) {
-	if !serverStarted {
-		serverStarted = val
-	}
+	serverStarted = val
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
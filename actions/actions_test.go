@@ -0,0 +1,79 @@
+package actions
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	callback := func(data interface{}, c *Client) {}
+	defer delete(customClientActions, "rollDice")
+
+	if err := New("rollDice", DataTypeMap, callback); err != nil {
+		t.Fatal(err)
+	}
+	// Built-in client action names, duplicates and missing parameters are rejected
+	for _, actionType := range []string{helpers.ClientActionLogin, helpers.ClientActionJoinRoom, helpers.ClientActionCustomAction} {
+		if New(actionType, DataTypeNil, callback) == nil {
+			t.Errorf("Expected the built-in client action name %q to be reserved", actionType)
+		}
+	}
+	if New("rollDice", DataTypeNil, callback) == nil {
+		t.Error("Expected an error making an action that already exists")
+	} else if New("", DataTypeNil, callback) == nil {
+		t.Error("Expected an error making an action without a name")
+	} else if New("flipCoin", DataTypeNil, nil) == nil {
+		t.Error("Expected an error making an action without a callback")
+	} else if Exists("flipCoin") {
+		t.Error("Expected rejected actions not to be made")
+	}
+	if customClientActions["rollDice"].dataType != DataTypeMap {
+		t.Error("Expected a rejected duplicate not to replace the action")
+	}
+	if RequireLogin("flipCoin") == nil {
+		t.Error("Expected an error requiring a login for an action that doesn't exist")
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	core.SettingsSet(false, "server", false, false, false, false, 0, 0)
+	var called *Client
+	New("loggedInMove", DataTypeNil, func(data interface{}, c *Client) {
+		called = c
+		c.Respond(nil, NoError())
+	})
+	defer delete(customClientActions, "loggedInMove")
+	if err := RequireLogin("loggedInMove"); err != nil {
+		t.Fatal(err)
+	}
+	server, client := testSocketPair(t)
+
+	// Clients that aren't logged in are rejected
+	HandleCustomClientAction("loggedInMove", nil, nil, server, "")
+	if e, _ := readResponse(t, client)["e"].(map[string]interface{}); e["c"] != float64(ErrorNotLoggedIn) || called != nil {
+		t.Error("Expected an ErrorNotLoggedIn without running the callback, got", e)
+	}
+
+	// Guests are logged in, and the callback can tell them apart
+	var guest *core.User
+	var clientMux sync.Mutex
+	connID, gErr := core.Login("actionGuest", -1, "", true, false, server, &guest, &clientMux)
+	if gErr.ID != 0 {
+		t.Fatal(gErr.Message)
+	}
+	defer guest.Kick()
+	HandleCustomClientAction("loggedInMove", nil, guest, server, connID)
+	for {
+		if response := readResponse(t, client); response != nil {
+			if response["e"] != nil {
+				t.Error("Expected the guest to be let through, got", response)
+			}
+			break
+		}
+	}
+	if called == nil || !called.LoggedIn() || !called.User().IsGuest() {
+		t.Error("Expected the callback to get the guest's Client")
+	}
+}
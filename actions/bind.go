@@ -0,0 +1,308 @@
+package actions
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// BindError is the error Bind() returns when the params don't fit the struct. It names the param that doesn't fit.
+type BindError struct {
+	Field   string // The path to the param, like "pos.x" or "items[2]", or "" for the params themselves
+	Message string // What's wrong with it, like "is required"
+}
+
+func (e *BindError) Error() string {
+	if e.Field == "" {
+		return "Parameters " + e.Message
+	}
+	return "Parameter '" + e.Field + "' " + e.Message
+}
+
+// paramRules are the rules from a struct field's "param" tag.
+type paramRules struct {
+	name     string
+	required bool
+	min      float64
+	max      float64
+	hasMin   bool
+	hasMax   bool
+}
+
+// Bind copies a client's action params, a JSON object, onto the struct dest points to. Fields get the param with their
+// name, or the name in their "param" tag, and can have these rules after the name in the tag:
+//
+//     type MoveParams struct {
+//         X    int    `param:"x,required,min=0,max=100"`
+//         Y    int    `param:"y,required,min=0,max=100"`
+//         Note string `param:"note,max=64"`
+//     }
+//
+// - required: The param must be sent, and not be null
+//
+// - min=N, max=N: The lowest and highest value for a number, the fewest and most characters for a string, or the fewest and most
+// items for a slice or map
+//
+// A tag of "-" leaves the field out. Numbers only go into integer fields when they are whole and fit the field, and params
+// that are objects can go into structs and maps, and arrays into slices. Params that are missing or null leave their field as it
+// was. When a param doesn't fit its field, Bind returns a *BindError naming it.
+func Bind(params interface{}, dest interface{}) error {
+	value := reflect.ValueOf(dest)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return errors.New("actions.Bind() requires a pointer to a struct")
+	}
+	if bindErr := bindValue(params, value.Elem(), ""); bindErr != nil {
+		return bindErr
+	}
+	return nil
+}
+
+// checkSchema returns an error if a struct's "param" tags can't be read, so bad schemas are found before the server starts.
+func checkSchema(t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		if _, _, err := fieldRules(t.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldRules reads a struct field's "param" tag. Returns false when the field is left out.
+func fieldRules(field reflect.StructField) (paramRules, bool, error) {
+	tag := field.Tag.Get("param")
+	if field.PkgPath != "" || tag == "-" {
+		return paramRules{}, false, nil
+	}
+	parts := strings.Split(tag, ",")
+	rules := paramRules{name: parts[0]}
+	if rules.name == "" {
+		rules.name = field.Name
+	}
+	for _, rule := range parts[1:] {
+		var err error
+		switch {
+		case rule == "required":
+			rules.required = true
+		case strings.HasPrefix(rule, "min="):
+			rules.min, err = strconv.ParseFloat(strings.TrimPrefix(rule, "min="), 64)
+			rules.hasMin = true
+		case strings.HasPrefix(rule, "max="):
+			rules.max, err = strconv.ParseFloat(strings.TrimPrefix(rule, "max="), 64)
+			rules.hasMax = true
+		default:
+			err = errors.New("unknown rule")
+		}
+		if err != nil {
+			return paramRules{}, false, errors.New("Bad rule '" + rule + "' in the param tag of " + field.Name)
+		}
+	}
+	return rules, true, nil
+}
+
+// bindValue copies a param onto a value.
+func bindValue(param interface{}, dest reflect.Value, path string) *BindError {
+	switch dest.Kind() {
+	case reflect.Interface:
+		if param == nil {
+			return nil
+		} else if !reflect.TypeOf(param).AssignableTo(dest.Type()) {
+			return &BindError{path, "has the wrong type"}
+		}
+		dest.Set(reflect.ValueOf(param))
+		return nil
+
+	case reflect.Ptr:
+		if param == nil {
+			return nil
+		}
+		elem := reflect.New(dest.Type().Elem())
+		if bindErr := bindValue(param, elem.Elem(), path); bindErr != nil {
+			return bindErr
+		}
+		dest.Set(elem)
+		return nil
+
+	case reflect.Bool:
+		b, ok := param.(bool)
+		if !ok {
+			return &BindError{path, "must be a boolean"}
+		}
+		dest.SetBool(b)
+		return nil
+
+	case reflect.String:
+		s, ok := param.(string)
+		if !ok {
+			return &BindError{path, "must be a string"}
+		}
+		dest.SetString(s)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := numberOf(param)
+		if !ok {
+			return &BindError{path, "must be a number"}
+		} else if number != math.Trunc(number) {
+			return &BindError{path, "must be a whole number"}
+		} else if number < math.MinInt64 || number >= math.MaxInt64 || dest.OverflowInt(int64(number)) {
+			return &BindError{path, "is out of range"}
+		}
+		dest.SetInt(int64(number))
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := numberOf(param)
+		if !ok {
+			return &BindError{path, "must be a number"}
+		} else if number != math.Trunc(number) {
+			return &BindError{path, "must be a whole number"}
+		} else if number < 0 || number >= math.MaxUint64 || dest.OverflowUint(uint64(number)) {
+			return &BindError{path, "is out of range"}
+		}
+		dest.SetUint(uint64(number))
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		number, ok := numberOf(param)
+		if !ok {
+			return &BindError{path, "must be a number"}
+		} else if dest.OverflowFloat(number) {
+			return &BindError{path, "is out of range"}
+		}
+		dest.SetFloat(number)
+		return nil
+
+	case reflect.Slice:
+		items, ok := param.([]interface{})
+		if !ok {
+			return &BindError{path, "must be an array"}
+		}
+		slice := reflect.MakeSlice(dest.Type(), len(items), len(items))
+		for i, item := range items {
+			if bindErr := bindValue(item, slice.Index(i), path+"["+strconv.Itoa(i)+"]"); bindErr != nil {
+				return bindErr
+			}
+		}
+		dest.Set(slice)
+		return nil
+
+	case reflect.Map:
+		object, ok := param.(map[string]interface{})
+		if !ok {
+			return &BindError{path, "must be an object"}
+		} else if dest.Type().Key().Kind() != reflect.String {
+			return &BindError{path, "has a map type without string keys"}
+		}
+		m := reflect.MakeMapWithSize(dest.Type(), len(object))
+		for key, item := range object {
+			elem := reflect.New(dest.Type().Elem()).Elem()
+			if bindErr := bindValue(item, elem, joinPath(path, key)); bindErr != nil {
+				return bindErr
+			}
+			m.SetMapIndex(reflect.ValueOf(key).Convert(dest.Type().Key()), elem)
+		}
+		dest.Set(m)
+		return nil
+
+	case reflect.Struct:
+		object, ok := param.(map[string]interface{})
+		if !ok {
+			return &BindError{path, "must be an object"}
+		}
+		return bindStruct(object, dest, path)
+	}
+	return &BindError{path, "has a type that params can't be bound to"}
+}
+
+// bindStruct copies the params in an object onto a struct's fields, and checks the fields' rules.
+func bindStruct(object map[string]interface{}, dest reflect.Value, path string) *BindError {
+	t := dest.Type()
+	for i := 0; i < t.NumField(); i++ {
+		rules, ok, err := fieldRules(t.Field(i))
+		if err != nil {
+			return &BindError{path, err.Error()}
+		} else if !ok {
+			continue
+		}
+		fieldPath := joinPath(path, rules.name)
+		param, sent := object[rules.name]
+		if !sent || param == nil {
+			if rules.required {
+				return &BindError{fieldPath, "is required"}
+			}
+			continue
+		}
+		if bindErr := bindValue(param, dest.Field(i), fieldPath); bindErr != nil {
+			return bindErr
+		}
+		if bindErr := checkRange(dest.Field(i), rules, fieldPath); bindErr != nil {
+			return bindErr
+		}
+	}
+	return nil
+}
+
+// checkRange checks a bound field against the min and max rules from its tag.
+func checkRange(field reflect.Value, rules paramRules, path string) *BindError {
+	if !rules.hasMin && !rules.hasMax {
+		return nil
+	}
+	for field.Kind() == reflect.Ptr {
+		field = field.Elem()
+	}
+	var size float64
+	var unit string
+	switch field.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(field.String())), " characters"
+	case reflect.Slice, reflect.Map:
+		size, unit = float64(field.Len()), " items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(field.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(field.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = field.Float()
+	default:
+		return nil
+	}
+	limit := func(n float64) string {
+		return strconv.FormatFloat(n, 'f', -1, 64) + unit
+	}
+	if unit != "" {
+		if rules.hasMin && size < rules.min {
+			return &BindError{path, "must have at least " + limit(rules.min)}
+		} else if rules.hasMax && size > rules.max {
+			return &BindError{path, "must have at most " + limit(rules.max)}
+		}
+	} else if rules.hasMin && size < rules.min {
+		return &BindError{path, "must be at least " + limit(rules.min)}
+	} else if rules.hasMax && size > rules.max {
+		return &BindError{path, "must be at most " + limit(rules.max)}
+	}
+	return nil
+}
+
+// numberOf gets a param as a float64, if it's a number. JSON numbers are always float64, but MessagePack can send any size.
+func numberOf(param interface{}) (float64, bool) {
+	value := reflect.ValueOf(param)
+	switch value.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return value.Float(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint()), true
+	}
+	return 0, false
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
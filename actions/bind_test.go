@@ -0,0 +1,106 @@
+package actions
+
+import (
+	"encoding/json"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"testing"
+)
+
+type testPosition struct {
+	X int `param:"x,required,min=0,max=100"`
+	Y int `param:"y,required,min=0,max=100"`
+}
+
+type testMoveParams struct {
+	Pos    testPosition     `param:"pos,required"`
+	Path   []testPosition   `param:"path,max=3"`
+	Speed  float64          `param:"speed,min=0.5"`
+	Note   string           `param:"note,min=1,max=4"`
+	Run    *bool            `param:"run"`
+	Tags   map[string]uint8 `param:"tags"`
+	Extra  interface{}      `param:"extra"`
+	Hidden string           `param:"-"`
+	Plain  int
+}
+
+func TestBind(t *testing.T) {
+	decode := func(s string) interface{} {
+		var params interface{}
+		if err := json.Unmarshal([]byte(s), &params); err != nil {
+			t.Fatal(err)
+		}
+		return params
+	}
+
+	var params testMoveParams
+	err := Bind(decode(`{"pos": {"x": 3, "y": 4}, "path": [{"x": 1, "y": 2}], "speed": 1.5, "note": "hé", "run": true,
+		"tags": {"a": 1}, "extra": [1], "Hidden": "x", "Plain": 7}`), &params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Pos.X != 3 || params.Pos.Y != 4 || len(params.Path) != 1 || params.Path[0].Y != 2 || params.Speed != 1.5 ||
+		params.Note != "hé" || params.Run == nil || !*params.Run || params.Tags["a"] != 1 || params.Extra == nil ||
+		params.Hidden != "" || params.Plain != 7 {
+
+		t.Error("The params weren't bound right, got", params)
+	}
+
+	// Every param that doesn't fit is named
+	for input, expected := range map[string]string{
+		`"not an object"`:                                 "",
+		`{"pos": {"x": 3}}`:                               "pos.y",
+		`{"pos": {"x": "3", "y": 4}}`:                     "pos.x",
+		`{"pos": {"x": 3.5, "y": 4}}`:                     "pos.x",
+		`{"pos": {"x": 101, "y": 4}}`:                     "pos.x",
+		`{"pos": {"x": 1, "y": 1}, "path": [{"x": 1}]}`:   "path[0].y",
+		`{"pos": {"x": 1, "y": 1}, "path": [1, 2, 3, 4]}`: "path[0]",
+		`{"pos": {"x": 1, "y": 1}, "speed": 0.1}`:         "speed",
+		`{"pos": {"x": 1, "y": 1}, "note": ""}`:           "note",
+		`{"pos": {"x": 1, "y": 1}, "note": "hello"}`:      "note",
+		`{"pos": {"x": 1, "y": 1}, "tags": {"a": 256}}`:   "tags.a",
+		`{"pos": {"x": 1, "y": 1}, "run": 1}`:             "run",
+	} {
+		var params testMoveParams
+		err := Bind(decode(input), &params)
+		if bindErr, ok := err.(*BindError); !ok || bindErr.Field != expected {
+			t.Error("Expected an error for '"+expected+"' from", input, "got", err)
+		}
+	}
+
+	if Bind(map[string]interface{}{}, params) == nil {
+		t.Error("Bind() should require a pointer to a struct")
+	}
+}
+
+func TestSchema(t *testing.T) {
+	defer delete(customClientActions, "schemaMove")
+	var received *testMoveParams
+	New("schemaMove", DataTypeMap, func(data interface{}, client *Client) {
+		received, _ = data.(*testMoveParams)
+	})
+	if err := SetSchema("schemaMove", testMoveParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if SetSchema("schemaMove", "not a struct") == nil {
+		t.Error("SetSchema() should require a struct")
+	}
+	if SetSchema("schemaMove", struct {
+		X int `param:"x,between=1"`
+	}{}) == nil {
+		t.Error("SetSchema() should reject unknown rules")
+	}
+
+	// The callback gets the bound struct, and params that don't fit are malformed
+	customAction := customClientActions["schemaMove"]
+	data, err := customAction.bindSchema(map[string]interface{}{"pos": map[string]interface{}{"x": 1.0, "y": 2.0}})
+	if err.id != -1 {
+		t.Fatal("Expected the params to fit, got", err.message)
+	}
+	runCallback(customAction, data, &Client{})
+	if received == nil || received.Pos.Y != 2 {
+		t.Error("Expected the callback to get the bound params, got", received)
+	}
+	if _, err := customAction.bindSchema(map[string]interface{}{}); err.id != helpers.ErrorMalformedRequest || err.field != "pos" {
+		t.Error("Expected a malformed request error for pos, got", err)
+	}
+}
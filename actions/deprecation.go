@@ -0,0 +1,101 @@
+package actions
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+type deprecation struct {
+	replacement string
+	message     string
+	sunset      time.Time // zero means the action never gets removed
+	uses        int
+}
+
+var (
+	deprecations    map[string]*deprecation = make(map[string]*deprecation)
+	deprecationsMux sync.Mutex
+
+	// timeNow is swapped out by tests that need to control the clock
+	timeNow = time.Now
+)
+
+// Deprecate marks a `CustomClientAction` as deprecated. The action keeps working, but every response
+// the client receives from it will carry a warning with the replacement action's name, your message, and the
+// sunset time. Once the sunset time has passed, the action will no longer execute your callback and the client will receive
+// an `ErrorActionRemoved` error telling them to use the replacement instead. Use a zero `time.Time` for the sunset if the
+// action should never be removed.
+//
+// The replacement can be an empty string when there is no replacement, otherwise it must be the name of a `CustomClientAction`
+// you have already made with `actions.New()`.
+//
+// Note: This function can only be called BEFORE starting the server.
+func Deprecate(actionType string, replacement string, message string, sunset time.Time) error {
+	if serverStarted {
+		return errors.New("Cannot deprecate a CustomClientAction once the server has started")
+	} else if _, ok := customClientActions[actionType]; !ok {
+		return errors.New("The CustomClientAction '" + actionType + "' does not exist")
+	} else if replacement == actionType {
+		return errors.New("A CustomClientAction cannot be replaced by itself")
+	} else if _, ok := customClientActions[replacement]; replacement != "" && !ok {
+		return errors.New("The replacement CustomClientAction '" + replacement + "' does not exist")
+	}
+	deprecationsMux.Lock()
+	deprecations[actionType] = &deprecation{replacement: replacement, message: message, sunset: sunset}
+	deprecationsMux.Unlock()
+	return nil
+}
+
+// ExtendSunset pushes back the sunset time of a deprecated `CustomClientAction` by the given duration. If the
+// sunset time has already passed, the grace period starts from now. This can be called while the server is running,
+// and is meant for emergencies where clients still depend on an action that has been (or is about to be) removed.
+func ExtendSunset(actionType string, grace time.Duration) error {
+	if grace <= 0 {
+		return errors.New("actions.ExtendSunset() requires a positive grace period")
+	}
+	deprecationsMux.Lock()
+	defer deprecationsMux.Unlock()
+	d, ok := deprecations[actionType]
+	if !ok {
+		return errors.New("The CustomClientAction '" + actionType + "' is not deprecated")
+	} else if d.sunset.IsZero() {
+		return errors.New("The CustomClientAction '" + actionType + "' has no sunset time")
+	}
+	if now := timeNow(); d.sunset.Before(now) {
+		d.sunset = now
+	}
+	d.sunset = d.sunset.Add(grace)
+	return nil
+}
+
+// DeprecatedUsage gets the number of times clients have called a deprecated `CustomClientAction`, including
+// calls that were rejected after the sunset time.
+func DeprecatedUsage(actionType string) int {
+	deprecationsMux.Lock()
+	defer deprecationsMux.Unlock()
+	if d, ok := deprecations[actionType]; ok {
+		return d.uses
+	}
+	return 0
+}
+
+// checkDeprecation records a call to a deprecated action. Returns the warning to attach to the response,
+// and true if the action is past its sunset time and must not execute.
+func checkDeprecation(actionType string) (map[string]interface{}, bool) {
+	deprecationsMux.Lock()
+	defer deprecationsMux.Unlock()
+	d, ok := deprecations[actionType]
+	if !ok {
+		return nil, false
+	}
+	d.uses++
+	warning := map[string]interface{}{
+		"r": d.replacement,
+		"m": d.message,
+	}
+	if !d.sunset.IsZero() {
+		warning["s"] = d.sunset.Unix()
+	}
+	return warning, !d.sunset.IsZero() && !timeNow().Before(d.sunset)
+}
@@ -0,0 +1,146 @@
+package actions
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSocketPair makes a connected pair of sockets. The first is the server's side, the second the client's.
+func testSocketPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	socketChan := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, w.Header(), 1024, 1024)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		socketChan <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return <-socketChan, client
+}
+
+// readResponse reads the client's next CustomClientAction response.
+func readResponse(t *testing.T, client *websocket.Conn) map[string]interface{} {
+	client.SetReadDeadline(time.Now().Add(time.Second * 2))
+	var message map[string]map[string]interface{}
+	if err := client.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	return message["a"]
+}
+
+func TestDeprecate(t *testing.T) {
+	var calls int
+	New("deprecatedMove", DataTypeNil, func(data interface{}, c *Client) {
+		calls++
+		c.Respond("moved", NoError())
+	})
+	New("replacementMove", DataTypeNil, func(data interface{}, c *Client) {
+		c.Respond("moved", NoError())
+	})
+	New("foreverMove", DataTypeNil, func(data interface{}, c *Client) {})
+	defer func() {
+		timeNow = time.Now
+		for _, action := range []string{"deprecatedMove", "replacementMove", "foreverMove"} {
+			delete(customClientActions, action)
+			delete(deprecations, action)
+		}
+	}()
+
+	if Deprecate("notAnAction", "", "", time.Time{}) == nil {
+		t.Error("Expected an error deprecating an action that doesn't exist")
+	} else if Deprecate("deprecatedMove", "deprecatedMove", "", time.Time{}) == nil {
+		t.Error("Expected an error replacing an action with itself")
+	} else if Deprecate("deprecatedMove", "notAnAction", "", time.Time{}) == nil {
+		t.Error("Expected an error replacing an action with one that doesn't exist")
+	}
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	sunset := now.Add(time.Hour)
+	if err := Deprecate("deprecatedMove", "replacementMove", "Use replacementMove", sunset); err != nil {
+		t.Fatal(err)
+	}
+	Deprecate("foreverMove", "", "Going away some day", time.Time{})
+
+	server, client := testSocketPair(t)
+	// call calls deprecatedMove at the time, and returns true if the callback ran
+	call := func(at time.Time) bool {
+		now = at
+		before := calls
+		HandleCustomClientAction("deprecatedMove", nil, nil, server, "")
+		response := readResponse(t, client)
+		if calls == before {
+			if e, _ := response["e"].(map[string]interface{}); e["c"] != float64(ErrorActionRemoved) ||
+				!strings.Contains(e["m"].(string), "replacementMove") {
+
+				t.Error("Expected an ErrorActionRemoved naming the replacement, got", response)
+			}
+			return false
+		} else if response["r"] != "moved" {
+			t.Error("Expected the callback's response, got", response)
+		}
+		return true
+	}
+
+	// Responses carry the warning until the sunset
+	now = sunset.Add(-time.Minute)
+	HandleCustomClientAction("deprecatedMove", nil, nil, server, "")
+	warning, _ := readResponse(t, client)["w"].(map[string]interface{})
+	if warning["r"] != "replacementMove" || warning["m"] != "Use replacementMove" || warning["s"] != float64(sunset.Unix()) {
+		t.Error("Expected the deprecation warning, got", warning)
+	}
+	HandleCustomClientAction("foreverMove", nil, nil, server, "")
+	HandleCustomClientAction("replacementMove", nil, nil, server, "")
+	if response := readResponse(t, client); response["w"] != nil {
+		t.Error("Expected no warning from an action that isn't deprecated, got", response["w"])
+	}
+
+	// The action is removed at the exact sunset time
+	if !call(sunset.Add(-time.Nanosecond)) {
+		t.Error("Expected the action to run just before its sunset")
+	} else if call(sunset) || call(sunset.Add(time.Nanosecond)) {
+		t.Error("Expected the action to be removed from its sunset")
+	}
+
+	// A sunset that passed is extended from now, and one that hasn't is extended from itself
+	if err := ExtendSunset("deprecatedMove", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	extended := sunset.Add(time.Nanosecond).Add(time.Hour)
+	if !call(extended.Add(-time.Nanosecond)) || call(extended) {
+		t.Error("Expected the sunset to move to an hour from when it was extended")
+	}
+	now = sunset
+	if err := ExtendSunset("deprecatedMove", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	extended = extended.Add(time.Hour)
+	if !call(extended.Add(-time.Nanosecond)) || call(extended) {
+		t.Error("Expected the sunset to move back by another hour")
+	}
+	if ExtendSunset("deprecatedMove", 0) == nil {
+		t.Error("Expected an error extending a sunset by nothing")
+	} else if ExtendSunset("replacementMove", time.Hour) == nil {
+		t.Error("Expected an error extending the sunset of an action that isn't deprecated")
+	} else if ExtendSunset("foreverMove", time.Hour) == nil {
+		t.Error("Expected an error extending an action without a sunset")
+	}
+
+	// Calls are counted whether they ran or not
+	if uses := DeprecatedUsage("deprecatedMove"); uses != 8 {
+		t.Error("Expected 8 deprecated calls, got", uses)
+	} else if uses = DeprecatedUsage("replacementMove"); uses != 0 {
+		t.Error("Expected no deprecated calls of the replacement, got", uses)
+	}
+}
@@ -0,0 +1,114 @@
+package actions
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"time"
+)
+
+// ActionHandler handles a client action in a middleware chain made with actions.Use(). It gets the client's User (nil
+// before they log in), the name of the action, and its parameters. Returning an error answers the client with it instead
+// of the action's response. A helpers.GopherError keeps its error code, and any other error gets helpers.ErrorActionRejected.
+//
+// Calling the next ActionHandler runs the rest of the chain, and then the action. It returns the action's error, if it had one.
+type ActionHandler func(user *core.User, action string, params interface{}) error
+
+var (
+	middleware []func(ActionHandler) ActionHandler
+)
+
+// Use adds a middleware that every client action goes through, built-in actions like logging in included. Middleware
+// runs in the order it's added, after ActionRateLimit in ServerSettings. Custom actions sent with the built-in custom action
+// message get their own name and data as the action and parameters.
+//
+// Your middleware gets the next ActionHandler in the chain, and returns the ActionHandler that runs before it:
+//
+//	actions.Use(func(next actions.ActionHandler) actions.ActionHandler {
+//	    return func(user *core.User, action string, params interface{}) error {
+//	        if action == "move" && user == nil {
+//	            return errors.New("Log in to move")
+//	        }
+//	        return next(user, action, params)
+//	    }
+//	})
+//
+// Note: This function can only be called BEFORE starting the server.
+func Use(mw func(next ActionHandler) ActionHandler) error {
+	if serverStarted {
+		return errors.New("Cannot add middleware once the server has started")
+	} else if mw == nil {
+		return errors.New("actions.Use() requires a middleware")
+	}
+	middleware = append(middleware, mw)
+	return nil
+}
+
+// HasMiddleware is only for internal Gopher Game Server mechanics.
+func HasMiddleware() bool {
+	return len(middleware) > 0
+}
+
+// RunMiddleware is only for internal Gopher Game Server mechanics.
+func RunMiddleware(user *core.User, action string, params interface{}, handle ActionHandler) helpers.GopherError {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handle = middleware[i](handle)
+	}
+	if err := handle(user, action, params); err != nil {
+		return helpers.ErrorFrom(err, helpers.ErrorActionRejected)
+	}
+	return helpers.NoError()
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   STOCK MIDDLEWARE   //////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// LogActions is a middleware that logs every client action with the User that sent it, how long it took, and its error.
+func LogActions(next ActionHandler) ActionHandler {
+	return func(user *core.User, action string, params interface{}) error {
+		start := time.Now()
+		err := next(user, action, params)
+		var userName string
+		if user != nil {
+			userName = user.Name()
+		}
+		if err != nil {
+			helpers.Log().Info("Client action", "action", action, "user", userName, "duration", time.Since(start), "error", err)
+		} else {
+			helpers.Log().Info("Client action", "action", action, "user", userName, "duration", time.Since(start))
+		}
+		return err
+	}
+}
+
+// RequireLoginFor makes a middleware that only lets clients that are logged in call the actions, built-in or custom. Any
+// other client gets a helpers.ErrorGopherNotLoggedIn error.
+func RequireLoginFor(actionTypes ...string) func(next ActionHandler) ActionHandler {
+	required := make(map[string]bool, len(actionTypes))
+	for _, actionType := range actionTypes {
+		required[actionType] = true
+	}
+	return func(next ActionHandler) ActionHandler {
+		return func(user *core.User, action string, params interface{}) error {
+			if user == nil && required[action] {
+				return helpers.NewError("You must be logged in", helpers.ErrorGopherNotLoggedIn)
+			}
+			return next(user, action, params)
+		}
+	}
+}
+
+// MaxParamsSize makes a middleware that rejects actions with parameters bigger than maxBytes when they're encoded as JSON,
+// with a helpers.ErrorActionRejected error.
+func MaxParamsSize(maxBytes int) func(next ActionHandler) ActionHandler {
+	return func(next ActionHandler) ActionHandler {
+		return func(user *core.User, action string, params interface{}) error {
+			if encoded, err := json.Marshal(params); err != nil || len(encoded) > maxBytes {
+				return helpers.NewError("The action's parameters are too large", helpers.ErrorActionRejected)
+			}
+			return next(user, action, params)
+		}
+	}
+}
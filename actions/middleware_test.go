@@ -0,0 +1,71 @@
+package actions
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strings"
+	"testing"
+)
+
+func TestMiddleware(t *testing.T) {
+	defer func() { middleware = nil }()
+	var order []string
+	trace := func(name string) func(ActionHandler) ActionHandler {
+		return func(next ActionHandler) ActionHandler {
+			return func(user *core.User, action string, params interface{}) error {
+				order = append(order, name)
+				return next(user, action, params)
+			}
+		}
+	}
+	Use(trace("first"))
+	Use(trace("second"))
+	Use(RequireLoginFor("move"))
+	Use(MaxParamsSize(16))
+	if Use(nil) == nil {
+		t.Error("Use() should reject a nil middleware")
+	}
+
+	ran := false
+	action := func(*core.User, string, interface{}) error {
+		ran = true
+		return nil
+	}
+
+	// Middleware runs in the order it was added, then the action
+	if err := RunMiddleware(nil, "li", "name", action); err.ID != 0 || !ran {
+		t.Fatal("Expected the action to run, got", err)
+	} else if strings.Join(order, ",") != "first,second" {
+		t.Error("Expected the middleware to run in order, got", order)
+	}
+
+	// Middleware can stop an action
+	ran = false
+	if err := RunMiddleware(nil, "move", nil, action); err.ID != helpers.ErrorGopherNotLoggedIn || ran {
+		t.Error("Expected ErrorGopherNotLoggedIn without running the action, got", err)
+	}
+	if err := RunMiddleware(nil, "li", strings.Repeat("x", 20), action); err.ID != helpers.ErrorActionRejected || ran {
+		t.Error("Expected ErrorActionRejected for large parameters, got", err)
+	}
+
+	// Plain errors get ErrorActionRejected, and the action's errors come back through the chain
+	middleware = nil
+	Use(func(next ActionHandler) ActionHandler {
+		return func(user *core.User, action string, params interface{}) error {
+			if action == "blocked" {
+				return errors.New("Blocked")
+			}
+			return next(user, action, params)
+		}
+	})
+	if err := RunMiddleware(nil, "blocked", nil, action); err.ID != helpers.ErrorActionRejected || err.Message != "Blocked" {
+		t.Error("Expected the middleware's error, got", err)
+	}
+	failing := func(*core.User, string, interface{}) error {
+		return helpers.NewError("Room full", helpers.ErrorRoomFull)
+	}
+	if err := RunMiddleware(nil, "j", "room", failing); err.ID != helpers.ErrorRoomFull {
+		t.Error("Expected the action's error, got", err)
+	}
+}
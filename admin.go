@@ -0,0 +1,388 @@
+package gopher
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The admin actions an admin client can send with the helpers.ClientActionAdminAction client action, as {"a": action, "d": data}.
+// Your admin action callback receives the action and its data before it's taken.
+const (
+	AdminActionUsers      = "users"      // Lists the logged in Users, with their status, and the Room and IP address of each of their connections
+	AdminActionKick       = "kick"       // Logs out the User whose name is the data
+	AdminActionBanUser    = "banUser"    // Bans the account whose name is the data, or {"n": name, "s": seconds, "r": reason}, and logs the User out. See core.Ban()
+	AdminActionUnbanUser  = "unbanUser"  // Unbans the account whose name is the data
+	AdminActionBanIP      = "banIP"      // Bans the IP address that is the data, or {"ip": ip, "s": seconds, "r": reason}, and disconnects its clients. See gopher.BanIP()
+	AdminActionUnbanIP    = "unbanIP"    // Unbans the IP address that is the data
+	AdminActionBans       = "bans"       // Lists the bans as [{"n": name, "ip": ip, "r": reason, "c": created, "x": expires}], in unix seconds. An "x" of 0 never expires
+	AdminActionRooms      = "rooms"      // Lists every Room, with its type, owner, and how many Users are in it
+	AdminActionDeleteRoom = "deleteRoom" // Deletes the Room whose name is the data, even if Users are in it
+	AdminActionAnnounce   = "announce"   // Sends an announcement to every client with gopher.Broadcast(). The data is {"t": messageType, "d": data}
+)
+
+const (
+	errorAdminLogin                 = "Incorrect admin login or password"
+	errorAdminRemote                = "Admin logins are only allowed from the server's machine"
+	errorAdminOrigin                = "Admin logins are only allowed from the server's origin"
+	errorNotAdmin                   = "You must be logged in as an admin"
+	errorAdminDenied                = "Admin action was denied"
+	errorInvalidAdminAction         = "Invalid admin action"
+	errorIncorrectFormatAdminAction = "Incorrect data format for admin action"
+
+	// ADMIN LOGIN ATTEMPTS EACH IP ADDRESS CAN MAKE EVERY adminLoginWindow, EVEN WITHOUT A LoginAttemptLimit
+	adminLoginLimit  = 5
+	adminLoginWindow = time.Minute
+)
+
+var (
+	adminLoginLimits ipBuckets = ipBuckets{buckets: make(map[string]*tokenBucket)}
+
+	adminActions = map[string]func(interface{}) (interface{}, error){
+		AdminActionUsers:      adminListUsers,
+		AdminActionKick:       adminKick,
+		AdminActionBanUser:    adminBanUser,
+		AdminActionUnbanUser:  adminUnbanUser,
+		AdminActionBanIP:      adminBanIP,
+		AdminActionUnbanIP:    adminUnbanIP,
+		AdminActionBans:       adminListBans,
+		AdminActionRooms:      adminListRooms,
+		AdminActionDeleteRoom: adminDeleteRoom,
+		AdminActionAnnounce:   adminAnnounce,
+	}
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   IP BANS   ///////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// BanIP stops clients from connecting from the IP address ip for duration, or forever when duration is 0, and disconnects the
+// clients that are connected from it. The IP address is the one the rate limits use, so set TrustedProxies in ServerSettings
+// if the server is behind a proxy. Bans are saved like core.Ban() saves them, and are listed with core.ListBans(). To ban an
+// account instead, use core.Ban().
+func BanIP(ip string, duration time.Duration, reason string) error {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return errors.New("Invalid IP address '" + ip + "'")
+	}
+	ip = parsed.String()
+	if err := core.BanAddress(ip, duration, reason); err != nil {
+		return err
+	}
+	helpers.Log().Info("IP address banned", "ip", ip, "reason", reason, "duration", duration)
+
+	// DISCONNECT EVERYONE ON IT
+	var sockets []*websocket.Conn
+	conns.connsMux.Lock()
+	for conn, info := range conns.sockets {
+		if info.ip == ip {
+			sockets = append(sockets, conn)
+		}
+	}
+	conns.connsMux.Unlock()
+	for _, conn := range sockets {
+		core.CloseBanned(conn)
+	}
+
+	//
+	return nil
+}
+
+// UnbanIP lets clients connect from the IP address ip again after a BanIP().
+func UnbanIP(ip string) error {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	if err := core.UnbanAddress(ip); err != nil {
+		return err
+	}
+	helpers.Log().Info("IP address unbanned", "ip", ip)
+	return nil
+}
+
+// IsBannedIP returns true if the IP address ip is banned.
+func IsBannedIP(ip string) bool {
+	if parsed := net.ParseIP(ip); parsed != nil {
+		ip = parsed.String()
+	}
+	_, banned := core.GetAddressBan(ip)
+	return banned
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   ADMIN CLIENT ACTIONS   //////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func clientActionAdminLogin(params interface{}, conn *websocket.Conn) (interface{}, bool, helpers.GopherError) {
+	info := conns.info(conn)
+	if !(*settings).EnableAdminTools || info == nil {
+		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+	}
+	// Get param map and extract values
+	var ok bool
+	var pMap map[string]interface{}
+	var name string
+	var pass string
+	if pMap, ok = params.(map[string]interface{}); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+	}
+	if name, ok = pMap["n"].(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormatName, helpers.ErrorGopherNameFormat)
+	}
+	if pass, ok = pMap["p"].(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormatPass, helpers.ErrorGopherPasswordFormat)
+	}
+	// Check where the client connected from, then their login
+	if reason := adminLoginRefused(info); reason != "" {
+		helpers.Log().Warn("Admin login refused", "ip", info.ip, "origin", info.origin, "reason", reason)
+		return nil, true, helpers.NewError(reason, helpers.ErrorAdminLogin)
+	} else if !secureEqual(name, (*settings).AdminLogin) || !secureEqual(pass, (*settings).AdminPassword) {
+		helpers.Log().Warn("Failed admin login", "ip", info.ip, "origin", info.origin)
+		return nil, true, helpers.NewError(errorAdminLogin, helpers.ErrorAdminLogin)
+	}
+	conns.connsMux.Lock()
+	info.admin = true
+	conns.connsMux.Unlock()
+	helpers.Log().Info("Admin logged in", "ip", info.ip)
+
+	//
+	return nil, true, helpers.NoError()
+}
+
+// adminLoginRefused gets why admin logins aren't allowed from where a client connected, or "" when they are. Without
+// EnableRemoteAdmin in ServerSettings, admins must connect from the server's machine. With it, admins connecting from a
+// browser must be on the server's HostName or HostAlias.
+func adminLoginRefused(info *socketInfo) string {
+	if !(*settings).EnableRemoteAdmin {
+		if ip := net.ParseIP(info.ip); ip == nil || !ip.IsLoopback() {
+			return errorAdminRemote
+		}
+	} else if info.origin != "" && !strings.EqualFold(info.origin, (*settings).HostName) &&
+		((*settings).HostAlias == "" || !strings.EqualFold(info.origin, (*settings).HostAlias)) {
+		return errorAdminOrigin
+	}
+	return ""
+}
+
+// secureEqual compares two strings in the same time no matter where they differ.
+func secureEqual(a string, b string) bool {
+	aSum, bSum := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(aSum[:], bSum[:]) == 1
+}
+
+func clientActionAdminAction(params interface{}, conn *websocket.Conn) (interface{}, bool, helpers.GopherError) {
+	info := conns.info(conn)
+	if !(*settings).EnableAdminTools || info == nil {
+		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+	}
+	conns.connsMux.Lock()
+	admin := info.admin
+	conns.connsMux.Unlock()
+	if !admin {
+		return nil, true, helpers.NewError(errorNotAdmin, helpers.ErrorNotAdmin)
+	}
+	// Get param map and extract values
+	var ok bool
+	var pMap map[string]interface{}
+	var action string
+	if pMap, ok = params.(map[string]interface{}); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+	}
+	if action, ok = pMap["a"].(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormatAction, helpers.ErrorGopherIncorrectFormat)
+	}
+	handler, ok := adminActions[action]
+	if !ok {
+		return nil, true, helpers.NewError(errorInvalidAdminAction, helpers.ErrorAdminAction)
+	}
+	// Admin action callback
+	if adminActionCallback != nil && !adminActionCallback(action, pMap["d"]) {
+		helpers.Log().Info("Admin action denied", "action", action, "ip", info.ip)
+		return nil, true, helpers.NewError(errorAdminDenied, helpers.ErrorActionDenied)
+	}
+	// Take action
+	result, err := handler(pMap["d"])
+	if err != nil {
+		return nil, true, helpers.ErrorFrom(err, helpers.ErrorAdminAction)
+	}
+	helpers.Log().Info("Admin action", "action", action, "data", pMap["d"], "ip", info.ip)
+
+	//
+	return result, true, helpers.NoError()
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   ADMIN ACTIONS   /////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func adminListUsers(data interface{}) (interface{}, error) {
+	users := core.GetUsers()
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name() < users[j].Name()
+	})
+	list := make([]map[string]interface{}, 0, len(users))
+	for _, user := range users {
+		userConns := []map[string]interface{}{}
+		for _, connID := range user.ConnectionIDs() {
+			var roomName, ip string
+			if room := user.RoomIn(connID); room != nil {
+				roomName = room.Name()
+			}
+			if socket := user.Socket(connID); socket != nil {
+				if info := conns.info(socket); info != nil {
+					ip = info.ip
+				}
+			}
+			userConns = append(userConns, map[string]interface{}{
+				"i":  connID,
+				"r":  roomName,
+				"ip": ip,
+			})
+		}
+		list = append(list, map[string]interface{}{
+			"n": user.Name(),
+			"g": user.IsGuest(),
+			"s": user.Status(),
+			"c": userConns,
+		})
+	}
+	return list, nil
+}
+
+func adminKick(data interface{}) (interface{}, error) {
+	userName, ok := data.(string)
+	if !ok {
+		return nil, errors.New(errorIncorrectFormatAdminAction)
+	}
+	user, err := core.GetUser(userName)
+	if err != nil {
+		return nil, err
+	}
+	user.Kick()
+	return nil, nil
+}
+
+// banData gets the target, duration and reason of a ban admin action. The data is either the target, for a permanent ban
+// without a reason, or {key: target, "s": seconds, "r": reason}.
+func banData(data interface{}, key string) (string, time.Duration, string, error) {
+	if target, ok := data.(string); ok {
+		return target, 0, "", nil
+	}
+	pMap, ok := data.(map[string]interface{})
+	if !ok {
+		return "", 0, "", errors.New(errorIncorrectFormatAdminAction)
+	}
+	target, ok := pMap[key].(string)
+	if !ok {
+		return "", 0, "", errors.New(errorIncorrectFormatAdminAction)
+	}
+	var duration time.Duration
+	if seconds, ok := pMap["s"].(float64); ok {
+		duration = time.Duration(seconds * float64(time.Second))
+	} else if pMap["s"] != nil {
+		return "", 0, "", errors.New(errorIncorrectFormatAdminAction)
+	}
+	reason, _ := pMap["r"].(string)
+	return target, duration, reason, nil
+}
+
+func adminBanUser(data interface{}) (interface{}, error) {
+	userName, duration, reason, err := banData(data, "n")
+	if err != nil {
+		return nil, err
+	}
+	return nil, core.Ban(userName, duration, reason)
+}
+
+func adminUnbanUser(data interface{}) (interface{}, error) {
+	userName, ok := data.(string)
+	if !ok {
+		return nil, errors.New(errorIncorrectFormatAdminAction)
+	}
+	return nil, core.Unban(userName)
+}
+
+func adminBanIP(data interface{}) (interface{}, error) {
+	ip, duration, reason, err := banData(data, "ip")
+	if err != nil {
+		return nil, err
+	}
+	return nil, BanIP(ip, duration, reason)
+}
+
+func adminUnbanIP(data interface{}) (interface{}, error) {
+	ip, ok := data.(string)
+	if !ok {
+		return nil, errors.New(errorIncorrectFormatAdminAction)
+	}
+	return nil, UnbanIP(ip)
+}
+
+func adminListBans(data interface{}) (interface{}, error) {
+	bans := []map[string]interface{}{}
+	for _, ban := range core.ListBans() {
+		var expires int64
+		if !ban.Expires.IsZero() {
+			expires = ban.Expires.Unix()
+		}
+		bans = append(bans, map[string]interface{}{
+			"n":  ban.Name,
+			"ip": ban.IP,
+			"r":  ban.Reason,
+			"c":  ban.Created.Unix(),
+			"x":  expires,
+		})
+	}
+	return bans, nil
+}
+
+func adminListRooms(data interface{}) (interface{}, error) {
+	rooms := core.GetRooms()
+	sort.Slice(rooms, func(i, j int) bool {
+		return rooms[i].Name() < rooms[j].Name()
+	})
+	list := make([]map[string]interface{}, 0, len(rooms))
+	for _, room := range rooms {
+		list = append(list, map[string]interface{}{
+			"n": room.Name(),
+			"t": room.Type(),
+			"p": room.IsPrivate(),
+			"o": room.Owner(),
+			"u": room.NumUsers(),
+			"s": room.NumSpectators(),
+			"m": room.MaxUsers(),
+		})
+	}
+	return list, nil
+}
+
+func adminDeleteRoom(data interface{}) (interface{}, error) {
+	roomName, ok := data.(string)
+	if !ok {
+		return nil, errors.New(errorIncorrectFormatAdminAction)
+	}
+	room, err := core.GetRoom(roomName)
+	if err != nil {
+		return nil, err
+	}
+	return nil, room.Delete()
+}
+
+func adminAnnounce(data interface{}) (interface{}, error) {
+	announcement, ok := data.(map[string]interface{})
+	if !ok {
+		return nil, errors.New(errorIncorrectFormatAdminAction)
+	}
+	messageType, ok := announcement["t"].(string)
+	if !ok || messageType == "" {
+		return nil, errors.New(errorIncorrectFormatAdminAction)
+	}
+	return nil, Broadcast(messageType, announcement["d"])
+}
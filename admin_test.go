@@ -0,0 +1,192 @@
+package gopher
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdminLoginRefused(t *testing.T) {
+	oldSettings := settings
+	defer func() { settings = oldSettings }()
+	settings = &ServerSettings{HostName: "https://example.com", HostAlias: "https://www.example.com"}
+
+	tests := []struct {
+		remote bool
+		info   socketInfo
+		reason string
+	}{
+		{false, socketInfo{ip: "127.0.0.1"}, ""},
+		{false, socketInfo{ip: "::1"}, ""},
+		{false, socketInfo{ip: "203.0.113.5"}, errorAdminRemote},
+		{true, socketInfo{ip: "203.0.113.5"}, ""},
+		{true, socketInfo{ip: "203.0.113.5", origin: "https://www.example.com"}, ""},
+		{true, socketInfo{ip: "203.0.113.5", origin: "https://evil.com"}, errorAdminOrigin},
+	}
+	for _, test := range tests {
+		settings.EnableRemoteAdmin = test.remote
+		if reason := adminLoginRefused(&test.info); reason != test.reason {
+			t.Errorf("adminLoginRefused(%v) with EnableRemoteAdmin %v = %q, expected %q", test.info, test.remote, reason, test.reason)
+		}
+	}
+}
+
+func TestAdminTools(t *testing.T) {
+	waitForDisconnects(t)
+	oldSettings := settings
+	defer func() {
+		waitForDisconnects(t)
+		settings = oldSettings
+		clientDisconnectCallback = nil
+		adminActionCallback = nil
+		adminLoginLimits = ipBuckets{buckets: make(map[string]*tokenBucket)}
+	}()
+	disconnected := make(chan bool, 2)
+	clientDisconnectCallback = func(string, bool, error) {
+		disconnected <- true
+	}
+	adminActionCallback = func(action string, data interface{}) bool {
+		return action != AdminActionDeleteRoom
+	}
+	settings = &ServerSettings{HostName: "localhost", EnableAdminTools: true, AdminLogin: "admin", AdminPassword: "secret"}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	admin, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	player, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer player.Close()
+
+	// send sends a client action, and reads messages until its response
+	send := func(client *websocket.Conn, action string, params interface{}) map[string]interface{} {
+		client.WriteJSON(map[string]interface{}{"A": action, "P": params})
+		for {
+			var message map[string]map[string]interface{}
+			if err := client.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			} else if response, ok := message[helpers.ServerActionClientActionResponse]; ok && response["a"] == action {
+				return response
+			}
+		}
+	}
+	errorID := func(response map[string]interface{}) int {
+		if e, ok := response["e"].(map[string]interface{}); ok {
+			return int(e["id"].(float64))
+		}
+		return 0
+	}
+	adminAction := func(action string, data interface{}) map[string]interface{} {
+		return send(admin, helpers.ClientActionAdminAction, map[string]interface{}{"a": action, "d": data})
+	}
+
+	if id := errorID(send(player, helpers.ClientActionLogin, map[string]interface{}{"n": "adminTarget"})); id != 0 {
+		t.Fatal("Expected the player to log in, got error", id)
+	}
+	core.NewRoomType("adminTest", false)
+	room, roomErr := core.NewRoom("adminRoom", "adminTest", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	// Admin actions need an admin login with the right password
+	if id := errorID(adminAction(AdminActionUsers, nil)); id != helpers.ErrorNotAdmin {
+		t.Error("Expected admin actions to require an admin login, got error", id)
+	}
+	if id := errorID(send(admin, helpers.ClientActionAdminLogin, map[string]interface{}{"n": "admin", "p": "wrong"})); id != helpers.ErrorAdminLogin {
+		t.Error("Expected a wrong admin password to fail, got error", id)
+	}
+	if id := errorID(send(admin, helpers.ClientActionAdminLogin, map[string]interface{}{"n": "admin", "p": "secret"})); id != 0 {
+		t.Fatal("Expected the admin login to succeed, got error", id)
+	}
+
+	// Listing
+	users, _ := adminAction(AdminActionUsers, nil)["r"].([]interface{})
+	var found bool
+	for _, u := range users {
+		user := u.(map[string]interface{})
+		if user["n"] == "adminTarget" {
+			conns := user["c"].([]interface{})
+			found = len(conns) == 1 && conns[0].(map[string]interface{})["ip"] == "127.0.0.1"
+		}
+	}
+	if !found {
+		t.Error("Expected the player in the User list with their IP address, got", users)
+	}
+	rooms, _ := adminAction(AdminActionRooms, nil)["r"].([]interface{})
+	found = false
+	for _, r := range rooms {
+		if r.(map[string]interface{})["n"] == "adminRoom" {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("Expected the Room in the Room list, got", rooms)
+	}
+
+	// The admin action callback can deny actions
+	if id := errorID(adminAction(AdminActionDeleteRoom, "adminRoom")); id != helpers.ErrorActionDenied {
+		t.Error("Expected the callback to deny deleting the Room, got error", id)
+	} else if _, err := core.GetRoom("adminRoom"); err != nil {
+		t.Error("The denied action should not have deleted the Room")
+	}
+	if id := errorID(adminAction("aMadeUpAction", nil)); id != helpers.ErrorAdminAction {
+		t.Error("Expected an invalid admin action error, got error", id)
+	}
+
+	// Banning an account logs them out, disconnects them, and keeps them out
+	if id := errorID(adminAction(AdminActionBanUser, map[string]interface{}{"n": "adminTarget", "s": 3600, "r": "Cheating"})); id != 0 {
+		t.Error("Expected the ban to succeed, got error", id)
+	} else if _, err := core.GetUser("adminTarget"); err == nil {
+		t.Error("The banned User should have been logged out")
+	}
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Expected the banned User to be disconnected")
+	}
+	player, _, err = websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer player.Close()
+	banned := send(player, helpers.ClientActionLogin, map[string]interface{}{"n": "adminTarget"})
+	if id := errorID(banned); id != helpers.ErrorAuthBanned {
+		t.Error("Expected the banned User's login to fail, got error", id)
+	} else if message := banned["e"].(map[string]interface{})["m"].(string); !strings.Contains(message, "Cheating") || !strings.Contains(message, "left") {
+		t.Error("Expected the ban's reason and time left in the login error, got", message)
+	}
+	bans, _ := adminAction(AdminActionBans, nil)["r"].([]interface{})
+	if len(bans) != 1 || bans[0].(map[string]interface{})["n"] != "adminTarget" || bans[0].(map[string]interface{})["x"].(float64) == 0 {
+		t.Error("Expected the temporary ban in the ban list, got", bans)
+	}
+	if id := errorID(adminAction(AdminActionUnbanUser, "adminTarget")); id != 0 {
+		t.Error("Expected the unban to succeed, got error", id)
+	}
+
+	// Banning an IP disconnects everyone on it, the admin too, and keeps them from connecting
+	defer UnbanIP("127.0.0.1")
+	admin.WriteJSON(map[string]interface{}{"A": helpers.ClientActionAdminAction, "P": map[string]interface{}{"a": AdminActionBanIP, "d": "127.0.0.1"}})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second * 2):
+			t.Fatal("Expected both clients to be disconnected")
+		}
+	}
+	if _, _, err := websocket.DefaultDialer.Dial(url, nil); err == nil {
+		t.Error("Connections from a banned IP address should be declined")
+	}
+}
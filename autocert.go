@@ -0,0 +1,59 @@
+package gopher
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"golang.org/x/crypto/acme/autocert"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var (
+	// SERVES THE ACME HTTP-01 CHALLENGES ON PORT 80 WITH AutoCert
+	challengeServer *http.Server
+)
+
+// useTLS checks if the server listens with TLS, from certificate files or AutoCert.
+func (settings *ServerSettings) useTLS() bool {
+	return settings.TLS || settings.AutoCert
+}
+
+// autoCertHosts gets the host names AutoCert gets certificates for. Defaults to the hosts of HostName and HostAlias.
+func (settings *ServerSettings) autoCertHosts() []string {
+	if len(settings.AutoCertHosts) > 0 {
+		return settings.AutoCertHosts
+	}
+	var hosts []string
+	for _, name := range []string{settings.HostName, settings.HostAlias} {
+		if name == "" {
+			continue
+		}
+		if !strings.Contains(name, "://") {
+			name = "https://" + name
+		}
+		if u, err := url.Parse(name); err == nil && u.Hostname() != "" && u.Hostname() != "localhost" && net.ParseIP(u.Hostname()) == nil {
+			hosts = append(hosts, u.Hostname())
+		}
+	}
+	return hosts
+}
+
+// useAutoCert makes the server get its certificates from Let's Encrypt, and starts serving the HTTP-01 challenges on port 80.
+// Certificates are renewed in the background before they expire, and new TLS handshakes pick them up, so renewing doesn't
+// need a restart or drop any connections.
+func useAutoCert(server *http.Server) {
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(settings.autoCertHosts()...),
+		Cache:      autocert.DirCache(settings.AutoCertCacheDir),
+	}
+	server.TLSConfig = manager.TLSConfig()
+
+	challengeServer = &http.Server{Addr: net.JoinHostPort(settings.IP, "80"), Handler: manager.HTTPHandler(nil)}
+	go func() {
+		if err := challengeServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			helpers.Log().Error("AutoCert challenge server error", "error", err)
+		}
+	}()
+}
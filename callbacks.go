@@ -4,6 +4,7 @@ import (
 	"errors"
 	"github.com/hewiefreeman/GopherGameServer/core"
 	"github.com/hewiefreeman/GopherGameServer/database"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
 	"net/http"
 )
 
@@ -25,7 +26,7 @@ func SetStartCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func()); ok {
-		startCallback = callback
+		startCallback = func() { helpers.Protect("start callback", callback) }
 	} else {
 		return errors.New(ErrorIncorrectFunction)
 	}
@@ -42,7 +43,7 @@ func SetPauseCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func()); ok {
-		pauseCallback = callback
+		pauseCallback = func() { helpers.Protect("pause callback", callback) }
 	} else {
 		return errors.New(ErrorIncorrectFunction)
 	}
@@ -59,7 +60,7 @@ func SetResumeCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func()); ok {
-		resumeCallback = callback
+		resumeCallback = func() { helpers.Protect("resume callback", callback) }
 	} else {
 		return errors.New(ErrorIncorrectFunction)
 	}
@@ -76,7 +77,7 @@ func SetShutDownCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func()); ok {
-		stopCallback = callback
+		stopCallback = func() { helpers.Protect("shut down callback", callback) }
 	} else {
 		return errors.New(ErrorIncorrectFunction)
 	}
@@ -92,11 +93,78 @@ func SetShutDownCallback(cb interface{}) error {
 //
 // The function returns a boolean. If false is returned, the client will receive an HTTP error `http.StatusForbidden` and
 // will be rejected from the server. This can be used to, for instance, make a black/white list or implement client sessions.
+//
+// The function can also return a map of metadata about the connection, like the account from an SSO header or a country
+// from the client's IP address:
+//
+//    func clientConnected(writer *http.ResponseWriter, request *http.Request) (bool, map[string]interface{}) {
+//	     return true, map[string]interface{}{"country": lookUpCountry(request)}
+//	 }
+//
+// The metadata becomes the User variables of the connection when it logs in, so you can get them with `*User.GetVariable()`.
+// The client's IP address is always kept, and you can get it with `*User.IP()`.
 func SetClientConnectCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
-	} else if callback, ok := cb.(func(*http.ResponseWriter, *http.Request) bool); ok {
-		clientConnectCallback = callback
+	}
+	switch callback := cb.(type) {
+	case func(*http.ResponseWriter, *http.Request) bool:
+		clientConnectCallback = func(writer *http.ResponseWriter, request *http.Request) (accept bool, metadata map[string]interface{}) {
+			helpers.Protect("client connect callback", func() { accept = callback(writer, request) }, "ip", request.RemoteAddr)
+			return
+		}
+	case func(*http.ResponseWriter, *http.Request) (bool, map[string]interface{}):
+		clientConnectCallback = func(writer *http.ResponseWriter, request *http.Request) (accept bool, metadata map[string]interface{}) {
+			helpers.Protect("client connect callback", func() { accept, metadata = callback(writer, request) }, "ip", request.RemoteAddr)
+			return
+		}
+	default:
+		return errors.New(ErrorIncorrectFunction)
+	}
+	return nil
+}
+
+// SetClientVersionCallback sets the callback that decides which protocol versions clients can connect with, instead of
+// MinClientVersion in ServerSettings. The function passed must have the same parameter types as the following example:
+//
+//    func clientVersionCheck(version string) bool {
+//	     //code...
+//	 }
+//
+// The version is what the client sent, like "1.1", or helpers.LegacyProtocolVersion when it didn't send one, so it might not be a
+// version at all. If false is returned, the client gets a ServerActionClientOutdated message and is disconnected, without the client
+// connect callback being called. Get the version a logged in client connected with from `*User.ClientVersion()`.
+func SetClientVersionCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string) bool); ok {
+		clientVersionCallback = func(version string) (allow bool) {
+			helpers.Protect("client version callback", func() { allow = callback(version) }, "version", version)
+			return
+		}
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetClientDisconnectCallback sets the callback that triggers when a client's connection closes, whether they logged out
+// first or their connection dropped. The function passed must have the same parameter types as the following example:
+//
+//    func clientDisconnected(userName string, wasLoggedIn bool, err error) {
+//	     //code...
+//	 }
+//
+// The userName is an empty string when the client wasn't logged in. If they were, the callback runs after they've been removed
+// from their Room, but before they're logged out. The err is what closed the connection, for instance a *websocket.CloseError
+// from the client, a read timeout when PingInterval is set, or helpers.ErrWriteTimeout and helpers.ErrSlowClient when the client
+// couldn't keep up with what the server sent them. The callback runs exactly once for every connection.
+func SetClientDisconnectCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string, bool, error)); ok {
+		clientDisconnectCallback = func(userName string, wasLoggedIn bool, err error) {
+			helpers.Protect("client disconnect callback", func() { callback(userName, wasLoggedIn, err) }, "user", userName)
+		}
 		return nil
 	}
 	return errors.New(ErrorIncorrectFunction)
@@ -113,7 +181,8 @@ func SetClientConnectCallback(cb interface{}) error {
 // received from the database, and `clientColumns` have the same keys as the `receivedColumns`, but are the input from the client.
 //
 // The function returns a boolean. If false is returned, the client will receive a `helpers.ErrorActionDenied` (1052) error and will be
-// denied from logging in. This can be used to, for instance, suspend or ban a User.
+// denied from logging in. This can be used to, for instance, suspend or ban a User. Guests trigger it too, with a `databaseID` of -1
+// and nil columns.
 //
 // Note: the `clientColumns` decides which `AccountInfoColumn`s were fetched from the database, so the keys will always be the same as `receivedColumns`.
 // You can compare the `receivedColumns` and `clientColumns` to, for instance, compare the key 'email' to make sure the
@@ -122,18 +191,19 @@ func SetLoginCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func(string, int, map[string]interface{}, map[string]interface{}) bool); ok {
+		callback = protectAccountCallback("login callback", callback)
 		if (*settings).EnableSqlFeatures {
 			database.LoginCallback = callback
-		} else {
-			core.LoginCallback = callback
 		}
+		// GUESTS ARE ALWAYS LOGGED IN BY core
+		core.LoginCallback = callback
 		return nil
 	}
 	return errors.New(ErrorIncorrectFunction)
 }
 
-// SetLogoutCallback sets the callback that triggers when a client logs out from a User. The
-// function passed must have the same parameter types as the following example:
+// SetLogoutCallback sets the callback that triggers when a User logs out. With MultiConnect in ServerSettings, it only
+// triggers once the User's last connection logs out. The function passed must have the same parameter types as the following example:
 //
 //    func clientLoggedOut(userName string, databaseID int) {
 //	     //code...
@@ -144,7 +214,75 @@ func SetLogoutCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func(string, int)); ok {
-		core.LogoutCallback = callback
+		core.LogoutCallback = func(userName string, databaseID int) {
+			helpers.Protect("logout callback", func() { callback(userName, databaseID) }, "user", userName)
+		}
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetRoomJoinCallback sets the callback that triggers when a User joins a Room. The
+// function passed must have the same parameter types as the following example:
+//
+//    func userJoinedRoom(roomName string, userName string) {
+//	     //code...
+//	 }
+//
+// With MultiConnect enabled, the callback only triggers for the first of the User's connections to join the Room.
+// The callback runs after the User has been added to the Room, and while no Room or User is locked, so it's safe
+// to use any of the *Room and *User methods within it.
+func SetRoomJoinCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string, string)); ok {
+		core.RoomJoinCallback = func(roomName string, userName string) {
+			helpers.Protect("room join callback", func() { callback(roomName, userName) }, "room", roomName, "user", userName)
+		}
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetRoomLeaveCallback sets the callback that triggers when a User leaves a Room. The
+// function passed must have the same parameter types as the following example:
+//
+//    func userLeftRoom(roomName string, userName string, reason int) {
+//	     //code...
+//	 }
+//
+// The `reason` is one of `core.LeaveReasonVoluntary`, `core.LeaveReasonLogout`, `core.LeaveReasonKick`, or `core.LeaveReasonDeleted`.
+// With MultiConnect enabled, the callback only triggers when the last of the User's connections leaves the Room.
+// When RoomDeleteOnLeave in ServerSettings deletes a Room because its owner left, the callback triggers for the owner before
+// the Room is deleted, then once for every other User in the Room with `core.LeaveReasonDeleted`, and then the
+// RoomType's delete callback runs.
+func SetRoomLeaveCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string, string, int)); ok {
+		core.RoomLeaveCallback = func(roomName string, userName string, reason int) {
+			helpers.Protect("room leave callback", func() { callback(roomName, userName, reason) }, "room", roomName, "user", userName)
+		}
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetRoomExpiredCallback sets the callback that triggers when a Room made by a User is deleted for being empty for EmptyRoomTTL
+// in ServerSettings. The function passed must have the same parameter types as the following example:
+//
+//    func roomExpired(roomName string, ownerName string) {
+//	     //code...
+//	 }
+//
+// The callback runs before the Room is deleted, and while it isn't locked, so you can still get its variables or save it.
+func SetRoomExpiredCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string, string)); ok {
+		core.RoomExpiredCallback = func(roomName string, ownerName string) {
+			helpers.Protect("room expired callback", func() { callback(roomName, ownerName) }, "room", roomName, "owner", ownerName)
+		}
 		return nil
 	}
 	return errors.New(ErrorIncorrectFunction)
@@ -160,13 +298,32 @@ func SetLogoutCallback(cb interface{}) error {
 // `userName` is the name of the User logging in, `clientColumns` is the input from the client for setting
 // custom `AccountInfoColumn`s on the database.
 //
+// With RequireEmailVerification in ServerSettings, use a function that also takes the account's verification token:
+//
+//    func clientSignedUp(userName string, clientColumns map[string]interface{}, verificationToken string) bool {
+//	     //code...
+//	 }
+//
+// Send the token to the User's email, and call `database.VerifyAccount()` with it when they follow your link. The callback
+// runs before the account is stored, so the token only works if the sign up succeeds. The token is an empty string
+// when RequireEmailVerification is disabled.
+//
 // The function returns a boolean. If false is returned, the client will receive a `helpers.ErrorActionDenied` (1052) error and will be
 // denied from signing up. This can be used to, for instance, deny user names or `AccountInfoColumn`s with profanity.
 func SetSignupCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string, map[string]interface{}, string) bool); ok {
+		database.SignUpCallback = func(userName string, clientColumns map[string]interface{}, token string) (allow bool) {
+			helpers.Protect("sign up callback", func() { allow = callback(userName, clientColumns, token) }, "user", userName)
+			return
+		}
+		return nil
 	} else if callback, ok := cb.(func(string, map[string]interface{}) bool); ok {
-		database.SignUpCallback = callback
+		database.SignUpCallback = func(userName string, clientColumns map[string]interface{}, token string) (allow bool) {
+			helpers.Protect("sign up callback", func() { allow = callback(userName, clientColumns) }, "user", userName)
+			return
+		}
 		return nil
 	}
 	return errors.New(ErrorIncorrectFunction)
@@ -192,7 +349,28 @@ func SetDeleteAccountCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func(string, int, map[string]interface{}, map[string]interface{}) bool); ok {
-		database.DeleteAccountCallback = callback
+		database.DeleteAccountCallback = protectAccountCallback("delete account callback", callback)
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetAccountPurgeCallback sets the callback that triggers once an account has been deleted, so you can delete your own data
+// tied to it. The function passed must have the same parameter types as the following example:
+//
+//    func accountPurged(databaseID int, userName string) {
+//	     //code...
+//	 }
+//
+// The account's friends, auto-logins and ban are already deleted with it in one transaction, and the callback only runs when
+// that worked. After the callback, the account's User is logged out on every connection with the reason "accountDeleted".
+func SetAccountPurgeCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(int, string)); ok {
+		database.AccountPurgeCallback = func(databaseID int, userName string) {
+			helpers.Protect("account purge callback", func() { callback(databaseID, userName) }, "user", userName)
+		}
 		return nil
 	}
 	return errors.New(ErrorIncorrectFunction)
@@ -218,7 +396,7 @@ func SetAccountInfoChangeCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func(string, int, map[string]interface{}, map[string]interface{}) bool); ok {
-		database.AccountInfoChangeCallback = callback
+		database.AccountInfoChangeCallback = protectAccountCallback("account info change callback", callback)
 		return nil
 	}
 	return errors.New(ErrorIncorrectFunction)
@@ -244,8 +422,127 @@ func SetPasswordChangeCallback(cb interface{}) error {
 	if serverStarted {
 		return errors.New(ErrorServerRunning)
 	} else if callback, ok := cb.(func(string, int, map[string]interface{}, map[string]interface{}) bool); ok {
-		database.PasswordChangeCallback = callback
+		database.PasswordChangeCallback = protectAccountCallback("password change callback", callback)
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetNameChangeCallback sets the callback that triggers when a User renames their account with the SQL features enabled. The
+// function passed must have the same parameter types as the following example:
+//
+//    func clientChangedName(oldName string, newName string, databaseID int) bool {
+//	     //code...
+//	 }
+//
+// The function returns a boolean. If false is returned, the client will receive a `helpers.ErrorActionDenied` (1052) error and
+// will keep their name. This can be used to, for instance, check new names for profanity.
+func SetNameChangeCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string, string, int) bool); ok {
+		database.NameChangeCallback = func(oldName string, newName string, databaseID int) (allow bool) {
+			helpers.Protect("name change callback", func() { allow = callback(oldName, newName, databaseID) }, "user", oldName)
+			return
+		}
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetDatabaseStateChangeCallback sets the callback that triggers when the server loses or regains its connection to the
+// database with the SQL features enabled. The function passed must have the same parameter types as the following example:
+//
+//    func databaseStateChanged(up bool) {
+//	     //code...
+//	 }
+//
+// `up` is false when the database could not be reached, and true once the server has reconnected. While the database is down,
+// clients get a `helpers.ErrorDatabaseUnavailable` (1057) error when they try to sign up, log in, or change their account.
+// You can also check the state any time with `database.Healthy()`.
+func SetDatabaseStateChangeCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(bool)); ok {
+		database.DatabaseStateChangeCallback = func(up bool) {
+			helpers.Protect("database state change callback", func() { callback(up) })
+		}
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetWriteFailedCallback sets the callback that triggers when a database write the server queued to run in the background
+// failed for good, with the SQL features enabled. A write that failed because the database couldn't be reached is retried
+// a few times first. The function passed must have the same parameter types as the following example:
+//
+//    func writeFailed(query database.Query, err error) {
+//	     //code...
+//	 }
+//
+// The write is already logged. You could use this to save it somewhere, and run it again when the database is back.
+func SetWriteFailedCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(database.Query, error)); ok {
+		database.WriteFailedCallback = func(query database.Query, err error) {
+			helpers.Protect("database write failed callback", func() { callback(query, err) })
+		}
 		return nil
 	}
 	return errors.New(ErrorIncorrectFunction)
 }
+
+// SetAutoLoginTheftCallback sets the callback that triggers when a device's auto-login data was used after it had already
+// been replaced, with the SQL features and RememberMe enabled. This means someone copied the device's auto-login data, so
+// the server stops the device from automatically logging in, and the User (or the thief) has to log in with a password
+// again. The function passed must have the same parameter types as the following example:
+//
+//    func autoLoginTheft(userName string, databaseID int) {
+//	     //code...
+//	 }
+//
+// You could use this to warn the User, or to revoke all of their devices with `database.RevokeAllDevices()`.
+func SetAutoLoginTheftCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string, int)); ok {
+		database.AutoLoginTheftCallback = func(userName string, databaseID int) {
+			helpers.Protect("auto-login theft callback", func() { callback(userName, databaseID) }, "user", userName)
+		}
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// SetAdminActionCallback sets the callback that triggers when an admin client sends an admin action, with EnableAdminTools
+// in ServerSettings. The function passed must have the same parameter types as the following example:
+//
+//    func adminAction(action string, data interface{}) bool {
+//	     //code...
+//	 }
+//
+// The action is one of the gopher.AdminAction constants, and the data is what the admin sent with it. The function returns
+// a boolean. If false is returned, the action isn't taken, and the admin receives a `helpers.ErrorActionDenied` error.
+func SetAdminActionCallback(cb interface{}) error {
+	if serverStarted {
+		return errors.New(ErrorServerRunning)
+	} else if callback, ok := cb.(func(string, interface{}) bool); ok {
+		adminActionCallback = func(action string, data interface{}) (allow bool) {
+			helpers.Protect("admin action callback", func() { allow = callback(action, data) }, "action", action)
+			return
+		}
+		return nil
+	}
+	return errors.New(ErrorIncorrectFunction)
+}
+
+// protectAccountCallback wraps a login or account callback, so a panic in it is reported and denies the action instead of
+// crashing the server.
+func protectAccountCallback(where string, callback func(string, int, map[string]interface{}, map[string]interface{}) bool) func(string,
+	int, map[string]interface{}, map[string]interface{}) bool {
+	return func(userName string, databaseID int, receivedColumns map[string]interface{}, clientColumns map[string]interface{}) (allow bool) {
+		helpers.Protect(where, func() { allow = callback(userName, databaseID, receivedColumns, clientColumns) }, "user", userName)
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package gopher
 
 import (
+	"context"
 	"github.com/gorilla/websocket"
 	"github.com/hewiefreeman/GopherGameServer/actions"
 	"github.com/hewiefreeman/GopherGameServer/core"
@@ -11,8 +12,11 @@ import (
 
 const (
 	errorInvalidAction               = "Invalid action"
+	errorServerPaused                = "Server is paused"
+	errorServerFull                  = "Server is full"
 	errorLoggedIn                    = "You must be logged out"
 	errorNotLoggedIn                 = "You must be logged in"
+	errorNotInRoom                   = "You must be in a room"
 	errorFeatureDisabled             = "Server feature not enabled"
 	errorRoomControl                 = "Clients cannot control rooms"
 	errorServerRoom                  = "Clients cannot control that room type"
@@ -26,21 +30,37 @@ const (
 	errorIncorrectFormatCols         = "Incorrect data format for custom columns"
 	errorIncorrectFormatRemember     = "Incorrect data format for remember me"
 	errorIncorrectFormatGuest        = "Incorrect data format for guest"
+	errorIncorrectFormatDevice       = "Incorrect data format for device"
 	errorIncorrectFormatRoomName     = "Incorrect data format for room name"
 	errorIncorrectFormatRoomType     = "Incorrect data format for room type"
 	errorIncorrectFormatPrivateRoom  = "Incorrect data format for private room"
 	errorIncorrectFormatMaxRoomUsers = "Incorrect data format for max room users"
 	errorIncorrectFormatVarKey       = "Incorrect data format for variable key"
+	errorIncorrectFormatLocale       = "Incorrect data format for locale"
+	errorTimedOut                    = "The action timed out"
+	errorActionFailed                = "The action failed"
+	errorGuestRoomControl            = "Guests cannot create rooms"
+	errorGuestFriending              = "Guests cannot make friends"
+	errorGuestVariables              = "Guests cannot set variables"
 )
 
-func clientActionHandler(action clientAction, user **core.User, conn *websocket.Conn,
+const (
+	defaultGuestNamePrefix = "Guest"
+	// HOW MANY GENERATED NAMES A GUEST LOGIN TRIES BEFORE GIVING UP
+	guestNameAttempts = 5
+	// HOW MANY ROOMS A ROOM LISTING SENDS AT MOST, AND WHEN THE CLIENT DOESN'T SAY
+	maxRoomListLimit     = 100
+	defaultRoomListLimit = 50
+)
+
+func clientActionHandler(ctx context.Context, action clientAction, user **core.User, conn *websocket.Conn,
 	deviceTag *string, devicePass *string, deviceUserID *int, connID *string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	switch action.A {
 
 	// Custom actions and voice streams
 
 	case helpers.ClientActionCustomAction:
-		return clientCustomAction(action.P, user, conn, *connID, clientMux)
+		return clientCustomAction(ctx, action.P, user, conn, *connID, clientMux)
 	case helpers.ClientActionVoiceStream:
 		return clientActionVoiceStream(action.P, user, conn, *connID, clientMux)
 
@@ -50,40 +70,83 @@ func clientActionHandler(action clientAction, user **core.User, conn *websocket.
 		return clientActionSetVariable(action.P, user, *connID, clientMux)
 	case helpers.ClientActionSetVariables:
 		return clientActionSetVariables(action.P, user, *connID, clientMux)
+	case helpers.ClientActionGetVariables:
+		return clientActionGetVariables(action.P, user, *connID, clientMux)
 
 	// Chat
 
+	case helpers.ClientActionChatHistory:
+		return clientActionChatHistory(action.P, user, *connID, clientMux)
 	case helpers.ClientActionChatMessage:
 		return clientActionChatMessage(action.P, user, *connID, clientMux)
 	case helpers.ClientActionPrivateMessage:
 		return clientActionPrivateMessage(action.P, user, *connID, clientMux)
 
+	// Server info
+
+	case helpers.ClientActionServerInfo:
+		return serverInfo(), true, helpers.NoError()
+
 	// Change user status
 
 	case helpers.ClientActionChangeStatus:
 		return clientActionChangeStatus(action.P, user, clientMux)
 
+	// Watch users
+
+	case helpers.ClientActionWatchUser:
+		return clientActionWatchUser(action.P, true, user, *connID, clientMux)
+	case helpers.ClientActionUnwatchUser:
+		return clientActionWatchUser(action.P, false, user, *connID, clientMux)
+
 	// Log in/out
 
 	case helpers.ClientActionLogin:
-		return clientActionLogin(action.P, user, deviceTag, devicePass, deviceUserID, conn, connID, clientMux)
+		return clientActionLogin(ctx, action.P, user, deviceTag, devicePass, deviceUserID, conn, connID, clientMux)
+	case helpers.ClientActionGuestLogin:
+		return clientActionGuestLogin(ctx, user, conn, connID, clientMux)
 	case helpers.ClientActionLogout:
 		return clientActionLogout(user, deviceTag, devicePass, deviceUserID, connID, clientMux)
 
 	// Room actions
 
 	case helpers.ClientActionJoinRoom:
-		return clientActionJoinRoom(action.P, user, *connID, clientMux)
+		return clientActionJoinRoom(ctx, action.P, false, user, *connID, clientMux)
+	case helpers.ClientActionSpectateRoom:
+		return clientActionJoinRoom(ctx, action.P, true, user, *connID, clientMux)
 	case helpers.ClientActionLeaveRoom:
 		return clientActionLeaveRoom(user, *connID, clientMux)
+	case helpers.ClientActionRoomUsers:
+		return clientActionRoomUsers(user, *connID, clientMux)
+	case helpers.ClientActionListRooms:
+		return clientActionListRooms(action.P, user, clientMux)
 	case helpers.ClientActionCreateRoom:
-		return clientActionCreateRoom(action.P, user, *connID, clientMux)
+		return clientActionCreateRoom(ctx, action.P, user, *connID, clientMux)
 	case helpers.ClientActionDeleteRoom:
 		return clientActionDeleteRoom(action.P, user, clientMux)
 	case helpers.ClientActionRoomInvite:
 		return clientActionRoomInvite(action.P, user, *connID, clientMux)
 	case helpers.ClientActionRevokeInvite:
 		return clientActionRevokeInvite(action.P, user, *connID, clientMux)
+	case helpers.ClientActionTransferOwner:
+		return clientActionTransferOwner(action.P, user, *connID, clientMux)
+	case helpers.ClientActionMuteUser:
+		return clientActionMuteUser(action.P, true, user, *connID, clientMux)
+	case helpers.ClientActionUnmuteUser:
+		return clientActionMuteUser(action.P, false, user, *connID, clientMux)
+	case helpers.ClientActionPromoteSpectator:
+		return clientActionPromoteSpectator(action.P, user, *connID, clientMux)
+	case helpers.ClientActionStateResync:
+		return clientActionStateResync(user, *connID, clientMux)
+	case helpers.ClientActionRoomUpdate:
+		return clientActionRoomUpdate(action.P, user, *connID, clientMux)
+
+	// Matchmaking
+
+	case helpers.ClientActionJoinQueue:
+		return clientActionQueue(action.P, true, user, *connID, clientMux)
+	case helpers.ClientActionLeaveQueue:
+		return clientActionQueue(action.P, false, user, *connID, clientMux)
 
 	// Friending
 
@@ -99,26 +162,76 @@ func clientActionHandler(action clientAction, user **core.User, conn *websocket.
 	// Database
 
 	case helpers.ClientActionSignup:
-		return clientActionSignup(action.P, user, clientMux)
+		return clientActionSignup(ctx, action.P, user, clientMux)
 	case helpers.ClientActionDeleteAccount:
-		return clientActionDeleteAccount(action.P, user, clientMux)
+		return clientActionDeleteAccount(ctx, action.P, user, clientMux)
 	case helpers.ClientActionChangePassword:
-		return clientActionChangePassword(action.P, user, clientMux)
+		return clientActionChangePassword(ctx, action.P, user, clientMux)
 	case helpers.ClientActionChangeAccountInfo:
-		return clientActionChangeAccountInfo(action.P, user, clientMux)
+		return clientActionChangeAccountInfo(ctx, action.P, user, clientMux)
+	case helpers.ClientActionChangeName:
+		return clientActionChangeName(ctx, action.P, user, clientMux)
+	case helpers.ClientActionGetDevices:
+		return clientActionGetDevices(user, *deviceTag, clientMux)
+	case helpers.ClientActionRevokeDevice:
+		return clientActionRevokeDevice(action.P, user, *deviceTag, *connID, clientMux)
+
+	// Admin tools
+
+	case helpers.ClientActionAdminLogin:
+		return clientActionAdminLogin(action.P, conn)
+	case helpers.ClientActionAdminAction:
+		return clientActionAdminAction(action.P, conn)
 
 	// Invalid client action
 
 	default:
+		if actions.Exists(action.A) {
+			return clientCustomActionByName(ctx, action.A, action.P, user, conn, *connID, clientMux)
+		}
 		return nil, true, helpers.NewError(errorInvalidAction, helpers.ErrorGopherInvalidAction)
 	}
 }
 
+// clientActionMiddleware runs a client action through the middleware added with actions.Use(). When a middleware stops the
+// action, the client gets its error.
+func clientActionMiddleware(ctx context.Context, action clientAction, user **core.User, conn *websocket.Conn,
+	deviceTag *string, devicePass *string, deviceUserID *int, connID *string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	if !actions.HasMiddleware() {
+		return clientActionHandler(ctx, action, user, conn, deviceTag, devicePass, deviceUserID, connID, clientMux)
+	}
+	(*clientMux).Lock()
+	userRef := *user
+	(*clientMux).Unlock()
+	// Custom actions in the built-in custom action message go through as themselves
+	name, params := action.A, action.P
+	if pMap, ok := action.P.(map[string]interface{}); ok && action.A == helpers.ClientActionCustomAction {
+		if customName, ok := pMap["a"].(string); ok {
+			name, params = customName, pMap["d"]
+		}
+	}
+	var responseVal interface{}
+	var respond, ran bool
+	var actionErr helpers.GopherError
+	mwErr := actions.RunMiddleware(userRef, name, params, func(*core.User, string, interface{}) error {
+		ran = true
+		responseVal, respond, actionErr = clientActionHandler(ctx, action, user, conn, deviceTag, devicePass, deviceUserID, connID, clientMux)
+		if actionErr.ID != 0 {
+			return actionErr
+		}
+		return nil
+	})
+	if !ran {
+		return nil, true, mwErr
+	}
+	return responseVal, respond, actionErr
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   CUSTOM CLIENT ACTIONS   /////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func clientCustomAction(params interface{}, user **core.User, conn *websocket.Conn, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+func clientCustomAction(ctx context.Context, params interface{}, user **core.User, conn *websocket.Conn, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	var ok bool
 	var pMap map[string]interface{}
 	var action string
@@ -131,7 +244,16 @@ func clientCustomAction(params interface{}, user **core.User, conn *websocket.Co
 	(*clientMux).Lock()
 	userRef := *user
 	(*clientMux).Unlock()
-	actions.HandleCustomClientAction(action, pMap["d"], userRef, conn, connID)
+	actions.HandleCustomClientActionCtx(ctx, action, pMap["d"], userRef, conn, connID)
+	return nil, false, helpers.NoError()
+}
+
+// clientCustomActionByName handles a CustomClientAction sent as its own action name, with the action data as the parameters
+func clientCustomActionByName(ctx context.Context, action string, params interface{}, user **core.User, conn *websocket.Conn, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	userRef := *user
+	(*clientMux).Unlock()
+	actions.HandleCustomClientActionCtx(ctx, action, params, userRef, conn, connID)
 	return nil, false, helpers.NoError()
 }
 
@@ -143,7 +265,7 @@ func clientActionChangeStatus(params interface{}, user **core.User, clientMux *s
 	(*clientMux).Lock()
 	if *user == nil {
 		(*clientMux).Unlock()
-		return nil, true, helpers.NewError(errorLoggedIn, helpers.ErrorGopherNotLoggedIn)
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
 	}
 	userRef := *user
 	(*clientMux).Unlock()
@@ -155,16 +277,54 @@ func clientActionChangeStatus(params interface{}, user **core.User, clientMux *s
 	}
 	status := int(statusF)
 	//
-	userRef.SetStatus(status)
+	if statusErr := userRef.SetStatus(status); statusErr != nil {
+		return nil, true, helpers.ErrorFrom(statusErr, helpers.ErrorGopherStatusChange)
+	}
 	//
 	return status, true, helpers.NoError()
 }
 
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   WATCH USERS   ///////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func clientActionWatchUser(params interface{}, watch bool, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get the User to watch
+	userName, ok := params.(string)
+	if !ok || len(userName) == 0 {
+		return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+	}
+	if !watch {
+		if unwatchErr := userRef.UnwatchUser(userName, connID); unwatchErr != nil {
+			return nil, true, helpers.ErrorFrom(unwatchErr, helpers.ErrorActionDenied)
+		}
+		return userName, true, helpers.NoError()
+	}
+	if watchErr := userRef.WatchUser(userName, connID); watchErr != nil {
+		return nil, true, helpers.ErrorFrom(watchErr, helpers.ErrorActionDenied)
+	}
+	// Respond with their presence right now
+	presence := core.UserPresence(userName)
+	return map[string]interface{}{
+		"n": presence.User,
+		"o": presence.Type == core.PresenceLogin,
+		"s": presence.Status,
+		"r": presence.Room,
+	}, true, helpers.NoError()
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   ACCOUNT/DATABASE ACTIONS   //////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func clientActionSignup(params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+func clientActionSignup(ctx context.Context, params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
 	if *user != nil {
 		(*clientMux).Unlock()
@@ -195,7 +355,7 @@ func clientActionSignup(params interface{}, user **core.User, clientMux *sync.Mu
 		return nil, true, helpers.NewError(errorIncorrectFormatPass, helpers.ErrorGopherPasswordFormat)
 	}
 	// Sign client up
-	signupErr := database.SignUpClient(userName, pass, customCols)
+	signupErr := database.SignUpClientCtx(ctx, userName, pass, customCols)
 	if signupErr.ID != 0 {
 		return nil, true, signupErr
 	}
@@ -204,7 +364,7 @@ func clientActionSignup(params interface{}, user **core.User, clientMux *sync.Mu
 	return nil, true, helpers.NoError()
 }
 
-func clientActionDeleteAccount(params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+func clientActionDeleteAccount(ctx context.Context, params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
 	if *user != nil {
 		(*clientMux).Unlock()
@@ -235,22 +395,19 @@ func clientActionDeleteAccount(params interface{}, user **core.User, clientMux *
 		return nil, true, helpers.NewError(errorIncorrectFormatPass, helpers.ErrorGopherPasswordFormat)
 	}
 
-	// Check if online
-	_, err := core.GetUser(userName)
-	if err == nil {
-		return nil, true, helpers.NewError(err.Error(), helpers.ErrorGopherLoggedIn)
-	}
-
 	// Delete account
-	deleteErr := database.DeleteAccount(userName, pass, customCols)
+	deleteErr := database.DeleteAccountCtx(ctx, userName, pass, customCols)
 	if deleteErr.ID != 0 {
 		return nil, true, deleteErr
 	}
+
+	// Log out the account's sessions on other devices
+	core.AccountDeleted(userName)
 	//
 	return nil, true, helpers.NoError()
 }
 
-func clientActionChangePassword(params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+func clientActionChangePassword(ctx context.Context, params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
 	if *user == nil {
 		(*clientMux).Unlock()
@@ -282,7 +439,7 @@ func clientActionChangePassword(params interface{}, user **core.User, clientMux
 		return nil, true, helpers.NewError(errorIncorrectFormatNewPass, helpers.ErrorGopherNewPasswordFormat)
 	}
 	// Change password
-	changeErr := database.ChangePassword(userRef.Name(), pass, newPass, customCols)
+	changeErr := database.ChangePasswordCtx(ctx, userRef.Name(), pass, newPass, customCols)
 	if changeErr.ID != 0 {
 		return nil, true, changeErr
 	}
@@ -291,7 +448,41 @@ func clientActionChangePassword(params interface{}, user **core.User, clientMux
 	return nil, true, helpers.NoError()
 }
 
-func clientActionChangeAccountInfo(params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+func clientActionChangeName(ctx context.Context, params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	} else if !(*settings).EnableSqlFeatures {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get param map and extract values
+	var ok bool
+	var pMap map[string]interface{}
+	var pass string
+	var newName string
+	if pMap, ok = params.(map[string]interface{}); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+	}
+	if pass, ok = pMap["p"].(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormatPass, helpers.ErrorGopherPasswordFormat)
+	}
+	if newName, ok = pMap["n"].(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormatName, helpers.ErrorGopherNameFormat)
+	}
+	// Change name
+	if changeErr := userRef.ChangeAccountNameCtx(ctx, newName, pass); changeErr.ID != 0 {
+		return nil, true, changeErr
+	}
+
+	//
+	return newName, true, helpers.NoError()
+}
+
+func clientActionChangeAccountInfo(ctx context.Context, params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
 	if *user == nil {
 		(*clientMux).Unlock()
@@ -319,7 +510,7 @@ func clientActionChangeAccountInfo(params interface{}, user **core.User, clientM
 		return nil, true, helpers.NewError(errorIncorrectFormatPass, helpers.ErrorGopherPasswordFormat)
 	}
 	// Change account info
-	changeErr := database.ChangeAccountInfo(userRef.Name(), pass, customCols)
+	changeErr := database.ChangeAccountInfoCtx(ctx, userRef.Name(), pass, customCols)
 	if changeErr.ID != 0 {
 		return nil, true, changeErr
 	}
@@ -328,12 +519,104 @@ func clientActionChangeAccountInfo(params interface{}, user **core.User, clientM
 	return nil, true, helpers.NoError()
 }
 
+func clientActionGetDevices(user **core.User, deviceTag string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	} else if !(*settings).EnableSqlFeatures || !(*settings).RememberMe {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get devices
+	devices, err := database.GetDevices(userRef.DatabaseID())
+	if err != nil {
+		return nil, true, helpers.ErrorFrom(err, helpers.ErrorAuthQuery)
+	}
+	// Make response, marking the device the client is on
+	thisDevice := database.DeviceTokenID(deviceTag)
+	devicesResp := make([]map[string]interface{}, 0, len(devices))
+	for _, device := range devices {
+		var created, lastUsed int64
+		if !device.Created.IsZero() {
+			created = device.Created.Unix()
+		}
+		if !device.LastUsed.IsZero() {
+			lastUsed = device.LastUsed.Unix()
+		}
+		devicesResp = append(devicesResp, map[string]interface{}{
+			"i": device.ID,
+			"c": created,
+			"u": lastUsed,
+			"t": device.ID == thisDevice,
+		})
+	}
+
+	//
+	return devicesResp, true, helpers.NoError()
+}
+
+// clientActionRevokeDevice revokes the device with the ID sent, or every other device and connection when no ID is sent
+func clientActionRevokeDevice(params interface{}, user **core.User, deviceTag string, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	} else if !(*settings).EnableSqlFeatures || !(*settings).RememberMe {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Revoke one device
+	if params != nil {
+		deviceID, ok := params.(string)
+		if !ok {
+			return nil, true, helpers.NewError(errorIncorrectFormatDevice, helpers.ErrorGopherIncorrectFormat)
+		}
+		if err := database.RevokeDevice(userRef.DatabaseID(), deviceID); err != nil {
+			return nil, true, helpers.ErrorFrom(err, helpers.ErrorGopherRevokeDevice)
+		}
+		return nil, true, helpers.NoError()
+	}
+	// Revoke all other devices
+	devices, err := database.GetDevices(userRef.DatabaseID())
+	if err != nil {
+		return nil, true, helpers.ErrorFrom(err, helpers.ErrorGopherRevokeDevice)
+	}
+	thisDevice := database.DeviceTokenID(deviceTag)
+	for _, device := range devices {
+		if device.ID == thisDevice {
+			continue
+		}
+		if err := database.RevokeDevice(userRef.DatabaseID(), device.ID); err != nil {
+			return nil, true, helpers.ErrorFrom(err, helpers.ErrorGopherRevokeDevice)
+		}
+	}
+	// Log out the User's other connections
+	if (*settings).MultiConnect {
+		for _, id := range userRef.ConnectionIDs() {
+			if id != connID {
+				userRef.Logout(id)
+			}
+		}
+	}
+
+	//
+	return nil, true, helpers.NoError()
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   LOGIN+LOGOUT ACTIONS   //////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func clientActionLogin(params interface{}, user **core.User, deviceTag *string, devicePass *string, deviceUserID *int, conn *websocket.Conn,
+func clientActionLogin(ctx context.Context, params interface{}, user **core.User, deviceTag *string, devicePass *string, deviceUserID *int, conn *websocket.Conn,
 	connID *string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	if isPaused() {
+		return nil, true, helpers.NewError(errorServerPaused, helpers.ErrorServerPaused)
+	}
 	(*clientMux).Lock()
 	if *user != nil {
 		(*clientMux).Unlock()
@@ -369,6 +652,17 @@ func clientActionLogin(params interface{}, user **core.User, deviceTag *string,
 			return nil, true, helpers.NewError(errorIncorrectFormatGuest, helpers.ErrorGopherGuestFormat)
 		}
 	}
+	if guest && !(*settings).AllowGuests {
+		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+	}
+	// The client can send its locale when it logs in, instead of when it connects
+	if pMap["l"] != nil {
+		locale, ok := pMap["l"].(string)
+		if !ok || helpers.NormalizeLocale(locale) == "" {
+			return nil, true, helpers.NewError(errorIncorrectFormatLocale, helpers.ErrorGopherIncorrectFormat)
+		}
+		core.SetConnLocale(conn, locale)
+	}
 	if pMap["c"] != nil {
 		if customCols, ok = pMap["c"].(map[string]interface{}); !ok {
 			return nil, true, helpers.NewError(errorIncorrectFormatCols, helpers.ErrorGopherColumnsFormat)
@@ -379,9 +673,9 @@ func clientActionLogin(params interface{}, user **core.User, deviceTag *string,
 	var dPass string
 	var cID string
 	var err helpers.GopherError
-	if dbIndex, dPass, cID, err = loginClient(settings, guest, name, pass, *deviceTag, remMe, customCols, user,
+	if dbIndex, dPass, cID, err = loginClient(ctx, settings, guest, name, pass, *deviceTag, remMe, customCols, user,
 							conn, clientMux); err.ID != 0 {
-		return nil, false, err
+		return nil, true, err
 	}
 
 	// Update socket
@@ -393,7 +687,7 @@ func clientActionLogin(params interface{}, user **core.User, deviceTag *string,
 	return nil, false, helpers.NoError()
 }
 
-func loginClient(s *ServerSettings, guest bool, name string, pass string, deviceTag string, remMe bool,
+func loginClient(ctx context.Context, s *ServerSettings, guest bool, name string, pass string, deviceTag string, remMe bool,
 		customCols map[string]interface{}, user **core.User, conn *websocket.Conn, clientMux *sync.Mutex) (int, string, string, helpers.GopherError) {
 	var dbIndex int
 	var dPass string
@@ -401,18 +695,55 @@ func loginClient(s *ServerSettings, guest bool, name string, pass string, device
 	var err helpers.GopherError
 	if s.EnableSqlFeatures && !guest {
 		var uName string
-		uName, dbIndex, dPass, err = database.LoginClient(name, pass, deviceTag, remMe, customCols)
+		uName, dbIndex, dPass, err = database.LoginClientCtx(ctx, name, pass, deviceTag, remMe, customCols)
 		if err.ID != 0 {
+			core.LoginFailed(name, conn, guest, err)
 			return 0, "", "", err
 		}
-		cID, err = core.Login(uName, dbIndex, dPass, guest, remMe, conn, user, clientMux)
+		cID, err = core.LoginCtx(ctx, uName, dbIndex, dPass, guest, remMe, conn, user, clientMux)
 	} else {
-		cID, err = core.Login(name, -1, "", guest, false, conn, user, clientMux)
+		cID, err = core.LoginCtx(ctx, name, -1, "", guest, false, conn, user, clientMux)
 	}
 
 	return dbIndex, dPass, cID, err
 }
 
+func clientActionGuestLogin(ctx context.Context, user **core.User, conn *websocket.Conn, connID *string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	if !(*settings).AllowGuests {
+		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+	} else if isPaused() {
+		return nil, true, helpers.NewError(errorServerPaused, helpers.ErrorServerPaused)
+	}
+	(*clientMux).Lock()
+	if *user != nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorLoggedIn, helpers.ErrorGopherLoggedIn)
+	}
+	(*clientMux).Unlock()
+	prefix := (*settings).GuestNamePrefix
+	if prefix == "" {
+		prefix = defaultGuestNamePrefix
+	}
+	// Log in with a generated name. Another login can take the name first, or it can belong to an account, so try again with the next one
+	var cID string
+	var err helpers.GopherError
+	for i := 0; i < guestNameAttempts; i++ {
+		cID, err = core.LoginCtx(ctx, core.GuestName(prefix), -1, "", true, false, conn, user, clientMux)
+		if err.ID != helpers.ErrorAuthAlreadyLogged && err.ID != helpers.ErrorAuthNameUnavail {
+			break
+		}
+	}
+	if err.ID != 0 {
+		return nil, true, err
+	}
+
+	// Update socket
+	*connID = cID
+
+	//
+	return nil, false, helpers.NoError()
+}
+
 func clientActionLogout(user **core.User, deviceTag *string, devicePass *string, deviceUserID *int, connID *string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
 	if *user == nil {
@@ -440,7 +771,10 @@ func clientActionLogout(user **core.User, deviceTag *string, devicePass *string,
 //   ROOM ACTIONS   //////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func clientActionJoinRoom(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+func clientActionJoinRoom(ctx context.Context, params interface{}, spectate bool, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	if isPaused() {
+		return nil, true, helpers.NewError(errorServerPaused, helpers.ErrorServerPaused)
+	}
 	(*clientMux).Lock()
 	if *user == nil {
 		(*clientMux).Unlock()
@@ -454,15 +788,31 @@ func clientActionJoinRoom(params interface{}, user **core.User, connID string, c
 	if roomName, ok = params.(string); !ok {
 		return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
 	}
-	// Get room
-	room, roomErr := core.GetRoom(roomName)
+	// Get room. Send the client to the node it's on when it's on another one
+	room, roomErr := core.GetRoomCtx(ctx, roomName)
 	if roomErr != nil {
-		return nil, true, helpers.NewError(roomErr.Error(), helpers.ErrorGopherJoin)
+		if node, address, onNode := core.RoomNode(roomName); onNode && node != core.NodeName() {
+			userRef.SendToConnection(connID, map[string]map[string]interface{}{
+				helpers.ServerActionRoomRedirect: {
+					"r": roomName,
+					"a": address,
+				},
+			})
+			return nil, true, helpers.NewError("The room '"+roomName+"' is on another server", helpers.ErrorRoomRedirect)
+		}
+		return nil, true, helpers.ErrorFrom(roomErr, helpers.ErrorGopherJoin)
+	}
+	// Make user join or spectate the room. Joining the room they're already in just sends the response again
+	var joinErr error
+	if !spectate && userRef.RoomIn(connID) == room {
+		return room.Name(), true, helpers.NoError()
+	} else if spectate {
+		joinErr = userRef.SpectateCtx(ctx, room, connID)
+	} else {
+		joinErr = userRef.JoinCtx(ctx, room, connID)
 	}
-	// Make user join the room
-	joinErr := userRef.Join(room, connID)
 	if joinErr != nil {
-		return nil, true, helpers.NewError(joinErr.Error(), helpers.ErrorGopherJoin)
+		return nil, true, helpers.ErrorFrom(joinErr, helpers.ErrorGopherJoin)
 	}
 
 	//
@@ -480,14 +830,145 @@ func clientActionLeaveRoom(user **core.User, connID string, clientMux *sync.Mute
 	// Make user leave room
 	leaveErr := userRef.Leave(connID)
 	if leaveErr != nil {
-		return nil, true, helpers.NewError(leaveErr.Error(), helpers.ErrorGopherLeave)
+		return nil, true, helpers.ErrorFrom(leaveErr, helpers.ErrorGopherLeave)
 	}
 
 	//
 	return nil, false, helpers.NoError()
 }
 
-func clientActionCreateRoom(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+func clientActionRoomUsers(user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get the user's room
+	room := userRef.RoomIn(connID)
+	if room == nil {
+		return nil, true, helpers.NewError(errorNotInRoom, helpers.ErrorNotInRoom)
+	}
+	roomUsers, usersErr := room.GetUsers()
+	if usersErr != nil {
+		return nil, true, helpers.ErrorFrom(usersErr, helpers.ErrorNotInRoom)
+	}
+	// Make the list
+	list := make([]map[string]interface{}, len(roomUsers))
+	for i, roomUser := range roomUsers {
+		list[i] = map[string]interface{}{
+			"n":  roomUser.Name(),
+			"g":  roomUser.IsGuest(),
+			"s":  roomUser.Status(),
+			"sp": roomUser.IsSpectator(),
+		}
+	}
+
+	//
+	return list, true, helpers.NoError()
+}
+
+func clientActionListRooms(params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	var viewer string
+	if *user != nil {
+		viewer = (*user).Name()
+	}
+	(*clientMux).Unlock()
+	// Get param map and extract values
+	filter := core.RoomFilter{Viewer: viewer, Listed: true}
+	offset, limit := 0, defaultRoomListLimit
+	if params != nil {
+		pMap, ok := params.(map[string]interface{})
+		if !ok {
+			return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+		}
+		typeOK, fullOK, prefixOK, offsetOK, limitOK := true, true, true, true, true
+		if pMap["t"] != nil {
+			filter.Type, typeOK = pMap["t"].(string)
+		}
+		if pMap["f"] != nil {
+			filter.NotFull, fullOK = pMap["f"].(bool)
+		}
+		if pMap["p"] != nil {
+			filter.NamePrefix, prefixOK = pMap["p"].(string)
+		}
+		if o, isNum := pMap["o"].(float64); isNum {
+			offset = int(o)
+		} else {
+			offsetOK = pMap["o"] == nil
+		}
+		if l, isNum := pMap["l"].(float64); isNum {
+			limit = int(l)
+		} else {
+			limitOK = pMap["l"] == nil
+		}
+		hasVars, hasOK := stringList(pMap["h"])
+		vars, varsOK := stringList(pMap["v"])
+		if !typeOK || !fullOK || !prefixOK || !offsetOK || !limitOK || !hasOK || !varsOK {
+			return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+		}
+		filter.HasVariables = hasVars
+		filter.Variables = vars
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if limit <= 0 || limit > maxRoomListLimit {
+		limit = maxRoomListLimit
+	}
+	// Get the page of rooms
+	summaries := core.FindRooms(filter)
+	total := len(summaries)
+	if offset > total {
+		offset = total
+	}
+	if offset+limit < total {
+		summaries = summaries[offset : offset+limit]
+	} else {
+		summaries = summaries[offset:]
+	}
+	list := make([]map[string]interface{}, len(summaries))
+	for i, summary := range summaries {
+		list[i] = map[string]interface{}{
+			"n": summary.Name,
+			"t": summary.Type,
+			"o": summary.Owner,
+			"p": summary.Private,
+			"u": summary.Users,
+			"s": summary.Spectators,
+			"m": summary.MaxUsers,
+			"v": summary.Variables,
+		}
+	}
+
+	//
+	return map[string]interface{}{"r": list, "c": total}, true, helpers.NoError()
+}
+
+// stringList gets a []string from a list in a client action's parameters. A missing list is an empty one.
+func stringList(param interface{}) ([]string, bool) {
+	if param == nil {
+		return nil, true
+	}
+	items, ok := param.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	list := make([]string, len(items))
+	for i, item := range items {
+		if list[i], ok = item.(string); !ok {
+			return nil, false
+		}
+	}
+	return list, true
+}
+
+func clientActionCreateRoom(ctx context.Context, params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	if isPaused() {
+		return nil, true, helpers.NewError(errorServerPaused, helpers.ErrorServerPaused)
+	}
 	(*clientMux).Lock()
 	if *user == nil {
 		(*clientMux).Unlock()
@@ -495,6 +976,9 @@ func clientActionCreateRoom(params interface{}, user **core.User, connID string,
 	} else if !(*settings).UserRoomControl {
 		(*clientMux).Unlock()
 		return nil, true, helpers.NewError(errorRoomControl, helpers.ErrorGopherRoomControl)
+	} else if (*user).IsGuest() && !(*settings).GuestRoomControl {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorGuestRoomControl, helpers.ErrorGuestRestricted)
 	}
 	userRef := *user
 	(*clientMux).Unlock()
@@ -514,34 +998,86 @@ func clientActionCreateRoom(params interface{}, user **core.User, connID string,
 	if roomType, ok = pMap["t"].(string); !ok {
 		return nil, true, helpers.NewError(errorIncorrectFormatRoomType, helpers.ErrorGopherRoomTypeFormat)
 	}
-	if private, ok = pMap["t"].(bool); !ok {
+	if private, ok = pMap["p"].(bool); !ok {
 		return nil, true, helpers.NewError(errorIncorrectFormatPrivateRoom, helpers.ErrorGopherPrivateFormat)
 	}
 	if maxUsersF, ok = pMap["m"].(float64); !ok {
 		return nil, true, helpers.NewError(errorIncorrectFormatMaxRoomUsers, helpers.ErrorGopherMaxRoomFormat)
 	}
+	spectate := true
+	if pMap["s"] != nil {
+		if spectate, ok = pMap["s"].(bool); !ok {
+			return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+		}
+	}
 	maxUsers := int(maxUsersF)
+	if maxUsers < 0 {
+		maxUsers = 0
+	}
+	if ceiling := (*settings).UserRoomMaxUsers; ceiling > 0 && (maxUsers == 0 || maxUsers > ceiling) {
+		maxUsers = ceiling
+	}
 	// Verify type
 	if rType, ok := core.GetRoomTypes()[roomType]; !ok {
-		return nil, true, helpers.NewError(errorRoomType, helpers.ErrorGopherMaxRoomFormat)
+		return nil, true, helpers.NewError(errorRoomType, helpers.ErrorRoomTypeInvalid)
 	} else if rType.ServerOnly() {
 		return nil, true, helpers.NewError(errorServerRoom, helpers.ErrorGopherServerRoom)
+	} else if rType.MaxUsers() > 0 && (maxUsers == 0 || maxUsers > rType.MaxUsers()) {
+		maxUsers = rType.MaxUsers()
 	}
 	// Make the room
 	room, roomErr := core.NewRoom(roomName, roomType, private, maxUsers, userRef.Name())
 	if roomErr != nil {
-		return nil, true, helpers.NewError(roomErr.Error(), helpers.ErrorGopherCreateRoom)
+		return nil, true, helpers.ErrorFrom(roomErr, helpers.ErrorGopherCreateRoom)
+	}
+	// The owner can keep spectators out of a room type that allows them
+	if !spectate {
+		room.SetSpectatorsAllowed(false)
 	}
 	// Add user to the new room
-	joinErr := userRef.Join(room, connID)
+	joinErr := userRef.JoinCtx(ctx, room, connID)
 	if joinErr != nil {
-		return nil, true, helpers.NewError(joinErr.Error(), helpers.ErrorGopherJoin)
+		return nil, true, helpers.ErrorFrom(joinErr, helpers.ErrorGopherJoin)
 	}
 
 	//
 	return roomName, true, helpers.NoError()
 }
 
+func clientActionTransferOwner(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	} else if !(*settings).UserRoomControl {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorRoomControl, helpers.ErrorGopherRoomControl)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get new owner's name from params
+	var ok bool
+	var name string
+	if name, ok = params.(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormatName, helpers.ErrorGopherNameFormat)
+	}
+	// Get the user's room
+	room := userRef.RoomIn(connID)
+	if room == nil {
+		return nil, true, helpers.NewError(errorNotInRoom, helpers.ErrorNotInRoom)
+	} else if room.Owner() != userRef.Name() {
+		return nil, true, helpers.NewError(errorNotOwner, helpers.ErrorGopherNotOwner)
+	} else if core.GetRoomTypes()[room.Type()].ServerOnly() {
+		return nil, true, helpers.NewError(errorServerRoom, helpers.ErrorGopherServerRoom)
+	}
+	// Transfer ownership
+	if transferErr := room.TransferOwner(name); transferErr != nil {
+		return nil, true, helpers.ErrorFrom(transferErr, helpers.ErrorTransferOwner)
+	}
+	//
+	return name, true, helpers.NoError()
+}
+
 func clientActionDeleteRoom(params interface{}, user **core.User, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
 	if *user == nil {
@@ -562,7 +1098,7 @@ func clientActionDeleteRoom(params interface{}, user **core.User, clientMux *syn
 	// Get room
 	room, roomErr := core.GetRoom(roomName)
 	if roomErr != nil {
-		return nil, true, helpers.NewError(roomErr.Error(), helpers.ErrorGopherDeleteRoom)
+		return nil, true, helpers.ErrorFrom(roomErr, helpers.ErrorGopherDeleteRoom)
 	} else if room.Owner() != userRef.Name() {
 		return nil, true, helpers.NewError(errorNotOwner, helpers.ErrorGopherNotOwner)
 	}
@@ -574,7 +1110,7 @@ func clientActionDeleteRoom(params interface{}, user **core.User, clientMux *syn
 	// Delete the room
 	deleteErr := room.Delete()
 	if deleteErr != nil {
-		return nil, true, helpers.NewError(deleteErr.Error(), helpers.ErrorGopherDeleteRoom)
+		return nil, true, helpers.ErrorFrom(deleteErr, helpers.ErrorGopherDeleteRoom)
 	}
 
 	return roomName, true, helpers.NoError()
@@ -600,12 +1136,12 @@ func clientActionRoomInvite(params interface{}, user **core.User, connID string,
 	// Get invited user
 	invUser, invUserErr := core.GetUser(name)
 	if invUserErr != nil {
-		return nil, true, helpers.NewError(invUserErr.Error(), helpers.ErrorGopherInvite)
+		return nil, true, helpers.ErrorFrom(invUserErr, helpers.ErrorGopherInvite)
 	}
 	// Invite user
 	invUserErr = userRef.Invite(invUser, connID)
 	if invUserErr != nil {
-		return nil, true, helpers.NewError(invUserErr.Error(), helpers.ErrorGopherInvite)
+		return nil, true, helpers.ErrorFrom(invUserErr, helpers.ErrorGopherInvite)
 	}
 	//
 	return nil, true, helpers.NoError()
@@ -622,16 +1158,22 @@ func clientActionRevokeInvite(params interface{}, user **core.User, connID strin
 	}
 	userRef := *user
 	(*clientMux).Unlock()
-	// Get name from params
+	// Get name from params, with an optional kick flag
 	var ok bool
 	var name string
-	if name, ok = params.(string); !ok {
+	var kick bool
+	if pMap, isMap := params.(map[string]interface{}); isMap {
+		if name, ok = pMap["n"].(string); !ok {
+			return nil, true, helpers.NewError(errorIncorrectFormatName, helpers.ErrorGopherNameFormat)
+		}
+		kick, _ = pMap["k"].(bool)
+	} else if name, ok = params.(string); !ok {
 		return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
 	}
 	// Revoke invite
-	revokeErr := userRef.RevokeInvite(name, connID)
+	revokeErr := userRef.RevokeInvite(name, kick, connID)
 	if revokeErr != nil {
-		return nil, true, helpers.NewError(revokeErr.Error(), helpers.ErrorGopherRevokeInvite)
+		return nil, true, helpers.ErrorFrom(revokeErr, helpers.ErrorGopherRevokeInvite)
 	}
 	//
 	return nil, true, helpers.NoError()
@@ -655,8 +1197,7 @@ func clientActionVoiceStream(params interface{}, user **core.User, conn *websock
 		return nil, false, helpers.NoError()
 	}
 	// Check for voice chat
-	rType := core.GetRoomTypes()[currRoom.Type()]
-	if !rType.VoiceChatEnabled() {
+	if !currRoom.VoiceChatEnabled() || currRoom.IsMuted(userRef.Name()) || userRef.IsSpectating(connID) {
 		return nil, false, helpers.NoError()
 	}
 	// Send voice stream
@@ -665,6 +1206,91 @@ func clientActionVoiceStream(params interface{}, user **core.User, conn *websock
 	return nil, false, helpers.NoError()
 }
 
+func clientActionMuteUser(params interface{}, mute bool, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get the name from params
+	var ok bool
+	var name string
+	if name, ok = params.(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormatName, helpers.ErrorGopherNameFormat)
+	}
+	// Only the room's owner can mute
+	room := userRef.RoomIn(connID)
+	if room == nil {
+		return nil, true, helpers.NewError(errorNotInRoom, helpers.ErrorNotInRoom)
+	} else if room.Owner() != userRef.Name() {
+		return nil, true, helpers.NewError(errorNotOwner, helpers.ErrorGopherNotOwner)
+	}
+	// Mute or unmute
+	var muteErr error
+	if mute {
+		muteErr = room.MuteUser(name)
+	} else {
+		muteErr = room.UnmuteUser(name)
+	}
+	if muteErr != nil {
+		return nil, true, helpers.ErrorFrom(muteErr, helpers.ErrorMuteUser)
+	}
+	//
+	return name, true, helpers.NoError()
+}
+
+func clientActionPromoteSpectator(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get the spectator's name from params
+	var ok bool
+	var name string
+	if name, ok = params.(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormatName, helpers.ErrorGopherNameFormat)
+	}
+	// Only the room's owner can promote
+	room := userRef.RoomIn(connID)
+	if room == nil {
+		return nil, true, helpers.NewError(errorNotInRoom, helpers.ErrorNotInRoom)
+	} else if room.Owner() != userRef.Name() {
+		return nil, true, helpers.NewError(errorNotOwner, helpers.ErrorGopherNotOwner)
+	}
+	// Promote the spectator
+	if promoteErr := room.PromoteSpectator(name); promoteErr != nil {
+		return nil, true, helpers.ErrorFrom(promoteErr, helpers.ErrorPromote)
+	}
+	//
+	return name, true, helpers.NoError()
+}
+
+func clientActionStateResync(user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get the user's room
+	room := userRef.RoomIn(connID)
+	if room == nil {
+		return nil, true, helpers.NewError(errorNotInRoom, helpers.ErrorNotInRoom)
+	}
+	// The snapshot is the response
+	if syncErr := room.SendSyncedState(userRef, connID); syncErr != nil {
+		return nil, true, helpers.ErrorFrom(syncErr, helpers.ErrorNotInRoom)
+	}
+	//
+	return nil, false, helpers.NoError()
+}
+
 func clientActionChatMessage(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
 	if *user == nil {
@@ -678,12 +1304,66 @@ func clientActionChatMessage(params interface{}, user **core.User, connID string
 	if currRoom == nil || currRoom.Name() == "" {
 		return nil, false, helpers.NoError()
 	}
-	// Send chat message
-	currRoom.ChatMessage(userRef.Name(), params)
+	// Send chat message. Only tell the client when they're sending too fast
+	if chatErr := currRoom.ChatMessage(userRef.Name(), params); chatErr == core.ErrBroadcastLimited {
+		return nil, true, helpers.ErrorFrom(chatErr, helpers.ErrorBroadcastLimited)
+	}
 	//
 	return nil, false, helpers.NoError()
 }
 
+func clientActionRoomUpdate(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Check params
+	values, ok := params.(map[string]interface{})
+	if !ok || len(values) == 0 {
+		return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+	}
+	// Get current room
+	currRoom := userRef.RoomIn(connID)
+	if currRoom == nil {
+		return nil, true, helpers.NewError(errorNotInRoom, helpers.ErrorNotInRoom)
+	}
+	// Send the update
+	if updateErr := currRoom.SendUpdate(userRef.Name(), values); updateErr != nil {
+		return nil, true, helpers.ErrorFrom(updateErr, helpers.ErrorNotInRoom)
+	}
+	//
+	return nil, false, helpers.NoError()
+}
+
+func clientActionChatHistory(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get the amount of messages. No amount gets all of them
+	var count int
+	if params != nil {
+		countF, ok := params.(float64)
+		if !ok {
+			return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+		}
+		count = int(countF)
+	}
+	// Get current room
+	currRoom := userRef.RoomIn(connID)
+	if currRoom == nil || currRoom.Name() == "" {
+		return nil, true, helpers.NewError(errorNotInRoom, helpers.ErrorNotInRoom)
+	}
+	//
+	return core.MakeChatHistoryResponse(currRoom.GetChatHistory(count)), true, helpers.NoError()
+}
+
 func clientActionPrivateMessage(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
 	if *user == nil {
@@ -714,7 +1394,7 @@ func clientActionPrivateMessage(params interface{}, user **core.User, connID str
 
 func clientActionSetVariable(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
-	if *user == nil {
+	if *user == nil || ((*user).IsGuest() && !(*settings).GuestVariables) {
 		(*clientMux).Unlock()
 		return nil, false, helpers.NoError()
 	}
@@ -740,7 +1420,7 @@ func clientActionSetVariable(params interface{}, user **core.User, connID string
 
 func clientActionSetVariables(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
 	(*clientMux).Lock()
-	if *user == nil {
+	if *user == nil || ((*user).IsGuest() && !(*settings).GuestVariables) {
 		(*clientMux).Unlock()
 		return nil, false, helpers.NoError()
 	}
@@ -758,6 +1438,33 @@ func clientActionSetVariables(params interface{}, user **core.User, connID strin
 	return nil, false, helpers.NoError()
 }
 
+func clientActionGetVariables(params interface{}, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get the keys from params. No keys gets all variables
+	var keys []string
+	if params != nil {
+		var ok bool
+		var pKeys []interface{}
+		if pKeys, ok = params.([]interface{}); !ok {
+			return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+		}
+		keys = make([]string, len(pKeys))
+		for i := 0; i < len(pKeys); i++ {
+			if keys[i], ok = pKeys[i].(string); !ok {
+				return nil, true, helpers.NewError(errorIncorrectFormatVarKey, helpers.ErrorGopherIncorrectFormat)
+			}
+		}
+	}
+	//
+	return userRef.GetVariables(keys, connID), true, helpers.NoError()
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   FRIENDING ACTIONS   /////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -767,9 +1474,9 @@ func clientActionFriendRequest(params interface{}, user **core.User, clientMux *
 	if *user == nil {
 		(*clientMux).Unlock()
 		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
-	} else if !(*settings).EnableSqlFeatures {
+	} else if (*user).IsGuest() && !(*settings).GuestFriending {
 		(*clientMux).Unlock()
-		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+		return nil, true, helpers.NewError(errorGuestFriending, helpers.ErrorGuestRestricted)
 	}
 	userRef := *user
 	(*clientMux).Unlock()
@@ -783,7 +1490,7 @@ func clientActionFriendRequest(params interface{}, user **core.User, clientMux *
 
 	requestErr := userRef.FriendRequest(friendName)
 	if requestErr != nil {
-		return nil, true, helpers.NewError(requestErr.Error(), helpers.ErrorGopherFriendRequest)
+		return nil, true, helpers.ErrorFrom(requestErr, helpers.ErrorGopherFriendRequest)
 	}
 
 	//
@@ -795,9 +1502,9 @@ func clientActionAcceptFriend(params interface{}, user **core.User, clientMux *s
 	if *user == nil {
 		(*clientMux).Unlock()
 		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
-	} else if !(*settings).EnableSqlFeatures {
+	} else if (*user).IsGuest() && !(*settings).GuestFriending {
 		(*clientMux).Unlock()
-		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+		return nil, true, helpers.NewError(errorGuestFriending, helpers.ErrorGuestRestricted)
 	}
 	userRef := *user
 	(*clientMux).Unlock()
@@ -811,7 +1518,7 @@ func clientActionAcceptFriend(params interface{}, user **core.User, clientMux *s
 
 	acceptErr := userRef.AcceptFriendRequest(friendName)
 	if acceptErr != nil {
-		return nil, true, helpers.NewError(acceptErr.Error(), helpers.ErrorGopherFriendAccept)
+		return nil, true, helpers.ErrorFrom(acceptErr, helpers.ErrorGopherFriendAccept)
 	}
 
 	//
@@ -823,9 +1530,9 @@ func clientActionDeclineFriend(params interface{}, user **core.User, clientMux *
 	if *user == nil {
 		(*clientMux).Unlock()
 		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
-	} else if !(*settings).EnableSqlFeatures {
+	} else if (*user).IsGuest() && !(*settings).GuestFriending {
 		(*clientMux).Unlock()
-		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+		return nil, true, helpers.NewError(errorGuestFriending, helpers.ErrorGuestRestricted)
 	}
 	userRef := *user
 	(*clientMux).Unlock()
@@ -839,7 +1546,7 @@ func clientActionDeclineFriend(params interface{}, user **core.User, clientMux *
 
 	declineErr := userRef.DeclineFriendRequest(friendName)
 	if declineErr != nil {
-		return nil, true, helpers.NewError(declineErr.Error(), helpers.ErrorGopherFriendDecline)
+		return nil, true, helpers.ErrorFrom(declineErr, helpers.ErrorGopherFriendDecline)
 	}
 
 	//
@@ -851,9 +1558,9 @@ func clientActionRemoveFriend(params interface{}, user **core.User, clientMux *s
 	if *user == nil {
 		(*clientMux).Unlock()
 		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
-	} else if !(*settings).EnableSqlFeatures {
+	} else if (*user).IsGuest() && !(*settings).GuestFriending {
 		(*clientMux).Unlock()
-		return nil, true, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled)
+		return nil, true, helpers.NewError(errorGuestFriending, helpers.ErrorGuestRestricted)
 	}
 	userRef := *user
 	(*clientMux).Unlock()
@@ -867,9 +1574,52 @@ func clientActionRemoveFriend(params interface{}, user **core.User, clientMux *s
 
 	removeErr := userRef.RemoveFriend(friendName)
 	if removeErr != nil {
-		return nil, true, helpers.NewError(removeErr.Error(), helpers.ErrorGopherFriendRemove)
+		return nil, true, helpers.ErrorFrom(removeErr, helpers.ErrorGopherFriendRemove)
 	}
 
 	//
 	return nil, false, helpers.NoError()
 }
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   MATCHMAKING ACTIONS   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func clientActionQueue(params interface{}, join bool, user **core.User, connID string, clientMux *sync.Mutex) (interface{}, bool, helpers.GopherError) {
+	(*clientMux).Lock()
+	if *user == nil {
+		(*clientMux).Unlock()
+		return nil, true, helpers.NewError(errorNotLoggedIn, helpers.ErrorGopherNotLoggedIn)
+	}
+	userRef := *user
+	(*clientMux).Unlock()
+	// Get the queue from params
+	var ok bool
+	var name string
+	if name, ok = params.(string); !ok {
+		return nil, true, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat)
+	}
+	queue, queueErr := GetQueue(name)
+	if queueErr != nil {
+		return nil, true, helpers.NewError(errorQueueInvalid, helpers.ErrorQueue)
+	}
+	// Join with the rating from the rating callback, or leave
+	if join {
+		var rating int
+		queue.mux.Lock()
+		ratingCallback := queue.ratingCallback
+		queue.mux.Unlock()
+		if ratingCallback != nil && helpers.Protect("rating callback", func() { rating = ratingCallback(userRef) },
+			"queue", name, "user", userRef.Name()) {
+			return nil, true, helpers.NewError(errorActionFailed, helpers.ErrorActionFailed)
+		}
+		queueErr = queue.Join(userRef, connID, rating)
+	} else {
+		queueErr = queue.Leave(userRef, connID)
+	}
+	if queueErr != nil {
+		return nil, true, helpers.ErrorFrom(queueErr, helpers.ErrorQueue)
+	}
+	//
+	return name, true, helpers.NoError()
+}
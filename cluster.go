@@ -0,0 +1,69 @@
+package gopher
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strings"
+	"time"
+)
+
+const (
+	defaultClusterChannel   = "gopher"
+	defaultClusterHeartbeat = time.Second * 5
+)
+
+// ClusterSettings runs the server as one node of a cluster, so you can run more than one server behind a load balancer. The
+// nodes share who is logged in and which node each Room is on through a Redis or NATS server, so core.IsUserOnline() and
+// private messages work for Users on any node.
+//
+// Each Room lives on the node it was made on, and Room names are unique across the cluster. A client that tries to join a Room
+// on another node gets an ErrorRoomRedirect error, and a helpers.ServerActionRoomRedirect message with the Room's name ("r")
+// and the NodeAddress of its node ("a"), so it can connect to that node and join there.
+type ClusterSettings struct {
+	Driver   string // The pub/sub server the nodes talk through: core.ClusterDriverRedis ("redis") or core.ClusterDriverNATS ("nats"). (Required)
+	Address  string // The pub/sub server's address, like "localhost:6379". (Required)
+	Password string // The Redis AUTH password, or the NATS auth token.
+	Channel  string // The name of the channel the nodes talk on. Give each cluster its own to share a pub/sub server between them. Default is "gopher".
+
+	NodeName    string // This node's name. Every node in the cluster needs a different one. Defaults to a random name.
+	NodeAddress string // The address clients connect to this node with, like "wss://game2.example.com/wss". Clients are sent it when they're redirected to this node. (Required)
+
+	HeartbeatInterval time.Duration // How often this node tells the others it's still running. A node that misses three heartbeats is removed from the cluster, with its Users and Rooms. Default is 5 seconds.
+}
+
+// validate adds the problems with the ClusterSettings to problem.
+func (c *ClusterSettings) validate(problem func(string)) {
+	if c.Driver != core.ClusterDriverRedis && c.Driver != core.ClusterDriverNATS {
+		problem("Cluster.Driver must be \"redis\" or \"nats\"")
+	}
+	if c.Address == "" || c.NodeAddress == "" {
+		problem("Cluster.Address and Cluster.NodeAddress are required for clustering")
+	}
+	if strings.ContainsAny(c.Channel+c.NodeName, " .*>\t\r\n") {
+		problem("Cluster.Channel and Cluster.NodeName can't have spaces, dots, '*' or '>'")
+	}
+	if c.HeartbeatInterval < 0 {
+		problem("Cluster.HeartbeatInterval cannot be negative")
+	}
+}
+
+// startCluster joins the cluster with the ServerSettings' ClusterSettings.
+func startCluster(c *ClusterSettings) error {
+	node := c.NodeName
+	if node == "" {
+		random, err := helpers.GenerateSecureString(8)
+		if err != nil {
+			return err
+		}
+		node = "node-" + strings.TrimRight(random, "=")
+	}
+	channel := c.Channel
+	if channel == "" {
+		channel = defaultClusterChannel
+	}
+	heartbeat := c.HeartbeatInterval
+	if heartbeat == 0 {
+		heartbeat = defaultClusterHeartbeat
+	}
+	return core.StartCluster(c.Driver, c.Address, c.Password, channel, node, c.NodeAddress, heartbeat)
+}
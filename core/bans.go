@@ -0,0 +1,412 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/database"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// BanRecord is an account or IP address ban. Get them all with ListBans().
+type BanRecord struct {
+	Name    string    // The banned User name, or "" for an IP address ban
+	IP      string    // The banned IP address, or "" for an account ban
+	Reason  string    // Why they were banned
+	Created time.Time // When the ban was made
+	Expires time.Time // When the ban ends, or the zero Time if it never does
+}
+
+const (
+	banKindName = "n"
+	banKindIP   = "ip"
+
+	// BANS ARE SAVED IN THE DATABASE, SO THE REASON HAS TO FIT IN ITS COLUMN
+	maxBanReasonLength = 255
+)
+
+var (
+	//bansMux LOCKS bannedUsers, bannedIPs, AND WRITING THE BANS FILE
+	bannedUsers = make(map[string]BanRecord)
+	bannedIPs   = make(map[string]BanRecord)
+	bansMux     sync.Mutex
+
+	bansOnDatabase bool   // SAVE BANS IN THE bans TABLE
+	bansFile       string // OR IN THIS JSON FILE. NEITHER KEEPS THEM IN MEMORY ONLY.
+
+	// BANS activeBan() FOUND EXPIRED, WAITING FOR removeExpiredBans() TO SAVE THEIR REMOVAL - LOCK bansMux TO USE
+	expiredBans []expiredBan
+)
+
+// expiredBan is a ban that was removed from memory when it expired.
+type expiredBan struct {
+	kind   string
+	target string
+	ban    BanRecord
+}
+
+// Expired returns true if the ban is temporary, and has ended by the time now.
+func (b BanRecord) Expired(now time.Time) bool {
+	return !b.Expires.IsZero() && !now.Before(b.Expires)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   ACCOUNT BANS   //////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Ban stops the User with the name userName from logging in for duration, or forever when duration is 0. If they're logged
+// in, they're kicked and their clients are disconnected. They see the reason and how long is left when they try to log in.
+//
+// Bans are saved in the database when EnableSqlFeatures is on, and otherwise in a file in the RecoveryLocation, so they last
+// through restarts. Banning a User again replaces their old ban.
+func Ban(userName string, duration time.Duration, reason string) error {
+	if len(userName) == 0 {
+		return errors.New("core.Ban() requires a user name")
+	}
+	ban, err := newBan(duration, reason)
+	if err != nil {
+		return err
+	}
+	ban.Name = userName
+	bansMux.Lock()
+	err = saveBan(banKindName, userName, ban)
+	bansMux.Unlock()
+	if err != nil {
+		return err
+	}
+	helpers.Log().Info("User banned", "user", userName, "reason", reason, "duration", duration)
+
+	// KICK THEM, THEN HANG UP ON THEM
+	if user, userErr := GetUser(userName); userErr == nil {
+		user.kick(banMessage(ban), "Banned")
+	}
+
+	//
+	return nil
+}
+
+// Unban lets the User with the name userName log in again after a Ban().
+func Unban(userName string) error {
+	defer removeExpiredBans()
+	bansMux.Lock()
+	defer bansMux.Unlock()
+	if _, ok := activeBan(banKindName, userName, time.Now()); !ok {
+		return errors.New("The user '" + userName + "' is not banned")
+	}
+	if err := removeBan(banKindName, userName); err != nil {
+		return err
+	}
+	helpers.Log().Info("User unbanned", "user", userName)
+	return nil
+}
+
+// forgetBan removes the ban on a deleted account's name, without removing it from the database. Deleting the account
+// already did.
+func forgetBan(userName string) {
+	bansMux.Lock()
+	defer bansMux.Unlock()
+	if _, ok := bannedUsers[userName]; ok {
+		delete(bannedUsers, userName)
+		if err := writeBans(); err != nil {
+			helpers.Log().Error("Error saving bans", "error", err)
+		}
+	}
+}
+
+// IsBanned returns true if the User with the name userName is banned.
+func IsBanned(userName string) bool {
+	_, banned := GetBan(userName)
+	return banned
+}
+
+// GetBan gets the ban on the User with the name userName. Returns false if they aren't banned.
+func GetBan(userName string) (BanRecord, bool) {
+	defer removeExpiredBans()
+	bansMux.Lock()
+	defer bansMux.Unlock()
+	return activeBan(banKindName, userName, time.Now())
+}
+
+// ListBans gets all the account bans sorted by User name, followed by all the IP address bans sorted by address. Bans that
+// have expired are removed.
+func ListBans() []BanRecord {
+	now := time.Now()
+	var names, ips []BanRecord
+	bansMux.Lock()
+	for name := range bannedUsers {
+		if ban, ok := activeBan(banKindName, name, now); ok {
+			names = append(names, ban)
+		}
+	}
+	for ip := range bannedIPs {
+		if ban, ok := activeBan(banKindIP, ip, now); ok {
+			ips = append(ips, ban)
+		}
+	}
+	bansMux.Unlock()
+	removeExpiredBans()
+	sort.Slice(names, func(i, j int) bool { return names[i].Name < names[j].Name })
+	sort.Slice(ips, func(i, j int) bool { return ips[i].IP < ips[j].IP })
+
+	//
+	return append(names, ips...)
+}
+
+// banMessage makes the login error for a banned User, with the reason and how long the ban has left.
+func banMessage(ban BanRecord) string {
+	message := errorBanned
+	if ban.Reason != "" {
+		message += ": " + ban.Reason
+	}
+	if ban.Expires.IsZero() {
+		return message + " (permanently)"
+	}
+	left := time.Until(ban.Expires).Round(time.Second)
+	if left < time.Second {
+		left = time.Second
+	}
+	return message + " (" + left.String() + " left)"
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   IP ADDRESS BANS   ///////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// BanAddress is only for internal Gopher Game Server mechanics.
+func BanAddress(ip string, duration time.Duration, reason string) error {
+	ban, err := newBan(duration, reason)
+	if err != nil {
+		return err
+	}
+	ban.IP = ip
+	bansMux.Lock()
+	defer bansMux.Unlock()
+	return saveBan(banKindIP, ip, ban)
+}
+
+// UnbanAddress is only for internal Gopher Game Server mechanics.
+func UnbanAddress(ip string) error {
+	defer removeExpiredBans()
+	bansMux.Lock()
+	defer bansMux.Unlock()
+	if _, ok := activeBan(banKindIP, ip, time.Now()); !ok {
+		return errors.New("The IP address '" + ip + "' is not banned")
+	}
+	return removeBan(banKindIP, ip)
+}
+
+// GetAddressBan is only for internal Gopher Game Server mechanics.
+func GetAddressBan(ip string) (BanRecord, bool) {
+	defer removeExpiredBans()
+	bansMux.Lock()
+	defer bansMux.Unlock()
+	return activeBan(banKindIP, ip, time.Now())
+}
+
+// CloseBanned is only for internal Gopher Game Server mechanics.
+func CloseBanned(socket *websocket.Conn) {
+	socket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "Banned"), time.Now().Add(time.Second*1))
+	socket.Close()
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SAVING AND LOADING BANS   ///////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// LoadBans is only for internal Gopher Game Server mechanics.
+func LoadBans(onDatabase bool, folder string) error {
+	defer removeExpiredBans()
+	bansMux.Lock()
+	defer bansMux.Unlock()
+	bansOnDatabase = onDatabase
+	bansFile = ""
+	if !onDatabase && folder != "" {
+		bansFile = filepath.Join(folder, "Gopher Bans.json")
+	}
+
+	var bans []BanRecord
+	if onDatabase {
+		records, err := database.GetBans()
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			ban := BanRecord{Reason: record.Reason, Created: time.Unix(record.Created, 0)}
+			if record.Expires != 0 {
+				ban.Expires = time.Unix(record.Expires, 0)
+			}
+			if record.Kind == banKindIP {
+				ban.IP = record.Target
+			} else {
+				ban.Name = record.Target
+			}
+			bans = append(bans, ban)
+		}
+	} else if bansFile != "" {
+		data, err := ioutil.ReadFile(bansFile)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if err = json.Unmarshal(data, &bans); err != nil {
+			return err
+		}
+	}
+
+	for _, ban := range bans {
+		if ban.IP != "" {
+			bannedIPs[ban.IP] = ban
+		} else if ban.Name != "" {
+			bannedUsers[ban.Name] = ban
+		}
+	}
+
+	// DROP THE ONES THAT RAN OUT WHILE THE SERVER WAS DOWN
+	now := time.Now()
+	for name := range bannedUsers {
+		activeBan(banKindName, name, now)
+	}
+	for ip := range bannedIPs {
+		activeBan(banKindIP, ip, now)
+	}
+	helpers.Log().Info("Loaded "+strconv.Itoa(len(bannedUsers)+len(bannedIPs))+" bans", "database", onDatabase, "file", bansFile)
+
+	//
+	return nil
+}
+
+func newBan(duration time.Duration, reason string) (BanRecord, error) {
+	if duration < 0 {
+		return BanRecord{}, errors.New("A ban's duration cannot be negative")
+	} else if len(reason) > maxBanReasonLength {
+		return BanRecord{}, errors.New("A ban's reason can be at most " + strconv.Itoa(maxBanReasonLength) + " characters")
+	}
+	ban := BanRecord{Reason: reason, Created: time.Now()}
+	if duration > 0 {
+		ban.Expires = ban.Created.Add(duration)
+	}
+	return ban, nil
+}
+
+// bansOf gets the bans of a kind. bansMux must be locked.
+func bansOf(kind string) map[string]BanRecord {
+	if kind == banKindIP {
+		return bannedIPs
+	}
+	return bannedUsers
+}
+
+// activeBan gets the ban on target, and removes it from memory instead if it has expired. bansMux must be locked. Call
+// removeExpiredBans() after unlocking it to save the removal.
+func activeBan(kind string, target string, now time.Time) (BanRecord, bool) {
+	ban, ok := bansOf(kind)[target]
+	if !ok {
+		return BanRecord{}, false
+	} else if ban.Expired(now) {
+		delete(bansOf(kind), target)
+		expiredBans = append(expiredBans, expiredBan{kind: kind, target: target, ban: ban})
+		return BanRecord{}, false
+	}
+	return ban, true
+}
+
+// removeExpiredBans saves the removal of the bans activeBan() found expired. bansMux must NOT be locked, so logins don't
+// wait on the database.
+func removeExpiredBans() {
+	bansMux.Lock()
+	if len(expiredBans) == 0 {
+		bansMux.Unlock()
+		return
+	}
+	expired := expiredBans
+	expiredBans = nil
+	onDatabase := bansOnDatabase
+	err := writeBans()
+	bansMux.Unlock()
+	if err != nil {
+		helpers.Log().Error("Error saving bans", "error", err)
+	}
+	if !onDatabase {
+		return
+	}
+	for _, e := range expired {
+		if err := database.RemoveExpiredBan(e.kind, e.target, e.ban.Expires.Unix()); err != nil {
+			helpers.Log().Error("Error removing expired ban", "target", e.target, "error", err)
+		}
+	}
+}
+
+// saveBan adds or replaces the ban on target, and saves it. bansMux must be locked.
+func saveBan(kind string, target string, ban BanRecord) error {
+	if bansOnDatabase {
+		record := database.BanRecord{Kind: kind, Target: target, Reason: ban.Reason, Created: ban.Created.Unix()}
+		if !ban.Expires.IsZero() {
+			record.Expires = ban.Expires.Unix()
+		}
+		if err := database.SaveBan(record); err != nil {
+			return err
+		}
+	}
+	bansOf(kind)[target] = ban
+	return writeBans()
+}
+
+// removeBan removes the ban on target, and saves the change. bansMux must be locked.
+func removeBan(kind string, target string) error {
+	if bansOnDatabase {
+		if err := database.RemoveBan(kind, target); err != nil {
+			return err
+		}
+	}
+	delete(bansOf(kind), target)
+	return writeBans()
+}
+
+// writeBans writes every ban to a temporary file, then renames it over the bans file, when bans aren't saved in the
+// database. bansMux must be locked.
+func writeBans() error {
+	if bansFile == "" {
+		return nil
+	}
+	bans := make([]BanRecord, 0, len(bannedUsers)+len(bannedIPs))
+	for _, ban := range bannedUsers {
+		bans = append(bans, ban)
+	}
+	for _, ban := range bannedIPs {
+		bans = append(bans, ban)
+	}
+	data, err := json.Marshal(bans)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(bansFile, data)
+}
+
+// writeFileAtomic writes data to a temporary file next to the file, then renames it over the file, so the file is never left
+// half written.
+func writeFileAtomic(file string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+" *.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = temp.Write(data); err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBans(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	folder := t.TempDir()
+	if err := LoadBans(false, folder); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		bansMux.Lock()
+		bannedUsers = make(map[string]BanRecord)
+		bannedIPs = make(map[string]BanRecord)
+		bansOnDatabase, bansFile = false, ""
+		bansMux.Unlock()
+	})
+
+	// Banning a logged in User kicks them, and keeps them from logging in
+	testLogin(t, "bansCheater")
+	if err := Ban("bansCheater", time.Hour, "Cheating"); err != nil {
+		t.Fatal(err)
+	} else if _, err := GetUser("bansCheater"); err == nil {
+		t.Error("The banned User should have been logged out")
+	}
+	var user *User
+	var clientMux sync.Mutex
+	_, err := Login("bansCheater", -1, "", true, false, testSocket(t), &user, &clientMux)
+	if err.ID != helpers.ErrorAuthBanned {
+		t.Error("Expected the banned User's login to fail, got error", err.ID)
+	} else if !strings.Contains(err.Message, "Cheating") || !strings.Contains(err.Message, "left") {
+		t.Error("Expected the ban's reason and time left in the login error, got", err.Message)
+	}
+	if err := Ban("bansCheater", -time.Hour, ""); err == nil {
+		t.Error("Bans with a negative duration should fail")
+	}
+
+	// Bans are read back from the file
+	if err := BanAddress("203.0.113.5", 0, "Flooding"); err != nil {
+		t.Fatal(err)
+	}
+	bansMux.Lock()
+	bannedUsers = make(map[string]BanRecord)
+	bannedIPs = make(map[string]BanRecord)
+	bansMux.Unlock()
+	if err := LoadBans(false, folder); err != nil {
+		t.Fatal(err)
+	}
+	bans := ListBans()
+	if len(bans) != 2 || bans[0].Name != "bansCheater" || bans[0].Expires.IsZero() || bans[1].IP != "203.0.113.5" || !bans[1].Expires.IsZero() {
+		t.Error("Expected the saved bans after loading them, got", bans)
+	}
+
+	// Temporary bans expire
+	if err := Ban("bansShort", time.Millisecond*50, ""); err != nil {
+		t.Fatal(err)
+	} else if !IsBanned("bansShort") {
+		t.Error("Expected the User to be banned")
+	}
+	time.Sleep(time.Millisecond * 100)
+	if IsBanned("bansShort") {
+		t.Error("Expected the temporary ban to have expired")
+	}
+
+	// Unbanning
+	if err := Unban("bansCheater"); err != nil {
+		t.Error(err)
+	} else if err := Unban("bansCheater"); err == nil {
+		t.Error("Unbanning a User that isn't banned should fail")
+	}
+	if _, err := Login("bansCheater", -1, "", true, false, testSocket(t), &user, &clientMux); err.ID != 0 {
+		t.Error("Expected the unbanned User to log in, got error", err.Message)
+	} else {
+		user.Logout("")
+	}
+}
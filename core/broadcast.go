@@ -0,0 +1,138 @@
+package core
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strconv"
+)
+
+// BroadcastFilter decides which Users get a broadcast from BroadcastToUsers(). A nil *BroadcastFilter sends
+// the broadcast to every User.
+type BroadcastFilter struct {
+	Statuses     []int    // Only send to Users with one of these statuses, like StatusAvailable. Leave empty for any status.
+	ExcludeRooms []string // Don't send to the connections that are in one of these Rooms.
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SERVER-WIDE ANNOUNCEMENTS   /////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// BroadcastToUsers sends an announcement to every User logged into the server that passes the filter. The messageType
+// is up to you, and lets your client tell apart different kinds of announcements. The client APIs receive announcements
+// separately from Room messages.
+//
+// The message is only encoded once for each wire format the Users' clients use (see helpers.FormatJSON). Connections that fail to receive it are skipped, and the returned error tells how
+// many failed along with the first error.
+func BroadcastToUsers(messageType string, data interface{}, filter *BroadcastFilter) error {
+	message, err := PrepareAnnouncement(messageType, data)
+	if err != nil {
+		return err
+	}
+	var held []*userConn
+	sockets := []*websocket.Conn{}
+	for _, user := range GetUsers() {
+		if filter != nil && len(filter.Statuses) > 0 && !containsInt(filter.Statuses, user.Status()) {
+			continue
+		}
+		user.mux.Lock()
+		for _, conn := range user.conns {
+			if filter != nil && conn.room != nil && containsString(filter.ExcludeRooms, conn.room.Name()) {
+				continue
+			}
+			if socket := conn.liveSocket(); socket != nil {
+				sockets = append(sockets, socket)
+			} else {
+				held = append(held, conn)
+			}
+		}
+		user.mux.Unlock()
+	}
+	// BUFFER IT FOR THE CONNECTIONS WAITING TO RECONNECT
+	for _, conn := range held {
+		conn.send(message)
+	}
+	return WritePrepared(sockets, message)
+}
+
+// PrepareAnnouncement is only for internal Gopher Game Server mechanics.
+func PrepareAnnouncement(messageType string, data interface{}) (*helpers.Encoded, error) {
+	if len(messageType) == 0 {
+		return nil, errors.New("An announcement requires a message type")
+	}
+	message := helpers.NewEncoded(announcement(messageType, data))
+	if _, err := message.Prepared(helpers.FormatJSON); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// PrepareLocalizedAnnouncement is only for internal Gopher Game Server mechanics.
+func PrepareLocalizedAnnouncement(messageType string, key int, params []interface{}) (func(locale string) *helpers.Encoded, error) {
+	if len(messageType) == 0 {
+		return nil, errors.New("An announcement requires a message type")
+	}
+	messages, _, err := newLocalizedMessages(key, params, func(text string) *helpers.Encoded {
+		return helpers.NewEncoded(announcement(messageType, text))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return messages.get, nil
+}
+
+func announcement(messageType string, data interface{}) map[string]map[string]interface{} {
+	return map[string]map[string]interface{}{
+		helpers.ServerActionAnnouncement: {
+			"t": messageType,
+			"d": data,
+		},
+	}
+}
+
+// WritePrepared is only for internal Gopher Game Server mechanics.
+func WritePrepared(sockets []*websocket.Conn, message *helpers.Encoded) error {
+	return writeEach(sockets, func(*websocket.Conn) *helpers.Encoded { return message })
+}
+
+// WriteLocalized is only for internal Gopher Game Server mechanics.
+func WriteLocalized(sockets []*websocket.Conn, messages func(locale string) *helpers.Encoded) error {
+	return writeEach(sockets, func(socket *websocket.Conn) *helpers.Encoded { return messages(ConnLocale(socket)) })
+}
+
+// writeEach writes the socket's message to each socket. Sockets that fail are skipped, and the returned error tells how many
+// failed along with the first error.
+func writeEach(sockets []*websocket.Conn, messageFor func(*websocket.Conn) *helpers.Encoded) error {
+	var failed int
+	var firstErr error
+	for _, socket := range sockets {
+		if err := messageFor(socket).Write(socket); err != nil {
+			if failed == 0 {
+				firstErr = err
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errors.New("Failed to send to " + strconv.Itoa(failed) + " of " + strconv.Itoa(len(sockets)) + " connections: " + firstErr.Error())
+	}
+	return nil
+}
+
+func containsInt(list []int, val int) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, val string) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
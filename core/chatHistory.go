@@ -0,0 +1,132 @@
+package core
+
+import (
+	"time"
+)
+
+// ChatMessage represents a chat message kept in a Room's chat history.
+type ChatMessage struct {
+	Author  string      // The name of the User who sent the message
+	Time    time.Time   // When the message was sent
+	Message interface{} // The message
+}
+
+// chatHistory is a ring buffer of the latest chat messages in a Room. Must lock the Room's mux to use.
+type chatHistory struct {
+	messages []ChatMessage
+	next     int
+	full     bool
+}
+
+func newChatHistory(length int) *chatHistory {
+	if length <= 0 {
+		return nil
+	}
+	return &chatHistory{messages: make([]ChatMessage, length)}
+}
+
+func (h *chatHistory) add(message ChatMessage) {
+	h.messages[h.next] = message
+	h.next++
+	if h.next == len(h.messages) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// get returns the latest count messages, oldest first. A count of 0 or less gets all of them.
+func (h *chatHistory) get(count int) []ChatMessage {
+	size := h.next
+	if h.full {
+		size = len(h.messages)
+	}
+	if count <= 0 || count > size {
+		count = size
+	}
+	history := make([]ChatMessage, count)
+	start := h.next - count
+	if start < 0 {
+		start += len(h.messages)
+	}
+	for i := 0; i < count; i++ {
+		history[i] = h.messages[(start+i)%len(h.messages)]
+	}
+	return history
+}
+
+// purge removes all messages from the author, or all messages if the author is an empty string.
+func (h *chatHistory) purge(author string) {
+	kept := []ChatMessage{}
+	if author != "" {
+		for _, message := range h.get(0) {
+			if message.Author != author {
+				kept = append(kept, message)
+			}
+		}
+	}
+	h.messages = make([]ChatMessage, len(h.messages))
+	h.next = 0
+	h.full = false
+	for _, message := range kept {
+		h.add(message)
+	}
+}
+
+// MakeChatHistoryResponse is only for internal Gopher Game Server mechanics.
+func MakeChatHistoryResponse(history []ChatMessage) []map[string]interface{} {
+	response := make([]map[string]interface{}, len(history))
+	for i, message := range history {
+		response[i] = map[string]interface{}{
+			"a": message.Author,
+			"t": message.Time.Unix(),
+			"m": message.Message,
+		}
+	}
+	return response
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   Room CHAT HISTORY   /////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// GetChatHistory gets the latest chat messages sent in the Room, oldest first. A count of 0 or less gets all the messages
+// the Room has kept. Returns an empty slice if the Room does not keep a chat history, which you can enable with
+// ChatHistoryLen in ServerSettings or *RoomType.SetChatHistoryLen().
+func (r *Room) GetChatHistory(count int) []ChatMessage {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.chatHistory == nil {
+		return []ChatMessage{}
+	}
+	return r.chatHistory.get(count)
+}
+
+// PurgeChatHistory removes all the messages sent by a User from the Room's chat history. Messages from Users who leave the
+// Room or get kicked are otherwise kept. Use ClearChatHistory() to remove every message.
+func (r *Room) PurgeChatHistory(userName string) {
+	if len(userName) == 0 {
+		return
+	}
+	r.mux.Lock()
+	if r.chatHistory != nil {
+		r.chatHistory.purge(userName)
+	}
+	r.mux.Unlock()
+}
+
+// ClearChatHistory removes every message from the Room's chat history.
+func (r *Room) ClearChatHistory() {
+	r.mux.Lock()
+	if r.chatHistory != nil {
+		r.chatHistory.purge("")
+	}
+	r.mux.Unlock()
+}
+
+func (r *Room) addChatHistory(author string, message interface{}) {
+	r.mux.Lock()
+	if r.chatHistory != nil {
+		r.chatHistory.add(ChatMessage{Author: author, Time: time.Now(), Message: message})
+	}
+	r.mux.Unlock()
+}
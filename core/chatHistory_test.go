@@ -0,0 +1,38 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestChatHistory(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 3)
+	defer SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("chatHistory", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	room.ChatMessage("a", "one")
+	room.ChatMessage("b", "two")
+	room.ChatMessage("a", "three")
+	room.ChatMessage("b", "four")
+
+	// Only the latest 3 are kept, oldest first
+	history := room.GetChatHistory(0)
+	if len(history) != 3 || history[0].Message != "two" || history[2].Message != "four" {
+		t.Fatal("Unexpected chat history:", history)
+	}
+	if latest := room.GetChatHistory(1); len(latest) != 1 || latest[0].Message != "four" {
+		t.Error("GetChatHistory(1) should only get the latest message, got", latest)
+	}
+
+	room.PurgeChatHistory("b")
+	if history := room.GetChatHistory(0); len(history) != 1 || history[0].Author != "a" {
+		t.Error("PurgeChatHistory() should only remove the author's messages, got", history)
+	}
+	room.ClearChatHistory()
+	if len(room.GetChatHistory(0)) != 0 {
+		t.Error("ClearChatHistory() should remove every message")
+	}
+}
@@ -0,0 +1,583 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The pub/sub servers the nodes of a cluster can talk through.
+const (
+	ClusterDriverRedis = "redis"
+	ClusterDriverNATS  = "nats"
+)
+
+// The kinds of messages the nodes of a cluster send each other.
+const (
+	clusterHello  = "hello" // A node connected. Has its state, and the other nodes answer with theirs.
+	clusterState  = "state" // Everyone on, and every Room in, the node. Replaces what was known about it.
+	clusterBeat   = "hb"    // The node is still there.
+	clusterBye    = "bye"   // The node is shutting down.
+	clusterOnline = "in"    // A User logged in on the node.
+	clusterLogout = "out"   // A User logged out of the node.
+	clusterRoom   = "rc"    // A Room was made on the node.
+	clusterNoRoom = "rd"    // A Room was deleted on the node.
+	clusterPM     = "pm"    // A private message for a User on the node it was sent to.
+	clusterKick   = "kick"  // A User on the node it was sent to logged in on another node.
+)
+
+const (
+	clusterOutboxSize      = 4096
+	clusterMaxReconnectGap = time.Second * 30
+)
+
+// clusterMessage is what the nodes of a cluster send each other, as JSON.
+type clusterMessage struct {
+	T  string      `json:"t"`            // kind
+	N  string      `json:"n"`            // node it's from
+	A  string      `json:"a,omitempty"`  // address of the node it's from
+	U  string      `json:"u,omitempty"`  // User name
+	R  string      `json:"r,omitempty"`  // Room name
+	F  string      `json:"f,omitempty"`  // private message author
+	M  interface{} `json:"m,omitempty"`  // private message
+	US []string    `json:"us,omitempty"` // Users on the node
+	RS []string    `json:"rs,omitempty"` // Rooms on the node
+}
+
+type clusterOutbound struct {
+	channel string
+	payload []byte
+}
+
+// clusterNode is another node of the cluster.
+type clusterNode struct {
+	address  string
+	lastSeen time.Time
+}
+
+var (
+	// 1 WHILE THE CLUSTER IS RUNNING, SO THE USER AND ROOM HOOKS DON'T NEED A LOCK WHEN IT'S OFF
+	clusterRunning int32
+
+	// SET BY StartCluster() BEFORE clusterRunning
+	clusterDriver    string
+	clusterAddress   string
+	clusterPassword  string
+	clusterPrefix    string
+	clusterHeartbeat time.Duration
+	nodeName         string
+	nodeAddress      string
+
+	clusterMux  sync.Mutex
+	nodes       map[string]*clusterNode
+	remoteUsers map[string]map[string]bool // User NAME TO THE NODES THEY'RE ON
+	remoteRooms map[string]string          // Room NAME TO THE NODE IT'S ON
+	bus         clusterBus
+
+	clusterOutbox    chan clusterOutbound
+	clusterStop      chan struct{}
+	clusterDone      sync.WaitGroup // THE OUTBOX AND HEARTBEAT
+	clusterListening sync.WaitGroup
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   STARTING & STOPPING   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// StartCluster is only for internal Gopher Game Server mechanics. Use Cluster in ServerSettings.
+func StartCluster(driver string, address string, password string, prefix string, node string, publicAddress string, heartbeat time.Duration) error {
+	if atomic.LoadInt32(&clusterRunning) == 1 {
+		return errors.New("The cluster is already running")
+	} else if node == "" {
+		return errors.New("The cluster requires a node name")
+	} else if heartbeat <= 0 {
+		return errors.New("The cluster heartbeat must be greater than 0")
+	}
+	clusterDriver, clusterAddress, clusterPassword, clusterPrefix = driver, address, password, prefix
+	nodeName, nodeAddress, clusterHeartbeat = node, publicAddress, heartbeat
+	newBus, err := dialClusterBus(driver, address, password, clusterChannels())
+	if err != nil {
+		return err
+	}
+	runCluster(newBus)
+	helpers.Log().Info("Joined the cluster", "node", nodeName, "driver", driver)
+	return nil
+}
+
+// runCluster starts the cluster's goroutines with a connected clusterBus, and says hello to the other nodes.
+func runCluster(newBus clusterBus) {
+	clusterMux.Lock()
+	nodes = make(map[string]*clusterNode)
+	remoteUsers = make(map[string]map[string]bool)
+	remoteRooms = make(map[string]string)
+	bus = newBus
+	clusterMux.Unlock()
+	clusterOutbox = make(chan clusterOutbound, clusterOutboxSize)
+	clusterStop = make(chan struct{})
+	atomic.StoreInt32(&clusterRunning, 1)
+
+	clusterDone.Add(2)
+	clusterListening.Add(1)
+	go runClusterListener(newBus)
+	go runClusterOutbox()
+	go runClusterHeartbeat()
+	sendClusterState(clusterHello, clusterPrefix)
+}
+
+// StopCluster is only for internal Gopher Game Server mechanics.
+func StopCluster() {
+	if !atomic.CompareAndSwapInt32(&clusterRunning, 1, 0) {
+		return
+	}
+	// THE OUTBOX SENDS THE GOODBYE, THEN FINISHES
+	select {
+	case clusterOutbox <- clusterOutbound{channel: clusterPrefix, payload: encodeClusterMessage(clusterMessage{T: clusterBye})}:
+	default:
+	}
+	close(clusterStop)
+	clusterDone.Wait()
+
+	// CLOSING THE BUS STOPS THE LISTENER
+	clusterMux.Lock()
+	closing := bus
+	bus = nil
+	nodes, remoteUsers, remoteRooms = nil, nil, nil
+	clusterMux.Unlock()
+	if closing != nil {
+		closing.close()
+	}
+	clusterListening.Wait()
+	helpers.Log().Info("Left the cluster", "node", nodeName)
+}
+
+// clusterChannels gets the channel every node listens to, and this node's own channel.
+func clusterChannels() []string {
+	return []string{clusterPrefix, nodeChannel(nodeName)}
+}
+
+func nodeChannel(node string) string {
+	return clusterPrefix + "." + node
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CLUSTER INFO   //////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ClusterEnabled returns true when the server is running as a node of a cluster.
+func ClusterEnabled() bool {
+	return atomic.LoadInt32(&clusterRunning) == 1
+}
+
+// NodeName gets the name of this node of the cluster, or an empty string when clustering is off.
+func NodeName() string {
+	if !ClusterEnabled() {
+		return ""
+	}
+	return nodeName
+}
+
+// ClusterNodes gets the names of the nodes in the cluster, including this one, mapped to the addresses clients connect to them
+// with. Returns nil when clustering is off.
+func ClusterNodes() map[string]string {
+	if !ClusterEnabled() {
+		return nil
+	}
+	clusterMux.Lock()
+	defer clusterMux.Unlock()
+	list := map[string]string{nodeName: nodeAddress}
+	for name, node := range nodes {
+		list[name] = node.address
+	}
+	return list
+}
+
+// UserNode gets the name of a node the User is logged in on. It's this node's name when they're logged in here, even if
+// MultiConnect lets them log in on others too. When clustering is off, it's an empty string for Users that are logged in.
+func UserNode(userName string) (string, bool) {
+	if findUser(userName) != nil {
+		return NodeName(), true
+	}
+	if remote := remoteUserNodes(userName); len(remote) > 0 {
+		return remote[0], true
+	}
+	return "", false
+}
+
+// IsUserOnline returns true if the User is logged in on this node, or on any other node of the cluster.
+func IsUserOnline(userName string) bool {
+	_, online := UserNode(userName)
+	return online
+}
+
+// RoomNode gets the name of the node a Room is on, and the address clients connect to the node with. The Room is on this
+// node when it's found with GetRoom(). Returns false when no node has the Room. The name and address are empty when clustering
+// is off.
+func RoomNode(roomName string) (string, string, bool) {
+	roomsMux.Lock()
+	_, local := rooms[roomName]
+	roomsMux.Unlock()
+	if !ClusterEnabled() {
+		return "", "", local
+	} else if local {
+		return nodeName, nodeAddress, true
+	}
+	clusterMux.Lock()
+	defer clusterMux.Unlock()
+	node, ok := remoteRooms[roomName]
+	if !ok {
+		return "", "", false
+	}
+	var address string
+	if n := nodes[node]; n != nil {
+		address = n.address
+	}
+	return node, address, true
+}
+
+// remoteRoomNode gets the other node a Room is on, or an empty string.
+func remoteRoomNode(roomName string) string {
+	if !ClusterEnabled() {
+		return ""
+	}
+	clusterMux.Lock()
+	defer clusterMux.Unlock()
+	return remoteRooms[roomName]
+}
+
+// remoteUserNodes gets the other nodes a User is logged in on, in order.
+func remoteUserNodes(userName string) []string {
+	if !ClusterEnabled() {
+		return nil
+	}
+	clusterMux.Lock()
+	list := make([]string, 0, len(remoteUsers[userName]))
+	for node := range remoteUsers[userName] {
+		list = append(list, node)
+	}
+	clusterMux.Unlock()
+	sort.Strings(list)
+	return list
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   ANNOUNCEMENTS   /////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// clusterUserOnline tells the cluster a User logged in. Never waits, so it's safe to call with a shard locked.
+func clusterUserOnline(userName string) {
+	sendCluster(clusterPrefix, clusterMessage{T: clusterOnline, U: userName})
+}
+
+// clusterUserOffline tells the cluster a User logged out. Never waits, so it's safe to call with a shard locked.
+func clusterUserOffline(userName string) {
+	sendCluster(clusterPrefix, clusterMessage{T: clusterLogout, U: userName})
+}
+
+func clusterRoomCreated(roomName string) {
+	sendCluster(clusterPrefix, clusterMessage{T: clusterRoom, R: roomName})
+}
+
+func clusterRoomDeleted(roomName string) {
+	sendCluster(clusterPrefix, clusterMessage{T: clusterNoRoom, R: roomName})
+}
+
+// clusterPrivateMessage sends a private message to a User on another node. Returns false if they aren't on one.
+func (u *User) clusterPrivateMessage(userName string, message interface{}) bool {
+	remote := remoteUserNodes(userName)
+	if len(remote) == 0 {
+		return false
+	}
+	sendCluster(nodeChannel(remote[0]), clusterMessage{T: clusterPM, U: userName, F: u.Name(), M: message})
+
+	// THE AUTHOR GETS THEIR COPY FROM HERE
+	u.mux.Lock()
+	for _, conn := range u.conns {
+		conn.send(privateMessage(u.Name(), userName, message))
+	}
+	u.mux.Unlock()
+	return true
+}
+
+// clusterKickElsewhere logs a User out of the other nodes they're on. Returns false if they aren't on any.
+func clusterKickElsewhere(userName string) bool {
+	remote := remoteUserNodes(userName)
+	for _, node := range remote {
+		sendCluster(nodeChannel(node), clusterMessage{T: clusterKick, U: userName})
+	}
+	return len(remote) > 0
+}
+
+// clusterResetUsers tells the cluster this node's Users were all logged out, like when the server is paused.
+func clusterResetUsers() {
+	if ClusterEnabled() {
+		sendClusterState(clusterState, clusterPrefix)
+	}
+}
+
+// sendCluster queues a message for the cluster. When the queue is full, the message is dropped, and the heartbeat's
+// state fixes what the other nodes missed.
+func sendCluster(channel string, message clusterMessage) {
+	if !ClusterEnabled() {
+		return
+	}
+	select {
+	case clusterOutbox <- clusterOutbound{channel: channel, payload: encodeClusterMessage(message)}:
+	default:
+		helpers.Log().Warn("Cluster outbox is full, dropping message", "kind", message.T)
+	}
+}
+
+// sendClusterState sends this node's Users and Rooms.
+func sendClusterState(kind string, channel string) {
+	users := make([]string, 0, UserCount())
+	for _, shard := range userShards {
+		shard.mux.Lock()
+		for name := range shard.users {
+			users = append(users, name)
+		}
+		shard.mux.Unlock()
+	}
+	roomsMux.Lock()
+	roomNames := make([]string, 0, len(rooms))
+	for name := range rooms {
+		roomNames = append(roomNames, name)
+	}
+	roomsMux.Unlock()
+	sendCluster(channel, clusterMessage{T: kind, US: users, RS: roomNames})
+}
+
+func encodeClusterMessage(message clusterMessage) []byte {
+	message.N, message.A = nodeName, nodeAddress
+	payload, err := json.Marshal(message)
+	if err != nil {
+		helpers.Log().Error("Couldn't encode cluster message", "kind", message.T, "error", err)
+		return nil
+	}
+	return payload
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   RECEIVING   /////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func receiveCluster(payload []byte) {
+	var message clusterMessage
+	if err := json.Unmarshal(payload, &message); err != nil {
+		helpers.Log().Warn("Invalid cluster message", "error", err)
+		return
+	} else if message.N == "" || message.N == nodeName {
+		// EVERY NODE GETS ITS OWN ANNOUNCEMENTS BACK
+		return
+	}
+
+	clusterMux.Lock()
+	if nodes == nil {
+		clusterMux.Unlock()
+		return
+	}
+	if message.T == clusterBye {
+		dropNode(message.N)
+		clusterMux.Unlock()
+		helpers.Log().Info("Node left the cluster", "node", message.N)
+		return
+	}
+	node := nodes[message.N]
+	if node == nil {
+		node = &clusterNode{}
+		nodes[message.N] = node
+		helpers.Log().Info("Node joined the cluster", "node", message.N, "address", message.A)
+	}
+	node.lastSeen = time.Now()
+	if message.A != "" {
+		node.address = message.A
+	}
+	switch message.T {
+	case clusterHello, clusterState:
+		dropNode(message.N)
+		nodes[message.N] = node
+		for _, userName := range message.US {
+			addRemoteUser(userName, message.N)
+		}
+		for _, roomName := range message.RS {
+			remoteRooms[roomName] = message.N
+		}
+	case clusterOnline:
+		addRemoteUser(message.U, message.N)
+	case clusterLogout:
+		if onNodes := remoteUsers[message.U]; onNodes != nil {
+			delete(onNodes, message.N)
+			if len(onNodes) == 0 {
+				delete(remoteUsers, message.U)
+			}
+		}
+	case clusterRoom:
+		remoteRooms[message.R] = message.N
+	case clusterNoRoom:
+		if remoteRooms[message.R] == message.N {
+			delete(remoteRooms, message.R)
+		}
+	}
+	clusterMux.Unlock()
+
+	// MESSAGES FOR THIS NODE'S USERS
+	switch message.T {
+	case clusterHello:
+		sendClusterState(clusterState, nodeChannel(message.N))
+	case clusterPM:
+		if user := findUser(message.U); user != nil {
+			user.mux.Lock()
+			for _, conn := range user.conns {
+				conn.send(privateMessage(message.F, message.U, message.M))
+			}
+			user.mux.Unlock()
+		}
+	case clusterKick:
+		if user := findUser(message.U); user != nil {
+			user.kick(errorLoggedElsewhere, errorLoggedElsewhere)
+		}
+	}
+}
+
+// addRemoteUser must lock clusterMux to use.
+func addRemoteUser(userName string, node string) {
+	if remoteUsers[userName] == nil {
+		remoteUsers[userName] = make(map[string]bool)
+	}
+	remoteUsers[userName][node] = true
+}
+
+// dropNode forgets a node, and its Users and Rooms. Must lock clusterMux to use.
+func dropNode(node string) {
+	delete(nodes, node)
+	for userName, onNodes := range remoteUsers {
+		if onNodes[node] {
+			delete(onNodes, node)
+			if len(onNodes) == 0 {
+				delete(remoteUsers, userName)
+			}
+		}
+	}
+	for roomName, roomNode := range remoteRooms {
+		if roomNode == node {
+			delete(remoteRooms, roomName)
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CLUSTER GOROUTINES   ////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// runClusterListener receives messages until the cluster stops, and reconnects to the pub/sub server when the connection is lost.
+func runClusterListener(current clusterBus) {
+	defer clusterListening.Done()
+	for {
+		err := current.listen(receiveCluster)
+		select {
+		case <-clusterStop:
+			return
+		default:
+		}
+		helpers.Log().Warn("Lost connection to the cluster", "error", err)
+		clusterMux.Lock()
+		bus = nil
+		clusterMux.Unlock()
+		current.close()
+
+		// TRY AGAIN, WAITING LONGER EACH TIME
+		gap := time.Second
+		for {
+			select {
+			case <-clusterStop:
+				return
+			case <-time.After(gap):
+			}
+			if current, err = dialClusterBus(clusterDriver, clusterAddress, clusterPassword, clusterChannels()); err == nil {
+				break
+			}
+			helpers.Log().Warn("Couldn't reconnect to the cluster", "error", err)
+			if gap *= 2; gap > clusterMaxReconnectGap {
+				gap = clusterMaxReconnectGap
+			}
+		}
+		clusterMux.Lock()
+		select {
+		case <-clusterStop:
+			// STOPPED WHILE CONNECTING
+			clusterMux.Unlock()
+			current.close()
+			return
+		default:
+		}
+		bus = current
+		clusterMux.Unlock()
+		helpers.Log().Info("Reconnected to the cluster")
+
+		// THE OTHER NODES COULD HAVE CHANGED IN THE MEANTIME
+		sendClusterState(clusterHello, clusterPrefix)
+	}
+}
+
+// runClusterOutbox sends the queued messages, one at a time, until the cluster stops.
+func runClusterOutbox() {
+	defer clusterDone.Done()
+	for {
+		select {
+		case out := <-clusterOutbox:
+			publishCluster(out)
+		case <-clusterStop:
+			// SEND WHAT'S LEFT, LIKE THE GOODBYE
+			for {
+				select {
+				case out := <-clusterOutbox:
+					publishCluster(out)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func publishCluster(out clusterOutbound) {
+	if out.payload == nil {
+		return
+	}
+	clusterMux.Lock()
+	current := bus
+	clusterMux.Unlock()
+	if current == nil {
+		// RECONNECTING - THE HELLO AFTER RECONNECTING HAS THE LATEST STATE
+		return
+	}
+	if err := current.publish(out.channel, out.payload); err != nil {
+		helpers.Log().Warn("Couldn't send cluster message", "error", err)
+	}
+}
+
+// runClusterHeartbeat tells the other nodes this one is still there, and forgets the nodes that missed three heartbeats.
+func runClusterHeartbeat() {
+	defer clusterDone.Done()
+	ticker := time.NewTicker(clusterHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-clusterStop:
+			return
+		case <-ticker.C:
+		}
+		sendCluster(clusterPrefix, clusterMessage{T: clusterBeat})
+		clusterMux.Lock()
+		for name, node := range nodes {
+			if time.Since(node.lastSeen) > clusterHeartbeat*3 {
+				dropNode(name)
+				helpers.Log().Warn("Node stopped responding, removed it from the cluster", "node", name)
+			}
+		}
+		clusterMux.Unlock()
+	}
+}
@@ -0,0 +1,298 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	clusterDialTimeout  = time.Second * 5
+	clusterWriteTimeout = time.Second * 5
+)
+
+// clusterBus is a connection to the pub/sub server the nodes of a cluster talk through.
+type clusterBus interface {
+	// listen calls receive with every message sent to the channels the bus subscribed to, until the connection is lost or closed.
+	listen(receive func(payload []byte)) error
+	publish(channel string, payload []byte) error
+	close() error
+}
+
+// dialClusterBus connects to the pub/sub server, and subscribes to the channels.
+func dialClusterBus(driver string, address string, password string, channels []string) (clusterBus, error) {
+	switch driver {
+	case ClusterDriverRedis:
+		return dialRedis(address, password, channels)
+	case ClusterDriverNATS:
+		return dialNATS(address, password, channels)
+	}
+	return nil, errors.New("Unknown cluster driver '" + driver + "'")
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   REDIS   /////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// redisBus uses Redis' pub/sub. A subscribed Redis connection can't publish, so it has one connection for each.
+type redisBus struct {
+	sub     net.Conn
+	subRead *bufio.Reader
+
+	pubMux  sync.Mutex // LOCKS pub AND pubRead, SO EVERY PUBLISH READS ITS OWN REPLY
+	pub     net.Conn
+	pubRead *bufio.Reader
+}
+
+func dialRedis(address string, password string, channels []string) (*redisBus, error) {
+	b := &redisBus{}
+	var err error
+	if b.sub, b.subRead, err = redisConnect(address, password); err != nil {
+		return nil, err
+	}
+	if b.pub, b.pubRead, err = redisConnect(address, password); err != nil {
+		b.sub.Close()
+		return nil, err
+	}
+	// REDIS CONFIRMS EACH CHANNEL
+	b.sub.SetDeadline(time.Now().Add(clusterDialTimeout))
+	if err = writeRESP(b.sub, append([]string{"SUBSCRIBE"}, channels...)...); err == nil {
+		for range channels {
+			if _, err = readRESP(b.subRead); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		b.close()
+		return nil, err
+	}
+	b.sub.SetDeadline(time.Time{})
+	return b, nil
+}
+
+func redisConnect(address string, password string) (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", address, clusterDialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	if password != "" {
+		conn.SetDeadline(time.Now().Add(clusterDialTimeout))
+		if err = writeRESP(conn, "AUTH", password); err == nil {
+			_, err = readRESP(reader)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+		conn.SetDeadline(time.Time{})
+	}
+	return conn, reader, nil
+}
+
+func (b *redisBus) listen(receive func(payload []byte)) error {
+	for {
+		reply, err := readRESP(b.subRead)
+		if err != nil {
+			return err
+		}
+		// ["message", channel, payload]
+		if parts, ok := reply.([]interface{}); ok && len(parts) == 3 {
+			if kind, _ := parts[0].([]byte); string(kind) == "message" {
+				if payload, ok := parts[2].([]byte); ok {
+					receive(payload)
+				}
+			}
+		}
+	}
+}
+
+func (b *redisBus) publish(channel string, payload []byte) error {
+	b.pubMux.Lock()
+	defer b.pubMux.Unlock()
+	b.pub.SetDeadline(time.Now().Add(clusterWriteTimeout))
+	if err := writeRESP(b.pub, "PUBLISH", channel, string(payload)); err != nil {
+		return err
+	}
+	_, err := readRESP(b.pubRead)
+	return err
+}
+
+func (b *redisBus) close() error {
+	b.pub.Close()
+	return b.sub.Close()
+}
+
+// writeRESP writes a Redis command.
+func writeRESP(w io.Writer, args ...string) error {
+	var command strings.Builder
+	command.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		command.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	_, err := io.WriteString(w, command.String())
+	return err
+}
+
+// readRESP reads a Redis reply. Simple strings and integers are strings, bulk strings are []byte, and arrays are []interface{}.
+// Error replies are returned as errors.
+func readRESP(r *bufio.Reader) (interface{}, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	} else if len(line) == 0 {
+		return nil, errors.New("Empty reply from Redis")
+	}
+	switch line[0] {
+	case '+', ':':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New("Redis: " + line[1:])
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		} else if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		parts := make([]interface{}, count)
+		for i := range parts {
+			if parts[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return parts, nil
+	}
+	return nil, errors.New("Unexpected reply from Redis: " + line)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   NATS   //////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// natsBus uses NATS' text protocol over one connection.
+type natsBus struct {
+	conn     net.Conn
+	read     *bufio.Reader
+	writeMux sync.Mutex
+}
+
+func dialNATS(address string, password string, channels []string) (*natsBus, error) {
+	conn, err := net.DialTimeout("tcp", address, clusterDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	b := &natsBus{conn: conn, read: bufio.NewReader(conn)}
+	conn.SetDeadline(time.Now().Add(clusterDialTimeout))
+
+	// THE SERVER STARTS WITH ITS INFO, THEN THE PING IS ANSWERED ONCE THE CONNECT AND SUBSCRIPTIONS WENT THROUGH
+	if _, err = readLine(b.read); err == nil {
+		options := map[string]interface{}{"verbose": false, "pedantic": false, "name": "gopher-game-server"}
+		if password != "" {
+			options["auth_token"] = password
+		}
+		optionsJSON, _ := json.Marshal(options)
+		var command strings.Builder
+		command.WriteString("CONNECT " + string(optionsJSON) + "\r\n")
+		for i, channel := range channels {
+			command.WriteString("SUB " + channel + " " + strconv.Itoa(i+1) + "\r\n")
+		}
+		command.WriteString("PING\r\n")
+		if _, err = io.WriteString(conn, command.String()); err == nil {
+			err = b.waitForPong()
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return b, nil
+}
+
+func (b *natsBus) waitForPong() error {
+	for {
+		line, err := readLine(b.read)
+		if err != nil {
+			return err
+		} else if line == "PONG" {
+			return nil
+		} else if strings.HasPrefix(line, "-ERR") {
+			return errors.New("NATS: " + strings.TrimSpace(line[4:]))
+		}
+	}
+}
+
+func (b *natsBus) listen(receive func(payload []byte)) error {
+	for {
+		line, err := readLine(b.read)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				return errors.New("Unexpected message from NATS: " + line)
+			}
+			size, sizeErr := strconv.Atoi(fields[len(fields)-1])
+			if sizeErr != nil {
+				return sizeErr
+			}
+			data := make([]byte, size+2)
+			if _, err := io.ReadFull(b.read, data); err != nil {
+				return err
+			}
+			receive(data[:size])
+		case line == "PING":
+			if err := b.write("PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("NATS: " + strings.TrimSpace(line[4:]))
+		}
+	}
+}
+
+func (b *natsBus) publish(channel string, payload []byte) error {
+	return b.write("PUB " + channel + " " + strconv.Itoa(len(payload)) + "\r\n" + string(payload) + "\r\n")
+}
+
+func (b *natsBus) write(command string) error {
+	b.writeMux.Lock()
+	defer b.writeMux.Unlock()
+	b.conn.SetWriteDeadline(time.Now().Add(clusterWriteTimeout))
+	_, err := io.WriteString(b.conn, command)
+	return err
+}
+
+func (b *natsBus) close() error {
+	return b.conn.Close()
+}
+
+// readLine reads a line ending with "\r\n", without it.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBus is a clusterBus that keeps what it publishes.
+type testBus struct {
+	published chan clusterOutbound
+	closed    chan struct{}
+}
+
+func newTestBus() *testBus {
+	return &testBus{published: make(chan clusterOutbound, 100), closed: make(chan struct{})}
+}
+
+func (b *testBus) listen(receive func(payload []byte)) error {
+	<-b.closed
+	return nil
+}
+
+func (b *testBus) publish(channel string, payload []byte) error {
+	b.published <- clusterOutbound{channel: channel, payload: payload}
+	return nil
+}
+
+func (b *testBus) close() error {
+	select {
+	case <-b.closed:
+	default:
+		close(b.closed)
+	}
+	return nil
+}
+
+// next gets the next message of a kind the node published, skipping the others.
+func (b *testBus) next(t *testing.T, kind string) (string, clusterMessage) {
+	for {
+		select {
+		case out := <-b.published:
+			var message clusterMessage
+			json.Unmarshal(out.payload, &message)
+			if message.T == kind {
+				return out.channel, message
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("Expected a '" + kind + "' message")
+		}
+	}
+}
+
+func receiveTest(message clusterMessage) {
+	payload, _ := json.Marshal(message)
+	receiveCluster(payload)
+}
+
+func TestCluster(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	clusterPrefix, nodeName, nodeAddress, clusterHeartbeat = "gopher", "nodeA", "ws://a/ws", time.Hour
+	bus := newTestBus()
+	runCluster(bus)
+	defer StopCluster()
+	if !ClusterEnabled() || NodeName() != "nodeA" {
+		t.Fatal("Expected the cluster to be running as nodeA")
+	}
+	if channel, _ := bus.next(t, clusterHello); channel != "gopher" {
+		t.Error("Expected the hello to be sent to every node, got", channel)
+	}
+
+	// Another node says hello, and gets this node's state back
+	receiveTest(clusterMessage{T: clusterHello, N: "nodeB", A: "ws://b/ws", US: []string{"clusterRemote"}, RS: []string{"clusterRoom"}})
+	if channel, _ := bus.next(t, clusterState); channel != "gopher.nodeB" {
+		t.Error("Expected the state to be sent to nodeB, got", channel)
+	}
+	if node, online := UserNode("clusterRemote"); !online || node != "nodeB" {
+		t.Error("Expected clusterRemote to be on nodeB, got", node, online)
+	}
+	if node, address, ok := RoomNode("clusterRoom"); !ok || node != "nodeB" || address != "ws://b/ws" {
+		t.Error("Expected clusterRoom to be on nodeB, got", node, address, ok)
+	}
+	if _, err := NewRoom("clusterRoom", "test", false, 0, ""); err == nil {
+		t.Error("Room names should be unique across the cluster")
+	}
+	if nodes := ClusterNodes(); len(nodes) != 2 || nodes["nodeB"] != "ws://b/ws" {
+		t.Error("Expected nodeA and nodeB, got", nodes)
+	}
+
+	// Local Users are announced, and their private messages reach other nodes
+	user, _ := testLogin(t, "clusterLocal")
+	if _, message := bus.next(t, clusterOnline); message.U != "clusterLocal" || message.N != "nodeA" {
+		t.Error("Expected clusterLocal to be announced, got", message)
+	}
+	user.PrivateMessage("clusterRemote", "hi")
+	if channel, message := bus.next(t, clusterPM); channel != "gopher.nodeB" || message.F != "clusterLocal" ||
+		message.U != "clusterRemote" || message.M != "hi" {
+
+		t.Error("Expected the private message to go to nodeB, got", channel, message)
+	}
+	user.Kick()
+	if _, message := bus.next(t, clusterLogout); message.U != "clusterLocal" {
+		t.Error("Expected clusterLocal to log out, got", message)
+	}
+
+	// Logging out and leaving
+	receiveTest(clusterMessage{T: clusterLogout, N: "nodeB", U: "clusterRemote"})
+	if IsUserOnline("clusterRemote") {
+		t.Error("clusterRemote logged out")
+	}
+	receiveTest(clusterMessage{T: clusterBye, N: "nodeB"})
+	if _, _, ok := RoomNode("clusterRoom"); ok {
+		t.Error("nodeB's Rooms should be gone after it left")
+	}
+
+	StopCluster()
+	if _, message := bus.next(t, clusterBye); message.N != "nodeA" {
+		t.Error("Expected a goodbye from nodeA, got", message)
+	}
+	if ClusterEnabled() {
+		t.Error("Expected the cluster to be stopped")
+	}
+}
+
+func TestReadRESP(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("*3\r\n$7\r\nmessage\r\n$6\r\ngopher\r\n$5\r\nhe\r\no\r\n-ERR wrong\r\n"))
+	reply, err := readRESP(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, ok := reply.([]interface{})
+	if !ok || len(parts) != 3 || string(parts[0].([]byte)) != "message" || string(parts[2].([]byte)) != "he\r\no" {
+		t.Error("Expected a message with a payload that has a line break, got", reply)
+	}
+	if _, err := readRESP(reader); err == nil || !strings.Contains(err.Error(), "wrong") {
+		t.Error("Expected the error reply, got", err)
+	}
+}
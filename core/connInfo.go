@@ -0,0 +1,77 @@
+package core
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+)
+
+// connInfo is what the server knows about a socket from its connection request, before it logs in.
+type connInfo struct {
+	ip       string
+	version  string // THE PROTOCOL VERSION THE CLIENT CONNECTED WITH
+	locale   string // THE CLIENT'S LOCALE, OR "" FOR THE DefaultLocale
+	metadata map[string]interface{}
+}
+
+var (
+	// *websocket.Conn -> *connInfo
+	connInfos sync.Map
+)
+
+// SetConnInfo is only for internal Gopher Game Server mechanics.
+func SetConnInfo(socket *websocket.Conn, ip string, version string, locale string, metadata map[string]interface{}) {
+	connInfos.Store(socket, &connInfo{ip: ip, version: version, locale: helpers.NormalizeLocale(locale), metadata: metadata})
+}
+
+// SetConnLocale is only for internal Gopher Game Server mechanics.
+func SetConnLocale(socket *websocket.Conn, locale string) {
+	// REPLACE THE connInfo - IT'S READ WITHOUT LOCKING
+	info := *socketConnInfo(socket)
+	info.locale = helpers.NormalizeLocale(locale)
+	connInfos.Store(socket, &info)
+}
+
+// ConnLocale is only for internal Gopher Game Server mechanics.
+func ConnLocale(socket *websocket.Conn) string {
+	return socketConnInfo(socket).locale
+}
+
+// ForgetConnInfo is only for internal Gopher Game Server mechanics.
+func ForgetConnInfo(socket *websocket.Conn) {
+	connInfos.Delete(socket)
+}
+
+// socketConnInfo gets the connInfo of a socket. Sockets the server didn't accept itself, like in tests, have an empty one that
+// speaks the current protocol.
+func socketConnInfo(socket *websocket.Conn) *connInfo {
+	if info, ok := connInfos.Load(socket); ok {
+		return info.(*connInfo)
+	}
+	return &connInfo{version: helpers.ProtocolVersion}
+}
+
+// IP gets the IP address of the client the User logged in from, behind any TrustedProxies in ServerSettings. With MultiConnect
+// enabled, it's the IP address of the User's latest connection.
+func (u *User) IP() string {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return u.ip
+}
+
+// ClientVersion gets the protocol version of the client API the User logged in with, like "1.1", so features can be kept from
+// clients that are too old for them. Compare it with helpers.CompareVersions(). With MultiConnect enabled, it's the version of
+// the User's latest connection.
+func (u *User) ClientVersion() string {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return u.clientVersion
+}
+
+// Locale gets the locale the User's client sent when it connected or logged in, like "pt-br", or "" when it didn't send one.
+// With MultiConnect enabled, it's the locale of the User's latest connection. See helpers.SetTranslations().
+func (u *User) Locale() string {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return u.locale
+}
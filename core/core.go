@@ -21,6 +21,7 @@ package core
 
 import (
 	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync/atomic"
 )
 
 var (
@@ -34,16 +35,20 @@ var (
 	multiConnect      bool
 	maxUserConns      uint8
 	deleteRoomOnLeave bool = true
+	chatHistoryLen    int
 )
 
-// RoomRecoveryState is used internally for persisting room states on shutdown.
+// RoomRecoveryState is used internally for persisting room states in recovery snapshots.
 type RoomRecoveryState struct {
-	T string                 // rType
-	P bool                   // private
-	O string                 // owner
-	M int                    // maxUsers
-	I []string               // inviteList
-	V map[string]interface{} // vars
+	T  string                        // rType
+	P  bool                          // private
+	O  string                        // owner
+	M  int                           // maxUsers
+	I  []string                      // inviteList
+	V  map[string]interface{}        // vars
+	TM map[string]TimerRecoveryState // repeating timers, since version 3
+	SS map[string]interface{}        // synced state, since version 4
+	SQ uint64                        // synced state sequence number, since version 4
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -52,13 +57,11 @@ type RoomRecoveryState struct {
 
 // SetServerStarted is for Gopher Game Server internal mechanics only.
 func SetServerStarted(val bool) {
-	if !serverStarted {
-		serverStarted = val
-	}
+	serverStarted = val
 }
 
 // SettingsSet is for Gopher Game Server internal mechanics only.
-func SettingsSet(kickDups bool, name string, deleteOnLeave bool, sqlFeat bool, remMe bool, multiConn bool, maxConns uint8) {
+func SettingsSet(kickDups bool, name string, deleteOnLeave bool, sqlFeat bool, remMe bool, multiConn bool, maxConns uint8, historyLen int) {
 	if !serverStarted {
 		kickOnLogin = kickDups
 		serverName = name
@@ -67,6 +70,7 @@ func SettingsSet(kickDups bool, name string, deleteOnLeave bool, sqlFeat bool, r
 		multiConnect = multiConn
 		maxUserConns = maxConns
 		deleteRoomOnLeave = deleteOnLeave
+		chatHistoryLen = historyLen
 	}
 }
 
@@ -81,32 +85,38 @@ func Pause() {
 
 		//
 		clientResp := helpers.MakeClientResponse(helpers.ClientActionLogout, nil, helpers.NoError())
-		usersMux.Lock()
-		for _, user := range users {
-			user.mux.Lock()
-			for connID, conn := range user.conns {
-				//REMOVE CONNECTION FROM THEIR ROOM
-				currRoom := conn.room
-				if currRoom != nil && currRoom.Name() != "" {
-					user.mux.Unlock()
-					currRoom.RemoveUser(user, connID)
-					user.mux.Lock()
-				}
+		for _, shard := range userShards {
+			shard.mux.Lock()
+			for _, user := range shard.users {
+				user.mux.Lock()
+				for connID, conn := range user.conns {
+					//REMOVE CONNECTION FROM THEIR ROOM
+					currRoom := conn.room
+					if currRoom != nil && currRoom.Name() != "" {
+						user.mux.Unlock()
+						currRoom.removeUser(user, connID, LeaveReasonLogout)
+						user.mux.Lock()
+					}
 
-				//LOG CONNECTION OUT
-				conn.clientMux.Lock()
-				if *(conn.user) != nil {
-					*(conn.user) = nil
-				}
-				conn.clientMux.Unlock()
+					//LOG CONNECTION OUT
+					conn.clientMux.Lock()
+					if *(conn.user) != nil {
+						*(conn.user) = nil
+					}
+					conn.clientMux.Unlock()
 
-				//SEND LOG OUT MESSAGE
-				conn.socket.WriteJSON(clientResp)
+					//SEND LOG OUT MESSAGE
+					conn.send(clientResp)
+				}
+				user.mux.Unlock()
 			}
-			user.mux.Unlock()
+			shard.users = make(map[string]*User)
+			shard.mux.Unlock()
 		}
-		users = make(map[string]*User)
-		usersMux.Unlock()
+		atomic.StoreInt64(&userCount, 0)
+		atomic.StoreInt64(&guestCount, 0)
+		atomic.StoreInt64(&registeredCount, 0)
+		clusterResetUsers()
 	}
 }
 
@@ -127,13 +137,22 @@ func GetRoomsState() map[string]RoomRecoveryState {
 	roomsMux.Lock()
 	for _, room := range rooms {
 		room.mux.Lock()
+		vars := make(map[string]interface{})
+		for key, val := range room.vars {
+			vars[key] = val
+		}
+		inviteList := make([]string, len(room.inviteList))
+		copy(inviteList, room.inviteList)
 		state[room.name] = RoomRecoveryState{
-			T: room.rType,
-			P: room.private,
-			O: room.owner,
-			M: room.maxUsers,
-			I: room.inviteList,
-			V: room.vars,
+			T:  room.rType,
+			P:  room.private,
+			O:  room.owner,
+			M:  room.maxUsers,
+			I:  inviteList,
+			V:  vars,
+			TM: room.getTimersState(),
+			SS: room.getSyncedState(),
+			SQ: room.syncSeq,
 		}
 		room.mux.Unlock()
 	}
@@ -0,0 +1,282 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event is something that happened in a Room of a RoomType that records its events. See *RoomType.EnableRecordEvents().
+type Event struct {
+	Seq  uint64      // The order of the event in the Room, starting at 1. A gap means events were dropped
+	Time time.Time   // When it happened
+	Type string      // What happened, like EventJoin
+	User string      // The User that did it, or "" for the server
+	Data interface{} // What it's about, like the client action and its parameters
+}
+
+// These are the types of events Rooms record. You can record your own with *Room.RecordEvent().
+const (
+	EventCreate        = "create" // The Room was made
+	EventDelete        = "delete" // The Room was deleted. It's the Room's last event
+	EventJoin          = "join"   // A User's connection joined. Data has the connection ID "c", and "s" is true when spectating
+	EventLeave         = "leave"  // A User's connection left. Data has the connection ID "c", and the leave reason "r"
+	EventClientAction  = "action" // A User in the Room sent a client action. Data has the action "a", and its parameters "p"
+	EventChat          = "chat"   // A chat message. Data has the message "m"
+	EventServerMessage = "server" // A server message. Data has the message "m", its sub-type "s", and the recipients "r" or audience "a"
+	EventDataMessage   = "data"   // A data message. Data has the message "m", and the recipients "r" or audience "a"
+	EventVariables     = "vars"   // Room variables were set. Data has the variables that changed
+	EventTimer         = "timer"  // A timer went off. Data has its name "n"
+)
+
+// EventSink is where the events of Rooms that record them are written, instead of the files in the RecoveryLocation. Set it with
+// SetEventSink(). Each Room's events are written in order by one goroutine, but different Rooms can be written at the same time.
+type EventSink interface {
+	WriteEvents(room string, events []Event) error // Writes the next events of a Room
+	CloseEvents(room string) error                 // Called once a Room's last events are written, when it's deleted or the server shuts down
+}
+
+const (
+	eventQueueSize  = 1024 // THE MOST EVENTS OF A Room WAITING TO BE WRITTEN
+	eventMemory     = 1000 // THE LATEST EVENTS OF A Room KEPT FOR *Room.EventLog()
+	eventLogBackups = 3    // THE ROTATED FILES KEPT OF EACH Room'S EVENT LOG
+
+	eventLogFilePrefix = "Gopher Events "
+)
+
+var (
+	eventSink       EventSink // WHERE EVENTS ARE WRITTEN, OR nil TO ONLY KEEP THEM IN MEMORY
+	customEventSink bool
+
+	//eventWriters TRACKS THE GOROUTINES WRITING EVENT LOGS, SO SHUT DOWN CAN WAIT FOR THEM
+	eventWriters sync.WaitGroup
+
+	errNoEventLog = errors.New("The room's type doesn't record events")
+)
+
+// SetEventSink sets where the events of Rooms that record them are written, instead of the files in the RecoveryLocation. You
+// can only set it before starting the server.
+func SetEventSink(sink EventSink) error {
+	if serverStarted {
+		return errors.New("You can't set the event sink once the server has started")
+	} else if sink == nil {
+		return errors.New("core.SetEventSink() requires an EventSink")
+	}
+	eventSink = sink
+	customEventSink = true
+	return nil
+}
+
+// SetEventLog is only for internal Gopher Game Server mechanics.
+func SetEventLog(folder string, maxSize int64) {
+	if !serverStarted && !customEventSink {
+		eventSink = nil
+		if folder != "" {
+			eventSink = &fileEventSink{folder: folder, maxSize: maxSize}
+		}
+	}
+}
+
+// CloseEventLogs is only for internal Gopher Game Server mechanics.
+func CloseEventLogs(ctx context.Context) bool {
+	for _, room := range GetRooms() {
+		room.events.finish("")
+	}
+	done := make(chan bool)
+	go func() {
+		eventWriters.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   RECORDING EVENTS   //////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// eventLog records the events of a Room. They're written by their own goroutine, so recording an event never waits on the
+// EventSink. When the Room records events faster than they can be written, the ones that don't fit are dropped.
+type eventLog struct {
+	room  string
+	queue chan Event // nil WHEN THERE'S NO EventSink
+
+	//mux LOCKS ALL ITEMS BELOW
+	mux     sync.Mutex
+	seq     uint64
+	recent  []Event
+	closed  bool
+	dropped uint64
+}
+
+func newEventLog(room string) *eventLog {
+	l := &eventLog{room: room}
+	if eventSink != nil {
+		l.queue = make(chan Event, eventQueueSize)
+		eventWriters.Add(1)
+		go l.write(eventSink)
+	}
+	return l
+}
+
+// record records an event. Does nothing for a Room that doesn't record events.
+func (l *eventLog) record(eventType string, userName string, data interface{}) {
+	if l == nil {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if l.closed {
+		return
+	}
+	l.seq++
+	event := Event{Seq: l.seq, Time: time.Now(), Type: eventType, User: userName, Data: data}
+	if len(l.recent) == eventMemory {
+		l.recent = l.recent[1:]
+	}
+	l.recent = append(l.recent, event)
+	if l.queue == nil {
+		return
+	}
+	select {
+	case l.queue <- event:
+	default:
+		l.dropped++
+		if l.dropped == 1 {
+			helpers.Log().Warn("Room events are recorded faster than they can be written, dropping some", "room", l.room)
+		}
+	}
+}
+
+// finish records the Room's last event, unless it's "", and stops recording. The writer stops once everything is written.
+func (l *eventLog) finish(lastEvent string) {
+	if l == nil {
+		return
+	}
+	if lastEvent != "" {
+		l.record(lastEvent, "", nil)
+	}
+	l.mux.Lock()
+	if !l.closed {
+		l.closed = true
+		if l.queue != nil {
+			close(l.queue)
+		}
+	}
+	l.mux.Unlock()
+}
+
+// write writes the events to the EventSink as they're recorded. The events already waiting are written together.
+func (l *eventLog) write(sink EventSink) {
+	defer eventWriters.Done()
+	for event := range l.queue {
+		batch := []Event{event}
+	waiting:
+		for len(batch) < eventQueueSize {
+			select {
+			case next, ok := <-l.queue:
+				if !ok {
+					break waiting
+				}
+				batch = append(batch, next)
+			default:
+				break waiting
+			}
+		}
+		var err error
+		helpers.Protect("event sink", func() { err = sink.WriteEvents(l.room, batch) }, "room", l.room)
+		if err != nil {
+			helpers.Log().Error("Error writing room events", "room", l.room, "error", err)
+		}
+	}
+	var err error
+	helpers.Protect("event sink", func() { err = sink.CloseEvents(l.room) }, "room", l.room)
+	if err != nil {
+		helpers.Log().Error("Error closing room events", "room", l.room, "error", err)
+	}
+}
+
+// RecordEvent records an event of your own in the Room's event log, like a move in the game, when its RoomType records events.
+// The data is written as JSON, so don't change it after recording it.
+func (r *Room) RecordEvent(eventType string, userName string, data interface{}) {
+	r.events.record(eventType, userName, data)
+}
+
+// EventLog gets the events the Room recorded after the time, oldest first, so admin tools can see what's going on in it. Only
+// the latest 1000 are kept in memory. The rest are in the EventSink, or the Room's event log files in the RecoveryLocation.
+// Returns an error when the Room's RoomType doesn't record events.
+func (r *Room) EventLog(since time.Time) ([]Event, error) {
+	if r.events == nil {
+		return nil, errNoEventLog
+	}
+	r.events.mux.Lock()
+	defer r.events.mux.Unlock()
+	events := []Event{}
+	for _, event := range r.events.recent {
+		if event.Time.After(since) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   EVENT LOG FILES   ///////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// fileEventSink writes each Room's events to its own file in the folder, one JSON Event per line. A file bigger than maxSize
+// is rotated, keeping eventLogBackups old files like "Gopher Events lobby.jsonl.1".
+type fileEventSink struct {
+	folder  string
+	maxSize int64
+	mux     sync.Mutex
+}
+
+func (s *fileEventSink) WriteEvents(room string, events []Event) error {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			// KEEP THE EVENT'S PLACE IN THE LOG
+			event.Data = "Can't write the event's data: " + err.Error()
+			encoder.Encode(event)
+		}
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	file := filepath.Join(s.folder, eventLogFilePrefix+url.QueryEscape(room)+".jsonl")
+	if info, err := os.Stat(file); err == nil && s.maxSize > 0 && info.Size() > 0 && info.Size()+int64(data.Len()) > s.maxSize {
+		os.Remove(file + "." + strconv.Itoa(eventLogBackups))
+		for i := eventLogBackups - 1; i > 0; i-- {
+			os.Rename(file+"."+strconv.Itoa(i), file+"."+strconv.Itoa(i+1))
+		}
+		if err := os.Rename(file, file+".1"); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fileEventSink) CloseEvents(room string) error {
+	return nil
+}
@@ -0,0 +1,133 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySink keeps the events written to it, and closes closed when a Room's events are closed
+type memorySink struct {
+	mux    sync.Mutex
+	events []Event
+	closed chan string
+}
+
+func (s *memorySink) WriteEvents(room string, events []Event) error {
+	s.mux.Lock()
+	s.events = append(s.events, events...)
+	s.mux.Unlock()
+	return nil
+}
+
+func (s *memorySink) CloseEvents(room string) error {
+	s.closed <- room
+	return nil
+}
+
+func TestEventLog(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	sink := &memorySink{closed: make(chan string, 1)}
+	SetEventSink(sink)
+	defer func() {
+		eventSink = nil
+		customEventSink = false
+	}()
+	NewRoomType("eventLogTest", false).EnableRecordEvents()
+
+	room, _ := NewRoom("eventLogRoom", "eventLogTest", false, 0, "")
+	if _, err := room.EventLog(time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	user, _ := testLogin(t, "eventLogUser")
+	defer user.Kick()
+	if err := user.Join(room, ""); err != nil {
+		t.Fatal(err)
+	}
+	room.SetVariable("turn", 1)
+	room.ChatMessage("eventLogUser", "gg")
+	room.DataMessage("board", nil)
+	room.RecordEvent("move", "eventLogUser", "e4")
+	user.Leave("")
+
+	// The latest events can be looked at while the Room is going
+	events, _ := room.EventLog(start)
+	want := []string{EventJoin, EventVariables, EventChat, EventDataMessage, "move", EventLeave}
+	if len(events) != len(want) {
+		t.Fatal("Expected", len(want), "events since the Room was made, got", events)
+	}
+	for i, event := range events {
+		if event.Type != want[i] || event.Seq != uint64(i+2) {
+			t.Errorf("Expected event %d to be %q, got %+v", i+2, want[i], event)
+		}
+	}
+	if events[4].User != "eventLogUser" || events[4].Data != "e4" {
+		t.Error("Expected the recorded event's user and data, got", events[4])
+	}
+
+	// Deleting the Room writes the rest, then closes its events
+	room.Delete()
+	select {
+	case closed := <-sink.closed:
+		if closed != "eventLogRoom" {
+			t.Error("Expected the Room's events to be closed, got", closed)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Expected the Room's events to be closed when it's deleted")
+	}
+	sink.mux.Lock()
+	defer sink.mux.Unlock()
+	if len(sink.events) != len(want)+2 || sink.events[0].Type != EventCreate || sink.events[len(sink.events)-1].Type != EventDelete {
+		t.Error("Expected every event to be written, from the Room being made to being deleted, got", sink.events)
+	}
+
+	// Rooms of other RoomTypes don't record events
+	other, _ := NewRoom("noEventLogRoom", "test", false, 0, "")
+	defer other.Delete()
+	if _, err := other.EventLog(time.Time{}); err == nil {
+		t.Error("Expected an error getting the events of a Room that doesn't record them")
+	}
+}
+
+func TestEventLogFiles(t *testing.T) {
+	folder := t.TempDir()
+	SetEventLog(folder, 1024)
+	defer SetEventLog("", 0)
+	NewRoomType("eventFileTest", false).EnableRecordEvents()
+
+	room, _ := NewRoom("event/file room", "eventFileTest", false, 0, "")
+	for i := 0; i < 100; i++ {
+		room.RecordEvent("tick", "", i)
+		time.Sleep(time.Millisecond)
+	}
+	room.Delete()
+	if !CloseEventLogs(context.Background()) {
+		t.Fatal("Expected the event logs to be written")
+	}
+
+	// The log is rotated, and the newest events are in the newest file
+	files, _ := filepath.Glob(filepath.Join(folder, eventLogFilePrefix+"*"))
+	if len(files) != eventLogBackups+1 {
+		t.Fatal("Expected the log and", eventLogBackups, "rotated files, got", files)
+	}
+	f, err := os.Open(filepath.Join(folder, eventLogFilePrefix+"event%2Ffile+room.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var last Event
+	for lines := bufio.NewScanner(f); lines.Scan(); {
+		if err := json.Unmarshal(lines.Bytes(), &last); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if last.Type != EventDelete || last.Seq != 102 {
+		t.Error("Expected the Room's last event to be its deletion, got", last)
+	}
+}
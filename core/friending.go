@@ -4,6 +4,23 @@ import (
 	"errors"
 	"github.com/hewiefreeman/GopherGameServer/database"
 	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sort"
+	"sync"
+)
+
+// Friend represents one of a User's friends, as returned by *User.Friends().
+type Friend struct {
+	Name          string // The friend's User name
+	DatabaseID    int    // The friend's index on the database, or -1 when the SQL features are disabled
+	RequestStatus int    // One of database.FriendStatusRequested, database.FriendStatusPending, or database.FriendStatusAccepted
+	Online        bool   // True if the friend is logged in
+	Status        int    // The friend's User status, like StatusAvailable. StatusOffline when they are not logged in
+}
+
+var (
+	// Friend lists are kept here when the SQL features are disabled, and last until the server shuts down.
+	memFriends    map[string]map[string]int = make(map[string]map[string]int) // userName -> friendName -> request status
+	memFriendsMux sync.Mutex
 )
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -12,22 +29,15 @@ import (
 
 // FriendRequest sends a friend request to another User by their name.
 func (u *User) FriendRequest(friendName string) error {
-	if _, ok := u.friends[friendName]; ok {
+	if friendName == u.Name() {
+		return errors.New("You cannot request yourself as a friend")
+	} else if _, ok := u.getFriend(friendName); ok {
 		return errors.New("The user '" + friendName + "' cannot be requested as a friend")
 	}
 	//CHECK IF FRIEND IS ONLINE & GET DATABASE ID
-	friend, friendErr := GetUser(friendName)
-	var friendOnline bool = false
-	var friendID int
+	friend, friendOnline, friendID, friendErr := findFriend(friendName)
 	if friendErr != nil {
-		//GET FRIEND'S DATABASE ID FROM database PACKAGE
-		friendID, friendErr = database.GetUserDatabaseIndex(friendName)
-		if friendErr != nil {
-			return errors.New("The user '" + friendName + "' does not exist")
-		}
-	} else {
-		friendID = friend.databaseID
-		friendOnline = true
+		return friendErr
 	}
 
 	//ADD REQUESTED FRIEND FOR USER
@@ -38,26 +48,30 @@ func (u *User) FriendRequest(friendName string) error {
 	//ADD REQUESTED FRIEND FOR FRIEND
 	if friendOnline {
 		friend.mux.Lock()
-		friend.friends[u.name] = database.NewFriend(u.name, u.databaseID, database.FriendStatusRequested)
+		friend.friends[u.Name()] = database.NewFriend(u.Name(), u.databaseID, database.FriendStatusRequested)
 		friend.mux.Unlock()
 	}
 
 	//MAKE THE FRIEND REQUEST ON DATABASE
-	friendingErr := database.FriendRequest(u.databaseID, friendID)
-	if friendingErr != nil {
-		return errors.New("Unexpected friend error")
+	if sqlFeatures {
+		if friendingErr := database.FriendRequest(u.databaseID, friendID); friendingErr != nil {
+			return errors.New("Unexpected friend error")
+		}
+	} else {
+		storeFriend(u.Name(), friendName, database.FriendStatusPending)
+		storeFriend(friendName, u.Name(), database.FriendStatusRequested)
 	}
 
 	//SEND A FRIEND REQUEST TO THE USER IF THEY ARE ONLINE
 	if friendOnline {
 		message := map[string]map[string]interface{}{
 			helpers.ServerActionFriendRequest: {
-				"n": u.name,
+				"n": u.Name(),
 			},
 		}
 		friend.mux.Lock()
 		for _, conn := range friend.conns {
-			(*conn).socket.WriteJSON(message)
+			(*conn).send(message)
 		}
 		friend.mux.Unlock()
 	}
@@ -66,7 +80,7 @@ func (u *User) FriendRequest(friendName string) error {
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionFriendRequest, friendName, helpers.NoError())
 	u.mux.Lock()
 	for _, conn := range u.conns {
-		(*conn).socket.WriteJSON(clientResp)
+		(*conn).send(clientResp)
 	}
 	u.mux.Unlock()
 
@@ -80,24 +94,15 @@ func (u *User) FriendRequest(friendName string) error {
 
 // AcceptFriendRequest accepts a friend request from another User by their name.
 func (u *User) AcceptFriendRequest(friendName string) error {
-	if _, ok := u.friends[friendName]; !ok {
+	if requestStatus, ok := u.getFriend(friendName); !ok {
 		return errors.New("The user '" + friendName + "' has not requested you as a friend")
-	} else if (u.friends[friendName]).RequestStatus() != database.FriendStatusRequested {
+	} else if requestStatus != database.FriendStatusRequested {
 		return errors.New("The user '" + friendName + "' cannot be accepted as a friend")
 	}
 	//CHECK IF FRIEND IS ONLINE & GET DATABASE ID
-	friend, friendErr := GetUser(friendName)
-	var friendOnline bool = false
-	var friendID int
+	friend, friendOnline, friendID, friendErr := findFriend(friendName)
 	if friendErr != nil {
-		//GET FRIEND'S DATABASE ID FROM database PACKAGE
-		friendID, friendErr = database.GetUserDatabaseIndex(friendName)
-		if friendErr != nil {
-			return errors.New("The user '" + friendName + "' does not exist")
-		}
-	} else {
-		friendID = friend.databaseID
-		friendOnline = true
+		return friendErr
 	}
 
 	//ACCEPT FRIEND FOR USER
@@ -107,13 +112,19 @@ func (u *User) AcceptFriendRequest(friendName string) error {
 	//ACCEPT FRIEND FOR FRIEND
 	if friendOnline {
 		friend.mux.Lock()
-		friend.friends[u.name].SetStatus(database.FriendStatusAccepted)
+		if f, ok := friend.friends[u.Name()]; ok {
+			f.SetStatus(database.FriendStatusAccepted)
+		}
 		friend.mux.Unlock()
 	}
 	//UPDATE FRIENDS ON DATABASE
-	friendingErr := database.FriendRequestAccepted(u.databaseID, friendID)
-	if friendingErr != nil {
-		return errors.New("Unexpected friend error")
+	if sqlFeatures {
+		if friendingErr := database.FriendRequestAccepted(u.databaseID, friendID); friendingErr != nil {
+			return errors.New("Unexpected friend error")
+		}
+	} else {
+		storeFriend(u.Name(), friendName, database.FriendStatusAccepted)
+		storeFriend(friendName, u.Name(), database.FriendStatusAccepted)
 	}
 
 	//SEND ACCEPT MESSAGE TO THE USER IF THEY ARE ONLINE
@@ -121,13 +132,13 @@ func (u *User) AcceptFriendRequest(friendName string) error {
 	if friendOnline {
 		message := map[string]map[string]interface{}{
 			helpers.ServerActionFriendAccept: {
-				"n": u.name,
-				"s": u.status,
+				"n": u.Name(),
+				"s": u.Status(),
 			},
 		}
 		friend.mux.Lock()
 		for _, conn := range friend.conns {
-			(*conn).socket.WriteJSON(message)
+			(*conn).send(message)
 		}
 		fStatus = friend.status
 		friend.mux.Unlock()
@@ -143,7 +154,7 @@ func (u *User) AcceptFriendRequest(friendName string) error {
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionAcceptFriend, responseMap, helpers.NoError())
 	u.mux.Lock()
 	for _, conn := range u.conns {
-		(*conn).socket.WriteJSON(clientResp)
+		(*conn).send(clientResp)
 	}
 	u.mux.Unlock()
 
@@ -157,24 +168,15 @@ func (u *User) AcceptFriendRequest(friendName string) error {
 
 // DeclineFriendRequest declines a friend request from another User by their name.
 func (u *User) DeclineFriendRequest(friendName string) error {
-	if _, ok := u.friends[friendName]; !ok {
+	if requestStatus, ok := u.getFriend(friendName); !ok {
 		return errors.New("The user '" + friendName + "' has not requested you as a friend")
-	} else if u.friends[friendName].RequestStatus() != database.FriendStatusRequested {
+	} else if requestStatus != database.FriendStatusRequested {
 		return errors.New("The user '" + friendName + "' cannot be declined as a friend")
 	}
 	//CHECK IF FRIEND IS ONLINE & GET DATABASE ID
-	friend, friendErr := GetUser(friendName)
-	var friendOnline bool = false
-	var friendID int
+	friend, friendOnline, friendID, friendErr := findFriend(friendName)
 	if friendErr != nil {
-		//GET FRIEND'S DATABASE ID FROM database PACKAGE
-		friendID, friendErr = database.GetUserDatabaseIndex(friendName)
-		if friendErr != nil {
-			return errors.New("The user '" + friendName + "' does not exist")
-		}
-	} else {
-		friendID = friend.databaseID
-		friendOnline = true
+		return friendErr
 	}
 
 	//DELETE THE Users' Friends
@@ -184,26 +186,30 @@ func (u *User) DeclineFriendRequest(friendName string) error {
 	//ACCEPT FRIEND FOR FRIEND
 	if friendOnline {
 		friend.mux.Lock()
-		delete(friend.friends, u.name)
+		delete(friend.friends, u.Name())
 		friend.mux.Unlock()
 	}
 
 	//UPDATE FRIENDS ON DATABASE
-	removeErr := database.RemoveFriend(u.databaseID, friendID)
-	if removeErr != nil {
-		return errors.New("Unexpected friend error")
+	if sqlFeatures {
+		if removeErr := database.RemoveFriend(u.databaseID, friendID); removeErr != nil {
+			return errors.New("Unexpected friend error")
+		}
+	} else {
+		unstoreFriend(u.Name(), friendName)
+		unstoreFriend(friendName, u.Name())
 	}
 
 	//SEND A FRIEND REQUEST TO THE USER IF THEY ARE ONLINE
 	if friendOnline {
 		message := map[string]map[string]interface{}{
 			helpers.ServerActionFriendRemove: {
-				"n": u.name,
+				"n": u.Name(),
 			},
 		}
 		friend.mux.Lock()
 		for _, conn := range friend.conns {
-			(*conn).socket.WriteJSON(message)
+			(*conn).send(message)
 		}
 		friend.mux.Unlock()
 	}
@@ -212,7 +218,7 @@ func (u *User) DeclineFriendRequest(friendName string) error {
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionDeclineFriend, friendName, helpers.NoError())
 	u.mux.Lock()
 	for _, conn := range u.conns {
-		(*conn).socket.WriteJSON(clientResp)
+		(*conn).send(clientResp)
 	}
 	u.mux.Unlock()
 
@@ -226,24 +232,15 @@ func (u *User) DeclineFriendRequest(friendName string) error {
 
 // RemoveFriend removes a friend from this this User and this User from the friend's Friend list.
 func (u *User) RemoveFriend(friendName string) error {
-	if _, ok := u.friends[friendName]; !ok {
+	if requestStatus, ok := u.getFriend(friendName); !ok {
 		return errors.New("The user '" + friendName + "' is not your friend")
-	} else if u.friends[friendName].RequestStatus() != database.FriendStatusAccepted {
+	} else if requestStatus != database.FriendStatusAccepted {
 		return errors.New("The user '" + friendName + "' cannot be removed as a friend")
 	}
 	//CHECK IF FRIEND IS ONLINE & GET DATABASE ID
-	friend, friendErr := GetUser(friendName)
-	var friendOnline bool = false
-	var friendID int
+	friend, friendOnline, friendID, friendErr := findFriend(friendName)
 	if friendErr != nil {
-		//GET FRIEND'S DATABASE ID FROM database PACKAGE
-		friendID, friendErr = database.GetUserDatabaseIndex(friendName)
-		if friendErr != nil {
-			return errors.New("The user '" + friendName + "' does not exist")
-		}
-	} else {
-		friendID = friend.databaseID
-		friendOnline = true
+		return friendErr
 	}
 
 	//DELETE THE Users' Friends
@@ -253,26 +250,30 @@ func (u *User) RemoveFriend(friendName string) error {
 	//ACCEPT FRIEND FOR FRIEND
 	if friendOnline {
 		friend.mux.Lock()
-		delete(friend.friends, u.name)
+		delete(friend.friends, u.Name())
 		friend.mux.Unlock()
 	}
 
 	//UPDATE FRIENDS ON DATABASE
-	removeErr := database.RemoveFriend(u.databaseID, friendID)
-	if removeErr != nil {
-		return errors.New("Unexpected friend error")
+	if sqlFeatures {
+		if removeErr := database.RemoveFriend(u.databaseID, friendID); removeErr != nil {
+			return errors.New("Unexpected friend error")
+		}
+	} else {
+		unstoreFriend(u.Name(), friendName)
+		unstoreFriend(friendName, u.Name())
 	}
 
 	//SEND A FRIEND REQUEST TO THE USER IF THEY ARE ONLINE
 	if friendOnline {
 		message := map[string]map[string]interface{}{
 			helpers.ServerActionFriendRemove: {
-				"n": u.name,
+				"n": u.Name(),
 			},
 		}
 		friend.mux.Lock()
 		for _, conn := range friend.conns {
-			(*conn).socket.WriteJSON(message)
+			(*conn).send(message)
 		}
 		friend.mux.Unlock()
 	}
@@ -281,7 +282,7 @@ func (u *User) RemoveFriend(friendName string) error {
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionRemoveFriend, friendName, helpers.NoError())
 	u.mux.Lock()
 	for _, conn := range u.conns {
-		(*conn).socket.WriteJSON(clientResp)
+		(*conn).send(clientResp)
 	}
 	u.mux.Unlock()
 
@@ -300,10 +301,98 @@ func (u *User) sendToFriends(message interface{}) {
 			if friendErr == nil {
 				friend.mux.Lock()
 				for _, friendConn := range friend.conns {
-					(*friendConn).socket.WriteJSON(message)
+					(*friendConn).send(message)
 				}
 				friend.mux.Unlock()
 			}
 		}
 	}
-}
\ No newline at end of file
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   Get friends   ///////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Friends gets the User's friend list, sorted by name. This includes friend requests the User has sent (database.FriendStatusPending)
+// and received (database.FriendStatusRequested). Without the SQL features, friend lists are kept in memory until the server shuts down.
+func (u *User) Friends() []Friend {
+	u.mux.Lock()
+	friends := make([]Friend, 0, len(u.friends))
+	for name, f := range u.friends {
+		friends = append(friends, Friend{Name: name, DatabaseID: f.DatabaseID(), RequestStatus: f.RequestStatus(), Status: StatusOffline})
+	}
+	u.mux.Unlock()
+
+	for i := range friends {
+		if friend, err := GetUser(friends[i].Name); err == nil {
+			friends[i].Online = true
+			friends[i].Status = friend.Status()
+		}
+	}
+	sort.Slice(friends, func(i, j int) bool { return friends[i].Name < friends[j].Name })
+
+	//
+	return friends
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   Friend helpers   ////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// getFriend gets the request status of one of the User's friends, and false if they are not on the User's friend list.
+func (u *User) getFriend(friendName string) (int, bool) {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	if f, ok := u.friends[friendName]; ok {
+		return f.RequestStatus(), true
+	}
+	return 0, false
+}
+
+// findFriend gets a User to friend by name, whether they're online, and their database ID. Without the
+// SQL features, only Users who are online or on someone's friend list can be found.
+func findFriend(friendName string) (*User, bool, int, error) {
+	if friend, friendErr := GetUser(friendName); friendErr == nil {
+		return friend, true, friend.databaseID, nil
+	} else if !sqlFeatures {
+		memFriendsMux.Lock()
+		_, known := memFriends[friendName]
+		memFriendsMux.Unlock()
+		if !known {
+			return nil, false, 0, errors.New("The user '" + friendName + "' is not online")
+		}
+		return nil, false, -1, nil
+	}
+	//GET FRIEND'S DATABASE ID FROM database PACKAGE
+	friendID, friendErr := database.GetUserDatabaseIndex(friendName)
+	if friendErr != nil {
+		return nil, false, 0, errors.New("The user '" + friendName + "' does not exist")
+	}
+	return nil, false, friendID, nil
+}
+
+func storeFriend(userName string, friendName string, requestStatus int) {
+	memFriendsMux.Lock()
+	if memFriends[userName] == nil {
+		memFriends[userName] = make(map[string]int)
+	}
+	memFriends[userName][friendName] = requestStatus
+	memFriendsMux.Unlock()
+}
+
+func unstoreFriend(userName string, friendName string) {
+	memFriendsMux.Lock()
+	delete(memFriends[userName], friendName)
+	memFriendsMux.Unlock()
+}
+
+// storedFriends makes a friend list for a User logging in when the SQL features are disabled
+func storedFriends(userName string) map[string]*database.Friend {
+	friends := make(map[string]*database.Friend)
+	memFriendsMux.Lock()
+	for friendName, requestStatus := range memFriends[userName] {
+		friends[friendName] = database.NewFriend(friendName, -1, requestStatus)
+	}
+	memFriendsMux.Unlock()
+	return friends
+}
@@ -0,0 +1,47 @@
+package core
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/database"
+	"testing"
+)
+
+func TestFriendsWithoutDatabase(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	alice, _ := testLogin(t, "friendAlice")
+	defer func() { alice.Kick() }()
+	bob, _ := testLogin(t, "friendBob")
+	defer func() { bob.Kick() }()
+
+	if err := alice.FriendRequest("friendNobody"); err == nil {
+		t.Error("Users who are not online can't be requested without the SQL features")
+	}
+	if err := alice.FriendRequest("friendBob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bob.AcceptFriendRequest("friendAlice"); err != nil {
+		t.Fatal(err)
+	}
+	bob.SetStatus(StatusInGame)
+	friends := alice.Friends()
+	if len(friends) != 1 || friends[0].Name != "friendBob" || friends[0].RequestStatus != database.FriendStatusAccepted {
+		t.Fatal("Expected 'friendBob' as an accepted friend, got", friends)
+	} else if !friends[0].Online || friends[0].Status != StatusInGame {
+		t.Error("Expected 'friendBob' to be online and in game, got", friends[0])
+	}
+
+	// Friend lists last between logins
+	bob.Kick()
+	if friends := alice.Friends(); friends[0].Online || friends[0].Status != StatusOffline {
+		t.Error("Expected 'friendBob' to be offline, got", friends[0])
+	}
+	bob, _ = testLogin(t, "friendBob")
+	if friends := bob.Friends(); len(friends) != 1 || friends[0].Name != "friendAlice" {
+		t.Fatal("Expected 'friendAlice' to still be a friend after logging back in, got", friends)
+	}
+	if err := bob.RemoveFriend("friendAlice"); err != nil {
+		t.Error(err)
+	}
+	if len(alice.Friends()) != 0 || len(bob.Friends()) != 0 {
+		t.Error("Removing a friend should remove them from both friend lists")
+	}
+}
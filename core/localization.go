@@ -0,0 +1,38 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strconv"
+)
+
+// localizedMessages makes a message from a message template for each locale it's asked for, only once for each. It isn't
+// safe to use from more than one goroutine.
+type localizedMessages struct {
+	key      int
+	params   []interface{}
+	build    func(text string) *helpers.Encoded
+	byLocale map[string]*helpers.Encoded
+}
+
+// newLocalizedMessages makes the localizedMessages for a message key, and gets its text in the DefaultLocale. Returns an error
+// when the DefaultLocale doesn't have a template for the key, so every client has something to get.
+func newLocalizedMessages(key int, params []interface{}, build func(text string) *helpers.Encoded) (*localizedMessages, string, error) {
+	template, ok := helpers.Translate(helpers.DefaultLocale, key)
+	if !ok {
+		return nil, "", errors.New("The message key " + strconv.Itoa(key) + " has no template in the locale '" + helpers.DefaultLocale + "'")
+	}
+	m := &localizedMessages{key: key, params: params, build: build, byLocale: make(map[string]*helpers.Encoded)}
+	return m, helpers.FormatMessage(template, params...), nil
+}
+
+// get gets the message in the locale, falling back like helpers.Translate().
+func (m *localizedMessages) get(locale string) *helpers.Encoded {
+	message, ok := m.byLocale[locale]
+	if !ok {
+		template, _ := helpers.Translate(locale, m.key)
+		message = m.build(helpers.FormatMessage(template, m.params...))
+		m.byLocale[locale] = message
+	}
+	return message
+}
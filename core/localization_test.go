@@ -0,0 +1,61 @@
+package core
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrivateMessageLocalized(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	const msgGreeting = 1
+	helpers.SetTranslations("en", map[int]string{msgGreeting: "{0} says hi to {1}"})
+	helpers.SetTranslations("es", map[int]string{msgGreeting: "{1}, {0} te saluda"})
+	defer helpers.SetTranslations("en", nil)
+	defer helpers.SetTranslations("es", nil)
+
+	sender, _ := testLogin(t, "localizedSender")
+	defer sender.Kick()
+	socket, client := testSocketPair(t)
+	SetConnInfo(socket, "", helpers.ProtocolVersion, "es-MX", nil)
+	defer ForgetConnInfo(socket)
+	var receiver *User
+	var clientMux sync.Mutex
+	if _, err := Login("localizedReceiver", -1, "", true, false, socket, &receiver, &clientMux); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	defer receiver.Kick()
+
+	var sent interface{}
+	SetPrivateMessageCallback(func(from *User, to *User, message interface{}) {
+		sent = message
+	})
+	defer func() {
+		privateMessageCallback = nil
+		privateMessageCallbackSet = false
+	}()
+	if err := sender.PrivateMessageLocalized("localizedReceiver", msgGreeting, "localizedSender", "{0}"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The receiver gets it in the language of their locale, and parameters aren't filled in twice
+	client.SetReadDeadline(time.Now().Add(time.Second * 2))
+	for {
+		var message map[string]map[string]interface{}
+		if err := client.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		} else if pm, ok := message[helpers.ServerActionPrivateMessage]; ok {
+			if pm["m"] != "{0}, localizedSender te saluda" {
+				t.Error("Expected the message in Spanish, got", pm["m"])
+			}
+			break
+		}
+	}
+	if sent != "localizedSender says hi to {0}" {
+		t.Error("Expected the callback to get the message in the DefaultLocale, got", sent)
+	}
+	if err := sender.PrivateMessageLocalized("localizedReceiver", 2); err == nil {
+		t.Error("Expected an error sending a key without a template in the DefaultLocale")
+	}
+}
@@ -0,0 +1,69 @@
+package core
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"time"
+)
+
+// LoginRecord is a login attempt, kept so admin tools can see recent activity. See RecentLogins().
+type LoginRecord struct {
+	Name    string    // The name the User tried to log in with
+	Time    time.Time // When they tried
+	IP      string    // The IP of their connection, or "" when it isn't known
+	Guest   bool      // true when they tried to log in as a guest
+	Success bool      // true when they were logged in
+	Error   int       // The helpers.GopherError ID of why they weren't logged in, or 0 when they were
+}
+
+const (
+	loginHistorySize = 256 // THE MOST LOGIN ATTEMPTS KEPT
+)
+
+var (
+	//loginHistoryMux LOCKS loginHistory AND loginHistoryNext
+	loginHistoryMux  sync.Mutex
+	loginHistory     []LoginRecord // RING OF THE LATEST LOGIN ATTEMPTS
+	loginHistoryNext int           // WHERE THE NEXT ATTEMPT GOES WHEN THE RING IS FULL
+)
+
+// LoginFailed is only for internal Gopher Game Server mechanics.
+func LoginFailed(userName string, socket *websocket.Conn, isGuest bool, err helpers.GopherError) {
+	recordLogin(userName, socket, isGuest, err)
+}
+
+// recordLogin keeps a login attempt in the history, replacing the oldest one when it's full.
+func recordLogin(userName string, socket *websocket.Conn, isGuest bool, err helpers.GopherError) {
+	record := LoginRecord{Name: userName, Time: time.Now(), Guest: isGuest, Success: err.ID == 0, Error: err.ID}
+	if socket != nil {
+		record.IP = socketConnInfo(socket).ip
+	}
+	loginHistoryMux.Lock()
+	defer loginHistoryMux.Unlock()
+	if len(loginHistory) < loginHistorySize {
+		loginHistory = append(loginHistory, record)
+		return
+	}
+	loginHistory[loginHistoryNext] = record
+	loginHistoryNext = (loginHistoryNext + 1) % loginHistorySize
+}
+
+// RecentLogins gets the latest n login attempts, newest first, whether they worked or not. Only the latest 256 are kept, so
+// you'll get fewer when there haven't been that many, or when n is more than that.
+func RecentLogins(n int) []LoginRecord {
+	loginHistoryMux.Lock()
+	defer loginHistoryMux.Unlock()
+	if n > len(loginHistory) {
+		n = len(loginHistory)
+	} else if n < 0 {
+		n = 0
+	}
+	records := make([]LoginRecord, 0, n)
+	// THE NEWEST IS JUST BEFORE loginHistoryNext
+	for i := 1; i <= n; i++ {
+		index := (loginHistoryNext - i + len(loginHistory)) % len(loginHistory)
+		records = append(records, loginHistory[index])
+	}
+	return records
+}
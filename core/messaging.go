@@ -32,31 +32,28 @@ var (
 //   Messaging Users   ///////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// PrivateMessage sends a private message to another User by name.
+// PrivateMessage sends a private message to another User by name. When clustering is on (see ClusterEnabled()), the message
+// is sent to the other node the User is on when they aren't logged in to this one. The private message callback only runs
+// when both Users are on this node.
 func (u *User) PrivateMessage(userName string, message interface{}) {
 	user, userErr := GetUser(userName)
 	if userErr != nil {
+		u.clusterPrivateMessage(userName, message)
 		return
 	}
 
 	//CONSTRUCT MESSAGE
-	theMessage := map[string]map[string]interface{}{
-		helpers.ServerActionPrivateMessage: {
-			"f": u.name,    // from
-			"t": user.name, // to
-			"m": message,
-		},
-	}
+	theMessage := privateMessage(u.Name(), user.Name(), message)
 
 	//SEND MESSAGES
 	user.mux.Lock()
 	for _, conn := range user.conns {
-		(*conn).socket.WriteJSON(theMessage)
+		(*conn).send(theMessage)
 	}
 	user.mux.Unlock()
 	u.mux.Lock()
 	for _, conn := range u.conns {
-		(*conn).socket.WriteJSON(theMessage)
+		(*conn).send(theMessage)
 	}
 	u.mux.Unlock()
 
@@ -67,6 +64,54 @@ func (u *User) PrivateMessage(userName string, message interface{}) {
 	return
 }
 
+// PrivateMessageLocalized is the same as PrivateMessage, but sends a message template set with helpers.SetTranslations(), with
+// its positional parameters filled in by helpers.FormatMessage(). Each connection of both Users gets it in its own locale. The
+// private message callback, and a User on another node of the cluster, get it in the DefaultLocale. Returns an error when the
+// key doesn't have a template in the DefaultLocale.
+func (u *User) PrivateMessageLocalized(userName string, key int, params ...interface{}) error {
+	user, userErr := GetUser(userName)
+	from, to := u.Name(), userName
+	if userErr == nil {
+		to = user.Name()
+	}
+	messages, message, err := newLocalizedMessages(key, params, func(text string) *helpers.Encoded {
+		return privateMessage(from, to, text)
+	})
+	if err != nil {
+		return err
+	} else if userErr != nil {
+		u.clusterPrivateMessage(userName, message)
+		return nil
+	}
+
+	//SEND MESSAGES IN EACH CONNECTION'S LOCALE
+	user.mux.Lock()
+	for _, conn := range user.conns {
+		(*conn).send(messages.get(conn.locale))
+	}
+	user.mux.Unlock()
+	u.mux.Lock()
+	for _, conn := range u.conns {
+		(*conn).send(messages.get(conn.locale))
+	}
+	u.mux.Unlock()
+
+	if privateMessageCallbackSet {
+		privateMessageCallback(u, user, message)
+	}
+	return nil
+}
+
+func privateMessage(from string, to string, message interface{}) *helpers.Encoded {
+	return helpers.NewEncoded(map[string]map[string]interface{}{
+		helpers.ServerActionPrivateMessage: {
+			"f": from, // from
+			"t": to,   // to
+			"m": message,
+		},
+	})
+}
+
 // DataMessage sends a data message directly to the User.
 func (u *User) DataMessage(data interface{}, connID string) {
 	//CONSTRUCT MESSAGE
@@ -78,11 +123,11 @@ func (u *User) DataMessage(data interface{}, connID string) {
 	u.mux.Lock()
 	if connID == "" {
 		for _, conn := range u.conns {
-			(*conn).socket.WriteJSON(message)
+			(*conn).send(message)
 		}
 	} else {
 		if conn, ok := u.conns[connID]; ok {
-			(*conn).socket.WriteJSON(message)
+			(*conn).send(message)
 		}
 	}
 	u.mux.Unlock()
@@ -103,10 +148,12 @@ func (r *Room) ServerMessage(message interface{}, messageType int, recipients []
 		serverMessageCallback(r, messageType, message)
 	}
 
+	r.events.record(EventServerMessage, "", map[string]interface{}{"m": message, "s": messageType, "r": recipients})
 	return r.sendMessage(MessageTypeServer, messageType, recipients, "", message)
 }
 
-// ChatMessage sends a chat message to all Users in the Room.
+// ChatMessage sends a chat message to all Users in the Room. Returns ErrBroadcastLimited when the author is sending too fast
+// for the Room's RoomType's broadcast rate limit (see *RoomType.SetBroadcastRateLimit()).
 func (r *Room) ChatMessage(author string, message interface{}) error {
 	//REJECT INCORRECT INPUT
 	if len(author) == 0 {
@@ -115,11 +162,37 @@ func (r *Room) ChatMessage(author string, message interface{}) error {
 		return errors.New("*Room.ChatMessage() requires a message")
 	}
 
+	r.mux.Lock()
+	allowed := r.allowBroadcast(author)
+	r.mux.Unlock()
+	if !allowed {
+		return ErrBroadcastLimited
+	}
+
+	message, send := r.moderateChat(author, message)
+	if !send {
+		return nil
+	}
+
+	if roomType := roomTypes[r.rType]; roomType.HasChatMessageHandler() {
+		if message, send = roomType.ChatMessageHandler()(r, author, message); !send {
+			return nil
+		}
+	}
+
 	if chatMessageCallbackSet {
 		chatMessageCallback(author, r, message)
 	}
 
-	return r.sendMessage(MessageTypeChat, 0, nil, author, message)
+	sendErr := r.sendMessage(MessageTypeChat, 0, nil, author, message)
+	if sendErr != nil {
+		return sendErr
+	}
+	r.events.record(EventChat, author, map[string]interface{}{"m": message})
+	r.addChatHistory(author, message)
+
+	//
+	return nil
 }
 
 // DataMessage sends a data message to the specified recipients in the Room. The parameter recipients can be nil or an empty slice
@@ -131,17 +204,19 @@ func (r *Room) DataMessage(message interface{}, recipients []string) error {
 		return err
 	}
 
+	r.events.record(EventDataMessage, "", map[string]interface{}{"m": message, "r": recipients})
+
 	//CONSTRUCT MESSAGE
-	theMessage := map[string]interface{}{
+	theMessage := helpers.NewEncoded(map[string]interface{}{
 		helpers.ServerActionDataMessage: message,
-	}
+	})
 
 	//SEND MESSAGE TO USERS
 	if recipients == nil || len(recipients) == 0 {
 		for _, u := range userMap {
 			u.mux.Lock()
 			for _, conn := range u.conns {
-				conn.socket.WriteJSON(theMessage)
+				conn.send(theMessage)
 			}
 			u.mux.Unlock()
 		}
@@ -150,7 +225,7 @@ func (r *Room) DataMessage(message interface{}, recipients []string) error {
 			if u, ok := userMap[recipients[i]]; ok {
 				u.mux.Lock()
 				for _, conn := range u.conns {
-					conn.socket.WriteJSON(theMessage)
+					conn.send(theMessage)
 				}
 				u.mux.Unlock()
 			}
@@ -167,28 +242,14 @@ func (r *Room) sendMessage(mt int, st int, rec []string, a string, m interface{}
 	if err != nil {
 		return err
 	}
-
-	//CONSTRUCT MESSAGE
-	message := map[string]map[string]interface{}{
-		helpers.ServerActionRoomMessage: make(map[string]interface{}),
-	}
-	// Server messages come with a sub-type
-	if mt == MessageTypeServer {
-		message[helpers.ServerActionRoomMessage]["s"] = st
-	}
-	// Non-server messages have authors
-	if len(a) > 0 && mt != MessageTypeServer {
-		message[helpers.ServerActionRoomMessage]["a"] = a
-	}
-	// The message
-	message[helpers.ServerActionRoomMessage]["m"] = m
+	encoded := roomMessage(mt, st, a, m)
 
 	//SEND MESSAGE TO USERS
 	if rec == nil || len(rec) == 0 {
 		for _, u := range userMap {
 			u.mux.Lock()
 			for _, conn := range u.conns {
-				conn.socket.WriteJSON(message)
+				conn.send(encoded)
 			}
 			u.mux.Unlock()
 		}
@@ -197,7 +258,7 @@ func (r *Room) sendMessage(mt int, st int, rec []string, a string, m interface{}
 			if u, ok := userMap[rec[i]]; ok {
 				u.mux.Lock()
 				for _, conn := range u.conns {
-					conn.socket.WriteJSON(message)
+					conn.send(encoded)
 				}
 				u.mux.Unlock()
 			}
@@ -207,6 +268,25 @@ func (r *Room) sendMessage(mt int, st int, rec []string, a string, m interface{}
 	return nil
 }
 
+// roomMessage makes a room message of the message type mt. Server messages have the sub-type st, and other messages have the
+// author a.
+func roomMessage(mt int, st int, a string, m interface{}) *helpers.Encoded {
+	message := map[string]map[string]interface{}{
+		helpers.ServerActionRoomMessage: make(map[string]interface{}),
+	}
+	// Server messages come with a sub-type
+	if mt == MessageTypeServer {
+		message[helpers.ServerActionRoomMessage]["s"] = st
+	}
+	// Non-server messages have authors
+	if len(a) > 0 && mt != MessageTypeServer {
+		message[helpers.ServerActionRoomMessage]["a"] = a
+	}
+	// The message
+	message[helpers.ServerActionRoomMessage]["m"] = m
+	return helpers.NewEncoded(message)
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 //   VOICE STREAMS   //////////////////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -220,12 +300,12 @@ func (r *Room) VoiceStream(userName string, userSocket *websocket.Conn, stream i
 	}
 
 	//CONSTRUCT VOICE MESSAGE
-	theMessage := map[string]map[string]interface{}{
+	theMessage := helpers.NewEncoded(map[string]map[string]interface{}{
 		helpers.ServerActionVoiceStream: {
 			"u": userName,
 			"d": stream,
 		},
-	}
+	})
 
 	//REMOVE SENDING USER FROM userMap
 	delete(userMap, userName) // COMMENT OUT FOR ECHO TESTS
@@ -233,7 +313,7 @@ func (r *Room) VoiceStream(userName string, userSocket *websocket.Conn, stream i
 	//SEND MESSAGE TO USERS
 	for _, u := range userMap {
 		for _, conn := range u.conns {
-			(*conn).socket.WriteJSON(theMessage)
+			(*conn).send(theMessage)
 		}
 	}
 
@@ -243,7 +323,7 @@ func (r *Room) VoiceStream(userName string, userSocket *websocket.Conn, stream i
 	}
 
 	//SEND PING MESSAGE TO SENDING USER
-	userSocket.WriteJSON(pingMessage)
+	helpers.WriteMessage(userSocket, pingMessage)
 
 	//
 	return
@@ -261,7 +341,9 @@ func (r *Room) VoiceStream(userName string, userSocket *websocket.Conn, stream i
 //	 }
 func SetPrivateMessageCallback(cb func(*User, *User, interface{})) {
 	if !serverStarted {
-		privateMessageCallback = cb
+		privateMessageCallback = func(from *User, to *User, message interface{}) {
+			helpers.Protect("private message callback", func() { cb(from, to, message) }, "user", from.Name())
+		}
 		privateMessageCallbackSet = true
 	}
 
@@ -275,7 +357,9 @@ func SetPrivateMessageCallback(cb func(*User, *User, interface{})) {
 //	 }
 func SetChatMessageCallback(cb func(string, *Room, interface{})) {
 	if !serverStarted {
-		chatMessageCallback = cb
+		chatMessageCallback = func(author string, room *Room, message interface{}) {
+			helpers.Protect("chat message callback", func() { cb(author, room, message) }, "room", room.Name(), "user", author)
+		}
 		chatMessageCallbackSet = true
 	}
 }
@@ -291,7 +375,9 @@ func SetChatMessageCallback(cb func(string, *Room, interface{})) {
 // core.ServerMessageImportant, or a custom value you have set.
 func SetServerMessageCallback(cb func(*Room, int, interface{})) {
 	if !serverStarted {
-		serverMessageCallback = cb
+		serverMessageCallback = func(room *Room, messageType int, message interface{}) {
+			helpers.Protect("server message callback", func() { cb(room, messageType, message) }, "room", room.Name())
+		}
 		serverMessageCallbackSet = true
 	}
 }
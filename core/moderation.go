@@ -0,0 +1,126 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// wordFilter censors a set of words in chat messages.
+type wordFilter struct {
+	words       map[string]bool // LOWER CASE
+	replacement string
+}
+
+var (
+	wordFilterValue atomic.Value // *wordFilter, OR A nil ONE FOR NO FILTER
+
+	chatModerationCallback    func(string, string, interface{}) (interface{}, bool)
+	chatModerationCallbackSet bool
+	chatDropWarning           interface{}
+
+	// A WORD IS A RUN OF LETTERS, NUMBERS AND MARKS IN ANY LANGUAGE
+	filterWordRegex = regexp.MustCompile(`[\pL\pN\pM]+`)
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CHAT MODERATION   ///////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SetWordFilter censors the words in every chat message sent to a Room, replacing each one with replacement. Words are
+// matched whole and regardless of case, so filtering "heck" censors "HECK" and "Heck!" but not "check". Strings inside map
+// and slice messages are censored too, and other values are left alone. Passing no words removes the filter. The filter
+// runs before the chat moderation callback, and can be changed while the server is running.
+func SetWordFilter(words []string, replacement string) error {
+	if len(words) == 0 {
+		wordFilterValue.Store((*wordFilter)(nil))
+		return nil
+	}
+	filter := wordFilter{words: make(map[string]bool), replacement: replacement}
+	for _, word := range words {
+		if filterWordRegex.FindString(word) != word {
+			return errors.New("The word filter can only filter whole words, not '" + word + "'")
+		}
+		filter.words[strings.ToLower(word)] = true
+	}
+	wordFilterValue.Store(&filter)
+
+	//
+	return nil
+}
+
+// SetChatModerationCallback sets the callback function that can change or drop every chat message sent to a Room, after
+// the word filter. It receives the Room's name, the author's name, and the message, and returns the message to send and
+// whether to send it. Unlike a RoomType's chat message handler, it runs for chat in every Room. The function passed must
+// have the same parameter types as the following example:
+//
+//    func moderateChat(roomName string, userName string, message interface{}) (interface{}, bool) {
+//	     //code...
+//	     return message, true
+//	 }
+func SetChatModerationCallback(cb func(string, string, interface{}) (interface{}, bool)) {
+	if !serverStarted {
+		// A PANIC DROPS THE MESSAGE
+		chatModerationCallback = func(roomName string, author string, message interface{}) (send interface{}, ok bool) {
+			helpers.Protect("chat moderation callback", func() { send, ok = cb(roomName, author, message) }, "room", roomName, "user", author)
+			return
+		}
+		chatModerationCallbackSet = true
+	}
+}
+
+// SetChatDropWarning sets a warning that is sent to a User, as a ServerMessageNotice server message only they get, when
+// the chat moderation callback drops their chat message. Use nil to drop messages without a warning, which is the default.
+func SetChatDropWarning(warning interface{}) {
+	if !serverStarted {
+		chatDropWarning = warning
+	}
+}
+
+// moderateChat runs the word filter and the chat moderation callback on a chat message. It doesn't lock the Room, so a slow
+// callback can't hold up Users joining or leaving it.
+func (r *Room) moderateChat(author string, message interface{}) (interface{}, bool) {
+	if filter, _ := wordFilterValue.Load().(*wordFilter); filter != nil {
+		message = filter.censor(message)
+	}
+	if chatModerationCallbackSet {
+		var send bool
+		if message, send = chatModerationCallback(r.Name(), author, message); !send {
+			if chatDropWarning != nil {
+				r.sendMessage(MessageTypeServer, ServerMessageNotice, []string{author}, "", chatDropWarning)
+			}
+			return nil, false
+		}
+	}
+
+	//
+	return message, true
+}
+
+// censor returns a copy of message with the filtered words in its strings replaced.
+func (f *wordFilter) censor(message interface{}) interface{} {
+	switch m := message.(type) {
+	case string:
+		return filterWordRegex.ReplaceAllStringFunc(m, func(word string) string {
+			if f.words[strings.ToLower(word)] {
+				return f.replacement
+			}
+			return word
+		})
+	case map[string]interface{}:
+		censored := make(map[string]interface{}, len(m))
+		for key, val := range m {
+			censored[key] = f.censor(val)
+		}
+		return censored
+	case []interface{}:
+		censored := make([]interface{}, len(m))
+		for i, val := range m {
+			censored[i] = f.censor(val)
+		}
+		return censored
+	}
+	return message
+}
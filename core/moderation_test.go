@@ -0,0 +1,85 @@
+package core
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWordFilter(t *testing.T) {
+	defer SetWordFilter(nil, "")
+	if err := SetWordFilter([]string{"heck", "darn"}, "****"); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetWordFilter([]string{"two words"}, "****"); err == nil {
+		t.Error("Expected the word filter to refuse more than one word")
+	}
+	filter := wordFilterValue.Load().(*wordFilter)
+
+	tests := []struct {
+		message  interface{}
+		expected interface{}
+	}{
+		{"What the HECK, darn it!", "What the ****, **** it!"},
+		{"check heckle", "check heckle"},
+		{map[string]interface{}{"heck": "Heck", "n": 5.0}, map[string]interface{}{"heck": "****", "n": 5.0}},
+		{[]interface{}{"darn", true, nil}, []interface{}{"****", true, nil}},
+		{12.5, 12.5},
+	}
+	for _, test := range tests {
+		if censored := filter.censor(test.message); !reflect.DeepEqual(censored, test.expected) {
+			t.Errorf("censor(%v) = %v, expected %v", test.message, censored, test.expected)
+		}
+	}
+}
+
+func TestChatModeration(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 5)
+	defer SettingsSet(false, "server", false, false, false, false, 0, 0)
+	defer SetWordFilter(nil, "")
+	defer func() {
+		chatModerationCallback, chatModerationCallbackSet, chatDropWarning = nil, false, nil
+	}()
+	SetWordFilter([]string{"heck"}, "*")
+	SetChatModerationCallback(func(roomName string, userName string, message interface{}) (interface{}, bool) {
+		return message, message != "spam"
+	})
+	SetChatDropWarning("Please don't spam")
+
+	room, roomErr := NewRoom("chatModeration", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	socket, client := testSocketPair(t)
+	var user *User
+	var clientMux sync.Mutex
+	connID, err := Login("moderated", -1, "", true, false, socket, &user, &clientMux)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	defer user.Logout(connID)
+	if joinErr := user.Join(room, connID); joinErr != nil {
+		t.Fatal(joinErr)
+	}
+
+	room.ChatMessage("moderated", "oh heck")
+	room.ChatMessage("moderated", "spam")
+	if history := room.GetChatHistory(0); len(history) != 1 || history[0].Message != "oh *" {
+		t.Error("Expected only the censored message in the chat history, got", history)
+	}
+
+	// The author gets the warning for the dropped message
+	client.SetReadDeadline(time.Now().Add(time.Second * 2))
+	for {
+		var message map[string]map[string]interface{}
+		if err := client.ReadJSON(&message); err != nil {
+			t.Fatal("Expected a warning for the dropped message:", err)
+		}
+		if roomMessage, ok := message[helpers.ServerActionRoomMessage]; ok && roomMessage["m"] == "Please don't spam" {
+			break
+		}
+	}
+}
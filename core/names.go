@@ -0,0 +1,60 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+)
+
+var (
+	//skeletonMux LOCKS onlineSkeletons
+	skeletonMux     sync.Mutex
+	onlineSkeletons = make(map[string]string) // THE NAME OF THE ONLINE User WITH EACH NAME SKELETON
+)
+
+// SetNamePolicy sets the rules for the names Users can take: how long they can be, the characters they can have, words they
+// can't have, and whether they are put in Unicode NFKC form and can look like other Users' names. Without the SQL features,
+// the NamePolicy is checked every time a User logs in. With them, it's checked when an account is made or renamed, and when a
+// guest logs in. A name that breaks the NamePolicy gets an error with the ID of the rule it broke, like
+// helpers.ErrorNameLength. You can only set the NamePolicy before starting the server, and without it any name that isn't empty
+// is allowed.
+func SetNamePolicy(p helpers.NamePolicy) error {
+	if serverStarted {
+		return errors.New("You can't set the name policy once the server has started")
+	} else if p.MinLength < 0 || p.MaxLength < 0 || (p.MaxLength > 0 && p.MinLength > p.MaxLength) {
+		return errors.New("The name policy's MinLength and MaxLength must be positive, and MinLength can't be more than MaxLength")
+	} else if p.Chars == helpers.NameCharsPattern && p.Pattern == nil {
+		return errors.New("The name policy needs a Pattern with NameCharsPattern")
+	}
+	helpers.SetNamePolicy(p)
+	return nil
+}
+
+// claimSkeleton marks a User's name as online, so nobody else can log in with a name that looks the same when the NamePolicy
+// has Unique names. Returns false when another online User's name looks the same, unless force is true.
+func claimSkeleton(userName string, force bool) bool {
+	if !helpers.UniqueNames() {
+		return true
+	}
+	skeleton := helpers.NameSkeleton(userName)
+	skeletonMux.Lock()
+	defer skeletonMux.Unlock()
+	if owner, ok := onlineSkeletons[skeleton]; ok && owner != userName && !force {
+		return false
+	}
+	onlineSkeletons[skeleton] = userName
+	return true
+}
+
+// releaseSkeleton lets other Users log in with a name that looks like the User's, once they're gone.
+func releaseSkeleton(userName string) {
+	if !helpers.UniqueNames() {
+		return
+	}
+	skeleton := helpers.NameSkeleton(userName)
+	skeletonMux.Lock()
+	if onlineSkeletons[skeleton] == userName {
+		delete(onlineSkeletons, skeleton)
+	}
+	skeletonMux.Unlock()
+}
@@ -0,0 +1,310 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+)
+
+// PresenceEvent is a change to a watched User's presence. See Watch() and *User.WatchUser().
+type PresenceEvent struct {
+	Type   int    // What changed: PresenceLogin, PresenceLogout, PresenceStatus or PresenceRoom
+	User   string // The watched User's name
+	Status int    // The User's status, which is StatusOffline after they log out
+	Room   string // The Room the User joined with PresenceRoom, or "" when they left one
+}
+
+// These represent the changes to a User's presence a PresenceEvent can be.
+const (
+	PresenceLogin  = iota // The User logged in
+	PresenceLogout        // The User logged out, or was kicked
+	PresenceStatus        // The User changed their status
+	PresenceRoom          // The User joined or left a Room
+)
+
+const (
+	// THE DEFAULT MOST USERS A CONNECTION CAN WATCH AT ONCE
+	defaultMaxWatches = 50
+)
+
+var (
+	// ErrWatchLimit is returned when a User's connection is already watching as many Users as it can.
+	ErrWatchLimit error = helpers.NewError("You are watching too many users", helpers.ErrorWatchLimit)
+	// ErrUserPrivate is returned when a User tries to watch a User that has set themselves private.
+	ErrUserPrivate error = helpers.NewError("That user is private", helpers.ErrorUserPrivate)
+
+	maxWatches = defaultMaxWatches
+
+	//watchMux LOCKS watchedBy, connWatches, serverWatches AND watchCounter
+	watchMux      sync.Mutex
+	watchedBy     = make(map[string]map[*userConn]bool)             // CLIENT WATCHES BY THE WATCHED User's NAME
+	connWatches   = make(map[*userConn]map[string]bool)             // THE NAMES EACH CONNECTION WATCHES
+	serverWatches = make(map[string]map[uint64]func(PresenceEvent)) // SERVER WATCHES BY THE WATCHED User's NAME
+	watchCounter  uint64
+
+	//presenceMux LOCKS presenceQueue AND presenceRunning
+	presenceMux     sync.Mutex
+	presenceQueue   []presenceNotice
+	presenceRunning bool
+)
+
+// presenceNotice is a PresenceEvent waiting to be sent to the watchers. Clients watching a User in a private Room don't get
+// the Room's name.
+type presenceNotice struct {
+	event   PresenceEvent
+	private bool
+}
+
+// SetMaxWatches is only for internal Gopher Game Server mechanics.
+func SetMaxWatches(max int) {
+	if !serverStarted {
+		maxWatches = max
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   WATCHING USERS   ////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Watch runs fn with a PresenceEvent every time the User with the given name logs in, logs out, changes their status, or
+// joins or leaves a Room. The User doesn't need to be logged in, and the watch keeps going when they log out and back in.
+// Call the returned cancel function to stop watching. The events are sent in order from their own goroutine, so fn can call
+// any core functions, but it shouldn't take long.
+func Watch(userName string, fn func(event PresenceEvent)) (cancel func()) {
+	watchMux.Lock()
+	watchCounter++
+	id := watchCounter
+	if serverWatches[userName] == nil {
+		serverWatches[userName] = make(map[uint64]func(PresenceEvent))
+	}
+	serverWatches[userName][id] = fn
+	watchMux.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			watchMux.Lock()
+			delete(serverWatches[userName], id)
+			if len(serverWatches[userName]) == 0 {
+				delete(serverWatches, userName)
+			}
+			watchMux.Unlock()
+		})
+	}
+}
+
+// WatchUser makes one of the User's connections watch another User, so its client gets a helpers.ServerActionPresence
+// message every time they log in, log out, change their status, or join or leave a Room. The watched User doesn't need to be
+// logged in. Returns ErrUserPrivate if they are and have set themselves private, and ErrWatchLimit if the connection is
+// already watching MaxWatches in ServerSettings Users. The watch ends when the connection logs out. If you are using
+// MultiConnect in ServerSettings, the connID parameter is the connection ID associated with one of the connections attached
+// to that User. This must be provided with MultiConnect enabled. Otherwise, an empty string can be used.
+func (u *User) WatchUser(userName string, connID string) error {
+	if len(userName) == 0 {
+		return errors.New("*User.WatchUser() requires a user name")
+	} else if multiConnect && len(connID) == 0 {
+		return errors.New("Must provide a connID when MultiConnect is enabled")
+	} else if !multiConnect {
+		connID = "1"
+	}
+	if watched := findUser(userName); watched != nil && watched != u && watched.IsPrivate() {
+		return ErrUserPrivate
+	}
+	u.mux.Lock()
+	conn := u.conns[connID]
+	u.mux.Unlock()
+	if conn == nil {
+		return errors.New("Invalid connID")
+	}
+
+	watchMux.Lock()
+	defer watchMux.Unlock()
+	if connWatches[conn][userName] {
+		return nil
+	} else if len(connWatches[conn]) >= maxWatches {
+		return ErrWatchLimit
+	}
+	if connWatches[conn] == nil {
+		connWatches[conn] = make(map[string]bool)
+	}
+	connWatches[conn][userName] = true
+	if watchedBy[userName] == nil {
+		watchedBy[userName] = make(map[*userConn]bool)
+	}
+	watchedBy[userName][conn] = true
+	return nil
+}
+
+// UnwatchUser stops one of the User's connections from watching another User. Unwatching a User the connection doesn't
+// watch does nothing. If you are using MultiConnect in ServerSettings, the connID parameter is the connection ID associated
+// with one of the connections attached to that User. This must be provided with MultiConnect enabled. Otherwise, an empty
+// string can be used.
+func (u *User) UnwatchUser(userName string, connID string) error {
+	if multiConnect && len(connID) == 0 {
+		return errors.New("Must provide a connID when MultiConnect is enabled")
+	} else if !multiConnect {
+		connID = "1"
+	}
+	u.mux.Lock()
+	conn := u.conns[connID]
+	u.mux.Unlock()
+	if conn == nil {
+		return errors.New("Invalid connID")
+	}
+
+	watchMux.Lock()
+	unwatch(conn, userName)
+	watchMux.Unlock()
+	return nil
+}
+
+// unwatch stops a connection from watching a User. watchMux must be locked.
+func unwatch(conn *userConn, userName string) {
+	delete(connWatches[conn], userName)
+	if len(connWatches[conn]) == 0 {
+		delete(connWatches, conn)
+	}
+	delete(watchedBy[userName], conn)
+	if len(watchedBy[userName]) == 0 {
+		delete(watchedBy, userName)
+	}
+}
+
+// unwatchAll ends all the watches of a connection that logged out.
+func unwatchAll(conn *userConn) {
+	watchMux.Lock()
+	for userName := range connWatches[conn] {
+		unwatch(conn, userName)
+	}
+	watchMux.Unlock()
+}
+
+// UserPresence gets the current presence of a User as a PresenceEvent with the type PresenceLogin, or PresenceLogout when
+// they aren't logged in. The Room is the one their first connection is in, and is left out when it's private.
+func UserPresence(userName string) PresenceEvent {
+	user := findUser(userName)
+	if user == nil {
+		return PresenceEvent{Type: PresenceLogout, User: userName, Status: StatusOffline}
+	}
+	event := PresenceEvent{Type: PresenceLogin, User: userName, Status: user.Status()}
+	if room := user.firstRoom(); room != nil && !room.IsPrivate() {
+		event.Room = room.Name()
+	}
+	return event
+}
+
+// firstRoom gets the Room the User's first connection is in, or nil.
+func (u *User) firstRoom() *Room {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	for _, conn := range u.conns {
+		if conn.room != nil {
+			return conn.room
+		}
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   PRIVATE USERS   /////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SetPrivate sets whether a User can be watched by other Users' clients. Making a User private also ends the watches other
+// clients already have on them, but not the server's watches from Watch(). Users aren't private when they log in.
+func (u *User) SetPrivate(private bool) {
+	u.mux.Lock()
+	u.private = private
+	u.mux.Unlock()
+	if !private {
+		return
+	}
+	userName := u.Name()
+	watchMux.Lock()
+	for conn := range watchedBy[userName] {
+		unwatch(conn, userName)
+	}
+	watchMux.Unlock()
+}
+
+// IsPrivate returns true if the User can't be watched by other Users' clients.
+func (u *User) IsPrivate() bool {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return u.private
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SENDING PRESENCE EVENTS   ///////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// notifyPresence queues a PresenceEvent for the User's watchers. The events are sent from their own goroutine, so it can be
+// called with any locks held.
+func notifyPresence(eventType int, userName string, status int, room *Room) {
+	watchMux.Lock()
+	watched := len(watchedBy[userName]) > 0 || len(serverWatches[userName]) > 0
+	watchMux.Unlock()
+	if !watched {
+		return
+	}
+	notice := presenceNotice{event: PresenceEvent{Type: eventType, User: userName, Status: status}}
+	if room != nil {
+		notice.event.Room = room.Name()
+		notice.private = room.IsPrivate()
+	}
+
+	presenceMux.Lock()
+	presenceQueue = append(presenceQueue, notice)
+	if !presenceRunning {
+		presenceRunning = true
+		go sendPresence()
+	}
+	presenceMux.Unlock()
+}
+
+// sendPresence sends the queued PresenceEvents to their watchers in order, until the queue is empty.
+func sendPresence() {
+	for {
+		presenceMux.Lock()
+		if len(presenceQueue) == 0 {
+			presenceRunning = false
+			presenceMux.Unlock()
+			return
+		}
+		notice := presenceQueue[0]
+		presenceQueue = presenceQueue[1:]
+		presenceMux.Unlock()
+
+		event := notice.event
+		watchMux.Lock()
+		conns := make([]*userConn, 0, len(watchedBy[event.User]))
+		for conn := range watchedBy[event.User] {
+			conns = append(conns, conn)
+		}
+		callbacks := make([]func(PresenceEvent), 0, len(serverWatches[event.User]))
+		for _, fn := range serverWatches[event.User] {
+			callbacks = append(callbacks, fn)
+		}
+		watchMux.Unlock()
+
+		if len(conns) > 0 {
+			room := event.Room
+			if notice.private {
+				room = ""
+			}
+			message := helpers.NewEncoded(map[string]map[string]interface{}{
+				helpers.ServerActionPresence: {
+					"n": event.User,
+					"t": event.Type,
+					"s": event.Status,
+					"r": room,
+				},
+			})
+			for _, conn := range conns {
+				conn.send(message)
+			}
+		}
+		for _, fn := range callbacks {
+			helpers.Protect("presence watch", func() { fn(event) }, "user", event.User)
+		}
+	}
+}
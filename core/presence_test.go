@@ -0,0 +1,102 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	events := make(chan PresenceEvent, 10)
+	cancel := Watch("watchedUser", func(event PresenceEvent) { events <- event })
+	defer cancel()
+	next := func(eventType int) PresenceEvent {
+		select {
+		case event := <-events:
+			if event.Type != eventType || event.User != "watchedUser" {
+				t.Error("Expected a presence event of type", eventType, "got", event)
+			}
+			return event
+		case <-time.After(time.Second * 2):
+			t.Fatal("Expected a presence event of type", eventType)
+		}
+		return PresenceEvent{}
+	}
+
+	// Every change to the watched User's presence is sent in order
+	watched, _ := testLogin(t, "watchedUser")
+	next(PresenceLogin)
+	watched.SetStatus(StatusInGame)
+	if event := next(PresenceStatus); event.Status != StatusInGame {
+		t.Error("Expected the new status, got", event.Status)
+	}
+	room, _ := NewRoom("watchRoom", "test", false, 0, "")
+	defer room.Delete()
+	watched.Join(room, "")
+	if event := next(PresenceRoom); event.Room != "watchRoom" {
+		t.Error("Expected the Room joined, got", event.Room)
+	}
+	watched.Leave("")
+	if event := next(PresenceRoom); event.Room != "" {
+		t.Error("Expected no Room after leaving, got", event.Room)
+	}
+	watched.Kick()
+	if event := next(PresenceLogout); event.Status != StatusOffline {
+		t.Error("Expected the User to be offline, got", event.Status)
+	}
+
+	// Nothing is sent after cancelling
+	cancel()
+	watched, _ = testLogin(t, "watchedUser")
+	defer watched.Kick()
+	select {
+	case event := <-events:
+		t.Error("Expected no events after cancelling, got", event)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestWatchUser(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	SetMaxWatches(2)
+	defer SetMaxWatches(defaultMaxWatches)
+	watcher, _ := testLogin(t, "presenceWatcher")
+	defer watcher.Kick()
+	private, _ := testLogin(t, "presencePrivate")
+	defer private.Kick()
+
+	if err := watcher.WatchUser("presenceA", ""); err != nil {
+		t.Fatal(err)
+	} else if err := watcher.WatchUser("presenceB", ""); err != nil {
+		t.Fatal(err)
+	} else if err := watcher.WatchUser("presenceC", ""); err != ErrWatchLimit {
+		t.Error("Expected the watch limit, got", err)
+	}
+	watcher.UnwatchUser("presenceA", "")
+
+	// Private Users can't be watched, and making a User private ends the watches on them
+	private.SetPrivate(true)
+	if err := watcher.WatchUser("presencePrivate", ""); err != ErrUserPrivate {
+		t.Error("Expected the User to be private, got", err)
+	}
+	private.SetPrivate(false)
+	if err := watcher.WatchUser("presencePrivate", ""); err != nil {
+		t.Fatal(err)
+	}
+	private.SetPrivate(true)
+	watchMux.Lock()
+	watchers := len(watchedBy["presencePrivate"])
+	watchMux.Unlock()
+	if watchers != 0 {
+		t.Error("Expected the watches on a private User to end, got", watchers)
+	}
+
+	// Logging out ends the watcher's watches
+	watcher.Kick()
+	watchMux.Lock()
+	watchers = len(watchedBy["presenceB"])
+	watchMux.Unlock()
+	if watchers != 0 {
+		t.Error("Expected the watches to end when the watcher logs out, got", watchers)
+	}
+}
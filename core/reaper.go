@@ -0,0 +1,89 @@
+package core
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"time"
+)
+
+var (
+	emptyRoomTTL time.Duration
+
+	// RoomExpiredCallback is only for internal Gopher Game Server mechanics.
+	RoomExpiredCallback func(string, string)
+)
+
+// SetEmptyRoomTTL is only for internal Gopher Game Server mechanics.
+func SetEmptyRoomTTL(ttl time.Duration) {
+	if !serverStarted {
+		emptyRoomTTL = ttl
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   PERSISTENT ROOMS   //////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SetPersistent keeps a Room made by a User from being deleted when it has been empty for EmptyRoomTTL in ServerSettings.
+// Rooms owned by the server are always persistent. Setting it back to false starts the Room's EmptyRoomTTL over if it's empty.
+func (r *Room) SetPersistent(persistent bool) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.usersMap == nil {
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	r.persistent = persistent
+	if persistent {
+		r.stopExpiry()
+	} else {
+		r.startExpiry()
+	}
+	return nil
+}
+
+// IsPersistent returns true if the Room was made persistent with *Room.SetPersistent().
+func (r *Room) IsPersistent() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.persistent
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   EXPIRING EMPTY ROOMS   //////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// startExpiry starts the Room's EmptyRoomTTL if it's an empty Room made by a User. Must lock the Room's mux to use.
+func (r *Room) startExpiry() {
+	if emptyRoomTTL <= 0 || r.persistent || r.owner == serverName || len(r.usersMap) > 0 || r.expiry != nil {
+		return
+	}
+	r.emptySince = time.Now()
+	r.expiry = time.AfterFunc(emptyRoomTTL, r.expire)
+}
+
+// stopExpiry stops the Room's EmptyRoomTTL. Must lock the Room's mux to use.
+func (r *Room) stopExpiry() {
+	if r.expiry != nil {
+		r.expiry.Stop()
+		r.expiry = nil
+	}
+}
+
+// expire deletes the Room if it's still an empty Room made by a User, and has been for EmptyRoomTTL.
+func (r *Room) expire() {
+	r.mux.Lock()
+	// A TIMER THAT WAS STOPPED TOO LATE FINDS THE Room JOINED, OR EMPTY FOR LESS TIME
+	if r.usersMap == nil || len(r.usersMap) > 0 || r.persistent || r.owner == serverName || r.expiry == nil ||
+		time.Since(r.emptySince) < emptyRoomTTL {
+		r.mux.Unlock()
+		return
+	}
+	r.expiry = nil
+	owner := r.owner
+	r.mux.Unlock()
+
+	helpers.Log().Debug("Room expired", "room", r.name, "owner", owner)
+	if RoomExpiredCallback != nil {
+		RoomExpiredCallback(r.name, owner)
+	}
+	r.Delete()
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmptyRoomTTL(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	SetEmptyRoomTTL(time.Millisecond * 50)
+	expired := make(chan string, 10)
+	RoomExpiredCallback = func(roomName string, ownerName string) {
+		expired <- roomName
+	}
+	defer func() {
+		SetEmptyRoomTTL(0)
+		RoomExpiredCallback = nil
+	}()
+
+	serverRoom, _ := NewRoom("reapServer", "test", false, 0, "")
+	defer serverRoom.Delete()
+	keptRoom, _ := NewRoom("reapKept", "test", false, 0, "reapOwner")
+	defer keptRoom.Delete()
+	keptRoom.SetPersistent(true)
+	usedRoom, _ := NewRoom("reapUsed", "test", false, 0, "reapOwner")
+	emptyRoom, _ := NewRoom("reapEmpty", "test", false, 0, "reapOwner")
+
+	// A Room with a User in it doesn't expire
+	user, _ := testLogin(t, "reapUser")
+	defer user.Kick()
+	if err := user.Join(usedRoom, ""); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case roomName := <-expired:
+		if roomName != "reapEmpty" {
+			t.Error("Expected only reapEmpty to expire, got", roomName)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Expected reapEmpty to expire")
+	}
+	if _, err := GetRoom("reapEmpty"); err == nil {
+		emptyRoom.Delete()
+		t.Error("reapEmpty should be deleted")
+	}
+
+	// The TTL starts when the last User leaves
+	time.Sleep(time.Millisecond * 60)
+	user.Leave("")
+	if _, err := GetRoom("reapUsed"); err != nil {
+		t.Error("reapUsed should not expire as soon as it's empty")
+	}
+	select {
+	case roomName := <-expired:
+		if roomName != "reapUsed" {
+			t.Error("Expected reapUsed to expire, got", roomName)
+		}
+	case <-time.After(time.Second * 2):
+		usedRoom.Delete()
+		t.Fatal("Expected reapUsed to expire")
+	}
+
+	// Server and persistent Rooms never expire
+	time.Sleep(time.Millisecond * 100)
+	select {
+	case roomName := <-expired:
+		t.Error("Expected no more Rooms to expire, got", roomName)
+	default:
+	}
+	if _, err := GetRoom("reapServer"); err != nil {
+		t.Error("Rooms owned by the server should not expire")
+	} else if _, err := GetRoom("reapKept"); err != nil {
+		t.Error("Persistent Rooms should not expire")
+	}
+}
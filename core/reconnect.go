@@ -0,0 +1,268 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"time"
+)
+
+// heldConn is a connection that lost its socket, and is waiting for the client to reconnect.
+type heldConn struct {
+	user   *User
+	connID string
+	conn   *userConn
+}
+
+var (
+	reconnectGrace      time.Duration
+	reconnectBufferSize int
+
+	//heldMux LOCKS heldConns
+	heldConns = make(map[string]heldConn) // BY userConn.resumeHash
+	heldMux   sync.Mutex
+)
+
+// SetReconnect is only for internal Gopher Game Server mechanics.
+func SetReconnect(grace time.Duration, bufferSize int) {
+	if !serverStarted {
+		reconnectGrace = grace
+		reconnectBufferSize = bufferSize
+	}
+}
+
+// resumeTokens returns true if logins get a session resume token.
+func resumeTokens() bool {
+	return sessionResumeWindow > 0 || reconnectGrace > 0
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SENDING TO A CONNECTION   ///////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// send writes a message to the connection's socket. While the connection is held for a reconnect, the message is buffered
+// instead, and the oldest buffered message is dropped when the buffer is full.
+func (c *userConn) send(message interface{}) {
+	c.sendMux.Lock()
+	defer c.sendMux.Unlock()
+	if c.held {
+		data, err := json.Marshal(message)
+		if err != nil {
+			return
+		}
+		if len(c.buffer) >= reconnectBufferSize {
+			c.dropped = true
+			if len(c.buffer) == 0 {
+				return
+			}
+			c.buffer = c.buffer[1:]
+		}
+		c.buffer = append(c.buffer, data)
+		return
+	} else if c.socket == nil {
+		return
+	}
+	helpers.WriteMessage(c.socket, message)
+}
+
+// kicked sends the connection's client a ServerActionKicked message with the reason, then closes its socket with closeText
+// once the message is written. Closing the socket ends the client's listener, which finds the client already logged out.
+func (c *userConn) kicked(reason string, closeText string) {
+	c.sendMux.Lock()
+	legacy := c.version == helpers.LegacyProtocolVersion
+	c.sendMux.Unlock()
+	if legacy && reason == errorLoggedElsewhere {
+		// CLIENTS ON THE LEGACY PROTOCOL ARE TOLD ABOUT A DUPLICATE LOGIN WITH ServerActionLoggedInElsewhere
+		c.send(map[string]interface{}{
+			helpers.ServerActionLoggedInElsewhere: nil,
+		})
+	} else {
+		c.send(map[string]map[string]interface{}{
+			helpers.ServerActionKicked: {
+				"m": reason,
+			},
+		})
+	}
+	if socket := c.liveSocket(); socket != nil {
+		go hangUp(socket, closeText)
+	}
+}
+
+// hangUp writes what's already queued for a socket, then closes it with a close frame.
+func hangUp(socket *websocket.Conn, closeText string) {
+	helpers.StopWriter(socket)
+	socket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, closeText),
+		time.Now().Add(time.Second*1))
+	socket.Close()
+}
+
+// liveSocket gets the connection's socket, or nil while it's held for a reconnect.
+func (c *userConn) liveSocket() *websocket.Conn {
+	c.sendMux.Lock()
+	defer c.sendMux.Unlock()
+	return c.socket
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   HOLDING AND RECONNECTING   //////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Hold is only for internal Gopher Game Server mechanics.
+func (u *User) Hold(connID string, expired func()) bool {
+	if reconnectGrace <= 0 {
+		return false
+	} else if !multiConnect {
+		connID = "1"
+	}
+	u.mux.Lock()
+	conn, ok := u.conns[connID]
+	if !ok || conn.resumeHash == "" {
+		u.mux.Unlock()
+		return false
+	}
+	hash := conn.resumeHash
+	conn.sendMux.Lock()
+	conn.held = true
+	conn.socket = nil
+	conn.buffer = nil
+	conn.dropped = false
+	conn.holdTimer = time.AfterFunc(reconnectGrace, func() {
+		if release(hash) {
+			// UNLESS THEY LOGGED OUT OR WERE KICKED WHILE HELD
+			u.mux.Lock()
+			stillOn := u.conns[connID] == conn
+			u.mux.Unlock()
+			if stillOn {
+				expired()
+			}
+		}
+	})
+	conn.sendMux.Unlock()
+	u.mux.Unlock()
+
+	heldMux.Lock()
+	heldConns[hash] = heldConn{user: u, connID: connID, conn: conn}
+	heldMux.Unlock()
+	helpers.Log().Debug("Holding User for a reconnect", "user", u.Name(), "conn", connID)
+	return true
+}
+
+// release gives up on a held connection once its ReconnectGracePeriod is over. Returns false if the client already reconnected.
+func release(hash string) bool {
+	heldMux.Lock()
+	held, ok := heldConns[hash]
+	delete(heldConns, hash)
+	heldMux.Unlock()
+	if !ok {
+		return false
+	}
+	held.conn.sendMux.Lock()
+	held.conn.held = false
+	held.conn.buffer = nil
+	held.conn.sendMux.Unlock()
+	return true
+}
+
+// Reconnect is only for internal Gopher Game Server mechanics.
+func Reconnect(token string, socket *websocket.Conn, connUser **User, clientMux *sync.Mutex) (string, bool) {
+	hash := hashResumeToken(token)
+	heldMux.Lock()
+	held, ok := heldConns[hash]
+	delete(heldConns, hash)
+	heldMux.Unlock()
+	if !ok {
+		return "", false
+	}
+	newToken, tokenErr := helpers.GenerateSecureString(32)
+	if tokenErr != nil {
+		newToken = ""
+	}
+
+	u := held.user
+	u.mux.Lock()
+	conn := held.conn
+	if u.conns[held.connID] != conn {
+		// LOGGED OUT OR KICKED WHILE HELD - LET THE GRACE PERIOD RUN OUT AS USUAL
+		u.mux.Unlock()
+		heldMux.Lock()
+		heldConns[hash] = held
+		heldMux.Unlock()
+		return "", false
+	}
+	conn.user = connUser
+	conn.clientMux = clientMux
+	info := socketConnInfo(socket)
+	u.ip = info.ip
+	u.clientVersion = info.version
+	// THE CLIENT KEEPS ITS LOCALE WHEN IT DOESN'T SEND ONE AGAIN
+	if info.locale != "" {
+		conn.locale = info.locale
+	} else if conn.locale != "" {
+		SetConnLocale(socket, conn.locale)
+	}
+	u.locale = conn.locale
+	if newToken != "" {
+		conn.resumeHash = hashResumeToken(newToken)
+	}
+	var roomName string
+	room := conn.room
+	if room != nil {
+		roomName = room.Name()
+	}
+
+	// SWAP THE SOCKET, THEN FLUSH THE BUFFER IN ORDER
+	conn.sendMux.Lock()
+	conn.holdTimer.Stop()
+	conn.held = false
+	conn.socket = socket
+	conn.version = info.version
+	helpers.WriteMessage(socket, map[string]map[string]interface{}{
+		helpers.ServerActionReconnected: {
+			"n":  u.Name(),
+			"r":  roomName,
+			"rt": newToken,
+			"o":  conn.dropped,
+		},
+	})
+	for _, message := range conn.buffer {
+		helpers.WriteJSONData(socket, message)
+	}
+	conn.buffer = nil
+	conn.dropped = false
+	conn.sendMux.Unlock()
+	u.mux.Unlock()
+
+	clientMux.Lock()
+	*connUser = u
+	clientMux.Unlock()
+	helpers.Log().Debug("User reconnected", "user", u.Name(), "conn", held.connID)
+
+	// LET THE ROOM KNOW THEY'RE BACK
+	if room != nil {
+		room.broadcastReconnected(u.Name())
+	}
+
+	//
+	return held.connID, true
+}
+
+// broadcastReconnected tells everyone else in the Room that a User reconnected.
+func (r *Room) broadcastReconnected(userName string) {
+	message := helpers.NewEncoded(map[string]map[string]interface{}{
+		helpers.ServerActionUserReconnected: {
+			"u": userName,
+		},
+	})
+	r.mux.Lock()
+	for _, u := range r.usersMap {
+		u.mux.Lock()
+		if u.user.Name() != userName {
+			for _, conn := range u.conns {
+				(*conn).send(message)
+			}
+		}
+		u.mux.Unlock()
+	}
+	r.mux.Unlock()
+}
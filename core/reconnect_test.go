@@ -0,0 +1,129 @@
+package core
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnect(t *testing.T) {
+	defer func() {
+		reconnectGrace = 0
+		reconnectBufferSize = 0
+	}()
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	reconnectGrace = time.Hour
+	reconnectBufferSize = 2
+	room, roomErr := NewRoom("reconnectRoom", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	dropped, connID := testLogin(t, "reconnectDropped")
+	defer dropped.Kick()
+	dropped.Join(room, connID)
+	watcher, watcherID := testLogin(t, "reconnectWatcher")
+	defer watcher.Kick()
+	watcherSocket, watcherClient := testSocketPair(t)
+	watcher.mux.Lock()
+	watcherConn := watcher.conns[watcherID]
+	watcher.mux.Unlock()
+	watcherConn.sendMux.Lock()
+	watcherConn.socket = watcherSocket
+	watcherConn.sendMux.Unlock()
+	watcher.Join(room, watcherID)
+
+	dropped.mux.Lock()
+	dropped.conns[connID].resumeHash = hashResumeToken("droppedToken")
+	dropped.mux.Unlock()
+	if !dropped.Hold(connID, func() { t.Error("The held connection should not expire") }) {
+		t.Fatal("Expected the connection to be held")
+	} else if dropped.Socket(connID) != nil {
+		t.Error("A held connection should not have a socket")
+	}
+
+	// Messages sent while held are buffered, dropping the oldest
+	for _, message := range []string{"one", "two", "three"} {
+		dropped.DataMessage(message, connID)
+	}
+
+	var connUser *User
+	var clientMux sync.Mutex
+	if _, ok := Reconnect("wrongToken", testSocket(t), &connUser, &clientMux); ok {
+		t.Error("A connection should not reconnect with the wrong token")
+	}
+	socket, client := testSocketPair(t)
+	reconnectedID, ok := Reconnect("droppedToken", socket, &connUser, &clientMux)
+	if !ok {
+		t.Fatal("The connection should reconnect with its token")
+	} else if reconnectedID != connID || connUser != dropped {
+		t.Fatal("The client should be back on the same User and connection")
+	} else if dropped.RoomIn(connID) != room {
+		t.Error("The reconnected User should still be in their room")
+	}
+
+	var reconnected map[string]map[string]interface{}
+	if err := client.ReadJSON(&reconnected); err != nil {
+		t.Fatal(err)
+	} else if info := reconnected[helpers.ServerActionReconnected]; info == nil || info["r"] != "reconnectRoom" || info["o"] != true ||
+		info["rt"] == "" {
+		t.Error("Expected the reconnect message with the room, a new token, and that messages were dropped, got", reconnected)
+	}
+	for _, expected := range []string{"two", "three"} {
+		var message map[string]interface{}
+		if err := client.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		} else if message[helpers.ServerActionDataMessage] != expected {
+			t.Error("Expected the buffered message", expected, "got", message)
+		}
+	}
+
+	// The rest of the room is told they're back
+	for {
+		var message map[string]map[string]interface{}
+		if err := watcherClient.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		} else if info, ok := message[helpers.ServerActionUserReconnected]; ok {
+			if info["u"] != "reconnectDropped" {
+				t.Error("Expected the reconnected User's name, got", info)
+			}
+			break
+		}
+	}
+
+	if _, ok := Reconnect("droppedToken", testSocket(t), &connUser, &clientMux); ok {
+		t.Error("A token should only reconnect once")
+	}
+}
+
+func TestReconnectExpires(t *testing.T) {
+	defer func() {
+		reconnectGrace = 0
+		reconnectBufferSize = 0
+	}()
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	reconnectGrace = 10 * time.Millisecond
+	reconnectBufferSize = 1
+
+	user, connID := testLogin(t, "reconnectExpires")
+	defer user.Kick()
+	user.mux.Lock()
+	user.conns[connID].resumeHash = hashResumeToken("expiresToken")
+	user.mux.Unlock()
+	expired := make(chan bool, 1)
+	if !user.Hold(connID, func() { expired <- true }) {
+		t.Fatal("Expected the connection to be held")
+	}
+	select {
+	case <-expired:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the held connection to expire")
+	}
+	var connUser *User
+	var clientMux sync.Mutex
+	if _, ok := Reconnect("expiresToken", testSocket(t), &connUser, &clientMux); ok {
+		t.Error("A connection should not reconnect after its grace period")
+	}
+}
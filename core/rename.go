@@ -0,0 +1,179 @@
+package core
+
+import (
+	"context"
+	"github.com/hewiefreeman/GopherGameServer/database"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+)
+
+const (
+	errorGuestRename    = "Guests don't have an account to rename"
+	errorRenameFeatures = "Renaming accounts requires the SQL features"
+)
+
+var (
+	// ONE RENAME AT A TIME, SO NOTHING ELSE EVER HOLDS TWO SHARD LOCKS
+	renameMux sync.Mutex
+)
+
+// ChangeAccountName renames the User's account to newName with the SQL features enabled, after checking their password.
+// The NameChangeCallback can deny it. The User stays logged in under their new name: their Rooms, friends' lists, and any
+// Rooms they own or are invited to are updated, and the Users in their Room and their online friends get a
+// ServerActionUserRenamed message. A private message sent to the old name at the same time either gets to the User before
+// the rename, or doesn't find them after it.
+func (u *User) ChangeAccountName(newName string, password string) helpers.GopherError {
+	return u.ChangeAccountNameCtx(context.Background(), newName, password)
+}
+
+// ChangeAccountNameCtx is the same as ChangeAccountName, but gives up on the database when ctx is done.
+func (u *User) ChangeAccountNameCtx(ctx context.Context, newName string, password string) helpers.GopherError {
+	if !sqlFeatures {
+		return helpers.NewError(errorRenameFeatures, helpers.ErrorGopherFeatureDisabled)
+	} else if u.isGuest {
+		return helpers.NewError(errorGuestRename, helpers.ErrorGuestRestricted)
+	} else if len(newName) == 0 {
+		return helpers.NewError(errorRequiredName, helpers.ErrorAuthRequiredName)
+	}
+	newName, nameErr := helpers.CheckName(newName)
+	if nameErr.ID != 0 {
+		return nameErr
+	} else if newName == serverName {
+		return helpers.NewError(errorNameUnavail, helpers.ErrorAuthNameUnavail)
+	}
+	renameMux.Lock()
+	defer renameMux.Unlock()
+	oldName := u.Name()
+	if newName == oldName {
+		return helpers.NoError()
+	}
+
+	// Hold the new name, so nobody logs in with it while the database is renaming the account
+	newShard := shardFor(newName)
+	newShard.mux.Lock()
+	if _, online := newShard.users[newName]; online {
+		newShard.mux.Unlock()
+		return helpers.NewError(errorNameUnavail, helpers.ErrorAuthNameUnavail)
+	}
+	if newShard.renaming == nil {
+		newShard.renaming = make(map[string]bool)
+	}
+	newShard.renaming[newName] = true
+	newShard.mux.Unlock()
+
+	renameErr := database.ChangeAccountNameCtx(ctx, u.databaseID, newName, password)
+
+	// Move the User to their new name in one go
+	oldShard := shardFor(oldName)
+	oldShard.mux.Lock()
+	if newShard != oldShard {
+		newShard.mux.Lock()
+	}
+	delete(newShard.renaming, newName)
+	if renameErr.ID == 0 {
+		online := oldShard.users[oldName] == u
+		if online {
+			delete(oldShard.users, oldName)
+		}
+		u.nameMux.Lock()
+		u.name = newName
+		u.nameMux.Unlock()
+		if online {
+			newShard.users[newName] = u
+			releaseSkeleton(oldName)
+			claimSkeleton(newName, true)
+		}
+	}
+	if newShard != oldShard {
+		newShard.mux.Unlock()
+	}
+	oldShard.mux.Unlock()
+	if renameErr.ID != 0 {
+		return renameErr
+	}
+	if findUser(newName) == u {
+		clusterUserOffline(oldName)
+		clusterUserOnline(newName)
+		notifyPresence(PresenceLogout, oldName, StatusOffline, nil)
+		notifyPresence(PresenceLogin, newName, u.Status(), nil)
+	}
+
+	// Rename them in the Rooms they're in, own, or are invited to
+	message := helpers.NewEncoded(map[string]map[string]interface{}{
+		helpers.ServerActionUserRenamed: {
+			"o": oldName,
+			"n": newName,
+		},
+	})
+	for _, room := range GetRooms() {
+		room.renameUser(oldName, newName, message)
+	}
+
+	// Rename them in their online friends' lists
+	u.mux.Lock()
+	friendNames := make([]string, 0, len(u.friends))
+	for friendName := range u.friends {
+		friendNames = append(friendNames, friendName)
+	}
+	u.mux.Unlock()
+	for _, friendName := range friendNames {
+		friend := findUser(friendName)
+		if friend == nil {
+			continue
+		}
+		friend.mux.Lock()
+		if f, ok := friend.friends[oldName]; ok {
+			delete(friend.friends, oldName)
+			friend.friends[newName] = database.NewFriend(newName, u.databaseID, f.RequestStatus())
+			for _, conn := range friend.conns {
+				conn.send(message)
+			}
+		}
+		friend.mux.Unlock()
+	}
+
+	helpers.Log().Info("User renamed", "user", oldName, "name", newName)
+	return helpers.NoError()
+}
+
+// renameUser changes a User's name in the Room's User list, owner, invite list and muted Users, and tells the Users in the
+// Room about it if the User is one of them.
+func (r *Room) renameUser(oldName string, newName string, message *helpers.Encoded) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.usersMap == nil {
+		return
+	}
+	if r.owner == oldName {
+		r.owner = newName
+	}
+	for i, invited := range r.inviteList {
+		if invited == oldName {
+			r.inviteList[i] = newName
+		}
+	}
+	if r.muted[oldName] {
+		delete(r.muted, oldName)
+		r.muted[newName] = true
+	}
+	if r.turns != nil {
+		for i, turnName := range r.turns.order {
+			if turnName == oldName {
+				r.turns.order[i] = newName
+			}
+		}
+	}
+	ru, ok := r.usersMap[oldName]
+	if !ok {
+		return
+	}
+	delete(r.usersMap, oldName)
+	r.usersMap[newName] = ru
+	for _, roomUser := range r.usersMap {
+		roomUser.mux.Lock()
+		for _, conn := range roomUser.conns {
+			conn.send(message)
+		}
+		roomUser.mux.Unlock()
+	}
+}
@@ -0,0 +1,95 @@
+package core
+
+import (
+	"sort"
+	"strings"
+)
+
+// RoomFilter picks the Rooms that FindRooms() lists. Leave a field at its zero value to not filter by it. Private Rooms are
+// only listed for their owner and the Users on their invite list (see Viewer), unless AllPrivate is true.
+type RoomFilter struct {
+	Type         string   // Only Rooms of this RoomType
+	NotFull      bool     // Only Rooms that haven't reached their maximum User capacity, which spectators don't count towards
+	NamePrefix   string   // Only Rooms with names that start with this
+	HasVariables []string // Only Rooms that have all of these Room variables set
+	Variables    []string // The Room variables to put in each RoomSummary
+
+	Viewer     string // The User the list is for. Private Rooms they own or are invited to are listed
+	AllPrivate bool   // Lists every private Room, for server code
+	Listed     bool   // Lists Rooms like a client sees them: only RoomTypes with EnableListed(), and only their SetListedVariables()
+}
+
+// RoomSummary is what FindRooms() lists about a Room, taken all at once under the Room's lock.
+type RoomSummary struct {
+	Name       string
+	Type       string
+	Owner      string
+	Private    bool
+	Users      int // The players in the Room
+	Spectators int
+	MaxUsers   int                    // 0 means no limit, and spectators don't count towards it
+	Variables  map[string]interface{} // The RoomFilter's Variables that the Room has set
+}
+
+// FindRooms lists the Rooms that match the filter, sorted by name. Each Room is looked at under its own lock, so listing
+// thousands of Rooms doesn't hold up Users joining them, or anything else on the server.
+func FindRooms(filter RoomFilter) []RoomSummary {
+	summaries := []RoomSummary{}
+	for _, room := range GetRooms() {
+		roomType := roomTypes[room.rType]
+		if (filter.Type != "" && room.rType != filter.Type) || !strings.HasPrefix(room.name, filter.NamePrefix) ||
+			(filter.Listed && !roomType.Listed()) {
+			continue
+		}
+		if summary, ok := room.summary(filter, roomType); ok {
+			summaries = append(summaries, summary)
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Name < summaries[j].Name
+	})
+	return summaries
+}
+
+// summary makes the Room's RoomSummary, unless the Room doesn't match the filter or was deleted.
+func (r *Room) summary(filter RoomFilter, roomType *RoomType) (RoomSummary, bool) {
+	visible := func(key string) bool {
+		if !filter.Listed {
+			return true
+		}
+		for _, listedKey := range roomType.listedVars {
+			if listedKey == key {
+				return true
+			}
+		}
+		return false
+	}
+
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.usersMap == nil || (filter.NotFull && r.playersFull()) {
+		return RoomSummary{}, false
+	}
+	if r.private && !filter.AllPrivate {
+		invited := filter.Viewer != "" && r.owner == filter.Viewer
+		for i := 0; i < len(r.inviteList) && !invited; i++ {
+			invited = filter.Viewer != "" && r.inviteList[i] == filter.Viewer
+		}
+		if !invited {
+			return RoomSummary{}, false
+		}
+	}
+	for _, key := range filter.HasVariables {
+		if _, ok := r.vars[key]; !ok || !visible(key) {
+			return RoomSummary{}, false
+		}
+	}
+	summary := RoomSummary{Name: r.name, Type: r.rType, Owner: r.owner, Private: r.private,
+		Users: len(r.usersMap) - r.spectators, Spectators: r.spectators, MaxUsers: r.maxUsers, Variables: make(map[string]interface{})}
+	for _, key := range filter.Variables {
+		if val, ok := r.vars[key]; ok && visible(key) {
+			summary.Variables[key] = val
+		}
+	}
+	return summary, true
+}
@@ -0,0 +1,70 @@
+package core
+
+import (
+	"testing"
+)
+
+var testListedRoomType = NewRoomType("testListed", false).EnableListed().SetListedVariables("map")
+
+func TestFindRooms(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	var made []*Room
+	newRoom := func(name string, rType string, private bool, maxUsers int, owner string) *Room {
+		room, roomErr := NewRoom(name, rType, private, maxUsers, owner)
+		if roomErr != nil {
+			t.Fatal(roomErr)
+		}
+		made = append(made, room)
+		return room
+	}
+	defer func() {
+		for _, room := range made {
+			room.Delete()
+		}
+	}()
+	lobby := newRoom("listLobby", "testListed", false, 0, "")
+	full := newRoom("listFull", "testListed", false, 1, "")
+	secret := newRoom("listSecret", "testListed", true, 0, "listOwner")
+	newRoom("listHidden", "test", false, 0, "")
+	secret.AddInvite("listFriend")
+	lobby.SetVariables(map[string]interface{}{"map": "desert", "password": "hunter2"})
+	user, _ := testLogin(t, "listPlayer")
+	defer user.Kick()
+	user.Join(full, "")
+
+	names := func(summaries []RoomSummary) string {
+		list := ""
+		for _, summary := range summaries {
+			list += summary.Name + " "
+		}
+		return list
+	}
+
+	// Server code sees everything, sorted by name
+	if list := names(FindRooms(RoomFilter{NamePrefix: "list", AllPrivate: true})); list != "listFull listHidden listLobby listSecret " {
+		t.Error("Expected every Room, got", list)
+	}
+	// Clients only see listed RoomTypes, and private Rooms they own or are invited to
+	if list := names(FindRooms(RoomFilter{NamePrefix: "list", Listed: true})); list != "listFull listLobby " {
+		t.Error("Expected the public listed Rooms, got", list)
+	}
+	if list := names(FindRooms(RoomFilter{NamePrefix: "list", Listed: true, Viewer: "listFriend"})); list != "listFull listLobby listSecret " {
+		t.Error("Expected the invited User to see the private Room, got", list)
+	}
+	if list := names(FindRooms(RoomFilter{NamePrefix: "list", Listed: true, Viewer: "listOwner", NotFull: true})); list != "listLobby listSecret " {
+		t.Error("Expected the Rooms that aren't full, got", list)
+	}
+
+	// Clients only see and filter by the listed variables
+	summaries := FindRooms(RoomFilter{NamePrefix: "list", Listed: true, HasVariables: []string{"map"}, Variables: []string{"map", "password"}})
+	if len(summaries) != 1 || summaries[0].Variables["map"] != "desert" || summaries[0].Variables["password"] != nil {
+		t.Error("Expected listLobby with only its listed variable, got", summaries)
+	}
+	if summaries := FindRooms(RoomFilter{NamePrefix: "list", Listed: true, HasVariables: []string{"password"}}); len(summaries) != 0 {
+		t.Error("Clients shouldn't be able to filter by a variable that isn't listed")
+	}
+	if summaries := FindRooms(RoomFilter{Type: "testListed", NamePrefix: "listFull"}); len(summaries) != 1 || summaries[0].Users != 1 ||
+		summaries[0].MaxUsers != 1 {
+		t.Error("Expected listFull with 1 of 1 Users, got", summaries)
+	}
+}
@@ -1,5 +1,11 @@
 package core
 
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync/atomic"
+	"time"
+)
+
 var (
 	roomTypes = make(map[string]*RoomType)
 )
@@ -8,6 +14,8 @@ var (
 // options for a RoomType before starting the server. Doing so at any other time will have no effect
 // at all.
 type RoomType struct {
+	roomCount int64 // THE NUMBER OF Rooms OF THIS TYPE - ATOMIC, SO IT'S FIRST FOR 64-BIT ALIGNMENT
+
 	serverOnly bool
 
 	voiceChat bool
@@ -15,10 +23,25 @@ type RoomType struct {
 	broadcastUserEnter bool
 	broadcastUserLeave bool
 
-	createCallback    func(*Room)            // roomCreated
-	deleteCallback    func(*Room)            // roomDeleted
-	userEnterCallback func(*Room, *RoomUser) // roomFrom, user
-	userLeaveCallback func(*Room, *RoomUser) // roomFrom, user
+	chatHistoryLen int
+	maxUsers       int
+	listed         bool
+	listedVars     []string
+	ownerTransfer  bool
+	spectators     bool
+	recordEvents   bool
+
+	broadcastRate    int // MESSAGES EACH User CAN SEND TO THE Room PER SECOND
+	broadcastBurst   int
+	coalesceInterval time.Duration
+
+	createCallback     func(*Room)                                          // roomCreated
+	deleteCallback     func(*Room)                                          // roomDeleted
+	userEnterCallback  func(*Room, *RoomUser)                               // roomFrom, user
+	userLeaveCallback  func(*Room, *RoomUser)                               // roomFrom, user
+	chatMessageHandler func(*Room, string, interface{}) (interface{}, bool) // room, author, message
+	timerCallbacks     map[string]func(*Room)                               // timer name -> room
+	turnTimeout        func(*Room, string)                                  // room, user
 }
 
 // NewRoomType Adds a RoomType to the server. A RoomType is used in conjunction with it's corresponding callbacks
@@ -45,10 +68,17 @@ func NewRoomType(name string, serverOnly bool) *RoomType {
 		broadcastUserEnter: false,
 		broadcastUserLeave: false,
 
-		createCallback:    nil,
-		deleteCallback:    nil,
-		userEnterCallback: nil,
-		userLeaveCallback: nil}
+		chatHistoryLen: -1,
+		maxUsers:       0,
+		listed:         false,
+		ownerTransfer:  false,
+		spectators:     false,
+
+		createCallback:     nil,
+		deleteCallback:     nil,
+		userEnterCallback:  nil,
+		userLeaveCallback:  nil,
+		chatMessageHandler: nil}
 
 	roomTypes[name] = &rt
 
@@ -100,6 +130,130 @@ func (r *RoomType) EnableBroadcastUserLeave() *RoomType {
 	return r
 }
 
+// SetChatHistoryLen sets how many of the latest chat messages Rooms of this RoomType keep, overriding ChatHistoryLen
+// in ServerSettings. Users that join the Room will be sent the chat history, and can request it with the client APIs.
+// Use 0 to disable the chat history for this RoomType.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) SetChatHistoryLen(length int) *RoomType {
+	if serverStarted || length < 0 {
+		return r
+	}
+	(*r).chatHistoryLen = length
+	return r
+}
+
+// SetMaxUsers sets the default maximum User capacity for Rooms of this RoomType. Rooms made with a maxUsers of 0 get this
+// capacity, and Rooms made by clients can't have a capacity above it. Use 0 for no limit.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) SetMaxUsers(maxUsers int) *RoomType {
+	if serverStarted || maxUsers < 0 {
+		return r
+	}
+	(*r).maxUsers = maxUsers
+	return r
+}
+
+// EnableListed makes the public Rooms of this RoomType show up in core.GetListedRooms(), for instance to make
+// a list of Rooms for matchmaking.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) EnableListed() *RoomType {
+	if serverStarted {
+		return r
+	}
+	(*r).listed = true
+	return r
+}
+
+// SetListedVariables sets the Room variables of this RoomType that clients can see and filter by in room listings. Clients
+// can't see any Room variables in room listings without it.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) SetListedVariables(keys ...string) *RoomType {
+	if serverStarted {
+		return r
+	}
+	(*r).listedVars = append([]string{}, keys...)
+	return r
+}
+
+// EnableOwnerTransfer makes Rooms of this RoomType get a new owner instead of being deleted when their owner leaves with
+// RoomDeleteOnLeave in ServerSettings enabled. The User who has been in the Room the longest becomes the new owner. If
+// nobody is left in the Room, it is deleted like usual.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) EnableOwnerTransfer() *RoomType {
+	if serverStarted {
+		return r
+	}
+	(*r).ownerTransfer = true
+	return r
+}
+
+// EnableSpectators lets Users spectate Rooms of this RoomType. Spectators get everything sent to the Room, but don't
+// count towards its maximum User capacity, and can't use voice chat or CustomClientActions unless they're allowed with
+// actions.AllowSpectators(). Turn it off for a single Room with *Room.SetSpectatorsAllowed().
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) EnableSpectators() *RoomType {
+	if serverStarted {
+		return r
+	}
+	(*r).spectators = true
+	return r
+}
+
+// EnableRecordEvents makes Rooms of this RoomType record everything that happens in them, so you can find out what happened
+// in a match later, like when a player is reported for cheating. Every client action sent by a User in the Room, chat, server
+// and data message, Room variable change, join, leave, and timer going off is recorded with a time and sequence number. The
+// events are written to a file for each Room in the RecoveryLocation, which is rotated when it reaches EventLogMaxSize in
+// ServerSettings, or to the EventSink set with core.SetEventSink(). They're written by their own goroutine, so recording them
+// doesn't slow down the Room. Get a Room's latest events with *Room.EventLog().
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) EnableRecordEvents() *RoomType {
+	if serverStarted {
+		return r
+	}
+	(*r).recordEvents = true
+	return r
+}
+
+// SetBroadcastRateLimit limits how many chat messages and *Room.SendUpdate() updates each User can send to a Room of this
+// RoomType, to perSecond messages a second with bursts of up to burst messages. Messages over the limit are dropped, and
+// the User's client gets a helpers.ErrorBroadcastLimited error. A burst below 1 uses perSecond. Setting perSecond to 0
+// means no limit.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) SetBroadcastRateLimit(perSecond int, burst int) *RoomType {
+	if serverStarted || perSecond < 0 {
+		return r
+	}
+	if burst < 1 {
+		burst = perSecond
+	}
+	(*r).broadcastRate = perSecond
+	(*r).broadcastBurst = burst
+	return r
+}
+
+// SetCoalesceInterval makes the Rooms of this RoomType collect updates sent with *Room.SendUpdate(), and send them every
+// interval in one message, with only the latest value of each of a User's keys. For instance, with an interval of 50
+// milliseconds, 200 position updates from a User in a second become 20 messages. Coalesced updates aren't rate limited by
+// SetBroadcastRateLimit(). Updates that are waiting are sent right away when a User leaves, so the Room always sees their
+// last values. Setting the interval to 0 sends updates right away.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) SetCoalesceInterval(interval time.Duration) *RoomType {
+	if serverStarted || interval < 0 {
+		return r
+	}
+	(*r).coalesceInterval = interval
+	return r
+}
+
 // SetCreateCallback is executed when someone creates a Room of this RoomType by setting the creation
 // callback. Your function must take in a Room object as the parameter which is a reference of the created room.
 //
@@ -108,7 +262,7 @@ func (r *RoomType) SetCreateCallback(callback func(*Room)) *RoomType {
 	if serverStarted {
 		return r
 	}
-	(*r).createCallback = callback
+	(*r).createCallback = protectRoomCallback("room create callback", callback)
 	return r
 }
 
@@ -120,7 +274,7 @@ func (r *RoomType) SetDeleteCallback(callback func(*Room)) *RoomType {
 	if serverStarted {
 		return r
 	}
-	(*r).deleteCallback = callback
+	(*r).deleteCallback = protectRoomCallback("room delete callback", callback)
 	return r
 }
 
@@ -133,7 +287,7 @@ func (r *RoomType) SetUserEnterCallback(callback func(*Room, *RoomUser)) *RoomTy
 	if serverStarted {
 		return r
 	}
-	(*r).userEnterCallback = callback
+	(*r).userEnterCallback = protectRoomUserCallback("user enter callback", callback)
 	return r
 }
 
@@ -146,7 +300,63 @@ func (r *RoomType) SetUserLeaveCallback(callback func(*Room, *RoomUser)) *RoomTy
 	if serverStarted {
 		return r
 	}
-	(*r).userLeaveCallback = callback
+	(*r).userLeaveCallback = protectRoomUserCallback("user leave callback", callback)
+	return r
+}
+
+// SetChatMessageHandler sets a handler for chat messages sent in Rooms of this RoomType. Your function must take in the Room,
+// the name of the author, and the message, and returns the message to send to the Room and a bool. The handler can change
+// the message before it's sent, or return false to not send it at all, for instance to filter profanity or only let
+// some Users chat. Messages that are not sent don't get added to the Room's chat history.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) SetChatMessageHandler(handler func(*Room, string, interface{}) (interface{}, bool)) *RoomType {
+	if serverStarted {
+		return r
+	}
+	if handler != nil {
+		// A PANIC DROPS THE MESSAGE
+		(*r).chatMessageHandler = func(room *Room, author string, message interface{}) (send interface{}, ok bool) {
+			helpers.Protect("chat message handler", func() { send, ok = handler(room, author, message) }, "room", room.Name(), "user", author)
+			return
+		}
+	} else {
+		(*r).chatMessageHandler = nil
+	}
+	return r
+}
+
+// SetTimerCallback sets the function that timers named name run in Rooms of this RoomType when they're set with a nil
+// function, and when repeating timers are restarted after a recovery. Your function must take in the Room the timer is on.
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) SetTimerCallback(name string, callback func(*Room)) *RoomType {
+	if serverStarted || len(name) == 0 {
+		return r
+	}
+	if (*r).timerCallbacks == nil {
+		(*r).timerCallbacks = make(map[string]func(*Room))
+	}
+	(*r).timerCallbacks[name] = callback
+	return r
+}
+
+// SetTurnTimeoutCallback sets the function that's called when a User's turn times out in a Room of this RoomType (see
+// *Room.EnableTurns()), instead of giving the turn to the next User. Your function must take in the Room and the name of the
+// User whose turn timed out. Use it to play a default move, and end the turn with *Room.EndTurn().
+//
+// Note: You must call this BEFORE starting the server in order for it to take effect.
+func (r *RoomType) SetTurnTimeoutCallback(callback func(*Room, string)) *RoomType {
+	if serverStarted {
+		return r
+	}
+	if callback != nil {
+		(*r).turnTimeout = func(room *Room, userName string) {
+			helpers.Protect("turn timeout callback", func() { callback(room, userName) }, "room", room.Name(), "user", userName)
+		}
+	} else {
+		(*r).turnTimeout = nil
+	}
 	return r
 }
 
@@ -174,6 +384,57 @@ func (r *RoomType) BroadcastUserLeave() bool {
 	return r.broadcastUserLeave
 }
 
+// ChatHistoryLen returns how many chat messages Rooms of this RoomType keep. Returns -1 if the RoomType
+// uses ChatHistoryLen from ServerSettings.
+func (r *RoomType) ChatHistoryLen() int {
+	return r.chatHistoryLen
+}
+
+// MaxUsers returns the default maximum User capacity for Rooms of this RoomType. 0 means no limit.
+func (r *RoomType) MaxUsers() int {
+	return r.maxUsers
+}
+
+// Listed returns true if the public Rooms of this RoomType are listed with core.GetListedRooms().
+func (r *RoomType) Listed() bool {
+	return r.listed
+}
+
+// ListedVariables returns the Room variables of this RoomType that clients can see in room listings.
+func (r *RoomType) ListedVariables() []string {
+	return append([]string{}, r.listedVars...)
+}
+
+// RoomCount returns the number of Rooms of this RoomType on the server.
+func (r *RoomType) RoomCount() int {
+	return int(atomic.LoadInt64(&r.roomCount))
+}
+
+// OwnerTransfer returns true if Rooms of this RoomType get a new owner when their owner leaves, instead of being deleted.
+func (r *RoomType) OwnerTransfer() bool {
+	return r.ownerTransfer
+}
+
+// SpectatorsAllowed returns true if Users can spectate new Rooms of this RoomType.
+func (r *RoomType) SpectatorsAllowed() bool {
+	return r.spectators
+}
+
+// RecordsEvents returns true if Rooms of this RoomType record their events.
+func (r *RoomType) RecordsEvents() bool {
+	return r.recordEvents
+}
+
+// BroadcastRateLimit returns the number of messages each User can send to a Room of this RoomType per second, and in a burst.
+func (r *RoomType) BroadcastRateLimit() (int, int) {
+	return r.broadcastRate, r.broadcastBurst
+}
+
+// CoalesceInterval returns how often Rooms of this RoomType send the updates they collected from *Room.SendUpdate().
+func (r *RoomType) CoalesceInterval() time.Duration {
+	return r.coalesceInterval
+}
+
 // CreateCallback returns the function that this RoomType calls when a Room of this RoomType is created.
 func (r *RoomType) CreateCallback() func(*Room) {
 	return r.createCallback
@@ -213,3 +474,49 @@ func (r *RoomType) UserLeaveCallback() func(*Room, *RoomUser) {
 func (r *RoomType) HasUserLeaveCallback() bool {
 	return r.userLeaveCallback != nil
 }
+
+// ChatMessageHandler returns the handler for chat messages sent in Rooms of this RoomType.
+func (r *RoomType) ChatMessageHandler() func(*Room, string, interface{}) (interface{}, bool) {
+	return r.chatMessageHandler
+}
+
+// HasChatMessageHandler returns true if this RoomType has a chat message handler.
+func (r *RoomType) HasChatMessageHandler() bool {
+	return r.chatMessageHandler != nil
+}
+
+// TimerCallback returns the function that timers named name run in Rooms of this RoomType, or nil if it has none.
+func (r *RoomType) TimerCallback(name string) func(*Room) {
+	return r.timerCallbacks[name]
+}
+
+// TurnTimeoutCallback returns the function that's called when a User's turn times out in a Room of this RoomType.
+func (r *RoomType) TurnTimeoutCallback() func(*Room, string) {
+	return r.turnTimeout
+}
+
+// HasTurnTimeoutCallback returns true if this RoomType has a turn timeout callback.
+func (r *RoomType) HasTurnTimeoutCallback() bool {
+	return r.turnTimeout != nil
+}
+
+// protectRoomCallback wraps a RoomType callback, so a panic in it is reported instead of crashing the server.
+func protectRoomCallback(where string, callback func(*Room)) func(*Room) {
+	if callback == nil {
+		return nil
+	}
+	return func(room *Room) {
+		helpers.Protect(where, func() { callback(room) }, "room", room.Name())
+	}
+}
+
+// protectRoomUserCallback wraps a RoomType User enter or leave callback, so a panic in it is reported instead of crashing
+// the server.
+func protectRoomUserCallback(where string, callback func(*Room, *RoomUser)) func(*Room, *RoomUser) {
+	if callback == nil {
+		return nil
+	}
+	return func(room *Room, user *RoomUser) {
+		helpers.Protect(where, func() { callback(room, user) }, "room", room.Name())
+	}
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"time"
+)
+
+var (
+	// ErrBroadcastLimited is returned when a User's chat message or update is dropped for going over their Room's
+	// RoomType's broadcast rate limit.
+	ErrBroadcastLimited error = helpers.NewError("You are sending messages to the room too fast", helpers.ErrorBroadcastLimited)
+)
+
+// sendBucket is a User's rate limit for sending messages to a Room. A new sendBucket is full.
+type sendBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a message from the bucket, which gets perSecond messages back a second, and holds up to burst.
+func (b *sendBucket) take(perSecond int, burst int, now time.Time) bool {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if b.tokens += now.Sub(b.last).Seconds() * float64(perSecond); b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true
+	}
+	return false
+}
+
+// allowBroadcast takes a message from the User's rate limit in the Room. Messages from anyone that isn't in the Room, like
+// the server, aren't limited. Must lock the Room's mux to use.
+func (r *Room) allowBroadcast(userName string) bool {
+	perSecond, burst := roomTypes[r.rType].BroadcastRateLimit()
+	if perSecond == 0 {
+		return true
+	} else if _, ok := r.usersMap[userName]; !ok {
+		return true
+	}
+	bucket := r.limiters[userName]
+	if bucket == nil {
+		if r.limiters == nil {
+			r.limiters = make(map[string]*sendBucket)
+		}
+		bucket = &sendBucket{}
+		r.limiters[userName] = bucket
+	}
+	return bucket.take(perSecond, burst, time.Now())
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   USER UPDATES   //////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SendUpdate sends a User's updates, like their position, to everyone in the Room in a helpers.ServerActionRoomUpdates
+// message. The message maps the names of the Users that sent updates to the keys and values they sent. Clients send
+// updates with the helpers.ClientActionRoomUpdate client action. Spectators can't send updates.
+//
+// When the Room's RoomType has a coalesce interval (see *RoomType.SetCoalesceInterval()), the updates are collected and sent
+// together every interval, with only the latest value of each key. Otherwise, they're sent right away, and are dropped with
+// ErrBroadcastLimited when the User goes over the RoomType's broadcast rate limit (see *RoomType.SetBroadcastRateLimit()).
+func (r *Room) SendUpdate(userName string, values map[string]interface{}) error {
+	if len(userName) == 0 {
+		return errors.New("*Room.SendUpdate() requires a user name")
+	} else if len(values) == 0 {
+		return errors.New("*Room.SendUpdate() requires values")
+	}
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	if ru, ok := r.usersMap[userName]; !ok {
+		r.mux.Unlock()
+		return helpers.NewError("User '"+userName+"' is not in room '"+r.name+"'", helpers.ErrorNotInRoom)
+	} else if ru.IsSpectator() {
+		r.mux.Unlock()
+		return helpers.NewError("Spectators can't send updates", helpers.ErrorSpectating)
+	}
+
+	// COLLECT THE UPDATES UNTIL THE NEXT FLUSH
+	if interval := roomTypes[r.rType].CoalesceInterval(); interval > 0 {
+		if r.updates == nil {
+			r.updates = make(map[string]map[string]interface{})
+		}
+		pending := r.updates[userName]
+		if pending == nil {
+			pending = make(map[string]interface{}, len(values))
+			r.updates[userName] = pending
+		}
+		for key, val := range values {
+			pending[key] = val
+		}
+		if r.flushTimer == nil {
+			r.flushTimer = time.AfterFunc(interval, r.flushUpdates)
+		}
+		r.mux.Unlock()
+		return nil
+	}
+
+	if !r.allowBroadcast(userName) {
+		r.mux.Unlock()
+		return ErrBroadcastLimited
+	}
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	update := make(map[string]interface{}, len(values))
+	for key, val := range values {
+		update[key] = val
+	}
+	sendToUsers(userList, updatesMessage(map[string]map[string]interface{}{userName: update}))
+
+	//
+	return nil
+}
+
+// flushUpdates sends the updates the Room has collected, if there are any.
+func (r *Room) flushUpdates() {
+	r.mux.Lock()
+	r.stopFlush()
+	updates := r.updates
+	r.updates = nil
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	if len(updates) > 0 {
+		sendToUsers(userList, updatesMessage(updates))
+	}
+}
+
+// stopFlush stops the Room's next flush of its collected updates. Must lock the Room's mux to use.
+func (r *Room) stopFlush() {
+	if r.flushTimer != nil {
+		r.flushTimer.Stop()
+		r.flushTimer = nil
+	}
+}
+
+func updatesMessage(updates map[string]map[string]interface{}) *helpers.Encoded {
+	return helpers.NewEncoded(map[string]interface{}{
+		helpers.ServerActionRoomUpdates: updates,
+	})
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroadcastRateLimit(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	NewRoomType("testLimited", false).SetBroadcastRateLimit(10, 3)
+	room, roomErr := NewRoom("limitedRoom", "testLimited", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	user, _ := testLogin(t, "limitedUser")
+	defer user.Kick()
+	if err := user.Join(room, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// The burst goes through, then the rest are dropped
+	for i := 0; i < 3; i++ {
+		if err := room.SendUpdate("limitedUser", map[string]interface{}{"x": i}); err != nil {
+			t.Fatal("Expected the burst to go through, got", err)
+		}
+	}
+	if err := room.SendUpdate("limitedUser", map[string]interface{}{"x": 3}); err != ErrBroadcastLimited {
+		t.Error("Expected ErrBroadcastLimited, got", err)
+	} else if err := room.ChatMessage("limitedUser", "hi"); err != ErrBroadcastLimited {
+		t.Error("Chat messages should share the limit, got", err)
+	}
+
+	// The server isn't limited
+	if err := room.ServerMessage("hi", 0, nil); err != nil {
+		t.Error(err)
+	}
+
+	// Messages come back over time
+	time.Sleep(time.Millisecond * 150)
+	if err := room.ChatMessage("limitedUser", "hi"); err != nil {
+		t.Error("Expected the limit to refill, got", err)
+	}
+}
+
+func TestSendBucket(t *testing.T) {
+	var bucket sendBucket
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if !bucket.take(1, 2, now) {
+			t.Fatal("A new bucket should be full")
+		}
+	}
+	if bucket.take(1, 2, now) {
+		t.Error("Expected the bucket to be empty")
+	}
+	// It never holds more than the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if !bucket.take(1, 2, now) {
+			t.Fatal("Expected the bucket to refill")
+		}
+	}
+	if bucket.take(1, 2, now) {
+		t.Error("The bucket should only hold the burst")
+	}
+}
+
+func TestCoalesceUpdates(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	NewRoomType("testCoalesce", false).SetCoalesceInterval(time.Hour)
+	room, roomErr := NewRoom("coalesceRoom", "testCoalesce", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	first, _ := testLogin(t, "coalesceFirst")
+	defer first.Kick()
+	second, _ := testLogin(t, "coalesceSecond")
+	defer second.Kick()
+	first.Join(room, "")
+	second.Join(room, "")
+
+	if err := room.SendUpdate("coalesceOutsider", map[string]interface{}{"x": 1}); err == nil {
+		t.Error("Users that aren't in the Room can't send updates")
+	}
+
+	// Only the latest value of each key is kept
+	room.SendUpdate("coalesceFirst", map[string]interface{}{"x": 1, "y": 1})
+	room.SendUpdate("coalesceFirst", map[string]interface{}{"x": 2})
+	room.SendUpdate("coalesceSecond", map[string]interface{}{"x": 5})
+	room.mux.Lock()
+	updates := room.updates
+	timerSet := room.flushTimer != nil
+	room.mux.Unlock()
+	if !timerSet {
+		t.Error("Expected a flush to be waiting")
+	}
+	if len(updates) != 2 || updates["coalesceFirst"]["x"] != 2 || updates["coalesceFirst"]["y"] != 1 ||
+		updates["coalesceSecond"]["x"] != 5 {
+
+		t.Error("Expected the updates to be merged, got", updates)
+	}
+
+	// Leaving sends what was collected
+	second.Leave("")
+	room.mux.Lock()
+	updates = room.updates
+	timerSet = room.flushTimer != nil
+	room.mux.Unlock()
+	if updates != nil || timerSet {
+		t.Error("Expected the updates to be flushed when a User leaves, got", updates)
+	}
+}
@@ -1,9 +1,13 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Room represents a room on the server that Users can join and leave. Use core.NewRoom() to make a new Room.
@@ -15,27 +19,79 @@ type Room struct {
 	name     string
 	rType    string
 	private  bool
-	owner    string
 	maxUsers int
 
 	//mux LOCKS ALL FIELDS BELOW
 	mux        sync.Mutex
+	owner      string
 	inviteList []string
 	usersMap   map[string]*RoomUser
 	vars       map[string]interface{}
+	joinCount  int
+	voiceChat  bool
+	muted      map[string]bool
+	timers     map[string]*roomTimer
+	spectators int // THE NUMBER OF SPECTATORS IN usersMap
+	spectate   bool
+	turns      *turnState
+	synced     map[string]interface{}
+	syncSeq    uint64
+	persistent bool
+	expiry     *time.Timer // DELETES THE ROOM WHEN IT HAS BEEN EMPTY FOR emptyRoomTTL
+	emptySince time.Time
+	limiters   map[string]*sendBucket
+	updates    map[string]map[string]interface{} // UPDATES WAITING FOR flushTimer, BY User
+	flushTimer *time.Timer
+
+	//syncMux KEEPS THE SYNCED STATE MESSAGES GOING OUT IN ORDER
+	syncMux sync.Mutex
+
+	chatHistory *chatHistory
+	events      *eventLog // nil WHEN THE RoomType DOESN'T RECORD EVENTS
 }
 
 // RoomUser represents a User inside of a Room. Use the *RoomUser.User() function to get a *User from a *RoomUser
 type RoomUser struct {
-	user *User
+	user    *User
+	joinNum int // The order the User joined the Room in
 
-	mux   sync.Mutex
-	conns map[string]*userConn
+	mux       sync.Mutex
+	conns     map[string]*userConn
+	spectator bool // ONLY CHANGED WITH THE Room's mux LOCKED TOO
 }
 
 var (
 	rooms    map[string]*Room = make(map[string]*Room)
 	roomsMux sync.Mutex
+
+	// THE NUMBER OF Rooms IN rooms, READ WITHOUT LOCKING roomsMux
+	roomCount int64
+
+	// ErrRoomFull is returned when a User can't join a Room because it has reached its maximum User capacity.
+	ErrRoomFull error = helpers.NewError("The room is full", helpers.ErrorRoomFull)
+	// ErrNotInvited is returned when a User can't join a private Room because they are not on its invite list.
+	ErrNotInvited error = helpers.NewError("You are not invited to the room", helpers.ErrorNotInvited)
+	// ErrInOtherRoom is returned when a User's connection can't be added to a Room because it is still in another one.
+	ErrInOtherRoom error = helpers.NewError("The user is already in another room", helpers.ErrorAlreadyInRoom)
+	// ErrTimeout is returned by the Ctx functions, like GetRoomCtx(), when their Context is done before they finish.
+	ErrTimeout error = helpers.NewError("The action timed out", helpers.ErrorTimeout)
+
+	// RoomJoinCallback is only for internal Gopher Game Server mechanics.
+	RoomJoinCallback func(string, string)
+	// RoomLeaveCallback is only for internal Gopher Game Server mechanics.
+	RoomLeaveCallback func(string, string, int)
+)
+
+const (
+	errorInviteRevoked = "Your invite was revoked"
+)
+
+// These are the reasons a User could have left a Room, which get passed to the room leave callback.
+const (
+	LeaveReasonVoluntary = iota // The User left the Room, or joined another one
+	LeaveReasonLogout           // The User logged out or their connection dropped
+	LeaveReasonKick             // The User was kicked, or removed from the Room with *Room.RemoveUser()
+	LeaveReasonDeleted          // The Room was deleted
 )
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -51,7 +107,7 @@ var (
 //
 // - isPrivate (bool): Indicates if the room is private or not
 //
-// - maxUsers (int): Maximum User capacity (Note: 0 means no limit)
+// - maxUsers (int): Maximum User capacity (Note: 0 uses the RoomType's MaxUsers, which is no limit by default)
 //
 // - owner (string): The owner of the room. If provided a blank string, will set the owner to the ServerName from ServerSettings
 func NewRoom(name string, rType string, isPrivate bool, maxUsers int, owner string) (*Room, error) {
@@ -60,26 +116,50 @@ func NewRoom(name string, rType string, isPrivate bool, maxUsers int, owner stri
 		return &Room{}, errors.New("core.NewRoom() requires a name")
 	} else if maxUsers < 0 {
 		maxUsers = 0
-	} else if owner == "" {
+	}
+	if owner == "" {
 		owner = serverName
 	}
 
 	var roomType *RoomType
 	var ok bool
 	if roomType, ok = roomTypes[rType]; !ok {
-		return &Room{}, errors.New("Invalid room type")
+		return &Room{}, helpers.NewError("Invalid room type", helpers.ErrorRoomTypeInvalid)
 	}
 
 	//ADD THE ROOM
 	roomsMux.Lock()
 	if _, ok := rooms[name]; ok {
 		roomsMux.Unlock()
-		return &Room{}, errors.New("A Room with the name '" + name + "' already exists")
+		return &Room{}, helpers.NewError("A Room with the name '"+name+"' already exists", helpers.ErrorRoomExists)
+	} else if remoteRoomNode(name) != "" {
+		roomsMux.Unlock()
+		return &Room{}, helpers.NewError("A Room with the name '"+name+"' already exists on another node", helpers.ErrorRoomExists)
+	}
+	if maxUsers == 0 {
+		maxUsers = roomType.MaxUsers()
+	}
+	historyLen := roomType.ChatHistoryLen()
+	if historyLen < 0 {
+		historyLen = chatHistoryLen
 	}
 	theRoom := Room{name: name, private: isPrivate, inviteList: []string{}, usersMap: make(map[string]*RoomUser), maxUsers: maxUsers,
-		vars: make(map[string]interface{}), owner: owner, rType: rType}
+		vars: make(map[string]interface{}), owner: owner, rType: rType, chatHistory: newChatHistory(historyLen),
+		spectate: roomType.SpectatorsAllowed()}
+	if roomType.RecordsEvents() {
+		theRoom.events = newEventLog(name)
+		theRoom.events.record(EventCreate, "", map[string]interface{}{"t": rType, "p": isPrivate, "m": maxUsers, "o": owner})
+	}
+	// THE EXPIRY TIMER LOCKS THE Room, SO IT CAN'T FIRE BEFORE r.expiry IS SET
+	theRoom.mux.Lock()
+	theRoom.startExpiry()
+	theRoom.mux.Unlock()
 	rooms[name] = &theRoom
+	atomic.AddInt64(&roomCount, 1)
+	atomic.AddInt64(&roomType.roomCount, 1)
 	roomsMux.Unlock()
+	clusterRoomCreated(name)
+	helpers.Log().Debug("Room created", "room", name, "type", rType, "owner", owner)
 
 	//CALLBACK
 	if roomType.HasCreateCallback() {
@@ -96,21 +176,23 @@ func NewRoom(name string, rType string, isPrivate bool, maxUsers int, owner stri
 // Delete deletes the Room from the server. Will also send a room leave message to all the Users in the Room that you can
 // capture with the client APIs.
 func (r *Room) Delete() error {
+	r.flushUpdates()
 	r.mux.Lock()
 	if r.usersMap == nil {
 		r.mux.Unlock()
-		return errors.New("The room '" + r.name + "' does not exist")
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
 	}
 
 	// MAKE LEAVE MESSAGE
-	leaveMessage := helpers.MakeClientResponse(helpers.ClientActionLeaveRoom, nil, helpers.NoError())
+	leaveMessage := helpers.NewEncoded(helpers.MakeClientResponse(helpers.ClientActionLeaveRoom, nil, helpers.NoError()))
 
 	// GO THROUGH ALL Users IN ROOM
-	for _, u := range r.usersMap {
+	userList := r.usersMap
+	for _, u := range userList {
 		//CHANGE User's room POINTER TO nil & SEND MESSAGES
 		u.mux.Lock()
 		for key := range u.conns {
-			(*u.conns[key]).socket.WriteJSON(leaveMessage)
+			(*u.conns[key]).send(leaveMessage)
 			u.user.mux.Lock()
 			(*u.conns[key]).room = nil
 			u.user.mux.Unlock()
@@ -119,12 +201,27 @@ func (r *Room) Delete() error {
 	}
 
 	r.usersMap = nil
+	r.stopTimers()
+	r.stopTurns()
+	r.stopExpiry()
+	r.stopFlush()
 	r.mux.Unlock()
 
 	// DELETE THE ROOM
 	roomsMux.Lock()
 	delete(rooms, r.name)
+	atomic.AddInt64(&roomCount, -1)
+	atomic.AddInt64(&roomTypes[r.rType].roomCount, -1)
 	roomsMux.Unlock()
+	clusterRoomDeleted(r.name)
+	helpers.Log().Debug("Room deleted", "room", r.name)
+
+	// ROOM LEAVE CALLBACK FOR EVERYONE LEFT IN THE ROOM
+	if RoomLeaveCallback != nil {
+		for userName := range userList {
+			RoomLeaveCallback(r.name, userName, LeaveReasonDeleted)
+		}
+	}
 
 	// CALLBACK
 	rType := roomTypes[r.rType]
@@ -132,6 +229,9 @@ func (r *Room) Delete() error {
 		rType.DeleteCallback()(r)
 	}
 
+	// FINISH THE EVENT LOG - ITS WRITER WRITES WHAT'S LEFT, THEN CLOSES IT
+	r.events.finish(EventDelete)
+
 	//
 	return nil
 }
@@ -142,9 +242,16 @@ func (r *Room) Delete() error {
 
 // GetRoom finds a Room on the server. If the room does not exit, an error will be returned.
 func GetRoom(roomName string) (*Room, error) {
+	return GetRoomCtx(context.Background(), roomName)
+}
+
+// GetRoomCtx is the same as GetRoom, but returns ErrTimeout if ctx is done before the Room is found.
+func GetRoomCtx(ctx context.Context, roomName string) (*Room, error) {
 	//REJECT INCORRECT INPUT
 	if len(roomName) == 0 {
 		return &Room{}, errors.New("core.GetRoom() requires a room name")
+	} else if ctx.Err() != nil {
+		return &Room{}, ErrTimeout
 	}
 
 	var room *Room
@@ -153,7 +260,7 @@ func GetRoom(roomName string) (*Room, error) {
 	roomsMux.Lock()
 	if room, ok = rooms[roomName]; !ok {
 		roomsMux.Unlock()
-		return &Room{}, errors.New("The room '" + roomName + "' does not exist")
+		return &Room{}, helpers.NewError("The room '"+roomName+"' does not exist", helpers.ErrorRoomNotFound)
 	}
 	roomsMux.Unlock()
 
@@ -169,7 +276,10 @@ func GetRoom(roomName string) (*Room, error) {
 // parameter is the connection ID associated with one of the connections attached to that User. This must
 // be provided when adding a User to a Room with MultiConnect enabled. Otherwise, an empty string can be used.
 func (r *Room) AddUser(user *User, connID string) error {
-	userName := user.Name()
+	return r.addUser(user, connID, false)
+}
+
+func (r *Room) addUser(user *User, connID string, spectator bool) error {
 	// REJECT INCORRECT INPUT
 	if user == nil {
 		return errors.New("*Room.AddUser() requires a valid User")
@@ -178,47 +288,41 @@ func (r *Room) AddUser(user *User, connID string) error {
 	} else if !multiConnect {
 		connID = "1"
 	}
+	if serverPaused {
+		return helpers.NewError(errorServerPaused, helpers.ErrorServerPaused)
+	}
+	userName := user.Name()
 	r.mux.Lock()
 	if r.usersMap == nil {
 		r.mux.Unlock()
-		return errors.New("The room '" + r.name + "' does not exist")
-	} else if r.maxUsers != 0 && len(r.usersMap) == r.maxUsers {
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if spectator && !r.spectate {
+		r.mux.Unlock()
+		return ErrNoSpectators
+	} else if !spectator && r.playersFull() && r.usersMap[userName] == nil {
 		r.mux.Unlock()
-		return errors.New("The room '" + r.name + "' is full")
+		return ErrRoomFull
 	}
 	// CHECK IF THE ROOM IS PRIVATE, OWNER JOINS FREELY
-	if r.private && userName != r.owner {
-		// IF PRIVATE AND NOT OWNER, CHECK IF THIS USER IS ON THE INVITE LIST
-		if len(r.inviteList) > 0 {
-			for i := 0; i < len(r.inviteList); i++ {
-				if (r.inviteList)[i] == userName {
-					// INVITED User
-					break
-				}
-				if i == len(r.inviteList)-1 {
-					r.mux.Unlock()
-					return errors.New("User '" + userName + "' is not on the invite list")
-				}
-			}
-		} else {
-			r.mux.Unlock()
-			return errors.New("User '" + userName + "' is not on the invite list")
-		}
+	if r.private && userName != r.owner && !r.invited(userName) {
+		r.mux.Unlock()
+		return ErrNotInvited
 	}
 
 	// CHECK IF USER IS ALREADY IN THE ROOM
 	var ru *RoomUser
 	var ok bool
+	joined := false
 	if ru, ok = r.usersMap[userName]; ok {
 		if !multiConnect {
 			r.mux.Unlock()
-			return errors.New("User '" + userName + "' is already in room '" + r.name + "'")
+			return helpers.NewError("User '"+userName+"' is already in room '"+r.name+"'", helpers.ErrorAlreadyInRoom)
 		}
 		ru.mux.Lock()
 		if _, ok := ru.conns[connID]; ok {
 			r.mux.Unlock()
 			ru.mux.Unlock()
-			return errors.New("User '" + userName + "' is already in room '" + r.name + "'")
+			return helpers.NewError("User '"+userName+"' is already in room '"+r.name+"'", helpers.ErrorAlreadyInRoom)
 		}
 		ru.mux.Unlock()
 	}
@@ -226,23 +330,40 @@ func (r *Room) AddUser(user *User, connID string) error {
 	user.mux.Lock()
 	c := user.conns[connID]
 	if c == nil {
+		user.mux.Unlock()
 		r.mux.Unlock()
 		return errors.New("Invalid connection ID")
 	}
+	if c.room != nil && c.room != r {
+		// THE CONNECTION CAN ONLY BE IN ONE ROOM, SO IT HAS TO LEAVE THE OTHER ONE FIRST
+		user.mux.Unlock()
+		r.mux.Unlock()
+		return ErrInOtherRoom
+	}
 	if ru != nil {
+		// ANOTHER CONNECTION KEEPS THE ROLE THE User ALREADY HAS IN THE ROOM
 		(*r.usersMap[userName]).mux.Lock()
 		(*r.usersMap[userName]).conns[connID] = c
+		spectator = (*r.usersMap[userName]).spectator
 		(*r.usersMap[userName]).mux.Unlock()
 	} else {
 		conns := make(map[string]*userConn)
 		conns[connID] = c
-		newUser := RoomUser{user: user, conns: conns}
+		r.joinCount++
+		newUser := RoomUser{user: user, joinNum: r.joinCount, conns: conns, spectator: spectator}
 		r.usersMap[userName] = &newUser
 		ru = r.usersMap[userName]
+		if spectator {
+			r.spectators++
+		}
+		r.stopExpiry()
+		joined = true
 	}
 	// CHANGE USER'S ROOM
 	c.room = r
+	r.events.record(EventJoin, userName, map[string]interface{}{"c": connID, "s": spectator})
 
+	userList := r.roomUsers()
 	user.mux.Unlock()
 	r.mux.Unlock()
 
@@ -250,30 +371,55 @@ func (r *Room) AddUser(user *User, connID string) error {
 	roomType := roomTypes[r.rType]
 	if roomType.BroadcastUserEnter() {
 		//BROADCAST ENTER TO USERS IN ROOM
-		message := map[string]map[string]interface{}{
+		message := helpers.NewEncoded(map[string]map[string]interface{}{
 			helpers.ServerActionUserEnter: {
-				"u": userName,
-				"g": user.isGuest,
+				"u":  userName,
+				"g":  user.isGuest,
+				"s":  user.Status(),
+				"sp": spectator,
 			},
-		}
-		for _, u := range r.usersMap {
+		})
+		for _, u := range userList {
 			u.mux.Lock()
 			if u.user.Name() != userName {
 				for _, conn := range u.conns {
-					(*conn).socket.WriteJSON(message)
+					(*conn).send(message)
 				}
 			}
 			u.mux.Unlock()
 		}
 	}
-	// CALLBACK
+	// CALLBACKS
+	if joined && RoomJoinCallback != nil {
+		RoomJoinCallback(r.name, userName)
+	}
 	if roomType.HasUserEnterCallback() {
 		roomType.UserEnterCallback()(r, ru)
 	}
+	if joined {
+		notifyPresence(PresenceRoom, userName, user.Status(), r)
+	}
 
 	// SEND RESPONSE TO CLIENT
-	clientResp := helpers.MakeClientResponse(helpers.ClientActionJoinRoom, r.Name(), helpers.NoError())
-	c.socket.WriteJSON(clientResp)
+	responseAction := helpers.ClientActionJoinRoom
+	if spectator {
+		responseAction = helpers.ClientActionSpectateRoom
+	}
+	clientResp := helpers.MakeClientResponse(responseAction, r.Name(), helpers.NoError())
+	c.send(clientResp)
+
+	// SEND CHAT HISTORY TO CLIENT
+	if history := r.GetChatHistory(0); len(history) > 0 {
+		historyMessage := map[string]interface{}{
+			helpers.ServerActionChatHistory: MakeChatHistoryResponse(history),
+		}
+		c.send(historyMessage)
+	}
+
+	// SEND SYNCED STATE TO CLIENT
+	if r.SyncedStateSequence() > 0 {
+		r.SendSyncedState(user, connID)
+	}
 
 	//
 	return nil
@@ -286,26 +432,58 @@ func (r *Room) AddUser(user *User, connID string) error {
 // RemoveUser removes a User from the room. If you are using MultiConnect in ServerSettings, the connID
 // parameter is the connection ID associated with one of the connections attached to that User. This must
 // be provided when removing a User from a Room with MultiConnect enabled. Otherwise, an empty string can be used.
+//
+// The room leave callback gets LeaveReasonKick as the reason, and the client gets a ServerActionKicked message with the Room's
+// name. Use *User.Leave() to make a User leave a Room voluntarily.
 func (r *Room) RemoveUser(user *User, connID string) error {
+	return r.kickUser(user, connID, "")
+}
+
+// kickUser removes a User's connection from the Room, and tells its client why with a ServerActionKicked message. Unlike
+// *User.Kick(), the client stays logged in.
+func (r *Room) kickUser(user *User, connID string, reason string) error {
+	if !multiConnect {
+		connID = "1"
+	}
+	if err := r.removeUser(user, connID, LeaveReasonKick); err != nil {
+		return err
+	}
+	user.mux.Lock()
+	conn := user.conns[connID]
+	user.mux.Unlock()
+	if conn != nil {
+		conn.send(map[string]map[string]interface{}{
+			helpers.ServerActionKicked: {
+				"m": reason,
+				"r": r.name,
+			},
+		})
+	}
+	return nil
+}
+
+func (r *Room) removeUser(user *User, connID string, reason int) error {
 	//REJECT INCORRECT INPUT
-	if user == nil || len(user.name) == 0 {
+	if user == nil || len(user.Name()) == 0 {
 		return errors.New("*Room.RemoveUser() requires a valid *User")
 	} else if multiConnect && len(connID) == 0 {
 		return errors.New("Must provide a connID when MultiConnect is enabled")
 	} else if !multiConnect {
 		connID = "1"
 	}
+	// SEND THE UPDATES WAITING TO GO OUT, SO THE USER'S LAST ONES REACH THE ROOM
+	r.flushUpdates()
 	//
 	r.mux.Lock()
 	if r.usersMap == nil {
 		r.mux.Unlock()
-		return errors.New("The room '" + r.name + "' does not exist")
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
 	}
 	var ok bool
 	var ru *RoomUser
-	if ru, ok = r.usersMap[user.name]; !ok {
+	if ru, ok = r.usersMap[user.Name()]; !ok {
 		r.mux.Unlock()
-		return errors.New("User '" + user.name + "' is not in room '" + r.name + "'")
+		return helpers.NewError("User '"+user.Name()+"' is not in room '"+r.name+"'", helpers.ErrorNotInRoom)
 	}
 	ru.mux.Lock()
 	var uConn *userConn
@@ -316,91 +494,244 @@ func (r *Room) RemoveUser(user *User, connID string) error {
 	}
 	delete(ru.conns, connID)
 	// Remove user when no conns are left in room
-	if len(ru.conns) == 0 {
-		delete(r.usersMap, user.name)
+	left := len(ru.conns) == 0
+	if left {
+		delete(r.usersMap, user.Name())
+		delete(r.limiters, user.Name())
+		if ru.spectator {
+			r.spectators--
+		}
 	}
 	ru.mux.Unlock()
-	userList := r.usersMap
-	r.mux.Unlock()
-	//
+	r.events.record(EventLeave, user.Name(), map[string]interface{}{"c": connID, "r": reason})
+	// CHANGE USER'S ROOM WHILE THE ROOM IS LOCKED, UNLESS A JOIN ALREADY MOVED THE CONNECTION
+	user.mux.Lock()
+	if uConn.room == r {
+		uConn.room = nil
+	}
+	user.mux.Unlock()
+	// TAKE THEM OUT OF THE TURN ORDER
+	var turnMessage *helpers.Encoded
+	if left {
+		turnMessage = r.removeFromTurns(user.Name())
+	}
 	roomType := roomTypes[r.rType]
+	// PICK THE NEXT OWNER IF THE OWNER LEFT AND THE RoomType TRANSFERS OWNERSHIP
+	deleteRoom := deleteRoomOnLeave && user.Name() == r.owner
+	var newOwner string
+	if deleteRoom && roomType.OwnerTransfer() {
+		if left {
+			newOwner = r.longestPresent()
+			if newOwner != "" {
+				r.owner = newOwner
+				deleteRoom = false
+			}
+		} else {
+			// THE OWNER IS STILL IN THE ROOM ON ANOTHER CONNECTION
+			deleteRoom = false
+		}
+	}
+	if left && !deleteRoom {
+		r.startExpiry()
+	}
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	//ROOM LEAVE CALLBACK, BEFORE THE ROOM CAN GET DELETED
+	if left && RoomLeaveCallback != nil {
+		RoomLeaveCallback(r.name, user.Name(), reason)
+	}
 
 	//DELETE THE ROOM IF THE OWNER LEFT AND UserRoomControl IS ENABLED
-	if deleteRoomOnLeave && user.name == r.owner {
+	if deleteRoom {
 		deleteErr := r.Delete()
 		if deleteErr != nil {
 			return deleteErr
 		}
 	} else if roomType.BroadcastUserLeave() {
 		//CONSTRUCT LEAVE MESSAGE
-		message := map[string]map[string]interface{}{
+		message := helpers.NewEncoded(map[string]map[string]interface{}{
 			helpers.ServerActionUserLeave: {
-				"u": user.name,
+				"u": user.Name(),
 			},
-		}
+		})
 
 		//SEND MESSAGE TO USERS
 		for _, u := range userList {
 			u.mux.Lock()
 			for _, conn := range u.conns {
-				conn.socket.WriteJSON(message)
+				conn.send(message)
 			}
 			u.mux.Unlock()
 		}
 	}
 
-	// CHANGE USER'S ROOM
-	user.mux.Lock()
-	uConn.room = nil
-	user.mux.Unlock()
+	// TELL EVERYONE ABOUT THE NEW OWNER AND TURN
+	if newOwner != "" {
+		broadcastOwner(userList, newOwner)
+	}
+	if !deleteRoom {
+		sendToUsers(userList, turnMessage)
+	}
 
 	//CALLBACK
 	if roomType.HasUserLeaveCallback() {
 		roomType.UserLeaveCallback()(r, ru)
 	}
+	if left {
+		notifyPresence(PresenceRoom, user.Name(), user.Status(), nil)
+	}
 
 	//SEND RESPONSE TO CLIENT
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionLeaveRoom, r.Name(), helpers.NoError())
-	uConn.socket.WriteJSON(clientResp)
+	uConn.send(clientResp)
+
+	//
+	return nil
+}
+
+// roomUsers copies the RoomUsers into a list that can be sent messages after the Room's mux is unlocked, while Users join
+// and leave. Must lock the Room's mux to use.
+func (r *Room) roomUsers() []*RoomUser {
+	list := make([]*RoomUser, 0, len(r.usersMap))
+	for _, u := range r.usersMap {
+		list = append(list, u)
+	}
+	return list
+}
+
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CHANGE THE OWNER   ///////////////////////////////////////////////////////////////////////////////////////////
+///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// TransferOwner makes another User in the Room the owner of the Room, and sends an owner change message to all the Users
+// in the Room that you can capture with the client APIs.
+func (r *Room) TransferOwner(newOwner string) error {
+	if len(newOwner) == 0 {
+		return errors.New("*Room.TransferOwner() requires a new owner")
+	}
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if _, ok := r.usersMap[newOwner]; !ok {
+		r.mux.Unlock()
+		return helpers.NewError("User '"+newOwner+"' is not in room '"+r.name+"'", helpers.ErrorNotInRoom)
+	} else if r.owner == newOwner {
+		r.mux.Unlock()
+		return nil
+	}
+	r.owner = newOwner
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	broadcastOwner(userList, newOwner)
+
+	//
+	return nil
+}
+
+// SetServerOwned makes the server the owner of the Room, using the ServerName from ServerSettings. A Room owned by the
+// server will not be deleted by RoomDeleteOnLeave, and clients can't delete it or change its invites.
+func (r *Room) SetServerOwned() error {
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if r.owner == serverName {
+		r.mux.Unlock()
+		return nil
+	}
+	r.owner = serverName
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	broadcastOwner(userList, serverName)
 
 	//
 	return nil
 }
 
+// longestPresent gets the name of the User who has been in the Room the longest. Players come before spectators. Must lock
+// the Room's mux to use.
+func (r *Room) longestPresent() string {
+	var name string
+	var first int
+	var spectator bool
+	for userName, u := range r.usersMap {
+		u.mux.Lock()
+		isSpectator := u.spectator
+		u.mux.Unlock()
+		if name == "" || (spectator && !isSpectator) || (spectator == isSpectator && u.joinNum < first) {
+			name = userName
+			first = u.joinNum
+			spectator = isSpectator
+		}
+	}
+	return name
+}
+
+func broadcastOwner(userList []*RoomUser, owner string) {
+	message := helpers.NewEncoded(map[string]interface{}{
+		helpers.ServerActionOwnerChange: owner,
+	})
+	for _, u := range userList {
+		u.mux.Lock()
+		for _, conn := range u.conns {
+			conn.send(message)
+		}
+		u.mux.Unlock()
+	}
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 //   ADD TO inviteList   //////////////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // AddInvite adds a User to a private Room's invite list. This is only meant for internal Gopher Game Server mechanics.
-// If you want a User to invite someone to a private room, use the *User.Invite() function instead.
+// If you want a User to invite someone to a private room, use the *User.Invite() function instead. Adding a User that
+// is already on the invite list does nothing.
 //
 // NOTE: Remember that private rooms are designed to have an "owner",
 // and only the owner should be able to send an invite and revoke an invitation for their Rooms. Also, *User.Invite()
 // will send an invite notification message to the invited User that the client API can easily receive. Though if you wish to make
 // your own implementations for sending and receiving these notifications, this function is safe to use.
 func (r *Room) AddInvite(userName string) error {
+	_, err := r.addInvite(userName)
+	return err
+}
+
+// addInvite returns true if the User was added to the invite list, and false if they were already on it.
+func (r *Room) addInvite(userName string) (bool, error) {
 	if !r.private {
-		return errors.New("Room is not private")
+		return false, errors.New("Room is not private")
 	} else if len(userName) == 0 {
-		return errors.New("*Room.AddInvite() requires a userName")
+		return false, errors.New("*Room.AddInvite() requires a userName")
 	}
 
 	r.mux.Lock()
 	if r.usersMap == nil {
 		r.mux.Unlock()
-		return errors.New("The room '" + r.name + "' does not exist")
-	}
-	for i := 0; i < len(r.inviteList); i++ {
-		if r.inviteList[i] == userName {
-			r.mux.Unlock()
-			return errors.New("User '" + userName + "' is already on the invite list")
-		}
+		return false, helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if r.invited(userName) {
+		r.mux.Unlock()
+		return false, nil
 	}
 	r.inviteList = append(r.inviteList, userName)
 	r.mux.Unlock()
 
 	//
-	return nil
+	return true, nil
+}
+
+// invited returns true if the User is on the invite list. Must lock the Room's mux to use.
+func (r *Room) invited(userName string) bool {
+	for i := 0; i < len(r.inviteList); i++ {
+		if r.inviteList[i] == userName {
+			return true
+		}
+	}
+	return false
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -408,12 +739,13 @@ func (r *Room) AddInvite(userName string) error {
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // RemoveInvite removes a User from a private Room's invite list. To make a User remove someone from their room themselves,
-// use the *User.RevokeInvite() function.
+// use the *User.RevokeInvite() function. When kick is true and the User is in the Room, they will also be removed from the
+// Room.
 //
 // NOTE: You can use this function safely, but remember that private rooms are designed to have an "owner",
 // and only the owner should be able to send an invite and revoke an invitation for their Rooms. But if you find the
 // need to break the rules here, by all means do so!
-func (r *Room) RemoveInvite(userName string) error {
+func (r *Room) RemoveInvite(userName string, kick bool) error {
 	if !r.private {
 		return errors.New("Room is not private")
 	} else if len(userName) == 0 {
@@ -424,7 +756,7 @@ func (r *Room) RemoveInvite(userName string) error {
 
 	if r.usersMap == nil {
 		r.mux.Unlock()
-		return errors.New("The room '" + r.name + "' does not exist")
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
 	}
 	for i := 0; i < len(r.inviteList); i++ {
 		if r.inviteList[i] == userName {
@@ -434,11 +766,21 @@ func (r *Room) RemoveInvite(userName string) error {
 		}
 		if i == len(r.inviteList)-1 {
 			r.mux.Unlock()
-			return errors.New("User '" + userName + "' is not on the invite list")
+			return helpers.NewError("User '"+userName+"' is not on the invite list", helpers.ErrorNotInvited)
 		}
 	}
+	var kickConns []string
+	ru := r.usersMap[userName]
+	if kick && ru != nil && userName != r.owner {
+		kickConns = ru.ConnectionIDs()
+	}
 	r.mux.Unlock()
 
+	// KICK THE USER OUT OF THE ROOM
+	for _, connID := range kickConns {
+		r.kickUser(ru.user, connID, errorInviteRevoked)
+	}
+
 	//
 	return nil
 }
@@ -464,7 +806,10 @@ func (r *Room) IsPrivate() bool {
 
 // Owner gets the name of the owner of the room
 func (r *Room) Owner() string {
-	return r.owner
+	r.mux.Lock()
+	owner := r.owner
+	r.mux.Unlock()
+	return owner
 }
 
 // MaxUsers gets the maximum User capacity of the Room.
@@ -472,7 +817,20 @@ func (r *Room) MaxUsers() int {
 	return r.maxUsers
 }
 
-// NumUsers gets the number of Users in the Room.
+// IsFull returns true if the Room has reached its maximum User capacity. Spectators don't count towards it.
+func (r *Room) IsFull() bool {
+	r.mux.Lock()
+	full := r.playersFull()
+	r.mux.Unlock()
+	return full
+}
+
+// playersFull returns true if the Room has as many players as its maximum User capacity. Must lock the Room's mux to use.
+func (r *Room) playersFull() bool {
+	return r.maxUsers != 0 && len(r.usersMap)-r.spectators >= r.maxUsers
+}
+
+// NumUsers gets the number of Users in the Room, including spectators.
 func (r *Room) NumUsers() int {
 	m, e := r.GetUserMap()
 	if e != nil {
@@ -486,9 +844,10 @@ func (r *Room) InviteList() ([]string, error) {
 	r.mux.Lock()
 	if r.usersMap == nil {
 		r.mux.Unlock()
-		return []string{}, errors.New("The room '" + r.name + "' does not exist")
+		return []string{}, helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
 	}
-	list := r.inviteList
+	list := make([]string, len(r.inviteList))
+	copy(list, r.inviteList)
 	r.mux.Unlock()
 	//
 	return list, nil
@@ -501,7 +860,7 @@ func (r *Room) GetUserMap() (map[string]*RoomUser, error) {
 
 	r.mux.Lock()
 	if r.usersMap == nil {
-		err = errors.New("The room '" + r.name + "' does not exist")
+		err = helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
 	} else {
 		userMap = r.usersMap
 	}
@@ -510,12 +869,62 @@ func (r *Room) GetUserMap() (map[string]*RoomUser, error) {
 	return userMap, err
 }
 
-// RoomCount returns the number of Rooms created on the server.
-func RoomCount() int {
+// GetUsers gets the RoomUsers in the Room, in the order they joined it. The list is taken all at once, so a User joining or
+// leaving at the same time is either in it or not, never half-way in.
+func (r *Room) GetUsers() ([]*RoomUser, error) {
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return []*RoomUser{}, helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	list := make([]*RoomUser, 0, len(r.usersMap))
+	for _, u := range r.usersMap {
+		list = append(list, u)
+	}
+	r.mux.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].joinNum < list[j].joinNum
+	})
+	//
+	return list, nil
+}
+
+// HasUser returns true if a User with the name userName is in the Room.
+func (r *Room) HasUser(userName string) bool {
+	r.mux.Lock()
+	_, ok := r.usersMap[userName]
+	r.mux.Unlock()
+	return ok
+}
+
+// GetListedRooms gets all the public Rooms with a RoomType that has been listed with *RoomType.EnableListed(). Use
+// core.FindRooms() to filter the Rooms, and list their details.
+func GetListedRooms() []*Room {
 	roomsMux.Lock()
-	length := len(rooms)
+	listedRooms := []*Room{}
+	for _, room := range rooms {
+		if !room.private && roomTypes[room.rType].Listed() {
+			listedRooms = append(listedRooms, room)
+		}
+	}
 	roomsMux.Unlock()
-	return length
+	return listedRooms
+}
+
+// GetRooms gets all the Rooms on the server, including the private ones.
+func GetRooms() []*Room {
+	roomsMux.Lock()
+	allRooms := make([]*Room, 0, len(rooms))
+	for _, room := range rooms {
+		allRooms = append(allRooms, room)
+	}
+	roomsMux.Unlock()
+	return allRooms
+}
+
+// RoomCount returns the number of Rooms created on the server.
+func RoomCount() int {
+	return int(atomic.LoadInt64(&roomCount))
 }
 
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -527,6 +936,21 @@ func (u *RoomUser) User() *User {
 	return u.user
 }
 
+// Name gets the name of the RoomUser's User.
+func (u *RoomUser) Name() string {
+	return u.user.Name()
+}
+
+// IsGuest returns true if the RoomUser's User is a guest.
+func (u *RoomUser) IsGuest() bool {
+	return u.user.isGuest
+}
+
+// Status gets the status of the RoomUser's User, like StatusAvailable.
+func (u *RoomUser) Status() int {
+	return u.user.Status()
+}
+
 // ConnectionIDs returns a []string of all the RoomUser's connection IDs. With MultiConnect in ServerSettings enabled,
 // this will give you all the connections for this User that are currently in the Room. Otherwise, if you want
 // all the User's connection IDs (not just the connections in the specified Room), use *User.ConnectionIDs() after getting
@@ -0,0 +1,400 @@
+package core
+
+import (
+	"context"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+var roomEvents []string
+
+var testEventsRoomType = NewRoomType("testEvents", false).SetDeleteCallback(func(r *Room) {
+	roomEvents = append(roomEvents, "delete "+r.Name())
+})
+
+var testSpectateRoomType = NewRoomType("testSpectate", false).EnableSpectators()
+
+func TestRoomJoinLeaveCallbacks(t *testing.T) {
+	// CLEANUPS RUN AFTER THE DEFERRED DELETES, SO THEIR EVENTS DON'T CARRY OVER TO THE NEXT RUN
+	t.Cleanup(func() {
+		roomEvents = nil
+		RoomJoinCallback = nil
+		RoomLeaveCallback = nil
+		SettingsSet(false, "server", false, false, false, false, 0, 0)
+	})
+	roomEvents = nil
+	RoomJoinCallback = func(room string, user string) {
+		roomEvents = append(roomEvents, "join "+room+" "+user)
+	}
+	RoomLeaveCallback = func(room string, user string, reason int) {
+		roomEvents = append(roomEvents, "leave "+room+" "+user+" "+strconv.Itoa(reason))
+	}
+	SettingsSet(false, "server", true, false, false, false, 0, 0)
+
+	owner, _ := testLogin(t, "eventsOwner")
+	defer owner.Kick()
+	guest, _ := testLogin(t, "eventsGuest")
+	defer func() { guest.Kick() }()
+	room, roomErr := NewRoom("events", "testEvents", false, 0, "eventsOwner")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	other, roomErr := NewRoom("eventsOther", "testEvents", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer other.Delete()
+
+	guest.Join(room, "")
+	guest.Join(other, "") // Leaves events voluntarily
+	guest.Kick()
+	owner.Join(room, "")
+	guest, _ = testLogin(t, "eventsGuest")
+	guest.Join(room, "")
+	owner.Logout("") // RoomDeleteOnLeave deletes the Room
+
+	expected := []string{
+		"join events eventsGuest",
+		"leave events eventsGuest " + strconv.Itoa(LeaveReasonVoluntary),
+		"join eventsOther eventsGuest",
+		"leave eventsOther eventsGuest " + strconv.Itoa(LeaveReasonKick),
+		"join events eventsOwner",
+		"join events eventsGuest",
+		"leave events eventsOwner " + strconv.Itoa(LeaveReasonLogout),
+		"leave events eventsGuest " + strconv.Itoa(LeaveReasonDeleted),
+		"delete events",
+	}
+	if len(roomEvents) != len(expected) {
+		t.Fatal("Expected events", expected, "got", roomEvents)
+	}
+	for i := range expected {
+		if roomEvents[i] != expected[i] {
+			t.Error("Expected event '"+expected[i]+"', got", "'"+roomEvents[i]+"'")
+		}
+	}
+}
+
+func TestRoomTypeOptions(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	NewRoomType("testOptions", false).SetMaxUsers(1).EnableListed().SetChatHistoryLen(5).
+		SetChatMessageHandler(func(r *Room, author string, message interface{}) (interface{}, bool) {
+			if message == "blocked" {
+				return nil, false
+			}
+			return "[" + r.Type() + "] " + message.(string), true
+		})
+	room, roomErr := NewRoom("options", "testOptions", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	private, roomErr := NewRoom("optionsPrivate", "testOptions", true, 5, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer private.Delete()
+
+	if room.MaxUsers() != 1 || private.MaxUsers() != 5 {
+		t.Error("Rooms made with a maxUsers of 0 should get the RoomType's MaxUsers")
+	}
+	if listed := GetListedRooms(); len(listed) != 1 || listed[0] != room {
+		t.Error("GetListedRooms() should only return the public Room, got", listed)
+	}
+
+	room.ChatMessage("optionsAuthor", "blocked")
+	room.ChatMessage("optionsAuthor", "hello")
+	history := room.GetChatHistory(0)
+	if len(history) != 1 || history[0].Message != "[testOptions] hello" {
+		t.Error("The chat message handler should change or block messages before they're sent, got", history)
+	}
+}
+
+func TestRoomFull(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("full", "test", false, 1, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	first, _ := testLogin(t, "fullFirst")
+	defer first.Kick()
+	second, _ := testLogin(t, "fullSecond")
+	defer second.Kick()
+
+	// Both Users race for the last spot
+	joinErrs := make(chan error, 2)
+	for _, u := range []*User{first, second} {
+		go func(u *User) {
+			joinErrs <- u.Join(room, "")
+		}(u)
+	}
+	var joined, full int
+	for i := 0; i < 2; i++ {
+		if err := <-joinErrs; err == nil {
+			joined++
+		} else if err == ErrRoomFull {
+			full++
+		} else {
+			t.Error(err)
+		}
+	}
+	if joined != 1 || full != 1 {
+		t.Error("Expected 1 User to join and 1 to get ErrRoomFull, got", joined, "and", full)
+	}
+	if room.NumUsers() != 1 || !room.IsFull() {
+		t.Error("The Room should be full with 1 User")
+	}
+}
+
+func TestContextTimeouts(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("timeouts", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	user, _ := testLogin(t, "timeoutsUser")
+	defer user.Kick()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := GetRoomCtx(ctx, "timeouts"); err != ErrTimeout {
+		t.Error("Expected ErrTimeout from GetRoomCtx, got", err)
+	}
+	if err := user.JoinCtx(ctx, room, ""); err != ErrTimeout {
+		t.Error("Expected ErrTimeout from JoinCtx, got", err)
+	} else if room.NumUsers() != 0 {
+		t.Error("The User shouldn't have joined the Room")
+	}
+	var timedOut *User
+	var clientMux sync.Mutex
+	if _, err := LoginCtx(ctx, "timeoutsLogin", -1, "", true, false, testSocket(t), &timedOut, &clientMux); err.ID != helpers.ErrorTimeout {
+		t.Error("Expected an ErrorTimeout from LoginCtx, got", err.ID)
+	} else if _, userErr := GetUser("timeoutsLogin"); userErr == nil {
+		t.Error("The User shouldn't have logged in")
+	}
+
+	// The old functions don't time out
+	if err := user.Join(room, ""); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInvites(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("invites", "test", true, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	guest, _ := testLogin(t, "invitesGuest")
+	defer guest.Kick()
+
+	if err := guest.Join(room, ""); err != ErrNotInvited {
+		t.Error("Expected ErrNotInvited, got", err)
+	}
+	room.AddInvite("invitesGuest")
+	if err := room.AddInvite("invitesGuest"); err != nil {
+		t.Error("Inviting a User twice should do nothing, got", err)
+	}
+	if list, _ := room.InviteList(); len(list) != 1 {
+		t.Error("Expected 1 invite, got", list)
+	}
+	if err := guest.Join(room, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revoking without kicking leaves them in the Room
+	room.RemoveInvite("invitesGuest", false)
+	if guest.RoomIn("") != room {
+		t.Error("Revoking an invite without kick should not remove the User from the Room")
+	}
+	room.AddInvite("invitesGuest")
+	room.RemoveInvite("invitesGuest", true)
+	if guest.RoomIn("") != nil || room.NumUsers() != 0 {
+		t.Error("Revoking an invite with kick should remove the User from the Room")
+	}
+}
+
+func TestTransferOwner(t *testing.T) {
+	defer SettingsSet(false, "server", false, false, false, false, 0, 0)
+	SettingsSet(false, "server", true, false, false, false, 0, 0)
+	NewRoomType("testTransfer", false).EnableOwnerTransfer()
+	owner, _ := testLogin(t, "transferOwner")
+	defer owner.Kick()
+	first, _ := testLogin(t, "transferFirst")
+	defer first.Kick()
+	second, _ := testLogin(t, "transferSecond")
+	defer second.Kick()
+	room, roomErr := NewRoom("transfer", "testTransfer", false, 0, "transferOwner")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	owner.Join(room, "")
+	first.Join(room, "")
+	second.Join(room, "")
+
+	if err := room.TransferOwner("transferNobody"); err == nil {
+		t.Error("Ownership can only be transferred to a User in the Room")
+	}
+
+	// The longest present User gets the Room when the owner leaves
+	owner.Leave("")
+	if room.Owner() != "transferFirst" {
+		t.Error("Expected 'transferFirst' to own the Room, got", room.Owner())
+	}
+	if err := room.TransferOwner("transferSecond"); err != nil || room.Owner() != "transferSecond" {
+		t.Error("Ownership should be transferred to 'transferSecond'", err)
+	}
+	room.SetServerOwned()
+	second.Leave("")
+	first.Leave("")
+	if _, err := GetRoom("transfer"); err != nil {
+		t.Error("A server owned Room should not be deleted when its Users leave")
+	}
+}
+
+func TestRoomUsers(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("rosterRoom", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	first, _ := testLogin(t, "rosterFirst")
+	defer first.Kick()
+	var connUser *User
+	var clientMux sync.Mutex
+	if _, err := Login("rosterSecond", 3, "", false, false, testSocket(t), &connUser, &clientMux); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	second := connUser
+	defer second.Kick()
+	first.Join(room, "")
+	second.Join(room, "")
+	second.SetStatus(StatusInGame)
+
+	// RoomUsers come in the order they joined
+	users, err := room.GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(users) != 2 || users[0].Name() != "rosterFirst" || users[1].Name() != "rosterSecond" {
+		t.Fatal("Expected rosterFirst and rosterSecond, got", len(users), "RoomUsers")
+	} else if !users[0].IsGuest() || users[1].IsGuest() || users[1].Status() != StatusInGame {
+		t.Error("Expected the RoomUsers to have their User's guest flag and status")
+	}
+	if !room.HasUser("rosterSecond") || room.HasUser("rosterNobody") {
+		t.Error("HasUser() should only find the Users in the Room")
+	}
+
+	// A list taken while Users join and leave never has anyone twice
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		user, _ := testLogin(t, "rosterBusy"+strconv.Itoa(i))
+		defer user.Kick()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				user.Join(room, "")
+				user.Leave("")
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		users, _ := room.GetUsers()
+		seen := map[string]bool{}
+		for _, u := range users {
+			if seen[u.Name()] {
+				t.Fatal("RoomUser", u.Name(), "is in the list twice")
+			}
+			seen[u.Name()] = true
+		}
+	}
+	wg.Wait()
+	room.RemoveUser(second, "")
+	if room.HasUser("rosterSecond") {
+		t.Error("A removed User should no longer be in the Room")
+	}
+}
+
+func TestSpectators(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("spectated", "testSpectate", false, 1, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	player, _ := testLogin(t, "spectatePlayer")
+	defer player.Kick()
+	watcher, _ := testLogin(t, "spectateWatcher")
+	defer watcher.Kick()
+	late, _ := testLogin(t, "spectateLate")
+	defer late.Kick()
+
+	// Spectators don't count towards MaxUsers
+	if err := player.Join(room, ""); err != nil {
+		t.Fatal(err)
+	} else if err := watcher.Spectate(room, ""); err != nil {
+		t.Fatal("A spectator should get into a full Room, got", err)
+	} else if err := late.Join(room, ""); err != ErrRoomFull {
+		t.Error("Expected ErrRoomFull for another player, got", err)
+	} else if err := late.Spectate(room, ""); err != nil {
+		t.Fatal(err)
+	}
+	if room.NumPlayers() != 1 || room.NumSpectators() != 2 || !room.IsFull() {
+		t.Error("Expected 1 player and 2 spectators, got", room.NumPlayers(), "and", room.NumSpectators())
+	}
+	if !watcher.IsSpectating("") || player.IsSpectating("") {
+		t.Error("Only the spectators should be spectating")
+	}
+	spectators, _ := room.GetSpectators()
+	players, _ := room.GetPlayers()
+	if len(spectators) != 2 || spectators[0].Name() != "spectateWatcher" || len(players) != 1 || players[0].Name() != "spectatePlayer" {
+		t.Error("Expected the players and spectators in separate lists")
+	}
+	summaries := FindRooms(RoomFilter{Type: "testSpectate"})
+	if len(summaries) != 1 || summaries[0].Users != 1 || summaries[0].Spectators != 2 {
+		t.Error("Expected the listing to count 1 player and 2 spectators, got", summaries)
+	}
+
+	// A spectator can only be promoted when a player's spot opens
+	if err := room.PromoteSpectator("spectateWatcher"); err != ErrRoomFull {
+		t.Error("Expected ErrRoomFull promoting into a full Room, got", err)
+	}
+	player.Leave("")
+	if err := room.PromoteSpectator("spectateWatcher"); err != nil {
+		t.Fatal(err)
+	} else if watcher.IsSpectating("") || room.NumPlayers() != 1 || room.NumSpectators() != 1 {
+		t.Error("The promoted spectator should be a player")
+	} else if err := room.PromoteSpectator("spectateWatcher"); err == nil {
+		t.Error("Promoting a player should fail")
+	}
+
+	// Kicking and deleting clean up spectators like players
+	room.RemoveUser(late, "")
+	if room.NumSpectators() != 0 || late.RoomIn("") != nil {
+		t.Error("A kicked spectator should be out of the Room")
+	}
+	late.Spectate(room, "")
+	room.Delete()
+	if late.RoomIn("") != nil || watcher.RoomIn("") != nil {
+		t.Error("Deleting the Room should remove its spectators and players")
+	}
+
+	// Rooms only take spectators when their RoomType or the Room allows them
+	plain, roomErr := NewRoom("notSpectated", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer plain.Delete()
+	if err := late.Spectate(plain, ""); err != ErrNoSpectators {
+		t.Error("Expected ErrNoSpectators, got", err)
+	}
+	plain.SetSpectatorsAllowed(true)
+	if err := late.Spectate(plain, ""); err != nil {
+		t.Error(err)
+	}
+}
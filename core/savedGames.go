@@ -0,0 +1,292 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/database"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// THE VERSION OF THE SAVED GAME FORMAT. GAMES SAVED WITH A NEWER VERSION CAN'T BE LOADED.
+	savedGameVersion = 1
+
+	// THE DEFAULT MOST BYTES A SAVED GAME CAN TAKE
+	defaultMaxSavedGameSize = 64 * 1024
+
+	savedGamesFileName = "Gopher Saved Games.json"
+)
+
+// savedGameState is what's saved of a Room.
+type savedGameState struct {
+	V int                    // version
+	L string                 // slot
+	S int64                  // when the game was saved, in unix seconds
+	N string                 // room name
+	T string                 // room type
+	P bool                   // private
+	M int                    // max users
+	O string                 // owner
+	I []string               // invite list
+	A map[string]interface{} // variables
+	U []string               // the Users in the Room
+}
+
+var (
+	// ErrSavedGameTooBig is returned when a Room's variables are too big to save. See MaxSavedGameSize in ServerSettings.
+	ErrSavedGameTooBig = errors.New("The game is too big to save")
+	// ErrSavedGameVersion is returned when a game was saved by a newer version of the server than the one loading it.
+	ErrSavedGameVersion = errors.New("The game was saved by a newer version of the server")
+
+	errSavedGamesOff = errors.New("Saving games requires the SQL features or a RecoveryLocation")
+
+	maxSavedGameSize = defaultMaxSavedGameSize
+
+	//savedGamesMux LOCKS READING AND WRITING THE SAVED GAMES FILE
+	savedGamesMux  sync.Mutex
+	savedGamesFile string // WHERE SAVED GAMES GO WITHOUT THE SQL FEATURES
+)
+
+// SetSavedGames is only for internal Gopher Game Server mechanics.
+func SetSavedGames(folder string, maxSize int) {
+	if !serverStarted {
+		savedGamesFile = ""
+		if folder != "" {
+			savedGamesFile = filepath.Join(folder, savedGamesFileName)
+		}
+		maxSavedGameSize = maxSize
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SAVING GAMES   //////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SaveGame saves the Room's settings, variables, invite list and the Users in it, so the game can be made again with LoadGame()
+// after the Room is gone, even after the server restarts. Saving a Room to a slot it was already saved to replaces that game,
+// and keeps its ID. With the SQL features, games are saved on the database, and the accounts in the Room can find them with
+// *User.SavedGames(). Without them, they are saved in the RecoveryLocation in ServerSettings. The Room's variables must fit in
+// JSON, and the saved game can be at most MaxSavedGameSize in ServerSettings bytes.
+func (r *Room) SaveGame(slot string) (int64, error) {
+	return r.SaveGameCtx(context.Background(), slot)
+}
+
+// SaveGameCtx is the same as SaveGame, but gives up on the database when ctx is done.
+func (r *Room) SaveGameCtx(ctx context.Context, slot string) (int64, error) {
+	if !sqlFeatures && savedGamesFile == "" {
+		return 0, errSavedGamesOff
+	}
+	state := savedGameState{V: savedGameVersion, L: slot, S: time.Now().Unix(), N: r.name, T: r.rType, P: r.private, M: r.maxUsers}
+	var players []int
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return 0, helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	state.O = r.owner
+	state.I = append([]string{}, r.inviteList...)
+	state.A = make(map[string]interface{}, len(r.vars))
+	for key, val := range r.vars {
+		state.A[key] = val
+	}
+	for userName, ru := range r.usersMap {
+		state.U = append(state.U, userName)
+		if !ru.user.isGuest && ru.user.databaseID != -1 {
+			players = append(players, ru.user.databaseID)
+		}
+	}
+	r.mux.Unlock()
+	sort.Strings(state.U)
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return 0, err
+	} else if maxSavedGameSize > 0 && len(data) > maxSavedGameSize {
+		return 0, ErrSavedGameTooBig
+	}
+
+	if sqlFeatures {
+		game := database.SavedGame{Slot: slot, Room: r.name, RoomType: r.rType, Version: savedGameVersion, Saved: state.S}
+		return database.SaveGame(ctx, game, players, string(data))
+	}
+	savedGamesMux.Lock()
+	defer savedGamesMux.Unlock()
+	games, err := readSavedGames()
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	for gameID, game := range games {
+		if game.N == r.name && game.L == slot {
+			id = gameID
+			break
+		}
+	}
+	for id == 0 {
+		id = time.Now().UnixNano()
+		if _, taken := games[id]; taken {
+			id = 0
+		}
+	}
+	games[id] = state
+	return id, writeSavedGames(games)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   LOADING GAMES   /////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// LoadGame makes a Room again from a game saved with *Room.SaveGame(), with the same type, settings, owner and variables. The
+// Room gets the name roomName, or the name it was saved with if roomName is "". The Users that were in the Room aren't put back
+// in it, but they are invited to it when it's private. The saved game is kept, so it can be loaded again.
+func LoadGame(gameID int64, roomName string) (*Room, error) {
+	return LoadGameCtx(context.Background(), gameID, roomName)
+}
+
+// LoadGameCtx is the same as LoadGame, but gives up on the database when ctx is done.
+func LoadGameCtx(ctx context.Context, gameID int64, roomName string) (*Room, error) {
+	state, err := loadSavedGame(ctx, gameID)
+	if err != nil {
+		return nil, err
+	} else if state.V > savedGameVersion {
+		return nil, ErrSavedGameVersion
+	}
+	if roomName == "" {
+		roomName = state.N
+	}
+	room, err := NewRoom(roomName, state.T, state.P, state.M, state.O)
+	if err != nil {
+		return nil, err
+	}
+	if len(state.A) > 0 {
+		room.SetVariables(state.A)
+	}
+	if state.P {
+		for _, userName := range append(state.I, state.U...) {
+			room.AddInvite(userName)
+		}
+	}
+	return room, nil
+}
+
+// DeleteSavedGame deletes a game saved with *Room.SaveGame(). Deleting a game that doesn't exist does nothing.
+func DeleteSavedGame(gameID int64) error {
+	if sqlFeatures {
+		return database.DeleteSavedGame(gameID)
+	} else if savedGamesFile == "" {
+		return errSavedGamesOff
+	}
+	savedGamesMux.Lock()
+	defer savedGamesMux.Unlock()
+	games, err := readSavedGames()
+	if err != nil {
+		return err
+	} else if _, ok := games[gameID]; !ok {
+		return nil
+	}
+	delete(games, gameID)
+	return writeSavedGames(games)
+}
+
+// SavedGames gets the saved games the User was in when they were saved, newest first, so they can pick one to resume. With the
+// SQL features, guests don't have any.
+func (u *User) SavedGames() ([]database.SavedGame, error) {
+	if sqlFeatures {
+		if u.isGuest {
+			return []database.SavedGame{}, nil
+		}
+		return database.ListSavedGames(u.databaseID)
+	} else if savedGamesFile == "" {
+		return nil, errSavedGamesOff
+	}
+	savedGamesMux.Lock()
+	games, err := readSavedGames()
+	savedGamesMux.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	userName := u.Name()
+	list := []database.SavedGame{}
+	for id, game := range games {
+		for _, player := range game.U {
+			if player == userName {
+				list = append(list, database.SavedGame{ID: id, Slot: game.L, Room: game.N, RoomType: game.T, Version: game.V, Saved: game.S})
+				break
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Saved > list[j].Saved })
+	return list, nil
+}
+
+func loadSavedGame(ctx context.Context, gameID int64) (savedGameState, error) {
+	var state savedGameState
+	if sqlFeatures {
+		_, data, err := database.LoadGame(ctx, gameID)
+		if err != nil {
+			return state, err
+		}
+		return state, json.Unmarshal([]byte(data), &state)
+	} else if savedGamesFile == "" {
+		return state, errSavedGamesOff
+	}
+	savedGamesMux.Lock()
+	defer savedGamesMux.Unlock()
+	games, err := readSavedGames()
+	if err != nil {
+		return state, err
+	}
+	state, ok := games[gameID]
+	if !ok {
+		return state, database.ErrSavedGameNotFound
+	}
+	return state, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   THE SAVED GAMES FILE   //////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// readSavedGames reads every game in the saved games file, by ID. savedGamesMux must be locked.
+func readSavedGames() (map[int64]savedGameState, error) {
+	games := make(map[int64]savedGameState)
+	data, err := ioutil.ReadFile(savedGamesFile)
+	if os.IsNotExist(err) {
+		return games, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var stored map[string]savedGameState
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	for key, game := range stored {
+		id, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, errors.New("Bad saved game ID '" + key + "' in " + savedGamesFile)
+		}
+		games[id] = game
+	}
+	return games, nil
+}
+
+// writeSavedGames replaces the saved games file with the games. savedGamesMux must be locked.
+func writeSavedGames(games map[int64]savedGameState) error {
+	stored := make(map[string]savedGameState, len(games))
+	for id, game := range games {
+		stored[strconv.FormatInt(id, 10)] = game
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(savedGamesFile, data)
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSaveGame(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	SetSavedGames(t.TempDir(), 256)
+	defer SetSavedGames("", defaultMaxSavedGameSize)
+
+	room, _ := NewRoom("savedRoom", "test", true, 4, "savedOwner")
+	player, _ := testLogin(t, "savedPlayer")
+	defer player.Kick()
+	room.AddInvite("savedPlayer")
+	if err := player.Join(room, ""); err != nil {
+		t.Fatal(err)
+	}
+	room.SetVariables(map[string]interface{}{"turn": 3, "board": "x-o"})
+
+	// Saving to the same slot again keeps the game's ID
+	id, err := room.SaveGame("slot1")
+	if err != nil {
+		t.Fatal(err)
+	} else if again, err := room.SaveGame("slot1"); err != nil || again != id {
+		t.Error("Expected saving to the same slot to keep the ID", id, "got", again, err)
+	}
+	room.SetVariable("board", strings.Repeat("x", 300))
+	if _, err := room.SaveGame("slot2"); err != ErrSavedGameTooBig {
+		t.Error("Expected the game to be too big to save, got", err)
+	}
+	room.Delete()
+
+	// The game is made again with its settings and variables, and the players are invited back
+	games, err := player.SavedGames()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(games) != 1 || games[0].ID != id || games[0].Slot != "slot1" || games[0].Room != "savedRoom" {
+		t.Fatal("Expected the saved game in the player's list, got", games)
+	}
+	loaded, err := LoadGame(id, "loadedRoom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Delete()
+	if loaded.Type() != "test" || !loaded.IsPrivate() || loaded.MaxUsers() != 4 || loaded.Owner() != "savedOwner" {
+		t.Error("Expected the Room's settings to be loaded")
+	}
+	if board, _ := loaded.GetVariable("board"); board != "x-o" {
+		t.Error("Expected the Room's variables to be loaded, got", board)
+	}
+	if invites, _ := loaded.InviteList(); len(invites) != 1 || invites[0] != "savedPlayer" {
+		t.Error("Expected the player to be invited back, got", invites)
+	}
+
+	if err := DeleteSavedGame(id); err != nil {
+		t.Fatal(err)
+	} else if _, err := LoadGame(id, "deletedRoom"); err == nil {
+		t.Error("A deleted game shouldn't load")
+	}
+}
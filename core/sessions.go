@@ -0,0 +1,221 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"time"
+)
+
+// UserRecoveryState is used internally for persisting logged in Users in recovery snapshots.
+type UserRecoveryState struct {
+	N string              // name
+	D int                 // databaseID
+	G bool                // isGuest
+	S int                 // status
+	C []ConnRecoveryState // conns
+}
+
+// ConnRecoveryState is used internally for persisting a User's connections in recovery snapshots.
+type ConnRecoveryState struct {
+	T string                 // SHA-256 of the connection's session resume token
+	R string                 // room
+	V map[string]interface{} // vars
+}
+
+// pendingSession is a connection from before a restart that can still be resumed.
+type pendingSession struct {
+	user UserRecoveryState // WITHOUT C
+	conn ConnRecoveryState
+}
+
+var (
+	sessionResumeWindow time.Duration
+
+	//pendingMux LOCKS pendingSessions
+	pendingSessions = make(map[string]*pendingSession) // BY ConnRecoveryState.T
+	pendingMux      sync.Mutex
+)
+
+// SetSessionResume is only for internal Gopher Game Server mechanics.
+func SetSessionResume(window time.Duration) {
+	if !serverStarted {
+		sessionResumeWindow = window
+	}
+}
+
+func hashResumeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SAVING SESSIONS   ///////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// GetUsersState is only for internal Gopher Game Server mechanics.
+func GetUsersState() []UserRecoveryState {
+	if sessionResumeWindow <= 0 {
+		return nil
+	}
+	states := []UserRecoveryState{}
+	for _, u := range GetUsers() {
+		u.mux.Lock()
+		state := UserRecoveryState{N: u.Name(), D: u.databaseID, G: u.isGuest, S: u.status}
+		for _, conn := range u.conns {
+			if conn.resumeHash == "" {
+				continue
+			}
+			connState := ConnRecoveryState{T: conn.resumeHash, V: make(map[string]interface{})}
+			if conn.room != nil {
+				connState.R = conn.room.Name()
+			}
+			for key, val := range conn.vars {
+				connState.V[key] = val
+			}
+			state.C = append(state.C, connState)
+		}
+		u.mux.Unlock()
+		if len(state.C) > 0 {
+			states = append(states, state)
+		}
+	}
+
+	// KEEP THE SESSIONS THAT HAVEN'T BEEN RESUMED YET, IN CASE THE SERVER GOES DOWN AGAIN
+	pendingMux.Lock()
+	for _, pending := range pendingSessions {
+		state := pending.user
+		state.C = []ConnRecoveryState{pending.conn}
+		states = append(states, state)
+	}
+	pendingMux.Unlock()
+
+	//
+	return states
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   RESUMING SESSIONS   /////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// RestoreSessions is only for internal Gopher Game Server mechanics.
+func RestoreSessions(states []UserRecoveryState) int {
+	if sessionResumeWindow <= 0 {
+		return 0
+	}
+	restored := 0
+	pendingMux.Lock()
+	for _, state := range states {
+		conns := state.C
+		state.C = nil
+		for _, conn := range conns {
+			if conn.T != "" {
+				pendingSessions[conn.T] = &pendingSession{user: state, conn: conn}
+			}
+		}
+		if len(conns) > 0 {
+			restored++
+		}
+	}
+	pendingMux.Unlock()
+	if restored > 0 {
+		time.AfterFunc(sessionResumeWindow, expireSessions)
+	}
+	return restored
+}
+
+// ResumeSession is only for internal Gopher Game Server mechanics.
+func ResumeSession(token string, socket *websocket.Conn, connUser **User, clientMux *sync.Mutex) (string, bool) {
+	hash := hashResumeToken(token)
+	pendingMux.Lock()
+	pending, ok := pendingSessions[hash]
+	delete(pendingSessions, hash)
+	pendingMux.Unlock()
+	if !ok {
+		return "", false
+	}
+
+	// Log them in like they never left
+	connID, err := Login(pending.user.N, pending.user.D, "", pending.user.G, false, socket, connUser, clientMux)
+	if err.ID != 0 {
+		helpers.Log().Debug("Could not resume session", "user", pending.user.N, "error", err.Message)
+		return "", false
+	}
+	restoreSession(pending, connID)
+	return connID, true
+}
+
+// resumeSessionByName resumes a session after the User has logged in automatically with RememberMe.
+func resumeSessionByName(userName string, connID string) {
+	var pending *pendingSession
+	pendingMux.Lock()
+	for hash, session := range pendingSessions {
+		if session.user.N == userName {
+			pending = session
+			delete(pendingSessions, hash)
+			break
+		}
+	}
+	pendingMux.Unlock()
+	if pending != nil {
+		restoreSession(pending, connID)
+	}
+}
+
+// restoreSession gives a connection that has just logged in its status, variables and Room from before the restart, and
+// sends it a ServerActionSessionRestored message with them.
+func restoreSession(pending *pendingSession, connID string) {
+	u, err := GetUser(pending.user.N)
+	if err != nil {
+		return
+	}
+	if pending.user.S != StatusAvailable && pending.user.S != StatusOffline {
+		u.SetStatus(pending.user.S)
+	}
+	if len(pending.conn.V) > 0 {
+		u.SetVariables(pending.conn.V, connID)
+	}
+	var roomName string
+	if pending.conn.R != "" {
+		if room, roomErr := GetRoom(pending.conn.R); roomErr == nil && u.Join(room, connID) == nil {
+			roomName = room.Name()
+		}
+	}
+	helpers.Log().Debug("Session resumed", "user", u.Name(), "room", roomName, "conn", connID)
+
+	if socket := u.Socket(connID); socket != nil {
+		helpers.WriteMessage(socket, map[string]map[string]interface{}{
+			helpers.ServerActionSessionRestored: {
+				"r": roomName,
+				"s": u.Status(),
+				"v": pending.conn.V,
+			},
+		})
+	}
+}
+
+// expireSessions forgets the sessions that weren't resumed within the SessionResumeWindow, and runs the logout callback for
+// their Users, unless they logged in some other way.
+func expireSessions() {
+	pendingMux.Lock()
+	expired := pendingSessions
+	pendingSessions = make(map[string]*pendingSession)
+	pendingMux.Unlock()
+
+	done := make(map[string]bool)
+	for _, pending := range expired {
+		if done[pending.user.N] {
+			continue
+		}
+		done[pending.user.N] = true
+		if _, err := GetUser(pending.user.N); err == nil {
+			continue
+		}
+		helpers.Log().Debug("Session expired", "user", pending.user.N)
+		if LogoutCallback != nil {
+			LogoutCallback(pending.user.N, pending.user.D)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package core
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSessionResume(t *testing.T) {
+	defer func() {
+		sessionResumeWindow = 0
+		LogoutCallback = nil
+	}()
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	sessionResumeWindow = time.Hour
+	room, roomErr := NewRoom("resumeRoom", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	// Logged in Users are saved with their connection's room and variables
+	user, connID := testLogin(t, "resumeSaved")
+	user.Join(room, connID)
+	user.SetVariable("score", 10.0, connID)
+	var saved *UserRecoveryState
+	states := GetUsersState()
+	for i := range states {
+		if states[i].N == "resumeSaved" {
+			saved = &states[i]
+		}
+	}
+	if saved == nil || len(saved.C) != 1 {
+		t.Fatal("Expected the logged in User's connection to be saved")
+	} else if conn := saved.C[0]; conn.T == "" || conn.R != "resumeRoom" || conn.V["score"] != 10.0 {
+		t.Error("Expected the connection's resume token hash, room and variables to be saved, got", conn)
+	}
+	user.Kick()
+
+	// After a restart, the session is resumed with its token
+	if n := RestoreSessions([]UserRecoveryState{
+		{N: "resumeGuest", D: -1, G: true, S: StatusInGame, C: []ConnRecoveryState{{T: hashResumeToken("guestToken"), R: "resumeRoom",
+			V: map[string]interface{}{"score": 3.0}}}},
+		{N: "resumeGone", D: 4, C: []ConnRecoveryState{{T: hashResumeToken("goneToken")}}},
+	}); n != 2 {
+		t.Fatal("Expected 2 Users waiting to resume their sessions, got", n)
+	}
+	var connUser *User
+	var clientMux sync.Mutex
+	if _, ok := ResumeSession("wrongToken", testSocket(t), &connUser, &clientMux); ok {
+		t.Error("A session should not resume with the wrong token")
+	}
+	resumedID, ok := ResumeSession("guestToken", testSocket(t), &connUser, &clientMux)
+	if !ok {
+		t.Fatal("The session should resume with its token")
+	}
+	resumed := connUser
+	defer resumed.Kick()
+	if resumed.Name() != "resumeGuest" || !resumed.IsGuest() || resumed.Status() != StatusInGame {
+		t.Error("The resumed User should have their name, guest flag and status back")
+	} else if resumed.RoomIn(resumedID) != room {
+		t.Error("The resumed User should be back in their room")
+	} else if resumed.GetVariable("score", resumedID) != 3.0 {
+		t.Error("The resumed User should have their variables back")
+	}
+	if _, ok := ResumeSession("guestToken", testSocket(t), &connUser, &clientMux); ok {
+		t.Error("A session should only resume once")
+	}
+
+	// Users who don't come back are logged out when the window ends
+	var loggedOut []string
+	LogoutCallback = func(name string, dbID int) {
+		loggedOut = append(loggedOut, name)
+	}
+	expireSessions()
+	if len(loggedOut) != 1 || loggedOut[0] != "resumeGone" {
+		t.Error("Expected only the User who didn't come back to be logged out, got", loggedOut)
+	}
+}
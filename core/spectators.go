@@ -0,0 +1,249 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sort"
+)
+
+var (
+	// ErrNoSpectators is returned when a User can't spectate a Room because it doesn't allow spectators.
+	ErrNoSpectators error = helpers.NewError("The room doesn't allow spectators", helpers.ErrorNoSpectators)
+)
+
+// These are the Users in a Room that a message can be sent to with *Room.ServerMessageTo() and *Room.DataMessageTo().
+const (
+	AudienceEveryone   = iota // Everyone in the Room
+	AudiencePlayers           // Only the Users playing in the Room
+	AudienceSpectators        // Only the Users spectating the Room
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SPECTATING A ROOM   /////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// AddSpectator adds a User to the Room as a spectator. Spectators get everything sent to the Room, but don't count towards
+// its maximum User capacity, and can't use voice chat or CustomClientActions unless they're allowed with
+// actions.AllowSpectators(). The Room must allow spectators (see *RoomType.EnableSpectators()). With MultiConnect, a User
+// that's already in the Room keeps the role they have in it. The connID works like it does with *Room.AddUser().
+//
+// Spectators leave, get kicked, and are removed when the Room is deleted the same way players are.
+func (r *Room) AddSpectator(user *User, connID string) error {
+	return r.addUser(user, connID, true)
+}
+
+// Spectate makes a User spectate a Room, leaving the Room they're in. The connID works like it does with *User.Join().
+func (u *User) Spectate(r *Room, connID string) error {
+	return u.SpectateCtx(context.Background(), r, connID)
+}
+
+// SpectateCtx is the same as Spectate, but returns ErrTimeout if ctx is done before the User is added to the Room.
+func (u *User) SpectateCtx(ctx context.Context, r *Room, connID string) error {
+	if ctx.Err() != nil {
+		return ErrTimeout
+	} else if multiConnect && len(connID) == 0 {
+		return errors.New("Must provide a connID when MultiConnect is enabled")
+	} else if !multiConnect {
+		connID = "1"
+	}
+	u.mux.Lock()
+	if _, ok := u.conns[connID]; !ok {
+		u.mux.Unlock()
+		return errors.New("Invalid connID")
+	}
+	currRoom := (*u.conns[connID]).room
+	u.mux.Unlock()
+	if currRoom != nil && currRoom.Name() == r.Name() {
+		return helpers.NewError("User '"+u.Name()+"' is already in room '"+r.Name()+"'", helpers.ErrorAlreadyInRoom)
+	} else if !r.SpectatorsAllowed() {
+		return ErrNoSpectators
+	} else if currRoom != nil && currRoom.Name() != "" {
+		u.leave(connID, LeaveReasonVoluntary)
+	}
+
+	if ctx.Err() != nil {
+		return ErrTimeout
+	}
+	return r.AddSpectator(u, connID)
+}
+
+// PromoteSpectator makes a spectator in the Room one of its players, when the Room has room for another player. Everyone in
+// the Room gets a ServerActionSpectatorPromoted message with the User's name.
+func (r *Room) PromoteSpectator(userName string) error {
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	ru, ok := r.usersMap[userName]
+	if !ok {
+		r.mux.Unlock()
+		return helpers.NewError("User '"+userName+"' is not in room '"+r.name+"'", helpers.ErrorNotInRoom)
+	} else if !ru.IsSpectator() {
+		r.mux.Unlock()
+		return helpers.NewError("User '"+userName+"' is not spectating room '"+r.name+"'", helpers.ErrorPromote)
+	} else if r.playersFull() {
+		r.mux.Unlock()
+		return ErrRoomFull
+	}
+	ru.mux.Lock()
+	ru.spectator = false
+	ru.mux.Unlock()
+	r.spectators--
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	message := helpers.NewEncoded(map[string]interface{}{
+		helpers.ServerActionSpectatorPromoted: userName,
+	})
+	for _, u := range userList {
+		u.mux.Lock()
+		for _, conn := range u.conns {
+			conn.send(message)
+		}
+		u.mux.Unlock()
+	}
+
+	//
+	return nil
+}
+
+// SpectatorsAllowed returns true if Users can spectate the Room.
+func (r *Room) SpectatorsAllowed() bool {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.spectate
+}
+
+// SetSpectatorsAllowed sets whether Users can spectate the Room, instead of using its RoomType's *RoomType.EnableSpectators().
+// Turning spectators off doesn't remove the ones already in the Room.
+func (r *Room) SetSpectatorsAllowed(allowed bool) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.usersMap == nil {
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	r.spectate = allowed
+	return nil
+}
+
+// NumPlayers gets the number of Users playing in the Room, which is what counts towards its maximum User capacity.
+func (r *Room) NumPlayers() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return len(r.usersMap) - r.spectators
+}
+
+// NumSpectators gets the number of Users spectating the Room.
+func (r *Room) NumSpectators() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.spectators
+}
+
+// GetPlayers gets the RoomUsers playing in the Room, in the order they joined it.
+func (r *Room) GetPlayers() ([]*RoomUser, error) {
+	return r.audienceUsers(AudiencePlayers)
+}
+
+// GetSpectators gets the RoomUsers spectating the Room, in the order they joined it.
+func (r *Room) GetSpectators() ([]*RoomUser, error) {
+	return r.audienceUsers(AudienceSpectators)
+}
+
+// audienceUsers gets the RoomUsers in the audience, in the order they joined the Room.
+func (r *Room) audienceUsers(audience int) ([]*RoomUser, error) {
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return []*RoomUser{}, helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	list := make([]*RoomUser, 0, len(r.usersMap))
+	for _, u := range r.usersMap {
+		if inAudience(u, audience) {
+			list = append(list, u)
+		}
+	}
+	r.mux.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].joinNum < list[j].joinNum
+	})
+	return list, nil
+}
+
+func inAudience(u *RoomUser, audience int) bool {
+	switch audience {
+	case AudiencePlayers:
+		return !u.IsSpectator()
+	case AudienceSpectators:
+		return u.IsSpectator()
+	}
+	return true
+}
+
+// IsSpectator returns true if the RoomUser is spectating the Room.
+func (u *RoomUser) IsSpectator() bool {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	return u.spectator
+}
+
+// IsSpectating returns true if the User's connection is spectating the Room it's in. The connID works like it does with
+// *User.RoomIn().
+func (u *User) IsSpectating(connID string) bool {
+	room := u.RoomIn(connID)
+	if room == nil {
+		return false
+	}
+	room.mux.Lock()
+	ru := room.usersMap[u.Name()]
+	room.mux.Unlock()
+	return ru != nil && ru.IsSpectator()
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   MESSAGING AN AUDIENCE   /////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ServerMessageTo sends a server message to the audience in the Room, like AudienceSpectators.
+func (r *Room) ServerMessageTo(audience int, message interface{}, messageType int) error {
+	if message == nil {
+		return errors.New("*Room.ServerMessageTo() requires a message")
+	}
+	users, err := r.audienceUsers(audience)
+	if err != nil {
+		return err
+	}
+
+	if serverMessageCallbackSet {
+		serverMessageCallback(r, messageType, message)
+	}
+
+	r.events.record(EventServerMessage, "", map[string]interface{}{"m": message, "s": messageType, "a": audience})
+	sendToRoomUsers(users, roomMessage(MessageTypeServer, messageType, "", message))
+	return nil
+}
+
+// DataMessageTo sends a data message to the audience in the Room, like AudiencePlayers.
+func (r *Room) DataMessageTo(audience int, message interface{}) error {
+	users, err := r.audienceUsers(audience)
+	if err != nil {
+		return err
+	}
+	r.events.record(EventDataMessage, "", map[string]interface{}{"m": message, "a": audience})
+	sendToRoomUsers(users, helpers.NewEncoded(map[string]interface{}{
+		helpers.ServerActionDataMessage: message,
+	}))
+	return nil
+}
+
+func sendToRoomUsers(users []*RoomUser, message *helpers.Encoded) {
+	for _, u := range users {
+		u.mux.Lock()
+		for _, conn := range u.conns {
+			conn.send(message)
+		}
+		u.mux.Unlock()
+	}
+}
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"reflect"
+)
+
+// stateDelta is the changes between two versions of a Room's synced state. Each change is a path into the state, made of
+// map keys and slice indexes, starting with the synced state's key.
+type stateDelta struct {
+	set     []interface{} // [path, value] PAIRS
+	removed []interface{} // PATHS
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SYNCED STATE   //////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SetSyncedState sets a piece of the Room's synced state. Unlike Room variables, only what changed since the last value is
+// sent to the Room: nested map[string]interface{} and []interface{} values are compared, and everyone in the Room gets a
+// helpers.ServerActionStateDelta message with the changed paths, the removed paths, and the state's sequence number, which goes
+// up by one with each change. Setting a key to nil removes it. Values are copied, so you can keep changing the same map
+// and set it again. Other types of maps and slices are sent whole when they change.
+//
+// Users get a helpers.ServerActionStateSnapshot message with all of the synced state and its sequence number when they join
+// the Room, and when their client asks for it with the helpers.ClientActionStateResync client action, like when it misses a
+// sequence number. Clients should drop deltas with a sequence number that isn't above their snapshot's.
+//
+// The synced state and its sequence number are saved in recovery snapshots, so clients don't see the sequence start over
+// after a restart.
+func (r *Room) SetSyncedState(key string, value interface{}) error {
+	if len(key) == 0 {
+		return errors.New("*Room.SetSyncedState() requires a key")
+	}
+	// KEEPS THE DELTAS GOING OUT IN ORDER
+	r.syncMux.Lock()
+	defer r.syncMux.Unlock()
+
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	var delta stateDelta
+	old, had := r.synced[key]
+	if value == nil {
+		if !had {
+			r.mux.Unlock()
+			return nil
+		}
+		delete(r.synced, key)
+		delta.removed = append(delta.removed, []interface{}{key})
+	} else {
+		value = copyState(value)
+		if had {
+			diffState([]interface{}{key}, old, value, &delta)
+		} else {
+			delta.set = append(delta.set, []interface{}{[]interface{}{key}, value})
+		}
+		if r.synced == nil {
+			r.synced = make(map[string]interface{})
+		}
+		r.synced[key] = value
+	}
+	if len(delta.set) == 0 && len(delta.removed) == 0 {
+		r.mux.Unlock()
+		return nil
+	}
+	r.syncSeq++
+	deltaMessage := map[string]interface{}{"q": r.syncSeq}
+	if len(delta.set) > 0 {
+		deltaMessage["s"] = delta.set
+	}
+	if len(delta.removed) > 0 {
+		deltaMessage["r"] = delta.removed
+	}
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	sendToUsers(userList, helpers.NewEncoded(map[string]interface{}{
+		helpers.ServerActionStateDelta: deltaMessage,
+	}))
+
+	//
+	return nil
+}
+
+// GetSyncedState gets a copy of a piece of the Room's synced state, or nil if it isn't set.
+func (r *Room) GetSyncedState(key string) (interface{}, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.usersMap == nil {
+		return nil, helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	return copyState(r.synced[key]), nil
+}
+
+// SyncedStateSequence gets the sequence number of the Room's synced state, which goes up by one with each change.
+func (r *Room) SyncedStateSequence() uint64 {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return r.syncSeq
+}
+
+// SendSyncedState sends all of the Room's synced state to one of the User's connections in the Room, in a
+// helpers.ServerActionStateSnapshot message. The connID works like it does with *User.RoomIn().
+func (r *Room) SendSyncedState(user *User, connID string) error {
+	if user == nil {
+		return errors.New("*Room.SendSyncedState() requires a User")
+	} else if !multiConnect {
+		connID = "1"
+	}
+	r.syncMux.Lock()
+	defer r.syncMux.Unlock()
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	ru, ok := r.usersMap[user.Name()]
+	if !ok {
+		r.mux.Unlock()
+		return helpers.NewError("User '"+user.Name()+"' is not in room '"+r.name+"'", helpers.ErrorNotInRoom)
+	}
+	message := r.syncedSnapshot()
+	r.mux.Unlock()
+
+	ru.mux.Lock()
+	conn := ru.conns[connID]
+	ru.mux.Unlock()
+	if conn == nil {
+		return errors.New("Invalid connID")
+	}
+	conn.send(message)
+
+	//
+	return nil
+}
+
+// RestoreSyncedState is only for internal Gopher Game Server mechanics.
+func (r *Room) RestoreSyncedState(state map[string]interface{}, seq uint64) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.usersMap == nil {
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	r.synced = make(map[string]interface{}, len(state))
+	for key, value := range state {
+		r.synced[key] = value
+	}
+	r.syncSeq = seq
+	return nil
+}
+
+// syncedSnapshot makes the message with all of the Room's synced state. Must lock the Room's mux to use.
+func (r *Room) syncedSnapshot() *helpers.Encoded {
+	state := make(map[string]interface{}, len(r.synced))
+	for key, value := range r.synced {
+		state[key] = value
+	}
+	return helpers.NewEncoded(map[string]map[string]interface{}{
+		helpers.ServerActionStateSnapshot: {
+			"q": r.syncSeq,
+			"s": state,
+		},
+	})
+}
+
+// getSyncedState gets a copy of the Room's synced state for a recovery snapshot. Must lock the Room's mux to use.
+func (r *Room) getSyncedState() map[string]interface{} {
+	if len(r.synced) == 0 {
+		return nil
+	}
+	state := make(map[string]interface{}, len(r.synced))
+	for key, value := range r.synced {
+		state[key] = value
+	}
+	return state
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   DIFFING   ///////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// diffState adds the changes from oldVal to newVal at path to the delta. Maps are compared key by key, and slices of the same
+// length item by item. Anything else that changed is set whole.
+func diffState(path []interface{}, oldVal interface{}, newVal interface{}, delta *stateDelta) {
+	switch newV := newVal.(type) {
+	case map[string]interface{}:
+		if oldV, ok := oldVal.(map[string]interface{}); ok {
+			for key, val := range newV {
+				if oldItem, had := oldV[key]; had {
+					diffState(statePath(path, key), oldItem, val, delta)
+				} else {
+					delta.set = append(delta.set, []interface{}{statePath(path, key), val})
+				}
+			}
+			for key := range oldV {
+				if _, ok := newV[key]; !ok {
+					delta.removed = append(delta.removed, statePath(path, key))
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if oldV, ok := oldVal.([]interface{}); ok && len(oldV) == len(newV) {
+			for i := range newV {
+				diffState(statePath(path, i), oldV[i], newV[i], delta)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(oldVal, newVal) {
+		delta.set = append(delta.set, []interface{}{path, newVal})
+	}
+}
+
+// statePath makes a new path with elem added to the end of path.
+func statePath(path []interface{}, elem interface{}) []interface{} {
+	newPath := make([]interface{}, len(path), len(path)+1)
+	copy(newPath, path)
+	return append(newPath, elem)
+}
+
+// copyState makes a deep copy of the map[string]interface{} and []interface{} values in a piece of synced state.
+func copyState(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			c[key] = copyState(val)
+		}
+		return c
+	case []interface{}:
+		c := make([]interface{}, len(v))
+		for i, val := range v {
+			c[i] = copyState(val)
+		}
+		return c
+	}
+	return value
+}
@@ -0,0 +1,119 @@
+package core
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestDiffState(t *testing.T) {
+	old := map[string]interface{}{
+		"board": []interface{}{"x", "", "o"},
+		"score": map[string]interface{}{"x": 1, "o": 2},
+		"turn":  "x",
+		"gone":  true,
+	}
+	newState := map[string]interface{}{
+		"board": []interface{}{"x", "x", "o"},
+		"score": map[string]interface{}{"x": 1, "o": 3},
+		"turn":  "o",
+		"added": []interface{}{1},
+	}
+	var delta stateDelta
+	diffState([]interface{}{"game"}, old, newState, &delta)
+
+	set := map[string]interface{}{}
+	for _, change := range delta.set {
+		pair := change.([]interface{})
+		set[pathString(pair[0].([]interface{}))] = pair[1]
+	}
+	expected := map[string]interface{}{
+		"game/board/1": "x",
+		"game/score/o": 3,
+		"game/turn":    "o",
+		"game/added":   []interface{}{1},
+	}
+	if !reflect.DeepEqual(set, expected) {
+		t.Error("Expected the changed paths", expected, "got", set)
+	}
+	if len(delta.removed) != 1 || pathString(delta.removed[0].([]interface{})) != "game/gone" {
+		t.Error("Expected game/gone to be removed, got", delta.removed)
+	}
+
+	// Slices that change length are set whole
+	delta = stateDelta{}
+	diffState([]interface{}{"list"}, []interface{}{1, 2}, []interface{}{1, 2, 3}, &delta)
+	if len(delta.set) != 1 || len(delta.removed) != 0 {
+		t.Error("Expected the whole slice to be set, got", delta.set)
+	}
+}
+
+func pathString(path []interface{}) string {
+	s := ""
+	for i, elem := range path {
+		if i > 0 {
+			s += "/"
+		}
+		switch e := elem.(type) {
+		case string:
+			s += e
+		case int:
+			s += strconv.Itoa(e)
+		}
+	}
+	return s
+}
+
+func TestSyncedState(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("syncRoom", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	user, _ := testLogin(t, "syncUser")
+	defer user.Kick()
+	user.Join(room, "")
+
+	// The sequence only goes up when something changed
+	state := map[string]interface{}{"round": 1, "players": map[string]interface{}{"syncUser": 0}}
+	if err := room.SetSyncedState("game", state); err != nil {
+		t.Fatal(err)
+	}
+	room.SetSyncedState("game", state)
+	if seq := room.SyncedStateSequence(); seq != 1 {
+		t.Error("Expected sequence 1 after setting the same state twice, got", seq)
+	}
+
+	// The state is copied, so changing the same map and setting it again is a change
+	state["players"].(map[string]interface{})["syncUser"] = 10
+	room.SetSyncedState("game", state)
+	if seq := room.SyncedStateSequence(); seq != 2 {
+		t.Error("Expected sequence 2, got", seq)
+	}
+	got, _ := room.GetSyncedState("game")
+	if got.(map[string]interface{})["players"].(map[string]interface{})["syncUser"] != 10 {
+		t.Error("Expected the new score in the synced state, got", got)
+	}
+	if err := room.SendSyncedState(user, ""); err != nil {
+		t.Error(err)
+	}
+
+	// The state and sequence are kept in recovery snapshots
+	saved := GetRoomsState()["syncRoom"]
+	if saved.SQ != 2 || saved.SS["game"] == nil {
+		t.Error("Expected the synced state in the recovery state, got", saved.SQ, saved.SS)
+	}
+	restored, roomErr := NewRoom("syncRestored", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer restored.Delete()
+	restored.RestoreSyncedState(saved.SS, saved.SQ)
+	restored.SetSyncedState("game", nil)
+	if seq := restored.SyncedStateSequence(); seq != 3 {
+		t.Error("Expected the sequence to carry on after recovering, got", seq)
+	} else if got, _ := restored.GetSyncedState("game"); got != nil {
+		t.Error("Setting nil should remove the state, got", got)
+	}
+}
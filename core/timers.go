@@ -0,0 +1,244 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"time"
+)
+
+// TimerRecoveryState is used internally for persisting a Room's repeating timers in recovery snapshots.
+type TimerRecoveryState struct {
+	D int64 // duration, in milliseconds
+	L int64 // time left until it next goes off, in milliseconds
+	B bool  // broadcast
+}
+
+// roomTimer is a timer set on a Room with *Room.SetTimer().
+type roomTimer struct {
+	name      string
+	duration  time.Duration
+	repeat    bool
+	broadcast bool
+	fn        func(*Room)
+	stop      chan bool
+
+	ends time.Time // LOCKED BY THE Room's mux
+}
+
+// How often a broadcasting timer tells the Room how long it has left.
+const timerTickInterval = time.Second
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SETTING AND CANCELING TIMERS   //////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SetTimer sets a timer named name on the Room, which runs fn after duration. A repeating timer keeps running fn every
+// duration until it's canceled with *Room.CancelTimer(). Setting a timer with the name of one the Room already has replaces
+// it. A Room's timers are canceled when it's deleted.
+//
+// fn runs in its own goroutine, not while the Room is locked, so it can use any of the Room's methods. A panic in fn is
+// logged instead of crashing the server. Use a nil fn to run the Room's RoomType's timer callback for name (see
+// *RoomType.SetTimerCallback()).
+//
+// When broadcast is true, everyone in the Room gets a helpers.ServerActionTimerTick message with the time left every
+// second, and a helpers.ServerActionTimerDone message each time the timer goes off.
+//
+// Repeating timers are saved in recovery snapshots with the time they have left, and restarted with the RoomType's timer
+// callback for their name when the server recovers. A repeating timer whose RoomType has no timer callback for its name
+// isn't recovered.
+func (r *Room) SetTimer(name string, duration time.Duration, repeat bool, broadcast bool, fn func(*Room)) error {
+	if len(name) == 0 {
+		return errors.New("*Room.SetTimer() requires a timer name")
+	} else if duration <= 0 {
+		return errors.New("A timer's duration must be more than 0")
+	}
+	if fn == nil {
+		if fn = r.timerCallback(name); fn == nil {
+			return errors.New("The room type '" + r.rType + "' has no timer callback for '" + name + "'")
+		}
+	}
+	return r.startTimer(&roomTimer{name: name, duration: duration, repeat: repeat, broadcast: broadcast, fn: fn}, duration)
+}
+
+// CancelTimer stops the timer named name on the Room before it goes off again.
+func (r *Room) CancelTimer(name string) error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.usersMap == nil {
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	timer, ok := r.timers[name]
+	if !ok {
+		return errors.New("The room '" + r.name + "' has no timer named '" + name + "'")
+	}
+	close(timer.stop)
+	delete(r.timers, name)
+	return nil
+}
+
+// TimerLeft gets how long is left until the timer named name on the Room goes off.
+func (r *Room) TimerLeft(name string) (time.Duration, error) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	timer, ok := r.timers[name]
+	if !ok {
+		return 0, errors.New("The room '" + r.name + "' has no timer named '" + name + "'")
+	}
+	if left := time.Until(timer.ends); left > 0 {
+		return left, nil
+	}
+	return 0, nil
+}
+
+// RestoreTimer is only for internal Gopher Game Server mechanics.
+func (r *Room) RestoreTimer(name string, state TimerRecoveryState) error {
+	fn := r.timerCallback(name)
+	if fn == nil {
+		return errors.New("The room type '" + r.rType + "' has no timer callback for '" + name + "'")
+	}
+	duration := time.Duration(state.D) * time.Millisecond
+	if duration <= 0 {
+		return errors.New("A timer's duration must be more than 0")
+	}
+	left := time.Duration(state.L) * time.Millisecond
+	if left < 0 || left > duration {
+		left = duration
+	}
+	return r.startTimer(&roomTimer{name: name, duration: duration, repeat: true, broadcast: state.B, fn: fn}, left)
+}
+
+func (r *Room) timerCallback(name string) func(*Room) {
+	if roomType, ok := roomTypes[r.rType]; ok {
+		return roomType.TimerCallback(name)
+	}
+	return nil
+}
+
+// startTimer adds a timer to the Room that first goes off after first, replacing the one with the same name.
+func (r *Room) startTimer(timer *roomTimer, first time.Duration) error {
+	timer.stop = make(chan bool)
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	if old, ok := r.timers[timer.name]; ok {
+		close(old.stop)
+	}
+	if r.timers == nil {
+		r.timers = make(map[string]*roomTimer)
+	}
+	timer.ends = time.Now().Add(first)
+	r.timers[timer.name] = timer
+	r.mux.Unlock()
+	go r.runTimer(timer, first)
+
+	//
+	return nil
+}
+
+// stopTimers cancels all of the Room's timers. r.mux must be locked.
+func (r *Room) stopTimers() {
+	for _, timer := range r.timers {
+		close(timer.stop)
+	}
+	r.timers = nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   RUNNING TIMERS   ////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (r *Room) runTimer(timer *roomTimer, first time.Duration) {
+	end := time.NewTimer(first)
+	defer end.Stop()
+	var ticks <-chan time.Time
+	var ticker *time.Ticker
+	if timer.broadcast {
+		ticker = time.NewTicker(timerTickInterval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-ticks:
+			r.mux.Lock()
+			left := time.Until(timer.ends)
+			r.mux.Unlock()
+			if left > 0 {
+				r.broadcastTimer(helpers.ServerActionTimerTick, map[string]interface{}{"n": timer.name, "l": left.Milliseconds()})
+			}
+		case <-end.C:
+			// IT COULD HAVE BEEN CANCELED OR REPLACED AS IT WENT OFF
+			r.mux.Lock()
+			if r.timers[timer.name] != timer {
+				r.mux.Unlock()
+				return
+			}
+			if timer.repeat {
+				timer.ends = timer.ends.Add(timer.duration)
+				end.Reset(time.Until(timer.ends))
+				if ticker != nil {
+					ticker.Reset(timerTickInterval)
+				}
+			} else {
+				delete(r.timers, timer.name)
+			}
+			r.events.record(EventTimer, "", map[string]interface{}{"n": timer.name})
+			r.mux.Unlock()
+
+			if timer.broadcast {
+				r.broadcastTimer(helpers.ServerActionTimerDone, map[string]interface{}{"n": timer.name})
+			}
+			r.runTimerFunc(timer)
+			if !timer.repeat {
+				return
+			}
+		case <-timer.stop:
+			return
+		}
+	}
+}
+
+// runTimerFunc runs a timer's function, and logs it if it panics.
+func (r *Room) runTimerFunc(timer *roomTimer) {
+	defer func() {
+		if p := recover(); p != nil {
+			helpers.Recovered("room timer "+timer.name, p, "room", r.name)
+		}
+	}()
+	timer.fn(r)
+}
+
+// broadcastTimer sends a timer message to everyone in the Room.
+func (r *Room) broadcastTimer(action string, data map[string]interface{}) {
+	message := helpers.NewEncoded(map[string]map[string]interface{}{action: data})
+	r.mux.Lock()
+	for _, u := range r.usersMap {
+		u.mux.Lock()
+		for _, conn := range u.conns {
+			conn.send(message)
+		}
+		u.mux.Unlock()
+	}
+	r.mux.Unlock()
+}
+
+// getTimersState gets the recovery state of the Room's repeating timers. r.mux must be locked.
+func (r *Room) getTimersState() map[string]TimerRecoveryState {
+	var state map[string]TimerRecoveryState
+	for name, timer := range r.timers {
+		if !timer.repeat {
+			continue
+		}
+		if state == nil {
+			state = make(map[string]TimerRecoveryState)
+		}
+		left := time.Until(timer.ends)
+		if left < 0 {
+			left = 0
+		}
+		state[name] = TimerRecoveryState{D: timer.duration.Milliseconds(), L: left.Milliseconds(), B: timer.broadcast}
+	}
+	return state
+}
@@ -0,0 +1,162 @@
+package core
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRoomTimers(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	recovered := make(chan *Room, 10)
+	NewRoomType("timerTest", false).SetTimerCallback("round", func(room *Room) {
+		recovered <- room
+	})
+	room, roomErr := NewRoom("timerRoom", "timerTest", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	fired := make(chan *Room, 10)
+	fire := func(room *Room) { fired <- room }
+	wait := func(ch chan *Room, what string) {
+		select {
+		case r := <-ch:
+			if r != room {
+				t.Error("Expected the timer to get its Room, got", r)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("Expected " + what)
+		}
+	}
+
+	// A one shot timer goes off once, then it's gone
+	if err := room.SetTimer("once", time.Millisecond*20, false, false, fire); err != nil {
+		t.Fatal(err)
+	} else if left, err := room.TimerLeft("once"); err != nil || left <= 0 || left > time.Millisecond*20 {
+		t.Error("Expected the time left on the timer, got", left, err)
+	}
+	wait(fired, "the timer to go off")
+	time.Sleep(time.Millisecond * 10)
+	if _, err := room.TimerLeft("once"); err == nil {
+		t.Error("The timer should be gone after going off")
+	}
+
+	// Repeating timers keep going until they're canceled
+	if err := room.SetTimer("repeat", time.Millisecond*10, true, false, fire); err != nil {
+		t.Fatal(err)
+	}
+	wait(fired, "the repeating timer to go off")
+	wait(fired, "the repeating timer to go off again")
+	if err := room.CancelTimer("repeat"); err != nil {
+		t.Fatal(err)
+	} else if room.CancelTimer("repeat") == nil {
+		t.Error("Canceling a timer twice should fail")
+	}
+	time.Sleep(time.Millisecond * 30)
+	for len(fired) > 0 {
+		<-fired
+	}
+	time.Sleep(time.Millisecond * 30)
+	if len(fired) > 0 {
+		t.Error("The canceled timer should not go off")
+	}
+
+	// Setting a timer with the same name replaces it
+	room.SetTimer("replaced", time.Millisecond*20, false, false, func(*Room) { t.Error("The replaced timer should not go off") })
+	room.SetTimer("replaced", time.Millisecond*40, false, false, fire)
+	wait(fired, "the replacing timer to go off")
+
+	// A panic in the timer's function doesn't crash the server
+	room.SetTimer("panics", time.Millisecond, false, false, func(*Room) { panic("timer panic") })
+	time.Sleep(time.Millisecond * 20)
+
+	// A nil function uses the RoomType's timer callback
+	if room.SetTimer("noCallback", time.Second, false, false, nil) == nil {
+		t.Error("Expected an error for a nil function without a timer callback")
+	}
+	if err := room.SetTimer("round", time.Millisecond*10, false, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	wait(recovered, "the timer callback to run")
+
+	// Only repeating timers are saved for recovery, and they're restored with the RoomType's timer callback
+	room.SetTimer("turn", time.Hour, false, false, fire)
+	room.SetTimer("round", time.Hour, true, true, fire)
+	state := GetRoomsState()["timerRoom"].TM
+	if len(state) != 1 || state["round"].D != time.Hour.Milliseconds() || !state["round"].B || state["round"].L <= 0 {
+		t.Fatal("Expected only the repeating timer in the recovery state, got", state)
+	}
+	restored, roomErr := NewRoom("timerRestored", "timerTest", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	if restored.RestoreTimer("turn", TimerRecoveryState{D: 10, L: 10}) == nil {
+		t.Error("A timer without a timer callback should not be restored")
+	}
+	if err := restored.RestoreTimer("round", TimerRecoveryState{D: time.Hour.Milliseconds(), L: 10}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case r := <-recovered:
+		if r != restored {
+			t.Error("Expected the restored timer to get its Room, got", r)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Expected the restored timer to go off after the time it had left")
+	}
+
+	// Deleting a Room cancels its timers
+	restored.SetTimer("afterDelete", time.Millisecond*20, false, false, func(*Room) { t.Error("A deleted Room's timer should not go off") })
+	restored.Delete()
+	if _, err := restored.TimerLeft("round"); err == nil {
+		t.Error("The deleted Room should have no timers")
+	} else if restored.SetTimer("late", time.Second, false, false, fire) == nil {
+		t.Error("A deleted Room should not get new timers")
+	}
+	time.Sleep(time.Millisecond * 40)
+}
+
+func TestRoomTimerBroadcast(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("timerBroadcastRoom", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	socket, client := testSocketPair(t)
+	var listener *User
+	var clientMux sync.Mutex
+	listenerID, err := Login("timerListener", -1, "", true, false, socket, &listener, &clientMux)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	defer listener.Kick()
+	listener.Join(room, listenerID)
+
+	// read reads messages until a timer message
+	read := func() (string, map[string]interface{}) {
+		client.SetReadDeadline(time.Now().Add(time.Second * 3))
+		for {
+			var message map[string]map[string]interface{}
+			if readErr := client.ReadJSON(&message); readErr != nil {
+				t.Fatal("Expected a timer message:", readErr)
+			}
+			for _, action := range []string{helpers.ServerActionTimerTick, helpers.ServerActionTimerDone} {
+				if data, ok := message[action]; ok {
+					return action, data
+				}
+			}
+		}
+	}
+
+	room.SetTimer("countdown", time.Millisecond*1500, false, true, func(*Room) {})
+	if action, data := read(); action != helpers.ServerActionTimerTick || data["n"] != "countdown" || data["l"].(float64) <= 0 || data["l"].(float64) > 1000 {
+		t.Error("Expected a tick with the time left, got", action, data)
+	}
+	if action, data := read(); action != helpers.ServerActionTimerDone || data["n"] != "countdown" {
+		t.Error("Expected the timer to be done, got", action, data)
+	}
+}
@@ -0,0 +1,242 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"time"
+)
+
+// turnState is a Room's turn order, set with *Room.EnableTurns(). It's locked by the Room's mux.
+type turnState struct {
+	order   []string
+	current int
+	turnNum int // COUNTS THE TURNS, SO A TIMEOUT FOR AN OLD TURN DOES NOTHING
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+const (
+	errorNoTurns = "The room doesn't have turns enabled"
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   TURN ORDER   ////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// EnableTurns makes the Users in order take turns in the Room, starting with the first one. The Users must be playing in the
+// Room, not spectating it. Enabling turns again starts a new rotation.
+//
+// When turnTimeout is more than 0, a turn that isn't over after turnTimeout times out. Everyone in the Room gets a
+// helpers.ServerActionTurnTimeout message, and then the RoomType's turn timeout callback is called so you can play a default
+// move (see *RoomType.SetTurnTimeoutCallback()). Without a turn timeout callback, the turn goes to the next User.
+//
+// Everyone in the Room gets a helpers.ServerActionTurnChange message each time the turn changes. A User that leaves the Room
+// is taken out of the rotation, and if it was their turn, it goes to the User after them. Use actions.RequireTurn() to make a
+// CustomClientAction only accept the User whose turn it is.
+func (r *Room) EnableTurns(order []string, turnTimeout time.Duration) error {
+	if len(order) == 0 {
+		return errors.New("*Room.EnableTurns() requires at least one User")
+	} else if turnTimeout < 0 {
+		turnTimeout = 0
+	}
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	}
+	seen := make(map[string]bool)
+	for _, userName := range order {
+		ru, ok := r.usersMap[userName]
+		if !ok || ru.IsSpectator() {
+			r.mux.Unlock()
+			return helpers.NewError("User '"+userName+"' is not playing in room '"+r.name+"'", helpers.ErrorNotInRoom)
+		} else if seen[userName] {
+			r.mux.Unlock()
+			return errors.New("User '" + userName + "' is in the turn order more than once")
+		}
+		seen[userName] = true
+	}
+	r.stopTurns()
+	r.turns = &turnState{order: append([]string{}, order...), timeout: turnTimeout}
+	message := r.startTurn()
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	sendToUsers(userList, message)
+
+	//
+	return nil
+}
+
+// DisableTurns stops the Room's turns.
+func (r *Room) DisableTurns() error {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.usersMap == nil {
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if r.turns == nil {
+		return errors.New(errorNoTurns)
+	}
+	r.stopTurns()
+	return nil
+}
+
+// CurrentTurn gets the name of the User whose turn it is in the Room. It's empty when the Room doesn't have turns enabled.
+func (r *Room) CurrentTurn() string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.turns == nil {
+		return ""
+	}
+	return r.turns.order[r.turns.current]
+}
+
+// TurnOrder gets the names of the Users taking turns in the Room, in the order they take them.
+func (r *Room) TurnOrder() []string {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if r.turns == nil {
+		return []string{}
+	}
+	return append([]string{}, r.turns.order...)
+}
+
+// AdvanceTurn gives the turn to the next User in the Room's turn order.
+func (r *Room) AdvanceTurn() error {
+	return r.advanceTurn("")
+}
+
+// EndTurn gives the turn to the next User in the Room's turn order, but only if it's userName's turn. Use it in a
+// CustomClientAction callback, so a move that was made as the turn timed out doesn't end the next User's turn.
+func (r *Room) EndTurn(userName string) error {
+	if len(userName) == 0 {
+		return errors.New("*Room.EndTurn() requires a user name")
+	}
+	return r.advanceTurn(userName)
+}
+
+// advanceTurn gives the turn to the next User, if it's whose turn it is. Anyone's turn is ended when whose is empty.
+func (r *Room) advanceTurn(whose string) error {
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if r.turns == nil {
+		r.mux.Unlock()
+		return errors.New(errorNoTurns)
+	} else if whose != "" && r.turns.order[r.turns.current] != whose {
+		r.mux.Unlock()
+		return errors.New("It's not the turn of User '" + whose + "'")
+	}
+	r.turns.current = (r.turns.current + 1) % len(r.turns.order)
+	message := r.startTurn()
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	sendToUsers(userList, message)
+
+	//
+	return nil
+}
+
+// startTurn starts the turn timeout for the current turn, and makes the turn change message. Must lock the Room's mux to use.
+func (r *Room) startTurn() *helpers.Encoded {
+	t := r.turns
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.turnNum++
+	if t.timeout > 0 {
+		turnNum := t.turnNum
+		t.timer = time.AfterFunc(t.timeout, func() {
+			r.turnTimedOut(turnNum)
+		})
+	}
+	return helpers.NewEncoded(map[string]map[string]interface{}{
+		helpers.ServerActionTurnChange: {
+			"u": t.order[t.current],
+			"n": t.turnNum,
+			"l": t.timeout.Milliseconds(),
+		},
+	})
+}
+
+// stopTurns turns off the Room's turns. Must lock the Room's mux to use.
+func (r *Room) stopTurns() {
+	if r.turns != nil && r.turns.timer != nil {
+		r.turns.timer.Stop()
+	}
+	r.turns = nil
+}
+
+// removeFromTurns takes a User that left the Room out of its turn order. If it was their turn, the turn goes to the User
+// after them, and the turn change message is returned. Must lock the Room's mux to use.
+func (r *Room) removeFromTurns(userName string) *helpers.Encoded {
+	t := r.turns
+	if t == nil {
+		return nil
+	}
+	i := -1
+	for j, turnName := range t.order {
+		if turnName == userName {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return nil
+	}
+	t.order = append(t.order[:i], t.order[i+1:]...)
+	if len(t.order) == 0 {
+		r.stopTurns()
+		return nil
+	} else if i > t.current {
+		return nil
+	} else if i < t.current {
+		t.current--
+		return nil
+	}
+	// IT WAS THEIR TURN - THE NEXT USER IS NOW AT i
+	t.current = i % len(t.order)
+	return r.startTurn()
+}
+
+// turnTimedOut handles the turn numbered turnNum timing out, if it's still going.
+func (r *Room) turnTimedOut(turnNum int) {
+	r.mux.Lock()
+	if r.usersMap == nil || r.turns == nil || r.turns.turnNum != turnNum {
+		r.mux.Unlock()
+		return
+	}
+	userName := r.turns.order[r.turns.current]
+	userList := r.roomUsers()
+	r.mux.Unlock()
+
+	sendToUsers(userList, helpers.NewEncoded(map[string]map[string]interface{}{
+		helpers.ServerActionTurnTimeout: {
+			"u": userName,
+			"n": turnNum,
+		},
+	}))
+
+	// THE CALLBACK PLAYS A DEFAULT MOVE AND ENDS THE TURN ITSELF
+	if roomType := roomTypes[r.rType]; roomType.HasTurnTimeoutCallback() {
+		roomType.TurnTimeoutCallback()(r, userName)
+		return
+	}
+	r.EndTurn(userName)
+}
+
+// sendToUsers sends a message to all the RoomUsers in userList.
+func sendToUsers(userList []*RoomUser, message *helpers.Encoded) {
+	if message == nil {
+		return
+	}
+	for _, u := range userList {
+		u.mux.Lock()
+		for _, conn := range u.conns {
+			conn.send(message)
+		}
+		u.mux.Unlock()
+	}
+}
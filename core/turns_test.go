@@ -0,0 +1,84 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoomTurns(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	timedOut := make(chan string, 10)
+	NewRoomType("turnTest", false).SetTurnTimeoutCallback(func(room *Room, userName string) {
+		timedOut <- userName
+		room.EndTurn(userName)
+	})
+	room, roomErr := NewRoom("turnRoom", "turnTest", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	var players []*User
+	for _, name := range []string{"turnA", "turnB", "turnC", "turnD"} {
+		user, _ := testLogin(t, name)
+		defer user.Kick()
+		user.Join(room, "")
+		players = append(players, user)
+	}
+
+	// Turns go around in order
+	if err := room.EnableTurns([]string{"turnA", "turnB", "turnNobody"}, 0); err == nil {
+		t.Error("Users that aren't in the Room can't take turns")
+	} else if err := room.EnableTurns([]string{"turnA", "turnB", "turnC", "turnD"}, 0); err != nil {
+		t.Fatal(err)
+	} else if room.CurrentTurn() != "turnA" {
+		t.Fatal("Expected turnA's turn, got", room.CurrentTurn())
+	}
+	if room.EndTurn("turnB") == nil {
+		t.Error("Only the User whose turn it is can end it")
+	} else if err := room.EndTurn("turnA"); err != nil || room.CurrentTurn() != "turnB" {
+		t.Error("Expected turnB's turn, got", room.CurrentTurn(), err)
+	}
+
+	// Leaving takes Users out of the rotation without breaking it
+	players[0].Leave("") // Before the current turn
+	if room.CurrentTurn() != "turnB" {
+		t.Error("Expected it to still be turnB's turn, got", room.CurrentTurn())
+	}
+	players[1].Leave("") // The current turn
+	if room.CurrentTurn() != "turnC" {
+		t.Error("Expected turnC's turn after turnB left, got", room.CurrentTurn())
+	}
+	room.AdvanceTurn()
+	players[3].Leave("") // The last in the order, on their turn
+	if room.CurrentTurn() != "turnC" {
+		t.Error("Expected the turn to wrap around to turnC, got", room.CurrentTurn())
+	} else if order := room.TurnOrder(); len(order) != 1 || order[0] != "turnC" {
+		t.Error("Expected only turnC in the turn order, got", order)
+	}
+	players[2].Leave("")
+	if room.CurrentTurn() != "" {
+		t.Error("Turns should stop when nobody is left to take them")
+	}
+
+	// A timed out turn goes to the RoomType's callback
+	players[0].Join(room, "")
+	players[1].Join(room, "")
+	if err := room.EnableTurns([]string{"turnA", "turnB"}, time.Millisecond*20); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"turnA", "turnB"} {
+		select {
+		case userName := <-timedOut:
+			if userName != expected {
+				t.Error("Expected "+expected+"'s turn to time out, got", userName)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("Expected " + expected + "'s turn to time out")
+		}
+	}
+	if err := room.DisableTurns(); err != nil {
+		t.Fatal(err)
+	} else if room.CurrentTurn() != "" {
+		t.Error("Turns should be off after DisableTurns()")
+	}
+}
@@ -1,11 +1,15 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/hewiefreeman/GopherGameServer/database"
 	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // User represents a client who has logged into the service. A User can
@@ -21,15 +25,22 @@ import (
 // Dereferencing them could cause data races (which will panic and stop the server) in the User
 // fields that get locked for synchronizing access.
 type User struct {
+	//nameMux LOCKS name, WHICH ONLY CHANGES WHEN THE ACCOUNT IS RENAMED
+	nameMux    sync.RWMutex
 	name       string
 	databaseID int
 	isGuest    bool
 
 	//mux lock all items below
-	mux     sync.Mutex
-	status  int
-	friends map[string]*database.Friend
-	conns   map[string]*userConn
+	mux           sync.Mutex
+	status        int
+	private       bool // CAN'T BE WATCHED BY OTHER USERS' CLIENTS
+	lastSeen      time.Time
+	ip            string
+	clientVersion string // THE PROTOCOL VERSION OF THE LATEST CONNECTION
+	locale        string // THE LOCALE OF THE LATEST CONNECTION
+	friends       map[string]*database.Friend
+	conns         map[string]*userConn
 }
 
 type userConn struct {
@@ -37,16 +48,46 @@ type userConn struct {
 	clientMux *sync.Mutex
 	user      **User
 
-	socket *websocket.Conn
+	socket *websocket.Conn // nil while held - lock sendMux to use it
 
 	//Must lock user's mux to use below items
-	room *Room
-	vars map[string]interface{}
+	room   *Room
+	vars   map[string]interface{}
+	locale string // the client's locale, or "" for the DefaultLocale
+
+	resumeHash string // SHA-256 of the connection's session resume token, or "" when sessions can't be resumed
+
+	//sendMux locks socket and all items below
+	sendMux   sync.Mutex
+	version   string   // the protocol version of the socket's client
+	held      bool     // true while the socket is gone and the client has ReconnectGracePeriod to come back
+	buffer    [][]byte // messages sent while held
+	dropped   bool     // true when messages didn't fit in the buffer
+	holdTimer *time.Timer
 }
 
+// userShard is one part of the logged in Users. Users are spread over the shards by name, so logging in, looking up and
+// logging out different Users rarely wait on each other.
+type userShard struct {
+	mux      sync.Mutex
+	users    map[string]*User
+	renaming map[string]bool // NAMES HELD FOR Users BEING RENAMED TO THEM
+}
+
+const (
+	userShardCount = 64
+)
+
 var (
-	users    map[string]*User = make(map[string]*User)
-	usersMux sync.Mutex
+	userShards = makeUserShards()
+
+	// THE NUMBER OF Users, GUESTS AND REGISTERED Users IN userShards, READ WITHOUT LOCKING ANY SHARD
+	userCount       int64
+	guestCount      int64
+	registeredCount int64
+
+	// ATOMIC - THE NUMBER IN THE LAST GENERATED GUEST NAME
+	guestCounter uint64
 
 	// LoginCallback is only for internal Gopher Game Server mechanics.
 	LoginCallback func(string, int, map[string]interface{}, map[string]interface{}) bool
@@ -64,16 +105,36 @@ const (
 
 // Error messages
 const (
-	errorDenied         = "Action was denied"
-	errorRequiredName   = "A user name is required"
-	errorRequiredID     = "An ID is required"
-	errorRequiredSocket = "A socket is required"
-	errorNameUnavail    = "Username is unavailable"
-	errorUnexpected     = "Unexpected error"
-	errorAlreadyLogged  = "User is already logged in"
-	errorServerPaused   = "Server is paused"
+	errorDenied          = "Action was denied"
+	errorRequiredName    = "A user name is required"
+	errorRequiredID      = "An ID is required"
+	errorRequiredSocket  = "A socket is required"
+	errorNameUnavail     = "Username is unavailable"
+	errorUnexpected      = "Unexpected error"
+	errorAlreadyLogged   = "User is already logged in"
+	errorLoggedElsewhere = "Logged in elsewhere"
+	errorKicked          = "Kicked"
+	errorServerPaused    = "Server is paused"
+	errorBanned          = "This account is banned"
+	errorTimedOut        = "Login timed out"
+	errorAccountDeleted  = "accountDeleted"
 )
 
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   GUEST NAMES   ///////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// GuestName makes a name for a guest User from a prefix and a counter, like "Guest12", that no logged in User has. Another
+// guest could still log in with it first, so retry with a new name if Login() says it's taken.
+func GuestName(prefix string) string {
+	for {
+		name := prefix + strconv.FormatUint(atomic.AddUint64(&guestCounter, 1), 10)
+		if findUser(name) == nil {
+			return name
+		}
+	}
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   LOG A USER IN   /////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -81,8 +142,31 @@ const (
 // Login logs a User in to the service.
 func Login(userName string, dbID int, autologPass string, isGuest bool, remMe bool, socket *websocket.Conn,
 	connUser **User, clientMux *sync.Mutex) (string, helpers.GopherError) {
+	return LoginCtx(context.Background(), userName, dbID, autologPass, isGuest, remMe, socket, connUser, clientMux)
+}
+
+// LoginCtx is the same as Login, but gives up with an ErrorTimeout if ctx is done before the User is logged in.
+func LoginCtx(ctx context.Context, userName string, dbID int, autologPass string, isGuest bool, remMe bool, socket *websocket.Conn,
+	connUser **User, clientMux *sync.Mutex) (string, helpers.GopherError) {
+	connID, err := login(ctx, userName, dbID, autologPass, isGuest, remMe, socket, connUser, clientMux)
+	recordLogin(userName, socket, isGuest, err)
+	return connID, err
+}
+
+func login(ctx context.Context, userName string, dbID int, autologPass string, isGuest bool, remMe bool, socket *websocket.Conn,
+	connUser **User, clientMux *sync.Mutex) (string, helpers.GopherError) {
+	// Names picked at login follow the NamePolicy - ACCOUNTS' NAMES WERE CHECKED WHEN THEY WERE MADE
+	if len(userName) > 0 && (isGuest || !sqlFeatures) {
+		var nameErr helpers.GopherError
+		if userName, nameErr = helpers.CheckName(userName); nameErr.ID != 0 {
+			return "", nameErr
+		}
+	}
+
 	// Verify input
-	if serverPaused {
+	if ctx.Err() != nil {
+		return "", helpers.NewError(errorTimedOut, helpers.ErrorTimeout)
+	} else if serverPaused {
 		return "", helpers.NewError(errorServerPaused, helpers.ErrorServerPaused)
 	} else if len(userName) == 0 {
 		return "", helpers.NewError(errorRequiredName, helpers.ErrorAuthRequiredName)
@@ -92,6 +176,24 @@ func Login(userName string, dbID int, autologPass string, isGuest bool, remMe bo
 		return "", helpers.NewError(errorRequiredID, helpers.ErrorAuthRequiredID)
 	} else if socket == nil {
 		return "", helpers.NewError(errorRequiredSocket, helpers.ErrorAuthRequiredSocket)
+	} else if ban, banned := GetBan(userName); banned {
+		return "", helpers.NewError(banMessage(ban), helpers.ErrorAuthBanned)
+	}
+
+	// Guests can't take the name of an account
+	if isGuest && sqlFeatures {
+		if exists, existsErr := database.AccountExists(ctx, userName); existsErr != nil {
+			return "", helpers.NewError(errorUnexpected, helpers.ErrorAuthUnexpected)
+		} else if exists {
+			return "", helpers.NewError(errorNameUnavail, helpers.ErrorAuthNameUnavail)
+		}
+		if helpers.UniqueNames() {
+			if lookalike, lookalikeErr := database.NameLookalikeExists(ctx, userName); lookalikeErr != nil {
+				return "", helpers.NewError(errorUnexpected, helpers.ErrorAuthUnexpected)
+			} else if lookalike {
+				return "", helpers.NameConfusableError()
+			}
+		}
 	}
 
 	// Guests always have -1 databaseID
@@ -100,41 +202,64 @@ func Login(userName string, dbID int, autologPass string, isGuest bool, remMe bo
 		databaseID = -1
 	}
 
-	// Callback
-	if LoginCallback != nil && !LoginCallback(userName, dbID, nil, nil) {
+	// Callback - WITH THE SQL FEATURES, THE DATABASE RUNS IT FOR EVERYONE BUT GUESTS
+	if LoginCallback != nil && (!sqlFeatures || isGuest) && !LoginCallback(userName, databaseID, nil, nil) {
 		return "", helpers.NewError(errorDenied, helpers.ErrorActionDenied)
 	}
 
+	// Make a token the connection can resume its session with after a restart
+	var resumeToken string
+	if resumeTokens() {
+		var tokenErr error
+		if resumeToken, tokenErr = helpers.GenerateSecureString(32); tokenErr != nil {
+			return "", helpers.NewError(errorUnexpected, helpers.ErrorAuthUnexpected)
+		}
+	}
+
+	// The callback could have taken a while
+	if ctx.Err() != nil {
+		return "", helpers.NewError(errorTimedOut, helpers.ErrorTimeout)
+	}
+
+	// The name could be logged in on another node of the cluster
+	if !multiConnect && len(remoteUserNodes(userName)) > 0 {
+		if !kickOnLogin {
+			return "", helpers.NewError(errorAlreadyLogged, helpers.ErrorAuthAlreadyLogged)
+		}
+		clusterKickElsewhere(userName)
+	}
+
 	// Make *User in users & make connID
 	var connID string
 	var connErr error
 	var userExists bool = false
 	//
-	usersMux.Lock()
+	shard := shardFor(userName)
+	shard.mux.Lock()
+	if shard.renaming[userName] {
+		shard.mux.Unlock()
+		return "", helpers.NewError(errorNameUnavail, helpers.ErrorAuthNameUnavail)
+	}
 	//
-	if userOnline, ok := users[userName]; ok {
+	var kickedUser *User
+	var kickedConns map[string]*userConn
+	if userOnline, ok := shard.users[userName]; ok {
 		userExists = true
-		if kickOnLogin {
-			// Kick user & remove from room
+		if kickOnLogin && !multiConnect {
+			// Take the User's conns - THEY LEAVE THEIR ROOMS AFTER THE SHARD IS UNLOCKED, SO CALLBACKS CAN FIND USERS
 			userOnline.mux.Lock()
-			for connKey, conn := range userOnline.conns {
-				userRoom := (*conn).room
-				if userRoom != nil && userRoom.Name() != "" {
-					userOnline.mux.Unlock()
-					userRoom.RemoveUser(userOnline, connKey)
-					userOnline.mux.Lock()
-				}
+			kickedConns = userOnline.conns
+			userOnline.conns = make(map[string]*userConn)
+			userOnline.mux.Unlock()
+			for _, conn := range kickedConns {
 				(*(*conn).clientMux).Lock()
 				*((*conn).user) = nil
 				(*(*conn).clientMux).Unlock()
-				// Send logout message to client
-				clientResp := helpers.MakeClientResponse(helpers.ClientActionLogout, nil, helpers.NoError())
-				(*conn).socket.WriteJSON(clientResp)
 			}
-			userOnline.mux.Unlock()
 
 			// Remove user from users map
-			delete(users, userName)
+			shard.remove(userOnline)
+			kickedUser = userOnline
 
 			// Make connID
 			connID = "1"
@@ -144,7 +269,7 @@ func Login(userName string, dbID int, autologPass string, isGuest bool, remMe bo
 			for {
 				connID, connErr = helpers.GenerateSecureString(5)
 				if connErr != nil {
-					usersMux.Unlock()
+					shard.mux.Unlock()
 					return "", helpers.NewError(errorUnexpected, helpers.ErrorAuthUnexpected)
 				}
 				userOnline.mux.Lock()
@@ -155,66 +280,114 @@ func Login(userName string, dbID int, autologPass string, isGuest bool, remMe bo
 				userOnline.mux.Unlock()
 			}
 		} else {
-			usersMux.Unlock()
+			shard.mux.Unlock()
 			return "", helpers.NewError(errorAlreadyLogged, helpers.ErrorAuthAlreadyLogged)
 		}
 	} else if multiConnect {
 		// Make connID
 		connID, connErr = helpers.GenerateSecureString(5)
 		if connErr != nil {
-			usersMux.Unlock()
+			shard.mux.Unlock()
 			return "", helpers.NewError(errorUnexpected, helpers.ErrorAuthUnexpected)
 		}
 	} else {
 		// Make connID
 		connID = "1"
 	}
-	// Make the userConn
-	vars := make(map[string]interface{})
-	conn := userConn{socket: socket, room: nil, vars: vars, user: connUser, clientMux: clientMux}
-	// Make friends objects
-	var u *User
-	var friends []map[string]interface{}
-	var friendsMap map[string]*database.Friend
+	// Make the userConn - ITS VARIABLES START WITH THE METADATA FROM THE CLIENT CONNECT CALLBACK
+	info := socketConnInfo(socket)
+	vars := make(map[string]interface{}, len(info.metadata))
+	for key, val := range info.metadata {
+		vars[key] = val
+	}
+	conn := userConn{socket: socket, room: nil, vars: vars, user: connUser, clientMux: clientMux, version: info.version,
+		locale: info.locale}
+	if resumeToken != "" {
+		conn.resumeHash = hashResumeToken(resumeToken)
+	}
 	// Add the userConn to the User or make new User
+	var u *User
 	if userExists {
-		(*users[userName]).mux.Lock()
-		(*users[userName]).conns[connID] = &conn
-		friendsMap = (*users[userName]).friends
-		(*users[userName]).mux.Unlock()
-		// Make friends list for response
-		friends = makeFriendsResponse(friendsMap)
+		u = shard.users[userName]
+		u.mux.Lock()
+		u.conns[connID] = &conn
+		u.ip = info.ip
+		u.clientVersion = info.version
+		u.locale = info.locale
+		u.mux.Unlock()
 	} else {
+		// Nobody else online can have a name that looks the same - ACCOUNTS KEEP THE NAMES THEY WERE MADE WITH
+		if !claimSkeleton(userName, !isGuest && sqlFeatures) {
+			shard.mux.Unlock()
+			return "", helpers.NameConfusableError()
+		}
 		// Get friend list from database
+		var friendsMap map[string]*database.Friend
 		if dbID != -1 && sqlFeatures {
 			var friendsErr error
-			if friendsMap, friendsErr = database.GetFriends(dbID); friendsErr == nil {
-				// Make friends list for response
-				friends = makeFriendsResponse(friendsMap)
+			if friendsMap, friendsErr = database.GetFriendsCtx(ctx, dbID); friendsErr != nil {
+				friendsMap = nil
 			}
+		} else if !sqlFeatures {
+			friendsMap = storedFriends(userName)
+		}
+		if friendsMap == nil {
+			friendsMap = make(map[string]*database.Friend)
 		}
 		conns := map[string]*userConn{
 			connID: &conn,
 		}
 		newUser := User{name: userName, databaseID: databaseID, isGuest: isGuest, status: 0,
-			friends: friendsMap, conns: conns}
+			lastSeen: time.Now(), ip: info.ip, clientVersion: info.version, locale: info.locale, friends: friendsMap, conns: conns}
 		u = &newUser
-		users[userName] = u
+		shard.add(u)
 	}
 	(*conn.clientMux).Lock()
-	*(conn.user) = users[userName]
+	*(conn.user) = u
 	(*conn.clientMux).Unlock()
+	// Copy the friends for the response - THEIR STATUSES ARE LOOKED UP AFTER UNLOCKING THE SHARD
+	u.mux.Lock()
+	friendsMap := make(map[string]*database.Friend, len(u.friends))
+	for friendName, friend := range u.friends {
+		friendsMap[friendName] = friend
+	}
+	u.mux.Unlock()
 	//
-	usersMux.Unlock()
+	shard.mux.Unlock()
+
+	// Make friends list for response
+	friends := makeFriendsResponse(friendsMap)
+
+	// Kick the old User's conns from their rooms, tell their clients they were logged in elsewhere & close their sockets
+	for connKey, conn := range kickedConns {
+		kickedUser.mux.Lock()
+		userRoom := (*conn).room
+		kickedUser.mux.Unlock()
+		if userRoom != nil && userRoom.Name() != "" {
+			userRoom.removeUser(kickedUser, connKey, LeaveReasonKick)
+		}
+		(*conn).kicked(errorLoggedElsewhere, errorLoggedElsewhere)
+		unwatchAll(conn)
+	}
+
+	// Run logout callback for the kicked User
+	if kickedUser != nil && LogoutCallback != nil {
+		LogoutCallback(kickedUser.Name(), kickedUser.DatabaseID())
+	}
 
 	// Send online message to friends
-	statusMessage := map[string]map[string]interface{}{
-		helpers.ServerActionFriendStatusChange: {
-			"n": userName,
-			"s": 0,
-		},
+	if !userExists {
+		statusMessage := map[string]map[string]interface{}{
+			helpers.ServerActionFriendStatusChange: {
+				"n": userName,
+				"s": 0,
+			},
+		}
+		u.sendToFriends(statusMessage)
+		notifyPresence(PresenceLogin, userName, StatusAvailable, nil)
 	}
-	u.sendToFriends(statusMessage)
+
+	helpers.Log().Debug("User logged in", "user", userName, "id", dbID, "guest", isGuest, "conn", connID)
 
 	// Login success, send response to client
 	var responseVal map[string]interface{}
@@ -231,8 +404,11 @@ func Login(userName string, dbID int, autologPass string, isGuest bool, remMe bo
 			"f": friends,
 		}
 	}
+	if resumeToken != "" {
+		responseVal["rt"] = resumeToken
+	}
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionLogin, responseVal, helpers.NoError())
-	socket.WriteJSON(clientResp)
+	helpers.WriteMessage(socket, clientResp)
 
 	//
 	return connID, helpers.NoError()
@@ -249,7 +425,7 @@ func makeFriendsResponse(friendsMap map[string]*database.Friend) []map[string]in
 		}
 		if frs == database.FriendStatusAccepted {
 			// Get the friend's status
-			if friend, ok := users[val.Name()]; ok {
+			if friend := findUser(val.Name()); friend != nil {
 				friendEntry["s"] = friend.Status()
 			} else {
 				friendEntry["s"] = StatusOffline
@@ -285,6 +461,8 @@ func AutoLogIn(tag string, pass string, newPass string, dbID int, conn *websocke
 	if userErr.ID != 0 {
 		return "", userErr
 	}
+	// Put them back where they were before a restart
+	resumeSessionByName(userName, connID)
 
 	return connID, helpers.NoError()
 }
@@ -312,7 +490,7 @@ func (u *User) Logout(connID string) {
 	currRoom := (*u.conns[connID]).room
 	if currRoom != nil && currRoom.Name() != "" {
 		u.mux.Unlock()
-		currRoom.RemoveUser(u, connID)
+		currRoom.removeUser(u, connID, LeaveReasonLogout)
 		u.mux.Lock()
 	}
 
@@ -320,7 +498,7 @@ func (u *User) Logout(connID string) {
 		// Send status change to friends
 		statusMessage := map[string]map[string]interface{}{
 			helpers.ServerActionFriendStatusChange: {
-				"n": u.name,
+				"n": u.Name(),
 				"s": StatusOffline,
 			},
 		}
@@ -332,52 +510,76 @@ func (u *User) Logout(connID string) {
 		*((*u.conns[connID]).user) = nil
 	}
 	(*u.conns[connID]).clientMux.Unlock()
-	socket := (*u.conns[connID]).socket
+	conn := u.conns[connID]
 	delete(u.conns, connID)
-	if len(u.conns) == 0 {
+	unwatchAll(conn)
+	lastConn := len(u.conns) == 0
+	if lastConn {
 		// Delete user if there are no more conns
+		u.status = StatusOffline
 		u.mux.Unlock()
-		usersMux.Lock()
-		delete(users, u.name)
-		usersMux.Unlock()
+		removeUser(u)
 	} else {
 		u.mux.Unlock()
 	}
 
+	helpers.Log().Debug("User logged out", "user", u.Name(), "conn", connID)
+
 	// Send response
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionLogout, nil, helpers.NoError())
-	socket.WriteJSON(clientResp)
+	conn.send(clientResp)
 
-	// Run callback
-	if LogoutCallback != nil {
-		LogoutCallback(u.Name(), u.DatabaseID())
+	// Run callback once the User's last connection logs out
+	if lastConn {
+		notifyPresence(PresenceLogout, u.Name(), StatusOffline, nil)
+		if LogoutCallback != nil {
+			LogoutCallback(u.Name(), u.DatabaseID())
+		}
 	}
 }
 
-// Kick will log off all connections on this User.
+// Kick will log off all connections on this User. Each of the User's clients gets a ServerActionKicked message, then its
+// socket is closed.
 func (u *User) Kick() {
+	u.kick("", errorKicked)
+}
+
+// KickWithReason is the same as Kick, but the clients get the reason with their ServerActionKicked message.
+func (u *User) KickWithReason(reason string) {
+	u.kick(reason, errorKicked)
+}
+
+// kick logs off all connections on the User, tells their clients why, and closes their sockets with closeText.
+func (u *User) kick(reason string, closeText string) {
 	u.mux.Lock()
+	if len(u.conns) == 0 {
+		// ALREADY KICKED OR LOGGED OUT
+		u.mux.Unlock()
+		return
+	}
+	helpers.Log().Debug("User kicked", "user", u.Name(), "reason", reason)
 
 	// Send status change message to friends
 	statusMessage := map[string]map[string]interface{}{
 		helpers.ServerActionFriendStatusChange: {
-			"n": u.name,
+			"n": u.Name(),
 			"s": StatusOffline,
 		},
 	}
 	u.sendToFriends(statusMessage)
 
-	// Make response
-	clientResp := helpers.MakeClientResponse(helpers.ClientActionLogout, nil, helpers.NoError())
-
 	// Go through all connections
-	for connID, conn := range u.conns {
+	kickedConns := u.conns
+	u.conns = make(map[string]*userConn)
+	u.status = StatusOffline
+	u.mux.Unlock()
+	for connID, conn := range kickedConns {
 		// Remove from room
+		u.mux.Lock()
 		currRoom := (*conn).room
+		u.mux.Unlock()
 		if currRoom != nil && currRoom.Name() != "" {
-			u.mux.Unlock()
-			currRoom.RemoveUser(u, connID)
-			u.mux.Lock()
+			currRoom.removeUser(u, connID, LeaveReasonKick)
 		}
 
 		// Log connection out
@@ -387,16 +589,14 @@ func (u *User) Kick() {
 		}
 		(*conn).clientMux.Unlock()
 
-		// Send response
-		(*conn).socket.WriteJSON(clientResp)
+		// Tell the client & close their socket
+		(*conn).kicked(reason, closeText)
+		unwatchAll(conn)
 	}
 
-	u.mux.Unlock()
-
 	// Remove from users
-	usersMux.Lock()
-	delete(users, u.name)
-	usersMux.Unlock()
+	removeUser(u)
+	notifyPresence(PresenceLogout, u.Name(), StatusOffline, nil)
 
 	// Run callback
 	if LogoutCallback != nil {
@@ -404,6 +604,78 @@ func (u *User) Kick() {
 	}
 }
 
+// AccountDeleted is only for internal Gopher Game Server mechanics. Logs out every connection of a deleted account's User,
+// whose clients get "accountDeleted" as the reason and close message, and forgets the ban on the account's name.
+func AccountDeleted(userName string) {
+	forgetBan(userName)
+	if user := findUser(userName); user != nil {
+		user.kick(errorAccountDeleted, errorAccountDeleted)
+	}
+}
+
+func makeUserShards() []*userShard {
+	shards := make([]*userShard, userShardCount)
+	for i := range shards {
+		shards[i] = &userShard{users: make(map[string]*User)}
+	}
+	return shards
+}
+
+// shardFor gets the shard a User name belongs in, by the name's FNV-1a hash.
+func shardFor(userName string) *userShard {
+	var hash uint32 = 2166136261
+	for i := 0; i < len(userName); i++ {
+		hash ^= uint32(userName[i])
+		hash *= 16777619
+	}
+	return userShards[hash%uint32(len(userShards))]
+}
+
+// findUser gets a logged in User by name, or nil.
+func findUser(userName string) *User {
+	shard := shardFor(userName)
+	shard.mux.Lock()
+	user := shard.users[userName]
+	shard.mux.Unlock()
+	return user
+}
+
+// removeUser removes a User from their shard, unless another User with the same name has taken their place.
+func removeUser(u *User) {
+	shard := shardFor(u.Name())
+	shard.mux.Lock()
+	shard.remove(u)
+	shard.mux.Unlock()
+}
+
+// add adds a User to the shard. s.mux must be locked.
+func (s *userShard) add(u *User) {
+	s.users[u.Name()] = u
+	clusterUserOnline(u.Name())
+	atomic.AddInt64(&userCount, 1)
+	if u.isGuest {
+		atomic.AddInt64(&guestCount, 1)
+	} else {
+		atomic.AddInt64(&registeredCount, 1)
+	}
+}
+
+// remove removes a User from the shard, unless another User with the same name has taken their place. s.mux must be locked.
+func (s *userShard) remove(u *User) {
+	if s.users[u.Name()] != u {
+		return
+	}
+	delete(s.users, u.Name())
+	releaseSkeleton(u.Name())
+	clusterUserOffline(u.Name())
+	atomic.AddInt64(&userCount, -1)
+	if u.isGuest {
+		atomic.AddInt64(&guestCount, -1)
+	} else {
+		atomic.AddInt64(&registeredCount, -1)
+	}
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   GET A USER   ////////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -414,67 +686,124 @@ func GetUser(userName string) (*User, error) {
 	if len(userName) == 0 {
 		return &User{}, errors.New("users.Get() requires a user name")
 	} else if serverPaused {
-		return &User{}, errors.New(errorServerPaused)
+		return &User{}, helpers.NewError(errorServerPaused, helpers.ErrorServerPaused)
 	}
 
-	var user *User
-	var ok bool
-
-	usersMux.Lock()
-	if user, ok = users[userName]; !ok {
-		usersMux.Unlock()
-		return &User{}, errors.New("User '" + userName + "' is not logged in")
+	user := findUser(userName)
+	if user == nil {
+		return &User{}, helpers.NewError("User '"+userName+"' is not logged in", helpers.ErrorUserNotFound)
 	}
-	usersMux.Unlock()
 
 	//
 	return user, nil
 }
 
+// GetUsers returns all the Users logged into the server. The slice is a snapshot, so Users that log out after it is taken
+// will still be in it. Use *User.IsOnline() to check if a User from the snapshot is still logged in. The shards are locked one
+// at a time, so taking the snapshot never stops Users from logging in or out.
+func GetUsers() []*User {
+	userList := make([]*User, 0, UserCount())
+	for _, shard := range userShards {
+		shard.mux.Lock()
+		for _, user := range shard.users {
+			userList = append(userList, user)
+		}
+		shard.mux.Unlock()
+	}
+
+	//
+	return userList
+}
+
+// GetUsersByStatus returns all the Users logged into the server with the given status, like StatusAvailable. Like
+// GetUsers(), the slice is a snapshot.
+func GetUsersByStatus(status int) []*User {
+	userList := []*User{}
+	for _, user := range GetUsers() {
+		if user.Status() == status {
+			userList = append(userList, user)
+		}
+	}
+
+	//
+	return userList
+}
+
+// ForEachUser runs the callback function on every User logged into the server, and stops when the callback returns false.
+// The callback is ran on a snapshot of the Users, so it's safe to call any *User methods or log Users in and out from it.
+func ForEachUser(cb func(*User) bool) {
+	for _, user := range GetUsers() {
+		if !cb(user) {
+			return
+		}
+	}
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   MAKE A USER JOIN/LEAVE A ROOM   /////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// Join makes a User join a Room. If you are using MultiConnect in ServerSettings, the connID
-// parameter is the connection ID associated with one of the connections attached to that User. This must
-// be provided when making a User join a Room with MultiConnect enabled. Otherwise, an empty string can be used.
+// Join makes a User join a Room. Joining the Room the connection is already in does nothing. If you are using MultiConnect in
+// ServerSettings, the connID parameter is the connection ID associated with one of the connections attached to that User. This
+// must be provided when making a User join a Room with MultiConnect enabled. Otherwise, an empty string can be used.
 func (u *User) Join(r *Room, connID string) error {
+	return u.JoinCtx(context.Background(), r, connID)
+}
+
+// JoinCtx is the same as Join, but returns ErrTimeout if ctx is done before the User is added to the Room.
+func (u *User) JoinCtx(ctx context.Context, r *Room, connID string) error {
 	if multiConnect && len(connID) == 0 {
 		return errors.New("Must provide a connID when MultiConnect is enabled")
 	} else if !multiConnect {
 		connID = "1"
 	}
-	u.mux.Lock()
-	if _, ok := u.conns[connID]; !ok {
-		u.mux.Unlock()
-		return errors.New("Invalid connID")
-	}
-	currRoom := (*u.conns[connID]).room
-	if currRoom != nil && currRoom.Name() == r.Name() {
-		u.mux.Unlock()
-		return errors.New("User '" + u.name + "' is already in room '" + r.Name() + "'")
-	} else if currRoom != nil && currRoom.Name() != "" {
-		// Leave current room
-		u.mux.Unlock()
-		u.Leave(connID)
+	for {
+		if ctx.Err() != nil {
+			return ErrTimeout
+		}
 		u.mux.Lock()
-	}
-	u.mux.Unlock()
+		if _, ok := u.conns[connID]; !ok {
+			u.mux.Unlock()
+			return errors.New("Invalid connID")
+		}
+		currRoom := (*u.conns[connID]).room
+		u.mux.Unlock()
+		if currRoom == r {
+			return nil
+		} else if currRoom != nil {
+			// Don't leave the current room for one that's full
+			if r.IsFull() {
+				return ErrRoomFull
+			}
+			// Leave current room. It only fails for good when the connection is still in it
+			if leaveErr := u.leave(connID, LeaveReasonVoluntary); leaveErr != nil && u.RoomIn(connID) == currRoom {
+				return leaveErr
+			}
+		}
 
-	// Add user to room
-	addErr := r.AddUser(u, connID)
-	if addErr != nil {
-		return addErr
+		// Add user to room. Another Join on the same connection can put it in a room first, so leave that one and try again
+		if ctx.Err() != nil {
+			return ErrTimeout
+		}
+		if addErr := r.AddUser(u, connID); addErr != ErrInOtherRoom {
+			return addErr
+		}
 	}
-
-	//
-	return nil
 }
 
 // Leave makes a User leave their current room. If you are using MultiConnect in ServerSettings, the connID
 // parameter is the connection ID associated with one of the connections attached to that User. This must
 // be provided when making a User leave a Room with MultiConnect enabled. Otherwise, an empty string can be used.
 func (u *User) Leave(connID string) error {
+	return u.leave(connID, LeaveReasonVoluntary)
+}
+
+// LeaveOnDisconnect is only for internal Gopher Game Server mechanics.
+func (u *User) LeaveOnDisconnect(connID string) error {
+	return u.leave(connID, LeaveReasonLogout)
+}
+
+func (u *User) leave(connID string, reason int) error {
 	if multiConnect && len(connID) == 0 {
 		return errors.New("Must provide a connID when MultiConnect is enabled")
 	} else if !multiConnect {
@@ -489,12 +818,12 @@ func (u *User) Leave(connID string) error {
 	currRoom := (*u.conns[connID]).room
 	u.mux.Unlock()
 	if currRoom != nil && currRoom.Name() != "" {
-		removeErr := currRoom.RemoveUser(u, connID)
+		removeErr := currRoom.removeUser(u, connID, reason)
 		if removeErr != nil {
 			return removeErr
 		}
 	} else {
-		return errors.New("User '" + u.name + "' is not in a room.")
+		return helpers.NewError("User '"+u.Name()+"' is not in a room.", helpers.ErrorNotInRoom)
 	}
 
 	return nil
@@ -504,21 +833,60 @@ func (u *User) Leave(connID string) error {
 //   SET THE STATUS OF A USER   //////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// SetStatus sets the status of a User. Also sends a notification to all the User's Friends (with the request
-// status "accepted") that they changed their status.
-func (u *User) SetStatus(status int) {
+// SetStatus sets the status of a User. The status must be one of StatusAvailable, StatusInGame, StatusIdle, or StatusOffline.
+// Also sends a notification to all the User's Friends (with the request status "accepted"), and everyone in the
+// Rooms the User's connections are in, that they changed their status.
+func (u *User) SetStatus(status int) error {
+	if status < StatusAvailable || status > StatusOffline {
+		return errors.New("Invalid status")
+	}
 	u.mux.Lock()
+	if u.conns == nil || len(u.conns) == 0 {
+		u.mux.Unlock()
+		return helpers.NewError("User '"+u.Name()+"' is not logged in", helpers.ErrorUserNotFound)
+	}
 	u.status = status
+	inRooms := make(map[*Room]bool)
+	for _, conn := range u.conns {
+		if (*conn).room != nil {
+			inRooms[(*conn).room] = true
+		}
+	}
 	u.mux.Unlock()
 
 	// Send status to friends
 	message := map[string]map[string]interface{}{
 		helpers.ServerActionFriendStatusChange: {
-			"n": u.name,
+			"n": u.Name(),
 			"s": status,
 		},
 	}
 	u.sendToFriends(message)
+	notifyPresence(PresenceStatus, u.Name(), status, nil)
+
+	// Send status to rooms
+	roomMessage := helpers.NewEncoded(map[string]map[string]interface{}{
+		helpers.ServerActionUserStatusChange: {
+			"u": u.Name(),
+			"s": status,
+		},
+	})
+	for room := range inRooms {
+		userMap, err := room.GetUserMap()
+		if err != nil {
+			continue
+		}
+		for _, ru := range userMap {
+			ru.mux.Lock()
+			for _, conn := range ru.conns {
+				conn.send(roomMessage)
+			}
+			ru.mux.Unlock()
+		}
+	}
+
+	//
+	return nil
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -529,6 +897,7 @@ func (u *User) SetStatus(status int) {
 // and the Room must be private and owned by the inviting User. If you are using MultiConnect in ServerSettings, the connID
 // parameter is the connection ID associated with one of the connections attached to the inviting User. This must
 // be provided when making a User invite another with MultiConnect enabled. Otherwise, an empty string can be used.
+// Inviting a User who is already invited does nothing.
 func (u *User) Invite(invUser *User, connID string) error {
 	if multiConnect && len(connID) == 0 {
 		return errors.New("Must provide a connID when MultiConnect is enabled")
@@ -543,27 +912,28 @@ func (u *User) Invite(invUser *User, connID string) error {
 	}
 	currRoom := (*u.conns[connID]).room
 	u.mux.Unlock()
-	rType := GetRoomTypes()[currRoom.Type()]
 	if currRoom == nil || currRoom.Name() == "" {
-		return errors.New("The user '" + u.name + "' is not in a room")
+		return helpers.NewError("The user '"+u.Name()+"' is not in a room", helpers.ErrorNotInRoom)
 	} else if !currRoom.IsPrivate() {
 		return errors.New("The room '" + currRoom.Name() + "' is not private")
-	} else if currRoom.Owner() != u.name {
-		return errors.New("The user '" + u.name + "' is not the owner of the room '" + currRoom.Name() + "'")
-	} else if rType.ServerOnly() {
-		return errors.New("Only the server can manipulate that type of room")
+	} else if currRoom.Owner() != u.Name() {
+		return helpers.NewError("The user '"+u.Name()+"' is not the owner of the room '"+currRoom.Name()+"'", helpers.ErrorGopherNotOwner)
+	} else if GetRoomTypes()[currRoom.Type()].ServerOnly() {
+		return helpers.NewError("Only the server can manipulate that type of room", helpers.ErrorGopherServerRoom)
 	}
 
-	// Add to invite list
-	addErr := currRoom.AddInvite(invUser.name)
+	// Add to invite list. Users that are already invited don't get notified again
+	added, addErr := currRoom.addInvite(invUser.Name())
 	if addErr != nil {
 		return addErr
+	} else if !added {
+		return nil
 	}
 
 	// Make response message
 	invMessage := map[string]map[string]interface{}{
 		helpers.ServerActionRoomInvite: {
-			"u": u.name,
+			"u": u.Name(),
 			"r": currRoom.Name(),
 		},
 	}
@@ -571,7 +941,7 @@ func (u *User) Invite(invUser *User, connID string) error {
 	// Send response to all connections
 	invUser.mux.Lock()
 	for _, conn := range invUser.conns {
-		(*conn).socket.WriteJSON(invMessage)
+		(*conn).send(invMessage)
 	}
 	invUser.mux.Unlock()
 
@@ -587,7 +957,9 @@ func (u *User) Invite(invUser *User, connID string) error {
 // is the owner of the Room. If you are using MultiConnect in ServerSettings, the connID
 // parameter is the connection ID associated with one of the connections attached to the inviting User. This must
 // be provided when making a User revoke an invite with MultiConnect enabled. Otherwise, an empty string can be used.
-func (u *User) RevokeInvite(revokeUser string, connID string) error {
+//
+// When kick is true and the revoked User is in the Room, they will also be removed from the Room.
+func (u *User) RevokeInvite(revokeUser string, kick bool, connID string) error {
 	if multiConnect && len(connID) == 0 {
 		return errors.New("Must provide a connID when MultiConnect is enabled")
 	} else if !multiConnect {
@@ -601,19 +973,18 @@ func (u *User) RevokeInvite(revokeUser string, connID string) error {
 	}
 	currRoom := (*u.conns[connID]).room
 	u.mux.Unlock()
-	rType := GetRoomTypes()[currRoom.Type()]
 	if currRoom == nil || currRoom.Name() == "" {
-		return errors.New("The user '" + u.name + "' is not in a room")
+		return helpers.NewError("The user '"+u.Name()+"' is not in a room", helpers.ErrorNotInRoom)
 	} else if !currRoom.IsPrivate() {
 		return errors.New("The room '" + currRoom.Name() + "' is not private")
-	} else if currRoom.Owner() != u.name {
-		return errors.New("The user '" + u.name + "' is not the owner of the room '" + currRoom.Name() + "'")
-	} else if rType.ServerOnly() {
-		return errors.New("Only the server can manipulate that type of room")
+	} else if currRoom.Owner() != u.Name() {
+		return helpers.NewError("The user '"+u.Name()+"' is not the owner of the room '"+currRoom.Name()+"'", helpers.ErrorGopherNotOwner)
+	} else if GetRoomTypes()[currRoom.Type()].ServerOnly() {
+		return helpers.NewError("Only the server can manipulate that type of room", helpers.ErrorGopherServerRoom)
 	}
 
 	// Remove from invite list
-	removeErr := currRoom.RemoveInvite(revokeUser)
+	removeErr := currRoom.RemoveInvite(revokeUser, kick)
 	if removeErr != nil {
 		return removeErr
 	}
@@ -628,14 +999,24 @@ func (u *User) RevokeInvite(revokeUser string, connID string) error {
 
 // UserCount returns the number of Users logged into the server.
 func UserCount() int {
-	usersMux.Lock()
-	length := len(users)
-	usersMux.Unlock()
-	return length
+	return int(atomic.LoadInt64(&userCount))
+}
+
+// GuestCount returns the number of Users logged into the server as guests.
+func GuestCount() int {
+	return int(atomic.LoadInt64(&guestCount))
+}
+
+// RegisteredCount returns the number of Users logged into the server that aren't guests. A User connected more than once with
+// MultiConnect counts once.
+func RegisteredCount() int {
+	return int(atomic.LoadInt64(&registeredCount))
 }
 
 // Name gets the name of the User.
 func (u *User) Name() string {
+	u.nameMux.RLock()
+	defer u.nameMux.RUnlock()
 	return u.name
 }
 
@@ -644,19 +1025,8 @@ func (u *User) DatabaseID() int {
 	return u.databaseID
 }
 
-// Friends gets the Friend list of the User as a map[string]database.Friend where the key string is the friend's
-// User name.
-func (u *User) Friends() map[string]database.Friend {
-	u.mux.Lock()
-	friends := make(map[string]database.Friend)
-	for key, val := range u.friends {
-		friends[key] = *val
-	}
-	u.mux.Unlock()
-	return friends
-}
-
-// RoomIn gets the Room that the User is currently in. A nil Room pointer means the User is not in a Room. If you are using MultiConnect in ServerSettings, the connID
+// RoomIn gets the Room that the User is currently in. A nil Room pointer means the User is not in a Room, or the connection
+// has logged out. If you are using MultiConnect in ServerSettings, the connID
 // parameter is the connection ID associated with one of the connections attached to that User. This must
 // be provided when getting a User's Room with MultiConnect enabled. Otherwise, an empty string can be used.
 func (u *User) RoomIn(connID string) *Room {
@@ -666,7 +1036,12 @@ func (u *User) RoomIn(connID string) *Room {
 		connID = "1"
 	}
 	u.mux.Lock()
-	room := (*u.conns[connID]).room
+	conn, ok := u.conns[connID]
+	if !ok {
+		u.mux.Unlock()
+		return nil
+	}
+	room := (*conn).room
 	u.mux.Unlock()
 	//
 	return room
@@ -680,7 +1055,22 @@ func (u *User) Status() int {
 	return status
 }
 
-// Socket gets the WebSocket connection of a User. If you are using MultiConnect in ServerSettings, the connID
+// LastSeen gets the last time the server received a message from any of the User's connections.
+func (u *User) LastSeen() time.Time {
+	u.mux.Lock()
+	lastSeen := u.lastSeen
+	u.mux.Unlock()
+	return lastSeen
+}
+
+// UpdateLastSeen is only for internal Gopher Game Server mechanics.
+func (u *User) UpdateLastSeen() {
+	u.mux.Lock()
+	u.lastSeen = time.Now()
+	u.mux.Unlock()
+}
+
+// Socket gets the WebSocket connection of a User, or nil while the connection is waiting for the client to reconnect. If you are using MultiConnect in ServerSettings, the connID
 // parameter is the connection ID associated with one of the connections attached to that User. This must
 // be provided when getting a User's socket connection with MultiConnect enabled. Otherwise, an empty string can be used.
 func (u *User) Socket(connID string) *websocket.Conn {
@@ -690,12 +1080,25 @@ func (u *User) Socket(connID string) *websocket.Conn {
 		connID = "1"
 	}
 	u.mux.Lock()
-	socket := (*u.conns[connID]).socket
+	conn, ok := u.conns[connID]
+	if !ok {
+		u.mux.Unlock()
+		return nil
+	}
+	socket := (*conn).liveSocket()
 	u.mux.Unlock()
 	//
 	return socket
 }
 
+// IsOnline returns true if the User is still logged in with at least one connection.
+func (u *User) IsOnline() bool {
+	u.mux.Lock()
+	online := len(u.conns) > 0
+	u.mux.Unlock()
+	return online
+}
+
 // IsGuest returns true if the User is a guest.
 func (u *User) IsGuest() bool {
 	return u.isGuest
@@ -710,4 +1113,36 @@ func (u *User) ConnectionIDs() []string {
 	}
 	u.mux.Unlock()
 	return ids
+}
+
+// ConnectionCount returns the number of connections the User is logged in with. It's never more than 1 unless
+// MultiConnect in ServerSettings is enabled.
+func (u *User) ConnectionCount() int {
+	u.mux.Lock()
+	count := len(u.conns)
+	u.mux.Unlock()
+	return count
+}
+
+// SendToConnection sends a message to only one of the User's connections, as the JSON encoding of message. If you
+// are using MultiConnect in ServerSettings, the connID parameter is the connection ID to send it to. Otherwise, an
+// empty string can be used.
+func (u *User) SendToConnection(connID string, message interface{}) error {
+	if message == nil {
+		return errors.New("*User.SendToConnection() requires a message")
+	} else if multiConnect && len(connID) == 0 {
+		return errors.New("Must provide a connID when MultiConnect is enabled")
+	} else if !multiConnect {
+		connID = "1"
+	}
+	u.mux.Lock()
+	conn, ok := u.conns[connID]
+	if !ok {
+		u.mux.Unlock()
+		return errors.New("Invalid connID")
+	}
+	(*conn).send(message)
+	u.mux.Unlock()
+	//
+	return nil
 }
\ No newline at end of file
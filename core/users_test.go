@@ -0,0 +1,736 @@
+package core
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testRoomType = NewRoomType("test", false)
+
+// testSocket opens a WebSocket connection to a test server, and returns the server's side of it. Everything
+// the server writes to the socket is read and thrown away by the client.
+func testSocket(t testing.TB) *websocket.Conn {
+	server, client := testSocketPair(t)
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return server
+}
+
+// testSocketPair opens a WebSocket connection to a test server, and returns the server's and the client's side of it.
+func testSocketPair(t testing.TB) (*websocket.Conn, *websocket.Conn) {
+	socketChan := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, w.Header(), 1024, 1024)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		socketChan <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return <-socketChan, client
+}
+
+// testLogin logs in a guest User with a new test socket, and returns the User and its connection ID.
+func testLogin(t *testing.T, name string) (*User, string) {
+	var user *User
+	var clientMux sync.Mutex
+	connID, err := Login(name, -1, "", true, false, testSocket(t), &user, &clientMux)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	return user, connID
+}
+
+func TestUserHandlesShareState(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("shareState", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	user, _ := testLogin(t, "shareState")
+	defer user.Kick()
+
+	// Both goroutines get their own handle to the same User
+	joined := make(chan bool)
+	left := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		u, err := GetUser("shareState")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := u.Join(room, ""); err != nil {
+			t.Error(err)
+		}
+		joined <- true
+		<-left
+		if u.RoomIn("") != nil {
+			t.Error("Leave made through another handle was not visible")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		u, err := GetUser("shareState")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		<-joined
+		if u.RoomIn("") != room {
+			t.Error("Join made through another handle was not visible")
+		}
+		if err := u.Leave(""); err != nil {
+			t.Error(err)
+		}
+		left <- true
+	}()
+	wg.Wait()
+
+	if u, _ := GetUser("shareState"); u != user {
+		t.Error("GetUser() should return the same *User that Login() made")
+	}
+}
+
+func TestInterleavedJoinLeave(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	roomA, _ := NewRoom("interleaveA", "test", false, 0, "")
+	defer roomA.Delete()
+	roomB, _ := NewRoom("interleaveB", "test", false, 0, "")
+	defer roomB.Delete()
+	user, _ := testLogin(t, "interleave")
+	defer user.Kick()
+
+	// Two handles to the same User race to join, switch and leave Rooms
+	var wg sync.WaitGroup
+	for _, rooms := range [][]*Room{{roomA, roomB}, {roomB, roomA}} {
+		wg.Add(1)
+		go func(rooms []*Room) {
+			defer wg.Done()
+			u, err := GetUser("interleave")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for i := 0; i < 200; i++ {
+				u.Join(rooms[i%2], "")
+				if i%3 == 0 {
+					u.Leave("")
+				}
+			}
+		}(rooms)
+	}
+	wg.Wait()
+
+	// The User is in at most one Room, and every view of them agrees on which
+	if u, _ := GetUser("interleave"); u != user {
+		t.Fatal("GetUser() should return the same *User that Login() made")
+	}
+	in := user.RoomIn("")
+	for _, room := range []*Room{roomA, roomB} {
+		if room.HasUser("interleave") != (in == room) {
+			t.Error("Room", room.Name(), "disagrees with RoomIn(), which is", in)
+		}
+	}
+
+	// Joining the Room they're in again does nothing
+	if err := user.Join(roomA, ""); err != nil {
+		t.Fatal(err)
+	} else if err := user.Join(roomA, ""); err != nil {
+		t.Error("Joining the same Room twice should do nothing, got", err)
+	} else if user.RoomIn("") != roomA || !roomA.HasUser("interleave") || roomB.HasUser("interleave") {
+		t.Error("Expected the User to only be in", roomA.Name())
+	}
+}
+
+func TestNamePolicy(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	if SetNamePolicy(helpers.NamePolicy{MinLength: 5, MaxLength: 2}) == nil {
+		t.Error("SetNamePolicy() should reject a MinLength over the MaxLength")
+	} else if SetNamePolicy(helpers.NamePolicy{Chars: helpers.NameCharsPattern}) == nil {
+		t.Error("SetNamePolicy() should require a Pattern with NameCharsPattern")
+	}
+	if err := SetNamePolicy(helpers.NamePolicy{MaxLength: 10, Chars: helpers.NameCharsAlphanumeric, Normalize: true, Unique: true}); err != nil {
+		t.Fatal(err)
+	}
+	defer SetNamePolicy(helpers.NamePolicy{})
+	login := func(name string) (*User, int) {
+		var user *User
+		var clientMux sync.Mutex
+		_, err := Login(name, -1, "", true, false, testSocket(t), &user, &clientMux)
+		return user, err.ID
+	}
+
+	if _, errID := login("zero\u200bwidth"); errID != helpers.ErrorNameChars {
+		t.Error("Expected a name with a zero-width space to be rejected, got", errID)
+	} else if _, errID := login("muchtoolonganame"); errID != helpers.ErrorNameLength {
+		t.Error("Expected a long name to be rejected, got", errID)
+	}
+
+	// Names are used in NFKC form, and can't look like an online User's name until they log out
+	user, errID := login("ｐａｙｐａｌ")
+	if errID != 0 {
+		t.Fatal("Expected to log in, got", errID)
+	} else if user.Name() != "paypal" {
+		t.Error("Expected the name in NFKC form, got", user.Name())
+	}
+	if _, errID := login("PayPaI"); errID != helpers.ErrorNameConfusable {
+		t.Error("Expected a lookalike name to be rejected, got", errID)
+	}
+	user.Kick()
+	if lookalike, errID := login("PayPaI"); errID != 0 {
+		t.Error("Expected the lookalike name to be free once the User logged out, got", errID)
+	} else {
+		lookalike.Kick()
+	}
+}
+
+func TestDuplicateLogin(t *testing.T) {
+	defer SettingsSet(false, "server", false, false, false, false, 0, 0)
+
+	// Neither KickDupOnLogin or MultiConnect: the second login fails
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	first, _ := testLogin(t, "dupNone")
+	var second *User
+	var clientMux sync.Mutex
+	if _, err := Login("dupNone", -1, "", true, false, testSocket(t), &second, &clientMux); err.ID == 0 {
+		t.Error("Logging in twice should fail without KickDupOnLogin or MultiConnect")
+	}
+	first.Kick()
+
+	// KickDupOnLogin: the first login is kicked, the second succeeds
+	SettingsSet(true, "server", false, false, false, false, 0, 0)
+	var kicked *User
+	var kickedMux sync.Mutex
+	if _, err := Login("dupKick", -1, "", true, false, testSocket(t), &kicked, &kickedMux); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	old := kicked
+	replacement, _ := testLogin(t, "dupKick")
+	kickedMux.Lock()
+	if kicked != nil {
+		t.Error("The kicked connection should no longer have a User")
+	}
+	kickedMux.Unlock()
+	if u, _ := GetUser("dupKick"); u != replacement || u == old {
+		t.Error("GetUser() should return the User from the second login")
+	}
+	if old.Socket("") != nil {
+		t.Error("The kicked User should have no connections left")
+	}
+	replacement.Kick()
+
+	// MultiConnect (overrides KickDupOnLogin): both logins share the User
+	SettingsSet(true, "server", false, false, false, true, 0, 0)
+	multi, firstConn := testLogin(t, "dupMulti")
+	again, secondConn := testLogin(t, "dupMulti")
+	if multi != again {
+		t.Error("Both connections should be logged in as the same *User")
+	} else if firstConn == secondConn {
+		t.Error("Both connections should have their own connection ID")
+	} else if len(multi.ConnectionIDs()) != 2 {
+		t.Error("The User should have 2 connections, has", len(multi.ConnectionIDs()))
+	}
+	multi.Kick()
+}
+
+func TestDuplicateLoginLeaveCallback(t *testing.T) {
+	defer func() {
+		SettingsSet(false, "server", false, false, false, false, 0, 0)
+		RoomLeaveCallback = nil
+	}()
+	SettingsSet(true, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("dupLeave", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	kicked, connID := testLogin(t, "dupLeave")
+	if err := kicked.Join(room, connID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The leave callback for the kicked User can look up Users without deadlocking the login
+	found := make(chan *User, 1)
+	RoomLeaveCallback = func(roomName string, userName string, reason int) {
+		u, _ := GetUser(userName)
+		found <- u
+	}
+	var replacement *User
+	var clientMux sync.Mutex
+	socket := testSocket(t)
+	done := make(chan helpers.GopherError)
+	go func() {
+		_, err := Login("dupLeave", -1, "", true, false, socket, &replacement, &clientMux)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err.ID != 0 {
+			t.Fatal(err.Message)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Timed out logging in over a User in a Room")
+	}
+	defer replacement.Kick()
+	select {
+	case u := <-found:
+		if u != replacement {
+			t.Error("The leave callback should find the User from the second login, got", u)
+		}
+	default:
+		t.Error("The leave callback should run for the kicked User")
+	}
+	if room.NumUsers() != 0 {
+		t.Error("The kicked User should have left the Room")
+	}
+}
+
+func TestKickWithReason(t *testing.T) {
+	defer func() { LogoutCallback = nil }()
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	var logouts int32
+	LogoutCallback = func(string, int) { atomic.AddInt32(&logouts, 1) }
+	server, client := testSocketPair(t)
+	var user *User
+	var clientMux sync.Mutex
+	if _, err := Login("kickReason", -1, "", true, false, server, &user, &clientMux); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	kicked := user
+	kicked.KickWithReason("Cheating")
+	kicked.Kick()
+
+	// The client is told why, then its socket is closed with a close frame
+	client.SetReadDeadline(time.Now().Add(time.Second * 5))
+	var reason interface{}
+	for {
+		var message map[string]interface{}
+		if err := client.ReadJSON(&message); err != nil {
+			if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Error("Expected the socket to be closed with a close frame, got", err)
+			}
+			break
+		}
+		if k, ok := message[helpers.ServerActionKicked].(map[string]interface{}); ok {
+			reason = k["m"]
+		}
+	}
+	if reason != "Cheating" {
+		t.Error("Expected the client to get the reason for the kick, got", reason)
+	}
+	clientMux.Lock()
+	if user != nil {
+		t.Error("The kicked connection should no longer have a User")
+	}
+	clientMux.Unlock()
+	if n := atomic.LoadInt32(&logouts); n != 1 {
+		t.Error("Expected the logout callback to run once, ran", n, "times")
+	}
+}
+
+func TestLegacyDuplicateLogin(t *testing.T) {
+	defer SettingsSet(false, "server", false, false, false, false, 0, 0)
+	SettingsSet(true, "server", false, false, false, false, 0, 0)
+
+	// A client on the legacy protocol is told about a duplicate login with ServerActionLoggedInElsewhere
+	server, client := testSocketPair(t)
+	SetConnInfo(server, "", helpers.LegacyProtocolVersion, "", nil)
+	defer ForgetConnInfo(server)
+	var user *User
+	var clientMux sync.Mutex
+	if _, err := Login("legacyDup", -1, "", true, false, server, &user, &clientMux); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	replacement, _ := testLogin(t, "legacyDup")
+	defer replacement.Kick()
+
+	client.SetReadDeadline(time.Now().Add(time.Second * 5))
+	var elsewhere, kicked bool
+	for {
+		var message map[string]interface{}
+		if err := client.ReadJSON(&message); err != nil {
+			break
+		}
+		_, le := message[helpers.ServerActionLoggedInElsewhere]
+		_, k := message[helpers.ServerActionKicked]
+		elsewhere, kicked = elsewhere || le, kicked || k
+	}
+	if !elsewhere || kicked {
+		t.Error("Expected the legacy client to get ServerActionLoggedInElsewhere instead of ServerActionKicked")
+	}
+}
+
+func TestMultiConnect(t *testing.T) {
+	defer func() {
+		SettingsSet(false, "server", false, false, false, false, 0, 0)
+		LogoutCallback = nil
+	}()
+	SettingsSet(false, "server", false, false, false, true, 0, 0)
+	var loggedOut []string
+	LogoutCallback = func(name string, dbID int) {
+		loggedOut = append(loggedOut, name)
+	}
+	room, roomErr := NewRoom("multiConnect", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	var user *User
+	var connIDs []string
+	var clients []*websocket.Conn
+	for i := 0; i < 2; i++ {
+		var connUser *User
+		var clientMux sync.Mutex
+		socket, client := testSocketPair(t)
+		connID, err := Login("multiConnect", -1, "", true, false, socket, &connUser, &clientMux)
+		if err.ID != 0 {
+			t.Fatal(err.Message)
+		}
+		user = connUser
+		connIDs = append(connIDs, connID)
+		clients = append(clients, client)
+	}
+	if user.ConnectionCount() != 2 {
+		t.Fatal("Expected 2 connections, got", user.ConnectionCount())
+	}
+	for _, connID := range connIDs {
+		user.Join(room, connID)
+	}
+	if room.NumUsers() != 1 {
+		t.Error("The User should be in the room once, no matter how many connections join, got", room.NumUsers())
+	}
+
+	// Messages to the User go to every connection, SendToConnection() only goes to one
+	readData := func(client *websocket.Conn) interface{} {
+		for {
+			var message map[string]interface{}
+			if err := client.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			} else if data, ok := message[helpers.ServerActionDataMessage]; ok {
+				return data
+			}
+		}
+	}
+	user.DataMessage("everyone", "")
+	for _, client := range clients {
+		if data := readData(client); data != "everyone" {
+			t.Error("Expected every connection to get the message, got", data)
+		}
+	}
+	if err := user.SendToConnection(connIDs[1], map[string]interface{}{helpers.ServerActionDataMessage: "second"}); err != nil {
+		t.Error(err)
+	} else if data := readData(clients[1]); data != "second" {
+		t.Error("Expected the second connection to get the message, got", data)
+	}
+	if err := user.SendToConnection("", "none"); err == nil {
+		t.Error("SendToConnection() should require a connID with MultiConnect enabled")
+	} else if err := user.SendToConnection("missing", "none"); err == nil {
+		t.Error("SendToConnection() should fail for a connID the User doesn't have")
+	}
+
+	// The User stays logged in until their last connection logs out, and the callback only runs then
+	user.Logout(connIDs[0])
+	if !user.IsOnline() || user.ConnectionCount() != 1 || len(loggedOut) != 0 {
+		t.Error("The User should still be logged in with their other connection")
+	} else if room.NumUsers() != 1 {
+		t.Error("The User should still be in the room with their other connection")
+	}
+	user.Logout(connIDs[1])
+	if user.IsOnline() || len(loggedOut) != 1 {
+		t.Error("Expected the logout callback to run once, after the last connection, got", loggedOut)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	user, _ := testLogin(t, "setStatus")
+	if err := user.SetStatus(StatusOffline + 1); err == nil {
+		t.Error("SetStatus() should reject an unknown status")
+	}
+	if err := user.SetStatus(StatusIdle); err != nil {
+		t.Error(err)
+	} else if user.Status() != StatusIdle {
+		t.Error("Expected status", StatusIdle, "got", user.Status())
+	}
+	user.Kick()
+	if user.Status() != StatusOffline {
+		t.Error("Kicking a User should set their status to StatusOffline")
+	}
+	if err := user.SetStatus(StatusAvailable); err == nil {
+		t.Error("SetStatus() should fail for a User that is not logged in")
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	idle, _ := testLogin(t, "getUsersIdle")
+	available, _ := testLogin(t, "getUsersAvailable")
+	idle.SetStatus(StatusIdle)
+
+	userList := GetUsers()
+	if len(userList) != 2 {
+		t.Fatal("Expected 2 Users, got", len(userList))
+	}
+	byStatus := GetUsersByStatus(StatusIdle)
+	if len(byStatus) != 1 || byStatus[0] != idle {
+		t.Error("GetUsersByStatus() should only return the idle User")
+	}
+	visited := 0
+	ForEachUser(func(u *User) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Error("ForEachUser() should stop when the callback returns false")
+	}
+
+	// Handles from the snapshot are safe to use after logging out
+	idle.Kick()
+	available.Kick()
+	for _, u := range userList {
+		if u.IsOnline() {
+			t.Error("User '" + u.Name() + "' should not be online")
+		}
+		if u.RoomIn("") != nil {
+			t.Error("A logged out User should not be in a Room")
+		}
+	}
+}
+
+func TestBroadcastToUsers(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("broadcast", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	available, _ := testLogin(t, "broadcastAvailable")
+	defer available.Kick()
+	idle, _ := testLogin(t, "broadcastIdle")
+	defer idle.Kick()
+	idle.SetStatus(StatusIdle)
+	inRoom, _ := testLogin(t, "broadcastInRoom")
+	defer inRoom.Kick()
+	inRoom.Join(room, "")
+
+	if err := BroadcastToUsers("", nil, nil); err == nil {
+		t.Error("BroadcastToUsers() should require a message type")
+	}
+	if err := BroadcastToUsers("notice", "hi", &BroadcastFilter{Statuses: []int{StatusAvailable}, ExcludeRooms: []string{"broadcast"}}); err != nil {
+		t.Error(err)
+	}
+
+	// Dead sockets are skipped and reported
+	idle.Socket("").Close()
+	if err := BroadcastToUsers("notice", "hi", nil); err == nil || !strings.HasPrefix(err.Error(), "Failed to send to 1 of 3") {
+		t.Error("Expected 1 of 3 connections to fail, got", err)
+	}
+}
+
+func TestUserAndRoomCounts(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	users, guests, rooms, testRooms := UserCount(), GuestCount(), RoomCount(), testRoomType.RoomCount()
+
+	guest, _ := testLogin(t, "countGuest")
+	var connUser *User
+	var clientMux sync.Mutex
+	if _, err := Login("countMember", 7, "", false, false, testSocket(t), &connUser, &clientMux); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	member := connUser
+	room, roomErr := NewRoom("countRoom", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	if UserCount() != users+2 || GuestCount() != guests+1 {
+		t.Error("Expected 2 more Users and 1 more guest, got", UserCount()-users, "and", GuestCount()-guests)
+	} else if RoomCount() != rooms+1 || testRoomType.RoomCount() != testRooms+1 {
+		t.Error("Expected 1 more Room of the test RoomType")
+	}
+
+	guest.Kick()
+	member.Kick()
+	member.Kick()
+	room.Delete()
+	room.Delete()
+	if UserCount() != users || GuestCount() != guests || RoomCount() != rooms || testRoomType.RoomCount() != testRooms {
+		t.Error("The counts should go back down after logging out and deleting the Room, and only go down once")
+	}
+}
+
+func TestCountsWithDuplicateLogins(t *testing.T) {
+	users, guests, registered := UserCount(), GuestCount(), RegisteredCount()
+	login := func(name string, dbID int) *User {
+		var connUser *User
+		var clientMux sync.Mutex
+		if _, err := Login(name, dbID, "", dbID == -1, false, testSocket(t), &connUser, &clientMux); err.ID != 0 {
+			t.Fatal(err.Message)
+		}
+		return connUser
+	}
+
+	// A User that replaces their old session is counted once
+	SettingsSet(true, "server", false, false, false, false, 0, 0)
+	login("dupMember", 3)
+	member := login("dupMember", 3)
+	if UserCount() != users+1 || RegisteredCount() != registered+1 || GuestCount() != guests {
+		t.Error("Expected 1 more registered User after logging in twice, got", UserCount()-users, RegisteredCount()-registered)
+	}
+	member.Kick()
+
+	// So is a User with more than one connection
+	SettingsSet(false, "server", false, false, false, true, 0, 0)
+	login("multiGuest", -1)
+	guest := login("multiGuest", -1)
+	if UserCount() != users+1 || GuestCount() != guests+1 || RegisteredCount() != registered {
+		t.Error("Expected 1 more guest after connecting twice, got", UserCount()-users, GuestCount()-guests)
+	}
+	guest.Kick()
+
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	if UserCount() != users || GuestCount() != guests || RegisteredCount() != registered {
+		t.Error("The counts should go back down after logging out")
+	}
+}
+
+func TestRecentLogins(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	user, _ := testLogin(t, "recentLogin")
+	defer user.Kick()
+	var connUser *User
+	var clientMux sync.Mutex
+	Login("recentLogin", -1, "", true, false, testSocket(t), &connUser, &clientMux)
+
+	logins := RecentLogins(2)
+	if len(logins) != 2 {
+		t.Fatal("Expected the 2 latest logins, got", logins)
+	}
+	if logins[0].Name != "recentLogin" || logins[0].Success || logins[0].Error != helpers.ErrorAuthAlreadyLogged {
+		t.Error("Expected the newest login to have failed, got", logins[0])
+	}
+	if logins[1].Name != "recentLogin" || !logins[1].Success || !logins[1].Guest {
+		t.Error("Expected the login before it to have worked, got", logins[1])
+	}
+
+	// Only the latest are kept
+	for i := 0; i < loginHistorySize; i++ {
+		recordLogin("recentLoginFiller"+strconv.Itoa(i), nil, false, helpers.NoError())
+	}
+	if logins := RecentLogins(loginHistorySize + 10); len(logins) != loginHistorySize ||
+		logins[0].Name != "recentLoginFiller"+strconv.Itoa(loginHistorySize-1) {
+		t.Error("Expected only the latest", loginHistorySize, "logins, newest first")
+	}
+}
+
+func TestGuestName(t *testing.T) {
+	defer func() { LoginCallback = nil }()
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	var callbackID int
+	LoginCallback = func(userName string, dbID int, _ map[string]interface{}, _ map[string]interface{}) bool {
+		callbackID = dbID
+		return true
+	}
+
+	// A name that's taken is skipped
+	taken, _ := testLogin(t, "nameGuest"+strconv.FormatUint(atomic.LoadUint64(&guestCounter)+1, 10))
+	defer taken.Kick()
+	name := GuestName("nameGuest")
+	if name == taken.Name() || !strings.HasPrefix(name, "nameGuest") {
+		t.Error("Expected a new name starting with nameGuest, got", name)
+	}
+	callbackID = 0
+	guest, _ := testLogin(t, name)
+	defer guest.Kick()
+	if !guest.IsGuest() || callbackID != -1 {
+		t.Error("Expected the login callback to get a guest with databaseID -1, got", callbackID)
+	}
+	if GuestName("nameGuest") == name {
+		t.Error("GuestName should not make the same name twice")
+	}
+}
+
+// BenchmarkLoginLogout logs Users in, looks them up, and logs them out from many goroutines at once. The "1 shard" case
+// keeps every User behind one lock, like before the Users were sharded.
+func BenchmarkLoginLogout(b *testing.B) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	for _, shardCount := range []int{1, userShardCount} {
+		b.Run(strconv.Itoa(shardCount)+" shards", func(b *testing.B) {
+			oldShards := userShards
+			defer func() { userShards = oldShards }()
+			userShards = makeUserShards()[:shardCount]
+
+			var next int64
+			b.RunParallel(func(pb *testing.PB) {
+				socket := testSocket(b)
+				var user *User
+				var clientMux sync.Mutex
+				for pb.Next() {
+					name := "bench" + strconv.FormatInt(atomic.AddInt64(&next, 1), 10)
+					if _, err := Login(name, -1, "", true, false, socket, &user, &clientMux); err.ID != 0 {
+						b.Error(err.Message)
+						return
+					}
+					found, getErr := GetUser(name)
+					if getErr != nil {
+						b.Error(getErr)
+						return
+					}
+					found.Logout("")
+				}
+			})
+		})
+	}
+}
+
+func TestUserConnInfo(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	socket := testSocket(t)
+	SetConnInfo(socket, "203.0.113.5", "1.0", "es_MX", map[string]interface{}{"country": "NZ"})
+	defer ForgetConnInfo(socket)
+
+	var user *User
+	var clientMux sync.Mutex
+	connID, err := Login("connInfo", -1, "", true, false, socket, &user, &clientMux)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	defer user.Kick()
+	if user.IP() != "203.0.113.5" {
+		t.Error("Expected the IP address of the connection, got", user.IP())
+	}
+	if country := user.GetVariable("country", connID); country != "NZ" {
+		t.Error("Expected the connection's metadata in its variables, got", country)
+	}
+	if user.ClientVersion() != "1.0" {
+		t.Error("Expected the protocol version of the connection, got", user.ClientVersion())
+	}
+	if user.Locale() != "es-mx" {
+		t.Error("Expected the locale of the connection, got", user.Locale())
+	}
+}
@@ -29,7 +29,7 @@ func (u *User) SetVariable(key string, value interface{}, connID string) {
 		return
 	}
 	(*u.conns[connID]).vars[key] = value
-	socket := (*u.conns[connID]).socket
+	conn := u.conns[connID]
 	u.mux.Unlock()
 
 	//MAKE CLIENT MESSAGE
@@ -40,7 +40,7 @@ func (u *User) SetVariable(key string, value interface{}, connID string) {
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionSetVariable, resp, helpers.NoError())
 
 	//SEND RESPONSE TO CLIENT
-	socket.WriteJSON(clientResp)
+	conn.send(clientResp)
 }
 
 // SetVariables sets all the specified User variables at once. The client API of the User will also receive these changes. If you are using MultiConnect in ServerSettings, the connID
@@ -65,12 +65,12 @@ func (u *User) SetVariables(values map[string]interface{}, connID string) {
 	for key, val := range values {
 		(*u.conns[connID]).vars[key] = val
 	}
-	socket := (*u.conns[connID]).socket
+	conn := u.conns[connID]
 	u.mux.Unlock()
 
 	//SEND RESPONSE TO CLIENT
 	clientResp := helpers.MakeClientResponse(helpers.ClientActionSetVariables, values, helpers.NoError())
-	socket.WriteJSON(clientResp)
+	conn.send(clientResp)
 
 }
 
@@ -81,9 +81,17 @@ func (u *User) GetVariable(key string, connID string) interface{} {
 	//REJECT INCORRECT INPUT
 	if len(key) == 0 {
 		return nil
+	} else if multiConnect && len(connID) == 0 {
+		return nil
+	} else if !multiConnect {
+		connID = "1"
 	}
 
 	u.mux.Lock()
+	if _, ok := u.conns[connID]; !ok {
+		u.mux.Unlock()
+		return nil
+	}
 	val := (*u.conns[connID]).vars[key]
 	u.mux.Unlock()
 
@@ -91,22 +99,33 @@ func (u *User) GetVariable(key string, connID string) interface{} {
 	return val
 }
 
-// GetVariables gets the specified (or all if nil) User variables as a map[string]interface{}.  If you are using MultiConnect in ServerSettings, the connID
+// GetVariables gets the specified (or all if nil) User variables as a map[string]interface{}. The map is a copy, so changing it
+// will not change the User's variables. If you are using MultiConnect in ServerSettings, the connID
 // parameter is the connection ID associated with one of the connections attached to the inviting User. This must
 // be provided when getting a User's variables with MultiConnect enabled. Otherwise, an empty string can be used.
 func (u *User) GetVariables(keys []string, connID string) map[string]interface{} {
 	var value map[string]interface{} = make(map[string]interface{})
-	if keys == nil || len(keys) == 0 {
-		u.mux.Lock()
-		value = (*u.conns[connID]).vars
+	if multiConnect && len(connID) == 0 {
+		return value
+	} else if !multiConnect {
+		connID = "1"
+	}
+
+	u.mux.Lock()
+	if _, ok := u.conns[connID]; !ok {
 		u.mux.Unlock()
+		return value
+	}
+	if keys == nil || len(keys) == 0 {
+		for key, val := range (*u.conns[connID]).vars {
+			value[key] = val
+		}
 	} else {
-		u.mux.Lock()
 		for i := 0; i < len(keys); i++ {
 			value[keys[i]] = (*u.conns[connID]).vars[keys[i]]
 		}
-		u.mux.Unlock()
 	}
+	u.mux.Unlock()
 
 	//
 	return value
@@ -116,39 +135,79 @@ func (u *User) GetVariables(keys []string, connID string) map[string]interface{}
 //   ROOM VARIABLES   /////////////////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
-// SetVariable sets a Room variable.
-func (r *Room) SetVariable(key string, value interface{}) {
+// SetVariable sets a Room variable. All the Users in the Room will receive the change, which you can capture with the client APIs.
+func (r *Room) SetVariable(key string, value interface{}) error {
 	//REJECT INCORRECT INPUT
 	if len(key) == 0 {
-		return
+		return errors.New("*Room.SetVariable() requires a key")
 	}
 
 	r.mux.Lock()
 	if r.usersMap == nil {
 		r.mux.Unlock()
-		return
+		return errors.New("Room '" + r.name + "' does not exist")
 	}
 	r.vars[key] = value
+	r.events.record(EventVariables, "", map[string]interface{}{key: value})
 	r.mux.Unlock()
 
+	//BROADCAST THE CHANGE
+	message := map[string]map[string]interface{}{
+		helpers.ServerActionRoomVariable: {
+			"k": key,
+			"v": value,
+		},
+	}
+	r.broadcastVariables(message)
+
 	//
-	return
+	return nil
 }
 
-// SetVariables sets all the specified Room variables at once.
-func (r *Room) SetVariables(values map[string]interface{}) {
+// SetVariables sets all the specified Room variables at once. All the Users in the Room will receive the changes
+// in a single message, which you can capture with the client APIs.
+func (r *Room) SetVariables(values map[string]interface{}) error {
+	//REJECT INCORRECT INPUT
+	if values == nil || len(values) == 0 {
+		return errors.New("*Room.SetVariables() requires values")
+	}
+
 	r.mux.Lock()
 	if r.usersMap == nil {
 		r.mux.Unlock()
-		return
+		return errors.New("Room '" + r.name + "' does not exist")
 	}
+	changes := make(map[string]interface{})
 	for key, val := range values {
 		r.vars[key] = val
+		changes[key] = val
 	}
+	r.events.record(EventVariables, "", changes)
 	r.mux.Unlock()
 
+	//BROADCAST THE CHANGES
+	message := map[string]interface{}{
+		helpers.ServerActionRoomVariables: changes,
+	}
+	r.broadcastVariables(message)
+
 	//
-	return
+	return nil
+}
+
+func (r *Room) broadcastVariables(message interface{}) {
+	userMap, err := r.GetUserMap()
+	if err != nil {
+		return
+	}
+	encoded := helpers.NewEncoded(message)
+	for _, u := range userMap {
+		u.mux.Lock()
+		for _, conn := range u.conns {
+			conn.send(encoded)
+		}
+		u.mux.Unlock()
+	}
 }
 
 // GetVariable gets one of the Room's variables.
@@ -170,7 +229,8 @@ func (r *Room) GetVariable(key string) (interface{}, error) {
 	return value, nil
 }
 
-// GetVariables gets all the specified (or all if not) Room variables as a map[string]interface{}.
+// GetVariables gets all the specified (or all if not) Room variables as a map[string]interface{}. The map is a copy, so changing it
+// will not change the Room's variables.
 func (r *Room) GetVariables(keys []string) (map[string]interface{}, error) {
 	var value map[string]interface{} = make(map[string]interface{})
 	r.mux.Lock()
@@ -179,7 +239,9 @@ func (r *Room) GetVariables(keys []string) (map[string]interface{}, error) {
 		return nil, errors.New("Room '" + r.name + "' does not exist")
 	}
 	if keys == nil || len(keys) == 0 {
-		value = r.vars
+		for key, val := range r.vars {
+			value[key] = val
+		}
 	} else {
 		for i := 0; i < len(keys); i++ {
 			value[keys[i]] = r.vars[keys[i]]
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"testing"
+)
+
+func TestUserVariables(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("userVariables", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	user, _ := testLogin(t, "userVariables")
+	defer user.Kick()
+
+	user.SetVariables(map[string]interface{}{"rating": 1200, "character": "knight"}, "")
+	user.Join(room, "")
+	user.Leave("")
+	if user.GetVariable("rating", "") != 1200 {
+		t.Error("User variables should survive changing rooms")
+	}
+
+	// GetVariables returns a copy
+	vars := user.GetVariables(nil, "")
+	vars["rating"] = 0
+	if user.GetVariable("rating", "") != 1200 {
+		t.Error("Changing the map from GetVariables() should not change the User's variables")
+	}
+	if vars := user.GetVariables([]string{"character"}, ""); len(vars) != 1 || vars["character"] != "knight" {
+		t.Error("GetVariables() with keys should only get those variables")
+	}
+}
+
+func TestRoomVariables(t *testing.T) {
+	room, roomErr := NewRoom("roomVariables", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	if err := room.SetVariable("", 1); err == nil {
+		t.Error("SetVariable() should require a key")
+	}
+	room.SetVariable("map", "canyon")
+	room.SetVariables(map[string]interface{}{"red": 3, "blue": 2})
+	if vars, _ := room.GetVariables(nil); len(vars) != 3 || vars["map"] != "canyon" {
+		t.Error("Unexpected Room variables:", vars)
+	}
+	if state := GetRoomsState()["roomVariables"]; state.V["red"] != 3 {
+		t.Error("Room variables should be in the recovery state")
+	}
+
+	room.Delete()
+	if err := room.SetVariable("map", "pass"); err == nil {
+		t.Error("SetVariable() should fail on a deleted Room")
+	}
+}
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+)
+
+// The most bytes a speaker's name can take up in a voice frame's header.
+const maxVoiceNameLength = 255
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   VOICE CHAT SETTINGS   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// EnableVoiceChat enables voice chat for the Room, even if its RoomType doesn't have voice chat enabled.
+func (r *Room) EnableVoiceChat() {
+	r.mux.Lock()
+	r.voiceChat = true
+	r.mux.Unlock()
+}
+
+// VoiceChatEnabled returns true if voice chat is enabled for the Room, or for its RoomType.
+func (r *Room) VoiceChatEnabled() bool {
+	r.mux.Lock()
+	enabled := r.voiceChat
+	r.mux.Unlock()
+	if roomType, ok := roomTypes[r.rType]; ok && roomType.VoiceChatEnabled() {
+		return true
+	}
+	return enabled
+}
+
+// MuteUser stops the voice chat of the User with the name userName from being relayed in the Room. They stay muted if
+// they leave and join the Room again, until they're unmuted with *Room.UnmuteUser(). Everyone in the Room is told with a
+// helpers.ServerActionVoiceMute message.
+func (r *Room) MuteUser(userName string) error {
+	if len(userName) == 0 {
+		return errors.New("*Room.MuteUser() requires a user name")
+	}
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if r.muted[userName] {
+		r.mux.Unlock()
+		return errors.New("The user '" + userName + "' is already muted")
+	}
+	if r.muted == nil {
+		r.muted = make(map[string]bool)
+	}
+	r.muted[userName] = true
+	r.mux.Unlock()
+	r.broadcastMute(userName, true)
+
+	//
+	return nil
+}
+
+// UnmuteUser lets the voice chat of the User with the name userName be relayed in the Room again after *Room.MuteUser().
+func (r *Room) UnmuteUser(userName string) error {
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if !r.muted[userName] {
+		r.mux.Unlock()
+		return errors.New("The user '" + userName + "' is not muted")
+	}
+	delete(r.muted, userName)
+	r.mux.Unlock()
+	r.broadcastMute(userName, false)
+
+	//
+	return nil
+}
+
+// IsMuted returns true if the User with the name userName is muted in the Room.
+func (r *Room) IsMuted(userName string) bool {
+	r.mux.Lock()
+	muted := r.muted[userName]
+	r.mux.Unlock()
+	return muted
+}
+
+// broadcastMute tells everyone in the Room that a User was muted or unmuted.
+func (r *Room) broadcastMute(userName string, muted bool) {
+	message := helpers.NewEncoded(map[string]map[string]interface{}{
+		helpers.ServerActionVoiceMute: {
+			"u": userName,
+			"m": muted,
+		},
+	})
+	r.mux.Lock()
+	for _, u := range r.usersMap {
+		u.mux.Lock()
+		for _, conn := range u.conns {
+			conn.send(message)
+		}
+		u.mux.Unlock()
+	}
+	r.mux.Unlock()
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   RELAYING VOICE FRAMES   /////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// RelayVoice sends a binary voice frame from the User with the name speaker to everyone else in the Room, as a WebSocket
+// binary message. The message starts with a header: one byte with the length of the speaker's name, then the name. The
+// rest is the frame as it was sent. MessagePack clients get the same bytes as a MessagePack bin. Frames aren't buffered for
+// clients waiting to reconnect, since they'd be stale by then.
+func (r *Room) RelayVoice(speaker string, frame []byte) error {
+	if len(speaker) == 0 || len(speaker) > maxVoiceNameLength {
+		return errors.New("*Room.RelayVoice() requires a speaker name of 1 to 255 bytes")
+	} else if !r.VoiceChatEnabled() {
+		return errors.New("Voice chat is not enabled in the room '" + r.name + "'")
+	} else if r.IsMuted(speaker) {
+		return errors.New("The user '" + speaker + "' is muted")
+	}
+
+	//MAKE THE MESSAGE ONCE FOR EVERY LISTENER - MessagePack CLIENTS GET IT AS A bin
+	data := make([]byte, 0, 1+len(speaker)+len(frame))
+	data = append(data, byte(len(speaker)))
+	data = append(data, speaker...)
+	data = append(data, frame...)
+	message := helpers.NewEncodedBinary(data)
+
+	//SEND IT TO EVERYONE ELSE
+	var listeners []*userConn
+	r.mux.Lock()
+	if r.usersMap == nil {
+		r.mux.Unlock()
+		return helpers.NewError("The room '"+r.name+"' does not exist", helpers.ErrorRoomNotFound)
+	} else if ru := r.usersMap[speaker]; ru != nil && ru.IsSpectator() {
+		r.mux.Unlock()
+		return helpers.NewError("Spectators can't use voice chat", helpers.ErrorSpectating)
+	}
+	for name, u := range r.usersMap {
+		if name == speaker {
+			continue
+		}
+		u.mux.Lock()
+		for _, conn := range u.conns {
+			listeners = append(listeners, conn)
+		}
+		u.mux.Unlock()
+	}
+	r.mux.Unlock()
+	for _, conn := range listeners {
+		conn.sendPrepared(message)
+	}
+
+	//
+	return nil
+}
+
+// sendPrepared writes an encoded message to the connection's socket. It's dropped while the connection is held for a reconnect.
+func (c *userConn) sendPrepared(message *helpers.Encoded) {
+	c.sendMux.Lock()
+	defer c.sendMux.Unlock()
+	if c.held || c.socket == nil {
+		return
+	}
+	message.Write(c.socket)
+}
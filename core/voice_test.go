@@ -0,0 +1,86 @@
+package core
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRelayVoice(t *testing.T) {
+	SettingsSet(false, "server", false, false, false, false, 0, 0)
+	room, roomErr := NewRoom("voiceRoom", "test", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	speaker, speakerID := testLogin(t, "voiceSpeaker")
+	defer speaker.Kick()
+	speaker.Join(room, speakerID)
+	socket, client := testSocketPair(t)
+	var listener *User
+	var clientMux sync.Mutex
+	listenerID, err := Login("voiceListener", -1, "", true, false, socket, &listener, &clientMux)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	defer listener.Kick()
+	listener.Join(room, listenerID)
+
+	// readFrame reads messages until a binary one
+	readFrame := func() []byte {
+		client.SetReadDeadline(time.Now().Add(time.Second * 2))
+		for {
+			messageType, message, readErr := client.ReadMessage()
+			if readErr != nil {
+				t.Fatal("Expected a voice frame:", readErr)
+			} else if messageType == websocket.BinaryMessage {
+				return message
+			}
+		}
+	}
+
+	if room.RelayVoice("voiceSpeaker", []byte{1, 2, 3}) == nil {
+		t.Error("Voice frames should not be relayed before voice chat is enabled")
+	}
+	room.EnableVoiceChat()
+	if !room.VoiceChatEnabled() {
+		t.Fatal("Expected voice chat to be enabled for the Room")
+	}
+	if relayErr := room.RelayVoice("voiceSpeaker", []byte{1, 2, 3}); relayErr != nil {
+		t.Fatal(relayErr)
+	}
+	if frame := readFrame(); string(frame) != "\x0cvoiceSpeaker\x01\x02\x03" {
+		t.Errorf("Expected the frame with the speaker's name in front, got %q", frame)
+	}
+
+	// Muted speakers aren't relayed, and everyone is told
+	if muteErr := room.MuteUser("voiceSpeaker"); muteErr != nil {
+		t.Fatal(muteErr)
+	} else if room.MuteUser("voiceSpeaker") == nil {
+		t.Error("Muting a muted User should fail")
+	}
+	for {
+		var message map[string]map[string]interface{}
+		if readErr := client.ReadJSON(&message); readErr != nil {
+			t.Fatal(readErr)
+		} else if mute, ok := message[helpers.ServerActionVoiceMute]; ok {
+			if mute["u"] != "voiceSpeaker" || mute["m"] != true {
+				t.Error("Expected the mute message for the speaker, got", mute)
+			}
+			break
+		}
+	}
+	if room.RelayVoice("voiceSpeaker", []byte{4}) == nil {
+		t.Error("A muted User's voice frames should not be relayed")
+	}
+	if unmuteErr := room.UnmuteUser("voiceSpeaker"); unmuteErr != nil {
+		t.Fatal(unmuteErr)
+	}
+	room.RelayVoice("voiceSpeaker", []byte{5})
+	if frame := readFrame(); string(frame) != "\x0cvoiceSpeaker\x05" {
+		t.Errorf("Expected the frame after unmuting, got %q", frame)
+	}
+}
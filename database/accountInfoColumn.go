@@ -24,8 +24,49 @@ type AccountInfoColumn struct {
 	encrypt   bool
 }
 
+// AccountInfoColumnDefinition describes an AccountInfoColumn for making many at once with NewAccountInfoColumns().
+// The fields are the same as the parameters of NewAccountInfoColumn().
+type AccountInfoColumnDefinition struct {
+	Name      string
+	DataType  int
+	MaxSize   int
+	Precision int
+	NotNull   bool
+	Unique    bool
+	Encrypt   bool
+}
+
 var (
 	customAccountInfo map[string]AccountInfoColumn = make(map[string]AccountInfoColumn)
+
+	//COLUMN NAMES THE users TABLE USES FOR ITSELF
+	reservedColumnNames map[string]bool = map[string]bool{
+		usersColumnID:            true,
+		usersColumnName:          true,
+		usersColumnPassword:      true,
+		usersColumnVerified:      true,
+		usersColumnVerifyToken:   true,
+		usersColumnVerifyExpires: true,
+		"id":                     true,
+		"password":               true,
+	}
+
+	//SQL KEYWORDS THAT CAN'T BE USED AS A COLUMN NAME WITHOUT QUOTING IT
+	sqlKeywords map[string]bool = map[string]bool{
+		"add": true, "all": true, "alter": true, "and": true, "as": true, "asc": true, "between": true, "by": true,
+		"case": true, "check": true, "column": true, "constraint": true, "create": true, "database": true, "default": true,
+		"delete": true, "desc": true, "distinct": true, "drop": true, "else": true, "end": true, "exists": true,
+		"foreign": true, "from": true, "grant": true, "group": true, "having": true, "in": true, "index": true,
+		"insert": true, "into": true, "is": true, "join": true, "key": true, "like": true, "limit": true, "not": true,
+		"null": true, "on": true, "or": true, "order": true, "primary": true, "references": true, "schema": true,
+		"select": true, "set": true, "table": true, "then": true, "to": true, "trigger": true, "union": true,
+		"unique": true, "update": true, "user": true, "values": true, "view": true, "when": true, "where": true,
+		"with": true,
+	}
+)
+
+const (
+	maxColumnNameLength = 64 // THE LONGEST IDENTIFIER MySQL ALLOWS
 )
 
 // MySQL database data types. Use one of these when making a new AccountInfoColumn or
@@ -114,7 +155,7 @@ var (
 		"FLOAT",
 		"DOUBLE",
 		"DECIMAL",
-		"BIG INT",
+		"BIGINT",
 		"CHAR",
 		"VARCHAR",
 		"NVARCHAR",
@@ -140,29 +181,85 @@ var (
 )
 
 // NewAccountInfoColumn makes a new AccountInfoColumn. You can only make new AccountInfoColumns before starting the server.
+// The server adds any new AccountInfoColumns to the users table when database.Init() runs.
+//
+// The names "id", "name", "password" (and the table's own "_id", "pass", "verified", "vtoken" and "vexpires") are reserved
+// and cannot be used.
+// A maxSize is required for the data types marked with parentheses, and a precision for the ones marked with two. For character
+// and text types, the maxSize is also the maximum length of a value a client can send. Setting notNull makes the column required
+// when a client signs up, and setting unique makes the server reject a sign up or AccountInfoColumn change with a value another
+// account already has. Unique is not checked for encrypted columns.
 func NewAccountInfoColumn(name string, dataType int, maxSize int, precision int, notNull bool, unique bool, encrypt bool) error {
+	if err := checkAccountInfoColumn(name, dataType, maxSize, precision); err != nil {
+		return err
+	} else if _, ok := customAccountInfo[name]; ok {
+		return errors.New("The AccountInfoColumn '" + name + "' already exists")
+	}
+
+	customAccountInfo[name] = AccountInfoColumn{dataType: dataType, maxSize: maxSize, precision: precision, notNull: notNull, unique: unique, encrypt: encrypt}
+
+	//
+	return nil
+}
+
+// NewAccountInfoColumns makes many AccountInfoColumns at once. Either all of them are made, or none are if any of them
+// has an error. You can only make new AccountInfoColumns before starting the server.
+func NewAccountInfoColumns(columns []AccountInfoColumnDefinition) error {
+	names := make(map[string]bool)
+	for _, c := range columns {
+		if err := checkAccountInfoColumn(c.Name, c.DataType, c.MaxSize, c.Precision); err != nil {
+			return err
+		} else if _, ok := customAccountInfo[c.Name]; ok || names[c.Name] {
+			return errors.New("The AccountInfoColumn '" + c.Name + "' already exists")
+		}
+		names[c.Name] = true
+	}
+	for _, c := range columns {
+		customAccountInfo[c.Name] = AccountInfoColumn{dataType: c.DataType, maxSize: c.MaxSize, precision: c.Precision, notNull: c.NotNull, unique: c.Unique, encrypt: c.Encrypt}
+	}
+
+	//
+	return nil
+}
+
+func checkAccountInfoColumn(name string, dataType int, maxSize int, precision int) error {
 	if serverStarted {
 		return errors.New("You can't make a new AccountInfoColumn after the server has started")
 	} else if len(name) == 0 {
 		return errors.New("database.NewAccountInfoColumn() requires a name")
+	} else if reservedColumnNames[strings.ToLower(name)] {
+		return errors.New("The AccountInfoColumn name '" + name + "' is reserved")
 	} else if dataType < 0 || dataType > len(dataTypes)-1 {
 		return errors.New("Incorrect data type")
-	} else if checkStringSQLInjection(name) {
-		return errors.New("Malicious characters detected")
+	} else if !validColumnName(name) {
+		return errors.New("The AccountInfoColumn name '" + name + "' must start with a letter or underscore, only have letters, numbers and underscores, and can't be an SQL keyword")
+	} else if maxSize < 0 || precision < 0 {
+		return errors.New("An AccountInfoColumn's max size and precision can't be negative")
 	}
 
 	if isSizeDataType(dataType) && maxSize == 0 {
-		return errors.New("The data type '" + dataTypesSize[dataType] + "' requires a max size")
+		return errors.New("The data type '" + dataTypes[dataType] + "' requires a max size")
 	} else if isPrecisionDataType(dataType) && (maxSize == 0 || precision == 0) {
-		return errors.New("The data type '" + dataTypesSize[dataType] + "' requires a max size and precision")
+		return errors.New("The data type '" + dataTypes[dataType] + "' requires a max size and precision")
 	}
 
-	customAccountInfo[name] = AccountInfoColumn{dataType: dataType, maxSize: maxSize, precision: precision, notNull: notNull, unique: unique, encrypt: encrypt}
-
 	//
 	return nil
 }
 
+// validColumnName returns true if the name can be used as a column name in every SQL dialect without quoting it.
+func validColumnName(name string) bool {
+	if len(name) > maxColumnNameLength || sqlKeywords[strings.ToLower(name)] {
+		return false
+	}
+	for i, c := range name {
+		if c != '_' && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return len(name) > 0
+}
+
 //CHECKS IF THE DATA TYPE REQUIRES A MAX SIZE
 func isSizeDataType(dataType int) bool {
 	for i := 0; i < len(dataTypesSize); i++ {
@@ -186,8 +283,11 @@ func isPrecisionDataType(dataType int) bool {
 //CONVERTS DATA TYPES TO STRING FOR SQL QUERIES
 func convertDataToString(dataType string, data interface{}) (string, error) {
 	switch data.(type) {
+	case nil:
+		return "NULL", nil
+
 	case int:
-		if dataType != "INTEGER" && dataType != "TINYINT" && dataType != "MEDIUMINT" && dataType != "BIGINT" && dataType != "SMALLINT" {
+		if !isIntDataTypeName(dataType) {
 			return "", errors.New("Mismatched data types")
 		}
 		return strconv.Itoa(data.(int)), nil
@@ -199,7 +299,10 @@ func convertDataToString(dataType string, data interface{}) (string, error) {
 		return fmt.Sprintf("%f", data.(float32)), nil
 
 	case float64:
-		if dataType != "REAL" && dataType != "FLOAT" && dataType != "DOUBLE" && dataType != "DECIMAL" {
+		//NUMBERS FROM CLIENTS ARE DECODED AS float64
+		if f := data.(float64); f == float64(int64(f)) && isIntDataTypeName(dataType) {
+			return strconv.FormatInt(int64(f), 10), nil
+		} else if dataType != "REAL" && dataType != "FLOAT" && dataType != "DOUBLE" && dataType != "DECIMAL" {
 			return "", errors.New("Mismatched data types")
 		}
 		return strconv.FormatFloat(data.(float64), 'f', -1, 64), nil
@@ -212,14 +315,24 @@ func convertDataToString(dataType string, data interface{}) (string, error) {
 		} else if checkStringSQLInjection(data.(string)) {
 			return "", errors.New("Malicious characters detected")
 		}
-		return "\"" + data.(string) + "\"", nil
+		return sqlDialect.quote(data.(string)), nil
 
 	default:
 		return "", errors.New("Data type is not supported. You can open an issue on GitHub to request support for an unsupported SQL data type.")
 	}
 }
 
+//CHECKS IF THE DATA TYPE NAME IS AN INTEGER TYPE
+func isIntDataTypeName(dataType string) bool {
+	return dataType == "INTEGER" || dataType == "TINYINT" || dataType == "MEDIUMINT" || dataType == "BIGINT" || dataType == "SMALLINT"
+}
+
+//CHECKS IF THE DATA TYPE HOLDS CHARACTERS THAT A MAX SIZE LIMITS
+func isCharDataType(dataType int) bool {
+	return dataType == DataTypeChar || dataType == DataTypeVarChar || dataType == DataTypeNationalVarChar || dataType == DataTypeText
+}
+
 //CHECKS IF THERE ARE ANY MALICIOUS CHARACTERS IN A STRING
 func checkStringSQLInjection(inputStr string) bool {
-	return (strings.Contains(inputStr, "\"") || strings.Contains(inputStr, ")") || strings.Contains(inputStr, "(") || strings.Contains(inputStr, ";"))
+	return (strings.Contains(inputStr, "\"") || strings.Contains(inputStr, sqlDialect.quoteMark()) || strings.Contains(inputStr, ")") || strings.Contains(inputStr, "(") || strings.Contains(inputStr, ";"))
 }
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewAccountInfoColumn(t *testing.T) {
+	defer func() {
+		for _, name := range []string{"level", "_rank2", "Country", "balance", "bio"} {
+			delete(customAccountInfo, name)
+		}
+	}()
+
+	// Valid columns are made
+	for name, dataType := range map[string]int{"level": DataTypeInt, "_rank2": DataTypeSmallInt, "Country": DataTypeVarChar} {
+		if err := NewAccountInfoColumn(name, dataType, 32, 0, false, false, false); err != nil {
+			t.Errorf("Expected the column %q to be made, got %v", name, err)
+		} else if _, ok := customAccountInfo[name]; !ok {
+			t.Errorf("Expected the column %q to be kept", name)
+		}
+	}
+	if err := NewAccountInfoColumn("balance", DataTypeDecimal, 10, 2, true, false, false); err != nil {
+		t.Error("Expected a decimal column with a precision to be made, got", err)
+	}
+	if err := NewAccountInfoColumns([]AccountInfoColumnDefinition{{Name: "bio", DataType: DataTypeText, MaxSize: 500}}); err != nil {
+		t.Error("Expected the batch of columns to be made, got", err)
+	}
+
+	// Names that aren't plain identifiers, SQL keywords and reserved names are rejected
+	for _, name := range []string{"", "select", "ORDER", "user", "table", "id", "Password", "nick name", "nick-name",
+		"nick;name", "nick'name", "nick\"name", "nick`name", "nick(name)", "nick,name", "nick.name", "2fast", "émoji",
+		strings.Repeat("a", maxColumnNameLength+1)} {
+
+		if err := NewAccountInfoColumn(name, DataTypeVarChar, 32, 0, false, false, false); err == nil {
+			t.Errorf("Expected the column name %q to be rejected", name)
+			delete(customAccountInfo, name)
+		}
+	}
+
+	// Data types have to exist, and get the sizes they need
+	for _, test := range []struct {
+		dataType  int
+		maxSize   int
+		precision int
+	}{
+		{-1, 0, 0},
+		{len(dataTypes), 0, 0},
+		{DataTypeVarChar, 0, 0},
+		{DataTypeDecimal, 10, 0},
+		{DataTypeInt, -1, 0},
+	} {
+		if err := NewAccountInfoColumn("badType", test.dataType, test.maxSize, test.precision, false, false, false); err == nil {
+			t.Error("Expected the column's type to be rejected:", test)
+			delete(customAccountInfo, "badType")
+		}
+	}
+
+	// A batch with a bad column makes none of them
+	err := NewAccountInfoColumns([]AccountInfoColumnDefinition{{Name: "goodName", DataType: DataTypeInt, MaxSize: 8},
+		{Name: "drop", DataType: DataTypeInt, MaxSize: 8}})
+	if err == nil {
+		t.Error("Expected the batch with a keyword column name to be rejected")
+	} else if _, ok := customAccountInfo["goodName"]; ok {
+		t.Error("Expected none of a rejected batch's columns to be made")
+	}
+	if NewAccountInfoColumn("level", DataTypeInt, 8, 0, false, false, false) == nil {
+		t.Error("Expected an error making a column that already exists")
+	}
+}
@@ -1,9 +1,12 @@
 package database
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/hewiefreeman/GopherGameServer/helpers"
 	"strconv"
+	"time"
 )
 
 var (
@@ -17,15 +20,19 @@ var (
 	customDeleteAccountRequirements     map[string]struct{} = make(map[string]struct{})
 
 	// SignUpCallback is only for internal Gopher Game Server mechanics.
-	SignUpCallback func(string, map[string]interface{}) bool
+	SignUpCallback func(string, map[string]interface{}, string) bool
 	// LoginCallback is only for internal Gopher Game Server mechanics.
 	LoginCallback func(string, int, map[string]interface{}, map[string]interface{}) bool
 	// DeleteAccountCallback is only for internal Gopher Game Server mechanics.
 	DeleteAccountCallback func(string, int, map[string]interface{}, map[string]interface{}) bool
+	// AccountPurgeCallback is only for internal Gopher Game Server mechanics.
+	AccountPurgeCallback func(int, string)
 	// AccountInfoChangeCallback is only for internal Gopher Game Server mechanics.
 	AccountInfoChangeCallback func(string, int, map[string]interface{}, map[string]interface{}) bool
 	// PasswordChangeCallback is only for internal Gopher Game Server mechanics.
 	PasswordChangeCallback func(string, int, map[string]interface{}, map[string]interface{}) bool
+	// NameChangeCallback is only for internal Gopher Game Server mechanics.
+	NameChangeCallback func(string, string, int) bool
 )
 
 // Authentication error messages
@@ -40,6 +47,14 @@ const (
 	errorIncorrectLogin   = "Incorrect login or password"
 	errorInvalidAutoLog   = "Invalid auto-login data"
 	errorNoShardFound     = "Could not find a master or healthy replica database"
+	errorUnknownCol       = "The AccountInfoColumn '%v' does not exist"
+	errorColDataType      = "Incorrect data type for the AccountInfoColumn '%v'"
+	errorColTooLong       = "The AccountInfoColumn '%v' can't be longer than %v characters"
+	errorColRequired      = "The AccountInfoColumn '%v' is required"
+	errorColTaken         = "The value for the AccountInfoColumn '%v' is already taken"
+	errorUnavailable      = "The database is temporarily unavailable"
+	errorNameUnavail      = "The user name is unavailable"
+	errorUnverified       = "The account's email has not been verified"
 )
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -152,6 +167,81 @@ func checkCustomRequirements(customCols map[string]interface{}, requirements map
 	return true
 }
 
+//CHECKS THAT EVERY COLUMN NAME IS AN AccountInfoColumn, SO UNKNOWN NAMES NEVER REACH A QUERY
+func checkAccountInfoNames(customCols map[string]interface{}) helpers.GopherError {
+	for key := range customCols {
+		if _, ok := customAccountInfo[key]; !ok {
+			return helpers.NewError(fmt.Sprintf(errorUnknownCol, key), helpers.ErrorAuthIncorrectCols)
+		}
+	}
+	return helpers.NoError()
+}
+
+// validateAccountInfo checks the values a client sent for a sign up (signUp == true) or AccountInfoColumn change of the
+// User userName against their AccountInfoColumn definitions, before any query that stores them runs. Returns the values
+// converted for a query, before encryption.
+func validateAccountInfo(ctx context.Context, userName string, customCols map[string]interface{}, signUp bool) (map[string]string, helpers.GopherError) {
+	if err := checkAccountInfoNames(customCols); err.ID != 0 {
+		return nil, err
+	}
+	if signUp {
+		// CHECK THE NAME FIRST, SO THE SIGN UP CALLBACK ONLY RUNS FOR SIGN UPS THAT CAN BE STORED
+		var count int
+		if err := database.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableUsers+" WHERE "+usersColumnName+"="+
+			sqlDialect.quote(userName)+";").Scan(&count); connectionLost(err) {
+			return nil, helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+		} else if err != nil {
+			return nil, queryError(err, userName)
+		} else if count > 0 {
+			return nil, helpers.NewError(errorNameUnavail, helpers.ErrorAuthNameUnavail)
+		}
+		if helpers.UniqueNames() {
+			if lookalike, err := NameLookalikeExists(ctx, userName); connectionLost(err) {
+				return nil, helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+			} else if err != nil {
+				return nil, queryError(err, userName)
+			} else if lookalike {
+				return nil, helpers.NameConfusableError()
+			}
+		}
+		for key, col := range customAccountInfo {
+			if _, ok := customCols[key]; col.notNull && !ok {
+				return nil, helpers.NewError(fmt.Sprintf(errorColRequired, key), helpers.ErrorAuthInsufficientCols)
+			}
+		}
+	}
+	values := make(map[string]string, len(customCols))
+	for key, val := range customCols {
+		col := customAccountInfo[key]
+		if val == nil && col.notNull {
+			return nil, helpers.NewError(fmt.Sprintf(errorColRequired, key), helpers.ErrorAuthInsufficientCols)
+		} else if str, ok := val.(string); ok && isCharDataType(col.dataType) && len([]rune(str)) > col.maxSize {
+			return nil, helpers.NewError(fmt.Sprintf(errorColTooLong, key, col.maxSize), helpers.ErrorAuthIncorrectCols)
+		}
+		value, valueErr := convertDataToString(dataTypes[col.dataType], val)
+		if valueErr != nil {
+			if valueErr.Error() == errorMaliciousChars {
+				return nil, helpers.NewError(errorMaliciousChars, helpers.ErrorAuthMaliciousChars)
+			}
+			return nil, helpers.NewError(fmt.Sprintf(errorColDataType, key), helpers.ErrorAuthIncorrectCols)
+		}
+		// ENCRYPTED VALUES ARE SALTED, SO THEY CAN'T BE COMPARED
+		if col.unique && !col.encrypt && val != nil {
+			var count int
+			if err := database.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableUsers+" WHERE "+key+"="+value+" AND "+
+				usersColumnName+"!="+sqlDialect.quote(userName)+";").Scan(&count); connectionLost(err) {
+				return nil, helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+			} else if err != nil {
+				return nil, queryError(err, userName)
+			} else if count > 0 {
+				return nil, helpers.NewError(fmt.Sprintf(errorColTaken, key), helpers.ErrorAuthColumnTaken)
+			}
+		}
+		values[key] = value
+	}
+	return values, helpers.NoError()
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   QUERY HELPERS   /////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -165,23 +255,49 @@ func checkCustomRequirements(customCols map[string]interface{}, requirements map
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to sign a
 // client up when using the SQL features.
 func SignUpClient(userName string, password string, customCols map[string]interface{}) helpers.GopherError {
-	if len(userName) == 0 {
+	return SignUpClientCtx(context.Background(), userName, password, customCols)
+}
+
+// SignUpClientCtx is the same as SignUpClient, but its queries are given up when ctx is done.
+func SignUpClientCtx(ctx context.Context, userName string, password string, customCols map[string]interface{}) helpers.GopherError {
+	if !Healthy() {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if len(userName) == 0 {
 		return helpers.NewError(errorRequiredName, helpers.ErrorAuthRequiredName)
 	} else if len(password) == 0 {
 		return helpers.NewError(errorRequiredPass, helpers.ErrorAuthRequiredPass)
+	}
+	userName, nameErr := helpers.CheckName(userName)
+	if nameErr.ID != 0 {
+		return nameErr
 	} else if checkStringSQLInjection(userName) {
 		return helpers.NewError(errorMaliciousChars, helpers.ErrorAuthMaliciousChars)
 	} else if !checkCustomRequirements(customCols, customSignupRequirements) {
 		return helpers.NewError(errorIncorrectCols, helpers.ErrorAuthIncorrectCols)
 	}
 
+	//VALIDATE THE AccountInfoColumn VALUES
+	values, valuesErr := validateAccountInfo(ctx, userName, customCols, true)
+	if valuesErr.ID != 0 {
+		return valuesErr
+	}
+
+	//MAKE THE EMAIL VERIFICATION TOKEN
+	var token, tokenExpires string
+	if requireVerification {
+		var tokenErr error
+		if token, tokenExpires, tokenErr = newVerificationToken(); tokenErr != nil {
+			return helpers.NewError(tokenErr.Error(), helpers.ErrorAuthEncryption)
+		}
+	}
+
 	//RUN CALLBACK
-	if SignUpCallback != nil && !SignUpCallback(userName, customCols) {
+	if SignUpCallback != nil && !SignUpCallback(userName, customCols, token) {
 		return helpers.NewError(errorDenied, helpers.ErrorActionDenied)
 	}
 
 	//ENCRYPT PASSWORD
-	passHash, hashErr := helpers.EncryptString(password, encryptionCost)
+	passHash, hashErr := hashPassword(password)
 	if hashErr != nil {
 		return helpers.NewError(hashErr.Error(), helpers.ErrorAuthEncryption)
 	}
@@ -189,7 +305,10 @@ func SignUpClient(userName string, password string, customCols map[string]interf
 	var vals []interface{}
 
 	//CREATE PART 1 OF QUERY
-	queryPart1 := "INSERT INTO " + tableUsers + " (" + usersColumnName + ", " + usersColumnPassword + ", "
+	queryPart1 := "INSERT INTO " + tableUsers + " (" + usersColumnName + ", " + usersColumnSkeleton + ", " + usersColumnPassword + ", "
+	if requireVerification {
+		queryPart1 = queryPart1 + usersColumnVerified + ", " + usersColumnVerifyToken + ", " + usersColumnVerifyExpires + ", "
+	}
 
 	if customCols != nil {
 		vals = make([]interface{}, 0, len(customCols))
@@ -198,10 +317,10 @@ func SignUpClient(userName string, password string, customCols map[string]interf
 				return helpers.NewError(errorInsufficientCols, helpers.ErrorAuthInsufficientCols)
 			}
 		}
-		for key, val := range customCols {
+		for key := range customCols {
 			queryPart1 = queryPart1 + key + ", "
 			//MAINTAIN THE ORDER IN WHICH THE COLUMNS WERE DECLARED VIA A SLICE
-			vals = append(vals, []interface{}{val, customAccountInfo[key]})
+			vals = append(vals, []interface{}{values[key], customAccountInfo[key]})
 		}
 	} else if customLoginColumn != "" {
 		return helpers.NewError(errorInsufficientCols, helpers.ErrorAuthInsufficientCols)
@@ -209,21 +328,22 @@ func SignUpClient(userName string, password string, customCols map[string]interf
 	queryPart1 = queryPart1[0:len(queryPart1)-2] + ") "
 
 	//CREATE PART 2 OF QUERY
-	queryPart2 := "VALUES (\"" + userName + "\", \"" + passHash + "\", "
+	queryPart2 := "VALUES (" + sqlDialect.quote(userName) + ", " + sqlDialect.quote(helpers.NameSkeleton(userName)) + ", " +
+		sqlDialect.quote(passHash) + ", "
+	if requireVerification {
+		queryPart2 = queryPart2 + "0, " + sqlDialect.quote(token) + ", " + tokenExpires + ", "
+	}
 	if customCols != nil {
 		for i := 0; i < len(vals); i++ {
 			dt := vals[i].([]interface{})[1].(AccountInfoColumn)
-			//GET STRING VALUE & CHECK FOR INJECTIONS
-			value, valueErr := convertDataToString(dataTypes[dt.dataType], dt.dataType)
-			if valueErr != nil {
-				return helpers.NewError(errorInsufficientCols, helpers.ErrorAuthInsufficientCols)
-			}
+			value := vals[i].([]interface{})[0].(string)
 			//CHECK FOR ENCRYPT
 			if dt.encrypt {
-				value, valueErr = helpers.EncryptString(value, encryptionCost)
-				if valueErr != nil {
-					return helpers.NewError(valueErr.Error(), helpers.ErrorAuthEncryption)
+				hash, hashErr := helpers.EncryptString(value, encryptionCost)
+				if hashErr != nil {
+					return helpers.NewError(hashErr.Error(), helpers.ErrorAuthEncryption)
 				}
+				value = sqlDialect.quote(hash)
 			}
 			//
 			queryPart2 = queryPart2 + value + ", "
@@ -233,9 +353,12 @@ func SignUpClient(userName string, password string, customCols map[string]interf
 	queryPart2 = queryPart2[0:len(queryPart2)-2] + ");"
 
 	//EXECUTE QUERY
-	_, insertErr := database.Exec(queryPart1 + queryPart2)
+	_, insertErr := execContext(ctx, queryPart1+queryPart2)
 	if insertErr != nil {
-		return helpers.NewError(insertErr.Error(), helpers.ErrorAuthQuery)
+		if connectionLost(insertErr) {
+			return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+		}
+		return queryError(insertErr, userName)
 	}
 
 	//
@@ -251,7 +374,15 @@ func SignUpClient(userName string, password string, customCols map[string]interf
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to log in a
 // client when using the SQL features.
 func LoginClient(userName string, password string, deviceTag string, remMe bool, customCols map[string]interface{}) (string, int, string, helpers.GopherError) {
-	if len(userName) == 0 {
+	return LoginClientCtx(context.Background(), userName, password, deviceTag, remMe, customCols)
+}
+
+// LoginClientCtx is the same as LoginClient, but its queries are given up when ctx is done.
+func LoginClientCtx(ctx context.Context, userName string, password string, deviceTag string, remMe bool, customCols map[string]interface{}) (string, int, string, helpers.GopherError) {
+	userName = helpers.NormalizeName(userName)
+	if !Healthy() {
+		return "", 0, "", helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if len(userName) == 0 {
 		return "", 0, "", helpers.NewError(errorRequiredName, helpers.ErrorAuthRequiredName)
 	} else if len(password) == 0 {
 		return "", 0, "", helpers.NewError(errorRequiredPass, helpers.ErrorAuthRequiredPass)
@@ -261,6 +392,8 @@ func LoginClient(userName string, password string, deviceTag string, remMe bool,
 		return "", 0, "", helpers.NewError(errorMaliciousChars, helpers.ErrorAuthMaliciousChars)
 	} else if !checkCustomRequirements(customCols, customLoginRequirements) {
 		return "", 0, "", helpers.NewError(errorIncorrectCols, helpers.ErrorAuthIncorrectCols)
+	} else if err := checkAccountInfoNames(customCols); err.ID != 0 {
+		return "", 0, "", err
 	}
 
 	//FIRST THREE ARE id, password, name IN THAT ORDER
@@ -289,11 +422,13 @@ func LoginClient(userName string, password string, deviceTag string, remMe bool,
 		loginCol = customLoginColumn
 	}
 
-	selectQuery = selectQuery[0:len(selectQuery)-2] + " FROM " + tableName + " WHERE " + loginCol + "=\"" + userName + "\" LIMIT 1;"
+	selectQuery = selectQuery[0:len(selectQuery)-2] + " FROM " + tableName + " WHERE " + loginCol + "=" + sqlDialect.quote(userName) + " LIMIT 1;"
 
 	//EXECUTE SELECT QUERY
-	checkRows, err := database.Query(selectQuery)
-	if err != nil {
+	checkRows, err := database.QueryContext(ctx, selectQuery)
+	if connectionLost(err) {
+		return "", 0, "", helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if err != nil {
 		return "", 0, "", helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
 	}
 	//
@@ -328,8 +463,14 @@ func LoginClient(userName string, password string, deviceTag string, remMe bool,
 	}
 
 	//COMPARE HASHED PASSWORDS
-	if !helpers.CompareEncryptedData(password, *dbPass) {
+	passOk, rehash := checkPassword(password, *dbPass)
+	if !passOk {
 		return "", 0, "", helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
+	} else if requireVerification && !accountVerified(*dbIndex) {
+		return "", 0, "", helpers.NewError(errorUnverified, helpers.ErrorAuthUnverified)
+	} else if rehash {
+		//UPGRADE THE HASH TO THE CURRENT PasswordHasher AND EncryptionCost
+		rehashPassword(*dbIndex, password, *dbPass)
 	}
 
 	//AUTO-LOGGING
@@ -339,14 +480,18 @@ func LoginClient(userName string, password string, deviceTag string, remMe bool,
 	if rememberMe && remMe {
 		//MAKE AUTO-LOG ENTRY
 		devicePass, devicePassErr = helpers.GenerateSecureString(32)
-		if devicePassErr == nil {
-			_, exErr := database.Exec("INSERT INTO " + tableAutologs + " (" + autologsColumnID + ", " + autologsColumnDeviceTag + ", " + autologsColumnDevicePass +
-				") VALUES (" + strconv.Itoa(*dbIndex) + ", \"" + deviceTag + "\", \"" + devicePass + "\");")
+		if devicePassErr == nil && !checkStringSQLInjection(deviceTag) {
+			//A DEVICE TAG STARTS A NEW SERIES, SO REPLACE ANY OLD ONE
+			RemoveAutoLog(*dbIndex, deviceTag)
+			now := strconv.FormatInt(time.Now().Unix(), 10)
+			_, exErr := execContext(ctx, "INSERT INTO "+tableAutologs+" ("+autologsColumnID+", "+autologsColumnDeviceTag+", "+autologsColumnDevicePass+
+				", "+autologsColumnCreated+", "+autologsColumnLastUsed+") VALUES ("+strconv.Itoa(*dbIndex)+", "+sqlDialect.quote(deviceTag)+
+				", "+sqlDialect.quote(devicePass)+", "+now+", "+now+");")
 			if exErr != nil {
-				////// LOG ERROR!!!!!!
+				helpers.Log().Error("Error saving auto-login", "user", *uName, "error", exErr)
 			}
-		} else {
-			////// LOG ERROR!!!!!!
+		} else if devicePassErr != nil {
+			helpers.Log().Error("Error making auto-login pass", "user", *uName, "error", devicePassErr)
 		}
 	}
 
@@ -354,6 +499,12 @@ func LoginClient(userName string, password string, deviceTag string, remMe bool,
 	return *uName, *dbIndex, devicePass, helpers.NoError()
 }
 
+//LOGS AN SQL ERROR, AND MAKES THE ErrorAuthQuery THE CLIENT GETS FOR IT
+func queryError(err error, userName string) helpers.GopherError {
+	helpers.Log().Error("SQL error", "user", userName, "error", err)
+	return helpers.NewError(err.Error(), helpers.ErrorAuthQuery)
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   AUTOLOGIN CLIENT   //////////////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -363,7 +514,9 @@ func LoginClient(userName string, password string, deviceTag string, remMe bool,
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to automatically
 // log in a client when using the "Remember Me" SQL feature.
 func AutoLoginClient(tag string, pass string, newPass string, dbID int) (string, helpers.GopherError) {
-	if checkStringSQLInjection(tag) {
+	if !Healthy() {
+		return "", helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if checkStringSQLInjection(tag) {
 		return "", helpers.NewError(errorMaliciousChars, helpers.ErrorAuthMaliciousChars)
 	}
 
@@ -372,8 +525,10 @@ func AutoLoginClient(tag string, pass string, newPass string, dbID int) (string,
 	db := database
 	tableName := tableAutologs
 	checkRows, checkErr := db.Query("Select " + autologsColumnDevicePass + " FROM " + tableName + " WHERE " + autologsColumnID + "=" + strconv.Itoa(dbID) + " AND " +
-		autologsColumnDeviceTag + "=\"" + tag + "\" LIMIT 1;")
-	if checkErr != nil {
+		autologsColumnDeviceTag + "=" + sqlDialect.quote(tag) + " LIMIT 1;")
+	if connectionLost(checkErr) {
+		return "", helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if checkErr != nil {
 		return "", helpers.NewError(errorInvalidAutoLog, helpers.ErrorDatabaseInvalidAutolog)
 	}
 	//
@@ -386,16 +541,20 @@ func AutoLoginClient(tag string, pass string, newPass string, dbID int) (string,
 
 	//COMPARE PASSES
 	if pass != dPass {
-		//SOMEONE TRIED TO COMPROMISE THIS KEY PAIR. DELETE IT NOW.
-		RemoveAutoLog(dbID, tag)
+		//AN OLD PASS FOR THIS SERIES WAS USED. SOMEONE COPIED THE KEY PAIR, SO DELETE THE SERIES NOW.
+		autoLoginTheft(dbID, tag)
 		return "", helpers.NewError(errorInvalidAutoLog, helpers.ErrorDatabaseInvalidAutolog)
 	}
 
-	//UPDATE TO NEW PASS
-	_, updateErr := db.Exec("UPDATE " + tableName + " SET " + autologsColumnDevicePass + "=\"" + newPass + "\" WHERE " + autologsColumnID + "=" + strconv.Itoa(dbID) + " AND " +
-		autologsColumnDeviceTag + "=\"" + tag + "\" LIMIT 1;")
+	//UPDATE TO NEW PASS. ONLY THE FIRST USE OF THE PASS MATCHES.
+	result, updateErr := exec("UPDATE " + tableName + " SET " + autologsColumnDevicePass + "=" + sqlDialect.quote(newPass) + ", " + autologsColumnLastUsed + "=" +
+		strconv.FormatInt(time.Now().Unix(), 10) + " WHERE " + autologsColumnID + "=" + strconv.Itoa(dbID) + " AND " + autologsColumnDeviceTag + "=" +
+		sqlDialect.quote(tag) + " AND " + autologsColumnDevicePass + "=" + sqlDialect.quote(pass) + sqlDialect.limitOne() + ";")
 	if updateErr != nil {
 		return "", helpers.NewError(errorInvalidAutoLog, helpers.ErrorDatabaseInvalidAutolog)
+	} else if rows, _ := result.RowsAffected(); rows == 0 {
+		autoLoginTheft(dbID, tag)
+		return "", helpers.NewError(errorInvalidAutoLog, helpers.ErrorDatabaseInvalidAutolog)
 	}
 
 	//EVERYTHING WENT WELL, GET THE User's NAME
@@ -434,7 +593,7 @@ func RemoveAutoLog(userID int, deviceTag string) {
 	if checkStringSQLInjection(deviceTag) {
 		return
 	}
-	database.Exec("DELETE FROM " + tableAutologs + " WHERE " + autologsColumnID + "=" + strconv.Itoa(userID) + " AND " + autologsColumnDeviceTag + "=\"" + deviceTag + "\" LIMIT 1;")
+	exec("DELETE FROM " + tableAutologs + " WHERE " + autologsColumnID + "=" + strconv.Itoa(userID) + " AND " + autologsColumnDeviceTag + "=" + sqlDialect.quote(deviceTag) + sqlDialect.limitOne() + ";")
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -446,7 +605,14 @@ func RemoveAutoLog(userID int, deviceTag string) {
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to change
 // a user's password when using the SQL features.
 func ChangePassword(userName string, password string, newPassword string, customCols map[string]interface{}) helpers.GopherError {
-	if len(userName) == 0 {
+	return ChangePasswordCtx(context.Background(), userName, password, newPassword, customCols)
+}
+
+// ChangePasswordCtx is the same as ChangePassword, but its queries are given up when ctx is done.
+func ChangePasswordCtx(ctx context.Context, userName string, password string, newPassword string, customCols map[string]interface{}) helpers.GopherError {
+	if !Healthy() {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if len(userName) == 0 {
 		return helpers.NewError(errorRequiredName, helpers.ErrorAuthRequiredName)
 	} else if len(password) == 0 {
 		return helpers.NewError(errorRequiredPass, helpers.ErrorAuthRequiredPass)
@@ -456,6 +622,8 @@ func ChangePassword(userName string, password string, newPassword string, custom
 		return helpers.NewError(errorMaliciousChars, helpers.ErrorAuthMaliciousChars)
 	} else if !checkCustomRequirements(customCols, customPasswordChangeRequirements) {
 		return helpers.NewError(errorIncorrectCols, helpers.ErrorAuthIncorrectCols)
+	} else if err := checkAccountInfoNames(customCols); err.ID != 0 {
+		return err
 	}
 
 	//FIRST TWO ARE id, password IN THAT ORDER
@@ -478,11 +646,13 @@ func ChangePassword(userName string, password string, newPassword string, custom
 		vals = make([]interface{}, 0, 2)
 		vals = append(vals, new(int), new([]byte))
 	}
-	selectQuery = selectQuery[0:len(selectQuery)-2] + " FROM " + tableUsers + " WHERE " + usersColumnName + "=\"" + userName + "\" LIMIT 1;"
+	selectQuery = selectQuery[0:len(selectQuery)-2] + " FROM " + tableUsers + " WHERE " + usersColumnName + "=" + sqlDialect.quote(userName) + " LIMIT 1;"
 
 	//EXECUTE SELECT QUERY
-	checkRows, err := database.Query(selectQuery)
-	if err != nil {
+	checkRows, err := database.QueryContext(ctx, selectQuery)
+	if connectionLost(err) {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if err != nil {
 		return helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
 	}
 	//
@@ -498,7 +668,7 @@ func ChangePassword(userName string, password string, newPassword string, custom
 	dbPass := *(vals[1]).(*[]byte)
 
 	//COMPARE HASHED PASSWORDS
-	if !helpers.CompareEncryptedData(password, dbPass) {
+	if passOk, _ := checkPassword(password, dbPass); !passOk {
 		return helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
 	}
 
@@ -521,15 +691,18 @@ func ChangePassword(userName string, password string, newPassword string, custom
 	}
 
 	//ENCRYPT NEW PASSWORD
-	passHash, hashErr := helpers.EncryptString(newPassword, encryptionCost)
+	passHash, hashErr := hashPassword(newPassword)
 	if hashErr != nil {
 		return helpers.NewError(hashErr.Error(), helpers.ErrorAuthEncryption)
 	}
 
 	//UPDATE THE PASSWORD
-	_, updateErr := database.Exec("UPDATE " + tableUsers + " SET " + usersColumnPassword + "=\"" + passHash + "\" WHERE " + usersColumnID + "=" + strconv.Itoa(dbIndex) + " LIMIT 1;")
+	_, updateErr := execContext(ctx, "UPDATE "+tableUsers+" SET "+usersColumnPassword+"="+sqlDialect.quote(passHash)+" WHERE "+usersColumnID+"="+strconv.Itoa(dbIndex)+sqlDialect.limitOne()+";")
 	if updateErr != nil {
-		return helpers.NewError(updateErr.Error(), helpers.ErrorAuthQuery)
+		if connectionLost(updateErr) {
+			return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+		}
+		return queryError(updateErr, userName)
 	}
 
 	//
@@ -545,7 +718,14 @@ func ChangePassword(userName string, password string, newPassword string, custom
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to change
 // a user's AccountInfoColumn when using the SQL features.
 func ChangeAccountInfo(userName string, password string, customCols map[string]interface{}) helpers.GopherError {
-	if len(userName) == 0 {
+	return ChangeAccountInfoCtx(context.Background(), userName, password, customCols)
+}
+
+// ChangeAccountInfoCtx is the same as ChangeAccountInfo, but its queries are given up when ctx is done.
+func ChangeAccountInfoCtx(ctx context.Context, userName string, password string, customCols map[string]interface{}) helpers.GopherError {
+	if !Healthy() {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if len(userName) == 0 {
 		return helpers.NewError(errorRequiredName, helpers.ErrorAuthRequiredName)
 	} else if len(password) == 0 {
 		return helpers.NewError(errorRequiredPass, helpers.ErrorAuthRequiredPass)
@@ -557,6 +737,12 @@ func ChangeAccountInfo(userName string, password string, customCols map[string]i
 		return helpers.NewError(errorIncorrectCols, helpers.ErrorAuthIncorrectCols)
 	}
 
+	//VALIDATE THE AccountInfoColumn VALUES
+	values, valuesErr := validateAccountInfo(ctx, userName, customCols, false)
+	if valuesErr.ID != 0 {
+		return valuesErr
+	}
+
 	//FIRST TWO ARE id, password IN THAT ORDER
 	var vals []interface{}
 	var valsList []interface{}
@@ -577,11 +763,13 @@ func ChangeAccountInfo(userName string, password string, customCols map[string]i
 		vals = make([]interface{}, 0, 2)
 		vals = append(vals, new(int), new([]byte))
 	}
-	selectQuery = selectQuery[0:len(selectQuery)-2] + " FROM " + tableUsers + " WHERE " + usersColumnName + "=\"" + userName + "\" LIMIT 1;"
+	selectQuery = selectQuery[0:len(selectQuery)-2] + " FROM " + tableUsers + " WHERE " + usersColumnName + "=" + sqlDialect.quote(userName) + " LIMIT 1;"
 
 	//EXECUTE SELECT QUERY
-	checkRows, err := database.Query(selectQuery)
-	if err != nil {
+	checkRows, err := database.QueryContext(ctx, selectQuery)
+	if connectionLost(err) {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if err != nil {
 		return helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
 	}
 	//
@@ -597,7 +785,7 @@ func ChangeAccountInfo(userName string, password string, customCols map[string]i
 	dbPass := *(vals[1]).(*[]byte)
 
 	//COMPARE HASHED PASSWORDS
-	if !helpers.CompareEncryptedData(password, dbPass) {
+	if passOk, _ := checkPassword(password, dbPass); !passOk {
 		return helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
 	}
 
@@ -622,21 +810,114 @@ func ChangeAccountInfo(userName string, password string, customCols map[string]i
 	//MAKE UPDATE QUERY
 	updateQuery := "UPDATE " + tableUsers + " SET "
 	for i := 0; i < len(valsList); i++ {
-		dt := valsList[i].([]interface{})[1].(int)
-		//GET STRING VALUE & CHECK FOR INJECTIONS
-		value, valueErr := convertDataToString(dataTypes[dt], valsList[i].([]interface{})[0])
-		if valueErr != nil {
-			return helpers.NewError(valueErr.Error(), helpers.ErrorAuthConversion)
+		key := valsList[i].([]interface{})[2].(string)
+		value := values[key]
+		//CHECK FOR ENCRYPT
+		if customAccountInfo[key].encrypt {
+			hash, hashErr := helpers.EncryptString(value, encryptionCost)
+			if hashErr != nil {
+				return helpers.NewError(hashErr.Error(), helpers.ErrorAuthEncryption)
+			}
+			value = sqlDialect.quote(hash)
 		}
 		//
-		updateQuery = updateQuery + valsList[i].([]interface{})[2].(string) + "=" + value + ", "
+		updateQuery = updateQuery + key + "=" + value + ", "
 	}
-	updateQuery = updateQuery[0:len(updateQuery)-2] + " WHERE " + usersColumnID + "=" + strconv.Itoa(dbIndex) + " LIMIT 1;"
+	updateQuery = updateQuery[0:len(updateQuery)-2] + " WHERE " + usersColumnID + "=" + strconv.Itoa(dbIndex) + sqlDialect.limitOne() + ";"
 
 	//EXECUTE THE UPDATE QUERY
-	_, updateErr := database.Exec(updateQuery)
+	_, updateErr := execContext(ctx, updateQuery)
 	if updateErr != nil {
-		return helpers.NewError(updateErr.Error(), helpers.ErrorAuthQuery)
+		if connectionLost(updateErr) {
+			return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+		}
+		return queryError(updateErr, userName)
+	}
+
+	//
+	return helpers.NoError()
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CHANGE ACCOUNT NAME   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ChangeAccountName renames the account with the database index dbID to newName, after checking its password. The friends
+// and auto-login tables go by the account's database index, so the account keeps its friends and remembered devices. With a
+// CustomLoginColumn, the User keeps logging in with it.
+//
+// WARNING: This only changes the database. Use *User.ChangeAccountName() to rename a User that is logged in.
+func ChangeAccountName(dbID int, newName string, password string) helpers.GopherError {
+	return ChangeAccountNameCtx(context.Background(), dbID, newName, password)
+}
+
+// ChangeAccountNameCtx is the same as ChangeAccountName, but its queries are given up when ctx is done.
+func ChangeAccountNameCtx(ctx context.Context, dbID int, newName string, password string) helpers.GopherError {
+	if !Healthy() {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if len(newName) == 0 {
+		return helpers.NewError(errorRequiredName, helpers.ErrorAuthRequiredName)
+	} else if len(password) == 0 {
+		return helpers.NewError(errorRequiredPass, helpers.ErrorAuthRequiredPass)
+	}
+	newName, nameErr := helpers.CheckName(newName)
+	if nameErr.ID != 0 {
+		return nameErr
+	} else if checkStringSQLInjection(newName) {
+		return helpers.NewError(errorMaliciousChars, helpers.ErrorAuthMaliciousChars)
+	}
+
+	//GET THE ACCOUNT'S NAME AND PASSWORD
+	var oldName string
+	var dbPass []byte
+	err := database.QueryRowContext(ctx, "SELECT "+usersColumnName+", "+usersColumnPassword+" FROM "+tableUsers+" WHERE "+
+		usersColumnID+"="+strconv.Itoa(dbID)+";").Scan(&oldName, &dbPass)
+	if connectionLost(err) {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if err != nil {
+		return helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
+	} else if passOk, _ := checkPassword(password, dbPass); !passOk {
+		return helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
+	} else if newName == oldName {
+		return helpers.NoError()
+	}
+
+	//THE NEW NAME MUST BE FREE
+	if taken, takenErr := AccountExists(ctx, newName); takenErr != nil {
+		if connectionLost(takenErr) {
+			return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+		}
+		return queryError(takenErr, oldName)
+	} else if taken {
+		return helpers.NewError(errorNameUnavail, helpers.ErrorAuthNameUnavail)
+	}
+	if helpers.UniqueNames() {
+		if lookalike, lookalikeErr := nameLookalikeExists(ctx, newName, dbID); lookalikeErr != nil {
+			if connectionLost(lookalikeErr) {
+				return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+			}
+			return queryError(lookalikeErr, oldName)
+		} else if lookalike {
+			return helpers.NameConfusableError()
+		}
+	}
+
+	//RUN CALLBACK
+	if NameChangeCallback != nil && !NameChangeCallback(oldName, newName, dbID) {
+		return helpers.NewError(errorDenied, helpers.ErrorActionDenied)
+	}
+
+	//RENAME THE ACCOUNT
+	if _, updateErr := execContext(ctx, "UPDATE "+tableUsers+" SET "+usersColumnName+"="+sqlDialect.quote(newName)+", "+
+		usersColumnSkeleton+"="+sqlDialect.quote(helpers.NameSkeleton(newName))+" WHERE "+
+		usersColumnID+"="+strconv.Itoa(dbID)+sqlDialect.limitOne()+";"); updateErr != nil {
+		if connectionLost(updateErr) {
+			return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+		} else if taken, _ := AccountExists(ctx, newName); taken {
+			// TAKEN BY A SIGN UP SINCE IT WAS CHECKED
+			return helpers.NewError(errorNameUnavail, helpers.ErrorAuthNameUnavail)
+		}
+		return queryError(updateErr, oldName)
 	}
 
 	//
@@ -652,7 +933,14 @@ func ChangeAccountInfo(userName string, password string, customCols map[string]i
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to delete a
 // user's account when using the SQL features.
 func DeleteAccount(userName string, password string, customCols map[string]interface{}) helpers.GopherError {
-	if len(userName) == 0 {
+	return DeleteAccountCtx(context.Background(), userName, password, customCols)
+}
+
+// DeleteAccountCtx is the same as DeleteAccount, but its queries are given up when ctx is done.
+func DeleteAccountCtx(ctx context.Context, userName string, password string, customCols map[string]interface{}) helpers.GopherError {
+	if !Healthy() {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if len(userName) == 0 {
 		return helpers.NewError(errorRequiredName, helpers.ErrorAuthRequiredName)
 	} else if len(password) == 0 {
 		return helpers.NewError(errorRequiredPass, helpers.ErrorAuthRequiredPass)
@@ -660,6 +948,8 @@ func DeleteAccount(userName string, password string, customCols map[string]inter
 		return helpers.NewError(errorMaliciousChars, helpers.ErrorAuthMaliciousChars)
 	} else if !checkCustomRequirements(customCols, customDeleteAccountRequirements) {
 		return helpers.NewError(errorIncorrectCols, helpers.ErrorAuthIncorrectCols)
+	} else if err := checkAccountInfoNames(customCols); err.ID != 0 {
+		return err
 	}
 
 	//FIRST TWO ARE id, password IN THAT ORDER
@@ -679,11 +969,13 @@ func DeleteAccount(userName string, password string, customCols map[string]inter
 		vals = make([]interface{}, 0, 2)
 		vals = append(vals, new(int), new([]byte))
 	}
-	selectQuery = selectQuery[0:len(selectQuery)-2] + " FROM " + tableUsers + " WHERE " + usersColumnName + "=\"" + userName + "\" LIMIT 1;"
+	selectQuery = selectQuery[0:len(selectQuery)-2] + " FROM " + tableUsers + " WHERE " + usersColumnName + "=" + sqlDialect.quote(userName) + " LIMIT 1;"
 
 	//EXECUTE SELECT QUERY
-	checkRows, err := database.Query(selectQuery)
-	if err != nil {
+	checkRows, err := database.QueryContext(ctx, selectQuery)
+	if connectionLost(err) {
+		return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+	} else if err != nil {
 		return helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
 	}
 	//
@@ -699,7 +991,7 @@ func DeleteAccount(userName string, password string, customCols map[string]inter
 	dbPass := *(vals[1]).(*[]byte)
 
 	//COMPARE HASHED PASSWORDS
-	if !helpers.CompareEncryptedData(password, dbPass) {
+	if passOk, _ := checkPassword(password, dbPass); !passOk {
 		return helpers.NewError(errorIncorrectLogin, helpers.ErrorAuthIncorrectLogin)
 	}
 
@@ -721,15 +1013,48 @@ func DeleteAccount(userName string, password string, customCols map[string]inter
 		}
 	}
 
-	//REMOVE INSTANCES FROM friends TABLE
-	database.Exec("DELETE FROM " + tableFriends + " WHERE " + friendsColumnUser + "=" + strconv.Itoa(dbIndex) + " OR " + friendsColumnFriend + "=" + strconv.Itoa(dbIndex) + ";")
+	//DELETE THE ACCOUNT AND EVERYTHING TIED TO IT
+	if deleteErr := purgeAccount(ctx, dbIndex, userName); deleteErr != nil {
+		if connectionLost(deleteErr) {
+			return helpers.NewError(errorUnavailable, helpers.ErrorDatabaseUnavailable)
+		}
+		return queryError(deleteErr, userName)
+	}
 
-	//DELETE THE ACCOUNT
-	_, deleteErr := database.Exec("DELETE FROM " + tableUsers + " WHERE " + usersColumnID + "=" + strconv.Itoa(dbIndex) + " LIMIT 1;")
-	if deleteErr != nil {
-		return helpers.NewError(deleteErr.Error(), helpers.ErrorAuthQuery)
+	//LET THE SERVER CLEAN UP ITS OWN TABLES
+	if AccountPurgeCallback != nil {
+		AccountPurgeCallback(dbIndex, userName)
 	}
 
 	//
 	return helpers.NoError()
 }
+
+// purgeAccount deletes an account, its friends in both directions, its auto-logins, the ban on its name and its place in saved
+// games in a transaction, so either all of it is deleted or none of it is.
+func purgeAccount(ctx context.Context, dbIndex int, userName string) error {
+	if sqlDialect.serializeWrites() {
+		writeMux.Lock()
+		defer writeMux.Unlock()
+	}
+	id := strconv.Itoa(dbIndex)
+	queries := []string{
+		"DELETE FROM " + tableFriends + " WHERE " + sqlDialect.ident(friendsColumnUser) + "=" + id + " OR " + friendsColumnFriend + "=" + id + ";",
+		"DELETE FROM " + tableAutologs + " WHERE " + autologsColumnID + "=" + id + ";",
+		"DELETE FROM " + tableBans + " WHERE " + bansColumnKind + "=" + sqlDialect.quote(banKindName) + " AND " + bansColumnTarget + "=" +
+			sqlDialect.quote(userName) + ";",
+		"DELETE FROM " + tableUsers + " WHERE " + usersColumnID + "=" + id + sqlDialect.limitOne() + ";",
+	}
+	queries = append(purgeSavedGamesQueries(id), queries...)
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, query := range queries {
+		if _, err := tx.ExecContext(ctx, query); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+// BanRecord is only for internal Gopher Game Server mechanics.
+type BanRecord struct {
+	Kind    string
+	Target  string
+	Reason  string
+	Created int64 // UNIX SECONDS
+	Expires int64 // UNIX SECONDS, OR 0 FOR NEVER
+}
+
+// SaveBan is only for internal Gopher Game Server mechanics.
+func SaveBan(ban BanRecord) error {
+	// THE REASON IS BOUND AS AN ARGUMENT, SO IT CAN HAVE ANY CHARACTERS
+	if checkStringSQLInjection(ban.Kind) || checkStringSQLInjection(ban.Target) {
+		return errors.New("Malicious characters detected")
+	}
+	if sqlDialect.serializeWrites() {
+		writeMux.Lock()
+		defer writeMux.Unlock()
+	}
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	// REPLACE THE OLD BAN ON THE TARGET, IF ANY
+	_, err = tx.Exec(removeBanQuery(), ban.Kind, ban.Target)
+	if err == nil {
+		_, err = tx.Exec("INSERT INTO "+tableBans+" ("+bansColumnKind+", "+bansColumnTarget+", "+bansColumnReason+", "+
+			bansColumnCreated+", "+bansColumnExpires+") VALUES ("+sqlDialect.placeholder(1)+", "+sqlDialect.placeholder(2)+", "+
+			sqlDialect.placeholder(3)+", "+sqlDialect.placeholder(4)+", "+sqlDialect.placeholder(5)+");",
+			ban.Kind, ban.Target, ban.Reason, ban.Created, ban.Expires)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveBan is only for internal Gopher Game Server mechanics.
+func RemoveBan(kind string, target string) error {
+	if checkStringSQLInjection(kind) || checkStringSQLInjection(target) {
+		return errors.New("Malicious characters detected")
+	}
+	_, err := execContext(context.Background(), removeBanQuery(), kind, target)
+	return err
+}
+
+// RemoveExpiredBan is only for internal Gopher Game Server mechanics.
+func RemoveExpiredBan(kind string, target string, expires int64) error {
+	if checkStringSQLInjection(kind) || checkStringSQLInjection(target) {
+		return errors.New("Malicious characters detected")
+	}
+	// A BAN THAT REPLACED IT SINCE HAS ANOTHER EXPIRY, AND STAYS
+	_, err := execContext(context.Background(), "DELETE FROM "+tableBans+" WHERE "+bansColumnKind+"="+sqlDialect.placeholder(1)+
+		" AND "+bansColumnTarget+"="+sqlDialect.placeholder(2)+" AND "+bansColumnExpires+"="+sqlDialect.placeholder(3)+";",
+		kind, target, expires)
+	return err
+}
+
+// removeBanQuery deletes the ban on a target. Its arguments are the kind and the target.
+func removeBanQuery() string {
+	return "DELETE FROM " + tableBans + " WHERE " + bansColumnKind + "=" + sqlDialect.placeholder(1) + " AND " +
+		bansColumnTarget + "=" + sqlDialect.placeholder(2) + ";"
+}
+
+// GetBans is only for internal Gopher Game Server mechanics.
+func GetBans() ([]BanRecord, error) {
+	rows, err := database.Query("SELECT " + bansColumnKind + ", " + bansColumnTarget + ", " + bansColumnReason + ", " + bansColumnCreated +
+		", " + bansColumnExpires + " FROM " + tableBans + ";")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	bans := []BanRecord{}
+	for rows.Next() {
+		var ban BanRecord
+		if scanErr := rows.Scan(&ban.Kind, &ban.Target, &ban.Reason, &ban.Created, &ban.Expires); scanErr != nil {
+			return nil, scanErr
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
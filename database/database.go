@@ -5,17 +5,22 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"fmt"
 	_ "github.com/go-sql-driver/mysql" // Github project page specifies to use blank import
+	"github.com/hewiefreeman/GopherGameServer/helpers"
 	"strconv"
+	"sync"
 )
 
 var (
 	//THE DATABASE
 	database *sql.DB
 
+	//SERIALIZES WRITES FOR DATABASES THAT CAN'T HANDLE CONCURRENT WRITERS
+	writeMux sync.Mutex
+
 	//SERVER SETTINGS
 	serverStarted bool   = false
 	serverPaused  bool   = false
@@ -29,12 +34,21 @@ const (
 	tableUsers    = "users"
 	tableFriends  = "friends"
 	tableAutologs = "autologs"
+	tableBans     = "bans"
 
 	//users TABLE COLUMNS
 	usersColumnID       = "_id"
 	usersColumnName     = "name"
 	usersColumnPassword = "pass"
 
+	//users TABLE COLUMN FOR WHAT THE NAME LOOKS LIKE
+	usersColumnSkeleton = "skeleton"
+
+	//users TABLE COLUMNS FOR EMAIL VERIFICATION
+	usersColumnVerified      = "verified"
+	usersColumnVerifyToken   = "vtoken"
+	usersColumnVerifyExpires = "vexpires"
+
 	//friends TABLE COLUMNS
 	friendsColumnUser   = "user"
 	friendsColumnFriend = "friend"
@@ -44,20 +58,37 @@ const (
 	autologsColumnID         = "_id"
 	autologsColumnDeviceTag  = "dn"
 	autologsColumnDevicePass = "da"
+	autologsColumnCreated    = "dc"
+	autologsColumnLastUsed   = "du"
+
+	//bans TABLE COLUMNS
+	bansColumnKind    = "kind"
+	bansColumnTarget  = "target"
+	bansColumnReason  = "reason"
+	bansColumnCreated = "created"
+	bansColumnExpires = "expires"
+
+	// THE Kind OF THE BANS ON ACCOUNT NAMES, THE SAME AS IN core
+	banKindName = "n"
 )
 
 // Init initializes the database connection and sets up the database according to your custom parameters.
+// With DriverSQLite, dbName is the path to the database file, which is made if it doesn't exist, and the
+// user name, password, protocol, ip and port are not used.
 //
 // WARNING: This is only meant for internal Gopher Game Server mechanics. If you want to enable SQL authorization
 // and friending, use the EnableSqlFeatures and corresponding options in ServerSetting.
-func Init(userName string, password string, dbName string, protocol string, ip string, port int, encryptCost int, remMe bool, custLoginCol string) error {
-	if inited {
+func Init(driver string, userName string, password string, dbName string, protocol string, ip string, port int, encryptCost int, remMe bool, custLoginCol string) error {
+	d, driverErr := getDialect(driver)
+	if driverErr != nil {
+		return driverErr
+	} else if inited {
 		return errors.New("sql package is already initialized")
-	} else if len(userName) == 0 {
+	} else if len(userName) == 0 && driver != DriverSQLite {
 		return errors.New("sql.Start() requires a user name")
-	} else if len(password) == 0 {
+	} else if len(password) == 0 && driver != DriverSQLite {
 		return errors.New("sql.Start() requires a password")
-	} else if len(userName) == 0 {
+	} else if len(dbName) == 0 {
 		return errors.New("sql.Start() requires a database name")
 	} else if len(custLoginCol) > 0 {
 		if _, ok := customAccountInfo[custLoginCol]; !ok {
@@ -69,18 +100,20 @@ func Init(userName string, password string, dbName string, protocol string, ip s
 	if encryptCost >= 4 && encryptCost <= 31 {
 		encryptionCost = encryptCost
 	} else if encryptCost != 0 {
-		fmt.Println("EncryptionCost must be a minimum of 4, and max of 31. Setting to default: 4")
+		helpers.Log().Warn("EncryptionCost must be a minimum of 4, and max of 31. Setting to default: 4")
 	}
 
 	rememberMe = remMe
+	sqlDialect = d
 
 	var err error
 
 	//OPEN THE DATABASE
-	database, err = sql.Open("mysql", userName+":"+password+"@"+protocol+"("+ip+":"+strconv.Itoa(port)+")/"+dbName)
+	database, err = sql.Open(sqlDialect.driverName(), sqlDialect.dsn(userName, password, dbName, protocol, ip, port))
 	if err != nil {
 		return err
 	}
+	configurePool()
 	//NOTE: Open doesn't open a connection.
 	//MUST PING TO CHECK IF FOUND DATABASE
 	err = database.Ping()
@@ -100,6 +133,8 @@ func Init(userName string, password string, dbName string, protocol string, ip s
 
 	//
 	inited = true
+	startHealthWatch()
+	startWriteQueue()
 
 	//
 	return nil
@@ -115,7 +150,7 @@ func GetUserDatabaseIndex(userName string) (int, error) {
 		return 0, errors.New("Malicious characters detected")
 	}
 	var id int
-	rows, err := database.Query("SELECT " + usersColumnID + " FROM " + tableUsers + " WHERE " + usersColumnName + "=\"" + userName + "\" LIMIT 1;")
+	rows, err := database.Query("SELECT " + usersColumnID + " FROM " + tableUsers + " WHERE " + usersColumnName + "=" + sqlDialect.quote(userName) + " LIMIT 1;")
 	if err != nil {
 		return 0, err
 	}
@@ -131,15 +166,74 @@ func GetUserDatabaseIndex(userName string) (int, error) {
 	return id, nil
 }
 
+// AccountExists checks if there is an account with the name on the database, like before letting a guest User use it.
+func AccountExists(ctx context.Context, userName string) (bool, error) {
+	var count int
+	if err := database.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableUsers+" WHERE "+usersColumnName+"="+
+		sqlDialect.quote(userName)+";").Scan(&count); err != nil {
+		connectionLost(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// NameLookalikeExists checks if there is an account on the database with a name that looks like the name, but isn't it, like
+// before letting a guest User use it. See helpers.NameSkeleton().
+func NameLookalikeExists(ctx context.Context, userName string) (bool, error) {
+	return nameLookalikeExists(ctx, userName, -1)
+}
+
+// nameLookalikeExists is NameLookalikeExists, leaving out the account with the database index exceptID.
+func nameLookalikeExists(ctx context.Context, userName string, exceptID int) (bool, error) {
+	var count int
+	if err := database.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+tableUsers+" WHERE "+usersColumnSkeleton+"="+
+		sqlDialect.quote(helpers.NameSkeleton(userName))+" AND "+usersColumnName+"<>"+sqlDialect.quote(userName)+" AND "+
+		usersColumnID+"<>"+strconv.Itoa(exceptID)+";").Scan(&count); err != nil {
+		connectionLost(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+//EXECUTES A QUERY THAT WRITES TO THE DATABASE
+func exec(query string) (sql.Result, error) {
+	return execContext(context.Background(), query)
+}
+
+//EXECUTES A QUERY THAT WRITES TO THE DATABASE, UNLESS ctx IS DONE FIRST
+func execContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if sqlDialect.serializeWrites() {
+		writeMux.Lock()
+		defer writeMux.Unlock()
+	}
+	return database.ExecContext(ctx, query, args...)
+}
+
+// Close is only for internal Gopher Game Server mechanics.
+func Close() error {
+	if !inited {
+		return nil
+	}
+	drainWriteQueue()
+	stopHealthWatch()
+	writeMux.Lock()
+	defer writeMux.Unlock()
+	if query := sqlDialect.checkpointQuery(); query != "" {
+		if _, err := database.Exec(query); err != nil {
+			helpers.Log().Error("Database checkpoint error", "error", err)
+		}
+	}
+	inited = false
+	return database.Close()
+}
+
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 //   SERVER STARTUP FUNCTIONS   ///////////////////////////////////////////////////////////////////////////////////
 ///////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // SetServerStarted is for Gopher Game Server internal mechanics only.
 func SetServerStarted(val bool) {
-	if !serverStarted {
-		serverStarted = val
-	}
+	serverStarted = val
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -0,0 +1,136 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strconv"
+	"time"
+)
+
+// DeviceToken represents a device that a User can be automatically logged in on with RememberMe enabled in ServerSettings.
+type DeviceToken struct {
+	// ID identifies the device for RevokeDevice(). It is not the device's auto-login data, so it is safe to show to the User.
+	ID string
+	// Created is when the User logged in with "Remember Me" on the device. It is the zero time for devices that were
+	// remembered before it was stored.
+	Created time.Time
+	// LastUsed is when the device last logged in automatically, or when it was created if it never has.
+	LastUsed time.Time
+}
+
+var (
+	// AutoLoginTheftCallback is only for internal Gopher Game Server mechanics.
+	AutoLoginTheftCallback func(string, int)
+)
+
+//ADDS THE DEVICE TIME COLUMNS TO AN autologs TABLE MADE BEFORE THEY EXISTED
+func addAutologColumnsSQL(r sqlRunner) error {
+	for _, col := range []string{autologsColumnCreated, autologsColumnLastUsed} {
+		if exists, err := columnExists(r, tableAutologs, col); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		helpers.Log().Info("Adding autologs column '" + col + "'...")
+		if _, err := r.Exec("ALTER TABLE " + tableAutologs + " ADD COLUMN " + col + " BIGINT NOT NULL DEFAULT 0;"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeviceTokenID is only for internal Gopher Game Server mechanics.
+func DeviceTokenID(deviceTag string) string {
+	sum := sha256.Sum256([]byte(deviceTag))
+	return hex.EncodeToString(sum[:8])
+}
+
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
+
+// GetDevices gets all the devices the User with the database index dbID can be automatically logged in on with RememberMe
+// enabled in ServerSettings.
+func GetDevices(dbID int) ([]DeviceToken, error) {
+	if !rememberMe {
+		return nil, errors.New("RememberMe in ServerSettings is not enabled")
+	}
+	rows, err := database.Query("SELECT " + autologsColumnDeviceTag + ", " + autologsColumnCreated + ", " + autologsColumnLastUsed +
+		" FROM " + tableAutologs + " WHERE " + autologsColumnID + "=" + strconv.Itoa(dbID) + ";")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	devices := []DeviceToken{}
+	for rows.Next() {
+		var tag string
+		var created, lastUsed int64
+		if scanErr := rows.Scan(&tag, &created, &lastUsed); scanErr != nil {
+			return nil, scanErr
+		}
+		devices = append(devices, DeviceToken{ID: DeviceTokenID(tag), Created: unixTime(created), LastUsed: unixTime(lastUsed)})
+	}
+	return devices, rows.Err()
+}
+
+// RevokeDevice stops the device with the DeviceToken ID deviceID from automatically logging in the User with the database
+// index dbID. The device has to log in with a password again. A User that is logged in on the device stays logged in.
+func RevokeDevice(dbID int, deviceID string) error {
+	if !rememberMe {
+		return errors.New("RememberMe in ServerSettings is not enabled")
+	}
+	rows, err := database.Query("SELECT " + autologsColumnDeviceTag + " FROM " + tableAutologs + " WHERE " + autologsColumnID + "=" +
+		strconv.Itoa(dbID) + ";")
+	if err != nil {
+		return err
+	}
+	var tag string
+	found := false
+	for rows.Next() {
+		if scanErr := rows.Scan(&tag); scanErr != nil {
+			rows.Close()
+			return scanErr
+		} else if DeviceTokenID(tag) == deviceID {
+			found = true
+			break
+		}
+	}
+	rows.Close()
+	if !found {
+		return errors.New("The device '" + deviceID + "' does not exist")
+	}
+	_, err = exec("DELETE FROM " + tableAutologs + " WHERE " + autologsColumnID + "=" + strconv.Itoa(dbID) + " AND " +
+		autologsColumnDeviceTag + "=" + sqlDialect.quote(tag) + ";")
+	return err
+}
+
+// RevokeAllDevices stops every device from automatically logging in the User with the database index dbID.
+func RevokeAllDevices(dbID int) error {
+	if !rememberMe {
+		return errors.New("RememberMe in ServerSettings is not enabled")
+	}
+	_, err := exec("DELETE FROM " + tableAutologs + " WHERE " + autologsColumnID + "=" + strconv.Itoa(dbID) + ";")
+	return err
+}
+
+// autoLoginTheft invalidates a device's auto-login series after one of its passes was used twice, which means someone
+// copied the device's auto-login data. Whoever uses it next, the User or the thief, has to log in with a password again.
+func autoLoginTheft(dbID int, tag string) {
+	helpers.Log().Warn("Auto-login data was used twice, removing the device", "id", dbID, "device", DeviceTokenID(tag))
+	exec("DELETE FROM " + tableAutologs + " WHERE " + autologsColumnID + "=" + strconv.Itoa(dbID) + " AND " +
+		autologsColumnDeviceTag + "=" + sqlDialect.quote(tag) + ";")
+	if AutoLoginTheftCallback == nil {
+		return
+	}
+	var userName string
+	if err := database.QueryRow("SELECT " + usersColumnName + " FROM " + tableUsers + " WHERE " + usersColumnID + "=" +
+		strconv.Itoa(dbID) + ";").Scan(&userName); err != nil {
+		return
+	}
+	AutoLoginTheftCallback(userName, dbID)
+}
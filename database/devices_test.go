@@ -0,0 +1,96 @@
+package database
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"testing"
+)
+
+func TestAutoLoginRotation(t *testing.T) {
+	var thefts []string
+	AutoLoginTheftCallback = func(userName string, dbID int) {
+		thefts = append(thefts, userName)
+	}
+	defer func() { AutoLoginTheftCallback = nil }()
+	testSQLite(t)
+	if err := SignUpClient("gopher", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	_, dbID, pass, err := LoginClient("gopher", "secret", "phone", true, nil)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+
+	// Each auto-login replaces the pass
+	if _, err := AutoLoginClient("phone", pass, "second", dbID); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	if _, err := AutoLoginClient("phone", "second", "third", dbID); err.ID != 0 {
+		t.Fatal(err.Message)
+	} else if len(thefts) != 0 {
+		t.Error("Auto-logins with the current pass should not be thefts")
+	}
+
+	// Using a replaced pass invalidates the series
+	if _, err := AutoLoginClient("phone", "second", "stolen", dbID); err.ID == 0 {
+		t.Error("A replaced pass should not auto-login")
+	} else if len(thefts) != 1 || thefts[0] != "gopher" {
+		t.Error("Expected one theft for gopher, got", thefts)
+	}
+	if _, err := AutoLoginClient("phone", "third", "fourth", dbID); err.ID == 0 {
+		t.Error("The series should be invalidated after a theft")
+	}
+	if devices, _ := GetDevices(dbID); len(devices) != 0 {
+		t.Error("The stolen device should be removed, got", len(devices), "devices")
+	}
+}
+
+func TestDevices(t *testing.T) {
+	testSQLite(t)
+	if err := SignUpClient("gopher", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	var dbID int
+	var lastPass string
+	for _, tag := range []string{"phone", "laptop", "phone", "tablet"} {
+		var err helpers.GopherError
+		if _, dbID, lastPass, err = LoginClient("gopher", "secret", tag, true, nil); err.ID != 0 {
+			t.Fatal(err.Message)
+		}
+	}
+
+	devices, err := GetDevices(dbID)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(devices) != 3 {
+		t.Fatal("Logging in again on a device should replace its series, expected 3 devices, got", len(devices))
+	}
+	for _, device := range devices {
+		if device.ID == "phone" || device.ID == "laptop" || device.ID == "tablet" {
+			t.Error("A DeviceToken ID should not be the device tag")
+		} else if device.Created.IsZero() || device.LastUsed.Before(device.Created) {
+			t.Error("Unexpected device times", device.Created, device.LastUsed)
+		}
+	}
+
+	// Revoke one device
+	if err := RevokeDevice(dbID, "unknown"); err == nil {
+		t.Error("RevokeDevice() should fail for an unknown device")
+	}
+	if err := RevokeDevice(dbID, DeviceTokenID("tablet")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := AutoLoginClient("tablet", lastPass, "newPass", dbID); err.ID == 0 {
+		t.Error("A revoked device should not auto-login")
+	}
+	if devices, _ := GetDevices(dbID); len(devices) != 2 {
+		t.Error("Expected 2 devices after revoking one, got", len(devices))
+	}
+
+	// Revoke all devices
+	if err := RevokeAllDevices(dbID); err != nil {
+		t.Fatal(err)
+	}
+	if devices, _ := GetDevices(dbID); len(devices) != 0 {
+		t.Error("Expected no devices after revoking all of them, got", len(devices))
+	}
+}
@@ -0,0 +1,303 @@
+package database
+
+import (
+	"errors"
+	_ "github.com/lib/pq"  // Github project page specifies to use blank import
+	_ "modernc.org/sqlite" // Github project page specifies to use blank import
+	"strconv"
+	"strings"
+)
+
+// The SQL drivers you can use with database.Init(). Set one to SqlDriver in ServerSettings.
+const (
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// dialect holds the few query differences between the supported SQL drivers. Every query in the database package
+// must build its string literals, id column, column types and UPDATE/DELETE limits through the current dialect.
+type dialect interface {
+	driverName() string
+	dsn(userName string, password string, dbName string, protocol string, ip string, port int) string
+	quote(value string) string   // makes a string literal
+	quoteMark() string           // the character quote() wraps literals with
+	placeholder(n int) string    // the placeholder for a query's nth argument, from 1
+	ident(name string) string    // makes an identifier that might be a reserved word
+	idColumn(name string) string // the auto-incrementing primary key column definition
+	resetAutoIncrement(table string) string
+	limitOne() string // limits an UPDATE or DELETE to one row
+	tableExistsQuery(table string) string
+	columnExistsQuery(table string, column string) string
+	addUniqueQuery(table string, column string) string
+	columnType(col AccountInfoColumn) (string, error)
+	serializeWrites() bool   // only one connection can write at a time
+	checkpointQuery() string // flushes everything to the database's files before closing
+}
+
+var (
+	//THE CURRENT DIALECT, SET BY Init()
+	sqlDialect dialect = mySQLDialect{}
+)
+
+func getDialect(driver string) (dialect, error) {
+	switch driver {
+	case DriverMySQL, "":
+		return mySQLDialect{}, nil
+	case DriverPostgres:
+		return postgresDialect{}, nil
+	case DriverSQLite:
+		return sqliteDialect{}, nil
+	default:
+		return nil, errors.New("Unsupported SQL driver '" + driver + "'. Use database.DriverMySQL, database.DriverPostgres, or database.DriverSQLite")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   MySQL   /////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type mySQLDialect struct{}
+
+func (mySQLDialect) driverName() string {
+	return DriverMySQL
+}
+
+func (mySQLDialect) dsn(userName string, password string, dbName string, protocol string, ip string, port int) string {
+	return userName + ":" + password + "@" + protocol + "(" + ip + ":" + strconv.Itoa(port) + ")/" + dbName
+}
+
+func (mySQLDialect) quote(value string) string {
+	return "\"" + value + "\""
+}
+
+func (mySQLDialect) quoteMark() string {
+	return "\""
+}
+
+func (mySQLDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (mySQLDialect) ident(name string) string {
+	return name
+}
+
+func (mySQLDialect) idColumn(name string) string {
+	return name + " INTEGER NOT NULL AUTO_INCREMENT"
+}
+
+func (mySQLDialect) resetAutoIncrement(table string) string {
+	return "ALTER TABLE " + table + " AUTO_INCREMENT=1;"
+}
+
+func (mySQLDialect) limitOne() string {
+	return " LIMIT 1"
+}
+
+func (mySQLDialect) tableExistsQuery(table string) string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema=DATABASE() AND table_name='" + table + "';"
+}
+
+func (mySQLDialect) columnExistsQuery(table string, column string) string {
+	return "SHOW COLUMNS FROM " + table + " LIKE '" + column + "';"
+}
+
+func (mySQLDialect) addUniqueQuery(table string, column string) string {
+	return "ALTER TABLE " + table + " ADD UNIQUE (" + column + ");"
+}
+
+func (mySQLDialect) serializeWrites() bool {
+	return false
+}
+
+func (mySQLDialect) checkpointQuery() string {
+	return ""
+}
+
+func (mySQLDialect) columnType(col AccountInfoColumn) (string, error) {
+	if isSizeDataType(col.dataType) {
+		return dataTypes[col.dataType] + "(" + strconv.Itoa(col.maxSize) + ")", nil
+	} else if isPrecisionDataType(col.dataType) {
+		return dataTypes[col.dataType] + "(" + strconv.Itoa(col.maxSize) + ", " + strconv.Itoa(col.precision) + ")", nil
+	}
+	return dataTypes[col.dataType], nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   PostgreSQL   ////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type postgresDialect struct{}
+
+func (postgresDialect) driverName() string {
+	return DriverPostgres
+}
+
+// The protocol is not used for PostgreSQL
+func (postgresDialect) dsn(userName string, password string, dbName string, protocol string, ip string, port int) string {
+	return "host=" + postgresDSNValue(ip) + " port=" + strconv.Itoa(port) + " user=" + postgresDSNValue(userName) +
+		" password=" + postgresDSNValue(password) + " dbname=" + postgresDSNValue(dbName)
+}
+
+func postgresDSNValue(value string) string {
+	return "'" + strings.NewReplacer("\\", "\\\\", "'", "\\'").Replace(value) + "'"
+}
+
+func (postgresDialect) quote(value string) string {
+	return "'" + value + "'"
+}
+
+func (postgresDialect) quoteMark() string {
+	return "'"
+}
+
+func (postgresDialect) placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (postgresDialect) ident(name string) string {
+	return "\"" + name + "\""
+}
+
+func (postgresDialect) idColumn(name string) string {
+	return name + " SERIAL"
+}
+
+// SERIAL columns already start at 1
+func (postgresDialect) resetAutoIncrement(table string) string {
+	return ""
+}
+
+// PostgreSQL has no LIMIT on UPDATE and DELETE. Every query using it matches on a unique column anyway.
+func (postgresDialect) limitOne() string {
+	return ""
+}
+
+func (postgresDialect) tableExistsQuery(table string) string {
+	return "SELECT table_name FROM information_schema.tables WHERE table_schema=current_schema() AND table_name='" + table + "';"
+}
+
+func (postgresDialect) columnExistsQuery(table string, column string) string {
+	return "SELECT column_name FROM information_schema.columns WHERE table_name='" + table + "' AND column_name='" + column + "';"
+}
+
+func (postgresDialect) addUniqueQuery(table string, column string) string {
+	return "CREATE UNIQUE INDEX IF NOT EXISTS " + table + "_" + column + "_unique ON " + table + " (" + column + ");"
+}
+
+func (postgresDialect) serializeWrites() bool {
+	return false
+}
+
+func (postgresDialect) checkpointQuery() string {
+	return ""
+}
+
+func (postgresDialect) columnType(col AccountInfoColumn) (string, error) {
+	size := "(" + strconv.Itoa(col.maxSize) + ")"
+	switch col.dataType {
+	case DataTypeTinyInt, DataTypeSmallInt, DataTypeYear:
+		return "SMALLINT", nil
+	case DataTypeMediumInt, DataTypeInt:
+		return "INTEGER", nil
+	case DataTypeBigInt:
+		return "BIGINT", nil
+	case DataTypeFloat:
+		return "REAL", nil
+	case DataTypeDouble:
+		return "DOUBLE PRECISION", nil
+	case DataTypeDecimal:
+		return "DECIMAL(" + strconv.Itoa(col.maxSize) + ", " + strconv.Itoa(col.precision) + ")", nil
+	case DataTypeChar:
+		return "CHAR" + size, nil
+	case DataTypeVarChar, DataTypeNationalVarChar:
+		return "VARCHAR" + size, nil
+	case DataTypeJSON:
+		return "JSON", nil
+	case DataTypeTinyText, DataTypeMediumText, DataTypeText, DataTypeLongText:
+		return "TEXT", nil
+	case DataTypeDate:
+		return "DATE", nil
+	case DataTypeDateTime, DataTypeTimeStamp:
+		return "TIMESTAMP" + size, nil
+	case DataTypeTime:
+		return "TIME" + size, nil
+	case DataTypeTinyBlob, DataTypeMediumBlob, DataTypeBlob, DataTypeLongBlob, DataTypeBinary, DataTypeVarBinary:
+		return "BYTEA", nil
+	case DataTypeBit:
+		return "BIT" + size, nil
+	default:
+		return "", errors.New("The data type '" + dataTypes[col.dataType] + "' is not supported by PostgreSQL")
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SQLite   ////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) driverName() string {
+	return DriverSQLite
+}
+
+// The database name is the path to the database file. Only the path is used for SQLite.
+func (sqliteDialect) dsn(userName string, password string, dbName string, protocol string, ip string, port int) string {
+	return "file:" + dbName + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+}
+
+func (sqliteDialect) quote(value string) string {
+	return "'" + value + "'"
+}
+
+func (sqliteDialect) quoteMark() string {
+	return "'"
+}
+
+func (sqliteDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) ident(name string) string {
+	return "\"" + name + "\""
+}
+
+// An INTEGER column that is the PRIMARY KEY is filled in automatically
+func (sqliteDialect) idColumn(name string) string {
+	return name + " INTEGER NOT NULL"
+}
+
+func (sqliteDialect) resetAutoIncrement(table string) string {
+	return ""
+}
+
+// SQLite only has LIMIT on UPDATE and DELETE when compiled with it
+func (sqliteDialect) limitOne() string {
+	return ""
+}
+
+func (sqliteDialect) tableExistsQuery(table string) string {
+	return "SELECT name FROM sqlite_master WHERE type='table' AND name='" + table + "';"
+}
+
+func (sqliteDialect) columnExistsQuery(table string, column string) string {
+	return "SELECT name FROM pragma_table_info('" + table + "') WHERE name='" + column + "';"
+}
+
+func (sqliteDialect) addUniqueQuery(table string, column string) string {
+	return "CREATE UNIQUE INDEX IF NOT EXISTS " + table + "_" + column + "_unique ON " + table + " (" + column + ");"
+}
+
+// SQLite accepts MySQL's type names
+func (sqliteDialect) columnType(col AccountInfoColumn) (string, error) {
+	return mySQLDialect{}.columnType(col)
+}
+
+func (sqliteDialect) serializeWrites() bool {
+	return true
+}
+
+func (sqliteDialect) checkpointQuery() string {
+	return "PRAGMA wal_checkpoint(TRUNCATE);"
+}
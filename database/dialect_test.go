@@ -0,0 +1,65 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestGetDialect(t *testing.T) {
+	if d, err := getDialect(""); err != nil || d.driverName() != DriverMySQL {
+		t.Error("An empty driver should default to MySQL")
+	}
+	if d, err := getDialect(DriverPostgres); err != nil || d.driverName() != DriverPostgres {
+		t.Error("Expected the PostgreSQL dialect, got", d, err)
+	}
+	if d, err := getDialect(DriverSQLite); err != nil || d.driverName() != DriverSQLite {
+		t.Error("Expected the SQLite dialect, got", d, err)
+	}
+	if _, err := getDialect("oracle"); err == nil {
+		t.Error("getDialect() should reject an unsupported driver")
+	}
+}
+
+func TestColumnTypes(t *testing.T) {
+	varChar := AccountInfoColumn{dataType: DataTypeVarChar, maxSize: 32}
+	decimal := AccountInfoColumn{dataType: DataTypeDecimal, maxSize: 10, precision: 2}
+	tests := []struct {
+		d    dialect
+		col  AccountInfoColumn
+		want string
+	}{
+		{mySQLDialect{}, varChar, "VARCHAR(32)"},
+		{mySQLDialect{}, decimal, "DECIMAL(10, 2)"},
+		{mySQLDialect{}, AccountInfoColumn{dataType: DataTypeBigInt, maxSize: 20}, "BIGINT(20)"},
+		{postgresDialect{}, varChar, "VARCHAR(32)"},
+		{postgresDialect{}, decimal, "DECIMAL(10, 2)"},
+		{postgresDialect{}, AccountInfoColumn{dataType: DataTypeTinyInt, maxSize: 4}, "SMALLINT"},
+		{postgresDialect{}, AccountInfoColumn{dataType: DataTypeText, maxSize: 500}, "TEXT"},
+		{postgresDialect{}, AccountInfoColumn{dataType: DataTypeBlob, maxSize: 500}, "BYTEA"},
+	}
+	for _, test := range tests {
+		if got, err := test.d.columnType(test.col); err != nil || got != test.want {
+			t.Error(test.d.driverName(), "expected", test.want, "got", got, err)
+		}
+	}
+	if _, err := (postgresDialect{}).columnType(AccountInfoColumn{dataType: DataTypeENUM, maxSize: 1}); err == nil {
+		t.Error("PostgreSQL should not support ENUM AccountInfoColumns")
+	}
+}
+
+func TestDialectQuoting(t *testing.T) {
+	defer func() { sqlDialect = mySQLDialect{} }()
+
+	sqlDialect = mySQLDialect{}
+	if value, _ := convertDataToString("VARCHAR", "gopher"); value != "\"gopher\"" {
+		t.Error("MySQL strings should be double quoted, got", value)
+	} else if checkStringSQLInjection("o'gopher") {
+		t.Error("A single quote is safe inside a MySQL string")
+	}
+
+	sqlDialect = postgresDialect{}
+	if value, _ := convertDataToString("VARCHAR", "gopher"); value != "'gopher'" {
+		t.Error("PostgreSQL strings should be single quoted, got", value)
+	} else if !checkStringSQLInjection("o'gopher") {
+		t.Error("A single quote should be rejected for PostgreSQL")
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"strconv"
 )
 
@@ -25,6 +26,14 @@ const (
 	FriendStatusAccepted
 )
 
+// friendsKey is the write queue Key for a friendship, so its writes run in order whichever User made them.
+func friendsKey(userIndex int, friendIndex int) string {
+	if userIndex > friendIndex {
+		userIndex, friendIndex = friendIndex, userIndex
+	}
+	return "friends:" + strconv.Itoa(userIndex) + "-" + strconv.Itoa(friendIndex)
+}
+
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 //   SEND FRIEND REQUEST   ///////////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -34,13 +43,13 @@ const (
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to send a
 // friend request when using the SQL features.
 func FriendRequest(userIndex int, friendIndex int) error {
-	_, insertErr := database.Exec("INSERT INTO " + tableFriends + " (" + friendsColumnUser + ", " + friendsColumnFriend + ", " + friendsColumnStatus + ") " +
-		"VALUES (" + strconv.Itoa(userIndex) + ", " + strconv.Itoa(friendIndex) + ", " + strconv.Itoa(FriendStatusPending) + ");")
+	insertErr := QueueWrite(Query{Key: friendsKey(userIndex, friendIndex), SQL: "INSERT INTO " + tableFriends + " (" + sqlDialect.ident(friendsColumnUser) + ", " + friendsColumnFriend + ", " + friendsColumnStatus + ") " +
+		"VALUES (" + strconv.Itoa(userIndex) + ", " + strconv.Itoa(friendIndex) + ", " + strconv.Itoa(FriendStatusPending) + ");"})
 	if insertErr != nil {
 		return insertErr
 	}
-	_, insertErr = database.Exec("INSERT INTO " + tableFriends + " (" + friendsColumnUser + ", " + friendsColumnFriend + ", " + friendsColumnStatus + ") " +
-		"VALUES (" + strconv.Itoa(friendIndex) + ", " + strconv.Itoa(userIndex) + ", " + strconv.Itoa(FriendStatusRequested) + ");")
+	insertErr = QueueWrite(Query{Key: friendsKey(userIndex, friendIndex), SQL: "INSERT INTO " + tableFriends + " (" + sqlDialect.ident(friendsColumnUser) + ", " + friendsColumnFriend + ", " + friendsColumnStatus + ") " +
+		"VALUES (" + strconv.Itoa(friendIndex) + ", " + strconv.Itoa(userIndex) + ", " + strconv.Itoa(FriendStatusRequested) + ");"})
 	if insertErr != nil {
 		return insertErr
 	}
@@ -57,9 +66,9 @@ func FriendRequest(userIndex int, friendIndex int) error {
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to accept a
 // friend request when using the SQL features.
 func FriendRequestAccepted(userIndex int, friendIndex int) error {
-	_, updateErr := database.Exec("UPDATE " + tableFriends + " SET " + friendsColumnStatus + "=" + strconv.Itoa(FriendStatusAccepted) + " WHERE (" + friendsColumnUser + "=" + strconv.Itoa(userIndex) +
-		" AND " + friendsColumnFriend + "=" + strconv.Itoa(friendIndex) + ") OR (" + friendsColumnUser + "=" + strconv.Itoa(friendIndex) +
-		" AND " + friendsColumnFriend + "=" + strconv.Itoa(userIndex) + ");")
+	updateErr := QueueWrite(Query{Key: friendsKey(userIndex, friendIndex), SQL: "UPDATE " + tableFriends + " SET " + friendsColumnStatus + "=" + strconv.Itoa(FriendStatusAccepted) + " WHERE (" + sqlDialect.ident(friendsColumnUser) + "=" + strconv.Itoa(userIndex) +
+		" AND " + friendsColumnFriend + "=" + strconv.Itoa(friendIndex) + ") OR (" + sqlDialect.ident(friendsColumnUser) + "=" + strconv.Itoa(friendIndex) +
+		" AND " + friendsColumnFriend + "=" + strconv.Itoa(userIndex) + ");"})
 	if updateErr != nil {
 		return updateErr
 	}
@@ -76,8 +85,8 @@ func FriendRequestAccepted(userIndex int, friendIndex int) error {
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the client APIs to remove a
 // friend when using the SQL features.
 func RemoveFriend(userIndex int, friendIndex int) error {
-	_, updateErr := database.Exec("DELETE FROM " + tableFriends + " WHERE (" + friendsColumnUser + "=" + strconv.Itoa(userIndex) + " AND " + friendsColumnFriend + "=" + strconv.Itoa(friendIndex) + ") OR (" +
-		friendsColumnUser + "=" + strconv.Itoa(friendIndex) + " AND " + friendsColumnFriend + "=" + strconv.Itoa(userIndex) + ");")
+	updateErr := QueueWrite(Query{Key: friendsKey(userIndex, friendIndex), SQL: "DELETE FROM " + tableFriends + " WHERE (" + sqlDialect.ident(friendsColumnUser) + "=" + strconv.Itoa(userIndex) + " AND " + friendsColumnFriend + "=" + strconv.Itoa(friendIndex) + ") OR (" +
+		sqlDialect.ident(friendsColumnUser) + "=" + strconv.Itoa(friendIndex) + " AND " + friendsColumnFriend + "=" + strconv.Itoa(userIndex) + ");"})
 	if updateErr != nil {
 		return updateErr
 	}
@@ -94,10 +103,15 @@ func RemoveFriend(userIndex int, friendIndex int) error {
 // WARNING: This is only meant for internal Gopher Game Server mechanics. Use the *User.Friends() function
 // instead to avoid errors when using the SQL features.
 func GetFriends(userIndex int) (map[string]*Friend, error) {
+	return GetFriendsCtx(context.Background(), userIndex)
+}
+
+// GetFriendsCtx is the same as GetFriends, but its queries are given up when ctx is done.
+func GetFriendsCtx(ctx context.Context, userIndex int) (map[string]*Friend, error) {
 	var friends map[string]*Friend = make(map[string]*Friend)
 
 	//EXECUTE SELECT QUERY
-	friendRows, friendRowsErr := database.Query("Select " + friendsColumnFriend + ", " + friendsColumnStatus + " FROM " + tableFriends + " WHERE " + friendsColumnUser + "=" + strconv.Itoa(userIndex) + ";")
+	friendRows, friendRowsErr := database.QueryContext(ctx, "Select "+friendsColumnFriend+", "+friendsColumnStatus+" FROM "+tableFriends+" WHERE "+sqlDialect.ident(friendsColumnUser)+"="+strconv.Itoa(userIndex)+";")
 	if friendRowsErr != nil {
 		return nil, friendRowsErr
 	}
@@ -111,7 +125,7 @@ func GetFriends(userIndex int) (map[string]*Friend, error) {
 			return nil, scanErr
 		}
 		//
-		friendInfoRows, friendInfoErr := database.Query("Select " + usersColumnName + " FROM " + tableUsers + " WHERE " + usersColumnID + "=" + strconv.Itoa(friendID) + " LIMIT 1;")
+		friendInfoRows, friendInfoErr := database.QueryContext(ctx, "Select "+usersColumnName+" FROM "+tableUsers+" WHERE "+usersColumnID+"="+strconv.Itoa(friendID)+" LIMIT 1;")
 		if friendInfoErr != nil {
 			friendRows.Close()
 			return nil, friendInfoErr
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/go-sql-driver/mysql"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	//CONNECTION POOL SETTINGS
+	poolMaxOpenConns    int
+	poolMaxIdleConns    int
+	poolConnMaxLifetime time.Duration
+
+	//DATABASE HEALTH
+	healthy    bool
+	healthMux  sync.Mutex
+	healthStop chan struct{}
+
+	// Swapped out by tests that can't wait
+	healthCheckInterval = 10 * time.Second
+	reconnectMinDelay   = time.Second
+	reconnectMaxDelay   = time.Minute
+	pingTimeout         = 5 * time.Second
+
+	// DatabaseStateChangeCallback is only for internal Gopher Game Server mechanics.
+	DatabaseStateChangeCallback func(bool)
+)
+
+// SetConnectionPool is only for internal Gopher Game Server mechanics. Use SqlMaxOpenConns, SqlMaxIdleConns and
+// SqlConnMaxLifetime in ServerSettings to configure the connection pool.
+func SetConnectionPool(maxOpenConns int, maxIdleConns int, connMaxLifetime time.Duration) {
+	poolMaxOpenConns = maxOpenConns
+	poolMaxIdleConns = maxIdleConns
+	poolConnMaxLifetime = connMaxLifetime
+}
+
+// Applies the connection pool settings. Zero keeps the database/sql default.
+func configurePool() {
+	if poolMaxOpenConns > 0 {
+		database.SetMaxOpenConns(poolMaxOpenConns)
+	}
+	if poolMaxIdleConns > 0 {
+		database.SetMaxIdleConns(poolMaxIdleConns)
+	}
+	if poolConnMaxLifetime > 0 {
+		database.SetConnMaxLifetime(poolConnMaxLifetime)
+	}
+}
+
+// Healthy returns true if the database could be reached the last time the server checked. While the database is
+// unreachable, the server keeps trying to reconnect, and clients get a helpers.ErrorDatabaseUnavailable error
+// when they try to sign up, log in, or change their account.
+func Healthy() bool {
+	healthMux.Lock()
+	defer healthMux.Unlock()
+	return healthy
+}
+
+func setHealthy(up bool) {
+	healthMux.Lock()
+	changed := healthy != up
+	healthy = up
+	healthMux.Unlock()
+	if !changed {
+		return
+	}
+	if up {
+		helpers.Log().Info("Database connection restored")
+	} else {
+		helpers.Log().Warn("Database connection lost, reconnecting...")
+	}
+	if DatabaseStateChangeCallback != nil {
+		DatabaseStateChangeCallback(up)
+	}
+}
+
+//PINGS THE DATABASE AND UPDATES THE HEALTH
+func checkHealth() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	up := database.PingContext(ctx) == nil
+	setHealthy(up)
+	return up
+}
+
+//PERIODICALLY CHECKS THE DATABASE, AND RECONNECTS WITH AN EXPONENTIAL BACKOFF WHEN IT IS UNREACHABLE
+func watchHealth(stop chan struct{}) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if checkHealth() {
+			continue
+		}
+		delay := reconnectMinDelay
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(delay):
+			}
+			if checkHealth() {
+				break
+			}
+			if delay *= 2; delay > reconnectMaxDelay {
+				delay = reconnectMaxDelay
+			}
+		}
+	}
+}
+
+func startHealthWatch() {
+	healthMux.Lock()
+	healthy = true
+	healthMux.Unlock()
+	healthStop = make(chan struct{})
+	go watchHealth(healthStop)
+}
+
+func stopHealthWatch() {
+	if healthStop != nil {
+		close(healthStop)
+		healthStop = nil
+	}
+}
+
+//CHECKS IF A QUERY FAILED BECAUSE THE DATABASE CONNECTION WAS LOST, AND MARKS THE DATABASE UNAVAILABLE IF SO
+func connectionLost(err error) bool {
+	var netErr net.Error
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		// AN ACTION THAT RAN OUT OF TIME DOESN'T MEAN THE CONNECTION IS GONE
+		return false
+	} else if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.As(err, &netErr) {
+		setHealthy(false)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,304 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration is a versioned change to the database's tables. The server applies the migrations the database doesn't have
+// yet when it starts, in order of their versions, each in its own transaction, and saves the version of each one it applies
+// in the schema_version table. New AccountInfoColumns are added the same way, after the built-in migrations and before yours.
+//
+// If a migration fails, its transaction is rolled back, its version is not saved, and the server doesn't start. NOTE: MySQL
+// can't roll back changes to tables, so a migration that fails part way on MySQL may need its changes undone by hand before
+// it can run again.
+type Migration struct {
+	Version     int    // The migration's version, or 0 for adding a new AccountInfoColumn
+	Description string // What the migration does
+	up          func(*sql.Tx) error
+}
+
+// CustomMigrationVersion is the lowest version you can give your own migrations. The versions below it are for the
+// built-in tables.
+const CustomMigrationVersion = 1000
+
+// schema_version TABLE & COLUMNS
+const (
+	tableSchemaVersion      = "schema_version"
+	schemaColumnVersion     = "version"
+	schemaColumnDescription = "description"
+	schemaColumnAppliedAt   = "applied"
+)
+
+var (
+	// THE BUILT-IN TABLES. EACH ONE CHECKS WHAT'S ALREADY THERE, SO DATABASES FROM BEFORE schema_version PICK UP WHERE THEY ARE.
+	builtInMigrations = []Migration{
+		{Version: 1, Description: "Create the users and friends tables", up: migrateUsersTable},
+		{Version: 2, Description: "Create the autologs table", up: migrateAutologsTable},
+		{Version: 3, Description: "Add the email verification columns", up: func(tx *sql.Tx) error {
+			return addVerificationColumnsSQL(tx)
+		}},
+		{Version: 4, Description: "Create the bans table", up: migrateBansTable},
+		{Version: 5, Description: "Add the name skeleton column", up: migrateSkeletonColumn},
+		{Version: 6, Description: "Create the saved games tables", up: migrateSavedGamesTables},
+	}
+
+	customMigrations []Migration
+
+	migrationDryRun bool
+)
+
+// AddMigration adds a migration for your own tables, or changes to the built-in ones. The version must be at least
+// CustomMigrationVersion, and is how the server remembers the migration was applied, so never change what a version does once
+// it has been applied. up gets the migration's transaction, and returning an error rolls it back. You can only add migrations
+// before starting the server.
+func AddMigration(version int, description string, up func(tx *sql.Tx) error) error {
+	if serverStarted {
+		return errors.New("You can't add a migration once the server has started")
+	} else if version < CustomMigrationVersion {
+		return errors.New("Migration versions must be at least " + strconv.Itoa(CustomMigrationVersion))
+	} else if up == nil {
+		return errors.New("database.AddMigration() requires an up function")
+	}
+	for _, m := range customMigrations {
+		if m.Version == version {
+			return errors.New("A migration with the version " + strconv.Itoa(version) + " already exists")
+		}
+	}
+	customMigrations = append(customMigrations, Migration{Version: version, Description: description, up: up})
+	return nil
+}
+
+// SetMigrationDryRun is only for internal Gopher Game Server mechanics. Use SqlMigrationDryRun in ServerSettings.
+func SetMigrationDryRun(dryRun bool) {
+	migrationDryRun = dryRun
+}
+
+// PendingMigrations gets the migrations the database doesn't have yet, in the order they would be applied, without
+// applying them. The database must be connected, so use SqlMigrationDryRun in ServerSettings to see them before the server
+// applies them.
+func PendingMigrations() ([]Migration, error) {
+	if database == nil {
+		return nil, errors.New("The database is not connected")
+	}
+	return pendingMigrations()
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   APPLYING MIGRATIONS   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func migrate() error {
+	if _, err := exec("CREATE TABLE IF NOT EXISTS " + tableSchemaVersion + " (" +
+		schemaColumnVersion + " INTEGER NOT NULL, " +
+		schemaColumnDescription + " VARCHAR(255) NOT NULL, " +
+		schemaColumnAppliedAt + " BIGINT NOT NULL, " +
+		"PRIMARY KEY (" + schemaColumnVersion + "));"); err != nil {
+
+		return err
+	}
+	pending, err := pendingMigrations()
+	if err != nil {
+		return err
+	}
+	if migrationDryRun {
+		for _, m := range pending {
+			helpers.Log().Info("Pending migration", "version", m.Version, "description", m.Description)
+		}
+		if len(pending) > 0 {
+			return errors.New("SqlMigrationDryRun is enabled, so the " + strconv.Itoa(len(pending)) + " pending migrations were not applied")
+		}
+		return nil
+	}
+	for _, m := range pending {
+		helpers.Log().Info("Applying migration", "version", m.Version, "description", m.Description)
+		if err := applyMigration(m); err != nil {
+			return errors.New("Migration " + strconv.Itoa(m.Version) + " (" + m.Description + ") failed and was rolled back: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func applyMigration(m Migration) error {
+	if sqlDialect.serializeWrites() {
+		writeMux.Lock()
+		defer writeMux.Unlock()
+	}
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	if err := m.up(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	// THE VERSION IS ONLY SAVED WITH THE REST OF THE MIGRATION
+	if m.Version > 0 {
+		if _, err := tx.Exec("INSERT INTO " + tableSchemaVersion + " (" + schemaColumnVersion + ", " + schemaColumnDescription + ", " +
+			schemaColumnAppliedAt + ") VALUES (" + strconv.Itoa(m.Version) + ", " + sqlDialect.quote(safeDescription(m.Description)) + ", " +
+			strconv.FormatInt(time.Now().Unix(), 10) + ");"); err != nil {
+
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pendingMigrations gets the built-in migrations, missing AccountInfoColumns, then custom migrations the database doesn't have.
+func pendingMigrations() ([]Migration, error) {
+	applied, err := appliedVersions()
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range builtInMigrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+
+	// NEW AccountInfoColumns - MAKING THE users TABLE ADDS THEM, SO ONLY ONCE IT'S THERE
+	if applied[1] {
+		columns, err := missingAccountInfoColumns()
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, columns...)
+	}
+
+	custom := make([]Migration, 0, len(customMigrations))
+	for _, m := range customMigrations {
+		if !applied[m.Version] {
+			custom = append(custom, m)
+		}
+	}
+	sort.Slice(custom, func(i, j int) bool { return custom[i].Version < custom[j].Version })
+	return append(pending, custom...), nil
+}
+
+func appliedVersions() (map[int]bool, error) {
+	rows, err := database.Query("SELECT " + schemaColumnVersion + " FROM " + tableSchemaVersion + ";")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func missingAccountInfoColumns() ([]Migration, error) {
+	names := make([]string, 0, len(customAccountInfo))
+	for name := range customAccountInfo {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var missing []Migration
+	for _, name := range names {
+		if exists, err := columnExists(database, tableUsers, name); err != nil {
+			return nil, err
+		} else if exists {
+			continue
+		}
+		name, col := name, customAccountInfo[name]
+		missing = append(missing, Migration{Description: "Add AccountInfoColumn '" + name + "'", up: func(tx *sql.Tx) error {
+			return addAccountInfoColumnSQL(tx, name, col)
+		}})
+	}
+	return missing, nil
+}
+
+// safeDescription makes a Migration's description safe to save in the schema_version table.
+func safeDescription(description string) string {
+	description = strings.ReplaceAll(description, sqlDialect.quoteMark(), "")
+	if len(description) > 255 {
+		description = description[:255]
+	}
+	return description
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   BUILT-IN MIGRATIONS   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func migrateUsersTable(tx *sql.Tx) error {
+	if exists, err := tableExists(tx, tableUsers); err != nil || exists {
+		return err
+	}
+	helpers.Log().Info("Creating \"" + tableUsers + "\" table...")
+	return createUserTableSQL(tx)
+}
+
+func migrateAutologsTable(tx *sql.Tx) error {
+	if exists, err := tableExists(tx, tableAutologs); err != nil {
+		return err
+	} else if exists {
+		return addAutologColumnsSQL(tx)
+	}
+	helpers.Log().Info("Making autologs table...")
+	return createAutologsTableSQL(tx)
+}
+
+func migrateBansTable(tx *sql.Tx) error {
+	if exists, err := tableExists(tx, tableBans); err != nil || exists {
+		return err
+	}
+	helpers.Log().Info("Making bans table...")
+	return createBansTableSQL(tx)
+}
+
+func migrateSavedGamesTables(tx *sql.Tx) error {
+	if exists, err := tableExists(tx, tableSavedGames); err != nil || exists {
+		return err
+	}
+	helpers.Log().Info("Making saved games tables...")
+	return createSavedGamesTablesSQL(tx)
+}
+
+// migrateSkeletonColumn adds the column for what accounts' names look like, and fills it in for the accounts already there.
+func migrateSkeletonColumn(tx *sql.Tx) error {
+	if exists, err := columnExists(tx, tableUsers, usersColumnSkeleton); err != nil || exists {
+		return err
+	}
+	helpers.Log().Info("Adding name skeleton column '" + usersColumnSkeleton + "'...")
+	if _, err := tx.Exec("ALTER TABLE " + tableUsers + " ADD COLUMN " + usersColumnSkeleton + " VARCHAR(255);"); err != nil {
+		return err
+	}
+	rows, err := tx.Query("SELECT " + usersColumnID + ", " + usersColumnName + " FROM " + tableUsers + ";")
+	if err != nil {
+		return err
+	}
+	skeletons := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return err
+		}
+		skeletons[id] = helpers.NameSkeleton(name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, skeleton := range skeletons {
+		if _, err := tx.Exec("UPDATE " + tableUsers + " SET " + usersColumnSkeleton + "=" + sqlDialect.quote(skeleton) + " WHERE " +
+			usersColumnID + "=" + strconv.Itoa(id) + ";"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
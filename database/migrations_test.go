@@ -0,0 +1,86 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	defer func() {
+		customMigrations = nil
+		migrationDryRun = false
+		delete(customAccountInfo, "nickname")
+	}()
+	testSQLite(t)
+
+	// Init applies the built-in migrations
+	if pending, err := PendingMigrations(); err != nil {
+		t.Fatal(err)
+	} else if len(pending) != 0 {
+		t.Fatal("Expected no pending migrations after Init(), got", pending)
+	}
+	if applied, err := appliedVersions(); err != nil {
+		t.Fatal(err)
+	} else if len(applied) != len(builtInMigrations) {
+		t.Error("Expected every built-in migration to be saved, got", applied)
+	}
+
+	// New AccountInfoColumns come before custom migrations
+	if AddMigration(5, "Too low", func(*sql.Tx) error { return nil }) == nil {
+		t.Error("Custom migrations can't use the built-in versions")
+	}
+	AddMigration(CustomMigrationVersion+1, "Create the scores table", func(tx *sql.Tx) error {
+		_, err := tx.Exec("CREATE TABLE scores (player INTEGER NOT NULL, score INTEGER NOT NULL);")
+		return err
+	})
+	AddMigration(CustomMigrationVersion, "Fail part way", func(tx *sql.Tx) error {
+		if _, err := tx.Exec("CREATE TABLE broken (id INTEGER);"); err != nil {
+			return err
+		}
+		return errors.New("Something went wrong")
+	})
+	if err := NewAccountInfoColumn("nickname", DataTypeVarChar, 32, 0, false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	pending, err := PendingMigrations()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(pending) != 3 || pending[0].Version != 0 || pending[1].Version != CustomMigrationVersion ||
+		pending[2].Version != CustomMigrationVersion+1 {
+		t.Fatal("Expected the AccountInfoColumn, then the custom migrations in order, got", pending)
+	}
+
+	// A dry run doesn't apply anything
+	migrationDryRun = true
+	if err := migrate(); err == nil {
+		t.Error("A dry run with pending migrations should return an error")
+	} else if pending, _ := PendingMigrations(); len(pending) != 3 {
+		t.Error("A dry run should not apply migrations, got", pending)
+	}
+	migrationDryRun = false
+
+	// A failed migration is rolled back, and its version isn't saved
+	if err := migrate(); err == nil {
+		t.Fatal("Expected the failing migration to stop the migrations")
+	}
+	if exists, _ := tableExists(database, "broken"); exists {
+		t.Error("The failed migration should have been rolled back")
+	} else if exists, _ := columnExists(database, tableUsers, "nickname"); !exists {
+		t.Error("The AccountInfoColumn should have been added before the failed migration")
+	}
+	if pending, _ := PendingMigrations(); len(pending) != 2 || pending[0].Version != CustomMigrationVersion {
+		t.Error("Expected both custom migrations to still be pending, got", pending)
+	}
+
+	// Once it's fixed, the rest go through
+	customMigrations[1].up = func(*sql.Tx) error { return nil }
+	if err := migrate(); err != nil {
+		t.Fatal(err)
+	} else if exists, _ := tableExists(database, "scores"); !exists {
+		t.Error("Expected the scores table to be made")
+	}
+	if pending, _ := PendingMigrations(); len(pending) != 0 {
+		t.Error("Expected no pending migrations, got", pending)
+	}
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"golang.org/x/crypto/bcrypt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// PasswordHasher hashes and checks User passwords with the SQL features enabled. The default PasswordHasher uses bcrypt with
+// EncryptionCost from ServerSettings. You can replace it with database.SetPasswordHasher() to, for instance, move to argon2id.
+//
+// Every stored password hash is prefixed with the ID of the PasswordHasher that made it, like "{bcrypt}$2a$04$...". When a
+// User logs in and their hash was made by a different PasswordHasher than the current one, or NeedsRehash() returns true
+// for it, the server re-hashes their password with the current PasswordHasher and updates it on the database.
+type PasswordHasher interface {
+	// ID is the name stored in front of the hashes, like "bcrypt". It must not contain '{' or '}'.
+	ID() string
+	// Hash hashes a password.
+	Hash(password string) (string, error)
+	// Compare returns true if the password matches the hash.
+	Compare(password string, hash string) bool
+	// NeedsRehash returns true if the hash was made with weaker parameters than the PasswordHasher uses now.
+	NeedsRehash(hash string) bool
+}
+
+var (
+	passwordHasher  PasswordHasher            = bcryptHasher{}
+	passwordHashers map[string]PasswordHasher = map[string]PasswordHasher{bcryptHasherID: bcryptHasher{}}
+
+	//ONLY ONE REHASH CAN RUN AT A TIME, SO CONCURRENT LOGINS CAN'T REHASH THE SAME PASSWORD TWICE
+	rehashMux sync.Mutex
+)
+
+// SetPasswordHasher sets the PasswordHasher used for new password hashes. Hashes made by the PasswordHashers you set before,
+// and the default bcrypt one, can still be checked, and get replaced when their Users log in. You can only set the
+// PasswordHasher before starting the server.
+func SetPasswordHasher(h PasswordHasher) error {
+	if serverStarted {
+		return errors.New("You can't set the PasswordHasher after the server has started")
+	} else if h == nil {
+		return errors.New("database.SetPasswordHasher() requires a PasswordHasher")
+	} else if id := h.ID(); len(id) == 0 || strings.ContainsAny(id, "{}") {
+		return errors.New("A PasswordHasher's ID can't be empty, or contain '{' or '}'")
+	}
+	passwordHasher = h
+	passwordHashers[h.ID()] = h
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   bcrypt   ////////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+const bcryptHasherID = "bcrypt"
+
+type bcryptHasher struct{}
+
+func (bcryptHasher) ID() string {
+	return bcryptHasherID
+}
+
+func (bcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), encryptionCost)
+	return string(hash), err
+}
+
+func (bcryptHasher) Compare(password string, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (bcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < encryptionCost
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   HASHING AND CHECKING PASSWORDS   ////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+//HASHES A PASSWORD WITH THE CURRENT PasswordHasher, PREFIXED WITH ITS ID
+func hashPassword(password string) (string, error) {
+	hash, err := passwordHasher.Hash(password)
+	if err != nil {
+		return "", err
+	}
+	return "{" + passwordHasher.ID() + "}" + hash, nil
+}
+
+//SPLITS A STORED HASH INTO ITS PasswordHasher ID AND HASH. HASHES FROM BEFORE THE PREFIX WAS ADDED ARE BCRYPT.
+func splitPasswordHash(stored string) (string, string, bool) {
+	if strings.HasPrefix(stored, "{") {
+		if end := strings.Index(stored, "}"); end > 0 {
+			return stored[1:end], stored[end+1:], false
+		}
+	}
+	return bcryptHasherID, stored, true
+}
+
+// checkPassword compares a password to its stored hash. The second return value is true if the password matched, but the
+// hash should be replaced with one from the current PasswordHasher.
+func checkPassword(password string, stored []byte) (bool, bool) {
+	id, hash, legacy := splitPasswordHash(string(stored))
+	h, ok := passwordHashers[id]
+	if !ok || !h.Compare(password, hash) {
+		return false, false
+	}
+	return true, legacy || id != passwordHasher.ID() || passwordHasher.NeedsRehash(hash)
+}
+
+// rehashPassword replaces the stored hash of the User with the database index dbIndex with a hash from the current
+// PasswordHasher. The hash is only replaced if it is still the one the password was checked against, so a password
+// change or another login's rehash in the meantime is never overwritten.
+func rehashPassword(dbIndex int, password string, stored []byte) {
+	rehashMux.Lock()
+	defer rehashMux.Unlock()
+
+	var current string
+	if err := database.QueryRow("SELECT " + usersColumnPassword + " FROM " + tableUsers + " WHERE " + usersColumnID + "=" +
+		strconv.Itoa(dbIndex) + ";").Scan(&current); err != nil || current != string(stored) {
+		return
+	}
+	newHash, hashErr := hashPassword(password)
+	if hashErr != nil {
+		helpers.Log().Error("Error re-hashing password", "id", dbIndex, "error", hashErr)
+		return
+	}
+	if _, err := exec("UPDATE " + tableUsers + " SET " + usersColumnPassword + "=" + sqlDialect.quote(newHash) + " WHERE " +
+		usersColumnID + "=" + strconv.Itoa(dbIndex) + " AND " + usersColumnPassword + "=" + sqlDialect.quote(current) + ";"); err != nil {
+		helpers.Log().Error("Error re-hashing password", "id", dbIndex, "error", err)
+	}
+}
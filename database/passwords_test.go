@@ -0,0 +1,139 @@
+package database
+
+import (
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// countingHasher is a bcrypt PasswordHasher that counts the hashes it makes.
+type countingHasher struct {
+	bcryptHasher
+	hashes *int32
+}
+
+func (countingHasher) ID() string {
+	return "counting"
+}
+
+func (h countingHasher) Hash(password string) (string, error) {
+	atomic.AddInt32(h.hashes, 1)
+	return h.bcryptHasher.Hash(password)
+}
+
+func storedPassword(t *testing.T, userName string) string {
+	var stored string
+	if err := database.QueryRow("SELECT " + usersColumnPassword + " FROM " + tableUsers + " WHERE " + usersColumnName + "=" +
+		sqlDialect.quote(userName) + ";").Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestRehashOnLogin(t *testing.T) {
+	testSQLite(t)
+	defer func() { encryptionCost = 4 }()
+
+	// A hash from before hashes were prefixed
+	legacy, _ := bcrypt.GenerateFromPassword([]byte("secret"), 4)
+	if _, err := exec("INSERT INTO " + tableUsers + " (" + usersColumnName + ", " + usersColumnPassword + ") VALUES ('legacy', '" + string(legacy) + "');"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := LoginClient("legacy", "secret", "", false, nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	if stored := storedPassword(t, "legacy"); !strings.HasPrefix(stored, "{bcrypt}") {
+		t.Error("Logging in should prefix a legacy hash, got", stored)
+	}
+
+	// Raising the cost upgrades the hash on the next login
+	encryptionCost = 5
+	if _, _, _, err := LoginClient("legacy", "secret", "", false, nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	_, hash, _ := splitPasswordHash(storedPassword(t, "legacy"))
+	if cost, _ := bcrypt.Cost([]byte(hash)); cost != 5 {
+		t.Error("Expected the hash to be upgraded to cost 5, got", cost)
+	}
+	if _, _, _, err := LoginClient("legacy", "wrong", "", false, nil); err.ID == 0 {
+		t.Error("The upgraded hash should still reject the wrong password")
+	}
+}
+
+func TestConcurrentRehash(t *testing.T) {
+	testSQLite(t)
+	if err := SignUpClient("gopher", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+
+	var hashes int32
+	if err := SetPasswordHasher(countingHasher{hashes: &hashes}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		passwordHasher = bcryptHasher{}
+		delete(passwordHashers, "counting")
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, err := LoginClient("gopher", "secret", "", false, nil); err.ID != 0 {
+				t.Error(err.Message)
+			}
+		}()
+	}
+	wg.Wait()
+	if hashes != 1 {
+		t.Error("Concurrent logins should rehash the password once, rehashed", hashes, "times")
+	}
+	if stored := storedPassword(t, "gopher"); !strings.HasPrefix(stored, "{counting}") {
+		t.Error("Expected the hash to be moved to the new PasswordHasher, got", stored)
+	}
+}
+
+func TestRehashAndPasswordChange(t *testing.T) {
+	var changes int
+	PasswordChangeCallback = func(string, int, map[string]interface{}, map[string]interface{}) bool {
+		changes++
+		return true
+	}
+	defer func() {
+		PasswordChangeCallback = nil
+		encryptionCost = 4
+	}()
+	testSQLite(t)
+	if err := SignUpClient("gopher", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	stale := storedPassword(t, "gopher")
+
+	// A rehash on login is not a password change
+	encryptionCost = 5
+	_, dbID, _, err := LoginClient("gopher", "secret", "", false, nil)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	} else if changes != 0 {
+		t.Error("Rehashing on login should not run the PasswordChangeCallback")
+	}
+
+	// The password can be changed with the rehashed hash
+	if err := ChangePassword("gopher", "secret", "newSecret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	} else if changes != 1 {
+		t.Error("Expected the PasswordChangeCallback to run once, ran", changes, "times")
+	}
+
+	// A login that checked the old hash before the change can't undo it
+	rehashPassword(dbID, "secret", []byte(stale))
+	if _, _, _, err := LoginClient("gopher", "secret", "", false, nil); err.ID == 0 {
+		t.Error("A late rehash should not bring back the old password")
+	}
+	if _, _, _, err := LoginClient("gopher", "newSecret", "", false, nil); err.ID != 0 {
+		t.Error(err.Message)
+	}
+}
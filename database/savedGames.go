@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strconv"
+	"time"
+)
+
+// SavedGame is a Room saved with *Room.SaveGame(), that can be made again with core.LoadGame(). Get the ones an account was
+// playing in with ListSavedGames().
+type SavedGame struct {
+	ID       int64  // The ID to load the game with
+	Slot     string // The slot the game was saved in
+	Room     string // The name of the Room that was saved
+	RoomType string // The Room's type
+	Version  int    // The version of the format the game was saved in
+	Saved    int64  // When the game was last saved, in unix seconds
+}
+
+// saved_games AND saved_game_players TABLES & COLUMNS
+const (
+	tableSavedGames       = "saved_games"
+	tableSavedGamePlayers = "saved_game_players"
+
+	savedGamesColumnID      = "_id"
+	savedGamesColumnSlot    = "slot"
+	savedGamesColumnRoom    = "room"
+	savedGamesColumnType    = "rtype"
+	savedGamesColumnVersion = "version"
+	savedGamesColumnSaved   = "saved"
+	savedGamesColumnData    = "data"
+
+	savedPlayersColumnGame   = "game"
+	savedPlayersColumnPlayer = "player"
+)
+
+var (
+	// ErrSavedGameNotFound is returned when there is no saved game with the ID.
+	ErrSavedGameNotFound = errors.New("The saved game does not exist")
+
+	purgeSavedGames bool
+)
+
+// SetPurgeSavedGames is only for internal Gopher Game Server mechanics. Use PurgeSavedGames in ServerSettings.
+func SetPurgeSavedGames(purge bool) {
+	if !serverStarted {
+		purgeSavedGames = purge
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SAVING AND LOADING GAMES   //////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SaveGame is only for internal Gopher Game Server mechanics.
+func SaveGame(ctx context.Context, game SavedGame, players []int, data string) (int64, error) {
+	if sqlDialect.serializeWrites() {
+		writeMux.Lock()
+		defer writeMux.Unlock()
+	}
+	tx, err := database.BeginTx(ctx, nil)
+	if err != nil {
+		connectionLost(err)
+		return 0, err
+	}
+	p1, p2, p3, p4 := sqlDialect.placeholder(1), sqlDialect.placeholder(2), sqlDialect.placeholder(3), sqlDialect.placeholder(4)
+
+	// SAVING TO A SLOT THE ROOM ALREADY USED REPLACES THAT GAME, AND KEEPS ITS ID
+	var id int64
+	err = tx.QueryRowContext(ctx, "SELECT "+savedGamesColumnID+" FROM "+tableSavedGames+" WHERE "+savedGamesColumnRoom+"="+p1+
+		" AND "+savedGamesColumnSlot+"="+p2+";", game.Room, game.Slot).Scan(&id)
+	if err == nil {
+		_, err = tx.ExecContext(ctx, "UPDATE "+tableSavedGames+" SET "+savedGamesColumnType+"="+p1+", "+savedGamesColumnVersion+"="+p2+
+			", "+savedGamesColumnSaved+"="+p3+", "+savedGamesColumnData+"="+p4+" WHERE "+savedGamesColumnID+"="+strconv.FormatInt(id, 10)+";",
+			game.RoomType, game.Version, game.Saved, data)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "DELETE FROM "+tableSavedGamePlayers+" WHERE "+savedPlayersColumnGame+"="+strconv.FormatInt(id, 10)+";")
+		}
+	} else if err == sql.ErrNoRows {
+		if id, err = newSavedGameID(); err == nil {
+			_, err = tx.ExecContext(ctx, "INSERT INTO "+tableSavedGames+" ("+savedGamesColumnID+", "+savedGamesColumnSlot+", "+
+				savedGamesColumnRoom+", "+savedGamesColumnType+", "+savedGamesColumnVersion+", "+savedGamesColumnSaved+", "+
+				savedGamesColumnData+") VALUES ("+strconv.FormatInt(id, 10)+", "+p1+", "+p2+", "+p3+", "+p4+", "+sqlDialect.placeholder(5)+
+				", "+sqlDialect.placeholder(6)+");", game.Slot, game.Room, game.RoomType, game.Version, game.Saved, data)
+		}
+	}
+	for i := 0; err == nil && i < len(players); i++ {
+		_, err = tx.ExecContext(ctx, "INSERT INTO "+tableSavedGamePlayers+" ("+savedPlayersColumnGame+", "+savedPlayersColumnPlayer+
+			") VALUES ("+strconv.FormatInt(id, 10)+", "+strconv.Itoa(players[i])+");")
+	}
+	if err != nil {
+		tx.Rollback()
+		connectionLost(err)
+		return 0, err
+	} else if err = tx.Commit(); err != nil {
+		connectionLost(err)
+		return 0, err
+	}
+	return id, nil
+}
+
+// LoadGame is only for internal Gopher Game Server mechanics.
+func LoadGame(ctx context.Context, id int64) (SavedGame, string, error) {
+	game := SavedGame{ID: id}
+	var data string
+	err := database.QueryRowContext(ctx, "SELECT "+savedGamesColumnSlot+", "+savedGamesColumnRoom+", "+savedGamesColumnType+", "+
+		savedGamesColumnVersion+", "+savedGamesColumnSaved+", "+savedGamesColumnData+" FROM "+tableSavedGames+" WHERE "+
+		savedGamesColumnID+"="+strconv.FormatInt(id, 10)+";").Scan(&game.Slot, &game.Room, &game.RoomType, &game.Version, &game.Saved, &data)
+	if err == sql.ErrNoRows {
+		return SavedGame{}, "", ErrSavedGameNotFound
+	} else if err != nil {
+		connectionLost(err)
+		return SavedGame{}, "", err
+	}
+	return game, data, nil
+}
+
+// DeleteSavedGame deletes a saved game. Deleting a game that doesn't exist does nothing.
+func DeleteSavedGame(id int64) error {
+	if sqlDialect.serializeWrites() {
+		writeMux.Lock()
+		defer writeMux.Unlock()
+	}
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		"DELETE FROM " + tableSavedGamePlayers + " WHERE " + savedPlayersColumnGame + "=" + strconv.FormatInt(id, 10) + ";",
+		"DELETE FROM " + tableSavedGames + " WHERE " + savedGamesColumnID + "=" + strconv.FormatInt(id, 10) + ";",
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListSavedGames gets the saved games the account with the database index dbID was playing in when they were saved, newest
+// first, so a client can pick one to resume.
+func ListSavedGames(dbID int) ([]SavedGame, error) {
+	rows, err := database.Query("SELECT " + savedGamesColumnID + ", " + savedGamesColumnSlot + ", " + savedGamesColumnRoom + ", " +
+		savedGamesColumnType + ", " + savedGamesColumnVersion + ", " + savedGamesColumnSaved + " FROM " + tableSavedGames + " WHERE " +
+		savedGamesColumnID + " IN (SELECT " + savedPlayersColumnGame + " FROM " + tableSavedGamePlayers + " WHERE " +
+		savedPlayersColumnPlayer + "=" + strconv.Itoa(dbID) + ") ORDER BY " + savedGamesColumnSaved + " DESC;")
+	if err != nil {
+		connectionLost(err)
+		return nil, err
+	}
+	defer rows.Close()
+	games := []SavedGame{}
+	for rows.Next() {
+		var game SavedGame
+		if scanErr := rows.Scan(&game.ID, &game.Slot, &game.Room, &game.RoomType, &game.Version, &game.Saved); scanErr != nil {
+			return nil, scanErr
+		}
+		games = append(games, game)
+	}
+	return games, rows.Err()
+}
+
+// purgeSavedGamesQueries are the queries that take a deleted account out of the saved games, and delete the games they were
+// in with PurgeSavedGames in ServerSettings.
+func purgeSavedGamesQueries(id string) []string {
+	var queries []string
+	if purgeSavedGames {
+		queries = append(queries,
+			"DELETE FROM "+tableSavedGames+" WHERE "+savedGamesColumnID+" IN (SELECT "+savedPlayersColumnGame+" FROM "+
+				tableSavedGamePlayers+" WHERE "+savedPlayersColumnPlayer+"="+id+");",
+			"DELETE FROM "+tableSavedGamePlayers+" WHERE "+savedPlayersColumnGame+" NOT IN (SELECT "+savedGamesColumnID+" FROM "+
+				tableSavedGames+");")
+	}
+	return append(queries, "DELETE FROM "+tableSavedGamePlayers+" WHERE "+savedPlayersColumnPlayer+"="+id+";")
+}
+
+// newSavedGameID makes a random positive ID for a saved game, so it doesn't depend on the database's auto-increment, and
+// can't be guessed from the others.
+func newSavedGameID() (int64, error) {
+	b, err := helpers.GenerateRandomBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	id := int64(binary.BigEndian.Uint64(b) >> 1)
+	if id == 0 {
+		id = time.Now().UnixNano()
+	}
+	return id, nil
+}
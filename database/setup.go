@@ -1,39 +1,24 @@
 package database
 
 import (
-	"fmt"
-	"strconv"
+	"database/sql"
 )
 
+// sqlRunner runs queries on the database, or in a migration's transaction.
+type sqlRunner interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
 // Configures the SQL database for Gopher Game Server
 func setUp() error {
-	// Check if the users table has been created
-	_, checkErr := database.Exec("SELECT " + usersColumnName + " FROM " + tableUsers + " WHERE " + usersColumnID + "=1;")
-	if checkErr != nil {
-		fmt.Println("Creating \"" + tableUsers + "\" table...")
-		// Make the users table
-		if cErr := createUserTableSQL(); cErr != nil {
-			return cErr
-		}
-	}
-	// Check for new custom AccountInfoColumn items
-	if newItemsErr := addNewCustomItemsSQL(); newItemsErr != nil {
-		return newItemsErr
-	}
-
-	if rememberMe {
-		// Check if autologs table has been made
-		_, checkErr := database.Exec("SELECT " + autologsColumnID + " FROM " + tableAutologs + " WHERE " + autologsColumnID + "=1;")
-		if checkErr != nil {
-			fmt.Println("Making autologs table...")
-			if cErr := createAutologsTableSQL(); cErr != nil {
-				return cErr
-			}
-		}
+	// Bring the tables up to date
+	if migrateErr := migrate(); migrateErr != nil {
+		return migrateErr
 	}
 	// Make sure customLoginColumn is unique if it is set
 	if len(customLoginColumn) > 0 {
-		_, alterErr := database.Exec("ALTER TABLE " + tableUsers + " ADD UNIQUE (" + customLoginColumn + ");")
+		_, alterErr := exec(sqlDialect.addUniqueQuery(tableUsers, customLoginColumn))
 		if alterErr != nil {
 			return alterErr
 		}
@@ -43,21 +28,38 @@ func setUp() error {
 	return nil
 }
 
-func createUserTableSQL() error {
+func tableExists(r sqlRunner, table string) (bool, error) {
+	return hasRows(r, sqlDialect.tableExistsQuery(table))
+}
+
+func columnExists(r sqlRunner, table string, column string) (bool, error) {
+	return hasRows(r, sqlDialect.columnExistsQuery(table, column))
+}
+
+func hasRows(r sqlRunner, query string) (bool, error) {
+	rows, err := r.Query(query)
+	if err != nil {
+		return false, err
+	}
+	exists := rows.Next()
+	rows.Close()
+	return exists, rows.Err()
+}
+
+func createUserTableSQL(r sqlRunner) error {
 	createQuery := "CREATE TABLE " + tableUsers + " (" +
-	usersColumnID + " INTEGER NOT NULL AUTO_INCREMENT, " +
+	sqlDialect.idColumn(usersColumnID) + ", " +
 	usersColumnName + " VARCHAR(255) UNIQUE NOT NULL, " +
 	usersColumnPassword + " VARCHAR(255) NOT NULL, "
 
 	// Append custom AccountInfoColumn items
 	for key, val := range customAccountInfo {
-		createQuery = createQuery + key + " " + dataTypes[val.dataType]
-		// Check for maxSize/precision
-		if isSizeDataType(val.dataType) {
-			createQuery = createQuery + "(" + strconv.Itoa(val.maxSize) + ")"
-		} else if isPrecisionDataType(val.dataType) {
-			createQuery = createQuery + "(" + strconv.Itoa(val.maxSize) + ", " + strconv.Itoa(val.precision) + ")"
+		// Get the type with its maxSize/precision
+		colType, typeErr := sqlDialect.columnType(val)
+		if typeErr != nil {
+			return typeErr
 		}
+		createQuery = createQuery + key + " " + colType
 		// Check for unique
 		if val.unique {
 			createQuery = createQuery + " UNIQUE"
@@ -73,20 +75,21 @@ func createUserTableSQL() error {
 	createQuery = createQuery + "PRIMARY KEY (" + usersColumnID + "));"
 
 	// Execute users table query
-	_, createErr := database.Exec(createQuery)
+	_, createErr := r.Exec(createQuery)
 	if createErr != nil {
 		return createErr
 	}
 
 	// Adjust auto-increment to 1
-	_, adjustErr := database.Exec("ALTER TABLE " + tableUsers + " AUTO_INCREMENT=1;")
-	if adjustErr != nil {
-		return adjustErr
+	if adjustQuery := sqlDialect.resetAutoIncrement(tableUsers); adjustQuery != "" {
+		if _, adjustErr := r.Exec(adjustQuery); adjustErr != nil {
+			return adjustErr
+		}
 	}
 
 	// Make friends table
-	if _, friendsErr := database.Exec("CREATE TABLE " + tableFriends + " (" +
-		friendsColumnUser + " INTEGER NOT NULL, " +
+	if _, friendsErr := r.Exec("CREATE TABLE " + tableFriends + " (" +
+		sqlDialect.ident(friendsColumnUser) + " INTEGER NOT NULL, " +
 		friendsColumnFriend + " INTEGER NOT NULL, " +
 		friendsColumnStatus + " INTEGER NOT NULL" +
 		");"); friendsErr != nil {
@@ -94,20 +97,16 @@ func createUserTableSQL() error {
 		return friendsErr
 	}
 
-	if rememberMe {
-		if cErr := createAutologsTableSQL(); cErr != nil {
-			return cErr
-		}
-	}
-
 	return nil
 }
 
-func createAutologsTableSQL() error {
-	if _, aErr := database.Exec("CREATE TABLE " + tableAutologs + " (" +
+func createAutologsTableSQL(r sqlRunner) error {
+	if _, aErr := r.Exec("CREATE TABLE " + tableAutologs + " (" +
 		autologsColumnID + " INTEGER NOT NULL, " +
 		autologsColumnDevicePass + " VARCHAR(255) NOT NULL, " +
 		autologsColumnDeviceTag + " VARCHAR(255) NOT NULL, " +
+		autologsColumnCreated + " BIGINT NOT NULL DEFAULT 0, " +
+		autologsColumnLastUsed + " BIGINT NOT NULL DEFAULT 0" +
 		");"); aErr != nil {
 
 		return aErr
@@ -115,50 +114,63 @@ func createAutologsTableSQL() error {
 	return nil
 }
 
-func addNewCustomItemsSQL() error {
-	query := "ALTER TABLE " + tableUsers + " "
-	var execQuery bool
-	//
-	for key, val := range customAccountInfo {
-		// Check if item exists
-		checkRows, err := database.Query("SHOW COLUMNS FROM " + tableUsers + " LIKE '" + key + "';")
-		if err != nil {
-			return err
-		}
-		//
-		checkRows.Next()
-		_, colsErr := checkRows.Columns()
-		if colsErr != nil {
-			// The item doesn't exist yet...
-			fmt.Println("Adding AccountInfoColumn '" + key + "'...")
-			query = query + "ADD COLUMN " + key + " " + dataTypes[val.dataType]
-			if isSizeDataType(val.dataType) {
-				query = query + "(" + strconv.Itoa(val.maxSize) + ")"
-			} else if isPrecisionDataType(val.dataType) {
-				query = query + "(" + strconv.Itoa(val.maxSize) + ", " + strconv.Itoa(val.precision) + ")"
-			}
-			// Unique check
-			if val.unique {
-				query = query + " UNIQUE"
-			}
-			// Not-null check
-			if val.notNull {
-				query = query + " NOT NULL, "
-			} else {
-				query = query + ", "
-			}
-			execQuery = true
-		}
-		checkRows.Close()
+func createBansTableSQL(r sqlRunner) error {
+	if _, bErr := r.Exec("CREATE TABLE " + tableBans + " (" +
+		bansColumnKind + " VARCHAR(8) NOT NULL, " +
+		bansColumnTarget + " VARCHAR(255) NOT NULL, " +
+		bansColumnReason + " VARCHAR(255) NOT NULL, " +
+		bansColumnCreated + " BIGINT NOT NULL DEFAULT 0, " +
+		bansColumnExpires + " BIGINT NOT NULL DEFAULT 0" +
+		");"); bErr != nil {
+
+		return bErr
 	}
-	if execQuery {
-		// Make new columns
-		query = query[0:len(query)-2] + ";"
-		_, colsErr := database.Exec(query)
-		if colsErr != nil {
-			return colsErr
+	return nil
+}
+
+func createSavedGamesTablesSQL(r sqlRunner) error {
+	dataType, typeErr := sqlDialect.columnType(AccountInfoColumn{dataType: DataTypeMediumText})
+	if typeErr != nil {
+		return typeErr
+	}
+	if _, err := r.Exec("CREATE TABLE " + tableSavedGames + " (" +
+		savedGamesColumnID + " BIGINT NOT NULL, " +
+		savedGamesColumnSlot + " VARCHAR(255) NOT NULL, " +
+		savedGamesColumnRoom + " VARCHAR(255) NOT NULL, " +
+		savedGamesColumnType + " VARCHAR(255) NOT NULL, " +
+		savedGamesColumnVersion + " INTEGER NOT NULL, " +
+		savedGamesColumnSaved + " BIGINT NOT NULL, " +
+		savedGamesColumnData + " " + dataType + " NOT NULL, " +
+		"PRIMARY KEY (" + savedGamesColumnID + "));"); err != nil {
+
+		return err
+	}
+	_, err := r.Exec("CREATE TABLE " + tableSavedGamePlayers + " (" +
+		savedPlayersColumnGame + " BIGINT NOT NULL, " +
+		savedPlayersColumnPlayer + " INTEGER NOT NULL);")
+	return err
+}
+
+func addAccountInfoColumnSQL(r sqlRunner, name string, col AccountInfoColumn) error {
+	colType, typeErr := sqlDialect.columnType(col)
+	if typeErr != nil {
+		return typeErr
+	}
+	// Some databases can only add one column at a time, and none of them inline unique
+	query := "ALTER TABLE " + tableUsers + " ADD COLUMN " + name + " " + colType
+	// Not-null check
+	if col.notNull {
+		query = query + " NOT NULL"
+	}
+	if _, colsErr := r.Exec(query + ";"); colsErr != nil {
+		return colsErr
+	}
+	// Unique check
+	if col.unique {
+		if _, uniqueErr := r.Exec(sqlDialect.addUniqueQuery(tableUsers, name)); uniqueErr != nil {
+			return uniqueErr
 		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"context"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"path/filepath"
+	"testing"
+)
+
+// testSQLite initializes the database package with a new SQLite file, and closes it when the test ends.
+func testSQLite(t *testing.T) {
+	if err := Init(DriverSQLite, "", "", filepath.Join(t.TempDir(), "gopher.db"), "", "", 0, 4, true, ""); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := Close(); err != nil {
+			t.Error(err)
+		}
+		sqlDialect = mySQLDialect{}
+	})
+}
+
+func TestSQLiteAccounts(t *testing.T) {
+	if err := NewAccountInfoColumn("email", DataTypeVarChar, 64, 0, true, true, false); err != nil {
+		t.Fatal(err)
+	}
+	defer delete(customAccountInfo, "email")
+	testSQLite(t)
+
+	// Sign up
+	if err := SignUpClient("gopher", "secret", map[string]interface{}{"email": "gopher@golang.org"}); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	if err := SignUpClient("gopher", "secret", map[string]interface{}{"email": "other@golang.org"}); err.ID == 0 {
+		t.Error("Signing up with a taken name should fail")
+	}
+	if err := SignUpClient("other", "secret", map[string]interface{}{"email": "gopher@golang.org"}); err.ID == 0 {
+		t.Error("Signing up with a taken unique AccountInfoColumn should fail")
+	}
+	if err := SignUpClient("other", "secret", nil); err.ID == 0 {
+		t.Error("Signing up without a not-null AccountInfoColumn should fail")
+	}
+
+	// Login
+	name, dbID, devicePass, err := LoginClient("gopher", "secret", "device", true, nil)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	} else if name != "gopher" || dbID != 1 {
+		t.Error("Expected gopher with database index 1, got", name, dbID)
+	} else if devicePass == "" {
+		t.Error("Logging in with remember me should make an auto-login pass")
+	}
+	if _, _, _, err := LoginClient("gopher", "wrong", "", false, nil); err.ID == 0 {
+		t.Error("Logging in with the wrong password should fail")
+	}
+	if autoName, err := AutoLoginClient("device", devicePass, "newPass", dbID); err.ID != 0 {
+		t.Error(err.Message)
+	} else if autoName != "gopher" {
+		t.Error("Expected an auto-login as gopher, got", autoName)
+	}
+
+	// Password change
+	if err := ChangePassword("gopher", "secret", "newSecret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	if _, _, _, err := LoginClient("gopher", "secret", "", false, nil); err.ID == 0 {
+		t.Error("Logging in with the old password should fail")
+	}
+	if _, _, _, err := LoginClient("gopher", "newSecret", "", false, nil); err.ID != 0 {
+		t.Error(err.Message)
+	}
+
+	// Account deletion
+	if err := DeleteAccount("gopher", "secret", nil); err.ID == 0 {
+		t.Error("Deleting an account with the wrong password should fail")
+	}
+	if err := DeleteAccount("gopher", "newSecret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	if _, _, _, err := LoginClient("gopher", "newSecret", "", false, nil); err.ID == 0 {
+		t.Error("Logging in to a deleted account should fail")
+	}
+}
+
+func TestSQLiteDeleteAccount(t *testing.T) {
+	testSQLite(t)
+	for _, name := range []string{"gopher", "friend"} {
+		if err := SignUpClient(name, "secret", nil); err.ID != 0 {
+			t.Fatal(err.Message)
+		}
+	}
+	FriendRequest(1, 2)
+	FriendRequestAccepted(2, 1)
+	FlushWrites()
+	if _, _, _, err := LoginClient("gopher", "secret", "device", true, nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	if err := SaveBan(BanRecord{Kind: banKindName, Target: "gopher", Reason: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	var purged []interface{}
+	AccountPurgeCallback = func(dbID int, userName string) { purged = append(purged, dbID, userName) }
+	defer func() { AccountPurgeCallback = nil }()
+	countRows := func(table string) int {
+		var count int
+		if err := database.QueryRow("SELECT COUNT(*) FROM " + table + ";").Scan(&count); err != nil {
+			t.Fatal(err)
+		}
+		return count
+	}
+
+	// A failure part way through deletes nothing
+	if _, err := database.Exec("ALTER TABLE " + tableBans + " RENAME TO bans_moved;"); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteAccount("gopher", "secret", nil); err.ID == 0 {
+		t.Fatal("Deleting the account should fail without the bans table")
+	}
+	if _, err := database.Exec("ALTER TABLE bans_moved RENAME TO " + tableBans + ";"); err != nil {
+		t.Fatal(err)
+	}
+	if friends, _ := GetFriends(2); friends["gopher"] == nil || countRows(tableAutologs) != 1 || countRows(tableUsers) != 2 {
+		t.Fatal("The failed deletion should have been rolled back")
+	} else if len(purged) != 0 {
+		t.Error("The purge callback shouldn't run when the deletion fails")
+	}
+
+	// Everything tied to the account goes with it
+	if err := DeleteAccount("gopher", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	if friends, _ := GetFriends(2); len(friends) != 0 {
+		t.Error("friend should have no friends left, has", len(friends))
+	}
+	if countRows(tableAutologs) != 0 || countRows(tableBans) != 0 || countRows(tableUsers) != 1 {
+		t.Error("Expected the auto-logins, ban and account to be deleted")
+	}
+	if len(purged) != 2 || purged[0] != 1 || purged[1] != "gopher" {
+		t.Error("Expected the purge callback for gopher, got", purged)
+	}
+}
+
+func TestSQLiteFriends(t *testing.T) {
+	testSQLite(t)
+	for _, name := range []string{"gopher", "friend"} {
+		if err := SignUpClient(name, "secret", nil); err.ID != 0 {
+			t.Fatal(err.Message)
+		}
+	}
+
+	if err := FriendRequest(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	FlushWrites()
+	friends, err := GetFriends(2)
+	if err != nil {
+		t.Fatal(err)
+	} else if f, ok := friends["gopher"]; !ok || f.RequestStatus() != FriendStatusRequested {
+		t.Error("friend should have a friend request from gopher")
+	}
+	if err := FriendRequestAccepted(2, 1); err != nil {
+		t.Fatal(err)
+	}
+	FlushWrites()
+	if friends, _ := GetFriends(1); friends["friend"] == nil || friends["friend"].RequestStatus() != FriendStatusAccepted {
+		t.Error("gopher and friend should be friends")
+	}
+	if err := RemoveFriend(1, 2); err != nil {
+		t.Fatal(err)
+	}
+	FlushWrites()
+	if friends, _ := GetFriends(1); len(friends) != 0 {
+		t.Error("gopher should have no friends left, has", len(friends))
+	}
+}
+
+func TestDatabaseHealth(t *testing.T) {
+	states := make(chan bool, 2)
+	DatabaseStateChangeCallback = func(up bool) { states <- up }
+	defer func() { DatabaseStateChangeCallback = nil }()
+	testSQLite(t)
+
+	if !Healthy() {
+		t.Fatal("The database should be healthy after Init()")
+	} else if !checkHealth() || len(states) != 0 {
+		t.Error("Checking a healthy database should not change its state")
+	}
+
+	// Closing the pool makes the ping fail like a dropped connection would
+	database.Close()
+	if checkHealth() || Healthy() {
+		t.Error("A database that can't be pinged should be unhealthy")
+	} else if up := <-states; up {
+		t.Error("The state change callback should have been called with false")
+	}
+	if _, _, _, err := LoginClient("gopher", "secret", "", false, nil); err.ID != helpers.ErrorDatabaseUnavailable {
+		t.Error("Logging in while the database is down should return ErrorDatabaseUnavailable, got", err.ID)
+	}
+}
+
+func TestSQLiteBans(t *testing.T) {
+	testSQLite(t)
+
+	if err := SaveBan(BanRecord{Kind: "n", Target: "gopher", Reason: "Cheating", Created: 100, Expires: 200}); err != nil {
+		t.Fatal(err)
+	}
+	// Saving a ban on the same target replaces it
+	if err := SaveBan(BanRecord{Kind: "n", Target: "gopher", Reason: "Spamming (again); said \"it's fine\"", Created: 300}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveBan(BanRecord{Kind: "ip", Target: "203.0.113.5", Created: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := SaveBan(BanRecord{Kind: "n", Target: "gopher'); DROP TABLE bans;--"}); err == nil {
+		t.Error("Expected a malicious ban target to be rejected")
+	}
+	bans, err := GetBans()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(bans) != 2 {
+		t.Fatal("Expected 2 bans, got", bans)
+	}
+	for _, ban := range bans {
+		if ban.Kind == "n" && (ban.Target != "gopher" || ban.Reason != "Spamming (again); said \"it's fine\"" || ban.Created != 300 || ban.Expires != 0) {
+			t.Error("Expected the replaced account ban, got", ban)
+		}
+	}
+
+	// Removing the expired ban leaves the one that replaced it
+	if err := RemoveExpiredBan("n", "gopher", 200); err != nil {
+		t.Fatal(err)
+	} else if bans, err = GetBans(); err != nil {
+		t.Fatal(err)
+	} else if len(bans) != 2 {
+		t.Error("Expected the replacing ban to stay, got", bans)
+	}
+
+	if err := RemoveBan("n", "gopher"); err != nil {
+		t.Fatal(err)
+	}
+	if bans, err = GetBans(); err != nil {
+		t.Fatal(err)
+	} else if len(bans) != 1 || bans[0].Target != "203.0.113.5" {
+		t.Error("Expected only the IP address ban left, got", bans)
+	}
+}
+
+func TestSQLiteChangeAccountName(t *testing.T) {
+	testSQLite(t)
+	for _, name := range []string{"gopher", "taken"} {
+		if err := SignUpClient(name, "secret", nil); err.ID != 0 {
+			t.Fatal(err.Message)
+		}
+	}
+	_, dbID, devicePass, err := LoginClient("gopher", "secret", "device", true, nil)
+	if err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+
+	if err := ChangeAccountName(dbID, "gopher2", "wrong"); err.ID != helpers.ErrorAuthIncorrectLogin {
+		t.Error("Renaming with the wrong password should fail, got", err.ID)
+	}
+	if err := ChangeAccountName(dbID, "taken", "secret"); err.ID != helpers.ErrorAuthNameUnavail {
+		t.Error("Renaming to a taken name should fail, got", err.ID)
+	}
+	NameChangeCallback = func(oldName string, newName string, dbID int) bool { return newName != "badword" }
+	defer func() { NameChangeCallback = nil }()
+	if err := ChangeAccountName(dbID, "badword", "secret"); err.ID != helpers.ErrorActionDenied {
+		t.Error("The name change callback should be able to deny a rename, got", err.ID)
+	}
+	if err := ChangeAccountName(dbID, "gopher2", "secret"); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+
+	// The account logs in with its new name, and keeps its remembered device
+	if _, _, _, err := LoginClient("gopher", "secret", "", false, nil); err.ID == 0 {
+		t.Error("The old name should not log in anymore")
+	}
+	if name, _, _, err := LoginClient("gopher2", "secret", "", false, nil); err.ID != 0 || name != "gopher2" {
+		t.Error("Expected to log in as gopher2, got", name, err.Message)
+	}
+	if autoName, err := AutoLoginClient("device", devicePass, "newPass", dbID); err.ID != 0 || autoName != "gopher2" {
+		t.Error("Expected an auto-login as gopher2, got", autoName, err.Message)
+	}
+}
+
+func TestSQLiteNamePolicy(t *testing.T) {
+	helpers.SetNamePolicy(helpers.NamePolicy{MinLength: 3, MaxLength: 12, Chars: helpers.NameCharsAny, Normalize: true, Unique: true,
+		Banned: []string{"admin"}})
+	defer helpers.SetNamePolicy(helpers.NamePolicy{})
+	testSQLite(t)
+
+	// Every rule a name can break has its own error
+	for _, signUp := range []struct {
+		name     string
+		expected int
+	}{
+		{"ab", helpers.ErrorNameLength},
+		{"averyverylongname", helpers.ErrorNameLength},
+		{"the4dmin", 0},
+		{"theAdmin", helpers.ErrorNameBanned},
+		{"paypal", 0},
+		{"раypal", helpers.ErrorNameConfusable},
+		{"PayPaI", helpers.ErrorNameConfusable},
+	} {
+		if err := SignUpClient(signUp.name, "secret", nil); err.ID != signUp.expected {
+			t.Error("Expected signing up as", signUp.name, "to get error", signUp.expected, "got", err.ID, err.Message)
+		}
+	}
+
+	// Names are saved and looked up in NFKC form
+	if err := SignUpClient("ｇｏｐｈｅｒ", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	name, dbID, _, err := LoginClient("ｇｏｐｈｅｒ", "secret", "", false, nil)
+	if err.ID != 0 || name != "gopher" {
+		t.Fatal("Expected to log in as gopher, got", name, err.Message)
+	}
+
+	// Renaming follows the same rules, but an account can look like its old name
+	if err := ChangeAccountName(dbID, "pаypal", "secret"); err.ID != helpers.ErrorNameConfusable {
+		t.Error("Renaming to a lookalike name should fail, got", err.ID)
+	} else if err := ChangeAccountName(dbID, "Gopher", "secret"); err.ID != 0 {
+		t.Error("Renaming to a name that looks like the account's own should work, got", err.Message)
+	}
+	if lookalike, _ := NameLookalikeExists(context.Background(), "Pаypal"); !lookalike {
+		t.Error("A guest shouldn't be able to take a name that looks like an account's")
+	}
+}
+
+func TestSQLiteSavedGames(t *testing.T) {
+	testSQLite(t)
+	for _, name := range []string{"gopher", "friend"} {
+		if err := SignUpClient(name, "secret", nil); err.ID != 0 {
+			t.Fatal(err.Message)
+		}
+	}
+	ctx := context.Background()
+
+	// Saving to the same slot replaces the game, and keeps its ID
+	game := SavedGame{Slot: "slot", Room: "chess \"room\"", RoomType: "chess", Version: 1, Saved: 100}
+	id, err := SaveGame(ctx, game, []int{1, 2}, `{"board": "it's x's turn"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	game.Saved = 200
+	if again, err := SaveGame(ctx, game, []int{1}, `{"board": "o's turn"}`); err != nil || again != id {
+		t.Fatal("Expected saving to the same slot to keep the ID", id, "got", again, err)
+	}
+	if loaded, data, err := LoadGame(ctx, id); err != nil || loaded.Room != game.Room || loaded.Saved != 200 || data != `{"board": "o's turn"}` {
+		t.Error("Expected the replaced game, got", loaded, data, err)
+	}
+	if games, err := ListSavedGames(1); err != nil || len(games) != 1 || games[0].ID != id {
+		t.Error("Expected gopher to have the game, got", games, err)
+	} else if games, _ := ListSavedGames(2); len(games) != 0 {
+		t.Error("friend was left out of the new save, but has", games)
+	}
+
+	// Deleting an account takes it out of its games, and deletes them with PurgeSavedGames
+	if err := DeleteAccount("friend", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	} else if _, _, err := LoadGame(ctx, id); err != nil {
+		t.Error("The game should be kept without PurgeSavedGames, got", err)
+	}
+	purgeSavedGames = true
+	defer func() { purgeSavedGames = false }()
+	if err := DeleteAccount("gopher", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	} else if _, _, err := LoadGame(ctx, id); err != ErrSavedGameNotFound {
+		t.Error("The game should be deleted with PurgeSavedGames, got", err)
+	}
+}
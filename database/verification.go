@@ -0,0 +1,116 @@
+package database
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strconv"
+	"time"
+)
+
+var (
+	//EMAIL VERIFICATION SETTINGS
+	requireVerification bool
+	verificationTTL     time.Duration = 24 * time.Hour
+)
+
+// SetEmailVerification is only for internal Gopher Game Server mechanics. Use RequireEmailVerification and
+// VerificationTokenTTL in ServerSettings to require new accounts to be verified before they can log in.
+func SetEmailVerification(require bool, ttl time.Duration) {
+	requireVerification = require
+	if ttl > 0 {
+		verificationTTL = ttl
+	}
+}
+
+// ADDS THE VERIFICATION COLUMNS TO THE users TABLE. ACCOUNTS MADE BEFORE VERIFICATION WAS REQUIRED COUNT AS VERIFIED.
+func addVerificationColumnsSQL(r sqlRunner) error {
+	columns := [][]string{
+		{usersColumnVerified, "SMALLINT NOT NULL DEFAULT 1"},
+		{usersColumnVerifyToken, "VARCHAR(64)"},
+		{usersColumnVerifyExpires, "BIGINT"},
+	}
+	for _, col := range columns {
+		if exists, err := columnExists(r, tableUsers, col[0]); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		helpers.Log().Info("Adding email verification column '" + col[0] + "'...")
+		if _, err := r.Exec("ALTER TABLE " + tableUsers + " ADD COLUMN " + col[0] + " " + col[1] + ";"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MAKES A NEW TOKEN AND ITS EXPIRATION TIME FOR A QUERY
+func newVerificationToken() (string, string, error) {
+	token, err := helpers.GenerateSecureString(32)
+	if err != nil {
+		return "", "", err
+	}
+	return token, strconv.FormatInt(time.Now().Add(verificationTTL).Unix(), 10), nil
+}
+
+// GenerateVerificationToken makes a new email verification token for an account that hasn't been verified yet, for instance
+// to send the User a new email when their last token expired. Any token made before for the account stops working. The token is
+// valid for VerificationTokenTTL in ServerSettings, and can only be used once with VerifyAccount().
+func GenerateVerificationToken(userName string) (string, error) {
+	if !requireVerification {
+		return "", errors.New("RequireEmailVerification in ServerSettings is not enabled")
+	} else if checkStringSQLInjection(userName) {
+		return "", errors.New(errorMaliciousChars)
+	}
+	var verified int
+	if err := database.QueryRow("SELECT " + usersColumnVerified + " FROM " + tableUsers + " WHERE " + usersColumnName + "=" +
+		sqlDialect.quote(userName) + ";").Scan(&verified); err != nil {
+		return "", errors.New("The account '" + userName + "' does not exist")
+	} else if verified != 0 {
+		return "", errors.New("The account '" + userName + "' is already verified")
+	}
+	token, expires, tokenErr := newVerificationToken()
+	if tokenErr != nil {
+		return "", tokenErr
+	}
+	if _, err := exec("UPDATE " + tableUsers + " SET " + usersColumnVerifyToken + "=" + sqlDialect.quote(token) + ", " +
+		usersColumnVerifyExpires + "=" + expires + " WHERE " + usersColumnName + "=" + sqlDialect.quote(userName) + ";"); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// VerifyAccount verifies the account a token was made for, so the User can log in. Tokens can only be used once, and
+// stop working after VerificationTokenTTL in ServerSettings. You can make a new one with GenerateVerificationToken().
+func VerifyAccount(token string) error {
+	if len(token) == 0 || checkStringSQLInjection(token) {
+		return errors.New("Invalid verification token")
+	}
+	var id int
+	var expires int64
+	if err := database.QueryRow("SELECT "+usersColumnID+", "+usersColumnVerifyExpires+" FROM "+tableUsers+" WHERE "+
+		usersColumnVerifyToken+"="+sqlDialect.quote(token)+" AND "+usersColumnVerified+"=0;").Scan(&id, &expires); err != nil {
+		return errors.New("Invalid verification token")
+	} else if time.Now().Unix() > expires {
+		return errors.New("The verification token has expired")
+	}
+	// Only the first use of the token matches
+	result, err := exec("UPDATE " + tableUsers + " SET " + usersColumnVerified + "=1, " + usersColumnVerifyToken + "=NULL, " +
+		usersColumnVerifyExpires + "=NULL WHERE " + usersColumnID + "=" + strconv.Itoa(id) + " AND " + usersColumnVerifyToken + "=" +
+		sqlDialect.quote(token) + ";")
+	if err != nil {
+		return err
+	} else if rows, _ := result.RowsAffected(); rows == 0 {
+		return errors.New("Invalid verification token")
+	}
+	return nil
+}
+
+// CHECKS IF THE ACCOUNT WITH THE DATABASE INDEX dbIndex HAS BEEN VERIFIED
+func accountVerified(dbIndex int) bool {
+	var verified int
+	if err := database.QueryRow("SELECT " + usersColumnVerified + " FROM " + tableUsers + " WHERE " + usersColumnID + "=" +
+		strconv.Itoa(dbIndex) + ";").Scan(&verified); err != nil {
+		return false
+	}
+	return verified != 0
+}
@@ -0,0 +1,72 @@
+package database
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"testing"
+	"time"
+)
+
+func TestEmailVerification(t *testing.T) {
+	var token string
+	SignUpCallback = func(userName string, clientColumns map[string]interface{}, verificationToken string) bool {
+		token = verificationToken
+		return true
+	}
+	SetEmailVerification(true, time.Hour)
+	defer func() {
+		SignUpCallback = nil
+		requireVerification = false
+		verificationTTL = 24 * time.Hour
+	}()
+	testSQLite(t)
+
+	if err := SignUpClient("gopher", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	} else if token == "" {
+		t.Fatal("The sign up callback should receive a verification token")
+	}
+	if _, _, _, err := LoginClient("gopher", "secret", "", false, nil); err.ID != helpers.ErrorAuthUnverified {
+		t.Error("Logging in before verifying should return ErrorAuthUnverified, got", err.ID)
+	}
+	if _, _, _, err := LoginClient("gopher", "wrong", "", false, nil); err.ID != helpers.ErrorAuthIncorrectLogin {
+		t.Error("A wrong password should not reveal that the account is unverified")
+	}
+
+	if err := VerifyAccount("wrongToken"); err == nil {
+		t.Error("VerifyAccount() should reject an unknown token")
+	}
+	if err := VerifyAccount(token); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyAccount(token); err == nil {
+		t.Error("A verification token should only work once")
+	}
+	if _, _, _, err := LoginClient("gopher", "secret", "", false, nil); err.ID != 0 {
+		t.Error(err.Message)
+	}
+	if _, err := GenerateVerificationToken("gopher"); err == nil {
+		t.Error("GenerateVerificationToken() should fail for a verified account")
+	}
+
+	// Expired and replaced tokens
+	if err := SignUpClient("late", "secret", nil); err.ID != 0 {
+		t.Fatal(err.Message)
+	}
+	first := token
+	verificationTTL = -time.Second
+	expired, genErr := GenerateVerificationToken("late")
+	if genErr != nil {
+		t.Fatal(genErr)
+	}
+	if err := VerifyAccount(first); err == nil {
+		t.Error("Making a new token should replace the old one")
+	}
+	if err := VerifyAccount(expired); err == nil {
+		t.Error("VerifyAccount() should reject an expired token")
+	}
+	verificationTTL = time.Hour
+	fresh, _ := GenerateVerificationToken("late")
+	if err := VerifyAccount(fresh); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,284 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Query is a write to the database for QueueWrite(). The SQL can have placeholders for Args, like "?" with MySQL and
+// SQLite, or "$1" with PostgreSQL.
+type Query struct {
+	SQL  string
+	Args []interface{}
+
+	// Writes with the same Key run in the order they were queued, one after another. Writes with different Keys, or no Key,
+	// can run at the same time on different workers.
+	Key string
+
+	flushed chan struct{} // FOR FlushWrites() - CLOSED ONCE THE WRITES QUEUED BEFORE IT HAVE RUN
+}
+
+// writeWorker runs the queued writes for the Keys that hash to it, in batches.
+type writeWorker struct {
+	queue chan Query
+	done  chan struct{} // CLOSED WHEN THE WORKER HAS RUN EVERYTHING IN ITS queue
+}
+
+const (
+	defaultWriteWorkers       = 2
+	defaultWriteBatchSize     = 50
+	defaultWriteFlushInterval = time.Millisecond * 100
+	defaultWriteQueueSize     = 1024
+
+	// HOW MANY TIMES A WRITE IS RETRIED WHEN THE DATABASE CAN'T BE REACHED, BEFORE IT FAILS FOR GOOD
+	writeRetries = 5
+)
+
+var (
+	//WRITE QUEUE SETTINGS
+	writeWorkers       = defaultWriteWorkers
+	writeBatchSize     = defaultWriteBatchSize
+	writeFlushInterval = defaultWriteFlushInterval
+	writeQueueSize     = defaultWriteQueueSize
+
+	//writeQueueMux LOCKS workers, SO NOTHING IS QUEUED WHILE THE WORKERS DRAIN
+	writeQueueMux sync.RWMutex
+	workers       []*writeWorker
+	nextWorker    uint32 // ATOMIC - FOR WRITES WITHOUT A Key
+
+	queuedWrites int64  // ATOMIC
+	failedWrites uint64 // ATOMIC
+	retriedWrite uint64 // ATOMIC
+
+	// Swapped out by tests that can't wait
+	writeRetryDelay = time.Millisecond * 200
+
+	// WriteFailedCallback is only for internal Gopher Game Server mechanics.
+	WriteFailedCallback func(Query, error)
+
+	errWriteQueueClosed = errors.New("The database write queue is closed")
+)
+
+// SetWriteQueue is only for internal Gopher Game Server mechanics. Use SqlWriteWorkers, SqlWriteBatchSize,
+// SqlWriteFlushInterval and SqlWriteQueueSize in ServerSettings to configure the write queue.
+func SetWriteQueue(workerCount int, batchSize int, flushInterval time.Duration, queueSize int) {
+	if workerCount > 0 {
+		writeWorkers = workerCount
+	}
+	if batchSize > 0 {
+		writeBatchSize = batchSize
+	}
+	if flushInterval > 0 {
+		writeFlushInterval = flushInterval
+	}
+	if queueSize > 0 {
+		writeQueueSize = queueSize
+	}
+}
+
+// WriteQueueStats gets the number of writes waiting in the write queue, the number of writes that failed for good, and the
+// number of times a write was retried because the database couldn't be reached.
+func WriteQueueStats() (int, uint64, uint64) {
+	return int(atomic.LoadInt64(&queuedWrites)), atomic.LoadUint64(&failedWrites), atomic.LoadUint64(&retriedWrite)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   QUEUEING WRITES   ///////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// QueueWrite queues a write to run in the background, so whatever made it doesn't wait on the database. The queued writes
+// are run in batches every SqlWriteFlushInterval, or as soon as SqlWriteBatchSize of them are waiting. A write that fails
+// because the database couldn't be reached is retried with a backoff, and the write failed callback gets the ones that
+// fail for good. When the queue is full, the write runs right away instead.
+//
+// Only queue writes that nothing is waiting to read back. Pending writes are run before the database closes when the
+// server shuts down.
+func QueueWrite(q Query) error {
+	if !inited {
+		return errors.New("The SQL features are not enabled")
+	} else if q.SQL == "" {
+		return errors.New("database.QueueWrite() requires a query")
+	}
+	writeQueueMux.RLock()
+	if len(workers) == 0 {
+		writeQueueMux.RUnlock()
+		return errWriteQueueClosed
+	}
+	var w *writeWorker
+	if q.Key == "" {
+		w = workers[atomic.AddUint32(&nextWorker, 1)%uint32(len(workers))]
+	} else {
+		w = workers[keyHash(q.Key)%uint32(len(workers))]
+	}
+	select {
+	case w.queue <- q:
+		atomic.AddInt64(&queuedWrites, 1)
+		writeQueueMux.RUnlock()
+		return nil
+	default:
+	}
+	writeQueueMux.RUnlock()
+
+	// THE QUEUE IS FULL - WRITE IT NOW, SO IT ISN'T LOST
+	_, err := execContext(context.Background(), q.SQL, q.Args...)
+	return err
+}
+
+// FlushWrites waits for every write queued before it to run, like before reading back something that was queued.
+func FlushWrites() {
+	writeQueueMux.RLock()
+	var waiting []chan struct{}
+	for _, w := range workers {
+		flushed := make(chan struct{})
+		w.queue <- Query{flushed: flushed}
+		waiting = append(waiting, flushed)
+	}
+	writeQueueMux.RUnlock()
+	for _, flushed := range waiting {
+		<-flushed
+	}
+}
+
+// keyHash is the FNV-1a hash of a Query's Key.
+func keyHash(key string) uint32 {
+	var hash uint32 = 2166136261
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return hash
+}
+
+func startWriteQueue() {
+	writeQueueMux.Lock()
+	workers = make([]*writeWorker, writeWorkers)
+	for i := range workers {
+		workers[i] = &writeWorker{queue: make(chan Query, writeQueueSize), done: make(chan struct{})}
+		go workers[i].run()
+	}
+	writeQueueMux.Unlock()
+}
+
+// drainWriteQueue stops taking writes, and waits for the workers to run the ones still waiting.
+func drainWriteQueue() {
+	writeQueueMux.Lock()
+	stopping := workers
+	workers = nil
+	for _, w := range stopping {
+		close(w.queue)
+	}
+	writeQueueMux.Unlock()
+	if queued := atomic.LoadInt64(&queuedWrites); queued > 0 {
+		helpers.Log().Info("Running queued database writes", "queued", queued)
+	}
+	for _, w := range stopping {
+		<-w.done
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   RUNNING WRITES   ////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// run gathers the worker's writes into batches until its queue is closed and empty.
+func (w *writeWorker) run() {
+	defer close(w.done)
+	batch := make([]Query, 0, writeBatchSize)
+	var flush <-chan time.Time
+	for {
+		select {
+		case q, ok := <-w.queue:
+			if !ok {
+				runBatch(batch)
+				return
+			} else if q.flushed != nil {
+				runBatch(batch)
+				close(q.flushed)
+				batch = batch[:0]
+				flush = nil
+				continue
+			}
+			batch = append(batch, q)
+			if len(batch) < writeBatchSize {
+				if flush == nil {
+					flush = time.After(writeFlushInterval)
+				}
+				continue
+			}
+		case <-flush:
+		}
+		runBatch(batch)
+		batch = batch[:0]
+		flush = nil
+	}
+}
+
+// runBatch runs a batch of writes in one transaction. When the transaction fails because of one of the writes, they are run
+// one at a time instead, so only that write fails.
+func runBatch(batch []Query) {
+	if len(batch) == 0 {
+		return
+	}
+	err := retryWrite(func() error {
+		return execBatch(batch)
+	})
+	if err != nil {
+		for _, q := range batch {
+			q := q
+			if err := retryWrite(func() error {
+				_, err := execContext(context.Background(), q.SQL, q.Args...)
+				return err
+			}); err != nil {
+				writeFailed(q, err)
+			}
+		}
+	}
+	atomic.AddInt64(&queuedWrites, -int64(len(batch)))
+}
+
+// execBatch runs the writes in a transaction.
+func execBatch(batch []Query) error {
+	if sqlDialect.serializeWrites() {
+		writeMux.Lock()
+		defer writeMux.Unlock()
+	}
+	tx, err := database.Begin()
+	if err != nil {
+		return err
+	}
+	for _, q := range batch {
+		if _, err := tx.Exec(q.SQL, q.Args...); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// retryWrite runs write until it works, it fails with an error that retrying won't fix, or it's out of retries. Waits twice
+// as long before each retry.
+func retryWrite(write func() error) error {
+	delay := writeRetryDelay
+	for i := 0; ; i++ {
+		err := write()
+		if err == nil || !connectionLost(err) || i == writeRetries {
+			return err
+		}
+		atomic.AddUint64(&retriedWrite, 1)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// writeFailed reports a queued write that failed for good.
+func writeFailed(q Query, err error) {
+	atomic.AddUint64(&failedWrites, 1)
+	helpers.Log().Error("Queued database write failed", "query", q.SQL, "error", err)
+	if WriteFailedCallback != nil {
+		WriteFailedCallback(q, err)
+	}
+}
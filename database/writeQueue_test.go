@@ -0,0 +1,60 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestWriteQueue(t *testing.T) {
+	failed := make(chan Query, 1)
+	WriteFailedCallback = func(q Query, err error) { failed <- q }
+	defer func() { WriteFailedCallback = nil }()
+	testSQLite(t)
+	if _, err := exec("CREATE TABLE queued (n INT);"); err != nil {
+		t.Fatal(err)
+	}
+	count := func() int {
+		var n int
+		if err := database.QueryRow("SELECT COUNT(*) FROM queued;").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// A bad write in a batch only fails itself
+	for i := 0; i < 10; i++ {
+		if err := QueueWrite(Query{SQL: "INSERT INTO queued (n) VALUES (?);", Args: []interface{}{i}, Key: "queued"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, failures, _ := WriteQueueStats()
+	QueueWrite(Query{SQL: "INSERT INTO missing (n) VALUES (1);", Key: "queued"})
+	QueueWrite(Query{SQL: "INSERT INTO queued (n) VALUES (10);", Key: "queued"})
+	FlushWrites()
+	if n := count(); n != 11 {
+		t.Error("Expected 11 rows after flushing, got", n)
+	}
+	select {
+	case q := <-failed:
+		if q.SQL != "INSERT INTO missing (n) VALUES (1);" {
+			t.Error("Expected the bad write in the failed callback, got", q.SQL)
+		}
+	default:
+		t.Error("The failed callback should have been called")
+	}
+	if queued, newFailures, _ := WriteQueueStats(); queued != 0 || newFailures != failures+1 {
+		t.Error("Expected nothing queued and 1 more failure, got", queued, newFailures-failures)
+	}
+
+	// Writes still queued run before the database closes
+	for i := 0; i < 5; i++ {
+		QueueWrite(Query{SQL: "INSERT INTO queued (n) VALUES (?);", Args: []interface{}{i}})
+	}
+	drainWriteQueue()
+	if n := count(); n != 16 {
+		t.Error("Expected the queued writes to run when draining, got", n, "rows")
+	}
+	if err := QueueWrite(Query{SQL: "INSERT INTO queued (n) VALUES (0);"}); err != errWriteQueueClosed {
+		t.Error("Expected writes to be refused after draining, got", err)
+	}
+	startWriteQueue()
+}
@@ -0,0 +1,438 @@
+package gophertest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTimeout = 10 * time.Second // HOW LONG A Client WAITS TO CONNECT, AND FOR RESPONSES
+	defaultBuffer  = 256              // THE SIZE OF A Client'S MESSAGE CHANNELS
+)
+
+var (
+	// ErrTimeout is returned when the server doesn't respond to a client action in time. After that, the Client can't tell which
+	// response is for which client action, so close it.
+	ErrTimeout = errors.New("Timed out waiting for the server to respond")
+	// ErrClosed is returned when the Client's connection is closed.
+	ErrClosed = errors.New("The connection is closed")
+)
+
+// Options changes how a Client connects and waits. A nil *Options uses the defaults.
+type Options struct {
+	RememberMe bool          // Set it when the server has RememberMe in its ServerSettings, so the Client tags its device when it connects
+	Timeout    time.Duration // How long to wait to connect, and for responses to client actions. Defaults to 10 seconds
+	Buffer     int           // The size of the Client's message channels. Defaults to 256
+}
+
+// Error is an error the server responded to a client action with.
+type Error struct {
+	Code    int // The error code, like helpers.ErrorRoomNotFound
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   MESSAGES   //////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// RoomMessage is a chat or server message sent to the Client's Room.
+type RoomMessage struct {
+	Author  string // The User that sent a chat message, or "" for a server message
+	SubType int    // The sub-type of a server message
+	Message interface{}
+}
+
+// PrivateMessage is a private message to or from the Client's User.
+type PrivateMessage struct {
+	From    string
+	To      string
+	Message interface{}
+}
+
+// Announcement is an announcement sent with gopher.Broadcast() or core.BroadcastToUsers().
+type Announcement struct {
+	Type string
+	Data interface{}
+}
+
+// Message is any other message from the server, like a User entering the Client's Room, or a response to a client action
+// the Client wasn't waiting for.
+type Message struct {
+	Type string      // The server action, like helpers.ServerActionUserEnter
+	Data interface{} // What came with it, decoded from JSON
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CONNECTING   ////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Client is a fake client connected to a Gopher Game Server over JSON. Its methods send client actions like the client APIs
+// do, and the ones that get a response wait for it, so a test can go step by step. They can be used from more than one
+// goroutine. Messages that aren't responses come in on the Client's channels. A channel that's full drops its messages, so
+// you only need to read the ones you care about.
+type Client struct {
+	RoomMessages    <-chan RoomMessage
+	PrivateMessages <-chan PrivateMessage
+	Announcements   <-chan Announcement
+	Messages        <-chan Message
+
+	roomMessages    chan RoomMessage
+	privateMessages chan PrivateMessage
+	announcements   chan Announcement
+	messages        chan Message
+	dropped         uint64 // ATOMIC
+
+	conn     *websocket.Conn
+	timeout  time.Duration
+	writeMux sync.Mutex
+	closed   chan bool // CLOSED ONCE THE CONNECTION IS
+
+	//mux LOCKS ALL ITEMS BELOW
+	mux       sync.Mutex
+	done      bool      // true ONCE THE CONNECTION IS CLOSED
+	waiting   []*waiter // IN THE ORDER THEIR CLIENT ACTIONS WERE SENT
+	latencies map[string][]time.Duration
+}
+
+// waiter waits for the first response with one of its keys, made of the server action and the client action, like "c:j".
+type waiter struct {
+	keys     []string
+	response chan response
+}
+
+type response struct {
+	result interface{}
+	err    error
+}
+
+// Dial connects a Client to the server at the WebSocket URL, like "ws://localhost:8080/ws". Add "?locale=" or "?version=" to
+// the URL to connect with them.
+func Dial(url string, opts *Options) (*Client, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	timeout, buffer := opts.Timeout, opts.Buffer
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if buffer <= 0 {
+		buffer = defaultBuffer
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: timeout}
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{roomMessages: make(chan RoomMessage, buffer), privateMessages: make(chan PrivateMessage, buffer),
+		announcements: make(chan Announcement, buffer), messages: make(chan Message, buffer), conn: conn, timeout: timeout,
+		closed: make(chan bool), latencies: make(map[string][]time.Duration)}
+	c.RoomMessages, c.PrivateMessages, c.Announcements, c.Messages = c.roomMessages, c.privateMessages, c.announcements, c.messages
+	if opts.RememberMe {
+		if err := c.tagDevice(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	go c.read()
+	return c, nil
+}
+
+// tagDevice answers the server's device tag requests when it has RememberMe, like a client that hasn't connected before.
+func (c *Client) tagDevice() error {
+	c.conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	for {
+		var message map[string]interface{}
+		if err := c.conn.ReadJSON(&message); err != nil {
+			return err
+		}
+		if _, ok := message[helpers.ServerActionRequestDeviceTag]; ok {
+			if err := c.Send("0", nil); err != nil {
+				return err
+			}
+		} else if tag, ok := message[helpers.ServerActionSetDeviceTag].(string); ok {
+			if err := c.Send("1", tag); err != nil {
+				return err
+			}
+		} else if _, ok := message[helpers.ServerActionAutoLoginNotFiled]; ok {
+			return nil
+		}
+	}
+}
+
+// Close closes the Client's connection, which logs its User out.
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	<-c.closed
+	return err
+}
+
+// Dropped gets the number of messages dropped because their channel was full.
+func (c *Client) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CLIENT ACTIONS   ////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Login logs the Client in. With the SQL features, the name and password are an account's. Without them, the password isn't
+// used, and the server's login callback decides who can log in.
+func (c *Client) Login(name string, password string) error {
+	_, err := c.Request(helpers.ClientActionLogin, map[string]interface{}{"n": name, "p": password, "r": false})
+	return err
+}
+
+// GuestLogin logs the Client in as a guest with a name the server makes, and returns the name.
+func (c *Client) GuestLogin() (string, error) {
+	// THE SERVER RESPONDS LIKE IT'S A LOGIN WHEN IT WORKS
+	result, err := c.request(helpers.ClientActionGuestLogin, nil, helpers.ClientActionGuestLogin,
+		helpers.ServerActionClientActionResponse+":"+helpers.ClientActionLogin,
+		helpers.ServerActionClientActionResponse+":"+helpers.ClientActionGuestLogin)
+	if err != nil {
+		return "", err
+	}
+	info, _ := result.(map[string]interface{})
+	name, _ := info["n"].(string)
+	return name, nil
+}
+
+// SignUp makes an account with the SQL features. The info is for the columns you made with database.NewAccountInfoColumn(),
+// and can be nil.
+func (c *Client) SignUp(name string, password string, info map[string]interface{}) error {
+	params := map[string]interface{}{"n": name, "p": password}
+	if info != nil {
+		params["c"] = info
+	}
+	_, err := c.Request(helpers.ClientActionSignup, params)
+	return err
+}
+
+// Logout logs the Client's User out. The Client stays connected, and can log in again.
+func (c *Client) Logout() error {
+	_, err := c.Request(helpers.ClientActionLogout, nil)
+	return err
+}
+
+// CreateRoom makes a Room of the RoomType, and joins it. The server must have UserRoomControl in its ServerSettings. A maxUsers
+// of 0 has no limit.
+func (c *Client) CreateRoom(name string, roomType string, private bool, maxUsers int) error {
+	_, err := c.Request(helpers.ClientActionCreateRoom, map[string]interface{}{"n": name, "t": roomType, "p": private, "m": maxUsers})
+	return err
+}
+
+// Join joins the Room.
+func (c *Client) Join(room string) error {
+	_, err := c.Request(helpers.ClientActionJoinRoom, room)
+	return err
+}
+
+// Leave leaves the Client's Room.
+func (c *Client) Leave() error {
+	_, err := c.Request(helpers.ClientActionLeaveRoom, nil)
+	return err
+}
+
+// Chat sends a chat message to the Client's Room. The server doesn't respond to it, so it doesn't wait. The Users in the Room
+// get it on their RoomMessages, including this one.
+func (c *Client) Chat(message interface{}) error {
+	return c.Send(helpers.ClientActionChatMessage, message)
+}
+
+// PrivateMessage sends a private message to the User. The server doesn't respond to it, so it doesn't wait.
+func (c *Client) PrivateMessage(userName string, message interface{}) error {
+	return c.Send(helpers.ClientActionPrivateMessage, map[string]interface{}{"u": userName, "m": message})
+}
+
+// CustomAction runs your custom client action with the data, and waits for its response. Only use it for custom client
+// actions that respond. Send the others with SendCustomAction().
+func (c *Client) CustomAction(action string, data interface{}) (interface{}, error) {
+	return c.request(helpers.ClientActionCustomAction, map[string]interface{}{"a": action, "d": data}, action,
+		helpers.ServerActionCustomClientActionResponse+":"+action)
+}
+
+// SendCustomAction runs your custom client action with the data, without waiting for a response.
+func (c *Client) SendCustomAction(action string, data interface{}) error {
+	return c.Send(helpers.ClientActionCustomAction, map[string]interface{}{"a": action, "d": data})
+}
+
+// Request sends a client action, like helpers.ClientActionJoinRoom, and waits for the server's response to it. Use it for the
+// client actions the Client doesn't have a method for. Returns the response, or an *Error when the server responds with one.
+func (c *Client) Request(action string, params interface{}) (interface{}, error) {
+	return c.request(action, params, action, helpers.ServerActionClientActionResponse+":"+action)
+}
+
+// Send sends a client action without waiting for a response.
+func (c *Client) Send(action string, params interface{}) error {
+	c.writeMux.Lock()
+	defer c.writeMux.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	return c.conn.WriteJSON(map[string]interface{}{"A": action, "P": params})
+}
+
+// request sends a client action, and waits for the first response with one of the keys. The time it took is kept under stat.
+func (c *Client) request(action string, params interface{}, stat string, keys ...string) (interface{}, error) {
+	w := &waiter{keys: keys, response: make(chan response, 1)}
+	c.mux.Lock()
+	if c.done {
+		c.mux.Unlock()
+		return nil, ErrClosed
+	}
+	c.waiting = append(c.waiting, w)
+	c.mux.Unlock()
+
+	start := time.Now()
+	if err := c.Send(action, params); err != nil {
+		c.forget(w)
+		return nil, err
+	}
+	select {
+	case r := <-w.response:
+		if r.err != ErrClosed {
+			c.mux.Lock()
+			c.latencies[stat] = append(c.latencies[stat], time.Since(start))
+			c.mux.Unlock()
+		}
+		return r.result, r.err
+	case <-time.After(c.timeout):
+		return nil, ErrTimeout
+	}
+}
+
+func (c *Client) forget(w *waiter) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i, waiting := range c.waiting {
+		if waiting == w {
+			c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+			return
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   RECEIVING   /////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// read reads the server's messages until the connection closes, then lets the waiting client actions know.
+func (c *Client) read() {
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			break
+		} else if messageType != websocket.TextMessage {
+			// VOICE FRAMES
+			continue
+		}
+		var message map[string]interface{}
+		if json.Unmarshal(data, &message) != nil {
+			continue
+		}
+		for serverAction, body := range message {
+			c.receive(serverAction, body)
+		}
+	}
+	c.mux.Lock()
+	c.done = true
+	waiting := c.waiting
+	c.waiting = nil
+	c.mux.Unlock()
+	for _, w := range waiting {
+		w.response <- response{err: ErrClosed}
+	}
+	close(c.closed)
+}
+
+// receive gives a message to the client action waiting for it, or puts it on its channel.
+func (c *Client) receive(serverAction string, body interface{}) {
+	data, _ := body.(map[string]interface{})
+	var delivered bool
+	switch serverAction {
+	case helpers.ServerActionClientActionResponse, helpers.ServerActionCustomClientActionResponse:
+		action, _ := data["a"].(string)
+		if c.respond(serverAction+":"+action, data) {
+			return
+		}
+	case helpers.ServerActionRoomMessage:
+		message := RoomMessage{Message: data["m"]}
+		message.Author, _ = data["a"].(string)
+		if subType, ok := data["s"].(float64); ok {
+			message.SubType = int(subType)
+		}
+		select {
+		case c.roomMessages <- message:
+			delivered = true
+		default:
+		}
+		c.countDropped(delivered)
+		return
+	case helpers.ServerActionPrivateMessage:
+		message := PrivateMessage{Message: data["m"]}
+		message.From, _ = data["f"].(string)
+		message.To, _ = data["t"].(string)
+		select {
+		case c.privateMessages <- message:
+			delivered = true
+		default:
+		}
+		c.countDropped(delivered)
+		return
+	case helpers.ServerActionAnnouncement:
+		announcement := Announcement{Data: data["d"]}
+		announcement.Type, _ = data["t"].(string)
+		select {
+		case c.announcements <- announcement:
+			delivered = true
+		default:
+		}
+		c.countDropped(delivered)
+		return
+	}
+	select {
+	case c.messages <- Message{Type: serverAction, Data: body}:
+		delivered = true
+	default:
+	}
+	c.countDropped(delivered)
+}
+
+func (c *Client) countDropped(delivered bool) {
+	if !delivered {
+		atomic.AddUint64(&c.dropped, 1)
+	}
+}
+
+// respond gives a response to the first client action waiting for it. Returns false when none are.
+func (c *Client) respond(key string, data map[string]interface{}) bool {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	for i, w := range c.waiting {
+		for _, waitingKey := range w.keys {
+			if waitingKey != key {
+				continue
+			}
+			c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+			if e, ok := data["e"].(map[string]interface{}); ok {
+				err := &Error{}
+				err.Message, _ = e["m"].(string)
+				if code, ok := e["c"].(float64); ok {
+					err.Code = int(code)
+				}
+				w.response <- response{err: err}
+			} else {
+				w.response <- response{result: data["r"]}
+			}
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package gophertest
+
+import (
+	"errors"
+	"fmt"
+	gopher "github.com/hewiefreeman/GopherGameServer"
+	"github.com/hewiefreeman/GopherGameServer/actions"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/database"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testServer *Server
+
+func TestMain(m *testing.M) {
+	folder, err := ioutil.TempDir("", "gophertest")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	core.NewRoomType("e2e", false).EnableBroadcastUserEnter().EnableBroadcastUserLeave()
+	actions.New("e2eEcho", actions.DataTypeString, func(data interface{}, c *actions.Client) {
+		c.Respond(data, actions.NoError())
+	})
+	testServer, err = StartServer(&gopher.ServerSettings{ServerName: "!server!", AllowGuests: true, UserRoomControl: true, GuestRoomControl: true,
+		RoomDeleteOnLeave: true, EnableSqlFeatures: true, SqlDriver: database.DriverSQLite,
+		SqlDatabase: filepath.Join(folder, "gophertest.db"), EncryptionCost: 4})
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	testServer.Close()
+	os.RemoveAll(folder)
+	os.Exit(code)
+}
+
+// testClient connects a Client to the test server, and closes it when the test is done.
+func testClient(t *testing.T) *Client {
+	c, err := testServer.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// nextMessage gets the Client's next Message of the type, skipping the others.
+func nextMessage(t *testing.T, c *Client, messageType string) Message {
+	timeout := time.After(time.Second * 2)
+	for {
+		select {
+		case message := <-c.Messages:
+			if message.Type == messageType {
+				return message
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for a", messageType, "message")
+		}
+	}
+}
+
+func TestLogin(t *testing.T) {
+	c := testClient(t)
+	if err := c.SignUp("e2eAccount", "hunter22", nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.DeleteAccount("e2eAccount", "hunter22", nil) })
+	var gErr *Error
+	if err := c.Login("e2eAccount", "wrong"); !errors.As(err, &gErr) || gErr.Code != helpers.ErrorAuthIncorrectLogin {
+		t.Error("Expected an incorrect login error, got", err)
+	}
+	if err := c.Login("e2eAccount", "hunter22"); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := core.GetUser("e2eAccount"); err != nil || user.IsGuest() {
+		t.Error("Expected the account to be logged in, got", user, err)
+	}
+	if err := c.Logout(); err != nil {
+		t.Error(err)
+	}
+
+	// The same Client can log in again as a guest
+	name, err := c.GuestLogin()
+	if err != nil {
+		t.Fatal(err)
+	} else if user, err := core.GetUser(name); err != nil || !user.IsGuest() {
+		t.Error("Expected the guest", name, "to be logged in, got", err)
+	}
+}
+
+func TestRooms(t *testing.T) {
+	owner, player := testClient(t), testClient(t)
+	ownerName, _ := owner.GuestLogin()
+	playerName, _ := player.GuestLogin()
+	if err := owner.CreateRoom("e2eRoom", "e2e", false, 4); err != nil {
+		t.Fatal(err)
+	}
+	var gErr *Error
+	if err := player.Join("notARoom"); !errors.As(err, &gErr) || gErr.Code != helpers.ErrorRoomNotFound {
+		t.Error("Expected a room not found error, got", err)
+	}
+	if err := player.Join("e2eRoom"); err != nil {
+		t.Fatal(err)
+	}
+	nextMessage(t, owner, helpers.ServerActionUserEnter)
+
+	// Everyone in the Room gets chat messages
+	if err := player.Chat("gg"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []*Client{owner, player} {
+		select {
+		case message := <-c.RoomMessages:
+			if message.Author != playerName || message.Message != "gg" {
+				t.Error("Expected the player's chat message, got", message)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("Timed out waiting for the chat message")
+		}
+	}
+
+	// Private messages say who they are from and to
+	owner.PrivateMessage(playerName, "psst")
+	select {
+	case message := <-player.PrivateMessages:
+		if message.From != ownerName || message.To != playerName || message.Message != "psst" {
+			t.Error("Expected the owner's private message, got", message)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Timed out waiting for the private message")
+	}
+
+	if err := player.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	nextMessage(t, owner, helpers.ServerActionUserLeave)
+	if response, err := player.CustomAction("e2eEcho", "ping"); err != nil || response != "ping" {
+		t.Error("Expected the custom action's response, got", response, err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	clients := []*Client{testClient(t), testClient(t)}
+	clients[0].GuestLogin()
+	if err := gopher.Broadcast("e2eNews", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	// Clients get them whether they're logged in or not
+	for _, c := range clients {
+		select {
+		case announcement := <-c.Announcements:
+			if announcement.Type != "e2eNews" || announcement.Data != "hello" {
+				t.Error("Expected the announcement, got", announcement)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatal("Timed out waiting for the announcement")
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	room, err := core.NewRoom("e2eLoadRoom", "e2e", false, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer room.Delete()
+	const clients = 25
+	report := testServer.Load(clients, func(c *Client, n int) error {
+		if _, err := c.GuestLogin(); err != nil {
+			return err
+		} else if err := c.Join("e2eLoadRoom"); err != nil {
+			return err
+		}
+		for i := 0; i < 5; i++ {
+			if _, err := c.CustomAction("e2eEcho", "move"); err != nil {
+				return err
+			}
+		}
+		return c.Chat(n)
+	})
+	t.Log(report)
+	if report.Failed != 0 {
+		t.Error("Expected every client to finish, got", report.Errors)
+	}
+	if report.Latency[helpers.ClientActionGuestLogin].Count != clients || report.Latency["e2eEcho"].Count != clients*5 {
+		t.Error("Expected the latencies of every response, got", report.Latency)
+	}
+	if latency := report.Latency[helpers.ClientActionJoinRoom]; latency.P50 <= 0 || latency.P50 > latency.P99 || latency.P99 > latency.Max {
+		t.Error("Expected ordered percentiles, got", latency)
+	}
+}
@@ -0,0 +1,120 @@
+package gophertest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	maxLoadErrors = 10 // THE MOST ERRORS A LoadReport KEEPS
+)
+
+// Scenario is what each fake client does in a load test, like logging in, joining a Room and chatting. n is the client's
+// number, from 0, so the clients can tell themselves apart. Returning an error counts the client as failed.
+type Scenario func(c *Client, n int) error
+
+// LoadReport is what happened in a load test.
+type LoadReport struct {
+	Clients  int                // The number of clients that ran the Scenario
+	Failed   int                // How many couldn't connect, or got an error from the Scenario
+	Errors   []error            // The first errors of the clients that failed
+	Duration time.Duration      // How long it took every client to finish
+	Latency  map[string]Latency // The response times of the client actions the clients waited on, by client action, like helpers.ClientActionJoinRoom. Custom client actions are by their own names
+}
+
+// Latency is the response times of a client action in a load test.
+type Latency struct {
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// Load connects the number of clients to the server at the WebSocket URL all at once, and runs the Scenario on each of them
+// at the same time. Each client is closed when its Scenario returns. Returns once they're all done.
+func Load(url string, clients int, opts *Options, scenario Scenario) *LoadReport {
+	report := &LoadReport{Clients: clients, Latency: make(map[string]Latency)}
+	times := make(map[string][]time.Duration)
+	var mux sync.Mutex // LOCKS report AND times
+	fail := func(err error) {
+		mux.Lock()
+		report.Failed++
+		if len(report.Errors) < maxLoadErrors {
+			report.Errors = append(report.Errors, err)
+		}
+		mux.Unlock()
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for n := 0; n < clients; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			c, err := Dial(url, opts)
+			if err != nil {
+				fail(err)
+				return
+			}
+			if err := scenario(c, n); err != nil {
+				fail(err)
+			}
+			c.Close()
+			c.mux.Lock()
+			mux.Lock()
+			for action, latencies := range c.latencies {
+				times[action] = append(times[action], latencies...)
+			}
+			mux.Unlock()
+			c.mux.Unlock()
+		}(n)
+	}
+	wg.Wait()
+	report.Duration = time.Since(start)
+
+	for action, latencies := range times {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		report.Latency[action] = Latency{Count: len(latencies), P50: percentile(latencies, 0.5), P90: percentile(latencies, 0.9),
+			P99: percentile(latencies, 0.99), Max: latencies[len(latencies)-1]}
+	}
+	return report
+}
+
+// Load runs a load test on the server. See Load().
+func (s *Server) Load(clients int, scenario Scenario) *LoadReport {
+	return Load(s.URL, clients, &Options{RememberMe: s.rememberMe}, scenario)
+}
+
+// percentile gets the nearest-rank percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// String makes the LoadReport into lines you can log, like in your CI, with the latencies sorted by client action.
+func (r *LoadReport) String() string {
+	var lines strings.Builder
+	lines.WriteString(strconv.Itoa(r.Clients) + " clients, " + strconv.Itoa(r.Failed) + " failed, in " + r.Duration.String() + "\n")
+	actions := make([]string, 0, len(r.Latency))
+	for action := range r.Latency {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	for _, action := range actions {
+		latency := r.Latency[action]
+		lines.WriteString("  " + action + ": " + strconv.Itoa(latency.Count) + " responses, p50 " + latency.P50.String() + ", p90 " +
+			latency.P90.String() + ", p99 " + latency.P99.String() + ", max " + latency.Max.String() + "\n")
+	}
+	for _, err := range r.Errors {
+		lines.WriteString("  error: " + err.Error() + "\n")
+	}
+	return lines.String()
+}
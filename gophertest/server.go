@@ -0,0 +1,88 @@
+// Package gophertest contains a fake client that speaks the Gopher Game Server protocol, and tools to start a server and put
+// load on it, for testing your server without a real client.
+package gophertest
+
+import (
+	"errors"
+	gopher "github.com/hewiefreeman/GopherGameServer"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+const (
+	// HOW LONG StartServer() WAITS FOR THE SERVER TO START
+	startTimeout = 10 * time.Second
+
+	endpointPath = "/ws"
+)
+
+// Server is a Gopher Game Server started with StartServer(), listening on a random port of the loopback interface.
+type Server struct {
+	URL string // The WebSocket URL clients connect to, like "ws://127.0.0.1:51234/ws"
+
+	rememberMe bool
+	http       *httptest.Server
+}
+
+// StartServer starts the server with the settings in handler mode (see Handler in ServerSettings), on a random port of the
+// loopback interface, and returns once it's running. A nil *ServerSettings starts a server for guests that lets them make
+// Rooms. IP, Port, TLS and the other listener settings aren't used, and HostName defaults to "localhost".
+//
+// Register your RoomTypes, custom client actions and callbacks before starting it, like you would in your own server. The
+// gopher package only runs one server in each process, and can't start it again after it shuts down, so start it once, like
+// in your tests' TestMain(), and share it between your tests.
+func StartServer(s *gopher.ServerSettings) (*Server, error) {
+	var settings gopher.ServerSettings
+	if s != nil {
+		settings = *s
+	} else {
+		settings = gopher.ServerSettings{ServerName: "!server!", AllowGuests: true, UserRoomControl: true, RoomDeleteOnLeave: true}
+	}
+	settings.Handler = true
+	settings.QuietStartup = true
+	if settings.HostName == "" {
+		settings.HostName = "localhost"
+	}
+	if err := settings.Validate(); err != nil {
+		return nil, err
+	} else if gopher.IsRunning() {
+		return nil, errors.New("The server is already running")
+	}
+
+	// Start() BLOCKS UNTIL THE SERVER SHUTS DOWN, OR RETURNS RIGHT AWAY WHEN IT CAN'T START
+	stopped := make(chan bool)
+	go func() {
+		gopher.Start(&settings)
+		close(stopped)
+	}()
+	timeout := time.After(startTimeout)
+	for !gopher.IsRunning() {
+		select {
+		case <-stopped:
+			return nil, errors.New("The server didn't start. See its logs for why")
+		case <-timeout:
+			return nil, errors.New("Timed out waiting for the server to start")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(endpointPath, gopher.SocketHandler())
+	server := &Server{rememberMe: settings.RememberMe, http: httptest.NewServer(mux)}
+	server.URL = "ws" + strings.TrimPrefix(server.http.URL, "http") + endpointPath
+	return server, nil
+}
+
+// Connect connects a new Client to the server.
+func (s *Server) Connect() (*Client, error) {
+	return Dial(s.URL, &Options{RememberMe: s.rememberMe})
+}
+
+// Close shuts the server down, like gopher.ShutDown(), and stops listening.
+func (s *Server) Close() error {
+	err := gopher.ShutDown()
+	s.http.Close()
+	return err
+}
@@ -1,6 +1,10 @@
 package helpers
 
-//BUILT-IN CLIENT ACTION/RESPONSE MESSAGE TYPES
+import (
+	"time"
+)
+
+// BUILT-IN CLIENT ACTION/RESPONSE MESSAGE TYPES
 const (
 	ClientActionSignup            = "s"
 	ClientActionDeleteAccount     = "d"
@@ -25,9 +29,52 @@ const (
 	ClientActionRemoveFriend      = "fr"
 	ClientActionSetVariable       = "vs"
 	ClientActionSetVariables      = "vx"
+	ClientActionGetVariables      = "vg"
+	ClientActionChatHistory       = "ch"
+	ClientActionTransferOwner     = "ot"
+	ClientActionGetDevices        = "dg"
+	ClientActionRevokeDevice      = "dr"
+	ClientActionAdminLogin        = "al"
+	ClientActionAdminAction       = "ad"
+	ClientActionMuteUser          = "mu"
+	ClientActionUnmuteUser        = "um"
+	ClientActionJoinQueue         = "qj"
+	ClientActionLeaveQueue        = "ql"
+	ClientActionGuestLogin        = "lg"
+	ClientActionRoomUsers         = "ru"
+	ClientActionListRooms         = "rl"
+	ClientActionChangeName        = "nc"
+	ClientActionSpectateRoom      = "js"
+	ClientActionPromoteSpectator  = "sp"
+	ClientActionStateResync       = "sy"
+	ClientActionServerInfo        = "si"
+	ClientActionRoomUpdate        = "ub"
+	ClientActionWatchUser         = "wu"
+	ClientActionUnwatchUser       = "uw"
 )
 
-//BUILT-IN SERVER ACTION RESPONSES
+// KEEP IN SYNC WITH THE BUILT-IN CLIENT ACTIONS ABOVE
+var clientActions = map[string]bool{
+	ClientActionSignup: true, ClientActionDeleteAccount: true, ClientActionChangePassword: true, ClientActionChangeAccountInfo: true,
+	ClientActionLogin: true, ClientActionLogout: true, ClientActionJoinRoom: true, ClientActionLeaveRoom: true,
+	ClientActionCreateRoom: true, ClientActionDeleteRoom: true, ClientActionRoomInvite: true, ClientActionRevokeInvite: true,
+	ClientActionChatMessage: true, ClientActionPrivateMessage: true, ClientActionVoiceStream: true, ClientActionChangeStatus: true,
+	ClientActionCustomAction: true, ClientActionFriendRequest: true, ClientActionAcceptFriend: true, ClientActionDeclineFriend: true,
+	ClientActionRemoveFriend: true, ClientActionSetVariable: true, ClientActionSetVariables: true, ClientActionGetVariables: true,
+	ClientActionChatHistory: true, ClientActionTransferOwner: true, ClientActionGetDevices: true, ClientActionRevokeDevice: true,
+	ClientActionAdminLogin: true, ClientActionAdminAction: true, ClientActionMuteUser: true, ClientActionUnmuteUser: true,
+	ClientActionJoinQueue: true, ClientActionLeaveQueue: true, ClientActionGuestLogin: true, ClientActionRoomUsers: true,
+	ClientActionListRooms: true, ClientActionChangeName: true, ClientActionSpectateRoom: true, ClientActionPromoteSpectator: true,
+	ClientActionStateResync: true, ClientActionServerInfo: true, ClientActionRoomUpdate: true, ClientActionWatchUser: true,
+	ClientActionUnwatchUser: true,
+}
+
+// IsClientAction is only for internal Gopher Game Server mechanics.
+func IsClientAction(action string) bool {
+	return clientActions[action]
+}
+
+// BUILT-IN SERVER ACTION RESPONSES
 const (
 	ServerActionClientActionResponse       = "c"
 	ServerActionCustomClientActionResponse = "a"
@@ -43,25 +90,53 @@ const (
 	ServerActionFriendAccept               = "fa"
 	ServerActionFriendRemove               = "fr"
 	ServerActionFriendStatusChange         = "fs"
+	ServerActionUserStatusChange           = "us"
 	ServerActionRequestDeviceTag           = "t"
 	ServerActionSetDeviceTag               = "ts"
 	ServerActionSetAutoLoginPass           = "ap"
 	ServerActionAutoLoginFailed            = "af"
 	ServerActionAutoLoginNotFiled          = "ai"
+	ServerActionShutDown                   = "sd"
+	ServerActionLoggedInElsewhere          = "le" // No longer sent. Kicked duplicate logins get ServerActionKicked.
+	ServerActionChatHistory                = "ch"
+	ServerActionRoomVariable               = "rv"
+	ServerActionRoomVariables              = "rx"
 	ServerActionWebRTCOffer                = "wo"
+	ServerActionOwnerChange                = "oc"
+	ServerActionAnnouncement               = "an"
+	ServerActionSessionRestored            = "sr"
+	ServerActionReconnected                = "rc"
+	ServerActionUserReconnected            = "ur"
+	ServerActionVoiceMute                  = "vm"
+	ServerActionMatchFound                 = "mf"
+	ServerActionTimerTick                  = "tt"
+	ServerActionTimerDone                  = "td"
+	ServerActionKicked                     = "k"
+	ServerActionUserRenamed                = "un"
+	ServerActionSpectatorPromoted          = "pr"
+	ServerActionTurnChange                 = "tc"
+	ServerActionTurnTimeout                = "to"
+	ServerActionStateDelta                 = "dl"
+	ServerActionStateSnapshot              = "sn"
+	ServerActionRoomUpdates                = "up"
+	ServerActionRoomRedirect               = "rr"
+	ServerActionPresence                   = "ps"
+	ServerActionClientOutdated             = "co"
 )
 
 // MakeClientResponse is used for Gopher Game Server inner mechanics only.
 func MakeClientResponse(action string, responseVal interface{}, err GopherError) map[string]map[string]interface{} {
+	return LocalizedClientResponse(action, responseVal, err, "")
+}
+
+// LocalizedClientResponse is used for Gopher Game Server inner mechanics only.
+func LocalizedClientResponse(action string, responseVal interface{}, err GopherError, locale string) map[string]map[string]interface{} {
 	var response map[string]map[string]interface{}
 	if err.ID != 0 {
 		response = map[string]map[string]interface{}{
 			ServerActionClientActionResponse: {
 				"a": action,
-				"e": map[string]interface{}{
-					"m":  err.Message,
-					"id": err.ID,
-				},
+				"e": LocalizedErrorObject(err, locale),
 			},
 		}
 	} else {
@@ -76,3 +151,23 @@ func MakeClientResponse(action string, responseVal interface{}, err GopherError)
 	//
 	return response
 }
+
+// MakeRateLimitResponse is used for Gopher Game Server inner mechanics only.
+func MakeRateLimitResponse(action string, message string, retryAfter time.Duration, locale string) map[string]map[string]interface{} {
+	response := LocalizedClientResponse(action, nil, NewError(message, ErrorRateLimited), locale)
+	// HOW MANY MILLISECONDS TO WAIT BEFORE TRYING AGAIN, ROUNDED UP
+	response[ServerActionClientActionResponse]["e"].(map[string]interface{})["r"] = int64((retryAfter + time.Millisecond - 1) / time.Millisecond)
+
+	//
+	return response
+}
+
+// MakeMalformedResponse is used for Gopher Game Server inner mechanics only.
+func MakeMalformedResponse(action string, message string, field string, locale string) map[string]map[string]interface{} {
+	response := LocalizedClientResponse(action, nil, NewError(message, ErrorMalformedRequest), locale)
+	// THE FIELD OF THE CLIENT ACTION THAT FAILED, OR "" WHEN IT WASN'T JSON
+	response[ServerActionClientActionResponse]["e"].(map[string]interface{})["f"] = field
+
+	//
+	return response
+}
@@ -1,11 +1,24 @@
 package helpers
 
-// GopherError is used when sending an error message to the client API.
+import (
+	"errors"
+	"sync"
+)
+
+// GopherError is used when sending an error message to the client API. The ID is one of the error codes below, which
+// clients can branch on, and the Message is for people to read. A GopherError is also an error, so the errors returned by
+// the core package can carry their code to the client APIs.
 type GopherError struct {
 	Message string
 	ID      int
 }
 
+var (
+	//errorMessagesMux LOCKS errorMessages
+	errorMessages    = make(map[int]string)
+	errorMessagesMux sync.RWMutex
+)
+
 // Client response message error IDs
 const (
 	ErrorGopherInvalidAction         = iota + 1001 // 1001. Invalid client action
@@ -60,8 +73,79 @@ const (
 	ErrorAuthConversion         // 1048. There was an error while converting data to be stored on the database
 
 	// Misc errors
-	ErrorActionDenied // 1049. A callback has denied the server action
-	ErrorServerPaused // 1050. The server is paused
+	ErrorActionDenied  // 1049. A callback has denied the server action
+	ErrorServerPaused  // 1050. The server is paused
+	ErrorNotInRoom     // 1051. The client must be in a room to take action
+	ErrorServerFull    // 1052. The server has reached MaxConnections
+	ErrorRoomFull      // 1053. The room has reached its maximum User capacity
+	ErrorNotInvited    // 1054. The client must be on a private room's invite list to join it
+	ErrorTransferOwner // 1055. There was an error transferring ownership of a room
+
+	// Authentication errors (continued)
+	ErrorAuthColumnTaken // 1056. Another account already has the value for a unique custom account info column
+
+	// Database errors (continued)
+	ErrorDatabaseUnavailable // 1057. The database can't be reached, and the server is trying to reconnect
+
+	// Authentication errors (continued)
+	ErrorAuthUnverified // 1058. The account's email has not been verified yet
+
+	// Gopher errors (continued)
+	ErrorGopherRevokeDevice // 1059. There was an error revoking a device's auto-login
+
+	// Misc errors (continued)
+	ErrorRateLimited      // 1060. The client sent too many actions, and must wait before sending more
+	ErrorMalformedRequest // 1061. The client sent a message that isn't a valid client action
+
+	// Authentication errors (continued)
+	ErrorAuthBanned // 1062. The account is banned
+
+	// Admin errors
+	ErrorAdminLogin  // 1063. The admin login failed, or admin logins aren't allowed from where the client connected
+	ErrorNotAdmin    // 1064. The client must be logged in as an admin
+	ErrorAdminAction // 1065. There was an error with an admin action
+
+	// Voice chat errors
+	ErrorMuteUser // 1066. There was an error muting or unmuting a User
+
+	// Matchmaking errors
+	ErrorQueue // 1067. There was an error joining or leaving a matchmaking queue
+
+	// Room errors
+	ErrorRoomNotFound    // 1068. The room does not exist
+	ErrorRoomExists      // 1069. A room with the name already exists
+	ErrorRoomTypeInvalid // 1070. The room type does not exist
+	ErrorAlreadyInRoom   // 1071. The User is already in the room
+
+	// User errors
+	ErrorUserNotFound // 1072. The User is not logged in
+
+	// Misc errors (continued)
+	ErrorTimeout      // 1073. The action took longer than the server's ActionTimeout
+	ErrorActionFailed // 1074. The server failed unexpectedly while handling the action, like from a panic in a callback
+
+	// Authentication errors (continued)
+	ErrorGuestRestricted // 1075. Guests can't use the action with the server's settings
+
+	// Room errors (continued)
+	ErrorNoSpectators // 1076. The room doesn't allow spectators
+	ErrorSpectating   // 1077. Spectators can't take the action
+	ErrorPromote      // 1078. There was an error promoting a spectator to a player
+
+	// Misc errors (continued)
+	ErrorActionRejected   // 1079. Action middleware rejected the client action
+	ErrorBroadcastLimited // 1080. The user is sending messages to the room too fast
+	ErrorRoomRedirect     // 1081. The room is on another node of the cluster. The client is sent a ServerActionRoomRedirect
+
+	// User errors (continued)
+	ErrorWatchLimit  // 1082. The connection is already watching as many users as it can
+	ErrorUserPrivate // 1083. The user has set themselves private, and can't be watched
+
+	// Name policy errors
+	ErrorNameLength     // 1084. The user name is too short or too long for the server's NamePolicy
+	ErrorNameChars      // 1085. The user name has characters the server's NamePolicy doesn't allow
+	ErrorNameBanned     // 1086. The user name has a word in it the server's NamePolicy bans
+	ErrorNameConfusable // 1087. The user name looks like the name of another user
 )
 
 // NewError creates a new GopherError.
@@ -73,3 +157,44 @@ func NewError(message string, id int) GopherError {
 func NoError() GopherError {
 	return GopherError{}
 }
+
+// Error gets the GopherError's message, so it can be used as an error.
+func (e GopherError) Error() string {
+	return e.Message
+}
+
+// Code gets the GopherError's error code, like ErrorRoomFull.
+func (e GopherError) Code() int {
+	return e.ID
+}
+
+// ErrorFrom gets err as a GopherError, keeping its code if it has one. Otherwise, the GopherError has err's message and the id.
+func ErrorFrom(err error, id int) GopherError {
+	var gErr GopherError
+	if errors.As(err, &gErr) && gErr.ID != 0 {
+		return gErr
+	}
+	return NewError(err.Error(), id)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   LOCALIZED ERROR MESSAGES   //////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SetErrorMessage replaces the message clients get with every error that has the error code id, for instance to translate
+// it. Use an empty message to send the server's own message again. The error code is sent either way, and the server's own
+// message is still what gets logged. To translate it for each client's own locale, use SetTranslations() instead.
+func SetErrorMessage(id int, message string) {
+	errorMessagesMux.Lock()
+	if message == "" {
+		delete(errorMessages, id)
+	} else {
+		errorMessages[id] = message
+	}
+	errorMessagesMux.Unlock()
+}
+
+// ErrorObject is used for Gopher Game Server inner mechanics only.
+func ErrorObject(err GopherError) map[string]interface{} {
+	return LocalizedErrorObject(err, "")
+}
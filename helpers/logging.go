@@ -0,0 +1,82 @@
+package helpers
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// Logger is what the server logs with. Each message can come with fields as key-value pairs, like
+//
+//	Info("User logged in", "user", "gopher", "id", 12)
+//
+// Use gopher.SetLogger() to change the server's Logger.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// loggerBox keeps every Logger stored in logger the same concrete type, like atomic.Value requires
+type loggerBox struct {
+	Logger
+}
+
+var logger atomic.Value
+
+// SetLogger is only for internal Gopher Game Server mechanics. Use gopher.SetLogger() to change the server's Logger.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = defaultLogger{}
+	}
+	logger.Store(loggerBox{l})
+}
+
+// Log is only for internal Gopher Game Server mechanics.
+func Log() Logger {
+	if l, ok := logger.Load().(loggerBox); ok {
+		return l.Logger
+	}
+	return defaultLogger{}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   DEFAULT LOGGER   ////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// defaultLogger prints Info, Warn and Error messages to the console like the server always has, and drops Debug messages.
+type defaultLogger struct{}
+
+func (defaultLogger) Debug(msg string, keyvals ...interface{}) {}
+
+func (defaultLogger) Info(msg string, keyvals ...interface{}) {
+	fmt.Println(FormatLogLine(msg, keyvals))
+}
+
+func (defaultLogger) Warn(msg string, keyvals ...interface{}) {
+	fmt.Println(FormatLogLine(msg, keyvals))
+}
+
+func (defaultLogger) Error(msg string, keyvals ...interface{}) {
+	fmt.Println(FormatLogLine(msg, keyvals))
+}
+
+// FormatLogLine formats a message and its fields like "Room created room=lobby owner=gopher". Values with spaces are quoted,
+// and a key without a value gets "(MISSING)".
+func FormatLogLine(msg string, keyvals []interface{}) string {
+	var line strings.Builder
+	line.WriteString(msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+		valStr := fmt.Sprint(val)
+		if strings.ContainsAny(valStr, " \t\n\"") {
+			valStr = fmt.Sprintf("%q", valStr)
+		}
+		line.WriteString(" " + fmt.Sprint(keyvals[i]) + "=" + valStr)
+	}
+	return line.String()
+}
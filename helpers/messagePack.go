@@ -0,0 +1,408 @@
+package helpers
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// The deepest MessagePack arrays and maps can be nested in a message from a client.
+const maxMessagePackDepth = 100
+
+var errMessagePackShort = errors.New("MessagePack data ended too soon")
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   ENCODING   //////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// MarshalMessagePack encodes v as MessagePack. The result decodes to the same thing v does as JSON: maps, slices, strings,
+// numbers, booleans and nil are encoded as they are, and every other value the way encoding/json would encode it. Only
+// []byte is different, which is a MessagePack bin instead of a base64 string.
+func MarshalMessagePack(v interface{}) ([]byte, error) {
+	return appendMessagePack(make([]byte, 0, 64), v)
+}
+
+func appendMessagePack(b []byte, v interface{}) ([]byte, error) {
+	var err error
+	switch val := v.(type) {
+	case nil:
+		return append(b, 0xc0), nil
+	case bool:
+		if val {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case string:
+		return appendMessagePackString(b, val), nil
+	case int:
+		return appendMessagePackInt(b, int64(val)), nil
+	case int8:
+		return appendMessagePackInt(b, int64(val)), nil
+	case int16:
+		return appendMessagePackInt(b, int64(val)), nil
+	case int32:
+		return appendMessagePackInt(b, int64(val)), nil
+	case int64:
+		return appendMessagePackInt(b, val), nil
+	case uint:
+		return appendMessagePackUint(b, uint64(val)), nil
+	case uint8:
+		return appendMessagePackUint(b, uint64(val)), nil
+	case uint16:
+		return appendMessagePackUint(b, uint64(val)), nil
+	case uint32:
+		return appendMessagePackUint(b, uint64(val)), nil
+	case uint64:
+		return appendMessagePackUint(b, val), nil
+	case float32:
+		if math.IsNaN(float64(val)) || math.IsInf(float64(val), 0) {
+			return b, errors.New("MessagePack messages can't have NaN or infinite numbers, like JSON")
+		}
+		return binary.BigEndian.AppendUint32(append(b, 0xca), math.Float32bits(val)), nil
+	case float64:
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return b, errors.New("MessagePack messages can't have NaN or infinite numbers, like JSON")
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(val)), nil
+	case json.Number:
+		if i, intErr := val.Int64(); intErr == nil {
+			return appendMessagePackInt(b, i), nil
+		}
+		f, floatErr := val.Float64()
+		if floatErr != nil {
+			return b, floatErr
+		}
+		return appendMessagePack(b, f)
+	case []byte:
+		if val == nil {
+			return append(b, 0xc0), nil
+		}
+		b = appendMessagePackLength(b, len(val), 0, 0xc4, 0xc5, 0xc6)
+		return append(b, val...), nil
+	case []interface{}:
+		if val == nil {
+			return append(b, 0xc0), nil
+		}
+		b = appendMessagePackLength(b, len(val), 0x90, 0, 0xdc, 0xdd)
+		for _, item := range val {
+			if b, err = appendMessagePack(b, item); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case []string:
+		if val == nil {
+			return append(b, 0xc0), nil
+		}
+		b = appendMessagePackLength(b, len(val), 0x90, 0, 0xdc, 0xdd)
+		for _, item := range val {
+			b = appendMessagePackString(b, item)
+		}
+		return b, nil
+	case map[string]interface{}:
+		if val == nil {
+			return append(b, 0xc0), nil
+		}
+		b = appendMessagePackLength(b, len(val), 0x80, 0, 0xde, 0xdf)
+		for key, item := range val {
+			b = appendMessagePackString(b, key)
+			if b, err = appendMessagePack(b, item); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case map[string]map[string]interface{}:
+		if val == nil {
+			return append(b, 0xc0), nil
+		}
+		b = appendMessagePackLength(b, len(val), 0x80, 0, 0xde, 0xdf)
+		for key, item := range val {
+			b = appendMessagePackString(b, key)
+			if b, err = appendMessagePack(b, item); err != nil {
+				return b, err
+			}
+		}
+		return b, nil
+	case map[string]string:
+		if val == nil {
+			return append(b, 0xc0), nil
+		}
+		b = appendMessagePackLength(b, len(val), 0x80, 0, 0xde, 0xdf)
+		for key, item := range val {
+			b = appendMessagePackString(b, key)
+			b = appendMessagePackString(b, item)
+		}
+		return b, nil
+	}
+
+	// EVERYTHING ELSE GOES THROUGH encoding/json, SO STRUCT TAGS AND MARSHALERS WORK THE SAME AS THEY DO FOR JSON CLIENTS
+	data, err := json.Marshal(v)
+	if err != nil {
+		return b, err
+	}
+	var generic interface{}
+	if err = decodeJSONNumbers(data, &generic); err != nil {
+		return b, err
+	}
+	return appendMessagePack(b, generic)
+}
+
+func appendMessagePackInt(b []byte, i int64) []byte {
+	if i >= 0 {
+		return appendMessagePackUint(b, uint64(i))
+	} else if i >= -32 {
+		return append(b, byte(i))
+	} else if i >= math.MinInt8 {
+		return append(b, 0xd0, byte(i))
+	} else if i >= math.MinInt16 {
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	} else if i >= math.MinInt32 {
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+func appendMessagePackUint(b []byte, u uint64) []byte {
+	if u <= 0x7f {
+		return append(b, byte(u))
+	} else if u <= math.MaxUint8 {
+		return append(b, 0xcc, byte(u))
+	} else if u <= math.MaxUint16 {
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(u))
+	} else if u <= math.MaxUint32 {
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(u))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), u)
+}
+
+func appendMessagePackString(b []byte, s string) []byte {
+	if len(s) < 32 {
+		b = append(b, 0xa0|byte(len(s)))
+	} else {
+		b = appendMessagePackLength(b, len(s), 0, 0xd9, 0xda, 0xdb)
+	}
+	return append(b, s...)
+}
+
+// appendMessagePackLength appends the header of a string, bin, array or map of length n. fixed is the type for lengths that
+// fit in the header itself (0 if there isn't one), and the others are the types with an 8, 16 and 32 bit length after them.
+func appendMessagePackLength(b []byte, n int, fixed byte, t8 byte, t16 byte, t32 byte) []byte {
+	if fixed != 0 && n < 16 {
+		return append(b, fixed|byte(n))
+	} else if t8 != 0 && n <= math.MaxUint8 {
+		return append(b, t8, byte(n))
+	} else if n <= math.MaxUint16 {
+		return binary.BigEndian.AppendUint16(append(b, t16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, t32), uint32(n))
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   DECODING   //////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// UnmarshalMessagePack decodes a MessagePack value. Maps decode to map[string]interface{}, arrays to []interface{},
+// integers to int64 (or uint64 when they're too big for one), floats to float64, strings to string, and bins to []byte.
+// Map keys must be strings, and extension types aren't supported.
+func UnmarshalMessagePack(data []byte) (interface{}, error) {
+	d := messagePackDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	} else if d.pos != len(d.data) {
+		return nil, errors.New("There is data after the end of the MessagePack value")
+	}
+	return v, nil
+}
+
+type messagePackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// finiteFloat makes sure a decoded float can be used like a JSON number.
+func finiteFloat(f float64) (interface{}, error) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, errors.New("MessagePack messages can't have NaN or infinite numbers, like JSON")
+	}
+	return f, nil
+}
+
+func (d *messagePackDecoder) value(depth int) (interface{}, error) {
+	if depth > maxMessagePackDepth {
+		return nil, errors.New("MessagePack data is nested too deep")
+	}
+	t, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch c := t[0]; {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	}
+	switch t[0] {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, lenErr := d.length(t[0] - 0xc4)
+		if lenErr != nil {
+			return nil, lenErr
+		}
+		bin, binErr := d.next(n)
+		if binErr != nil {
+			return nil, binErr
+		}
+		return append([]byte{}, bin...), nil
+	case 0xca:
+		b, numErr := d.next(4)
+		if numErr != nil {
+			return nil, numErr
+		}
+		return finiteFloat(float64(math.Float32frombits(binary.BigEndian.Uint32(b))))
+	case 0xcb:
+		b, numErr := d.next(8)
+		if numErr != nil {
+			return nil, numErr
+		}
+		return finiteFloat(math.Float64frombits(binary.BigEndian.Uint64(b)))
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, numErr := d.next(1 << (t[0] - 0xcc))
+		if numErr != nil {
+			return nil, numErr
+		}
+		u := bigEndian(b)
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return int64(u), nil
+	case 0xd0:
+		b, numErr := d.next(1)
+		if numErr != nil {
+			return nil, numErr
+		}
+		return int64(int8(b[0])), nil
+	case 0xd1:
+		b, numErr := d.next(2)
+		if numErr != nil {
+			return nil, numErr
+		}
+		return int64(int16(binary.BigEndian.Uint16(b))), nil
+	case 0xd2:
+		b, numErr := d.next(4)
+		if numErr != nil {
+			return nil, numErr
+		}
+		return int64(int32(binary.BigEndian.Uint32(b))), nil
+	case 0xd3:
+		b, numErr := d.next(8)
+		if numErr != nil {
+			return nil, numErr
+		}
+		return int64(binary.BigEndian.Uint64(b)), nil
+	case 0xd9, 0xda, 0xdb:
+		n, lenErr := d.length(t[0] - 0xd9)
+		if lenErr != nil {
+			return nil, lenErr
+		}
+		return d.str(n)
+	case 0xdc, 0xdd:
+		n, lenErr := d.length(t[0] - 0xdc + 1)
+		if lenErr != nil {
+			return nil, lenErr
+		}
+		return d.arrayOf(n, depth)
+	case 0xde, 0xdf:
+		n, lenErr := d.length(t[0] - 0xde + 1)
+		if lenErr != nil {
+			return nil, lenErr
+		}
+		return d.mapOf(n, depth)
+	}
+	return nil, errors.New("Unsupported MessagePack type 0x" + strconv.FormatInt(int64(t[0]), 16))
+}
+
+// next gets the next n bytes of the data.
+func (d *messagePackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errMessagePackShort
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// length reads an 8, 16 or 32 bit length, for a size of 0, 1 or 2.
+func (d *messagePackDecoder) length(size byte) (int, error) {
+	b, err := d.next(1 << size)
+	if err != nil {
+		return 0, err
+	}
+	return int(bigEndian(b)), nil
+}
+
+func (d *messagePackDecoder) str(n int) (interface{}, error) {
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+func (d *messagePackDecoder) arrayOf(n int, depth int) (interface{}, error) {
+	// EVERY ITEM TAKES AT LEAST A BYTE, SO A BAD LENGTH CAN'T MAKE A HUGE SLICE
+	if n > len(d.data)-d.pos {
+		return nil, errMessagePackShort
+	}
+	array := make([]interface{}, n)
+	for i := range array {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		array[i] = item
+	}
+	return array, nil
+}
+
+func (d *messagePackDecoder) mapOf(n int, depth int) (interface{}, error) {
+	if n > (len(d.data)-d.pos)/2 {
+		return nil, errMessagePackShort
+	}
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		keyString, ok := key.(string)
+		if !ok {
+			return nil, errors.New("MessagePack map keys must be strings")
+		}
+		if m[keyString], err = d.value(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func bigEndian(b []byte) uint64 {
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u
+}
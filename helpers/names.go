@@ -0,0 +1,134 @@
+package helpers
+
+import (
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NamePolicy is the rules for the names Users can take, when signing up or renaming an account with the SQL features, or when
+// logging in as a guest or without them. The zero NamePolicy allows any name that isn't empty.
+type NamePolicy struct {
+	MinLength int            // The fewest characters a name can have, or 0 for no minimum
+	MaxLength int            // The most characters a name can have, or 0 for no maximum
+	Chars     int            // The characters a name can have: NameCharsAny, NameCharsAlphanumeric or NameCharsPattern
+	Pattern   *regexp.Regexp // The pattern a whole name must match with NameCharsPattern
+	Normalize bool           // Names are put in Unicode NFKC form before they are checked and used, so "ｐａｙｐａｌ" is "paypal"
+	Unique    bool           // Names can't look like another User's name, so "раypal" in Cyrillic can't be taken when "paypal" is
+	Banned    []string       // Words names can't have in them, in any case or lookalike characters
+}
+
+// These represent the characters a NamePolicy allows in names.
+const (
+	NameCharsAny          = iota // Any characters
+	NameCharsAlphanumeric        // Only the ASCII letters and digits
+	NameCharsPattern             // Names must match the NamePolicy's Pattern
+)
+
+const (
+	errorNameShort      = "The user name must have at least "
+	errorNameLong       = "The user name can have at most "
+	errorNameChars      = "The user name has characters that aren't allowed"
+	errorNameBanned     = "The user name has a word that isn't allowed"
+	errorNameConfusable = "The user name looks like the name of another user"
+)
+
+var (
+	namePolicy NamePolicy
+
+	// LOOKALIKE CHARACTERS, AND THE LATIN LETTER THEY BECOME IN A NAME'S SKELETON
+	confusables = map[rune]rune{
+		// CYRILLIC
+		'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'һ': 'h', 'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k', 'м': 'm', 'н': 'h',
+		'о': 'o', 'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'ѕ': 's', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y',
+		// GREEK
+		'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u',
+		'χ': 'x', 'ω': 'w',
+		// DIGITS AND SYMBOLS
+		'0': 'o', '1': 'l', '|': 'l',
+		// CAPITAL I LOOKS LIKE A LOWERCASE L
+		'I': 'l',
+	}
+	// LETTER PAIRS THAT LOOK LIKE ONE LETTER
+	confusablePairs = strings.NewReplacer("rn", "m", "vv", "w")
+)
+
+// SetNamePolicy is only for internal Gopher Game Server mechanics. Use core.SetNamePolicy().
+func SetNamePolicy(p NamePolicy) {
+	namePolicy = p
+}
+
+// UniqueNames returns true if the NamePolicy doesn't let names look like other Users' names.
+func UniqueNames() bool {
+	return namePolicy.Unique
+}
+
+// NormalizeName puts a name in Unicode NFKC form when the NamePolicy normalizes names, so it can be looked up the way it
+// was saved.
+func NormalizeName(name string) string {
+	if namePolicy.Normalize {
+		return norm.NFKC.String(name)
+	}
+	return name
+}
+
+// CheckName checks a name against the NamePolicy, and returns it in the form it should be used in. The error's ID says which
+// rule the name broke: ErrorNameLength, ErrorNameChars or ErrorNameBanned. Names that look like other Users' names are
+// checked by whoever knows the other names, with NameSkeleton().
+func CheckName(name string) (string, GopherError) {
+	name = NormalizeName(name)
+	length := utf8.RuneCountInString(name)
+	if namePolicy.MinLength > 0 && length < namePolicy.MinLength {
+		return name, NewError(errorNameShort+strconv.Itoa(namePolicy.MinLength)+" characters", ErrorNameLength)
+	} else if namePolicy.MaxLength > 0 && length > namePolicy.MaxLength {
+		return name, NewError(errorNameLong+strconv.Itoa(namePolicy.MaxLength)+" characters", ErrorNameLength)
+	}
+	switch namePolicy.Chars {
+	case NameCharsAlphanumeric:
+		for i := 0; i < len(name); i++ {
+			if c := name[i]; (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+				return name, NewError(errorNameChars, ErrorNameChars)
+			}
+		}
+	case NameCharsPattern:
+		if namePolicy.Pattern == nil || !namePolicy.Pattern.MatchString(name) {
+			return name, NewError(errorNameChars, ErrorNameChars)
+		}
+	}
+	if len(namePolicy.Banned) > 0 {
+		skeleton := NameSkeleton(name)
+		for _, word := range namePolicy.Banned {
+			if word != "" && strings.Contains(skeleton, NameSkeleton(word)) {
+				return name, NewError(errorNameBanned, ErrorNameBanned)
+			}
+		}
+	}
+	return name, NoError()
+}
+
+// NameConfusableError is the error for a name that looks like another User's name.
+func NameConfusableError() GopherError {
+	return NewError(errorNameConfusable, ErrorNameConfusable)
+}
+
+// NameSkeleton gets what a name looks like, so names that look the same have the same skeleton. It's the name in Unicode NFKC
+// form, without accents or invisible characters like zero-width spaces, with lookalike characters from other alphabets
+// swapped for Latin ones, in lowercase.
+func NameSkeleton(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(norm.NFKC.String(name)) {
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) || unicode.IsSpace(r) {
+			continue
+		}
+		if latin, ok := confusables[r]; ok {
+			r = latin
+		} else if latin, ok := confusables[unicode.ToLower(r)]; ok {
+			r = latin
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return confusablePairs.Replace(b.String())
+}
@@ -0,0 +1,234 @@
+package helpers
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// outbound is a socket's queue of messages waiting to be written, and the goroutine that writes them. Sending to a socket
+// only queues the message, so a slow client never holds up a broadcast to everyone else.
+type outbound struct {
+	socket       *websocket.Conn
+	queue        chan *websocket.PreparedMessage
+	writeTimeout time.Duration
+
+	//mux LOCKS stopped, SO NOTHING IS QUEUED AFTER THE WRITER STOPS
+	mux     sync.Mutex
+	stopped bool
+	err     error         // WHY THE WRITER DROPPED THE CLIENT, IF IT DID
+	flush   bool          // WRITE WHAT'S LEFT IN THE QUEUE BEFORE STOPPING
+	done    chan struct{} // CLOSED TO STOP THE WRITER
+	ended   chan struct{} // CLOSED WHEN THE WRITER RETURNS
+}
+
+const (
+	// HOW LONG A STOPPING WRITER HAS TO WRITE WHAT'S LEFT IN ITS QUEUE
+	flushTimeout = time.Second * 1
+
+	// HOW LONG A PING CAN TAKE TO WRITE ON A SOCKET WITHOUT A WRITER
+	pingTimeout = time.Second * 1
+)
+
+var (
+	// THE OUTBOUND QUEUES OF THE SOCKETS WITH A WRITER, BY *websocket.Conn
+	outbounds sync.Map
+
+	outboundQueued  int64  // ATOMIC - THE MESSAGES WAITING IN ALL THE QUEUES
+	slowClientDrops uint64 // ATOMIC
+	writeTimeouts   uint64 // ATOMIC
+
+	// ErrSlowClient is returned when sending to a client whose outbound queue is full. The client is disconnected.
+	ErrSlowClient = errors.New("Client is too slow to receive messages")
+
+	// ErrWriteTimeout is the error a client is disconnected with when writing a message or ping to them takes longer than
+	// the WriteTimeout in ServerSettings.
+	ErrWriteTimeout = errors.New("write timeout")
+
+	errWriterStopped = errors.New("The socket's writer has stopped")
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SOCKET WRITERS   ////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// StartWriter is used for Gopher Game Server inner mechanics only.
+func StartWriter(socket *websocket.Conn, queueSize int, writeTimeout time.Duration) {
+	o := &outbound{
+		socket:       socket,
+		queue:        make(chan *websocket.PreparedMessage, queueSize),
+		writeTimeout: writeTimeout,
+		done:         make(chan struct{}),
+		ended:        make(chan struct{}),
+	}
+	outbounds.Store(socket, o)
+	go o.run()
+}
+
+// StopWriter is used for Gopher Game Server inner mechanics only. The messages already queued get written first, unless it
+// takes longer than a second.
+func StopWriter(socket *websocket.Conn) {
+	if o, ok := outbounds.Load(socket); ok {
+		o.(*outbound).stop(true)
+		<-o.(*outbound).ended
+	}
+}
+
+// OutboundStats gets the number of messages waiting in the outbound queues of all the sockets, and the number of clients
+// that were disconnected for being too slow to receive their messages.
+func OutboundStats() (int, uint64) {
+	return int(atomic.LoadInt64(&outboundQueued)), atomic.LoadUint64(&slowClientDrops)
+}
+
+// WriteTimeouts gets the number of clients that were disconnected because writing to them took longer than their write timeout.
+func WriteTimeouts() uint64 {
+	return atomic.LoadUint64(&writeTimeouts)
+}
+
+// WriterError is used for Gopher Game Server inner mechanics only. Gets the reason a socket's writer dropped the client
+// (ErrSlowClient or ErrWriteTimeout), or nil if it didn't.
+func WriterError(socket *websocket.Conn) error {
+	if o, ok := outbounds.Load(socket); ok {
+		o.(*outbound).mux.Lock()
+		defer o.(*outbound).mux.Unlock()
+		return o.(*outbound).err
+	}
+	return nil
+}
+
+// WritePing is used for Gopher Game Server inner mechanics only. The ping gets the same write timeout as the socket's
+// messages, and the client is dropped when it times out.
+func WritePing(socket *websocket.Conn) error {
+	timeout := pingTimeout
+	o, hasWriter := outbounds.Load(socket)
+	if hasWriter && o.(*outbound).writeTimeout > 0 {
+		timeout = o.(*outbound).writeTimeout
+	}
+	err := socket.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(timeout))
+	if hasWriter && isTimeout(err) {
+		o.(*outbound).timedOut()
+	}
+	return err
+}
+
+// isTimeout returns true if a write failed because its deadline passed.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// writePrepared queues a message for a socket's writer, or writes it right away when the socket doesn't have one.
+func writePrepared(socket *websocket.Conn, message *websocket.PreparedMessage) error {
+	if o, ok := outbounds.Load(socket); ok {
+		return o.(*outbound).enqueue(message)
+	}
+	return socket.WritePreparedMessage(message)
+}
+
+func (o *outbound) enqueue(message *websocket.PreparedMessage) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if o.stopped {
+		return errWriterStopped
+	}
+	select {
+	case o.queue <- message:
+		atomic.AddInt64(&outboundQueued, 1)
+		return nil
+	default:
+		// THE CLIENT CAN'T KEEP UP - DROP IT. CLOSING THE SOCKET ENDS ITS READER, WHICH DISCONNECTS IT AS USUAL.
+		o.stopped = true
+		o.err = ErrSlowClient
+		close(o.done)
+		atomic.AddUint64(&slowClientDrops, 1)
+		Log().Debug("Dropping a client that's too slow to receive messages", "queued", len(o.queue))
+		go func() {
+			<-o.ended
+			o.socket.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "Too slow"),
+				time.Now().Add(time.Second*1))
+			o.socket.Close()
+		}()
+		return ErrSlowClient
+	}
+}
+
+func (o *outbound) stop(flush bool) {
+	o.mux.Lock()
+	if !o.stopped {
+		o.stopped = true
+		o.flush = flush
+		close(o.done)
+	}
+	o.mux.Unlock()
+}
+
+// timedOut drops a client that a write timed out on. Closing the socket ends its reader, which disconnects it with
+// ErrWriteTimeout.
+func (o *outbound) timedOut() {
+	o.mux.Lock()
+	if o.err == nil {
+		o.err = ErrWriteTimeout
+		atomic.AddUint64(&writeTimeouts, 1)
+		Log().Debug("Dropping a client that timed out receiving a message", "timeout", o.writeTimeout)
+	}
+	if !o.stopped {
+		o.stopped = true
+		close(o.done)
+	}
+	o.mux.Unlock()
+	o.socket.Close()
+}
+
+// run writes the queued messages in order until the writer is stopped, or a write fails.
+func (o *outbound) run() {
+	defer close(o.ended)
+	for {
+		select {
+		case message := <-o.queue:
+			atomic.AddInt64(&outboundQueued, -1)
+			if o.writeTimeout > 0 {
+				o.socket.SetWriteDeadline(time.Now().Add(o.writeTimeout))
+			}
+			if err := o.socket.WritePreparedMessage(message); err != nil {
+				if isTimeout(err) {
+					o.timedOut()
+				} else {
+					o.stop(false)
+					o.socket.Close()
+				}
+				o.discard()
+				return
+			}
+		case <-o.done:
+			o.mux.Lock()
+			flush := o.flush
+			o.mux.Unlock()
+			if flush {
+				o.socket.SetWriteDeadline(time.Now().Add(flushTimeout))
+				for len(o.queue) > 0 {
+					atomic.AddInt64(&outboundQueued, -1)
+					if o.socket.WritePreparedMessage(<-o.queue) != nil {
+						break
+					}
+				}
+			}
+			o.discard()
+			return
+		}
+	}
+}
+
+// discard empties the queue of a stopped writer. Only the writer's goroutine can call it.
+func (o *outbound) discard() {
+	for {
+		select {
+		case <-o.queue:
+			atomic.AddInt64(&outboundQueued, -1)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package helpers
+
+import (
+	"runtime/debug"
+	"sync/atomic"
+)
+
+// panicHandlerBox keeps every handler stored in panicHandler the same concrete type, like atomic.Value requires
+type panicHandlerBox struct {
+	handler func(string, interface{}, []byte)
+}
+
+var panicHandler atomic.Value
+
+// SetPanicHandler is only for internal Gopher Game Server mechanics. Use gopher.SetPanicHandler() to set the PanicHandler.
+func SetPanicHandler(handler func(string, interface{}, []byte)) {
+	panicHandler.Store(panicHandlerBox{handler})
+}
+
+// Recovered is only for internal Gopher Game Server mechanics. It logs a panic that was recovered from with its stack trace,
+// and passes it to the PanicHandler. where says what panicked, like "login callback", and keyvals are logged with it.
+func Recovered(where string, recovered interface{}, keyvals ...interface{}) {
+	stack := debug.Stack()
+	Log().Error("Panic in "+where, append(keyvals, "panic", recovered, "stack", string(stack))...)
+	if box, ok := panicHandler.Load().(panicHandlerBox); ok && box.handler != nil {
+		// THE PANIC HANDLER ISN'T ALLOWED TO CRASH THE SERVER EITHER
+		defer func() {
+			if p := recover(); p != nil {
+				Log().Error("Panic in the PanicHandler", "panic", p)
+			}
+		}()
+		box.handler(where, recovered, stack)
+	}
+}
+
+// Protect is only for internal Gopher Game Server mechanics. It runs fn, and if fn panics, reports it with Recovered() instead
+// of crashing the server. Returns true if fn panicked.
+func Protect(where string, fn func(), keyvals ...interface{}) (panicked bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			Recovered(where, p, keyvals...)
+			panicked = true
+		}
+	}()
+	fn()
+	return false
+}
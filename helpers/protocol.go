@@ -0,0 +1,62 @@
+package helpers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// These are the versions of the protocol the server and the client APIs speak. A client sends its version when it connects,
+// in its Sec-WebSocket-Protocol like "gopher-1.1", or in the URL like "/ws?version=1.1".
+const (
+	ProtocolVersion       = "1.1"     // The version of the protocol the server speaks
+	LegacyProtocolVersion = "1.0"     // The version of clients that don't send one. The server still speaks it, with a few differences
+	ProtocolPrefix        = "gopher-" // The start of the Sec-WebSocket-Protocol a client sends its version in
+)
+
+// CompareVersions compares two versions made of numbers and dots, like "1.10" and "1.9". Returns -1 when a is older than b, 0
+// when they're the same, and 1 when a is newer. Missing numbers count as 0, so "1" and "1.0" are the same. The bool is false
+// when either isn't a version.
+func CompareVersions(a string, b string) (int, bool) {
+	aParts, aOk := versionNumbers(a)
+	bParts, bOk := versionNumbers(b)
+	if !aOk || !bOk {
+		return 0, false
+	}
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aNum, bNum int
+		if i < len(aParts) {
+			aNum = aParts[i]
+		}
+		if i < len(bParts) {
+			bNum = bParts[i]
+		}
+		if aNum < bNum {
+			return -1, true
+		} else if aNum > bNum {
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// ValidVersion returns true if the version is made of numbers and dots, like "1.1".
+func ValidVersion(version string) bool {
+	_, ok := versionNumbers(version)
+	return ok
+}
+
+func versionNumbers(version string) ([]int, bool) {
+	if version == "" {
+		return nil, false
+	}
+	parts := strings.Split(version, ".")
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		num, err := strconv.Atoi(part)
+		if err != nil || num < 0 || part[0] == '+' {
+			return nil, false
+		}
+		nums[i] = num
+	}
+	return nums, true
+}
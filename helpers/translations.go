@@ -0,0 +1,140 @@
+package helpers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultLocale is the locale the server falls back to for messages that aren't translated to a client's locale.
+const DefaultLocale = "en"
+
+var (
+	//translationsMux LOCKS translations
+	translations    = make(map[string]map[int]string) // LOCALE -> MESSAGE KEY -> TEMPLATE
+	translationsMux sync.RWMutex
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   TRANSLATIONS   //////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SetTranslations sets the message templates for a locale, like "es" or "pt-BR", replacing the ones it had. A key is either an
+// error code, like ErrorRoomFull, so clients with that locale get the error's message translated, or a number of your own for
+// the messages you send with gopher.BroadcastLocalized() and *core.User.PrivateMessageLocalized(). Keep your own keys below
+// 1000, so they never clash with the error codes. Use a nil map to remove a locale.
+//
+// Templates can have positional parameters, like "{0} joined the match", which are filled in with FormatMessage(). Clients get
+// the template of their own locale, then of its language ("pt" for "pt-BR"), then of the DefaultLocale. Errors that don't have
+// any get the message from SetErrorMessage(), or the server's own message.
+func SetTranslations(locale string, msgs map[int]string) {
+	locale = NormalizeLocale(locale)
+	if locale == "" {
+		return
+	}
+	translationsMux.Lock()
+	defer translationsMux.Unlock()
+	if len(msgs) == 0 {
+		delete(translations, locale)
+		return
+	}
+	templates := make(map[int]string, len(msgs))
+	for key, template := range msgs {
+		templates[key] = template
+	}
+	translations[locale] = templates
+}
+
+// Translate gets the message template for a key in the locale, falling back to its language, then the DefaultLocale. The bool
+// is false when none of them have one.
+func Translate(locale string, key int) (string, bool) {
+	translationsMux.RLock()
+	defer translationsMux.RUnlock()
+	if len(translations) == 0 {
+		return "", false
+	}
+	locale = NormalizeLocale(locale)
+	for locale != "" {
+		if template, ok := translations[locale][key]; ok {
+			return template, true
+		}
+		if dash := strings.LastIndexByte(locale, '-'); dash != -1 {
+			locale = locale[:dash]
+		} else {
+			break
+		}
+	}
+	template, ok := translations[DefaultLocale][key]
+	return template, ok
+}
+
+// NormalizeLocale makes a locale code like "pt_BR" or " PT-br" into the form translations are looked up by, "pt-br". Returns ""
+// when it isn't a locale code.
+func NormalizeLocale(locale string) string {
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if len(locale) == 0 || len(locale) > 35 {
+		return ""
+	}
+	normalized := []byte(locale)
+	for i, c := range normalized {
+		if c == '_' {
+			normalized[i] = '-'
+		} else if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return ""
+		}
+	}
+	return string(normalized)
+}
+
+// FormatMessage fills in the positional parameters of a message template, like "{0}" with the first parameter, so a translator
+// can put them in whatever order their language needs. The template is only read once, so a parameter with braces in it is
+// never filled in itself. Write "{{" for a "{" in the message. Parameters that aren't given are left as they are.
+func FormatMessage(template string, params ...interface{}) string {
+	if !strings.Contains(template, "{") {
+		return template
+	}
+	var message strings.Builder
+	for i := 0; i < len(template); i++ {
+		if template[i] != '{' {
+			message.WriteByte(template[i])
+			continue
+		} else if strings.HasPrefix(template[i:], "{{") {
+			message.WriteByte('{')
+			i++
+			continue
+		}
+		end := strings.IndexByte(template[i:], '}')
+		if end == -1 {
+			message.WriteString(template[i:])
+			break
+		}
+		index, err := strconv.Atoi(template[i+1 : i+end])
+		if err != nil || index < 0 || index >= len(params) || template[i+1] == '+' {
+			message.WriteByte('{')
+			continue
+		}
+		message.WriteString(fmt.Sprint(params[index]))
+		i += end
+	}
+	return message.String()
+}
+
+// LocalizedErrorObject is used for Gopher Game Server inner mechanics only.
+func LocalizedErrorObject(err GopherError, locale string) map[string]interface{} {
+	message := err.Message
+	if template, ok := Translate(locale, err.ID); ok {
+		message = template
+	} else {
+		errorMessagesMux.RLock()
+		if override, ok := errorMessages[err.ID]; ok {
+			message = override
+		}
+		errorMessagesMux.RUnlock()
+	}
+	return map[string]interface{}{
+		"m":  message,
+		"c":  err.ID,
+		"id": err.ID, // FOR CLIENT APIS FROM BEFORE THE "c" KEY
+	}
+}
@@ -0,0 +1,206 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gorilla/websocket"
+	"sync"
+)
+
+// These are the wire formats a client can use. Clients use JSON unless they ask for MessagePack when connecting, and
+// EnableMessagePack is on in ServerSettings.
+const (
+	FormatJSON        = iota // Text messages with JSON
+	FormatMessagePack        // Binary messages with MessagePack
+)
+
+var (
+	// THE FORMATS OF THE SOCKETS THAT DON'T USE JSON, BY *websocket.Conn
+	socketFormats sync.Map
+
+	errNotMessagePack = errors.New("MessagePack clients must send binary messages")
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   SOCKET FORMATS   ////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SetSocketFormat is used for Gopher Game Server inner mechanics only.
+func SetSocketFormat(socket *websocket.Conn, format int) {
+	if format == FormatJSON {
+		socketFormats.Delete(socket)
+		return
+	}
+	socketFormats.Store(socket, format)
+}
+
+// SocketFormat gets the wire format of a client's socket, like FormatJSON.
+func SocketFormat(socket *websocket.Conn) int {
+	if format, ok := socketFormats.Load(socket); ok {
+		return format.(int)
+	}
+	return FormatJSON
+}
+
+// ForgetSocket is used for Gopher Game Server inner mechanics only.
+func ForgetSocket(socket *websocket.Conn) {
+	socketFormats.Delete(socket)
+	if o, ok := outbounds.Load(socket); ok {
+		o.(*outbound).stop(false)
+		outbounds.Delete(socket)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   WRITING AND READING MESSAGES   //////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// WriteMessage writes a message to a client's socket in the socket's wire format. An *Encoded message is only encoded once
+// for each format, no matter how many sockets it's written to. Connected clients' messages are queued for their socket's
+// writer, so an error only means the message couldn't be queued.
+func WriteMessage(socket *websocket.Conn, message interface{}) error {
+	if encoded, ok := message.(*Encoded); ok {
+		return encoded.Write(socket)
+	}
+	return NewEncoded(message).Write(socket)
+}
+
+// WriteJSONData is used for Gopher Game Server inner mechanics only.
+func WriteJSONData(socket *websocket.Conn, data []byte) error {
+	if SocketFormat(socket) == FormatJSON {
+		prepared, err := websocket.NewPreparedMessage(websocket.TextMessage, data)
+		if err != nil {
+			return err
+		}
+		return writePrepared(socket, prepared)
+	}
+	var message interface{}
+	if err := decodeJSONNumbers(data, &message); err != nil {
+		return err
+	}
+	return WriteMessage(socket, message)
+}
+
+// ReadMessage is used for Gopher Game Server inner mechanics only.
+func ReadMessage(socket *websocket.Conn, v interface{}) error {
+	messageType, data, err := socket.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if data, err = ClientJSON(SocketFormat(socket), messageType, data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ClientJSON is used for Gopher Game Server inner mechanics only.
+func ClientJSON(format int, messageType int, data []byte) ([]byte, error) {
+	if format != FormatMessagePack {
+		return data, nil
+	} else if messageType != websocket.BinaryMessage {
+		return nil, errNotMessagePack
+	}
+	message, err := UnmarshalMessagePack(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(message)
+}
+
+func decodeJSONNumbers(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   ENCODING ONCE FOR MANY SOCKETS   ////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Encoded is a message for sending to many sockets, which is only encoded for each wire format the first time it's
+// written to a socket with that format. Don't change the message after making an Encoded with it.
+type Encoded struct {
+	message interface{}
+	binary  []byte // A BINARY MESSAGE, LIKE A VOICE FRAME, INSTEAD OF message
+
+	mux      sync.Mutex
+	json     []byte
+	prepared [2]*websocket.PreparedMessage // BY FORMAT
+	err      [2]error
+}
+
+// NewEncoded is used for Gopher Game Server inner mechanics only.
+func NewEncoded(message interface{}) *Encoded {
+	return &Encoded{message: message}
+}
+
+// NewEncodedBinary is used for Gopher Game Server inner mechanics only. JSON clients get the data as a binary message, and
+// MessagePack clients get it as a MessagePack bin, so they can tell it apart from the other messages.
+func NewEncodedBinary(data []byte) *Encoded {
+	return &Encoded{binary: data}
+}
+
+// Prepared gets the message prepared for sockets with the wire format.
+func (e *Encoded) Prepared(format int) (*websocket.PreparedMessage, error) {
+	if format != FormatMessagePack {
+		format = FormatJSON
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	if e.prepared[format] != nil || e.err[format] != nil {
+		return e.prepared[format], e.err[format]
+	}
+	var messageType int
+	var data []byte
+	var err error
+	if format == FormatMessagePack {
+		messageType = websocket.BinaryMessage
+		if e.binary != nil {
+			data, err = MarshalMessagePack(e.binary)
+		} else {
+			data, err = MarshalMessagePack(e.message)
+		}
+	} else if e.binary != nil {
+		messageType, data = websocket.BinaryMessage, e.binary
+	} else {
+		messageType = websocket.TextMessage
+		data, err = e.marshalJSON()
+	}
+	if err == nil {
+		e.prepared[format], err = websocket.NewPreparedMessage(messageType, data)
+	}
+	e.err[format] = err
+	return e.prepared[format], err
+}
+
+// Write writes the message to a socket in the socket's wire format.
+func (e *Encoded) Write(socket *websocket.Conn) error {
+	prepared, err := e.Prepared(SocketFormat(socket))
+	if err != nil {
+		return err
+	}
+	return writePrepared(socket, prepared)
+}
+
+// MarshalJSON gets the message as JSON, so an *Encoded can be buffered like any other message.
+func (e *Encoded) MarshalJSON() ([]byte, error) {
+	if e.binary != nil {
+		return nil, errors.New("A binary message can't be encoded as JSON")
+	}
+	e.mux.Lock()
+	defer e.mux.Unlock()
+	return e.marshalJSON()
+}
+
+// marshalJSON encodes the message as JSON once. e.mux must be locked.
+func (e *Encoded) marshalJSON() ([]byte, error) {
+	if e.json == nil {
+		data, err := json.Marshal(e.message)
+		if err != nil {
+			return nil, err
+		}
+		e.json = data
+	}
+	return e.json, nil
+}
@@ -0,0 +1,89 @@
+package gopher
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"time"
+)
+
+// redacted replaces secrets in the ServerSettings returned by Settings().
+const redacted = "[redacted]"
+
+// Version gets the version of Gopher Game Server, like "1.0-BETA.2".
+func Version() string {
+	return version
+}
+
+// IsRunning returns true when the server has started and hasn't shut down. A paused server is still running, so check IsPaused() too
+// before sending clients anywhere.
+func IsRunning() bool {
+	stoppingMux.Lock()
+	defer stoppingMux.Unlock()
+	return serverRunning && !serverStopping
+}
+
+// IsPaused returns true when the server is paused with Pause().
+func IsPaused() bool {
+	return isPaused()
+}
+
+// Uptime gets how long the server has been running, or 0 when it isn't running. Pausing the server doesn't reset it.
+func Uptime() time.Duration {
+	stoppingMux.Lock()
+	defer stoppingMux.Unlock()
+	if !serverRunning || serverStopping {
+		return 0
+	}
+	return time.Since(startTime)
+}
+
+// Settings gets a copy of the ServerSettings the server was started with, or an empty ServerSettings before it has started. The
+// SqlPassword, AdminPassword, RESTAuthToken and the Cluster's Password are replaced with "[redacted]" when they're set, so the copy is safe to show on a status page.
+// PrivKeyFile is only the location of the private key, so it's kept. Changing the copy doesn't change the server's settings.
+func Settings() ServerSettings {
+	if settings == nil {
+		return ServerSettings{}
+	}
+	s := *settings
+	if s.SqlPassword != "" {
+		s.SqlPassword = redacted
+	}
+	if s.AdminPassword != "" {
+		s.AdminPassword = redacted
+	}
+	if s.RESTAuthToken != "" {
+		s.RESTAuthToken = redacted
+	}
+	if s.Cluster != nil {
+		cluster := *s.Cluster
+		if cluster.Password != "" {
+			cluster.Password = redacted
+		}
+		s.Cluster = &cluster
+	}
+	s.ListenAddresses = copyStrings(s.ListenAddresses)
+	s.AutoCertHosts = copyStrings(s.AutoCertHosts)
+	s.AllowedOrigins = copyStrings(s.AllowedOrigins)
+	s.TrustedProxies = copyStrings(s.TrustedProxies)
+	return s
+}
+
+func copyStrings(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append([]string(nil), list...)
+}
+
+// serverInfo makes the response to the helpers.ClientActionServerInfo client action.
+func serverInfo() map[string]interface{} {
+	info := map[string]interface{}{
+		"v": version,
+		"p": helpers.ProtocolVersion,
+	}
+	if settings != nil {
+		info["n"] = settings.ServerName
+		info["g"] = settings.AllowGuests
+		info["q"] = settings.EnableSqlFeatures
+	}
+	return info
+}
@@ -0,0 +1,92 @@
+package gopher
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// FINDS THE IP ADDRESSES OF HOST NAMES IN IP AND ListenAddresses
+	lookupHost = net.LookupHost
+)
+
+// listenAddresses gets the addresses the server listens on, from ListenAddresses or IP. Host names are listened on at each of
+// their IP addresses, so a name like "localhost" gets both its IPv4 and IPv6 addresses. An empty host listens on every interface,
+// with IPv4 and IPv6.
+func (settings *ServerSettings) listenAddresses() ([]string, error) {
+	hosts := settings.ListenAddresses
+	if len(hosts) == 0 {
+		hosts = []string{settings.IP}
+	}
+	var addresses []string
+	added := make(map[string]bool)
+	for _, host := range hosts {
+		port := strconv.Itoa(settings.Port)
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			host, port = h, p
+		} else {
+			// NO PORT - IPv6 ADDRESSES CAN STILL BE IN BRACKETS
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		ips := []string{host}
+		if host != "" && net.ParseIP(host) == nil {
+			var err error
+			if ips, err = lookupHost(host); err != nil {
+				return nil, errors.New("Can't find the IP addresses of " + host + ": " + err.Error())
+			}
+		}
+		for _, ip := range ips {
+			address := net.JoinHostPort(ip, port)
+			if !added[address] {
+				added[address] = true
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	return addresses, nil
+}
+
+// listen opens a listener on each of the addresses. If any of them fails, the ones already open are closed, and the error
+// tells which address failed.
+func listen(addresses []string) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, address := range addresses {
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, errors.New("Can't listen on " + address + ": " + err.Error())
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// serve runs the server on each of the listeners. The first error, or http.ErrServerClosed once the server is shut down, is
+// sent to serverEndChan. When one listener fails, the server is closed so the others stop too.
+func serve(server *http.Server, listeners []net.Listener, certFile string, keyFile string, tls bool) {
+	var once sync.Once
+	end := func(err error) {
+		once.Do(func() { serverEndChan <- err })
+	}
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			var err error
+			if tls {
+				err = server.ServeTLS(listener, certFile, keyFile)
+			} else {
+				err = server.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				err = errors.New("Error listening on " + listener.Addr().String() + ": " + err.Error())
+				server.Close()
+			}
+			end(err)
+		}(listener)
+	}
+}
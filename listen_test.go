@@ -0,0 +1,68 @@
+package gopher
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestListenAddresses(t *testing.T) {
+	defer func() { lookupHost = net.LookupHost }()
+	lookupHost = func(host string) ([]string, error) {
+		if host == "dualstack.test" {
+			return []string{"127.0.0.1", "::1"}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	tests := []struct {
+		ip        string
+		listen    []string
+		addresses []string
+	}{
+		{ip: "[::1]", addresses: []string{"[::1]:8080"}},
+		{ip: "::1", addresses: []string{"[::1]:8080"}},
+		{ip: "0.0.0.0", addresses: []string{"0.0.0.0:8080"}},
+		{ip: "", addresses: []string{":8080"}},
+		{ip: "dualstack.test", addresses: []string{"127.0.0.1:8080", "[::1]:8080"}},
+		{ip: "ignored", listen: []string{"0.0.0.0", "[::1]:9000", "dualstack.test", "127.0.0.1:8080"},
+			addresses: []string{"0.0.0.0:8080", "[::1]:9000", "127.0.0.1:8080", "[::1]:8080"}},
+	}
+	for _, test := range tests {
+		s := ServerSettings{IP: test.ip, Port: 8080, ListenAddresses: test.listen}
+		addresses, err := s.listenAddresses()
+		if err != nil {
+			t.Error(test.ip, err)
+		} else if !reflect.DeepEqual(addresses, test.addresses) {
+			t.Error("Expected", test.addresses, "for", test.ip, test.listen, "got", addresses)
+		}
+	}
+	s := ServerSettings{IP: "missing.test", Port: 8080}
+	if _, err := s.listenAddresses(); err == nil || !strings.Contains(err.Error(), "missing.test") {
+		t.Error("Expected an error with the host that couldn't be found, got", err)
+	}
+}
+
+func TestListen(t *testing.T) {
+	listeners, err := listen([]string{"127.0.0.1:0", "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(listeners) != 2 {
+		t.Fatal("Expected 2 listeners, got", len(listeners))
+	}
+	defer listeners[1].Close()
+
+	// The address in use is reported, and the listeners already open are closed
+	taken := listeners[1].Addr().String()
+	listeners[0].Close()
+	free := listeners[0].Addr().String()
+	if _, err := listen([]string{free, taken}); err == nil || !strings.Contains(err.Error(), taken) {
+		t.Fatal("Expected an error listening on", taken, "got", err)
+	}
+	if l, err := net.Listen("tcp", free); err != nil {
+		t.Error("Expected", free, "to be closed after the error, got", err)
+	} else {
+		l.Close()
+	}
+}
@@ -0,0 +1,97 @@
+package gopher
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io"
+	"sync"
+	"time"
+)
+
+// Logger is what the server logs with. Each message can come with fields as key-value pairs, like the "user" and "ip" of
+// a client. The server logs connections, logins, and the Rooms being made and deleted with Debug, its start-up and
+// shut-down with Info, a lost database connection with Warn, and SQL errors and panics in your callbacks with Error.
+type Logger = helpers.Logger
+
+// SetLogger sets the Logger the server and all of its packages log with. The default Logger prints Info, Warn, and Error
+// messages to the console, and drops Debug messages. Setting it to nil brings back the default Logger.
+func SetLogger(l Logger) {
+	helpers.SetLogger(l)
+}
+
+// PanicHandler gets every panic the server recovers from, like one in your callbacks, CustomClientActions, or Room timers.
+// where says what panicked, like "login callback" or "CustomClientAction setPosition", and stack is the stack trace of the
+// panic. The panic is also logged with Error.
+type PanicHandler func(where string, recovered interface{}, stack []byte)
+
+// SetPanicHandler sets a PanicHandler, for instance to send panics to your error tracking service. A panic in your callbacks
+// is recovered from, and doesn't crash the server. Setting it to nil removes the PanicHandler.
+func SetPanicHandler(handler PanicHandler) {
+	helpers.SetPanicHandler(handler)
+}
+
+// NewJSONLogger makes a Logger that writes each message to w as a line of JSON, like:
+//
+//	{"level":"info","msg":"User logged in","time":"2019-10-14T09:32:04Z","user":"gopher"}
+//
+// Debug messages are only written when debug is true.
+func NewJSONLogger(w io.Writer, debug bool) Logger {
+	return &jsonLogger{w: w, debug: debug}
+}
+
+type jsonLogger struct {
+	mux   sync.Mutex
+	w     io.Writer
+	debug bool
+}
+
+func (l *jsonLogger) Debug(msg string, keyvals ...interface{}) {
+	if l.debug {
+		l.log("debug", msg, keyvals)
+	}
+}
+
+func (l *jsonLogger) Info(msg string, keyvals ...interface{}) {
+	l.log("info", msg, keyvals)
+}
+
+func (l *jsonLogger) Warn(msg string, keyvals ...interface{}) {
+	l.log("warn", msg, keyvals)
+}
+
+func (l *jsonLogger) Error(msg string, keyvals ...interface{}) {
+	l.log("error", msg, keyvals)
+}
+
+func (l *jsonLogger) log(level string, msg string, keyvals []interface{}) {
+	entry := map[string]interface{}{}
+	for i := 0; i < len(keyvals); i += 2 {
+		var val interface{} = "(MISSING)"
+		if i+1 < len(keyvals) {
+			val = keyvals[i+1]
+		}
+		switch v := val.(type) {
+		case error:
+			val = v.Error()
+		case fmt.Stringer:
+			val = v.String()
+		}
+		entry[fmt.Sprint(keyvals[i])] = val
+	}
+	entry["level"] = level
+	entry["msg"] = msg
+	entry["time"] = time.Now().UTC().Format(time.RFC3339)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		// A VALUE CAN'T BE JSON, SO WRITE ALL OF THEM AS STRINGS
+		for key, val := range entry {
+			entry[key] = fmt.Sprint(val)
+		}
+		line, _ = json.Marshal(entry)
+	}
+	l.mux.Lock()
+	l.w.Write(append(line, '\n'))
+	l.mux.Unlock()
+}
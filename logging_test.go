@@ -0,0 +1,84 @@
+package gopher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"strings"
+	"testing"
+)
+
+func TestFormatLogLine(t *testing.T) {
+	line := helpers.FormatLogLine("Room created", []interface{}{"room", "lobby", "owner", "gopher bot", "type"})
+	if want := `Room created room=lobby owner="gopher bot" type=(MISSING)`; line != want {
+		t.Errorf("FormatLogLine() = %q, want %q", line, want)
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, false)
+	logger.Debug("dropped")
+	logger.Error("SQL error", "user", "gopher", "error", errors.New("no such table"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatal("Expected only the Error message to be written, got", lines)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["level"] != "error" || entry["msg"] != "SQL error" || entry["user"] != "gopher" || entry["error"] != "no such table" {
+		t.Error("Unexpected log entry", entry)
+	}
+}
+
+type recordingLogger struct {
+	errors []string
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) {}
+func (l *recordingLogger) Info(msg string, keyvals ...interface{})  {}
+func (l *recordingLogger) Warn(msg string, keyvals ...interface{})  {}
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) {
+	l.errors = append(l.errors, msg)
+}
+
+func TestSetLogger(t *testing.T) {
+	defer SetLogger(nil)
+	logger := &recordingLogger{}
+	SetLogger(logger)
+	helpers.Log().Error("Database error")
+	if len(logger.errors) != 1 || logger.errors[0] != "Database error" {
+		t.Error("Expected the server to log with the set Logger, got", logger.errors)
+	}
+}
+
+func TestPanicHandler(t *testing.T) {
+	defer SetPanicHandler(nil)
+	var where string
+	var recovered interface{}
+	var stack []byte
+	SetPanicHandler(func(w string, r interface{}, s []byte) {
+		where, recovered, stack = w, r, s
+	})
+
+	// A panicking login callback denies the login instead of crashing the server
+	callback := protectAccountCallback("login callback", func(string, int, map[string]interface{}, map[string]interface{}) bool {
+		panic("login panic")
+	})
+	if callback("gopher", 1, nil, nil) {
+		t.Error("A login callback that panics should deny the login")
+	}
+	if where != "login callback" || recovered != "login panic" || len(stack) == 0 {
+		t.Error("Unexpected panic report", where, recovered, len(stack))
+	}
+
+	// A panicking PanicHandler is recovered from too
+	SetPanicHandler(func(string, interface{}, []byte) { panic("handler panic") })
+	if !helpers.Protect("test", func() { panic("test panic") }) {
+		t.Error("Protect() should return true when the function panics")
+	}
+}
@@ -0,0 +1,371 @@
+package gopher
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Queue is a matchmaking queue. Users join it with a rating, and in the background the Queue groups Users with close
+// ratings into matches. For each match, it makes a private Room of its RoomType, moves the Users into it, and sends each
+// of them a helpers.ServerActionMatchFound message. Use gopher.NewQueue() to make a Queue.
+//
+// A match's Room isn't deleted by the Queue. Delete it when the match is over.
+type Queue struct {
+	name            string
+	playersPerMatch int
+	roomType        string
+
+	//mux LOCKS ALL FIELDS BELOW
+	mux            sync.Mutex
+	players        []*queuedPlayer // IN THE ORDER THEY JOINED
+	ratingWindow   int
+	ratingGrowth   int
+	ratingEvery    time.Duration
+	ratingCallback func(*core.User) int
+	matchCallback  func(string, []string)
+	matches        int
+
+	stop chan bool
+}
+
+type queuedPlayer struct {
+	user   *core.User
+	connID string
+	rating int
+	joined time.Time
+}
+
+const (
+	errorQueueJoined    = "You are already in the queue"
+	errorQueueNotJoined = "You are not in the queue"
+	errorQueueInvalid   = "Invalid queue"
+
+	defaultRatingWindow = 100
+	defaultRatingGrowth = 50
+	defaultRatingEvery  = time.Second * 5
+	matchInterval       = time.Second
+)
+
+var (
+	//queuesMux LOCKS queues
+	queues    = make(map[string]*Queue)
+	queuesMux sync.Mutex
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   MAKING QUEUES   /////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// NewQueue makes a matchmaking Queue that makes matches of playersPerMatch Users in Rooms with the RoomType named roomType.
+// The Queue starts matching right away, and keeps going until it's deleted with *Queue.Delete(). By default, Users are
+// matched with others rated up to 100 away from them, and the window grows by 50 every 5 seconds they wait. Change it
+// with *Queue.SetRatingWindow(). You can chain the Queue's settings and callbacks like so:
+//
+//    queue, err := gopher.NewQueue("ranked", 2, "match")
+//    queue.SetRatingWindow(50, 25, time.Second*10).SetMatchFoundCallback(yourFunc)
+//
+func NewQueue(name string, playersPerMatch int, roomType string) (*Queue, error) {
+	if len(name) == 0 {
+		return nil, errors.New("gopher.NewQueue() requires a name")
+	} else if playersPerMatch < 2 {
+		return nil, errors.New("A queue's matches need at least 2 players")
+	} else if _, ok := core.GetRoomTypes()[roomType]; !ok {
+		return nil, errors.New("Invalid room type '" + roomType + "'")
+	}
+	queuesMux.Lock()
+	defer queuesMux.Unlock()
+	if _, ok := queues[name]; ok {
+		return nil, errors.New("A queue with the name '" + name + "' already exists")
+	}
+	queue := &Queue{
+		name:            name,
+		playersPerMatch: playersPerMatch,
+		roomType:        roomType,
+		ratingWindow:    defaultRatingWindow,
+		ratingGrowth:    defaultRatingGrowth,
+		ratingEvery:     defaultRatingEvery,
+		stop:            make(chan bool),
+	}
+	queues[name] = queue
+	go queue.run()
+
+	//
+	return queue, nil
+}
+
+// GetQueue gets a Queue by name.
+func GetQueue(name string) (*Queue, error) {
+	queuesMux.Lock()
+	queue, ok := queues[name]
+	queuesMux.Unlock()
+	if !ok {
+		return nil, errors.New("The queue '" + name + "' does not exist")
+	}
+	return queue, nil
+}
+
+// Delete stops the Queue from matching, and removes every User from it.
+func (q *Queue) Delete() {
+	queuesMux.Lock()
+	if queues[q.name] == q {
+		delete(queues, q.name)
+		close(q.stop)
+	}
+	queuesMux.Unlock()
+	q.mux.Lock()
+	q.players = nil
+	q.mux.Unlock()
+}
+
+// Name gets the name of the Queue.
+func (q *Queue) Name() string {
+	return q.name
+}
+
+// SetRatingWindow sets how far apart the ratings of matched Users can be. A User starts out matching Users rated up to window
+// away, and their window grows by growth every per they wait. Use a growth of 0 to never widen it.
+func (q *Queue) SetRatingWindow(window int, growth int, per time.Duration) *Queue {
+	if window < 0 || growth < 0 || per <= 0 {
+		return q
+	}
+	q.mux.Lock()
+	q.ratingWindow, q.ratingGrowth, q.ratingEvery = window, growth, per
+	q.mux.Unlock()
+	return q
+}
+
+// SetRatingCallback sets the function that gets the rating of a User joining the Queue with the helpers.ClientActionJoinQueue
+// client action. Clients can't send their own rating. Without a rating callback, they join with a rating of 0.
+func (q *Queue) SetRatingCallback(cb func(*core.User) int) *Queue {
+	q.mux.Lock()
+	q.ratingCallback = cb
+	q.mux.Unlock()
+	return q
+}
+
+// SetMatchFoundCallback sets the callback function for when the Queue makes a match. It receives the name of the match's
+// Room, and the names of the Users in it, after they've been moved into it.
+func (q *Queue) SetMatchFoundCallback(cb func(string, []string)) *Queue {
+	q.mux.Lock()
+	q.matchCallback = cb
+	q.mux.Unlock()
+	return q
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   JOINING AND LEAVING   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Join puts a User in the Queue with a rating. If you are using MultiConnect in ServerSettings, the connID parameter is the
+// connection ID of the connection that gets moved into the match's Room. Otherwise, an empty string can be used. A User
+// that disconnects, logs out or is kicked is removed from the Queue.
+func (q *Queue) Join(u *core.User, connID string, rating int) error {
+	multiConnect := settings != nil && (*settings).MultiConnect
+	if u == nil {
+		return errors.New("*Queue.Join() requires a User")
+	} else if multiConnect && len(connID) == 0 {
+		return errors.New("Must provide a connID when MultiConnect is enabled")
+	} else if !multiConnect {
+		connID = ""
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.find(u, connID) != -1 {
+		return errors.New(errorQueueJoined)
+	}
+	q.players = append(q.players, &queuedPlayer{user: u, connID: connID, rating: rating, joined: time.Now()})
+	helpers.Log().Debug("User joined queue", "user", u.Name(), "queue", q.name, "rating", rating)
+
+	//
+	return nil
+}
+
+// Leave takes a User out of the Queue. If you are using MultiConnect in ServerSettings, the connID parameter is the one
+// they joined with. Otherwise, an empty string can be used.
+func (q *Queue) Leave(u *core.User, connID string) error {
+	if settings == nil || !(*settings).MultiConnect {
+		connID = ""
+	}
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	i := q.find(u, connID)
+	if i == -1 {
+		return errors.New(errorQueueNotJoined)
+	}
+	q.players = append(q.players[:i], q.players[i+1:]...)
+	return nil
+}
+
+// Size gets the amount of Users in the Queue.
+func (q *Queue) Size() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return len(q.players)
+}
+
+// find gets the index of a User's connection in the Queue, or -1. q.mux must be locked.
+func (q *Queue) find(u *core.User, connID string) int {
+	for i, player := range q.players {
+		if player.user == u && player.connID == connID {
+			return i
+		}
+	}
+	return -1
+}
+
+// leaveQueues takes a User's connection out of every Queue.
+func leaveQueues(u *core.User, connID string) {
+	queuesMux.Lock()
+	all := make([]*Queue, 0, len(queues))
+	for _, queue := range queues {
+		all = append(all, queue)
+	}
+	queuesMux.Unlock()
+	for _, queue := range all {
+		queue.Leave(u, connID)
+	}
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   MATCHING   //////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (q *Queue) run() {
+	ticker := time.NewTicker(matchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			q.match(now)
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// match makes as many matches as it can from the Users in the Queue at the time now.
+func (q *Queue) match(now time.Time) {
+	q.mux.Lock()
+	// DROP THE USERS THAT AREN'T CONNECTED ANYMORE
+	players := q.players[:0]
+	for _, player := range q.players {
+		if player.user.Socket(player.connID) != nil {
+			players = append(players, player)
+		}
+	}
+	q.players = players
+
+	// THE USERS WHO HAVE WAITED THE LONGEST PICK THEIR MATCHES FIRST
+	var groups [][]*queuedPlayer
+	matched := make(map[*queuedPlayer]bool)
+	for _, anchor := range q.players {
+		if matched[anchor] {
+			continue
+		}
+		window := q.window(anchor, now)
+		var candidates []*queuedPlayer
+		for _, player := range q.players {
+			if player != anchor && !matched[player] && abs(player.rating-anchor.rating) <= window &&
+				abs(player.rating-anchor.rating) <= q.window(player, now) {
+				candidates = append(candidates, player)
+			}
+		}
+		if len(candidates) < q.playersPerMatch-1 {
+			continue
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return abs(candidates[i].rating-anchor.rating) < abs(candidates[j].rating-anchor.rating)
+		})
+		group := append([]*queuedPlayer{anchor}, candidates[:q.playersPerMatch-1]...)
+		for _, player := range group {
+			matched[player] = true
+		}
+		groups = append(groups, group)
+	}
+	if len(groups) > 0 {
+		remaining := q.players[:0]
+		for _, player := range q.players {
+			if !matched[player] {
+				remaining = append(remaining, player)
+			}
+		}
+		q.players = remaining
+	}
+	q.mux.Unlock()
+
+	for _, group := range groups {
+		q.startMatch(group)
+	}
+}
+
+// window gets how far from a User's rating they can be matched at the time now. q.mux must be locked.
+func (q *Queue) window(player *queuedPlayer, now time.Time) int {
+	return q.ratingWindow + q.ratingGrowth*int(now.Sub(player.joined)/q.ratingEvery)
+}
+
+// startMatch makes a Room for a group of matched Users, moves them into it, and tells them.
+func (q *Queue) startMatch(group []*queuedPlayer) {
+	// MAKE A PRIVATE ROOM ONLY THE MATCHED USERS ARE INVITED TO
+	var room *core.Room
+	for room == nil {
+		q.mux.Lock()
+		q.matches++
+		roomName := q.name + " #" + strconv.Itoa(q.matches)
+		q.mux.Unlock()
+		var roomErr error
+		if room, roomErr = core.NewRoom(roomName, q.roomType, true, q.playersPerMatch, ""); roomErr != nil {
+			// TRY THE NEXT NAME IF THIS ONE IS TAKEN
+			if _, getErr := core.GetRoom(roomName); getErr != nil {
+				helpers.Log().Error("Error making a match's room", "queue", q.name, "error", roomErr)
+				return
+			}
+			room = nil
+		}
+	}
+	names := make([]string, 0, len(group))
+	for _, player := range group {
+		names = append(names, player.user.Name())
+		room.AddInvite(player.user.Name())
+	}
+
+	// MOVE THEM IN
+	message := map[string]map[string]interface{}{
+		helpers.ServerActionMatchFound: {
+			"q": q.name,
+			"r": room.Name(),
+			"u": names,
+		},
+	}
+	for _, player := range group {
+		if joinErr := player.user.Join(room, player.connID); joinErr != nil {
+			helpers.Log().Warn("Error moving a matched user into their room", "queue", q.name, "user", player.user.Name(), "error", joinErr)
+			continue
+		}
+		player.user.SendToConnection(player.connID, message)
+	}
+	helpers.Log().Debug("Match found", "queue", q.name, "room", room.Name(), "users", names)
+
+	// A MATCHED USER ISN'T WAITING IN ANY OTHER QUEUE
+	for _, player := range group {
+		leaveQueues(player.user, player.connID)
+	}
+
+	q.mux.Lock()
+	cb := q.matchCallback
+	q.mux.Unlock()
+	if cb != nil {
+		helpers.Protect("match found callback", func() { cb(room.Name(), names) }, "queue", q.name)
+	}
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,138 @@
+package gopher
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testUser logs in a guest on the server's side of a new WebSocket connection, and returns them with the client's side of it.
+func testUser(t *testing.T, name string) (*core.User, *websocket.Conn) {
+	sockets := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, _ := websocket.Upgrade(w, r, w.Header(), 1024, 1024)
+		sockets <- socket
+	}))
+	t.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	var user *core.User
+	var clientMux sync.Mutex
+	if _, gErr := core.Login(name, -1, "", true, false, <-sockets, &user, &clientMux); gErr.ID != 0 {
+		t.Fatal(gErr.Message)
+	}
+	t.Cleanup(user.Kick)
+	return user, client
+}
+
+func TestMatchmaking(t *testing.T) {
+	oldSettings := settings
+	defer func() { settings = oldSettings }()
+	settings = &ServerSettings{}
+	core.NewRoomType("matchTest", false)
+	queue, err := NewQueue("testQueue", 2, "matchTest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Delete()
+	if _, err := NewQueue("testQueue", 2, "matchTest"); err == nil {
+		t.Error("Expected a second queue with the same name to fail")
+	}
+	// The Queue's own matcher could run the callback too
+	var found []string
+	var foundMux sync.Mutex
+	queue.SetRatingWindow(10, 10, time.Minute).SetMatchFoundCallback(func(roomName string, userNames []string) {
+		foundMux.Lock()
+		found = append([]string{roomName}, userNames...)
+		foundMux.Unlock()
+	})
+	getFound := func() []string {
+		foundMux.Lock()
+		defer foundMux.Unlock()
+		return found
+	}
+
+	first, firstClient := testUser(t, "matchFirst")
+	far, _ := testUser(t, "matchFar")
+	closest, _ := testUser(t, "matchClose")
+	queue.Join(first, "", 1000)
+	queue.Join(far, "", 1015)
+	queue.Join(closest, "", 1005)
+	if queue.Join(first, "", 1000) == nil {
+		t.Error("Joining a queue twice should fail")
+	}
+
+	// The User who waited the longest is matched with the closest rating in their window
+	now := time.Now()
+	queue.match(now)
+	if found := getFound(); len(found) != 3 || found[0] != "testQueue #1" || found[1] != "matchFirst" || found[2] != "matchClose" {
+		t.Fatal("Expected matchFirst and matchClose to be matched, got", found)
+	} else if queue.Size() != 1 {
+		t.Error("Expected only matchFar left in the queue, got", queue.Size())
+	}
+	room, roomErr := core.GetRoom("testQueue #1")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	if first.RoomIn("") != room || closest.RoomIn("") != room {
+		t.Error("Expected the matched Users to be moved into the match's Room")
+	}
+	firstClient.SetReadDeadline(time.Now().Add(time.Second * 2))
+	for {
+		var message map[string]map[string]interface{}
+		if err := firstClient.ReadJSON(&message); err != nil {
+			t.Fatal("Expected a match found message:", err)
+		} else if match, ok := message[helpers.ServerActionMatchFound]; ok {
+			if match["q"] != "testQueue" || match["r"] != "testQueue #1" {
+				t.Error("Expected the match found message for the queue and Room, got", match)
+			}
+			break
+		}
+	}
+
+	// Windows widen the longer a User waits, but both Users' windows must fit
+	queue.mux.Lock()
+	queue.players[0].joined = now.Add(-time.Minute * 3)
+	queue.mux.Unlock()
+	late, _ := testUser(t, "matchLate")
+	queue.Join(late, "", 1040)
+	foundMux.Lock()
+	found = nil
+	foundMux.Unlock()
+	queue.match(now)
+	if found := getFound(); found != nil {
+		t.Error("A new User's window should not have widened yet, got a match", found)
+	}
+	queue.match(now.Add(time.Minute * 3))
+	if found := getFound(); len(found) != 3 || found[1] != "matchFar" || found[2] != "matchLate" {
+		t.Error("Expected matchFar and matchLate to be matched once both windows widened, got", found)
+	}
+	if room, roomErr := core.GetRoom("testQueue #2"); roomErr == nil {
+		defer room.Delete()
+	}
+
+	// Users who aren't connected anymore are dropped
+	gone, _ := testUser(t, "matchGone")
+	queue.Join(gone, "", 0)
+	gone.Kick()
+	queue.match(now)
+	if queue.Size() != 0 {
+		t.Error("Expected the kicked User to be dropped from the queue, got a size of", queue.Size())
+	}
+	queue.Join(first, "", 1000)
+	if err := queue.Leave(first, ""); err != nil {
+		t.Error(err)
+	} else if queue.Leave(first, "") == nil {
+		t.Error("Leaving a queue twice should fail")
+	}
+}
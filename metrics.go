@@ -0,0 +1,284 @@
+package gopher
+
+import (
+	"bufio"
+	"github.com/hewiefreeman/GopherGameServer/actions"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/database"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats is a snapshot of the server's metrics. Use gopher.Stats() to get one.
+type ServerStats struct {
+	Connections int // The number of clients connected, including the ones not logged in as a User
+	Users       int // The number of Users logged in, including guests
+	Guests      int // The number of Users logged in as guests
+	Registered  int // The number of Users logged in that aren't guests
+
+	Rooms       int            // The number of Rooms on the server
+	RoomsByType map[string]int // The number of Rooms of each RoomType, by the RoomType's name
+
+	MessagesReceived  uint64 // The number of messages received from clients since the server started
+	MessagesPerSecond uint64 // The number of messages received from clients in the last full second
+	LoginFailures     uint64 // The number of login client actions that failed since the server started
+
+	OutboundQueued     int    // The number of messages waiting to be written to clients, across all the connections
+	SlowClientsDropped uint64 // The number of clients disconnected since the server started for falling OutboundQueueSize messages behind
+	WriteTimeouts      uint64 // The number of clients disconnected since the server started because a write to them took longer than WriteTimeout
+
+	DatabaseWritesQueued int    // The number of database writes waiting to run in the background, with the SQL features enabled
+	DatabaseWriteErrors  uint64 // The number of queued database writes that failed for good since the server started
+	DatabaseWriteRetries uint64 // The number of times a queued database write was retried because the database couldn't be reached
+
+	Actions map[string]ActionStats // How long each client action takes to handle, by the action's name. CustomClientActions go by the name you gave them.
+}
+
+// ActionStats are how long a client action has taken to handle, as a histogram.
+type ActionStats struct {
+	Count   uint64         // The number of times the action was handled
+	Total   time.Duration  // The time spent handling the action
+	Buckets []ActionBucket // The number of times handling the action took up to each UpperBound, from the shortest UpperBound to the longest
+}
+
+// ActionBucket is a bucket of an ActionStats histogram.
+type ActionBucket struct {
+	UpperBound time.Duration
+	Count      uint64 // The number of times handling the action took up to UpperBound, including the shorter buckets
+}
+
+var (
+	messageRate   rateCounter
+	loginFailures uint64
+
+	// CLIENT ACTION NAME -> *actionHistogram
+	actionTimes sync.Map
+
+	actionTimeBounds = []time.Duration{500 * time.Microsecond, time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond,
+		25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+		time.Second, 2500 * time.Millisecond, 5 * time.Second}
+)
+
+// Stats gets a snapshot of the server's metrics. It doesn't lock anything the server uses to handle clients, so it's safe to
+// call as often as you like.
+func Stats() ServerStats {
+	stats := ServerStats{
+		Connections: ClientsConnected(),
+		Users:       core.UserCount(),
+		Guests:      core.GuestCount(),
+		Registered:  core.RegisteredCount(),
+
+		Rooms:       core.RoomCount(),
+		RoomsByType: make(map[string]int),
+
+		MessagesReceived:  atomic.LoadUint64(&messageRate.total),
+		MessagesPerSecond: messageRate.lastSecond(time.Now()),
+		LoginFailures:     atomic.LoadUint64(&loginFailures),
+
+		Actions: make(map[string]ActionStats),
+	}
+	stats.OutboundQueued, stats.SlowClientsDropped = helpers.OutboundStats()
+	stats.WriteTimeouts = helpers.WriteTimeouts()
+	stats.DatabaseWritesQueued, stats.DatabaseWriteErrors, stats.DatabaseWriteRetries = database.WriteQueueStats()
+	for name, roomType := range core.GetRoomTypes() {
+		stats.RoomsByType[name] = roomType.RoomCount()
+	}
+	actionTimes.Range(func(name, hist interface{}) bool {
+		stats.Actions[name.(string)] = hist.(*actionHistogram).snapshot()
+		return true
+	})
+	return stats
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   COUNTERS   //////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// rateCounter counts events, and how many happened in the last full second.
+type rateCounter struct {
+	total   uint64
+	second  int64 // THE UNIX SECOND current IS COUNTING
+	current uint64
+	last    uint64 // THE COUNT OF THE SECOND BEFORE second
+}
+
+func (c *rateCounter) add(now time.Time) {
+	atomic.AddUint64(&c.total, 1)
+	sec := now.Unix()
+	if old := atomic.LoadInt64(&c.second); sec > old && atomic.CompareAndSwapInt64(&c.second, old, sec) {
+		count := atomic.SwapUint64(&c.current, 0)
+		if sec != old+1 {
+			// NOTHING HAPPENED IN THE LAST SECOND
+			count = 0
+		}
+		atomic.StoreUint64(&c.last, count)
+	}
+	atomic.AddUint64(&c.current, 1)
+}
+
+func (c *rateCounter) lastSecond(now time.Time) uint64 {
+	switch now.Unix() - atomic.LoadInt64(&c.second) {
+	case 0:
+		return atomic.LoadUint64(&c.last)
+	case 1:
+		return atomic.LoadUint64(&c.current)
+	}
+	return 0
+}
+
+// actionHistogram counts how long a client action takes to handle in the actionTimeBounds buckets. Each bucket only counts the
+// times that weren't in a shorter bucket.
+type actionHistogram struct {
+	count   uint64
+	total   int64 // NANOSECONDS
+	buckets []uint64
+}
+
+// observeAction adds how long handling a client action took to its histogram.
+func observeAction(name string, took time.Duration) {
+	stored, ok := actionTimes.Load(name)
+	if !ok {
+		stored, _ = actionTimes.LoadOrStore(name, &actionHistogram{buckets: make([]uint64, len(actionTimeBounds))})
+	}
+	hist := stored.(*actionHistogram)
+	atomic.AddUint64(&hist.count, 1)
+	atomic.AddInt64(&hist.total, int64(took))
+	for i, bound := range actionTimeBounds {
+		if took <= bound {
+			atomic.AddUint64(&hist.buckets[i], 1)
+			break
+		}
+	}
+}
+
+func (h *actionHistogram) snapshot() ActionStats {
+	stats := ActionStats{Count: atomic.LoadUint64(&h.count), Total: time.Duration(atomic.LoadInt64(&h.total)),
+		Buckets: make([]ActionBucket, len(actionTimeBounds))}
+	var cumulative uint64
+	for i, bound := range actionTimeBounds {
+		cumulative += atomic.LoadUint64(&h.buckets[i])
+		stats.Buckets[i] = ActionBucket{UpperBound: bound, Count: cumulative}
+	}
+	return stats
+}
+
+// actionStatName gets the name a client action's handling time is counted under. Actions that don't exist are all counted
+// as "unknown", so clients can't make new histograms.
+func actionStatName(action clientAction) string {
+	if name := customActionName(action); name != "" && actions.Exists(name) {
+		return name
+	} else if action.A != helpers.ClientActionCustomAction && helpers.IsClientAction(action.A) {
+		return action.A
+	}
+	return "unknown"
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   PROMETHEUS ENDPOINT   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// MetricsHandler gets the handler that serves gopher.Stats() in the Prometheus text format. The server serves it at MetricsEndpoint
+// in ServerSettings, so you only need this in handler mode (Handler in ServerSettings) to mount it on your own http.ServeMux or router.
+func MetricsHandler() http.HandlerFunc {
+	return metricsHandler
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w, Stats())
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetrics writes stats in the Prometheus text format.
+func writeMetrics(out io.Writer, stats ServerStats) error {
+	w := bufio.NewWriter(out)
+	metric := func(name string, kind string, help string) {
+		w.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + kind + "\n")
+	}
+	sample := func(name string, labels string, value string) {
+		if labels != "" {
+			name += "{" + labels + "}"
+		}
+		w.WriteString(name + " " + value + "\n")
+	}
+	label := func(key string, value string) string {
+		return key + "=\"" + labelEscaper.Replace(value) + "\""
+	}
+
+	metric("gopher_connections", "gauge", "The number of clients connected, including the ones not logged in.")
+	sample("gopher_connections", "", strconv.Itoa(stats.Connections))
+	metric("gopher_users", "gauge", "The number of Users logged in, including guests.")
+	sample("gopher_users", "", strconv.Itoa(stats.Users))
+	metric("gopher_guests", "gauge", "The number of Users logged in as guests.")
+	sample("gopher_guests", "", strconv.Itoa(stats.Guests))
+	metric("gopher_registered_users", "gauge", "The number of Users logged in that aren't guests.")
+	sample("gopher_registered_users", "", strconv.Itoa(stats.Registered))
+
+	metric("gopher_rooms", "gauge", "The number of Rooms of each RoomType.")
+	for _, roomType := range sortedKeys(stats.RoomsByType) {
+		sample("gopher_rooms", label("type", roomType), strconv.Itoa(stats.RoomsByType[roomType]))
+	}
+
+	metric("gopher_messages_received_total", "counter", "The number of messages received from clients.")
+	sample("gopher_messages_received_total", "", strconv.FormatUint(stats.MessagesReceived, 10))
+	metric("gopher_messages_per_second", "gauge", "The number of messages received from clients in the last full second.")
+	sample("gopher_messages_per_second", "", strconv.FormatUint(stats.MessagesPerSecond, 10))
+	metric("gopher_login_failures_total", "counter", "The number of login client actions that failed.")
+	sample("gopher_login_failures_total", "", strconv.FormatUint(stats.LoginFailures, 10))
+
+	metric("gopher_outbound_queued", "gauge", "The number of messages waiting to be written to clients.")
+	sample("gopher_outbound_queued", "", strconv.Itoa(stats.OutboundQueued))
+	metric("gopher_slow_clients_dropped_total", "counter", "The number of clients disconnected for being too slow to receive their messages.")
+	sample("gopher_slow_clients_dropped_total", "", strconv.FormatUint(stats.SlowClientsDropped, 10))
+	metric("gopher_write_timeouts_total", "counter", "The number of clients disconnected because a write to them timed out.")
+	sample("gopher_write_timeouts_total", "", strconv.FormatUint(stats.WriteTimeouts, 10))
+
+	metric("gopher_database_writes_queued", "gauge", "The number of database writes waiting to run in the background.")
+	sample("gopher_database_writes_queued", "", strconv.Itoa(stats.DatabaseWritesQueued))
+	metric("gopher_database_write_errors_total", "counter", "The number of queued database writes that failed for good.")
+	sample("gopher_database_write_errors_total", "", strconv.FormatUint(stats.DatabaseWriteErrors, 10))
+	metric("gopher_database_write_retries_total", "counter", "The number of times a queued database write was retried.")
+	sample("gopher_database_write_retries_total", "", strconv.FormatUint(stats.DatabaseWriteRetries, 10))
+
+	metric("gopher_action_duration_seconds", "histogram", "How long client actions take to handle.")
+	actionNames := make([]string, 0, len(stats.Actions))
+	for name := range stats.Actions {
+		actionNames = append(actionNames, name)
+	}
+	sort.Strings(actionNames)
+	for _, name := range actionNames {
+		action := stats.Actions[name]
+		actionLabel := label("action", name)
+		for _, bucket := range action.Buckets {
+			sample("gopher_action_duration_seconds_bucket", actionLabel+","+label("le", formatSeconds(bucket.UpperBound)),
+				strconv.FormatUint(bucket.Count, 10))
+		}
+		sample("gopher_action_duration_seconds_bucket", actionLabel+`,le="+Inf"`, strconv.FormatUint(action.Count, 10))
+		sample("gopher_action_duration_seconds_sum", actionLabel, formatSeconds(action.Total))
+		sample("gopher_action_duration_seconds_count", actionLabel, strconv.FormatUint(action.Count, 10))
+	}
+
+	//
+	return w.Flush()
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'g', -1, 64)
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,124 @@
+package gopher
+
+import (
+	"bytes"
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateCounter(t *testing.T) {
+	var counter rateCounter
+	now := time.Unix(1000, 0)
+	for i := 0; i < 3; i++ {
+		counter.add(now)
+	}
+	if got := counter.lastSecond(now); got != 0 {
+		t.Error("The current second should not count yet, got", got)
+	} else if got := counter.lastSecond(now.Add(time.Second)); got != 3 {
+		t.Error("Expected 3 messages in the last second, got", got)
+	}
+	counter.add(now.Add(time.Second))
+	if got := counter.lastSecond(now.Add(time.Second)); got != 3 {
+		t.Error("Expected 3 messages in the last second, got", got)
+	} else if got := counter.lastSecond(now.Add(5 * time.Second)); got != 0 {
+		t.Error("Expected no messages after going quiet, got", got)
+	}
+	counter.add(now.Add(5 * time.Second))
+	if got := counter.lastSecond(now.Add(5 * time.Second)); got != 0 {
+		t.Error("A gap of quiet seconds should count as 0, got", got)
+	} else if counter.total != 5 {
+		t.Error("Expected 5 messages in total, got", counter.total)
+	}
+}
+
+func TestWriteMetrics(t *testing.T) {
+	actionTimes.Delete("metricsTest")
+	observeAction("metricsTest", 2*time.Millisecond)
+	observeAction("metricsTest", 20*time.Second)
+	stats := Stats()
+	action, ok := stats.Actions["metricsTest"]
+	if !ok {
+		t.Fatal("Expected stats for the metricsTest action")
+	} else if action.Count != 2 || action.Total != 20*time.Second+2*time.Millisecond {
+		t.Error("Unexpected action stats", action)
+	}
+	for _, bucket := range action.Buckets {
+		if bucket.UpperBound >= 5*time.Millisecond && bucket.Count != 1 {
+			t.Error("Buckets from 5ms up should count the 2ms action once, got", bucket)
+		} else if bucket.UpperBound < 2*time.Millisecond && bucket.Count != 0 {
+			t.Error("Buckets under 2ms should be empty, got", bucket)
+		}
+	}
+
+	stats.RoomsByType = map[string]int{`say "hi"`: 2}
+	var out bytes.Buffer
+	if err := writeMetrics(&out, stats); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE gopher_action_duration_seconds histogram",
+		`gopher_action_duration_seconds_bucket{action="metricsTest",le="0.005"} 1`,
+		`gopher_action_duration_seconds_bucket{action="metricsTest",le="+Inf"} 2`,
+		`gopher_action_duration_seconds_sum{action="metricsTest"} 20.002`,
+		`gopher_action_duration_seconds_count{action="metricsTest"} 2`,
+		`gopher_rooms{type="say \"hi\""} 2`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expected the metrics to have the line %q, got:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestActionStats(t *testing.T) {
+	oldSettings := settings
+	defer func() {
+		settings = oldSettings
+		clientDisconnectCallback = nil
+	}()
+	disconnected := make(chan bool, 1)
+	clientDisconnectCallback = func(string, bool, error) {
+		disconnected <- true
+	}
+	settings = &ServerSettings{HostName: "localhost"}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := Stats()
+	if before.Connections < 1 {
+		t.Error("Expected the test client to be counted as a connection")
+	}
+	client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionLogin, "P": 1})
+	client.WriteJSON(map[string]interface{}{"A": "aMadeUpAction"})
+	var message map[string]interface{}
+	client.ReadJSON(&message)
+	client.ReadJSON(&message)
+
+	after := Stats()
+	if after.MessagesReceived != before.MessagesReceived+2 {
+		t.Error("Expected 2 more messages received, got", after.MessagesReceived-before.MessagesReceived)
+	} else if after.LoginFailures != before.LoginFailures+1 {
+		t.Error("Expected the failed login to be counted")
+	} else if after.Actions[helpers.ClientActionLogin].Count != before.Actions[helpers.ClientActionLogin].Count+1 {
+		t.Error("Expected the login action's handling time to be counted")
+	} else if _, ok := after.Actions["aMadeUpAction"]; ok {
+		t.Error("Actions that don't exist should not get their own stats")
+	}
+
+	// Wait for the disconnect, so it doesn't run another test's callback
+	client.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second * 2):
+		t.Error("The client was not disconnected")
+	}
+}
@@ -0,0 +1,291 @@
+package gopher
+
+import (
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/actions"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	errorRateLimited = "Too many requests"
+
+	defaultLoginAttemptWindow = time.Minute
+	defaultVoiceByteRate      = 32 * 1024
+	rateLimitStrikeWindow     = time.Minute
+)
+
+var (
+	errRateLimited = errors.New("Client sent too many rate limited actions")
+
+	// LOGIN ATTEMPTS ARE ALSO LIMITED PER IP, SO A CLIENT CAN'T RECONNECT FOR A NEW BUCKET
+	loginIPLimits ipBuckets = ipBuckets{buckets: make(map[string]*tokenBucket)}
+
+	// THE PARSED TrustedProxies FROM ServerSettings
+	trustedProxies []*net.IPNet
+
+	// CLIENT ACTIONS THAT CHECK OR MAKE PASSWORDS, OR LOG IN
+	loginActions = map[string]bool{
+		helpers.ClientActionLogin:          true,
+		helpers.ClientActionGuestLogin:     true,
+		helpers.ClientActionSignup:         true,
+		helpers.ClientActionChangePassword: true,
+		helpers.ClientActionChangeName:     true,
+		helpers.ClientActionDeleteAccount:  true,
+		helpers.ClientActionAdminLogin:     true,
+	}
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   TOKEN BUCKETS   /////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// tokenBucket holds up to limit tokens, and gets limit tokens back every per. A new tokenBucket is full.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token from the bucket. When the bucket is empty, it returns false and how long until the next token.
+func (b *tokenBucket) take(limit int, per time.Duration, now time.Time) (bool, time.Duration) {
+	rate := b.refill(limit, per, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration(math.Ceil((1 - b.tokens) / rate))
+}
+
+// spend takes amount tokens from the bucket at once, or none and returns false when it doesn't have that many.
+func (b *tokenBucket) spend(amount int, limit int, per time.Duration, now time.Time) bool {
+	b.refill(limit, per, now)
+	if b.tokens >= float64(amount) {
+		b.tokens -= float64(amount)
+		return true
+	}
+	return false
+}
+
+// refill gives the bucket back the tokens it got since it was last used, and returns its rate in tokens per nanosecond.
+func (b *tokenBucket) refill(limit int, per time.Duration, now time.Time) float64 {
+	rate := float64(limit) / float64(per)
+	if b.last.IsZero() {
+		b.tokens = float64(limit)
+	} else if b.tokens += float64(now.Sub(b.last)) * rate; b.tokens > float64(limit) {
+		b.tokens = float64(limit)
+	}
+	b.last = now
+	return rate
+}
+
+// full returns true if the bucket has refilled, and can be forgotten.
+func (b *tokenBucket) full(limit int, per time.Duration, now time.Time) bool {
+	return b.tokens+float64(now.Sub(b.last))*float64(limit)/float64(per) >= float64(limit)
+}
+
+// ipBuckets are tokenBuckets shared by all the connections from each IP address.
+type ipBuckets struct {
+	mux       sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+func (l *ipBuckets) take(ip string, limit int, per time.Duration, now time.Time) (bool, time.Duration) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	// FORGET THE IPs THAT HAVE STOPPED SENDING
+	if now.Sub(l.lastPrune) > per {
+		for key, bucket := range l.buckets {
+			if bucket.full(limit, per, now) {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastPrune = now
+	}
+	bucket, ok := l.buckets[ip]
+	if !ok {
+		bucket = &tokenBucket{}
+		l.buckets[ip] = bucket
+	}
+	return bucket.take(limit, per, now)
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CONNECTION RATE LIMITS   ////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// connLimits are the rate limits of one connection. They are only used by the connection's clientActionListener.
+type connLimits struct {
+	ip      string
+	actions tokenBucket
+	logins  tokenBucket
+	voice   tokenBucket // IN BYTES
+	custom  map[string]*tokenBucket
+
+	strikes      int
+	strikesStart time.Time
+}
+
+func newConnLimits(ip string) *connLimits {
+	return &connLimits{ip: ip, custom: make(map[string]*tokenBucket)}
+}
+
+// check takes a token for a client action. It returns false and how long the client should wait when the action is rate limited.
+func (c *connLimits) check(action clientAction, now time.Time) (bool, time.Duration) {
+	// CustomClientActions CAN OVERRIDE ActionRateLimit
+	if name := customActionName(action); name != "" {
+		if limit, per, ok := actions.RateLimit(name); ok {
+			bucket, ok := c.custom[name]
+			if !ok {
+				bucket = &tokenBucket{}
+				c.custom[name] = bucket
+			}
+			if ok, retry := bucket.take(limit, per, now); !ok {
+				return false, retry
+			}
+		} else if ok, retry := c.takeAction(now); !ok {
+			return false, retry
+		}
+	} else if ok, retry := c.takeAction(now); !ok {
+		return false, retry
+	}
+
+	// ADMIN LOGIN ATTEMPTS ARE ALWAYS LIMITED
+	if action.A == helpers.ClientActionAdminLogin {
+		if ok, retry := adminLoginLimits.take(c.ip, adminLoginLimit, adminLoginWindow, now); !ok {
+			helpers.Log().Warn("Admin logins rate limited", "ip", c.ip)
+			return false, retry
+		}
+	}
+
+	// LOGIN ATTEMPTS
+	if loginActions[action.A] && (*settings).LoginAttemptLimit > 0 {
+		window := (*settings).LoginAttemptWindow
+		if window <= 0 {
+			window = defaultLoginAttemptWindow
+		}
+		if ok, retry := c.logins.take((*settings).LoginAttemptLimit, window, now); !ok {
+			return false, retry
+		} else if ok, retry := loginIPLimits.take(c.ip, (*settings).LoginAttemptLimit, window, now); !ok {
+			return false, retry
+		}
+	}
+
+	//
+	return true, 0
+}
+
+// checkVoice takes the bytes of a voice frame from the connection's VoiceByteRate. It returns false when the frame should be dropped.
+func (c *connLimits) checkVoice(frameSize int, now time.Time) bool {
+	rate := (*settings).VoiceByteRate
+	if rate == 0 {
+		rate = defaultVoiceByteRate
+	}
+	return c.voice.spend(frameSize, rate, time.Second, now)
+}
+
+func (c *connLimits) takeAction(now time.Time) (bool, time.Duration) {
+	if (*settings).ActionRateLimit <= 0 {
+		return true, 0
+	}
+	return c.actions.take((*settings).ActionRateLimit, time.Second, now)
+}
+
+// strike counts a rate limited action, and returns true when the connection has been rate limited more than RateLimitDisconnect
+// times in the last minute and should be disconnected.
+func (c *connLimits) strike(now time.Time) bool {
+	if (*settings).RateLimitDisconnect <= 0 {
+		return false
+	}
+	if now.Sub(c.strikesStart) > rateLimitStrikeWindow {
+		c.strikes = 0
+		c.strikesStart = now
+	}
+	c.strikes++
+	return c.strikes > (*settings).RateLimitDisconnect
+}
+
+// customActionName gets the CustomClientAction a client action calls, if any.
+func customActionName(action clientAction) string {
+	if action.A == helpers.ClientActionCustomAction {
+		if pMap, ok := action.P.(map[string]interface{}); ok {
+			name, _ := pMap["a"].(string)
+			return name
+		}
+		return ""
+	} else if !helpers.IsClientAction(action.A) && actions.Exists(action.A) {
+		return action.A
+	}
+	return ""
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   CLIENT IP ADDRESSES   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// parseTrustedProxies parses the IP addresses and CIDR ranges in TrustedProxies.
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		if strings.Contains(proxy, "/") {
+			_, ipNet, err := net.ParseCIDR(proxy)
+			if err != nil {
+				return nil, errors.New("Invalid TrustedProxies entry '" + proxy + "'")
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil, errors.New("Invalid TrustedProxies entry '" + proxy + "'")
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP gets the IP address of the client that made a request. The X-Forwarded-For header is only used when the request came from
+// one of the TrustedProxies in ServerSettings, and then the client's IP is the last address in it that isn't a trusted proxy.
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !isTrustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		ip = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
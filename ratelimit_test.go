@@ -0,0 +1,209 @@
+package gopher
+
+import (
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/actions"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	var bucket tokenBucket
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if ok, _ := bucket.take(3, time.Second, now); !ok {
+			t.Fatal("A new bucket should be full")
+		}
+	}
+	ok, retry := bucket.take(3, time.Second, now)
+	if ok {
+		t.Fatal("An empty bucket should not give tokens")
+	} else if retry <= 0 || retry > time.Second/3+time.Millisecond {
+		t.Error("Expected to retry within a third of a second, got", retry)
+	}
+	if ok, _ := bucket.take(3, time.Second, now.Add(retry)); !ok {
+		t.Error("The bucket should have a token after the retry hint")
+	}
+	if !bucket.full(3, time.Second, now.Add(2*time.Second)) {
+		t.Error("The bucket should refill")
+	}
+
+	var bytes tokenBucket
+	if !bytes.spend(8, 10, time.Second, now) {
+		t.Error("A new bucket should have room for 8 of its 10 tokens")
+	} else if bytes.spend(8, 10, time.Second, now) {
+		t.Error("A bucket should not spend more tokens than it has")
+	} else if !bytes.spend(2, 10, time.Second, now) {
+		t.Error("A refused spend should not take any tokens")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer func() { trustedProxies = nil }()
+	var err error
+	if trustedProxies, err = parseTrustedProxies([]string{"10.0.0.0/8", "192.168.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseTrustedProxies([]string{"balancer"}); err == nil {
+		t.Error("parseTrustedProxies() should fail for an entry that isn't an IP or CIDR range")
+	}
+
+	tests := []struct {
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{"203.0.113.7:5000", "", "203.0.113.7"},
+		{"203.0.113.7:5000", "1.2.3.4", "203.0.113.7"},                // not from a trusted proxy
+		{"10.1.2.3:5000", "1.2.3.4", "1.2.3.4"},                       // from the load balancer
+		{"10.1.2.3:5000", "6.6.6.6, 1.2.3.4, 192.168.1.1", "1.2.3.4"}, // spoofed first hop and a second proxy
+		{"10.1.2.3:5000", "", "10.1.2.3"},
+		{"10.1.2.3:5000", "garbage, 1.2.3.4", "1.2.3.4"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.RemoteAddr = test.remote
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if got := clientIP(r); got != test.want {
+			t.Errorf("clientIP(%q, %q) = %q, want %q", test.remote, test.forwarded, got, test.want)
+		}
+	}
+}
+
+func TestConnLimits(t *testing.T) {
+	oldSettings := settings
+	defer func() {
+		settings = oldSettings
+		loginIPLimits = ipBuckets{buckets: make(map[string]*tokenBucket)}
+	}()
+	settings = &ServerSettings{LoginAttemptLimit: 2, ActionRateLimit: 5, RateLimitDisconnect: 2}
+	now := time.Now()
+
+	// Login attempts are limited per IP across connections
+	login := clientAction{A: helpers.ClientActionLogin}
+	first, second := newConnLimits("1.2.3.4"), newConnLimits("1.2.3.4")
+	if ok, _ := first.check(login, now); !ok {
+		t.Error("The first login attempt should be allowed")
+	}
+	if ok, _ := second.check(login, now); !ok {
+		t.Error("The second login attempt should be allowed")
+	}
+	if ok, retry := newConnLimits("1.2.3.4").check(login, now); ok {
+		t.Error("A new connection from the same IP should not get new login attempts")
+	} else if retry <= 0 || retry > 30*time.Second {
+		t.Error("Expected to retry within 30 seconds, got", retry)
+	}
+	if ok, _ := newConnLimits("5.6.7.8").check(login, now); !ok {
+		t.Error("Other IPs should have their own login attempts")
+	}
+
+	// Other actions use ActionRateLimit
+	conn := newConnLimits("1.2.3.4")
+	chat := clientAction{A: helpers.ClientActionChatMessage}
+	for i := 0; i < 5; i++ {
+		if ok, _ := conn.check(chat, now); !ok {
+			t.Fatal("Action", i+1, "should be allowed")
+		}
+	}
+	if ok, _ := conn.check(chat, now); ok {
+		t.Error("Actions past ActionRateLimit should be rate limited")
+	}
+	if conn.strike(now) || conn.strike(now) {
+		t.Error("The client should not be disconnected before passing RateLimitDisconnect")
+	} else if !conn.strike(now) {
+		t.Error("The client should be disconnected after passing RateLimitDisconnect")
+	} else if conn.strike(now.Add(2 * rateLimitStrikeWindow)) {
+		t.Error("Strikes should be forgotten after a minute")
+	}
+}
+
+func TestCustomActionRateLimit(t *testing.T) {
+	oldSettings := settings
+	defer func() { settings = oldSettings }()
+	settings = &ServerSettings{ActionRateLimit: 1}
+
+	if !actions.Exists("rateLimitedMove") {
+		if err := actions.New("rateLimitedMove", actions.DataTypeNil, func(interface{}, *actions.Client) {}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := actions.SetRateLimit("rateLimitedMove", 3, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := actions.SetRateLimit("notAnAction", 3, time.Second); err == nil {
+		t.Error("actions.SetRateLimit() should fail for an action that doesn't exist")
+	}
+
+	now := time.Now()
+	conn := newConnLimits("1.2.3.4")
+	byName := clientAction{A: "rateLimitedMove"}
+	custom := clientAction{A: helpers.ClientActionCustomAction, P: map[string]interface{}{"a": "rateLimitedMove"}}
+	for _, action := range []clientAction{byName, custom, byName} {
+		if ok, _ := conn.check(action, now); !ok {
+			t.Fatal("The action's own rate limit should be used")
+		}
+	}
+	if ok, _ := conn.check(custom, now); ok {
+		t.Error("Calls past the action's rate limit should be rate limited")
+	}
+	if ok, _ := conn.check(clientAction{A: helpers.ClientActionChatMessage}, now); !ok {
+		t.Error("The action's own rate limit should not count towards ActionRateLimit")
+	}
+}
+
+func TestRateLimitedClient(t *testing.T) {
+	waitForDisconnects(t)
+	oldSettings := settings
+	defer func() {
+		waitForDisconnects(t)
+		settings = oldSettings
+		clientDisconnectCallback = nil
+	}()
+	disconnects := make(chan error, 1)
+	clientDisconnectCallback = func(userName string, wasLoggedIn bool, err error) {
+		disconnects <- err
+	}
+	settings = &ServerSettings{HostName: "localhost", ActionRateLimit: 1, RateLimitDisconnect: 1}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The first action is answered as usual, the second is rate limited
+	for i := 0; i < 2; i++ {
+		client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionChangeStatus, "P": 1})
+	}
+	var message map[string]map[string]interface{}
+	client.ReadJSON(&message)
+	if e, _ := message[helpers.ServerActionClientActionResponse]["e"].(map[string]interface{}); e["id"] != float64(helpers.ErrorGopherNotLoggedIn) {
+		t.Error("Expected a not logged in error for the first action, got", message)
+	}
+	client.ReadJSON(&message)
+	e, _ := message[helpers.ServerActionClientActionResponse]["e"].(map[string]interface{})
+	if e["id"] != float64(helpers.ErrorRateLimited) {
+		t.Error("Expected a rate limited error for the second action, got", message)
+	} else if retry, _ := e["r"].(float64); retry <= 0 || retry > 1000 {
+		t.Error("Expected a retry-after hint of up to a second, got", e["r"])
+	}
+
+	// Going past RateLimitDisconnect disconnects the client
+	client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionChangeStatus, "P": 1})
+	select {
+	case err := <-disconnects:
+		if err != errRateLimited {
+			t.Error("Expected the client to be disconnected for being rate limited, got", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Error("The rate limited client was not disconnected")
+	}
+}
@@ -0,0 +1,248 @@
+package gopher
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// THE VERSION OF THE SNAPSHOT FORMAT. FILES FROM BEFORE IT WAS VERSIONED ARE VERSION 0, AND READ THE SAME WAY.
+	recoveryVersion = 4
+
+	recoveryPrefix    = "Gopher Recovery"
+	recoveryExtension = ".grf"
+	recoveryFile      = recoveryPrefix + recoveryExtension
+	recoveryTemp      = recoveryPrefix + " *.tmp"
+
+	defaultRecoveryInterval = time.Minute
+)
+
+type serverRestore struct {
+	V int                               // version
+	S int64                             // when the snapshot was saved, in unix seconds
+	R map[string]core.RoomRecoveryState // rooms
+	U []core.UserRecoveryState          // users, since version 2
+}
+
+var (
+	snapshotMux sync.Mutex // ONLY ONE SNAPSHOT IS WRITTEN AT A TIME
+
+	snapshotsStop chan bool
+	snapshotsOnce sync.Once
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   Saving snapshots   //////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// SnapshotNow saves a snapshot of all the Rooms, their settings, variables, synced state, invite lists, and repeating timers (and the logged in Users when
+// SessionResumeWindow is set) to the RecoveryLocation in ServerSettings right away. The server already saves one every RecoveryInterval and when it shuts down, so you only need this
+// before something risky, like an update. Requires EnableRecovery in ServerSettings.
+func SnapshotNow() error {
+	if settings == nil || !settings.EnableRecovery {
+		return errors.New("EnableRecovery in ServerSettings is not enabled")
+	}
+	return writeState(getState(), settings.RecoveryLocation)
+}
+
+func saveState() {
+	helpers.Log().Info("Saving server state...")
+	saveErr := writeState(getState(), settings.RecoveryLocation)
+	if saveErr != nil {
+		helpers.Log().Error("Error saving state", "error", saveErr)
+		return
+	}
+	helpers.Log().Info("Save state successful")
+}
+
+// writeState writes a snapshot to a temporary file in saveFolder, then renames it over the previous snapshot. A crash while
+// writing can only leave the temporary file behind, never a half written snapshot.
+func writeState(stateObj serverRestore, saveFolder string) error {
+	state, err := json.Marshal(stateObj)
+	if err != nil {
+		return err
+	}
+	snapshotMux.Lock()
+	defer snapshotMux.Unlock()
+	temp, err := ioutil.TempFile(saveFolder, recoveryTemp)
+	if err != nil {
+		return err
+	}
+	if _, err = temp.Write(state); err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp.Name(), filepath.Join(saveFolder, recoveryFile))
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+
+	// MAKE THE RENAME ITSELF DURABLE WHERE THE OS ALLOWS SYNCING A FOLDER
+	if dir, dirErr := os.Open(saveFolder); dirErr == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	//
+	return nil
+}
+
+func getState() serverRestore {
+	return serverRestore{
+		V: recoveryVersion,
+		S: time.Now().Unix(),
+		R: core.GetRoomsState(),
+		U: core.GetUsersState(),
+	}
+}
+
+// startSnapshots saves a snapshot every RecoveryInterval until stopSnapshots() is called.
+func startSnapshots() {
+	interval := settings.RecoveryInterval
+	if interval <= 0 {
+		interval = defaultRecoveryInterval
+	}
+	snapshotsStop = make(chan bool)
+	go func(stop chan bool) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := writeState(getState(), settings.RecoveryLocation); err != nil {
+					helpers.Log().Error("Error saving snapshot", "error", err)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}(snapshotsStop)
+}
+
+func stopSnapshots() {
+	snapshotsOnce.Do(func() {
+		if snapshotsStop != nil {
+			close(snapshotsStop)
+		}
+	})
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   Recovery   //////////////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func recoverState() {
+	helpers.Log().Info("Recovering previous state...")
+
+	recovery, fileName, err := readNewestState(settings.RecoveryLocation)
+	if err != nil {
+		helpers.Log().Error("Error recovering state", "error", err)
+		return
+	} else if fileName == "" {
+		helpers.Log().Info("No recovery files to restore from")
+		return
+	} else if recovery.V > recoveryVersion {
+		helpers.Log().Warn("Recovery file is from a newer version of the server, only restoring what this version knows about",
+			"file", fileName, "version", recovery.V)
+	}
+
+	// Recover rooms
+	restored := 0
+	for name, val := range recovery.R {
+		room, roomErr := core.NewRoom(name, val.T, val.P, val.M, val.O)
+		if roomErr != nil {
+			helpers.Log().Error("Error recovering room", "room", name, "error", roomErr)
+			continue
+		}
+		for _, userName := range val.I {
+			invErr := room.AddInvite(userName)
+			if invErr != nil {
+				helpers.Log().Error("Error recovering room invite", "room", name, "user", userName, "error", invErr)
+			}
+		}
+		if len(val.V) > 0 {
+			if varsErr := room.SetVariables(val.V); varsErr != nil {
+				helpers.Log().Error("Error recovering room variables", "room", name, "error", varsErr)
+			}
+		}
+		if val.SQ > 0 {
+			if syncErr := room.RestoreSyncedState(val.SS, val.SQ); syncErr != nil {
+				helpers.Log().Error("Error recovering room synced state", "room", name, "error", syncErr)
+			}
+		}
+		for timerName, timer := range val.TM {
+			if timerErr := room.RestoreTimer(timerName, timer); timerErr != nil {
+				helpers.Log().Error("Error recovering room timer", "room", name, "timer", timerName, "error", timerErr)
+			}
+		}
+		restored++
+	}
+
+	if len(recovery.R) == 0 {
+		helpers.Log().Info("No rooms to restore!")
+	} else {
+		helpers.Log().Info("Restored "+strconv.Itoa(restored)+" of "+strconv.Itoa(len(recovery.R))+" rooms", "file", fileName)
+	}
+
+	// Wait for Users to resume their sessions
+	if resumable := core.RestoreSessions(recovery.U); resumable > 0 {
+		helpers.Log().Info("Waiting for "+strconv.Itoa(resumable)+" users to resume their sessions", "window", settings.SessionResumeWindow)
+	}
+
+	//
+	helpers.Log().Info("State recovery successful")
+}
+
+// readNewestState reads the newest recovery file in folder that isn't damaged. Older files are only read when the newer ones
+// can't be. Returns an empty file name when there are no recovery files. Temporary files left by a crash are removed.
+func readNewestState(folder string) (serverRestore, string, error) {
+	files, err := ioutil.ReadDir(folder)
+	if err != nil {
+		return serverRestore{}, "", err
+	}
+	var candidates []os.FileInfo
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), recoveryPrefix) {
+			continue
+		} else if strings.HasSuffix(f.Name(), ".tmp") {
+			os.Remove(filepath.Join(folder, f.Name()))
+		} else if strings.HasSuffix(f.Name(), recoveryExtension) {
+			candidates = append(candidates, f)
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].ModTime().After(candidates[j].ModTime())
+	})
+
+	for _, f := range candidates {
+		data, readErr := ioutil.ReadFile(filepath.Join(folder, f.Name()))
+		if readErr != nil {
+			helpers.Log().Warn("Skipping unreadable recovery file", "file", f.Name(), "error", readErr)
+			continue
+		}
+		var recovery serverRestore
+		if jsonErr := json.Unmarshal(data, &recovery); jsonErr != nil {
+			helpers.Log().Warn("Skipping damaged recovery file", "file", f.Name(), "error", jsonErr)
+			continue
+		}
+		return recovery, f.Name(), nil
+	}
+
+	//
+	return serverRestore{}, "", nil
+}
@@ -0,0 +1,74 @@
+package gopher
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteAndReadState(t *testing.T) {
+	folder := t.TempDir()
+	state := serverRestore{V: recoveryVersion, S: time.Now().Unix(), R: map[string]core.RoomRecoveryState{
+		"lobby": {T: "lobby", O: "server", M: 10, I: []string{"gopher"}, V: map[string]interface{}{"round": 3.0}},
+	}}
+	if err := writeState(state, folder); err != nil {
+		t.Fatal(err)
+	}
+	// Writing again replaces the snapshot instead of adding a file
+	if err := writeState(state, folder); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(folder); len(files) != 1 || files[0].Name() != recoveryFile {
+		t.Fatal("Expected only the snapshot file in the recovery folder, got", files)
+	}
+
+	recovery, fileName, err := readNewestState(folder)
+	if err != nil {
+		t.Fatal(err)
+	} else if fileName != recoveryFile || recovery.V != recoveryVersion {
+		t.Error("Expected to read the snapshot, got", fileName, "version", recovery.V)
+	} else if room := recovery.R["lobby"]; room.M != 10 || len(room.I) != 1 || room.V["round"] != 3.0 {
+		t.Error("The snapshot's room did not match what was saved", room)
+	}
+}
+
+func TestReadNewestState(t *testing.T) {
+	folder := t.TempDir()
+	write := func(name string, data string, age time.Duration) {
+		path := filepath.Join(folder, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(-age)
+		os.Chtimes(path, modTime, modTime)
+	}
+	write("Gopher Recovery - 2019-01-01 00-00-00.grf", `{"R":{"old":{"T":"lobby"}}}`, time.Hour)
+	write("Gopher Recovery - 2019-01-02 00-00-00.grf", `{"R":{"legacy":{"T":"lobby"}}}`, time.Minute)
+	write(recoveryFile, `{"V":1,"R":{"cut off`, 0)
+	write("Gopher Recovery 12345.tmp", `{"V":1`, 0)
+	write("notes.txt", "not a recovery file", 0)
+
+	// The damaged newest file is skipped for the newest one that can be read, which doesn't have a version
+	recovery, fileName, err := readNewestState(folder)
+	if err != nil {
+		t.Fatal(err)
+	} else if _, ok := recovery.R["legacy"]; !ok || recovery.V != 0 {
+		t.Error("Expected the newest readable file to be restored, got", fileName)
+	}
+	if _, err := os.Stat(filepath.Join(folder, "Gopher Recovery 12345.tmp")); !os.IsNotExist(err) {
+		t.Error("Temporary files left by a crash should be removed")
+	}
+
+	// Files from newer versions are still read
+	write(recoveryFile, `{"V":99,"X":"a future field","R":{"future":{"T":"lobby"}}}`, 0)
+	if recovery, _, _ = readNewestState(folder); recovery.V != 99 || recovery.R["future"].T != "lobby" {
+		t.Error("Expected a file from a newer version to be read, got", recovery)
+	}
+
+	if _, fileName, _ := readNewestState(t.TempDir()); fileName != "" {
+		t.Error("An empty folder should have no recovery file")
+	}
+}
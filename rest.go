@@ -0,0 +1,301 @@
+package gopher
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The REST API lets your other servers manage the server over HTTP, without speaking the client protocol. Every request needs the
+// RESTAuthToken from ServerSettings as a bearer token, like "Authorization: Bearer <token>", or gets a 401. Responses are JSON:
+// {"r": result} when the request worked, or {"e": {"m": message, "c": code}} with the same error codes clients get.
+//
+//	GET    /rooms               Lists every Room, like the "rooms" admin action
+//	POST   /rooms               Makes a Room from {"n": name, "t": type, "p": private, "m": maxUsers, "o": owner}
+//	DELETE /rooms/{name}        Deletes a Room, even if Users are in it
+//	GET    /users               Lists the logged in Users, like the "users" admin action
+//	POST   /users/{name}/kick   Logs a User out, with an optional {"r": reason}
+//	POST   /users/{name}/ban    Bans an account with an optional {"s": seconds, "r": reason}, and logs the User out
+//	DELETE /users/{name}/ban    Unbans an account
+//	POST   /broadcast           Sends an announcement to every client from {"t": messageType, "d": data}
+//	GET    /stats               Gets gopher.Stats()
+//
+// The paths start with RESTPathPrefix, which defaults to "/api".
+const defaultRESTPathPrefix = "/api"
+
+const (
+	errorRESTUnauthorized = "A valid bearer token is required"
+	errorRESTNotFound     = "Unknown REST endpoint"
+	errorRESTMethod       = "Method not allowed"
+	errorRESTBody         = "The request body must be a JSON object"
+
+	// THE LARGEST REQUEST BODY THE REST API READS
+	maxRESTBodySize = 1 << 20
+)
+
+var restServer *http.Server
+
+// RESTHandler gets the handler for the REST API. The server mounts it itself when RESTAuthToken is set in ServerSettings, so
+// you only need it in handler mode (Handler in ServerSettings), where you can mount it on your own http.ServeMux at
+// RESTPathPrefix. It responds with a 404 until the server has started with a RESTAuthToken.
+func RESTHandler() http.Handler {
+	return http.HandlerFunc(restHandler)
+}
+
+// restPathPrefix gets the path the REST API's endpoints start with.
+func (settings *ServerSettings) restPathPrefix() string {
+	if settings.RESTPathPrefix != "" {
+		return strings.TrimSuffix(settings.RESTPathPrefix, "/")
+	}
+	return defaultRESTPathPrefix
+}
+
+// restSeparate returns true if the REST API listens on its own port.
+func (settings *ServerSettings) restSeparate() bool {
+	return settings.RESTPort > 0 && settings.RESTPort != settings.Port
+}
+
+// restAddresses gets the addresses the REST API listens on with its own port, on the same hosts as the server.
+func (settings *ServerSettings) restAddresses() ([]string, error) {
+	addresses, err := settings.listenAddresses()
+	if err != nil {
+		return nil, err
+	}
+	port := strconv.Itoa(settings.RESTPort)
+	seen := make(map[string]bool, len(addresses))
+	restAddresses := make([]string, 0, len(addresses))
+	for _, address := range addresses {
+		host, _, splitErr := net.SplitHostPort(address)
+		if splitErr != nil {
+			return nil, splitErr
+		}
+		if address = net.JoinHostPort(host, port); !seen[address] {
+			seen[address] = true
+			restAddresses = append(restAddresses, address)
+		}
+	}
+	return restAddresses, nil
+}
+
+// startREST serves the REST API on its own port, with the same TLS as server. Returns an error if it can't listen.
+func startREST(server *http.Server, certFile string, keyFile string, tls bool) error {
+	addresses, err := settings.restAddresses()
+	if err != nil {
+		return err
+	}
+	listeners, err := listen(addresses)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle(settings.restPathPrefix()+"/", RESTHandler())
+	restServer = &http.Server{Addr: addresses[0], Handler: mux, TLSConfig: server.TLSConfig}
+	for _, listener := range listeners {
+		helpers.Log().Info("REST API listening on " + listener.Addr().String())
+		go func(listener net.Listener) {
+			var err error
+			if tls {
+				err = restServer.ServeTLS(listener, certFile, keyFile)
+			} else {
+				err = restServer.Serve(listener)
+			}
+			if err != http.ErrServerClosed {
+				helpers.Log().Error("REST API stopped", "address", listener.Addr().String(), "error", err)
+			}
+		}(listener)
+	}
+	return nil
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   HANDLING REQUESTS   /////////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// restResponse is a REST API response's status and body.
+type restResponse struct {
+	status int
+	body   map[string]interface{}
+}
+
+func restResult(result interface{}) restResponse {
+	return restResponse{status: http.StatusOK, body: map[string]interface{}{"r": result}}
+}
+
+func restError(status int, err helpers.GopherError) restResponse {
+	return restResponse{status: status, body: map[string]interface{}{"e": helpers.ErrorObject(err)}}
+}
+
+// restErrorFrom makes a response for an error from the code the endpoints share with the admin actions.
+func restErrorFrom(err error) restResponse {
+	gopherErr := helpers.ErrorFrom(err, helpers.ErrorAdminAction)
+	switch gopherErr.ID {
+	case helpers.ErrorRoomNotFound, helpers.ErrorUserNotFound:
+		return restError(http.StatusNotFound, gopherErr)
+	case helpers.ErrorRoomExists:
+		return restError(http.StatusConflict, gopherErr)
+	}
+	return restError(http.StatusBadRequest, gopherErr)
+}
+
+func restHandler(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var response restResponse
+	if settings == nil || settings.RESTAuthToken == "" {
+		response = restError(http.StatusNotFound, helpers.NewError(errorFeatureDisabled, helpers.ErrorGopherFeatureDisabled))
+	} else if token, ok := bearerToken(r); !ok || !secureEqual(token, settings.RESTAuthToken) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		response = restError(http.StatusUnauthorized, helpers.NewError(errorRESTUnauthorized, helpers.ErrorActionDenied))
+	} else {
+		response = restRoute(r)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.status)
+	json.NewEncoder(w).Encode(response.body)
+	helpers.Log().Info("REST request", "method", r.Method, "path", r.URL.Path, "status", response.status,
+		"duration", time.Since(start), "remote", r.RemoteAddr)
+}
+
+// bearerToken gets the token from a request's "Authorization: Bearer <token>" header. The scheme is case-insensitive.
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return auth[len("Bearer "):], true
+}
+
+// restRoute runs the endpoint for a request.
+func restRoute(r *http.Request) restResponse {
+	path := strings.TrimPrefix(r.URL.EscapedPath(), settings.restPathPrefix())
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range parts {
+		if unescaped, err := url.PathUnescape(part); err == nil {
+			parts[i] = unescaped
+		}
+	}
+	methods := func(allowed map[string]func() restResponse) restResponse {
+		if endpoint, ok := allowed[r.Method]; ok {
+			return endpoint()
+		}
+		return restError(http.StatusMethodNotAllowed, helpers.NewError(errorRESTMethod, helpers.ErrorGopherInvalidAction))
+	}
+
+	switch {
+	case len(parts) == 1 && parts[0] == "rooms":
+		return methods(map[string]func() restResponse{
+			http.MethodGet:  func() restResponse { return restAdminAction(adminListRooms, nil) },
+			http.MethodPost: func() restResponse { return restCreateRoom(r) },
+		})
+	case len(parts) == 2 && parts[0] == "rooms":
+		return methods(map[string]func() restResponse{
+			http.MethodDelete: func() restResponse { return restAdminAction(adminDeleteRoom, parts[1]) },
+		})
+	case len(parts) == 1 && parts[0] == "users":
+		return methods(map[string]func() restResponse{
+			http.MethodGet: func() restResponse { return restAdminAction(adminListUsers, nil) },
+		})
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "kick":
+		return methods(map[string]func() restResponse{
+			http.MethodPost: func() restResponse { return restKick(r, parts[1]) },
+		})
+	case len(parts) == 3 && parts[0] == "users" && parts[2] == "ban":
+		return methods(map[string]func() restResponse{
+			http.MethodPost:   func() restResponse { return restBan(r, parts[1]) },
+			http.MethodDelete: func() restResponse { return restAdminAction(adminUnbanUser, parts[1]) },
+		})
+	case len(parts) == 1 && parts[0] == "broadcast":
+		return methods(map[string]func() restResponse{
+			http.MethodPost: func() restResponse { return restBroadcast(r) },
+		})
+	case len(parts) == 1 && parts[0] == "stats":
+		return methods(map[string]func() restResponse{
+			http.MethodGet: func() restResponse { return restResult(Stats()) },
+		})
+	}
+	return restError(http.StatusNotFound, helpers.NewError(errorRESTNotFound, helpers.ErrorGopherInvalidAction))
+}
+
+// restBody reads a request's JSON object. An empty body is an empty object.
+func restBody(r *http.Request) (map[string]interface{}, error) {
+	body := make(map[string]interface{})
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRESTBodySize))
+	if err := decoder.Decode(&body); err != nil && err != io.EOF {
+		return nil, errors.New(errorRESTBody)
+	}
+	return body, nil
+}
+
+// restAdminAction runs an admin action for an endpoint, so the REST API and the Admin Tools work the same.
+func restAdminAction(action func(interface{}) (interface{}, error), data interface{}) restResponse {
+	result, err := action(data)
+	if err != nil {
+		return restErrorFrom(err)
+	}
+	return restResult(result)
+}
+
+func restCreateRoom(r *http.Request) restResponse {
+	body, err := restBody(r)
+	if err != nil {
+		return restError(http.StatusBadRequest, helpers.NewError(err.Error(), helpers.ErrorGopherIncorrectFormat))
+	}
+	name, nameOK := body["n"].(string)
+	roomType, typeOK := body["t"].(string)
+	private, _ := body["p"].(bool)
+	maxUsers, _ := body["m"].(float64)
+	owner, _ := body["o"].(string)
+	if !nameOK || !typeOK {
+		return restError(http.StatusBadRequest, helpers.NewError(errorIncorrectFormat, helpers.ErrorGopherIncorrectFormat))
+	}
+	room, roomErr := core.NewRoom(name, roomType, private, int(maxUsers), owner)
+	if roomErr != nil {
+		return restErrorFrom(roomErr)
+	}
+	return restResult(map[string]interface{}{
+		"n": room.Name(),
+		"t": room.Type(),
+		"p": room.IsPrivate(),
+		"o": room.Owner(),
+		"m": room.MaxUsers(),
+	})
+}
+
+func restKick(r *http.Request, userName string) restResponse {
+	body, err := restBody(r)
+	if err != nil {
+		return restError(http.StatusBadRequest, helpers.NewError(err.Error(), helpers.ErrorGopherIncorrectFormat))
+	}
+	user, userErr := core.GetUser(userName)
+	if userErr != nil {
+		return restErrorFrom(userErr)
+	}
+	reason, _ := body["r"].(string)
+	user.KickWithReason(reason)
+	return restResult(nil)
+}
+
+func restBan(r *http.Request, userName string) restResponse {
+	body, err := restBody(r)
+	if err != nil {
+		return restError(http.StatusBadRequest, helpers.NewError(err.Error(), helpers.ErrorGopherIncorrectFormat))
+	}
+	body["n"] = userName
+	return restAdminAction(adminBanUser, body)
+}
+
+func restBroadcast(r *http.Request) restResponse {
+	body, err := restBody(r)
+	if err != nil {
+		return restError(http.StatusBadRequest, helpers.NewError(err.Error(), helpers.ErrorGopherIncorrectFormat))
+	}
+	return restAdminAction(adminAnnounce, body)
+}
@@ -0,0 +1,120 @@
+package gopher
+
+import (
+	"encoding/json"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRESTAPI(t *testing.T) {
+	oldSettings := settings
+	defer func() { settings = oldSettings }()
+	settings = &ServerSettings{ServerName: "server", RESTAuthToken: "secret-token"}
+	core.NewRoomType("restTest", false)
+	server := httptest.NewServer(RESTHandler())
+	defer server.Close()
+
+	// request sends a request to the REST API, and decodes its response
+	request := func(method string, path string, token string, body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, server.URL+"/api"+path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var decoded map[string]interface{}
+		if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, decoded
+	}
+	errorCode := func(response map[string]interface{}) int {
+		if e, ok := response["e"].(map[string]interface{}); ok {
+			return int(e["c"].(float64))
+		}
+		return 0
+	}
+
+	// Auth
+	if status, _ := request("GET", "/rooms", "", ""); status != http.StatusUnauthorized {
+		t.Error("Expected a 401 without a token, got", status)
+	}
+	if status, _ := request("GET", "/rooms", "wrong-token", ""); status != http.StatusUnauthorized {
+		t.Error("Expected a 401 with the wrong token, got", status)
+	}
+	for auth, want := range map[string]int{"secret-token": http.StatusUnauthorized, "Basic secret-token": http.StatusUnauthorized,
+		"bearer secret-token": http.StatusOK, "BEARER secret-token": http.StatusOK} {
+		req, _ := http.NewRequest("GET", server.URL+"/api/rooms", nil)
+		req.Header.Set("Authorization", auth)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Error("Expected", want, "with the Authorization header", auth, "got", resp.StatusCode)
+		}
+	}
+
+	// Rooms
+	if status, resp := request("POST", "/rooms", "secret-token", `{"n": "rest room", "t": "restTest", "m": 4}`); status != http.StatusOK {
+		t.Fatal("Expected the Room to be made, got", status, resp)
+	}
+	room, err := core.GetRoom("rest room")
+	if err != nil {
+		t.Fatal("Expected the Room to exist, got", err)
+	} else if room.MaxUsers() != 4 {
+		t.Error("Expected the Room to hold 4 Users, got", room.MaxUsers())
+	}
+	if status, resp := request("POST", "/rooms", "secret-token", `{"n": "rest room", "t": "restTest"}`); status != http.StatusConflict ||
+		errorCode(resp) != helpers.ErrorRoomExists {
+
+		t.Error("Expected a 409 for a taken Room name, got", status, resp)
+	}
+	if status, resp := request("POST", "/rooms", "secret-token", `not json`); status != http.StatusBadRequest {
+		t.Error("Expected a 400 for a bad body, got", status, resp)
+	}
+	if status, resp := request("GET", "/rooms", "secret-token", ""); status != http.StatusOK {
+		t.Error("Expected the Rooms, got", status, resp)
+	} else if list, _ := resp["r"].([]interface{}); len(list) == 0 {
+		t.Error("Expected the Rooms to be listed, got", resp)
+	}
+	if status, resp := request("DELETE", "/rooms/rest%20room", "secret-token", ""); status != http.StatusOK {
+		t.Error("Expected the Room to be deleted, got", status, resp)
+	} else if _, err := core.GetRoom("rest room"); err == nil {
+		t.Error("The Room should be gone")
+	}
+	if status, _ := request("DELETE", "/rooms/rest%20room", "secret-token", ""); status != http.StatusNotFound {
+		t.Error("Expected a 404 for a Room that doesn't exist, got", status)
+	}
+
+	// Users
+	if status, resp := request("POST", "/users/nobody/kick", "secret-token", ""); status != http.StatusNotFound ||
+		errorCode(resp) != helpers.ErrorUserNotFound {
+
+		t.Error("Expected a 404 kicking a User that isn't logged in, got", status, resp)
+	}
+	if status, resp := request("GET", "/users", "secret-token", ""); status != http.StatusOK {
+		t.Error("Expected the Users, got", status, resp)
+	}
+
+	// Everything else
+	if status, resp := request("GET", "/stats", "secret-token", ""); status != http.StatusOK {
+		t.Error("Expected the stats, got", status, resp)
+	} else if stats, ok := resp["r"].(map[string]interface{}); !ok || stats["Rooms"] == nil {
+		t.Error("Expected the stats to be the result, got", resp)
+	}
+	if status, _ := request("PUT", "/stats", "secret-token", ""); status != http.StatusMethodNotAllowed {
+		t.Error("Expected a 405 for the wrong method, got", status)
+	}
+	if status, _ := request("GET", "/nothing", "secret-token", ""); status != http.StatusNotFound {
+		t.Error("Expected a 404 for an unknown endpoint, got", status)
+	}
+}
@@ -0,0 +1,66 @@
+package gopher
+
+import (
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"sync"
+	"time"
+)
+
+var (
+	//scheduledMux LOCKS scheduled AND scheduledNext
+	scheduled     = make(map[int]*time.Timer)
+	scheduledNext int
+	scheduledMux  sync.Mutex
+)
+
+// Schedule runs fn after duration, in its own goroutine, for timers that don't belong to a Room (for Rooms, use
+// *core.Room.SetTimer()). A panic in fn is logged instead of crashing the server. Use the returned function to cancel it
+// before it runs, which returns false if it already ran or was canceled. Everything still scheduled is canceled when the
+// server shuts down.
+func Schedule(duration time.Duration, fn func()) func() bool {
+	scheduledMux.Lock()
+	defer scheduledMux.Unlock()
+	scheduledNext++
+	id := scheduledNext
+	scheduled[id] = time.AfterFunc(duration, func() {
+		scheduledMux.Lock()
+		_, ok := scheduled[id]
+		delete(scheduled, id)
+		scheduledMux.Unlock()
+		if ok {
+			runScheduled(fn)
+		}
+	})
+
+	//
+	return func() bool {
+		scheduledMux.Lock()
+		defer scheduledMux.Unlock()
+		timer, ok := scheduled[id]
+		if !ok {
+			return false
+		}
+		timer.Stop()
+		delete(scheduled, id)
+		return true
+	}
+}
+
+func runScheduled(fn func()) {
+	defer func() {
+		if p := recover(); p != nil {
+			helpers.Recovered("a scheduled function", p)
+		}
+	}()
+	fn()
+}
+
+// stopScheduled cancels everything scheduled with Schedule().
+func stopScheduled() {
+	scheduledMux.Lock()
+	for id, timer := range scheduled {
+		timer.Stop()
+		delete(scheduled, id)
+	}
+	scheduledMux.Unlock()
+}
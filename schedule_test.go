@@ -0,0 +1,33 @@
+package gopher
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	ran := make(chan bool, 3)
+	cancel := Schedule(time.Millisecond*10, func() { ran <- true })
+	select {
+	case <-ran:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Expected the scheduled function to run")
+	}
+	if cancel() {
+		t.Error("Canceling a function that already ran should return false")
+	}
+
+	// Canceled functions don't run, and neither does anything still scheduled at shut down
+	if !Schedule(time.Millisecond*10, func() { ran <- true })() {
+		t.Error("Expected canceling the scheduled function to return true")
+	}
+	Schedule(time.Millisecond*10, func() { ran <- true })
+	stopScheduled()
+
+	// A panic is logged instead of crashing the server
+	Schedule(time.Millisecond, func() { panic("scheduled panic") })
+	time.Sleep(time.Millisecond * 40)
+	if len(ran) > 0 {
+		t.Error("The canceled functions should not have run")
+	}
+}
@@ -6,21 +6,20 @@ package gopher
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/hewiefreeman/GopherGameServer/actions"
 	"github.com/hewiefreeman/GopherGameServer/core"
 	"github.com/hewiefreeman/GopherGameServer/database"
-	"io/ioutil"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net"
 	"net/http"
-	"os"
-	"strconv"
+	"sync"
 	"time"
 )
 
 /////////// TO DOs:
 ///////////    - Make authentication for GopherDB
-///////////	- Admin tools
 ///////////    - More useful command-line macros
 
 // ServerSettings are the core settings for the Gopher Game Server. You must fill one of these out to customize
@@ -28,45 +27,123 @@ import (
 type ServerSettings struct {
 	ServerName     string // The server's name. Used for the server's ownership of private Rooms. (Required)
 	MaxConnections int    // The maximum amount of concurrent connections the server will accept. Setting this to 0 means infinite.
+	QuietStartup   bool   // Stops the server from printing its ASCII art banner when it starts. Use gopher.SetLogger() to change how everything else is logged.
 
 	HostName  string // Server's host name. Use 'https://' for TLS connections. (ex: 'https://example.com') (Required)
 	HostAlias string // Server's host alias name. Use 'https://' for TLS connections. (ex: 'https://www.example.com')
-	IP        string // Server's IP address. (Required)
+	IP        string // Server's IP address, or a host name to listen on all of its IP addresses. IPv6 addresses can be written with or without brackets, like "::1" or "[::1]". Leaving it empty listens on every interface, with IPv4 and IPv6.
 	Port      int    // Server's port. (Required)
 
+	ListenAddresses []string // Listens on each of these addresses instead of IP, all with the same handlers, like []string{"0.0.0.0", "[::1]:8081"}. Addresses are written like IP, and can have their own port. Host names are listened on at all of their IP addresses.
+
 	TLS         bool   // Enables TLS/SSL connections.
 	CertFile    string // SSL/TLS certificate file location (starting from system's root folder). (Required for TLS)
 	PrivKeyFile string // SSL/TLS private key file location (starting from system's root folder). (Required for TLS)
 
-	OriginOnly bool // When enabled, the server declines connections made from outside the origin server (Admin logins always check origin). IMPORTANT: Enable this for web apps and LAN servers.
+	AutoCert         bool     // Gets and renews the server's TLS certificate from Let's Encrypt automatically, and enables TLS. The server answers Let's Encrypt's challenges on port 80 of IP, so it must be free and reachable from the internet. CertFile and PrivKeyFile can't be used with it.
+	AutoCertHosts    []string // The host names AutoCert gets certificates for, like "example.com". Connections for other host names are refused. Defaults to the hosts of HostName and HostAlias.
+	AutoCertCacheDir string   // The folder AutoCert keeps its certificates and account key in, so they aren't requested again every start-up. (Required for AutoCert)
+
+	Handler         bool   // Enables handler mode. The server will not listen for connections itself, so you can mount gopher.SocketHandler() on your own http.ServeMux or router. IP, Port, TLS, CertFile, PrivKeyFile, AutoCert, EndpointPath, MetricsEndpoint and RESTPort are not used in handler mode.
+	EndpointPath    string // The path the server accepts WebSocket connections on. Must start with "/". Defaults to "/ws", or "/wss" when TLS is enabled.
+	MetricsEndpoint string // When set, the server serves gopher.Stats() at this path in the Prometheus text format, like "/metrics". Must start with "/". Anyone who can reach the server can read it, so block it from the public at your proxy or firewall.
+
+	ReadBufferSize    int  // The size in bytes of each connection's read buffer. Defaults to 1024.
+	WriteBufferSize   int  // The size in bytes of each connection's write buffer. Defaults to 1024.
+	EnableCompression bool // Enables per message compression (RFC 7692) for clients that support it.
+
+	MaxMessageSize       int64 // The largest message in bytes the server accepts from a client. Clients that send a bigger one are disconnected. Defaults to 1 MB.
+	MaxMalformedMessages int   // The amount of messages that aren't valid client actions a client can send before they are disconnected. Defaults to 5.
+
+	OutboundQueueSize int           // The most messages that can wait to be written to a client. Sending to a client never waits for the client to receive it, so a slow client can't hold up the others. Clients that fall this far behind are disconnected. Default is 256.
+	WriteTimeout      time.Duration // How long writing a message or ping to a client can take before the client is disconnected, with a helpers.ErrWriteTimeout error for the ClientDisconnect callback. Default is 10 seconds.
+	ActionTimeout     time.Duration // How long a client action can take before the client gets an ErrorTimeout response instead. The built-in actions stop their database queries, logins and room joins when they run out of time, and a CustomClientAction that doesn't respond in time gets the error for it. Default is 0, for no limit.
+
+	PingInterval time.Duration // How often the server pings each client to check their connection is still alive. Setting this to 0 disables pinging, and dead connections will stay until the OS notices them.
+	PongTimeout  time.Duration // How long the server waits for a client to respond to a ping (or send anything) before disconnecting them. Defaults to PingInterval.
+
+	OriginOnly     bool     // When enabled, the server declines connections made from outside the origin server (Admin logins have their own check, see EnableRemoteAdmin). IMPORTANT: Enable this for web apps and LAN servers.
+	AllowedOrigins []string // When set, the server only accepts connections from these origins, overriding OriginOnly. Entries are full origins like "https://example.com:8080", and can use a wildcard for sub-domains like "https://*.example.com". Use "null" to allow sandboxed pages and mobile web views. Clients that don't send an Origin header (not web browsers) are always accepted.
+
+	LoginAttemptLimit   int           // The amount of login, sign up, password change and account deletion attempts each connection and IP address can make every LoginAttemptWindow. Setting this to 0 means no limit.
+	LoginAttemptWindow  time.Duration // The time it takes for a connection or IP address to get all of its LoginAttemptLimit back. Default is 1 minute.
+	ActionRateLimit     int           // The amount of client actions each connection can send every second. CustomClientActions can override this with actions.SetRateLimit(). Setting this to 0 means no limit.
+	RateLimitDisconnect int           // Disconnects a client when more than this many of its actions are rate limited within a minute. Setting this to 0 means rate limited clients are never disconnected.
+	VoiceByteRate       int           // The amount of bytes of binary voice frames each connection can send every second. Frames over the limit are dropped. Default is 32 KB.
+	TrustedProxies      []string      // The IP addresses and CIDR ranges (like "10.0.0.0/8") of your load balancers or reverse proxies. The X-Forwarded-For header is only used to find a client's IP address for the rate limits when the connection comes from one of these.
 
 	MultiConnect   bool  // Enables multiple connections under the same User. When enabled, will override KickDupOnLogin's functionality.
 	MaxUserConns   uint8 // Overrides the default (255) of maximum simultaneous connections on a single User
 	KickDupOnLogin bool  // When enabled, a logged in User will be disconnected from service when another User logs in with the same name.
+	MaxWatches     int   // The most Users each connection can watch at once with the "wu" client action. Default is 50.
+
+	AllowGuests      bool   // Lets clients log in as guests without an account, with the "lg" action and a generated name like "Guest12", or with the "g" login parameter and a name of their own. With the SQL features, guests can't take the name of an account.
+	GuestNamePrefix  string // The start of the names generated for guests. Default is "Guest".
+	GuestRoomControl bool   // Lets guests create Rooms when UserRoomControl is enabled. Guests can't create Rooms without it.
+	GuestFriending   bool   // Lets guests send, accept, decline and remove friend requests. Guests can't friend without it.
+	GuestVariables   bool   // Lets guests set their own User variables. Guests can't set variables without it.
 
 	UserRoomControl   bool // Enables Users to create Rooms, invite/uninvite(AKA revoke) other Users to their owned private rooms, and destroy their owned rooms.
-	RoomDeleteOnLeave bool // When enabled, Rooms created by a User will be deleted when the owner leaves. WARNING: If disabled, you must remember to at some point delete the rooms created by Users, or they will pile up endlessly!
-
-	EnableSqlFeatures bool   // Enables the built-in SQL User authentication and friending. NOTE: It is HIGHLY recommended to use TLS over an SSL/HTTPS connection when using the SQL features. Otherwise, sensitive User information can be compromised with network "snooping" (AKA "sniffing").
-	SqlIP             string // SQL Database IP address. (Required for SQL features)
-	SqlPort           int    // SQL Database port. (Required for SQL features)
-	SqlProtocol       string // The protocol to use while comminicating with the MySQL database. Most use either 'udp' or 'tcp'. (Required for SQL features)
-	SqlUser           string // SQL user name (Required for SQL features)
-	SqlPassword       string // SQL user password (Required for SQL features)
-	SqlDatabase       string // SQL database name (Required for SQL features)
-	EncryptionCost    int    // The amount of encryption iterations the server will run when storing and checking passwords. The higher the number, the longer encryptions take, but are more secure. Default is 4, range is 4-31.
-	CustomLoginColumn string // The custom AccountInfoColumn you wish to use for logging in instead of the default name column.
-	RememberMe        bool   // Enables the "Remember Me" login feature. You can read more about this in project's wiki.
-
-	EnableRecovery   bool   // Enables the recovery of all Rooms, their settings, and their variables on start-up after terminating the server.
-	RecoveryLocation string // The folder location (starting from system's root folder) where you would like to store the recovery data. (Required for recovery)
-
-	AdminLogin    string // The login name for the Admin Tools (Required for Admin Tools)
-	AdminPassword string // The password for the Admin Tools (Required for Admin Tools)
-}
+	UserRoomMaxUsers  int  // The highest User capacity clients can give the Rooms they create. Rooms asked for with no limit get this capacity. Setting this to 0 means no limit. *RoomType.SetMaxUsers() can lower it for a RoomType.
+	RoomDeleteOnLeave bool // When enabled, Rooms created by a User will be deleted when the owner leaves. WARNING: If disabled, you must remember to at some point delete the rooms created by Users, or they will pile up endlessly! EmptyRoomTTL can do it for you.
+	ChatHistoryLen    int  // The amount of latest chat messages each Room keeps and sends to Users when they join. Setting this to 0 disables the chat history. Can be overridden per RoomType with *RoomType.SetChatHistoryLen().
+
+	EmptyRoomTTL time.Duration // Deletes Rooms made by Users once they've been empty this long. Rooms owned by the server, and Rooms made persistent with *Room.SetPersistent(), are never deleted. Use gopher.SetRoomExpiredCallback() to know when it happens. Setting this to 0 disables it.
+
+	Cluster *ClusterSettings // Runs the server as one node of a cluster of servers that share their Users and Rooms. See ClusterSettings. Leaving it nil runs the server on its own.
+
+	EnableSqlFeatures  bool          // Enables the built-in SQL User authentication and friending. NOTE: It is HIGHLY recommended to use TLS over an SSL/HTTPS connection when using the SQL features. Otherwise, sensitive User information can be compromised with network "snooping" (AKA "sniffing").
+	SqlDriver          string        // The SQL database to use: database.DriverMySQL ("mysql"), database.DriverPostgres ("postgres"), or database.DriverSQLite ("sqlite"). Default is "mysql"
+	SqlIP              string        // SQL Database IP address. (Required for SQL features)
+	SqlPort            int           // SQL Database port. (Required for SQL features)
+	SqlProtocol        string        // The protocol to use while comminicating with the MySQL database. Most use either 'udp' or 'tcp'. (Required for SQL features with MySQL)
+	SqlUser            string        // SQL user name (Required for SQL features)
+	SqlPassword        string        // SQL user password (Required for SQL features)
+	SqlDatabase        string        // SQL database name, or the path to the database file with SQLite (Required for SQL features)
+	SqlMaxOpenConns    int           // The maximum number of open connections to the database. Default is 0 (no limit)
+	SqlMaxIdleConns    int           // The maximum number of idle connections kept open to the database. Default is 0 (database/sql's default of 2)
+	SqlConnMaxLifetime time.Duration // The maximum amount of time a database connection can be reused. Set this lower than the database's own idle timeout to avoid dropped connections. Default is 0 (no limit)
+	EncryptionCost     int           // The amount of encryption iterations the server will run when storing and checking passwords. The higher the number, the longer encryptions take, but are more secure. Default is 4, range is 4-31.
+	CustomLoginColumn  string        // The custom AccountInfoColumn you wish to use for logging in instead of the default name column.
+	RememberMe         bool          // Enables the "Remember Me" login feature. You can read more about this in project's wiki.
+
+	RequireEmailVerification bool          // Requires new accounts to verify their email before they can log in with the SQL features. Send the token your sign up callback receives to the User, and verify it with database.VerifyAccount().
+	VerificationTokenTTL     time.Duration // How long an email verification token stays valid. Default is 24 hours.
+
+	SqlWriteWorkers       int           // The number of workers that run database writes nothing waits on, like friend requests, in the background. Account changes like signing up, changing a password, and deleting an account are never queued. Default is 2.
+	SqlWriteBatchSize     int           // The most queued database writes a worker runs in one transaction. Default is 50.
+	SqlWriteFlushInterval time.Duration // The longest a queued database write waits for its batch to fill up before it runs. Default is 100 milliseconds.
+	SqlWriteQueueSize     int           // The most database writes each worker can have queued. When it's full, writes run right away instead. Default is 1024.
+
+	SqlMigrationDryRun bool // Logs the database migrations the server would apply when it starts, without applying them, and stops the server if there are any. See database.AddMigration().
+
+	EnableRecovery   bool          // Enables the recovery of all Rooms, their settings, and their variables on start-up after terminating the server.
+	RecoveryLocation string        // The folder location (starting from system's root folder) where you would like to store the recovery data. (Required for recovery) Bans are saved here too, when EnableSqlFeatures is off.
+	RecoveryInterval time.Duration // How often the server saves a snapshot of its Rooms while it runs, so they can be recovered after a crash. Defaults to 1 minute. The server also saves one when it shuts down, and with gopher.SnapshotNow().
 
-type serverRestore struct {
-	R map[string]core.RoomRecoveryState
+	MaxSavedGameSize int  // The most bytes a game saved with *core.Room.SaveGame() can take. Default is 64KB.
+	PurgeSavedGames  bool // With EnableSqlFeatures, deleting an account also deletes the saved games it was in. Otherwise, the games are kept without it.
+
+	EventLogMaxSize int64 // The biggest a Room's event log file in the RecoveryLocation gets before it's rotated, for RoomTypes with *core.RoomType.EnableRecordEvents(). The last 3 rotated files are kept. Default is 10 MB.
+
+	ReconnectGracePeriod time.Duration // How long a logged in client that lost its connection stays logged in, in its Room, waiting to reconnect. Messages sent to it in the meantime are buffered, and sent when it reconnects with the "rt" token from its login response in the URL, like "/ws?resume=<token>". The client gets a ServerActionReconnected message with a new token first. Setting this to 0 disables it.
+	ReconnectBufferSize  int           // The most messages buffered for a client waiting to reconnect. When it's full, the oldest ones are dropped, and the client is told so when it reconnects. Default is 100.
+
+	MinClientVersion string // The oldest protocol version clients can connect with, like "1.1". Clients send theirs in their Sec-WebSocket-Protocol like "gopher-1.1", or in the URL like "/ws?version=1.1", and clients that don't send one are version "1.0". Older clients get a ServerActionClientOutdated message, and are disconnected. Use gopher.SetClientVersionCallback() for your own rules. Default is "1.0", for every client.
+	ClientUpdateURL  string // Where outdated clients can get an update. It's sent to them in the ServerActionClientOutdated message.
+
+	EnableMessagePack bool // Lets clients use MessagePack instead of JSON, by connecting with "format=msgpack" in the URL, like "/ws?format=msgpack". MessagePack clients send and get every message as a binary MessagePack message, and voice frames as MessagePack bins. Other clients keep using JSON.
+
+	SessionResumeWindow time.Duration // With EnableRecovery, logged in Users are saved in the recovery snapshots too. After a restart, clients have this long to reconnect and be logged back in, in the same Room, with the same status and variables. A client resumes its session by automatically logging in with RememberMe, or by connecting with the "rt" token from its login response in the URL, like "/ws?resume=<token>". Setting this to 0 disables it.
+
+	EnableAdminTools  bool   // Enables the Admin Tools. A client that logs in with the AdminLogin and AdminPassword can list the logged in Users and Rooms, kick and ban Users and IP addresses, delete Rooms, and send announcements. Use gopher.SetAdminActionCallback() to decide which admin actions are allowed.
+	EnableRemoteAdmin bool   // Allows admin logins from other machines. Without it, admins must connect from the server's own machine. With it, admins connecting from a browser must be on the HostName or HostAlias origin. Set TrustedProxies if the server is behind a proxy, or every client looks like it's on the server's machine.
+	AdminLogin        string // The login name for the Admin Tools (Required for Admin Tools)
+	AdminPassword     string // The password for the Admin Tools (Required for Admin Tools)
+
+	RESTAuthToken  string // Enables the REST API for your other servers to list, make and delete Rooms, list, kick and ban Users, send announcements, and get gopher.Stats(). Requests must send it as a bearer token. Use a long random string. See RESTHandler().
+	RESTPort       int    // Serves the REST API on this port instead of Port, on the same IP addresses. Setting this to 0 serves it with the WebSocket endpoint.
+	RESTPathPrefix string // The path the REST API's endpoints start with. Must start with "/". Default is "/api".
 }
 
 var (
@@ -77,13 +154,22 @@ var (
 	serverStarted  bool       = false
 	serverPaused   bool       = false
 	serverStopping bool       = false
+	serverRunning  bool       = false
 	serverEndChan  chan error = make(chan error)
+	serverDoneChan chan bool  = make(chan bool)
+	stoppingMux    sync.Mutex
 
-	startCallback         func()
-	pauseCallback         func()
-	stopCallback          func()
-	resumeCallback        func()
-	clientConnectCallback func(*http.ResponseWriter, *http.Request) bool
+	// WHEN THE SERVER STARTED RUNNING - LOCK stoppingMux TO USE
+	startTime time.Time
+
+	startCallback            func()
+	pauseCallback            func()
+	stopCallback             func()
+	resumeCallback           func()
+	clientConnectCallback    func(*http.ResponseWriter, *http.Request) (bool, map[string]interface{})
+	clientDisconnectCallback func(string, bool, error)
+	clientVersionCallback    func(string) bool
+	adminActionCallback      func(string, interface{}) bool
 
 	//SERVER VERSION NUMBER
 	version string = "1.0-BETA.2"
@@ -99,23 +185,33 @@ var (
 // all `ServerSettings` options to tune the server for your desired functionality and security needs.
 //
 // This function will block the thread that it is ran on until the server either errors, or is manually shut-down. To run code after the
-// server starts/stops/pauses/etc, use the provided server callback setter functions.
+// server starts/stops/pauses/etc, use the provided server callback setter functions. This is also the case in handler mode (Handler in
+// ServerSettings), so run your own http.Server on a separate goroutine.
 func Start(s *ServerSettings) {
+	stoppingMux.Lock()
 	if serverStarted || serverPaused {
+		stoppingMux.Unlock()
 		return
 	}
 	serverStarted = true
-	fmt.Println("  _______                __\n |   _   |.-----..-----.|  |--..-----..----.\n |.  |___||. _  ||. _  ||.    ||. -__||.  _|\n |.  |   ||:. . ||:. __||: |: ||:    ||: |\n |:  |   |'-----'|: |   '--'--''-----''--'\n |::.. . |       '--' - Game Server -\n '-------'\n\n ")
-	fmt.Println("Starting server...")
+	stoppingMux.Unlock()
+	if s == nil || !s.QuietStartup {
+		fmt.Println("  _______                __\n |   _   |.-----..-----.|  |--..-----..----.\n |.  |___||. _  ||. _  ||.    ||. -__||.  _|\n |.  |   ||:. . ||:. __||: |: ||:    ||: |\n |:  |   |'-----'|: |   '--'--''-----''--'\n |::.. . |       '--' - Game Server -\n '-------'\n\n ")
+	}
+	helpers.Log().Info("Starting server...")
 	// Set server settings
 	if s != nil {
-		if !s.verify() {
+		if err := s.Validate(); err != nil {
+			for _, problem := range err.(*SettingsError).Problems {
+				helpers.Log().Error(problem + " in ServerSettings")
+			}
+			helpers.Log().Info("Shutting down...")
 			return
 		}
 		settings = s
 	} else {
 		// Default localhost settings
-		fmt.Println("Using default settings...")
+		helpers.Log().Info("Using default settings...")
 		settings = &ServerSettings{
 			ServerName:     "!server!",
 			MaxConnections: 0,
@@ -134,10 +230,15 @@ func Start(s *ServerSettings) {
 			MultiConnect:   false,
 			KickDupOnLogin: false,
 
+			AllowGuests: false,
+
 			UserRoomControl:   true,
+			UserRoomMaxUsers:  0,
 			RoomDeleteOnLeave: true,
+			ChatHistoryLen:    0,
 
 			EnableSqlFeatures: false,
+			SqlDriver:         database.DriverMySQL,
 			SqlIP:             "localhost",
 			SqlPort:           3306,
 			SqlProtocol:       "tcp",
@@ -157,7 +258,35 @@ func Start(s *ServerSettings) {
 
 	// Update package settings
 	core.SettingsSet((*settings).KickDupOnLogin, (*settings).ServerName, (*settings).RoomDeleteOnLeave, (*settings).EnableSqlFeatures,
-		(*settings).RememberMe, (*settings).MultiConnect, (*settings).MaxUserConns)
+		(*settings).RememberMe, (*settings).MultiConnect, (*settings).MaxUserConns, (*settings).ChatHistoryLen)
+	if (*settings).EnableRecovery {
+		core.SetSessionResume((*settings).SessionResumeWindow)
+	}
+	reconnectBuffer := (*settings).ReconnectBufferSize
+	if reconnectBuffer == 0 {
+		reconnectBuffer = defaultReconnectBufferSize
+	}
+	core.SetReconnect((*settings).ReconnectGracePeriod, reconnectBuffer)
+	core.SetEmptyRoomTTL((*settings).EmptyRoomTTL)
+	maxWatches := (*settings).MaxWatches
+	if maxWatches == 0 {
+		maxWatches = defaultMaxWatches
+	}
+	core.SetMaxWatches(maxWatches)
+	maxSavedGameSize := (*settings).MaxSavedGameSize
+	if maxSavedGameSize == 0 {
+		maxSavedGameSize = defaultMaxSavedGameSize
+	}
+	savedGamesFolder := ""
+	if !(*settings).EnableSqlFeatures {
+		savedGamesFolder = (*settings).RecoveryLocation
+	}
+	core.SetSavedGames(savedGamesFolder, maxSavedGameSize)
+	eventLogMaxSize := (*settings).EventLogMaxSize
+	if eventLogMaxSize == 0 {
+		eventLogMaxSize = defaultEventLogMaxSize
+	}
+	core.SetEventLog((*settings).RecoveryLocation, eventLogMaxSize)
 
 	// Notify packages of server start
 	core.SetServerStarted(true)
@@ -166,16 +295,39 @@ func Start(s *ServerSettings) {
 
 	// Start database
 	if (*settings).EnableSqlFeatures {
-		fmt.Println("Initializing database...")
-		dbErr := database.Init((*settings).SqlUser, (*settings).SqlPassword, (*settings).SqlDatabase,
+		helpers.Log().Info("Initializing database...")
+		database.SetConnectionPool((*settings).SqlMaxOpenConns, (*settings).SqlMaxIdleConns, (*settings).SqlConnMaxLifetime)
+		database.SetEmailVerification((*settings).RequireEmailVerification, (*settings).VerificationTokenTTL)
+		database.SetMigrationDryRun((*settings).SqlMigrationDryRun)
+		database.SetPurgeSavedGames((*settings).PurgeSavedGames)
+		database.SetWriteQueue((*settings).SqlWriteWorkers, (*settings).SqlWriteBatchSize, (*settings).SqlWriteFlushInterval,
+			(*settings).SqlWriteQueueSize)
+		dbErr := database.Init((*settings).SqlDriver, (*settings).SqlUser, (*settings).SqlPassword, (*settings).SqlDatabase,
 			(*settings).SqlProtocol, (*settings).SqlIP, (*settings).SqlPort, (*settings).EncryptionCost,
 			(*settings).RememberMe, (*settings).CustomLoginColumn)
 		if dbErr != nil {
-			fmt.Println("Database error:", dbErr.Error())
-			fmt.Println("Shutting down...")
+			helpers.Log().Error("Database error", "error", dbErr)
+			helpers.Log().Info("Shutting down...")
+			return
+		}
+		helpers.Log().Info("Database initialized")
+	}
+
+	// Load bans
+	if banErr := core.LoadBans((*settings).EnableSqlFeatures, (*settings).RecoveryLocation); banErr != nil {
+		helpers.Log().Error("Error loading bans", "error", banErr)
+		helpers.Log().Info("Shutting down...")
+		return
+	}
+
+	// Join the cluster - BEFORE RECOVERING, SO THE RECOVERED ROOMS ARE ANNOUNCED
+	if settings.Cluster != nil {
+		helpers.Log().Info("Joining the cluster...")
+		if clusterErr := startCluster(settings.Cluster); clusterErr != nil {
+			helpers.Log().Error("Error joining the cluster", "error", clusterErr)
+			helpers.Log().Info("Shutting down...")
 			return
 		}
-		fmt.Println("Database initialized")
 	}
 
 	// Recover state
@@ -183,11 +335,24 @@ func Start(s *ServerSettings) {
 		recoverState()
 	}
 
+	// Make WebSocket upgrader
+	upgrader = makeUpgrader()
+	trustedProxies, _ = parseTrustedProxies((*settings).TrustedProxies)
+
 	// Start socket listener
-	if settings.TLS {
-		httpServer = makeServer("/wss", settings.TLS)
+	stoppingMux.Lock()
+	if settings.Handler {
+		helpers.Log().Info("Running in handler mode")
 	} else {
-		httpServer = makeServer("/ws", settings.TLS)
+		httpServer = makeServer(settings.endpoint(), settings.useTLS())
+	}
+	serverRunning = true
+	startTime = time.Now()
+	stoppingMux.Unlock()
+
+	// Start saving snapshots
+	if settings.EnableRecovery {
+		startSnapshots()
 	}
 
 	// Run callback
@@ -198,94 +363,116 @@ func Start(s *ServerSettings) {
 	// Start macro listener
 	go macroListener()
 
-	fmt.Println("Startup complete")
+	helpers.Log().Info("Startup complete")
 
 	// Wait for server shutdown
 	doneErr := <-serverEndChan
+	stopSnapshots()
 
 	if doneErr != http.ErrServerClosed {
-		fmt.Println("Fatal server error:", doneErr.Error())
+		helpers.Log().Error("Fatal server error", "error", doneErr)
+
+		if !isStopping() {
+			// Save state
+			if settings.EnableRecovery {
+				saveState()
+			}
 
-		if !serverStopping {
-			fmt.Println("Disconnecting users...")
+			helpers.Log().Info("Disconnecting users...")
 
 			// Pause server
 			core.Pause()
 			actions.Pause()
 			database.Pause()
+		}
+	}
 
-			// Save state
-			if settings.EnableRecovery {
-				saveState()
-			}
+	// Leave the cluster
+	core.StopCluster()
+
+	// Close database
+	if settings.EnableSqlFeatures {
+		if closeErr := database.Close(); closeErr != nil {
+			helpers.Log().Error("Error closing database", "error", closeErr)
 		}
 	}
 
-	fmt.Println("Server shut-down completed")
+	helpers.Log().Info("Server shut-down completed")
 
 	if stopCallback != nil {
 		stopCallback()
 	}
-}
 
-func (settings *ServerSettings) verify() bool {
-	if settings.ServerName == "" {
-		fmt.Println("ServerName in ServerSettings is required. Shutting down...")
-		return false
-
-	} else if settings.HostName == "" || settings.IP == "" || settings.Port < 1 {
-		fmt.Println("HostName, IP, and Port in ServerSettings are required. Shutting down...")
-		return false
-
-	} else if settings.TLS == true && (settings.CertFile == "" || settings.PrivKeyFile == "") {
-		fmt.Println("CertFile and PrivKeyFile in ServerSettings are required for a TLS connection. Shutting down...")
-		return false
-
-	} else if settings.EnableSqlFeatures == true && (settings.SqlIP == "" || settings.SqlPort < 1 || settings.SqlProtocol == "" ||
-		settings.SqlUser == "" || settings.SqlPassword == "" || settings.SqlDatabase == "") {
-		fmt.Println("SqlIP, SqlPort, SqlProtocol, SqlUser, SqlPassword, and SqlDatabase in ServerSettings are required for the SQL features. Shutting down...")
-		return false
-
-	} else if settings.EnableRecovery == true && settings.RecoveryLocation == "" {
-		fmt.Println("RecoveryLocation in ServerSettings is required for server recovery. Shutting down...")
-		return false
-
-	} else if settings.EnableRecovery {
-		// Check if invalid file location
-		if _, err := os.Stat(settings.RecoveryLocation); err != nil {
-			fmt.Println("RecoveryLocation error:", err)
-			fmt.Println("Shutting down...")
-			return false
-		}
-		var d []byte
-		if err := ioutil.WriteFile(settings.RecoveryLocation+"/test.txt", d, 0644); err != nil {
-			fmt.Println("RecoveryLocation error:", err)
-			fmt.Println("Shutting down...")
-			return false
-		}
-		os.Remove(settings.RecoveryLocation + "/test.txt")
+	close(serverDoneChan)
+}
 
-	} else if settings.AdminLogin == "" || settings.AdminPassword == "" {
-		fmt.Println("AdminLogin and AdminPassword in ServerSettings are required. Shutting down...")
-		return false
+// endpoint gets the path the server accepts WebSocket connections on.
+func (settings *ServerSettings) endpoint() string {
+	if settings.EndpointPath != "" {
+		return settings.EndpointPath
+	} else if settings.useTLS() {
+		return "/wss"
 	}
+	return "/ws"
+}
 
-	return true
+// SocketHandler gets the handler that accepts the server's WebSocket connections. When using handler mode (Handler in ServerSettings),
+// mount it on your own http.ServeMux or router at any path you like. Start() must still be called to initialize the server, and
+// the handler will decline connections until it has started.
+func SocketHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stoppingMux.Lock()
+		running := serverRunning
+		stoppingMux.Unlock()
+		if !running {
+			http.Error(w, "Server is not running.", http.StatusServiceUnavailable)
+			return
+		}
+		socketInitializer(w, r)
+	}
 }
 
 func makeServer(handleDir string, tls bool) *http.Server {
-	server := &http.Server{Addr: settings.IP + ":" + strconv.Itoa(settings.Port)}
-	http.HandleFunc(handleDir, socketInitializer)
-	if tls {
-		go func() {
-			err := server.ListenAndServeTLS(settings.CertFile, settings.PrivKeyFile)
-			serverEndChan <- err
-		}()
-	} else {
+	// EACH START GETS ITS OWN ServeMux, SO STARTING AGAIN IN THE SAME PROCESS DOESN'T REGISTER THE HANDLERS TWICE
+	mux := http.NewServeMux()
+	mux.HandleFunc(handleDir, socketInitializer)
+	if settings.MetricsEndpoint != "" {
+		mux.HandleFunc(settings.MetricsEndpoint, metricsHandler)
+	}
+	if settings.RESTAuthToken != "" && !settings.restSeparate() {
+		mux.Handle(settings.restPathPrefix()+"/", RESTHandler())
+	}
+	server := &http.Server{Handler: mux}
+	certFile, keyFile := settings.CertFile, settings.PrivKeyFile
+	if tls && settings.AutoCert {
+		// THE CERTIFICATES COME FROM server.TLSConfig
+		useAutoCert(server)
+		certFile, keyFile = "", ""
+	}
+
+	// Open every listener first, so a bad address doesn't leave the others running
+	addresses, err := settings.listenAddresses()
+	var listeners []net.Listener
+	if err == nil {
+		listeners, err = listen(addresses)
+	}
+	if err != nil {
 		go func() {
-			err := server.ListenAndServe()
 			serverEndChan <- err
 		}()
+		return server
+	}
+	server.Addr = addresses[0]
+	for _, address := range addresses {
+		helpers.Log().Info("Listening on " + address)
+	}
+	serve(server, listeners, certFile, keyFile, tls)
+	if settings.RESTAuthToken != "" && settings.restSeparate() {
+		if restErr := startREST(server, certFile, keyFile, tls); restErr != nil {
+			go func() {
+				serverEndChan <- restErr
+			}()
+		}
 	}
 
 	//
@@ -297,172 +484,209 @@ func makeServer(handleDir string, tls bool) *http.Server {
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
 // Pause will log all Users off and prevent anyone from logging in. All rooms and their variables created by the server will remain in memory.
-// Same goes for rooms created by Users unless RoomDeleteOnLeave in ServerSettings is set to true.
+// Same goes for rooms created by Users unless RoomDeleteOnLeave in ServerSettings is set to true. Clients that are already connected stay
+// connected, but new connections are declined, and logging in, joining and creating rooms will respond with an `ErrorServerPaused` error until
+// the server is resumed.
 func Pause() {
-	if !serverPaused {
-		serverPaused = true
-
-		fmt.Println("Pausing server...")
-
-		core.Pause()
-		actions.Pause()
-		database.Pause()
+	stoppingMux.Lock()
+	if !serverStarted || serverPaused || serverStopping {
+		stoppingMux.Unlock()
+		return
+	}
+	serverPaused = true
+	stoppingMux.Unlock()
 
-		// Run callback
-		if pauseCallback != nil {
-			pauseCallback()
-		}
+	helpers.Log().Info("Pausing server...")
 
-		fmt.Println("Server paused")
+	core.Pause()
+	actions.Pause()
+	database.Pause()
 
-		serverStarted = false
+	// Run callback
+	if pauseCallback != nil {
+		pauseCallback()
 	}
 
+	helpers.Log().Info("Server paused")
+
+	stoppingMux.Lock()
+	serverStarted = false
+	stoppingMux.Unlock()
 }
 
 // Resume will allow Users to login again after pausing the server.
 func Resume() {
-	if serverPaused {
-		serverStarted = true
-
-		fmt.Println("Resuming server...")
-		core.Resume()
-		actions.Resume()
-		database.Resume()
-
-		// Run callback
-		if resumeCallback != nil {
-			resumeCallback()
-		}
+	stoppingMux.Lock()
+	if !serverPaused || serverStopping {
+		stoppingMux.Unlock()
+		return
+	}
+	serverStarted = true
+	stoppingMux.Unlock()
 
-		fmt.Println("Server resumed")
+	helpers.Log().Info("Resuming server...")
+	core.Resume()
+	actions.Resume()
+	database.Resume()
 
-		serverPaused = false
+	// Run callback
+	if resumeCallback != nil {
+		resumeCallback()
 	}
-}
 
-// ShutDown will log all Users off, save the state of the server if EnableRecovery in ServerSettings is set to true, then shut the server down.
-func ShutDown() error {
-	if !serverStopping {
-		serverStopping = true
-		fmt.Println("Disconnecting users...")
-
-		// Pause server
-		core.Pause()
-		actions.Pause()
-		database.Pause()
-
-		// Save state
-		if settings.EnableRecovery {
-			saveState()
-		}
+	helpers.Log().Info("Server resumed")
 
-		// Shut server down
-		fmt.Println("Shutting server down...")
-		shutdownErr := httpServer.Shutdown(context.Background())
-		if shutdownErr != http.ErrServerClosed {
-			return shutdownErr
-		}
-	}
-	//
-	return nil
+	stoppingMux.Lock()
+	serverPaused = false
+	stoppingMux.Unlock()
 }
 
 //////////////////////////////////////////////////////////////////////////////////////////////////////
-//   Saving and recovery   ///////////////////////////////////////////////////////////////////////////
+//   Server-wide announcements   /////////////////////////////////////////////////////////////////////
 //////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func saveState() {
-	fmt.Println("Saving server state...")
-	saveErr := writeState(getState(), settings.RecoveryLocation)
-	if saveErr != nil {
-		fmt.Println("Error saving state:", saveErr)
-		return
-	}
-	fmt.Println("Save state successful")
-}
-
-func writeState(stateObj serverRestore, saveFolder string) error {
-	state, err := json.Marshal(stateObj)
+// Broadcast sends an announcement to every client connected to the server, whether they're logged in or not. This is
+// useful for messages like "Server restarting in 5 minutes". The messageType is up to you, and lets your client tell
+// apart different kinds of announcements. The client APIs receive announcements separately from Room messages.
+//
+// To only send an announcement to some of the logged in Users, for instance only Users with `core.StatusAvailable`, use
+// `core.BroadcastToUsers()`.
+//
+// The message is only encoded once for each wire format the clients use (see helpers.FormatJSON). Connections that fail to receive it are skipped, and the returned error tells how
+// many failed along with the first error.
+func Broadcast(messageType string, data interface{}) error {
+	message, err := core.PrepareAnnouncement(messageType, data)
 	if err != nil {
 		return err
 	}
-	err = ioutil.WriteFile(saveFolder+"/Gopher Recovery - "+time.Now().Format("2006-01-02 15-04-05")+".grf", state, 0644)
+	return core.WritePrepared(conns.snapshot(), message)
+}
+
+// BroadcastLocalized is the same as Broadcast, but the announcement's data is a message template set with SetTranslations(),
+// with its positional parameters filled in by helpers.FormatMessage(). Each client gets it in its own locale, like:
+//
+//	gopher.SetTranslations("en", map[int]string{msgRestart: "Server restarting in {0} minutes"})
+//	gopher.SetTranslations("es", map[int]string{msgRestart: "El servidor se reinicia en {0} minutos"})
+//	gopher.BroadcastLocalized("restart", msgRestart, 5)
+//
+// The message is only encoded once for each locale. Returns an error when the key doesn't have a template in the
+// helpers.DefaultLocale.
+func BroadcastLocalized(messageType string, key int, params ...interface{}) error {
+	messages, err := core.PrepareLocalizedAnnouncement(messageType, key, params)
 	if err != nil {
 		return err
 	}
+	return core.WriteLocalized(conns.snapshot(), messages)
+}
 
-	return nil
+// SetTranslations sets the message templates for a locale, like "es" or "pt-BR". Clients send their locale in the URL they
+// connect with, like "/ws?locale=es", or when they log in. Otherwise, the first language in their Accept-Language header is
+// used. A key is an error code, like helpers.ErrorRoomFull, to translate the error messages clients get, or a key of your own
+// for BroadcastLocalized() and *core.User.PrivateMessageLocalized(). Messages that aren't translated to a client's locale
+// fall back to its language, then to helpers.DefaultLocale. See helpers.SetTranslations().
+func SetTranslations(locale string, msgs map[int]string) {
+	helpers.SetTranslations(locale, msgs)
 }
 
-func getState() serverRestore {
-	return serverRestore{
-		R: core.GetRoomsState(),
-	}
+// ShutDown will stop accepting new connections, notify all clients that the server is shutting down, wait for any client actions
+// that are still being processed, log all Users off, save the state of the server if EnableRecovery in ServerSettings is set to true,
+// then shut the server down. The server's stop callback runs after the listener has closed, and before ShutDown returns.
+//
+// ShutDown is safe to call from a signal handler. Calling it more than once returns an error.
+func ShutDown() error {
+	return ShutDownTimeout(0)
 }
 
-func recoverState() {
-	fmt.Println("Recovering previous state...")
+// ShutDownTimeout is the same as ShutDown, but will stop waiting for client actions and open connections after the given
+// duration, and force the server closed. A duration of 0 or less has no time limit.
+func ShutDownTimeout(timeout time.Duration) error {
+	stoppingMux.Lock()
+	if serverStopping {
+		stoppingMux.Unlock()
+		return errors.New("The server is already shutting down")
+	} else if !serverRunning {
+		stoppingMux.Unlock()
+		return errors.New("The server is not running")
+	}
+	serverStopping = true
+	stoppingMux.Unlock()
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
 
-	// Get last recovery file
-	files, fileErr := ioutil.ReadDir(settings.RecoveryLocation)
-	if fileErr != nil {
-		fmt.Println("Error recovering state:", fileErr)
-		return
+	// Notify clients and let in-flight actions finish
+	helpers.Log().Info("Waiting for client actions to finish...")
+	conns.broadcastShutDown()
+	if !waitForActions(ctx) {
+		helpers.Log().Warn("Timed out waiting for client actions")
 	}
-	var newestFile string
-	var newestTime int64
-	for _, f := range files {
-		if len(f.Name()) < 19 || f.Name()[0:15] != "Gopher Recovery" {
-			continue
+
+	// Save state - BEFORE PAUSING, WHICH LOGS EVERYONE OUT
+	if settings.EnableRecovery {
+		stopSnapshots()
+		saveState()
+	}
+
+	helpers.Log().Info("Disconnecting users...")
+
+	// Pause server
+	stopScheduled()
+	core.Pause()
+	actions.Pause()
+	database.Pause()
+
+	// Shut server down
+	helpers.Log().Info("Shutting server down...")
+	var shutdownErr error
+	if httpServer != nil {
+		shutdownErr = httpServer.Shutdown(ctx)
+		if shutdownErr != nil {
+			httpServer.Close()
 		}
-		fi, err := os.Stat(settings.RecoveryLocation + "/" + f.Name())
-		if err != nil {
-			fmt.Println("Error recovering state:", err)
-			return
+		if challengeServer != nil {
+			challengeServer.Close()
+		}
+		if restServer != nil {
+			restServer.Shutdown(ctx)
 		}
-		currTime := fi.ModTime().Unix()
-		if currTime > newestTime {
-			newestTime = currTime
-			newestFile = f.Name()
+	} else {
+		// Handler mode - nothing to close, just let Start() finish
+		select {
+		case serverEndChan <- http.ErrServerClosed:
+		case <-ctx.Done():
 		}
 	}
+	conns.closeAll()
 
-	// Read file
-	r, err := ioutil.ReadFile(settings.RecoveryLocation + "/" + newestFile)
-	if err != nil {
-		fmt.Println("Error recovering state:", err)
-		return
+	// Write what's left of the Rooms' event logs
+	if !core.CloseEventLogs(ctx) {
+		helpers.Log().Warn("Timed out writing room event logs")
 	}
 
-	// Convert JSON
-	var recovery serverRestore
-	if err = json.Unmarshal(r, &recovery); err != nil {
-		fmt.Println("Error recovering state:", err)
-		return
+	// Wait for Start() to finish up and run the stop callback
+	select {
+	case <-serverDoneChan:
+	case <-ctx.Done():
 	}
 
-	if recovery.R == nil || len(recovery.R) == 0 {
-		fmt.Println("No rooms to restore!")
-		return
-	}
+	//
+	return shutdownErr
+}
 
-	// Recover rooms
-	for name, val := range recovery.R {
-		room, roomErr := core.NewRoom(name, val.T, val.P, val.M, val.O)
-		if roomErr != nil {
-			fmt.Println("Error recovering room '"+name+"':", roomErr)
-			continue
-		}
-		for _, userName := range val.I {
-			invErr := room.AddInvite(userName)
-			if invErr != nil {
-				fmt.Println("Error inviting '"+userName+"' to the room '"+name+"':", invErr)
-			}
-		}
-		room.SetVariables(val.V)
-	}
+func isStopping() bool {
+	stoppingMux.Lock()
+	s := serverStopping
+	stoppingMux.Unlock()
+	return s
+}
 
-	//
-	fmt.Println("State recovery successful")
+func isPaused() bool {
+	stoppingMux.Lock()
+	p := serverPaused
+	stoppingMux.Unlock()
+	return p
 }
@@ -0,0 +1,42 @@
+package gopher
+
+import (
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMakeUpgrader(t *testing.T) {
+	oldSettings, oldUpgrader := settings, upgrader
+	defer func() {
+		waitForDisconnects(t)
+		settings, upgrader = oldSettings, oldUpgrader
+	}()
+
+	// Buffer sizes that aren't set get the defaults
+	settings = &ServerSettings{HostName: "localhost"}
+	if u := makeUpgrader(); u.ReadBufferSize != 1024 || u.WriteBufferSize != 1024 || u.EnableCompression {
+		t.Error("Expected 1024 byte buffers without compression, got", u.ReadBufferSize, u.WriteBufferSize, u.EnableCompression)
+	}
+	settings = &ServerSettings{HostName: "localhost", ReadBufferSize: 4096, WriteBufferSize: 2048, EnableCompression: true}
+	upgrader = makeUpgrader()
+	if upgrader.ReadBufferSize != 4096 || upgrader.WriteBufferSize != 2048 || !upgrader.EnableCompression {
+		t.Error("Expected the configured buffers and compression, got", upgrader.ReadBufferSize, upgrader.WriteBufferSize,
+			upgrader.EnableCompression)
+	}
+
+	// Clients that support compression negotiate it
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	dialer := websocket.Dialer{EnableCompression: true}
+	client, response, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if extensions := response.Header.Get("Sec-WebSocket-Extensions"); !strings.Contains(extensions, "permessage-deflate") {
+		t.Error("Expected compression to be negotiated, got", extensions)
+	}
+}
@@ -0,0 +1,336 @@
+package gopher
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/hewiefreeman/GopherGameServer/database"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SettingsError is returned by *ServerSettings.Validate() with every problem found in the ServerSettings.
+type SettingsError struct {
+	Problems []string
+}
+
+func (e *SettingsError) Error() string {
+	return "Invalid ServerSettings: " + strings.Join(e.Problems, "; ")
+}
+
+var (
+	// MATCHES ${NAME} IN SETTINGS FILE STRINGS
+	envVariable = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   LOADING SETTINGS FROM A FILE   //////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// LoadSettings reads ServerSettings from a JSON file. The keys are the names of the ServerSettings fields, and durations
+// can be written like "10s" or "1m30s", or as a number of nanoseconds:
+//
+//	{
+//	    "ServerName": "!server!",
+//	    "HostName": "example.com",
+//	    "IP": "0.0.0.0",
+//	    "Port": 8080,
+//	    "PingInterval": "30s",
+//	    "SqlPassword": "${GOPHER_SQL_PASSWORD}"
+//	}
+//
+// Anything like ${GOPHER_SQL_PASSWORD} in a string is replaced with that environment variable, so secrets like SqlPassword
+// and AdminPassword don't need to be in the file. An environment variable that isn't set, or a key that isn't a ServerSettings
+// field, is an error. The settings are not validated until you call *ServerSettings.Validate(), or Start() with them.
+func LoadSettings(path string) (*ServerSettings, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return nil, errors.New("Error reading " + path + ": " + err.Error())
+	}
+
+	// EXPAND ENVIRONMENT VARIABLES AND PARSE DURATIONS
+	var missing []string
+	for key, value := range values {
+		values[key] = expandEnv(value, &missing)
+	}
+	if len(missing) > 0 {
+		return nil, errors.New("Environment variables used in " + path + " are not set: " + strings.Join(missing, ", "))
+	}
+	if bad := parseDurations(values, reflect.TypeOf(ServerSettings{})); bad != "" {
+		return nil, errors.New("Error reading " + path + ": " + bad + " is not a duration")
+	}
+	if cluster, ok := values["Cluster"].(map[string]interface{}); ok {
+		if bad := parseDurations(cluster, reflect.TypeOf(ClusterSettings{})); bad != "" {
+			return nil, errors.New("Error reading " + path + ": Cluster." + bad + " is not a duration")
+		}
+	}
+
+	// DECODE INTO ServerSettings, REJECTING UNKNOWN KEYS
+	if data, err = json.Marshal(values); err != nil {
+		return nil, err
+	}
+	var s ServerSettings
+	decoder = json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, errors.New("Error reading " + path + ": " + err.Error())
+	}
+	return &s, nil
+}
+
+// parseDurations replaces the durations written like "10s" in decoded JSON values with their nanoseconds, for the
+// time.Duration fields of settingsType. Returns the name of the first field that isn't a valid duration.
+func parseDurations(values map[string]interface{}, settingsType reflect.Type) string {
+	for i := 0; i < settingsType.NumField(); i++ {
+		field := settingsType.Field(i)
+		if field.Type != durationType {
+			continue
+		}
+		if text, ok := values[field.Name].(string); ok {
+			duration, durationErr := time.ParseDuration(text)
+			if durationErr != nil {
+				return field.Name
+			}
+			values[field.Name] = int64(duration)
+		}
+	}
+	return ""
+}
+
+// expandEnv replaces the ${NAME}s in the strings of a decoded JSON value with their environment variables, and adds the
+// names of the ones that aren't set to missing.
+func expandEnv(value interface{}, missing *[]string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return envVariable.ReplaceAllStringFunc(v, func(match string) string {
+			name := match[2 : len(match)-1]
+			envValue, ok := os.LookupEnv(name)
+			if !ok {
+				*missing = append(*missing, name)
+			}
+			return envValue
+		})
+	case []interface{}:
+		for i := range v {
+			v[i] = expandEnv(v[i], missing)
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = expandEnv(v[key], missing)
+		}
+	}
+	return value
+}
+
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+//   VALIDATING SETTINGS   ///////////////////////////////////////////////////////////////////////////
+//////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// Validate checks the ServerSettings for every problem that would stop the server from starting, or make it run
+// differently than intended, like missing TLS certificate files, an EncryptionCost out of its range, or a Port that is
+// already in use. Start() validates its ServerSettings, and shuts down with all the problems logged. Returns nil or
+// a *SettingsError.
+func (settings *ServerSettings) Validate() error {
+	var problems []string
+	problem := func(p string) {
+		problems = append(problems, p)
+	}
+
+	// Server
+	if settings.ServerName == "" {
+		problem("ServerName is required")
+	}
+	if settings.HostName == "" || (!settings.Handler && settings.Port < 1) {
+		problem("HostName and Port are required")
+	} else if settings.Port > 65535 {
+		problem("Port must be at most 65535")
+	}
+	if settings.EndpointPath != "" && !strings.HasPrefix(settings.EndpointPath, "/") {
+		problem("EndpointPath must start with '/'")
+	}
+	if settings.MetricsEndpoint != "" && (!strings.HasPrefix(settings.MetricsEndpoint, "/") || settings.MetricsEndpoint == settings.endpoint()) {
+		problem("MetricsEndpoint must start with '/', and be different from EndpointPath")
+	}
+	if settings.MinClientVersion != "" && !helpers.ValidVersion(settings.MinClientVersion) {
+		problem("MinClientVersion must be made of numbers and dots, like \"1.1\"")
+	}
+
+	// TLS
+	if !settings.Handler && settings.AutoCert {
+		if settings.CertFile != "" || settings.PrivKeyFile != "" {
+			problem("AutoCert can't be used with CertFile and PrivKeyFile")
+		}
+		if len(settings.autoCertHosts()) == 0 {
+			problem("AutoCertHosts is required for AutoCert when HostName isn't a domain name")
+		}
+		if settings.AutoCertCacheDir == "" {
+			problem("AutoCertCacheDir is required for AutoCert")
+		}
+		if settings.Port == 80 {
+			problem("Port can't be 80 with AutoCert, which needs it for its challenges")
+		}
+	} else if !settings.Handler && settings.TLS {
+		if settings.CertFile == "" || settings.PrivKeyFile == "" {
+			problem("CertFile and PrivKeyFile are required for a TLS connection")
+		} else {
+			for _, file := range []string{settings.CertFile, settings.PrivKeyFile} {
+				if _, err := os.Stat(file); err != nil {
+					problem("Can't read TLS file " + file + ": " + err.Error())
+				}
+			}
+		}
+	}
+
+	// Negative numbers and durations
+	if settings.PingInterval < 0 || settings.PongTimeout < 0 {
+		problem("PingInterval and PongTimeout cannot be negative")
+	}
+	if settings.ReadBufferSize < 0 || settings.WriteBufferSize < 0 {
+		problem("ReadBufferSize and WriteBufferSize cannot be negative")
+	}
+	if settings.OutboundQueueSize < 0 || settings.WriteTimeout < 0 {
+		problem("OutboundQueueSize and WriteTimeout cannot be negative")
+	}
+	if settings.MaxWatches < 0 {
+		problem("MaxWatches cannot be negative")
+	}
+	if settings.MaxSavedGameSize < 0 || settings.EventLogMaxSize < 0 {
+		problem("MaxSavedGameSize and EventLogMaxSize cannot be negative")
+	}
+	if settings.ActionTimeout < 0 {
+		problem("ActionTimeout cannot be negative")
+	}
+	if settings.MaxMessageSize < 0 || settings.MaxMalformedMessages < 0 {
+		problem("MaxMessageSize and MaxMalformedMessages cannot be negative")
+	}
+	if settings.LoginAttemptLimit < 0 || settings.LoginAttemptWindow < 0 || settings.ActionRateLimit < 0 || settings.RateLimitDisconnect < 0 ||
+		settings.VoiceByteRate < 0 {
+		problem("LoginAttemptLimit, LoginAttemptWindow, ActionRateLimit, RateLimitDisconnect and VoiceByteRate cannot be negative")
+	}
+	if settings.RecoveryInterval < 0 || settings.SessionResumeWindow < 0 {
+		problem("RecoveryInterval and SessionResumeWindow cannot be negative")
+	}
+	if settings.ReconnectGracePeriod < 0 || settings.ReconnectBufferSize < 0 {
+		problem("ReconnectGracePeriod and ReconnectBufferSize cannot be negative")
+	}
+	if settings.EmptyRoomTTL < 0 {
+		problem("EmptyRoomTTL cannot be negative")
+	}
+
+	// REST API
+	if settings.RESTPathPrefix != "" && (!strings.HasPrefix(settings.RESTPathPrefix, "/") || settings.RESTPathPrefix == "/") {
+		problem("RESTPathPrefix must start with '/', and can't be only '/'")
+	}
+	if settings.RESTPort < 0 || settings.RESTPort > 65535 {
+		problem("RESTPort must be from 0 to 65535")
+	} else if settings.RESTPort > 0 && settings.RESTAuthToken == "" {
+		problem("RESTAuthToken is required for the REST API")
+	}
+	if settings.Cluster != nil {
+		settings.Cluster.validate(problem)
+	}
+	if _, proxyErr := parseTrustedProxies(settings.TrustedProxies); proxyErr != nil {
+		problem(proxyErr.Error())
+	}
+
+	// SQL features
+	if settings.EnableSqlFeatures {
+		if settings.SqlDriver != "" && settings.SqlDriver != database.DriverMySQL && settings.SqlDriver != database.DriverPostgres &&
+			settings.SqlDriver != database.DriverSQLite {
+			problem("SqlDriver must be \"mysql\", \"postgres\", or \"sqlite\"")
+		} else if settings.SqlDriver == database.DriverSQLite && settings.SqlDatabase == "" {
+			problem("SqlDatabase is required for the SQL features")
+		} else if settings.SqlDriver != database.DriverSQLite && (settings.SqlIP == "" || settings.SqlPort < 1 ||
+			(settings.SqlProtocol == "" && settings.SqlDriver != database.DriverPostgres) ||
+			settings.SqlUser == "" || settings.SqlPassword == "" || settings.SqlDatabase == "") {
+			problem("SqlIP, SqlPort, SqlProtocol, SqlUser, SqlPassword, and SqlDatabase are required for the SQL features")
+		} else if settings.SqlDriver != database.DriverSQLite && !settings.Handler && settings.SqlPort == settings.Port &&
+			sameHost(settings.SqlIP, settings.IP) {
+			problem("SqlPort and Port cannot be the same port on the same host")
+		}
+		if settings.SqlWriteWorkers < 0 || settings.SqlWriteBatchSize < 0 || settings.SqlWriteFlushInterval < 0 || settings.SqlWriteQueueSize < 0 {
+			problem("SqlWriteWorkers, SqlWriteBatchSize, SqlWriteFlushInterval and SqlWriteQueueSize cannot be negative")
+		}
+		if settings.EncryptionCost != 0 && (settings.EncryptionCost < 4 || settings.EncryptionCost > 31) {
+			problem("EncryptionCost must be from 4 to 31")
+		}
+	}
+
+	// Recovery
+	if settings.EnableRecovery {
+		if settings.RecoveryLocation == "" {
+			problem("RecoveryLocation is required for server recovery")
+		} else if _, err := os.Stat(settings.RecoveryLocation); err != nil {
+			problem("RecoveryLocation error: " + err.Error())
+		} else {
+			var d []byte
+			if err := ioutil.WriteFile(settings.RecoveryLocation+"/test.txt", d, 0644); err != nil {
+				problem("RecoveryLocation is not writable: " + err.Error())
+			}
+			os.Remove(settings.RecoveryLocation + "/test.txt")
+		}
+	}
+
+	// Admin Tools
+	if settings.EnableAdminTools && (settings.AdminLogin == "" || settings.AdminPassword == "") {
+		problem("AdminLogin and AdminPassword are required for the Admin Tools")
+	} else if settings.EnableRemoteAdmin && !settings.EnableAdminTools {
+		problem("EnableRemoteAdmin requires EnableAdminTools, with an AdminLogin and AdminPassword")
+	}
+
+	// The ports must be free, unless this server is already listening on them
+	stoppingMux.Lock()
+	running := serverRunning
+	stoppingMux.Unlock()
+	if !settings.Handler && !running && settings.Port > 0 && settings.Port <= 65535 {
+		addresses, err := settings.listenAddresses()
+		if err != nil {
+			problem(err.Error())
+		}
+		if settings.AutoCert && settings.Port != 80 {
+			addresses = append(addresses, net.JoinHostPort(settings.IP, "80"))
+		}
+		if settings.RESTAuthToken != "" && settings.restSeparate() && settings.RESTPort <= 65535 {
+			restAddresses, _ := settings.restAddresses()
+			addresses = append(addresses, restAddresses...)
+		}
+		if listeners, err := listen(addresses); err != nil {
+			problem(err.Error())
+		} else {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return &SettingsError{Problems: problems}
+	}
+	return nil
+}
+
+// sameHost checks if two IP addresses or host names from ServerSettings are the same machine.
+func sameHost(a string, b string) bool {
+	local := func(host string) bool {
+		if host == "" || host == "localhost" {
+			return true
+		}
+		ip := net.ParseIP(host)
+		return ip != nil && (ip.IsLoopback() || ip.IsUnspecified())
+	}
+	return a == b || (local(a) && local(b))
+}
@@ -0,0 +1,155 @@
+package gopher
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	file := `{
+		"ServerName": "!server!",
+		"HostName": "localhost",
+		"IP": "localhost",
+		"Port": 8080,
+		"PingInterval": "30s",
+		"PongTimeout": 5000000000,
+		"TrustedProxies": ["10.0.0.0/8"],
+		"SqlPassword": "${GOPHER_TEST_SQL_PASSWORD}"
+	}`
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The environment variable must be set
+	if _, err := LoadSettings(path); err == nil || !strings.Contains(err.Error(), "GOPHER_TEST_SQL_PASSWORD") {
+		t.Error("Expected an error for the missing environment variable, got", err)
+	}
+	t.Setenv("GOPHER_TEST_SQL_PASSWORD", "secret")
+	s, err := LoadSettings(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ServerName != "!server!" || s.Port != 8080 || s.SqlPassword != "secret" || len(s.TrustedProxies) != 1 {
+		t.Error("Unexpected settings", s)
+	}
+	if s.PingInterval != time.Second*30 || s.PongTimeout != time.Second*5 {
+		t.Error("Expected durations of 30s and 5s, got", s.PingInterval, s.PongTimeout)
+	}
+
+	// Unknown keys are errors, so typos don't go unnoticed
+	if err := ioutil.WriteFile(path, []byte(`{"ServerNme": "!server!"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadSettings(path); err == nil {
+		t.Error("Expected an error for an unknown key")
+	}
+}
+
+func TestValidateSettings(t *testing.T) {
+	s := &ServerSettings{
+		ServerName: "!server!",
+		HostName:   "localhost",
+		Handler:    true,
+	}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every problem is listed, not just the first one
+	s.Handler = false
+	s.IP = "localhost"
+	s.Port = 8080
+	s.TLS = true
+	s.CertFile = filepath.Join(t.TempDir(), "missing.crt")
+	s.PrivKeyFile = s.CertFile
+	s.EnableSqlFeatures = true
+	s.SqlDriver = "sqlite"
+	s.SqlDatabase = "test.db"
+	s.EncryptionCost = 32
+	s.EnableRemoteAdmin = true
+	s.PingInterval = -1
+	err := s.Validate()
+	if err == nil {
+		t.Fatal("Expected the settings to be invalid")
+	}
+	problems := err.(*SettingsError).Problems
+	for _, want := range []string{"TLS file", "EncryptionCost", "EnableRemoteAdmin", "PingInterval"} {
+		found := false
+		for _, problem := range problems {
+			found = found || strings.Contains(problem, want)
+		}
+		if !found {
+			t.Error("Expected a problem with", want, "in", problems)
+		}
+	}
+}
+
+func TestValidateEndpointAndBuffers(t *testing.T) {
+	// valid makes settings that pass Validate(), for a test to break
+	valid := func() *ServerSettings {
+		return &ServerSettings{ServerName: "!server!", HostName: "localhost", Handler: true}
+	}
+	for _, path := range []string{"/game/ws", "/"} {
+		s := valid()
+		s.EndpointPath = path
+		if err := s.Validate(); err != nil {
+			t.Errorf("Expected the EndpointPath %q to be valid, got %v", path, err)
+		}
+	}
+	for _, path := range []string{"ws", "game/ws", " /ws"} {
+		s := valid()
+		s.EndpointPath = path
+		if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "EndpointPath") {
+			t.Errorf("Expected the EndpointPath %q to be rejected, got %v", path, err)
+		}
+	}
+	s := valid()
+	s.ReadBufferSize = -1
+	if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "ReadBufferSize") {
+		t.Error("Expected a negative ReadBufferSize to be rejected, got", err)
+	}
+	s = valid()
+	s.WriteBufferSize = -1
+	if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "WriteBufferSize") {
+		t.Error("Expected a negative WriteBufferSize to be rejected, got", err)
+	}
+}
+
+func TestValidateAutoCert(t *testing.T) {
+	s := &ServerSettings{
+		ServerName:  "!server!",
+		HostName:    "https://example.com",
+		HostAlias:   "https://www.example.com",
+		IP:          "localhost",
+		Port:        8443,
+		AutoCert:    true,
+		CertFile:    "server.crt",
+		PrivKeyFile: "server.key",
+	}
+	if hosts := s.autoCertHosts(); len(hosts) != 2 || hosts[0] != "example.com" || hosts[1] != "www.example.com" {
+		t.Error("Expected the hosts of HostName and HostAlias, got", hosts)
+	}
+	err := s.Validate()
+	if err == nil {
+		t.Fatal("Expected the settings to be invalid")
+	}
+	problems := strings.Join(err.(*SettingsError).Problems, "; ")
+	if !strings.Contains(problems, "CertFile and PrivKeyFile") || !strings.Contains(problems, "AutoCertCacheDir") {
+		t.Error("Expected problems with the cert files and AutoCertCacheDir, got", problems)
+	} else if strings.Contains(problems, "TLS file") {
+		t.Error("The cert files shouldn't be checked with AutoCert, got", problems)
+	}
+	if s.endpoint() != "/wss" {
+		t.Error("AutoCert should imply TLS, got the endpoint", s.endpoint())
+	}
+
+	// HostName can't be used for the hosts when it isn't a domain name
+	s.HostName, s.HostAlias = "localhost", ""
+	if err := s.Validate(); err == nil || !strings.Contains(err.Error(), "AutoCertHosts") {
+		t.Error("Expected a problem with AutoCertHosts, got", err)
+	}
+}
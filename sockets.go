@@ -1,72 +1,328 @@
 package gopher
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/gorilla/websocket"
 	"github.com/hewiefreeman/GopherGameServer/core"
 	"github.com/hewiefreeman/GopherGameServer/helpers"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
-	conns connections = connections{}
+	conns connections = connections{sockets: make(map[*websocket.Conn]*socketInfo)}
+
+	actionsWaitGroup sync.WaitGroup // TRACKS CLIENT ACTIONS BEING PROCESSED FOR ShutDown()
+
+	upgrader websocket.Upgrader
+
+	errHandshake   = errors.New("Client did not complete the device tag handshake")
+	errMalformed   = errors.New("Client sent too many malformed messages")
+	errActionPanic = errors.New("Client action panicked")
+
+	// CLIENT ACTIONS RECORDED IN ROOM EVENT LOGS WITHOUT THEIR PARAMETERS, WHICH HAVE PASSWORDS OR ACCOUNT INFO
+	unrecordedParams = map[string]bool{
+		helpers.ClientActionSignup: true, helpers.ClientActionDeleteAccount: true, helpers.ClientActionChangePassword: true,
+		helpers.ClientActionChangeAccountInfo: true, helpers.ClientActionLogin: true, helpers.ClientActionAdminLogin: true,
+		helpers.ClientActionAdminAction: true,
+	}
+)
+
+const (
+	errorMalformed = "Malformed request"
+
+	defaultMaxMessageSize       = 1 << 20
+	defaultMaxMalformedMessages = 5
+	defaultReconnectBufferSize  = 100
+	defaultOutboundQueueSize    = 256
+	defaultWriteTimeout         = time.Second * 10
+	defaultMaxWatches           = 50
+	defaultMaxSavedGameSize     = 64 * 1024
+	defaultEventLogMaxSize      = 10 << 20
+
+	// CLIENTS CONNECT WITH ?format=msgpack TO USE MessagePack
+	messagePackQuery = "msgpack"
+
+	// CLIENTS ON A PROTOCOL VERSION THE SERVER DOESN'T ALLOW ARE CLOSED WITH THIS
+	closeClientOutdated = "Client outdated"
 )
 
 type connections struct {
-	conns    int
+	conns    int64 // ATOMIC, SO IT CAN BE READ WITHOUT LOCKING connsMux
+	sockets  map[*websocket.Conn]*socketInfo
 	connsMux sync.Mutex
 }
 
+// socketInfo is what the server knows about a connected socket. connsMux locks admin.
+type socketInfo struct {
+	ip     string
+	origin string
+	admin  bool
+}
+
 type clientAction struct {
 	A string      // action
 	P interface{} // parameters
 }
 
-func socketInitializer(w http.ResponseWriter, r *http.Request) {
-	//DECLINE CONNECTIONS COMING FROM OUTSIDE THE ORIGIN SERVER
-	if settings.OriginOnly {
-		origin := r.Header.Get("Origin") + ":" + strconv.Itoa(settings.Port)
-		host := settings.HostName + ":" + strconv.Itoa(settings.Port)
-		hostAlias := settings.HostAlias + ":" + strconv.Itoa(settings.Port)
-		if origin != host && (settings.HostAlias != "" && origin != hostAlias) {
-			http.Error(w, "Origin not allowed.", http.StatusForbidden)
-			return
+// messageReader is the part of a *websocket.Conn that client actions are read from
+type messageReader interface {
+	ReadMessage() (int, []byte, error)
+}
+
+// malformedAction is returned by readClientAction for a message that isn't a client action. The connection is still usable.
+type malformedAction struct {
+	field       string // the field that failed, or "" when the message isn't JSON
+	messagePack bool   // the message wasn't MessagePack, from a MessagePack client
+}
+
+func (m *malformedAction) Error() string {
+	if m.messagePack {
+		return errorMalformed + ", invalid MessagePack"
+	} else if m.field == "" {
+		return errorMalformed + ", invalid JSON"
+	}
+	return errorMalformed + ", incorrect field '" + m.field + "'"
+}
+
+// readClientAction reads the next client action from a connection in its wire format. Voice frames are returned as they are instead:
+// binary messages from JSON clients, and bins from MessagePack clients. A message that isn't a client action returns a
+// *malformedAction error, along with the action's name if it could be read. Any other error means the connection failed.
+func readClientAction(conn messageReader, format int) (clientAction, []byte, error) {
+	var action clientAction
+	messageType, message, err := conn.ReadMessage()
+	if err != nil {
+		return action, nil, err
+	} else if format == helpers.FormatJSON && messageType == websocket.BinaryMessage {
+		return action, message, nil
+	} else if format == helpers.FormatMessagePack {
+		if frame, isFrame := voiceFrameBin(messageType, message); isFrame {
+			return action, frame, nil
+		} else if message, err = helpers.ClientJSON(format, messageType, message); err != nil {
+			return action, nil, &malformedAction{messagePack: true}
+		}
+	}
+	if jsonErr := json.Unmarshal(message, &action); jsonErr != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(jsonErr, &typeErr) {
+			return clientAction{}, nil, &malformedAction{field: typeErr.Field}
 		}
+		return clientAction{}, nil, &malformedAction{}
+	} else if action.A == "" {
+		return action, nil, &malformedAction{field: "A"}
+	}
+	return action, nil, nil
+}
+
+// voiceFrameBin gets the voice frame in a message from a MessagePack client, which is a MessagePack bin. Returns false for
+// any other message.
+func voiceFrameBin(messageType int, message []byte) ([]byte, bool) {
+	if messageType != websocket.BinaryMessage || len(message) == 0 || message[0] < 0xc4 || message[0] > 0xc6 {
+		return nil, false
+	}
+	frame, err := helpers.UnmarshalMessagePack(message)
+	if err != nil {
+		return nil, false
+	}
+	return frame.([]byte), true
+}
+
+func socketInitializer(w http.ResponseWriter, r *http.Request) {
+	//DECLINE CONNECTIONS WHILE SHUTTING DOWN
+	if isStopping() {
+		http.Error(w, "Server is shutting down.", http.StatusServiceUnavailable)
+		return
+	}
+
+	//DECLINE CONNECTIONS WHILE PAUSED
+	if isPaused() {
+		http.Error(w, "Server is paused.", http.StatusServiceUnavailable)
+		return
+	}
+
+	//DECLINE CONNECTIONS COMING FROM ORIGINS THAT AREN'T ALLOWED
+	if !checkOrigin(r) {
+		http.Error(w, "Origin not allowed.", http.StatusForbidden)
+		return
+	}
+
+	//DECLINE BANNED IP ADDRESSES
+	ip := clientIP(r)
+	if IsBannedIP(ip) {
+		http.Error(w, "Could not establish a connection.", http.StatusForbidden)
+		return
 	}
 
 	//REJECT IF SERVER IS FULL
 	if !conns.add() {
-		http.Error(w, "Server is full.", 413)
+		connectionError(w, clientLocale(r), http.StatusServiceUnavailable, helpers.NewError(errorServerFull, helpers.ErrorServerFull))
 		return
 	}
 
+	// CHECK THE CLIENT'S PROTOCOL VERSION - OUTDATED CLIENTS ARE STILL UPGRADED, SO THEY CAN BE TOLD TO UPDATE
+	version, subprotocol := clientVersion(r)
+	versionAllowed := clientVersionAllowed(version)
+
 	// CLIENT CONNECT CALLBACK
-	if clientConnectCallback != nil && !clientConnectCallback(&w, r) {
-		http.Error(w, "Could not establish a connection.", http.StatusForbidden)
-		return
+	var metadata map[string]interface{}
+	if versionAllowed && clientConnectCallback != nil {
+		var accept bool
+		if accept, metadata = clientConnectCallback(&w, r); !accept {
+			conns.subtract()
+			http.Error(w, "Could not establish a connection.", http.StatusForbidden)
+			return
+		}
 	}
 
-	//UPGRADE CONNECTION PING-PONG
-	conn, err := websocket.Upgrade(w, r, w.Header(), 1024, 1024)
+	//UPGRADE CONNECTION PING-PONG - Upgrade() RESPONDS TO THE CLIENT ON FAILURE
+	if subprotocol != "" {
+		w.Header().Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	conn, err := upgrader.Upgrade(w, r, w.Header())
 	if err != nil {
-		http.Error(w, "Could not establish a connection.", http.StatusForbidden)
+		conns.subtract()
+		return
+	}
+
+	// THE CLIENT CAN ASK FOR MessagePack INSTEAD OF JSON
+	if (*settings).EnableMessagePack && r.URL.Query().Get("format") == messagePackQuery {
+		helpers.SetSocketFormat(conn, helpers.FormatMessagePack)
+	}
+
+	if !versionAllowed {
+		clientOutdated(conn, ip, version)
 		return
 	}
 
+	// MESSAGES TO THE CLIENT ARE QUEUED, AND WRITTEN BY THEIR OWN GOROUTINE
+	queueSize, writeTimeout := (*settings).OutboundQueueSize, (*settings).WriteTimeout
+	if queueSize == 0 {
+		queueSize = defaultOutboundQueueSize
+	}
+	if writeTimeout == 0 {
+		writeTimeout = defaultWriteTimeout
+	}
+	helpers.StartWriter(conn, queueSize, writeTimeout)
+
+	// KEEP THE IP AND METADATA FOR THE User THE CLIENT LOGS IN AS
+	core.SetConnInfo(conn, ip, version, clientLocale(r), metadata)
+
 	// START WEBSOCKET LOOP
-	go clientActionListener(conn)
+	helpers.Log().Debug("Client connected", "ip", ip)
+	conns.track(conn, &socketInfo{ip: ip, origin: r.Header.Get("Origin")})
+	go clientActionListener(conn, ip, r.URL.Query().Get("resume"))
+}
+
+// clientVersion gets the protocol version a client connected with, from its Sec-WebSocket-Protocol or the "version" in its URL,
+// and the subprotocol to accept, if it asked for one. Clients that don't send a version speak helpers.LegacyProtocolVersion.
+func clientVersion(r *http.Request) (string, string) {
+	for _, subprotocol := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(subprotocol, helpers.ProtocolPrefix) {
+			return strings.TrimPrefix(subprotocol, helpers.ProtocolPrefix), subprotocol
+		}
+	}
+	if version := r.URL.Query().Get("version"); version != "" {
+		return version, ""
+	}
+	return helpers.LegacyProtocolVersion, ""
+}
+
+// clientLocale gets the locale a client connected with, from the "locale" in its URL, like "/ws?locale=es", or else the first
+// language in its Accept-Language header. Returns "" when it has neither.
+func clientLocale(r *http.Request) string {
+	if locale := r.URL.Query().Get("locale"); locale != "" {
+		return helpers.NormalizeLocale(locale)
+	}
+	language := r.Header.Get("Accept-Language")
+	if end := strings.IndexAny(language, ",;"); end != -1 {
+		language = language[:end]
+	}
+	if language == "*" {
+		return ""
+	}
+	return helpers.NormalizeLocale(language)
+}
+
+// clientVersionAllowed checks a client's protocol version with the client version callback when there is one. Otherwise, the
+// version must be at least MinClientVersion in ServerSettings.
+func clientVersionAllowed(version string) bool {
+	if clientVersionCallback != nil {
+		return clientVersionCallback(version)
+	}
+	compared, ok := helpers.CompareVersions(version, minClientVersion())
+	return ok && compared >= 0
+}
+
+// minClientVersion gets the oldest protocol version clients can connect with, without a client version callback.
+func minClientVersion() string {
+	if minVersion := (*settings).MinClientVersion; minVersion != "" {
+		if compared, _ := helpers.CompareVersions(minVersion, helpers.LegacyProtocolVersion); compared > 0 {
+			return minVersion
+		}
+	}
+	return helpers.LegacyProtocolVersion
+}
+
+// clientOutdated tells a client its protocol version isn't allowed with a ServerActionClientOutdated message, with the oldest
+// version it can connect with and where to get an update, then closes its socket.
+func clientOutdated(conn *websocket.Conn, ip string, version string) {
+	helpers.Log().Debug("Client outdated", "ip", ip, "version", version)
+	outdated := map[string]interface{}{
+		"v": version,
+		"m": minClientVersion(),
+	}
+	if (*settings).ClientUpdateURL != "" {
+		outdated["u"] = (*settings).ClientUpdateURL
+	}
+	helpers.WriteMessage(conn, map[string]interface{}{
+		helpers.ServerActionClientOutdated: outdated,
+	})
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, closeClientOutdated),
+		time.Now().Add(time.Second*1))
+	conn.Close()
+	conns.subtract()
+	helpers.ForgetSocket(conn)
 }
 
-func clientActionListener(conn *websocket.Conn) {
+func clientActionListener(conn *websocket.Conn, ip string, resumeToken string) {
+	// LIMIT THE SIZE OF CLIENT MESSAGES - BIGGER ONES CLOSE THE CONNECTION WITH websocket.CloseMessageTooBig
+	maxSize := (*settings).MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxMessageSize
+	}
+	conn.SetReadLimit(maxSize)
+
+	// START KEEPALIVE PINGS
+	stopPings := keepAlive(conn)
+	defer close(stopPings)
+
 	// CLIENT ACTION INPUT
 	var action clientAction
+	format := helpers.SocketFormat(conn)
 
 	var clientMux sync.Mutex // LOCKS user AND connID
 	var user *core.User      // THE CLIENT'S User OBJECT
 	var connID string        // CLIENT SESSION ID
 
+	// DISCONNECT THE CLIENT WHEN THE LISTENER ENDS
+	var closeErr error
+	defer func() {
+		// A CLIENT THE WRITER DROPPED IS DISCONNECTED WITH THE WRITER'S REASON, NOT THE READ ERROR FROM ITS CLOSED SOCKET
+		if writerErr := helpers.WriterError(conn); writerErr != nil {
+			closeErr = writerErr
+		}
+		clientDisconnected(conn, ip, &user, connID, &clientMux, closeErr)
+	}()
+
+	// THE CLIENT'S RATE LIMITS
+	limits := newConnLimits(ip)
+
 	// THE CLIENT'S AUTOLOG INFO
 	var deviceTag string
 	var devicePass string
@@ -77,9 +333,9 @@ func clientActionListener(conn *websocket.Conn) {
 		tagMessage := map[string]interface{}{
 			helpers.ServerActionRequestDeviceTag: nil,
 		}
-		writeErr := conn.WriteJSON(tagMessage)
+		writeErr := helpers.WriteMessage(conn, tagMessage)
 		if writeErr != nil {
-			closeSocket(conn)
+			closeErr = writeErr
 			return
 		}
 		//PARAMS
@@ -90,9 +346,9 @@ func clientActionListener(conn *websocket.Conn) {
 		//PING-PONG FOR TAGGING DEVICE - BREAKS WHEN THE DEVICE HAS BEEN PROPERLY TAGGED OR AUTHENTICATED.
 		for {
 			//READ INPUT BUFFER
-			readErr := conn.ReadJSON(&action)
+			readErr := helpers.ReadMessage(conn, &action)
 			if readErr != nil || action.A == "" {
-				closeSocket(conn)
+				closeErr = readErr
 				return
 			}
 
@@ -101,16 +357,16 @@ func clientActionListener(conn *websocket.Conn) {
 				//NO DEVICE TAG. MAKE ONE AND SEND IT.
 				newDeviceTag, newDeviceTagErr := helpers.GenerateSecureString(32)
 				if newDeviceTagErr != nil {
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 				deviceTag = string(newDeviceTag)
 				tagMessage := map[string]interface{}{
 					helpers.ServerActionSetDeviceTag: deviceTag,
 				}
-				writeErr := conn.WriteJSON(tagMessage)
+				writeErr := helpers.WriteMessage(conn, tagMessage)
 				if writeErr != nil {
-					closeSocket(conn)
+					closeErr = writeErr
 					return
 				}
 			} else if action.A == "1" {
@@ -118,20 +374,20 @@ func clientActionListener(conn *websocket.Conn) {
 				if sentDeviceTag, ohK := action.P.(string); ohK {
 					if len(deviceTag) > 0 && sentDeviceTag != deviceTag {
 						//CLIENT DIDN'T USE THE PROVIDED DEVICE CODE FROM THE SERVER
-						closeSocket(conn)
+						closeErr = errHandshake
 						return
 					}
 					//SEND AUTO-LOG NOT FILED MESSAGE
 					notFiledMessage := map[string]interface{}{
 						helpers.ServerActionAutoLoginNotFiled: nil,
 					}
-					writeErr := conn.WriteJSON(notFiledMessage)
+					writeErr := helpers.WriteMessage(conn, notFiledMessage)
 					if writeErr != nil {
-						closeSocket(conn)
+						closeErr = writeErr
 						return
 					}
 				} else {
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 
@@ -143,46 +399,46 @@ func clientActionListener(conn *websocket.Conn) {
 				var pMap map[string]interface{}
 				devicePass, err = helpers.GenerateSecureString(32)
 				if err != nil {
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 				//GET PARAMS
 				if pMap, ok = action.P.(map[string]interface{}); !ok {
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 				if deviceTag, ok = pMap["dt"].(string); !ok {
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 				if oldPass, ok = pMap["da"].(string); !ok {
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 				var deviceUserIDStr string
 				if deviceUserIDStr, ok = pMap["di"].(string); !ok {
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 				//CONVERT di TO INT
 				deviceUserID, err = strconv.Atoi(deviceUserIDStr)
 				if err != nil {
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 				//CHANGE THE CLIENT'S PASS
 				newPassMessage := map[string]interface{}{
 					helpers.ServerActionSetAutoLoginPass: devicePass,
 				}
-				writeErr := conn.WriteJSON(newPassMessage)
+				writeErr := helpers.WriteMessage(conn, newPassMessage)
 				if writeErr != nil {
-					closeSocket(conn)
+					closeErr = writeErr
 					return
 				}
 			} else if action.A == "3" {
 				if deviceTag == "" || oldPass == "" || deviceUserID == 0 || devicePass == "" {
 					//IRRESPONSIBLE USAGE
-					closeSocket(conn)
+					closeErr = errHandshake
 					return
 				}
 				//AUTO-LOG THE CLIENT
@@ -191,21 +447,18 @@ func clientActionListener(conn *websocket.Conn) {
 					//ERROR AUTO-LOGGING - RUN AUTOLOGCOMPLETE AND DELETE KEYS FOR CLIENT, AND SILENTLY CHANGE DEVICE TAG
 					newTag, newTagErr := helpers.GenerateSecureString(32)
 					if newTagErr != nil {
-						closeSocket(conn)
+						closeErr = errHandshake
 						return
 					}
 					autologMessage := map[string]map[string]interface{}{
 						helpers.ServerActionAutoLoginFailed: {
 							"dt": newTag,
-							"e": map[string]interface{}{
-								"m":  gErr.Message,
-								"id": gErr.ID,
-							},
+							"e":  helpers.LocalizedErrorObject(gErr, core.ConnLocale(conn)),
 						},
 					}
-					writeErr := conn.WriteJSON(autologMessage)
+					writeErr := helpers.WriteMessage(conn, autologMessage)
 					if writeErr != nil {
-						closeSocket(conn)
+						closeErr = writeErr
 						return
 					}
 					devicePass = ""
@@ -220,77 +473,449 @@ func clientActionListener(conn *websocket.Conn) {
 		}
 	}
 
+	//RECONNECT A CLIENT THAT DROPPED OUT, OR RESUME THE CLIENT'S SESSION FROM BEFORE A RESTART
+	if resumeToken != "" {
+		clientMux.Lock()
+		loggedIn := user != nil
+		clientMux.Unlock()
+		if !loggedIn {
+			if reconnectedID, ok := core.Reconnect(resumeToken, conn, &user, &clientMux); ok {
+				connID = reconnectedID
+			} else if resumedID, ok := core.ResumeSession(resumeToken, conn, &user, &clientMux); ok {
+				connID = resumedID
+			}
+		}
+	}
+
 	//STANDARD CONNECTION LOOP
+	maxMalformed := (*settings).MaxMalformedMessages
+	if maxMalformed <= 0 {
+		maxMalformed = defaultMaxMalformedMessages
+	}
+	var malformedCount int
 	for {
 		//READ INPUT BUFFER
-		readErr := conn.ReadJSON(&action)
-		if readErr != nil || action.A == "" {
+		var readErr error
+		var voiceFrame []byte
+		action, voiceFrame, readErr = readClientAction(conn, format)
+		if malformed, ok := readErr.(*malformedAction); ok {
+			messageRate.add(time.Now())
+			//TELL THE CLIENT WHAT WAS WRONG - DISCONNECT CLIENTS THAT KEEP SENDING GARBAGE
+			if malformedCount++; malformedCount > maxMalformed {
+				closeErr = errMalformed
+				return
+			}
+			extendDeadline(conn)
+			if writeErr := helpers.WriteMessage(conn, helpers.MakeMalformedResponse(action.A, malformed.Error(), malformed.field, core.ConnLocale(conn))); writeErr != nil {
+				closeErr = writeErr
+				return
+			}
+			continue
+		} else if readErr != nil {
 			//DISCONNECT USER
-			clientMux.Lock()
-			sockedDropped(user, connID, &clientMux)
-			closeSocket(conn)
+			closeErr = readErr
 			return
 		}
+		messageRate.add(time.Now())
+		extendDeadline(conn)
+		clientMux.Lock()
+		if user != nil {
+			user.UpdateLastSeen()
+		}
+		voiceUser, voiceConnID := user, connID
+		clientMux.Unlock()
+
+		//RELAY VOICE FRAMES - THEY DON'T COUNT AS ACTIONS, BUT HAVE THEIR OWN BYTE RATE
+		if voiceFrame != nil {
+			if voiceUser != nil && limits.checkVoice(len(voiceFrame), time.Now()) {
+				if room := voiceUser.RoomIn(voiceConnID); room != nil {
+					room.RelayVoice(voiceUser.Name(), voiceFrame)
+				}
+			}
+			continue
+		}
+
+		//RATE LIMIT - DISCONNECT CLIENTS THAT KEEP GOING
+		now := time.Now()
+		if ok, retryAfter := limits.check(action, now); !ok {
+			if limits.strike(now) {
+				closeErr = errRateLimited
+				return
+			}
+			if writeErr := helpers.WriteMessage(conn, helpers.MakeRateLimitResponse(action.A, errorRateLimited, retryAfter, core.ConnLocale(conn))); writeErr != nil {
+				closeErr = writeErr
+				return
+			}
+			continue
+		}
 
-		//TAKE ACTION
-		responseVal, respond, actionErr := clientActionHandler(action, &user, conn, &deviceTag, &devicePass, &deviceUserID, &connID, &clientMux)
+		//TAKE ACTION - IGNORE NEW ACTIONS WHILE SHUTTING DOWN
+		if !startAction() {
+			continue
+		}
+		responseVal, respond, actionErr, panicErr := runClientAction(action, ip, &user, conn, &deviceTag, &devicePass, &deviceUserID, &connID, &clientMux)
+		if panicErr != nil {
+			//TELL THE CLIENT, THEN DISCONNECT THEM - THE ACTION MAY HAVE LEFT THEIR STATE HALF CHANGED
+			helpers.WriteMessage(conn, helpers.LocalizedClientResponse(action.A, nil, helpers.NewError(errorActionFailed, helpers.ErrorActionFailed),
+				core.ConnLocale(conn)))
+			closeErr = panicErr
+			return
+		}
 
 		if respond {
 			//SEND RESPONSE
-			if writeErr := conn.WriteJSON(helpers.MakeClientResponse(action.A, responseVal, actionErr)); writeErr != nil {
+			response := helpers.LocalizedClientResponse(action.A, responseVal, actionErr, core.ConnLocale(conn))
+			if writeErr := helpers.WriteMessage(conn, response); writeErr != nil {
 				//DISCONNECT USER
-				clientMux.Lock()
-				sockedDropped(user, connID, &clientMux)
-				closeSocket(conn)
+				closeErr = writeErr
+				return
+			}
+		}
+	}
+}
+
+// runClientAction handles a client action. A panic while handling it, like in one of your callbacks, is logged with the client's
+// context and returned as an error, so the client can be disconnected instead of crashing the server. With an ActionTimeout in
+// ServerSettings, an action that runs out of time gets an ErrorTimeout response.
+func runClientAction(action clientAction, ip string, user **core.User, conn *websocket.Conn, deviceTag *string, devicePass *string,
+	deviceUserID *int, connID *string, clientMux *sync.Mutex) (responseVal interface{}, respond bool, actionErr helpers.GopherError, panicErr error) {
+	defer actionsWaitGroup.Done()
+	start := time.Now()
+	defer func() {
+		observeAction(actionStatName(action), time.Since(start))
+		if (action.A == helpers.ClientActionLogin || action.A == helpers.ClientActionGuestLogin) && (panicErr != nil || actionErr.ID != 0) {
+			atomic.AddUint64(&loginFailures, 1)
+		}
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			// THE PANIC COULD HAVE HAPPENED WHILE clientMux WAS LOCKED
+			var userName string
+			if clientMux.TryLock() {
+				if *user != nil {
+					userName = (*user).Name()
+				}
+				clientMux.Unlock()
+			}
+			helpers.Recovered("client action "+action.A, r, "user", userName, "ip", ip)
+			panicErr = errActionPanic
+		}
+	}()
+	recordClientAction(action, user, connID, clientMux)
+	ctx := context.Background()
+	if (*settings).ActionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, (*settings).ActionTimeout)
+		defer cancel()
+	}
+	responseVal, respond, actionErr = clientActionMiddleware(ctx, action, user, conn, deviceTag, devicePass, deviceUserID, connID, clientMux)
+	if actionErr.ID != 0 && ctx.Err() != nil {
+		// WHATEVER WENT WRONG, IT'S BECAUSE THE ACTION RAN OUT OF TIME
+		helpers.Log().Warn("Client action timed out", "action", action.A, "ip", ip)
+		actionErr = helpers.NewError(errorTimedOut, helpers.ErrorTimeout)
+	}
+	return
+}
+
+// recordClientAction records a client action in the event log of the client's Room, when its RoomType records events.
+func recordClientAction(action clientAction, user **core.User, connID *string, clientMux *sync.Mutex) {
+	if action.A == helpers.ClientActionVoiceStream {
+		return
+	}
+	clientMux.Lock()
+	u, id := *user, *connID
+	clientMux.Unlock()
+	if u == nil {
+		return
+	}
+	room := u.RoomIn(id)
+	if room == nil || !core.GetRoomTypes()[room.Type()].RecordsEvents() {
+		return
+	}
+	params := action.P
+	if unrecordedParams[action.A] {
+		params = nil
+	}
+	room.RecordEvent(core.EventClientAction, u.Name(), map[string]interface{}{"a": action.A, "p": params})
+}
+
+// keepAlive pings the client every PingInterval, and disconnects them when they don't respond within the PongTimeout.
+// Close the returned channel to stop pinging.
+func keepAlive(conn *websocket.Conn) chan bool {
+	stop := make(chan bool)
+	if (*settings).PingInterval <= 0 {
+		return stop
+	}
+	extendDeadline(conn)
+	conn.SetPongHandler(func(string) error {
+		extendDeadline(conn)
+		return nil
+	})
+	go func() {
+		ticker := time.NewTicker((*settings).PingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := helpers.WritePing(conn); err != nil {
+					return
+				}
+			case <-stop:
 				return
 			}
 		}
+	}()
+	return stop
+}
 
-		//
-		action = clientAction{}
+// extendDeadline gives the client until PingInterval + PongTimeout from now to send something. When they don't,
+// the connection's read fails and the client is disconnected.
+func extendDeadline(conn *websocket.Conn) {
+	if (*settings).PingInterval <= 0 {
+		return
+	}
+	timeout := (*settings).PongTimeout
+	if timeout <= 0 {
+		timeout = (*settings).PingInterval
 	}
+	conn.SetReadDeadline(time.Now().Add((*settings).PingInterval + timeout))
+}
+
+func makeUpgrader() websocket.Upgrader {
+	readSize, writeSize := (*settings).ReadBufferSize, (*settings).WriteBufferSize
+	if readSize == 0 {
+		readSize = 1024
+	}
+	if writeSize == 0 {
+		writeSize = 1024
+	}
+	return websocket.Upgrader{
+		ReadBufferSize:    readSize,
+		WriteBufferSize:   writeSize,
+		EnableCompression: (*settings).EnableCompression,
+		CheckOrigin:       checkOrigin,
+	}
+}
+
+func checkOrigin(r *http.Request) bool {
+	if len((*settings).AllowedOrigins) > 0 {
+		origin, ok := r.Header["Origin"]
+		if !ok || len(origin) == 0 {
+			// NOT A BROWSER
+			return true
+		}
+		return originAllowed(origin[0], (*settings).AllowedOrigins)
+	} else if (*settings).OriginOnly {
+		origin := r.Header.Get("Origin") + ":" + strconv.Itoa(settings.Port)
+		host := settings.HostName + ":" + strconv.Itoa(settings.Port)
+		hostAlias := settings.HostAlias + ":" + strconv.Itoa(settings.Port)
+		if origin != host && (settings.HostAlias != "" && origin != hostAlias) {
+			return false
+		}
+	}
+	return true
+}
+
+// originAllowed checks an Origin header against a list of allowed origins. The scheme, host and port must all match, and
+// a "*" in an allowed origin matches one or more sub-domains.
+func originAllowed(origin string, allowed []string) bool {
+	origin = strings.ToLower(origin)
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == origin {
+			return true
+		}
+		star := strings.Index(a, "*")
+		if star == -1 {
+			continue
+		}
+		prefix, suffix := a[:star], a[star+1:]
+		if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+		// THE WILDCARD CAN ONLY MATCH HOST NAME CHARACTERS
+		if !strings.ContainsAny(origin[len(prefix):len(origin)-len(suffix)], "/:@?#") {
+			return true
+		}
+	}
+	return false
+}
+
+// connectionError responds to a connection request that could not be upgraded with a JSON error the client APIs can recognize.
+func connectionError(w http.ResponseWriter, locale string, status int, gErr helpers.GopherError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"e": helpers.LocalizedErrorObject(gErr, locale),
+	})
 }
 
 func closeSocket(conn *websocket.Conn) {
+	helpers.StopWriter(conn)
 	conn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(time.Second*1))
 	conn.Close()
 	conns.subtract()
+	conns.untrack(conn)
+	helpers.ForgetSocket(conn)
+	core.ForgetConnInfo(conn)
+}
+
+// clientDisconnected tears down a client's connection. The client is removed from their Room, the client disconnect
+// callback runs, then the client is logged out and their socket gets closed. With a ReconnectGracePeriod, a logged in client
+// that dropped out is held instead, and only goes through all that if it doesn't reconnect in time.
+func clientDisconnected(conn *websocket.Conn, ip string, user **core.User, connID string, clientMux *sync.Mutex, err error) {
+	clientMux.Lock()
+	u := *user
+	clientMux.Unlock()
+
+	//A CLIENT THAT DROPPED OUT CAN'T BE MATCHED, EVEN IF IT RECONNECTS
+	if u != nil {
+		leaveQueues(u, connID)
+	}
+
+	//GIVE CLIENTS THAT DROPPED OUT A CHANCE TO RECONNECT
+	if u != nil && (*settings).ReconnectGracePeriod > 0 && canReconnect(err) && !isStopping() && !IsBannedIP(ip) {
+		if u.Hold(connID, func() { logOutDisconnected(ip, u, connID, err) }) {
+			helpers.Log().Debug("Client dropped, waiting for a reconnect", "user", u.Name(), "ip", ip, "error", err)
+			closeSocket(conn)
+			return
+		}
+	}
+
+	logOutDisconnected(ip, u, connID, err)
+	closeSocket(conn)
 }
 
-func sockedDropped(user *core.User, connID string, clientMux *sync.Mutex) {
-	if user != nil {
-		//CLIENT WAS LOGGED IN. LOG THEM OUT
-		(*clientMux).Unlock()
-		user.Logout(connID)
+// canReconnect returns true if a client's connection closed with an error that a reconnect could recover from, and not because
+// the client closed it on purpose or the server closed it for misbehaving.
+func canReconnect(err error) bool {
+	if err == nil || err == errHandshake || err == errMalformed || err == errRateLimited || err == errActionPanic {
+		return false
+	}
+	return !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+}
+
+// logOutDisconnected removes a disconnected client from their Room, runs the client disconnect callback, then logs them out.
+func logOutDisconnected(ip string, u *core.User, connID string, err error) {
+	var userName string
+	if u != nil {
+		//CLIENT WAS LOGGED IN. REMOVE THEM FROM THEIR ROOM
+		userName = u.Name()
+		if room := u.RoomIn(connID); room != nil {
+			u.LeaveOnDisconnect(connID)
+		}
+	}
+
+	helpers.Log().Debug("Client disconnected", "user", userName, "ip", ip, "error", err)
+
+	// CLIENT DISCONNECT CALLBACK
+	if clientDisconnectCallback != nil {
+		clientDisconnectCallback(userName, u != nil, err)
+	}
+
+	if u != nil {
+		//LOG THEM OUT
+		u.Logout(connID)
 	}
 }
 
 /////////////////////// HELPERS FOR connections
 
 func (c *connections) add() bool {
+	for {
+		current := atomic.LoadInt64(&c.conns)
+		if (*settings).MaxConnections > 0 && current >= int64((*settings).MaxConnections) {
+			return false
+		} else if atomic.CompareAndSwapInt64(&c.conns, current, current+1) {
+			return true
+		}
+	}
+}
+
+func (c *connections) subtract() {
+	atomic.AddInt64(&c.conns, -1)
+}
+
+func (c *connections) track(conn *websocket.Conn, info *socketInfo) {
 	c.connsMux.Lock()
-	//
-	if (*settings).MaxConnections != 0 && c.conns == (*settings).MaxConnections {
-		c.connsMux.Unlock()
-		return false
+	c.sockets[conn] = info
+	c.connsMux.Unlock()
+}
+
+// info gets what the server knows about a socket, or nil if it isn't connected.
+func (c *connections) info(conn *websocket.Conn) *socketInfo {
+	c.connsMux.Lock()
+	info := c.sockets[conn]
+	c.connsMux.Unlock()
+	return info
+}
+
+func (c *connections) untrack(conn *websocket.Conn) {
+	c.connsMux.Lock()
+	delete(c.sockets, conn)
+	c.connsMux.Unlock()
+}
+
+func (c *connections) snapshot() []*websocket.Conn {
+	c.connsMux.Lock()
+	sockets := make([]*websocket.Conn, 0, len(c.sockets))
+	for conn := range c.sockets {
+		sockets = append(sockets, conn)
 	}
-	c.conns++
 	c.connsMux.Unlock()
-	//
-	return true
+	return sockets
 }
 
-func (c *connections) subtract() {
+func (c *connections) broadcastShutDown() {
+	message := helpers.NewEncoded(map[string]interface{}{
+		helpers.ServerActionShutDown: nil,
+	})
 	c.connsMux.Lock()
-	c.conns--
+	for conn := range c.sockets {
+		helpers.WriteMessage(conn, message)
+	}
 	c.connsMux.Unlock()
 }
 
+func (c *connections) closeAll() {
+	c.connsMux.Lock()
+	for conn := range c.sockets {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "Server shut down"), time.Now().Add(time.Second*1))
+		conn.Close()
+	}
+	c.connsMux.Unlock()
+}
+
+/////////////////////// HELPERS FOR IN-FLIGHT CLIENT ACTIONS
+
+func startAction() bool {
+	stoppingMux.Lock()
+	defer stoppingMux.Unlock()
+	if serverStopping {
+		return false
+	}
+	actionsWaitGroup.Add(1)
+	return true
+}
+
+// waitForActions blocks until all client actions being processed have finished, or the Context is done. Returns false if the
+// Context finished first.
+func waitForActions(ctx context.Context) bool {
+	done := make(chan bool)
+	go func() {
+		actionsWaitGroup.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 // ClientsConnected returns the number of clients connected to the server. Includes connections
 // not logged in as a User. To get the number of Users logged in, use the core.UserCount() function.
 func ClientsConnected() int {
-	conns.connsMux.Lock()
-	c := conns.conns
-	conns.connsMux.Unlock()
-	return c
+	return int(atomic.LoadInt64(&conns.conns))
 }
@@ -0,0 +1,984 @@
+package gopher
+
+import (
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestOriginAllowed(t *testing.T) {
+	allowed := []string{"https://example.com", "https://*.example.com", "http://localhost:8080", "null"}
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://example.com", true},
+		{"HTTPS://Example.com", true},
+		{"https://play.example.com", true},
+		{"https://a.b.example.com", true},
+		{"http://example.com", false},            // scheme mismatch
+		{"https://example.com:8443", false},      // port differs
+		{"https://play.example.com:8443", false}, // port differs on wildcard
+		{"http://localhost:8080", true},
+		{"http://localhost", false},
+		{"http://localhost:9090", false},
+		{"https://evilexample.com", false},
+		{"https://evil.com/.example.com", false},
+		{"null", true},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := originAllowed(test.origin, allowed); got != test.want {
+			t.Errorf("originAllowed(%q) = %v, want %v", test.origin, got, test.want)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	oldSettings := settings
+	defer func() { settings = oldSettings }()
+	settings = &ServerSettings{HostName: "https://example.com", Port: 443, OriginOnly: true,
+		AllowedOrigins: []string{"https://*.example.com"}}
+
+	r := httptest.NewRequest("GET", "/ws", nil)
+	if !checkOrigin(r) {
+		t.Error("Requests without an Origin header should be accepted")
+	}
+	r.Header.Set("Origin", "https://play.example.com")
+	if !checkOrigin(r) {
+		t.Error("AllowedOrigins should override OriginOnly")
+	}
+	r.Header.Set("Origin", "https://example.org")
+	if checkOrigin(r) {
+		t.Error("Origins not in AllowedOrigins should be declined")
+	}
+}
+
+func TestClientDisconnectCallback(t *testing.T) {
+	oldSettings := settings
+	defer func() {
+		settings = oldSettings
+		clientDisconnectCallback = nil
+	}()
+	disconnects := make(chan error, 2)
+	clientDisconnectCallback = func(userName string, wasLoggedIn bool, err error) {
+		if wasLoggedIn || userName != "" {
+			t.Error("The client was not logged in")
+		}
+		disconnects <- err
+	}
+
+	for _, rememberMe := range []bool{false, true} {
+		settings = &ServerSettings{HostName: "localhost", RememberMe: rememberMe}
+		upgrader = makeUpgrader()
+		server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rememberMe {
+			// Close in the middle of the device tag handshake
+			var message map[string]interface{}
+			client.ReadJSON(&message)
+		}
+		client.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+		client.Close()
+
+		select {
+		case err := <-disconnects:
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Error("Expected a normal close error, got", err)
+			}
+		case <-time.After(time.Second * 2):
+			t.Error("The client disconnect callback did not run")
+		}
+		server.Close()
+	}
+
+	select {
+	case <-disconnects:
+		t.Error("The client disconnect callback ran more than once")
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestConnectionCount(t *testing.T) {
+	waitForDisconnects(t)
+	oldSettings := settings
+	defer func() {
+		waitForDisconnects(t)
+		settings = oldSettings
+		clientConnectCallback = nil
+	}()
+	settings = &ServerSettings{HostName: "localhost", MaxConnections: 1}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	connected := ClientsConnected()
+
+	// A client the ClientConnect callback rejects doesn't stay counted
+	clientConnectCallback = func(*http.ResponseWriter, *http.Request) (bool, map[string]interface{}) {
+		return false, nil
+	}
+	if _, response, err := websocket.DefaultDialer.Dial(url, nil); err == nil || response == nil || response.StatusCode != http.StatusForbidden {
+		t.Fatal("Expected the callback to reject the client, got", err)
+	} else if count := ClientsConnected(); count != connected {
+		t.Error("Expected", connected, "clients connected after the rejection, got", count)
+	}
+	clientConnectCallback = nil
+
+	// Neither does a client turned away by a full server
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, response, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusServiceUnavailable {
+		t.Fatal("Expected the full server to turn the client away, got", err)
+	}
+	var body map[string]map[string]interface{}
+	if err := json.NewDecoder(response.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	} else if body["e"]["c"] != float64(helpers.ErrorServerFull) || body["e"]["m"] != errorServerFull {
+		t.Error("Expected an ErrorServerFull error, got", body)
+	}
+	if count := ClientsConnected(); count != connected+1 {
+		t.Error("Expected", connected+1, "clients connected with the server full, got", count)
+	}
+	client.Close()
+	waitForDisconnects(t)
+	if count := ClientsConnected(); count != connected {
+		t.Error("Expected", connected, "clients connected after the client left, got", count)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	waitForDisconnects(t)
+	oldSettings := settings
+	defer func() {
+		waitForDisconnects(t)
+		settings = oldSettings
+		clientDisconnectCallback = nil
+	}()
+	disconnects := make(chan error, 2)
+	clientDisconnectCallback = func(userName string, wasLoggedIn bool, err error) {
+		if userName == "keepAliveIdle" && wasLoggedIn {
+			disconnects <- err
+		}
+	}
+	settings = &ServerSettings{HostName: "localhost", PingInterval: time.Millisecond * 50, PongTimeout: time.Millisecond * 250}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	// login connects a client and logs it in. Pings are only answered while the client is reading.
+	login := func(name string) *websocket.Conn {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionLogin, "P": map[string]interface{}{"n": name}})
+		var message map[string]interface{}
+		if err := client.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	// A client that stops answering pings is disconnected and logged out
+	idle := login("keepAliveIdle")
+	defer idle.Close()
+	active := login("keepAliveActive")
+	defer active.Close()
+	messages := make(chan map[string]map[string]interface{}, 10)
+	go func() {
+		for {
+			var message map[string]map[string]interface{}
+			if err := active.ReadJSON(&message); err != nil {
+				return
+			}
+			messages <- message
+		}
+	}()
+	select {
+	case err := <-disconnects:
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() {
+			t.Error("Expected the idle client to time out, got", err)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatal("Expected the idle client to be disconnected")
+	}
+	deadline := time.Now().Add(time.Second * 2)
+	for _, err := core.GetUser("keepAliveIdle"); err == nil; _, err = core.GetUser("keepAliveIdle") {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the idle client to be logged out")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	// A client that answers pings stays, and LastSeen only moves when it sends something
+	user, err := core.GetUser("keepAliveActive")
+	if err != nil {
+		t.Fatal("Expected the client answering pings to stay logged in, got", err)
+	}
+	lastSeen := user.LastSeen()
+	time.Sleep(time.Millisecond * 150)
+	if !user.LastSeen().Equal(lastSeen) {
+		t.Error("Expected pongs not to count as being seen")
+	}
+	active.WriteJSON(map[string]interface{}{"A": helpers.ClientActionChangeStatus, "P": 1})
+	select {
+	case <-messages:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Expected a response to the status change")
+	}
+	if !user.LastSeen().After(lastSeen) {
+		t.Error("Expected LastSeen to move when the client sent a message")
+	}
+}
+
+func TestSocketHandler(t *testing.T) {
+	waitForDisconnects(t)
+	oldSettings := settings
+	defer func() {
+		waitForDisconnects(t)
+		settings = oldSettings
+		stoppingMux.Lock()
+		serverRunning = false
+		stoppingMux.Unlock()
+	}()
+	settings = &ServerSettings{HostName: "localhost", Handler: true}
+	upgrader = makeUpgrader()
+	mux := http.NewServeMux()
+	mux.Handle("/game/ws", SocketHandler())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/game/ws"
+
+	// The handler declines connections until the server is running
+	if _, response, err := websocket.DefaultDialer.Dial(url, nil); err == nil || response == nil ||
+		response.StatusCode != http.StatusServiceUnavailable {
+
+		t.Error("Expected the handler to decline connections before the server runs, got", err)
+	}
+	stoppingMux.Lock()
+	serverRunning = true
+	stoppingMux.Unlock()
+
+	// Clients log in through it like through the server's own listener
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionLogin, "P": map[string]interface{}{"n": "handlerUser"}})
+	client.SetReadDeadline(time.Now().Add(time.Second * 2))
+	var message map[string]map[string]interface{}
+	if err := client.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	} else if response := message[helpers.ServerActionClientActionResponse]; response["a"] != helpers.ClientActionLogin ||
+		response["e"] != nil || response["r"] == nil {
+
+		t.Error("Expected a login response, got", message)
+	}
+	if _, err := core.GetUser("handlerUser"); err != nil {
+		t.Error("Expected the client to be logged in, got", err)
+	}
+}
+
+func TestClientVersion(t *testing.T) {
+	oldSettings := settings
+	defer func() {
+		settings = oldSettings
+		clientVersionCallback = nil
+	}()
+	settings = &ServerSettings{HostName: "localhost", MinClientVersion: "1.1", ClientUpdateURL: "https://example.com/update"}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+
+	for _, test := range []struct{ a, b string }{{"1.10", "1.9"}, {"2", "1.99"}, {"1.0.1", "1"}} {
+		if compared, ok := helpers.CompareVersions(test.a, test.b); !ok || compared != 1 {
+			t.Errorf("Expected %q to be newer than %q", test.a, test.b)
+		}
+	}
+	if _, ok := helpers.CompareVersions("1.x", "1.0"); ok {
+		t.Error("Expected \"1.x\" not to be a version")
+	}
+
+	// connect connects with the query and subprotocols, and logs in as the user when it's not ""
+	connect := func(query string, userName string, subprotocols ...string) *websocket.Conn {
+		dialer := websocket.Dialer{Subprotocols: subprotocols}
+		client, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userName != "" {
+			client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionLogin, "P": map[string]interface{}{"n": userName}})
+		}
+		return client
+	}
+	// outdated gets the ServerActionClientOutdated message from the client's first message, if it's one, and closes the client
+	outdated := func(client *websocket.Conn) map[string]interface{} {
+		defer client.Close()
+		client.SetReadDeadline(time.Now().Add(time.Second * 2))
+		var message map[string]interface{}
+		if err := client.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		}
+		outdated, _ := message[helpers.ServerActionClientOutdated].(map[string]interface{})
+		if outdated != nil {
+			if _, _, err := client.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Error("Expected an outdated client to be closed, got", err)
+			}
+		}
+		return outdated
+	}
+
+	// Clients that don't send a version are on the legacy protocol
+	if o := outdated(connect("", "")); o == nil || o["v"] != helpers.LegacyProtocolVersion || o["m"] != "1.1" ||
+		o["u"] != "https://example.com/update" {
+		t.Error("Expected the legacy client to be told to update, got", o)
+	}
+	if outdated(connect("?version=1.0.9", "")) == nil {
+		t.Error("Expected the older client to be told to update")
+	}
+
+	// The subprotocol the version came in is accepted, and the User gets the version
+	client := connect("", "versionUser", "chat", helpers.ProtocolPrefix+"1.1")
+	if client.Subprotocol() != helpers.ProtocolPrefix+"1.1" {
+		t.Error("Expected the version's subprotocol to be accepted, got", client.Subprotocol())
+	}
+	if o := outdated(client); o != nil {
+		t.Error("Expected the client to be allowed, got", o)
+	} else if user, err := core.GetUser("versionUser"); err != nil {
+		t.Error(err)
+	} else if user.ClientVersion() != "1.1" {
+		t.Error("Expected the User's client version to be 1.1, got", user.ClientVersion())
+	}
+	if o := outdated(connect("?version=1.2", "versionUser2")); o != nil {
+		t.Error("Expected a newer client to be allowed, got", o)
+	}
+
+	// The client version callback replaces MinClientVersion
+	clientVersionCallback = func(version string) bool {
+		return version == helpers.LegacyProtocolVersion
+	}
+	if o := outdated(connect("", "versionUser3")); o != nil {
+		t.Error("Expected the callback to allow the legacy client, got", o)
+	}
+	if outdated(connect("?version=1.1", "")) == nil {
+		t.Error("Expected the callback to turn away the client")
+	}
+}
+
+// fakeReader feeds messages to readClientAction like a client connection would
+type fakeReader struct {
+	messages []string
+}
+
+func (f *fakeReader) ReadMessage() (int, []byte, error) {
+	if len(f.messages) == 0 {
+		return 0, nil, io.EOF
+	}
+	message := f.messages[0]
+	f.messages = f.messages[1:]
+	return websocket.TextMessage, []byte(message), nil
+}
+
+func TestReadClientAction(t *testing.T) {
+	tests := []struct {
+		message   string
+		action    string
+		malformed bool
+		field     string
+	}{
+		{`{"A":"c","P":"hello"}`, "c", false, ""},
+		{`{"A":"c","P":"hel`, "", true, ""}, // truncated
+		{`garbage`, "", true, ""},
+		{`{"A":5,"P":"hello"}`, "", true, "A"},
+		{`{"P":"hello"}`, "", true, "A"},
+		{`["c","hello"]`, "", true, ""},
+	}
+	reader := &fakeReader{}
+	for _, test := range tests {
+		reader.messages = append(reader.messages, test.message)
+	}
+	for _, test := range tests {
+		action, _, err := readClientAction(reader, helpers.FormatJSON)
+		malformed, ok := err.(*malformedAction)
+		if ok != test.malformed {
+			t.Errorf("readClientAction(%q) error = %v, want malformed = %v", test.message, err, test.malformed)
+		} else if ok && malformed.field != test.field {
+			t.Errorf("readClientAction(%q) failed field = %q, want %q", test.message, malformed.field, test.field)
+		} else if action.A != test.action {
+			t.Errorf("readClientAction(%q) action = %q, want %q", test.message, action.A, test.action)
+		}
+	}
+	if _, _, err := readClientAction(reader, helpers.FormatJSON); err != io.EOF {
+		t.Error("Connection errors should be returned as they are, got", err)
+	}
+}
+
+func TestMessageLimits(t *testing.T) {
+	oldSettings := settings
+	defer func() {
+		settings = oldSettings
+		clientDisconnectCallback = nil
+	}()
+	disconnects := make(chan error, 1)
+	clientDisconnectCallback = func(userName string, wasLoggedIn bool, err error) {
+		disconnects <- err
+	}
+	settings = &ServerSettings{HostName: "localhost", MaxMessageSize: 64, MaxMalformedMessages: 1}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	dial := func() *websocket.Conn {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	waitDisconnect := func(want error) {
+		select {
+		case err := <-disconnects:
+			if err != want {
+				t.Error("Expected the client to be disconnected with", want, "got", err)
+			}
+		case <-time.After(time.Second * 2):
+			t.Error("The client was not disconnected")
+		}
+	}
+
+	// Malformed messages get an error naming the field, until there are too many
+	client := dial()
+	client.WriteMessage(websocket.TextMessage, []byte(`{"A":["c"]}`))
+	var message map[string]map[string]interface{}
+	if err := client.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := message[helpers.ServerActionClientActionResponse]["e"].(map[string]interface{}); e["id"] != float64(helpers.ErrorMalformedRequest) || e["f"] != "A" {
+		t.Error("Expected a malformed request error for the field A, got", message)
+	}
+	client.WriteMessage(websocket.TextMessage, []byte(`{"A":`))
+	waitDisconnect(errMalformed)
+	client.Close()
+
+	// Messages over MaxMessageSize close the connection
+	client = dial()
+	client.WriteMessage(websocket.TextMessage, []byte(`{"A":"c","P":"`+strings.Repeat("a", 128)+`"}`))
+	_, _, err := client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Error("Expected the connection to close with CloseMessageTooBig, got", err)
+	}
+	waitDisconnect(websocket.ErrReadLimit)
+	client.Close()
+}
+
+func TestVoiceFrames(t *testing.T) {
+	oldSettings := settings
+	defer func() {
+		settings = oldSettings
+		clientDisconnectCallback = nil
+	}()
+	disconnected := make(chan bool, 1)
+	clientDisconnectCallback = func(string, bool, error) {
+		disconnected <- true
+	}
+	settings = &ServerSettings{HostName: "localhost", VoiceByteRate: 10}
+	upgrader = makeUpgrader()
+	core.NewRoomType("voiceTest", false).EnableVoiceChat()
+	room, roomErr := core.NewRoom("voiceFrames", "voiceTest", false, 0, "voiceListener")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+
+	// The listener is logged in on the server's side, so only the speaker's listener writes to the sockets after this
+	sockets := make(chan *websocket.Conn, 1)
+	listenerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, _ := websocket.Upgrade(w, r, w.Header(), 1024, 1024)
+		sockets <- socket
+	}))
+	defer listenerServer.Close()
+	listener, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(listenerServer.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var listenerUser *core.User
+	var listenerMux sync.Mutex
+	if _, gErr := core.Login("voiceListener", -1, "", true, false, <-sockets, &listenerUser, &listenerMux); gErr.ID != 0 {
+		t.Fatal(gErr.Message)
+	}
+	defer listenerUser.Kick()
+	listenerUser.Join(room, "")
+
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	speaker, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		speaker.Close()
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second * 2):
+			t.Error("Expected the speaker to disconnect")
+		}
+	}()
+	// send sends a client action, and reads messages until its response
+	send := func(action string, params interface{}) map[string]interface{} {
+		speaker.WriteJSON(map[string]interface{}{"A": action, "P": params})
+		for {
+			var message map[string]map[string]interface{}
+			if err := speaker.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			} else if response, ok := message[helpers.ServerActionClientActionResponse]; ok && response["a"] == action {
+				return response
+			}
+		}
+	}
+	send(helpers.ClientActionLogin, map[string]interface{}{"n": "voiceSpeaker"})
+	if response := send(helpers.ClientActionJoinRoom, "voiceFrames"); response["e"] != nil {
+		t.Fatal("Expected the speaker to join the Room, got", response)
+	}
+
+	// The second frame goes over the VoiceByteRate, and text actions still work in between. Only the owner can mute.
+	speaker.WriteMessage(websocket.BinaryMessage, []byte("12345678"))
+	speaker.WriteMessage(websocket.BinaryMessage, []byte("abcdefgh"))
+	response := send(helpers.ClientActionMuteUser, "voiceListener")
+	if e, _ := response["e"].(map[string]interface{}); e["id"] != float64(helpers.ErrorGopherNotOwner) {
+		t.Error("Expected a not owner error, got", response)
+	}
+	var frames []string
+	listener.SetReadDeadline(time.Now().Add(time.Millisecond * 200))
+	for {
+		messageType, message, err := listener.ReadMessage()
+		if err != nil {
+			break
+		} else if messageType == websocket.BinaryMessage {
+			frames = append(frames, string(message))
+		}
+	}
+	if len(frames) != 1 || frames[0] != "\x0cvoiceSpeaker12345678" {
+		t.Errorf("Expected only the first frame, with the speaker's name, got %q", frames)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	oldSettings := settings
+	defer func() {
+		settings = oldSettings
+		clientDisconnectCallback = nil
+	}()
+	disconnected := make(chan bool, 1)
+	clientDisconnectCallback = func(string, bool, error) {
+		disconnected <- true
+	}
+	settings = &ServerSettings{HostName: "localhost"}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		client.Close()
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second * 2):
+			t.Error("Expected the client to disconnect")
+		}
+	}()
+	// sendError sends a client action, and reads messages until its error
+	sendError := func(action string, params interface{}) map[string]interface{} {
+		client.WriteJSON(map[string]interface{}{"A": action, "P": params})
+		for {
+			var message map[string]map[string]interface{}
+			if err := client.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			} else if response, ok := message[helpers.ServerActionClientActionResponse]; ok && response["a"] == action {
+				e, _ := response["e"].(map[string]interface{})
+				return e
+			}
+		}
+	}
+
+	// Errors from the core package keep their codes. The "id" key is still sent for older clients.
+	client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionLogin, "P": map[string]interface{}{"n": "errorCodes"}})
+	if e := sendError(helpers.ClientActionJoinRoom, "notARoom"); e["c"] != float64(helpers.ErrorRoomNotFound) || e["id"] != e["c"] {
+		t.Error("Expected a room not found error code, got", e)
+	} else if !strings.Contains(e["m"].(string), "notARoom") {
+		t.Error("Expected the server's message, got", e["m"])
+	}
+
+	// Messages can be replaced for each code
+	helpers.SetErrorMessage(helpers.ErrorRoomNotFound, "La sala no existe")
+	defer helpers.SetErrorMessage(helpers.ErrorRoomNotFound, "")
+	if e := sendError(helpers.ClientActionJoinRoom, "notARoom"); e["m"] != "La sala no existe" || e["c"] != float64(helpers.ErrorRoomNotFound) {
+		t.Error("Expected the replaced message, got", e)
+	}
+
+	// Errors without a code get the action's
+	if gErr := helpers.ErrorFrom(core.ErrRoomFull, helpers.ErrorGopherJoin); gErr.Code() != helpers.ErrorRoomFull {
+		t.Error("Expected core.ErrRoomFull to keep its code, got", gErr.Code())
+	} else if gErr = helpers.ErrorFrom(io.EOF, helpers.ErrorGopherJoin); gErr.Code() != helpers.ErrorGopherJoin || gErr.Error() != io.EOF.Error() {
+		t.Error("Expected an error without a code to get the fallback code, got", gErr)
+	}
+}
+
+func TestLocalization(t *testing.T) {
+	oldSettings := settings
+	defer func() {
+		waitForDisconnects(t)
+		settings = oldSettings
+	}()
+	settings = &ServerSettings{HostName: "localhost"}
+	upgrader = makeUpgrader()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+
+	const msgRestart = 1
+	SetTranslations("en", map[int]string{msgRestart: "Restarting in {0} minutes"})
+	SetTranslations("es", map[int]string{msgRestart: "Reinicio en {0} minutos", helpers.ErrorRoomNotFound: "La sala no existe"})
+	SetTranslations("pt", map[int]string{msgRestart: "Reiniciando em {0} minutos"})
+	defer func() {
+		for _, locale := range []string{"en", "es", "pt"} {
+			SetTranslations(locale, nil)
+		}
+	}()
+
+	// Clients send their locale in the URL, or their Accept-Language is used
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	spanish, _, err := websocket.DefaultDialer.Dial(url+"?locale=es", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spanish.Close()
+	portuguese, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Accept-Language": {"pt-BR,pt;q=0.9,en;q=0.8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer portuguese.Close()
+	// read reads messages until one for the server action, skipping the responses to other client actions
+	read := func(client *websocket.Conn, serverAction string) map[string]interface{} {
+		client.SetReadDeadline(time.Now().Add(time.Second * 2))
+		for {
+			var message map[string]map[string]interface{}
+			if err := client.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			} else if data, ok := message[serverAction]; ok && (data["a"] == nil || data["a"] == helpers.ClientActionJoinRoom) {
+				return data
+			}
+		}
+	}
+
+	// Error messages are translated to the client's locale, and fall back to the server's own
+	for name, client := range map[string]*websocket.Conn{"localeSpanish": spanish, "localePortuguese": portuguese} {
+		client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionLogin, "P": map[string]interface{}{"n": name}})
+		client.WriteJSON(map[string]interface{}{"A": helpers.ClientActionJoinRoom, "P": "notARoom"})
+	}
+	if e, _ := read(spanish, helpers.ServerActionClientActionResponse)["e"].(map[string]interface{}); e["m"] != "La sala no existe" {
+		t.Error("Expected the error in Spanish, got", e)
+	}
+	if e, _ := read(portuguese, helpers.ServerActionClientActionResponse)["e"].(map[string]interface{}); e["m"] == nil ||
+		!strings.Contains(e["m"].(string), "notARoom") {
+		t.Error("Expected the server's own error message, got", e)
+	}
+
+	// Announcements are sent in each client's locale, with their parameters filled in
+	if err := BroadcastLocalized("restart", msgRestart, 5); err != nil {
+		t.Fatal(err)
+	}
+	if d := read(spanish, helpers.ServerActionAnnouncement)["d"]; d != "Reinicio en 5 minutos" {
+		t.Error("Expected the announcement in Spanish, got", d)
+	}
+	if d := read(portuguese, helpers.ServerActionAnnouncement)["d"]; d != "Reiniciando em 5 minutos" {
+		t.Error("Expected the announcement in Portuguese, got", d)
+	}
+	if err := BroadcastLocalized("restart", 2); err == nil {
+		t.Error("Expected an error broadcasting a key without an English template")
+	}
+}
+
+func TestMessagePack(t *testing.T) {
+	waitForDisconnects(t)
+	oldSettings := settings
+	defer func() {
+		settings = oldSettings
+		clientDisconnectCallback = nil
+	}()
+	disconnected := make(chan bool, 1)
+	clientDisconnectCallback = func(string, bool, error) {
+		disconnected <- true
+	}
+	settings = &ServerSettings{HostName: "localhost", UserRoomControl: true, EnableMessagePack: true}
+	upgrader = makeUpgrader()
+	core.NewRoomType("wireTest", false).EnableVoiceChat()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+
+	// run runs the same built-in actions on a client that uses the format, and gets their responses as they decode to JSON
+	run := func(query string, messagePack bool) []string {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			client.Close()
+			select {
+			case <-disconnected:
+			case <-time.After(time.Second * 2):
+				t.Error("Expected the client to disconnect")
+			}
+		}()
+		// read reads the next message, and checks it's in the client's format
+		read := func() (int, interface{}) {
+			client.SetReadDeadline(time.Now().Add(time.Second * 2))
+			messageType, data, err := client.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var message interface{}
+			if messagePack {
+				if messageType != websocket.BinaryMessage {
+					t.Fatalf("Expected a MessagePack client to only get binary messages, got %q", data)
+				} else if message, err = helpers.UnmarshalMessagePack(data); err != nil {
+					t.Fatal(err)
+				}
+			} else if messageType == websocket.BinaryMessage {
+				message = data
+			} else if err = json.Unmarshal(data, &message); err != nil {
+				t.Fatal(err)
+			}
+			return messageType, message
+		}
+		// sendFor sends a client action, and reads messages until the server action it causes
+		sendFor := func(action string, params interface{}, serverAction string) string {
+			message := map[string]interface{}{"A": action, "P": params}
+			if messagePack {
+				data, err := helpers.MarshalMessagePack(message)
+				if err != nil {
+					t.Fatal(err)
+				}
+				client.WriteMessage(websocket.BinaryMessage, data)
+			} else {
+				client.WriteJSON(message)
+			}
+			for {
+				_, message := read()
+				if m, ok := message.(map[string]interface{}); ok {
+					if response, ok := m[serverAction].(map[string]interface{}); ok && (serverAction != helpers.ServerActionClientActionResponse || response["a"] == action) {
+						data, _ := json.Marshal(response)
+						return string(data)
+					}
+				}
+			}
+		}
+		// send sends a client action, and reads messages until its response
+		send := func(action string, params interface{}) string {
+			return sendFor(action, params, helpers.ServerActionClientActionResponse)
+		}
+
+		responses := []string{
+			send(helpers.ClientActionLogin, map[string]interface{}{"n": "wireUser"}),
+			send(helpers.ClientActionCreateRoom, map[string]interface{}{"n": "wireRoom", "t": "wireTest", "p": false, "m": 4}),
+			send(helpers.ClientActionSetVariable, map[string]interface{}{"k": "score", "v": 12.5}),
+			send(helpers.ClientActionSetVariables, map[string]interface{}{"lives": 3, "tags": []interface{}{"a", "b"}, "big": 1 << 40}),
+			send(helpers.ClientActionGetVariables, nil),
+			send(helpers.ClientActionGetVariables, []interface{}{"lives", "tags"}),
+			sendFor(helpers.ClientActionChatMessage, "hello", helpers.ServerActionRoomMessage),
+			send(helpers.ClientActionChangeStatus, 1),
+			send(helpers.ClientActionJoinRoom, "notARoom"),
+			send(helpers.ClientActionCreateRoom, map[string]interface{}{"n": "wireRoom", "t": "notAType", "p": false, "m": 4}),
+		}
+
+		// MessagePack clients can't send text messages
+		if messagePack {
+			client.WriteMessage(websocket.TextMessage, []byte(`{"A":"c","P":"hello"}`))
+			_, message := read()
+			response, _ := message.(map[string]interface{})[helpers.ServerActionClientActionResponse].(map[string]interface{})
+			if e, _ := response["e"].(map[string]interface{}); e["m"] != errorMalformed+", invalid MessagePack" {
+				t.Error("Expected a malformed MessagePack error, got", message)
+			}
+		}
+
+		responses = append(responses,
+			send(helpers.ClientActionLeaveRoom, nil),
+			send(helpers.ClientActionLogout, nil),
+		)
+		return responses
+	}
+
+	jsonResponses := run("", false)
+	messagePackResponses := run("?format=msgpack", true)
+	if len(jsonResponses) != len(messagePackResponses) {
+		t.Fatal("Expected the same number of responses, got", len(jsonResponses), len(messagePackResponses))
+	}
+	for i := range jsonResponses {
+		if jsonResponses[i] != messagePackResponses[i] {
+			t.Errorf("Response %v differs between JSON and MessagePack:\n%v\n%v", i, jsonResponses[i], messagePackResponses[i])
+		}
+	}
+
+	// Without EnableMessagePack, clients asking for it still get JSON
+	settings.EnableMessagePack = false
+	if responses := run("?format=msgpack", false); responses[0] != jsonResponses[0] {
+		t.Error("Expected JSON responses without EnableMessagePack, got", responses[0])
+	}
+
+	// Voice frames are sent to MessagePack clients as bins. The listeners are logged in on the server's side, so only this
+	// goroutine writes to their sockets.
+	room, roomErr := core.NewRoom("wireVoice", "wireTest", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer room.Delete()
+	sockets := make(chan *websocket.Conn, 1)
+	listenerServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, _ := websocket.Upgrade(w, r, w.Header(), 1024, 1024)
+		sockets <- socket
+	}))
+	defer listenerServer.Close()
+	var listeners []*websocket.Conn
+	for i, format := range []int{helpers.FormatJSON, helpers.FormatMessagePack} {
+		listener, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(listenerServer.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		socket := <-sockets
+		helpers.SetSocketFormat(socket, format)
+		defer helpers.ForgetSocket(socket)
+		var listenerUser *core.User
+		var listenerMux sync.Mutex
+		if _, gErr := core.Login("wireListener"+strconv.Itoa(i), -1, "", true, false, socket, &listenerUser, &listenerMux); gErr.ID != 0 {
+			t.Fatal(gErr.Message)
+		}
+		defer listenerUser.Kick()
+		listenerUser.Join(room, "")
+		listeners = append(listeners, listener)
+	}
+	if err := room.RelayVoice("wireSpeaker", []byte("frame")); err != nil {
+		t.Fatal(err)
+	}
+	for i, listener := range listeners {
+		listener.SetReadDeadline(time.Now().Add(time.Second * 2))
+		for {
+			messageType, data, err := listener.ReadMessage()
+			if err != nil {
+				t.Fatal("Expected the voice frame:", err)
+			} else if messageType != websocket.BinaryMessage {
+				continue
+			}
+			if i == 1 {
+				var frame interface{}
+				if frame, err = helpers.UnmarshalMessagePack(data); err != nil {
+					continue
+				} else if data, _ = frame.([]byte); data == nil {
+					continue
+				}
+			}
+			if string(data) != "\x0bwireSpeakerframe" {
+				t.Errorf("Expected the voice frame, got %q", data)
+			}
+			break
+		}
+	}
+}
+
+func TestOutboundQueue(t *testing.T) {
+	sockets := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, _ := websocket.Upgrade(w, r, w.Header(), 1024, 1024)
+		sockets <- socket
+	}))
+	defer server.Close()
+	dial := func(queueSize int, writeTimeout time.Duration) (*websocket.Conn, *websocket.Conn) {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		socket := <-sockets
+		helpers.StartWriter(socket, queueSize, writeTimeout)
+		return client, socket
+	}
+
+	// Queued messages arrive in order, including the ones queued right before the writer stops
+	client, socket := dial(10, time.Second*5)
+	defer client.Close()
+	for i := 0; i < 5; i++ {
+		if err := helpers.WriteMessage(socket, map[string]int{"i": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	helpers.StopWriter(socket)
+	helpers.ForgetSocket(socket)
+	client.SetReadDeadline(time.Now().Add(time.Second * 2))
+	for i := 0; i < 5; i++ {
+		var message map[string]int
+		if err := client.ReadJSON(&message); err != nil {
+			t.Fatal(err)
+		} else if message["i"] != i {
+			t.Error("Expected message", i, "got", message["i"])
+		}
+	}
+	socket.Close()
+
+	// A client that doesn't read falls behind until it's dropped
+	_, droppedBefore := helpers.OutboundStats()
+	slowClient, slowSocket := dial(2, time.Second*5)
+	defer slowClient.Close()
+	defer helpers.ForgetSocket(slowSocket)
+	big := helpers.NewEncoded(strings.Repeat("x", 1<<20))
+	var writeErr error
+	for i := 0; i < 100 && writeErr == nil; i++ {
+		writeErr = big.Write(slowSocket)
+	}
+	if writeErr != helpers.ErrSlowClient {
+		t.Fatal("Expected the slow client to be dropped, got", writeErr)
+	}
+	if _, dropped := helpers.OutboundStats(); dropped != droppedBefore+1 {
+		t.Error("Expected the drop to be counted, got", dropped-droppedBefore)
+	}
+	if err := helpers.WriterError(slowSocket); err != helpers.ErrSlowClient {
+		t.Error("Expected the writer to have dropped the slow client, got", err)
+	}
+
+	// A client that stalls a write past the write timeout is dropped, even with room left in its queue
+	timeoutsBefore := helpers.WriteTimeouts()
+	stalledClient, stalledSocket := dial(100, time.Millisecond*50)
+	defer stalledClient.Close()
+	defer helpers.ForgetSocket(stalledSocket)
+	for i := 0; i < 20; i++ {
+		big.Write(stalledSocket)
+	}
+	deadline := time.Now().Add(time.Second * 5)
+	for helpers.WriterError(stalledSocket) == nil && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err := helpers.WriterError(stalledSocket); err != helpers.ErrWriteTimeout {
+		t.Fatal("Expected the stalled client to time out, got", err)
+	}
+	if timeouts := helpers.WriteTimeouts(); timeouts != timeoutsBefore+1 {
+		t.Error("Expected the timeout to be counted, got", timeouts-timeoutsBefore)
+	}
+	if helpers.WritePing(stalledSocket) == nil {
+		t.Error("Pinging a dropped client should fail")
+	}
+}
@@ -1,14 +1,158 @@
 package gopher
 
 import (
+	"github.com/gorilla/websocket"
+	"github.com/hewiefreeman/GopherGameServer/actions"
+	"github.com/hewiefreeman/GopherGameServer/core"
+	"github.com/hewiefreeman/GopherGameServer/database"
+	"github.com/hewiefreeman/GopherGameServer/helpers"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 )
 
+// waitForDisconnects waits for the listeners of closed clients to finish disconnecting them, so a test can change the
+// settings and callbacks they use.
+func waitForDisconnects(t *testing.T) {
+	deadline := time.Now().Add(time.Second * 2)
+	for ClientsConnected() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for", ClientsConnected(), "clients to disconnect")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestPause(t *testing.T) {
+	waitForDisconnects(t)
+	oldSettings := settings
+	stoppingMux.Lock()
+	serverStarted = true
+	stoppingMux.Unlock()
+	defer func() {
+		Resume()
+		waitForDisconnects(t)
+		settings = oldSettings
+		stoppingMux.Lock()
+		serverStarted = false
+		stoppingMux.Unlock()
+	}()
+	settings = &ServerSettings{HostName: "localhost"}
+	upgrader = makeUpgrader()
+	core.NewRoomType("pauseTest", false)
+	room, roomErr := core.NewRoom("pauseRoom", "pauseTest", false, 0, "")
+	if roomErr != nil {
+		t.Fatal(roomErr)
+	}
+	defer func() {
+		waitForDisconnects(t)
+		room.Delete()
+	}()
+	server := httptest.NewServer(http.HandlerFunc(socketInitializer))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	// send sends a client action, and reads messages until its response
+	send := func(client *websocket.Conn, action string, params interface{}) map[string]interface{} {
+		client.SetReadDeadline(time.Now().Add(time.Second * 2))
+		client.WriteJSON(map[string]interface{}{"A": action, "P": params})
+		for {
+			var message map[string]map[string]interface{}
+			if err := client.ReadJSON(&message); err != nil {
+				t.Fatal(err)
+			} else if response, ok := message[helpers.ServerActionClientActionResponse]; ok && response["a"] == action {
+				return response
+			}
+		}
+	}
+	// paused returns true if the response is an ErrorServerPaused
+	paused := func(response map[string]interface{}) bool {
+		e, _ := response["e"].(map[string]interface{})
+		return e != nil && e["c"] == float64(helpers.ErrorServerPaused)
+	}
+
+	// New connections, logins and room joins are declined while paused. Connected clients stay connected.
+	Pause()
+	if !isPaused() {
+		t.Fatal("Expected the server to be paused")
+	}
+	if _, response, err := websocket.DefaultDialer.Dial(url, nil); err == nil || response == nil ||
+		response.StatusCode != http.StatusServiceUnavailable {
+
+		t.Error("Expected new connections to be declined while paused, got", err)
+	}
+	if response := send(client, helpers.ClientActionLogin, map[string]interface{}{"n": "pauseUser"}); !paused(response) {
+		t.Error("Expected logins to be declined while paused, got", response)
+	} else if response = send(client, helpers.ClientActionJoinRoom, "pauseRoom"); !paused(response) {
+		t.Error("Expected room joins to be declined while paused, got", response)
+	}
+
+	// They all work again after Resume()
+	Resume()
+	if isPaused() {
+		t.Fatal("Expected the server to be resumed")
+	}
+	other, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal("Expected new connections after resuming, got", err)
+	}
+	defer other.Close()
+	if response := send(client, helpers.ClientActionLogin, map[string]interface{}{"n": "pauseUser"}); response["e"] != nil {
+		t.Error("Expected the login to work after resuming, got", response)
+	} else if response = send(client, helpers.ClientActionJoinRoom, "pauseRoom"); response["e"] != nil {
+		t.Error("Expected the room join to work after resuming, got", response)
+	}
+}
+
 func TestStartAndStop(t *testing.T) {
+	oldSettings := settings
+	t.Cleanup(func() {
+		// PUT THE SERVER BACK THE WAY IT WAS BEFORE STARTING, SO THE TESTS CAN RUN AGAIN
+		stoppingMux.Lock()
+		serverStarted, serverPaused, serverStopping, serverRunning = false, false, false, false
+		serverEndChan = make(chan error)
+		serverDoneChan = make(chan bool)
+		httpServer = nil
+		stoppingMux.Unlock()
+		settings = oldSettings
+		core.SetServerStarted(false)
+		core.Resume()
+		core.SettingsSet(false, "", true, false, false, false, 0, 0)
+		core.SetReconnect(0, 0)
+		core.SetSavedGames("", defaultMaxSavedGameSize)
+		core.SetEventLog("", 0)
+		core.LoadBans(false, "")
+		actions.SetServerStarted(false)
+		actions.Resume()
+		database.Resume()
+		database.SetServerStarted(false)
+	})
 	go Start(nil)
 	time.Sleep(time.Second * 2)
+	if !IsRunning() || IsPaused() || Uptime() <= 0 || Version() == "" {
+		t.Error("Expected the server to be running, got", IsRunning(), IsPaused(), Uptime(), Version())
+	}
+	s := Settings()
+	if s.SqlPassword != redacted || s.AdminPassword != redacted {
+		t.Error("Expected the passwords to be redacted, got", s.SqlPassword, s.AdminPassword)
+	}
+	s.ServerName = "changed"
+	if settings.ServerName == "changed" {
+		t.Error("Changing the copy from Settings() should not change the server's settings")
+	}
 	if sdErr := ShutDown(); sdErr != nil {
-		t.Errorf(sdErr.Error())
+		t.Error(sdErr)
+	}
+	if sdErr := ShutDown(); sdErr == nil {
+		t.Error("Calling ShutDown() twice should return an error")
+	}
+	if IsRunning() || Uptime() != 0 {
+		t.Error("Expected the server to be shut down")
 	}
 }